@@ -0,0 +1,170 @@
+package badger
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrInvalidBackup = errors.New("invalid badger backup")
+
+// 一个 KVList 消息的最大长度，Badger 默认每批最多 4 MB，超过这个长度的一定是损坏的文件，不按照它分配内存
+const maxListSize = 1 << 30
+
+// Badger 在 pb.KV.Meta 中使用的标志位，备份同时包含删除标记
+const bitDelete = 1 << 0
+
+// KV 是备份中的一条记录，对应 Badger 的 pb.KV
+type KV struct {
+	Key       []byte
+	Value     []byte
+	UserMeta  []byte
+	Version   uint64
+	ExpiresAt uint64 // 过期时间的 Unix 秒数，0 表示不会过期
+	Meta      []byte
+}
+
+// Deleted 返回这条记录是否是删除标记，删除标记之后的旧版本都已经失效
+func (kv *KV) Deleted() bool {
+	return len(kv.Meta) > 0 && kv.Meta[0]&bitDelete != 0
+}
+
+// Reader 读取 badger backup 或者 DB.Backup 生成的备份文件
+// 备份由多个 | SIZE 8 | KVLIST ? | 组成，SIZE 是小端的消息长度，KVLIST 是 Protobuf 编码的 pb.KVList
+// Badger 的 SST 和 value log 格式随版本变化并且可能加密，直接读取备份文件不依赖 Badger 的版本
+type Reader struct {
+	r    *bufio.Reader
+	list []*KV
+	pos  int
+}
+
+// NewReader 创建备份文件的 Reader
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReaderSize(r, 1<<20)}
+}
+
+// Next 返回下一条记录，读完之后返回 io.EOF，同一个 Key 的多个版本按照版本号从大到小排列
+func (rd *Reader) Next() (*KV, error) {
+	for rd.pos >= len(rd.list) {
+		var b [8]byte
+		if _, err := io.ReadFull(rd.r, b[:]); err != nil {
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("failed to read badger backup: %w", io.ErrUnexpectedEOF)
+		}
+		size := binary.LittleEndian.Uint64(b[:])
+		if size > maxListSize {
+			return nil, ErrInvalidBackup
+		}
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(rd.r, buf); err != nil {
+			return nil, fmt.Errorf("failed to read badger backup: %w", io.ErrUnexpectedEOF)
+		}
+		list, err := parseKVList(buf)
+		if err != nil {
+			return nil, err
+		}
+		rd.list, rd.pos = list, 0
+	}
+	kv := rd.list[rd.pos]
+	rd.list[rd.pos] = nil
+	rd.pos++
+	return kv, nil
+}
+
+// parseKVList 解析 pb.KVList，字段 1 是 repeated KV，其他字段跳过
+func parseKVList(b []byte) ([]*KV, error) {
+	var list []*KV
+	err := parseMessage(b, func(field int, wire int, value []byte, _ uint64) error {
+		if field != 1 || wire != wireBytes {
+			return nil
+		}
+		kv, err := parseKV(value)
+		if err != nil {
+			return err
+		}
+		list = append(list, kv)
+		return nil
+	})
+	return list, err
+}
+
+// parseKV 解析 pb.KV，key = 1、value = 2、user_meta = 3、version = 4、expires_at = 5、meta = 6
+func parseKV(b []byte) (*KV, error) {
+	kv := new(KV)
+	err := parseMessage(b, func(field int, wire int, value []byte, n uint64) error {
+		switch {
+		case field == 1 && wire == wireBytes:
+			kv.Key = value
+		case field == 2 && wire == wireBytes:
+			kv.Value = value
+		case field == 3 && wire == wireBytes:
+			kv.UserMeta = value
+		case field == 4 && wire == wireVarint:
+			kv.Version = n
+		case field == 5 && wire == wireVarint:
+			kv.ExpiresAt = n
+		case field == 6 && wire == wireBytes:
+			kv.Meta = value
+		}
+		return nil
+	})
+	return kv, err
+}
+
+// Protobuf 的 wire type
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// parseMessage 逐个解析 Protobuf 消息中的字段，长度前缀的字段通过 value 返回，整数字段通过 n 返回
+func parseMessage(b []byte, fn func(field int, wire int, value []byte, n uint64) error) error {
+	for len(b) > 0 {
+		tag, size := binary.Uvarint(b)
+		if size <= 0 {
+			return ErrInvalidBackup
+		}
+		b = b[size:]
+		field, wire := int(tag>>3), int(tag&7)
+
+		var (
+			value []byte
+			n     uint64
+		)
+		switch wire {
+		case wireVarint:
+			n, size = binary.Uvarint(b)
+			if size <= 0 {
+				return ErrInvalidBackup
+			}
+			b = b[size:]
+		case wireFixed64, wireFixed32:
+			width := 8
+			if wire == wireFixed32 {
+				width = 4
+			}
+			if len(b) < width {
+				return ErrInvalidBackup
+			}
+			b = b[width:]
+		case wireBytes:
+			l, size := binary.Uvarint(b)
+			if size <= 0 || l > uint64(len(b)-size) {
+				return ErrInvalidBackup
+			}
+			value, b = b[size:size+int(l)], b[size+int(l):]
+		default:
+			return ErrInvalidBackup
+		}
+		if err := fn(field, wire, value, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package badger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+// message 按照 Protobuf 的格式生成测试用的消息
+type message struct {
+	bytes.Buffer
+}
+
+func (m *message) varint(field int, v uint64) {
+	m.Write(binary.AppendUvarint(nil, uint64(field)<<3|wireVarint))
+	m.Write(binary.AppendUvarint(nil, v))
+}
+
+func (m *message) bytes(field int, b []byte) {
+	m.Write(binary.AppendUvarint(nil, uint64(field)<<3|wireBytes))
+	m.Write(binary.AppendUvarint(nil, uint64(len(b))))
+	m.Write(b)
+}
+
+func (m *message) fixed64(field int, v uint64) {
+	m.Write(binary.AppendUvarint(nil, uint64(field)<<3|wireFixed64))
+	_ = binary.Write(m, binary.LittleEndian, v)
+}
+
+func encodeKV(kv *KV) []byte {
+	var m message
+	m.bytes(1, kv.Key)
+	m.bytes(2, kv.Value)
+	m.bytes(3, kv.UserMeta)
+	m.varint(4, kv.Version)
+	m.varint(5, kv.ExpiresAt)
+	m.bytes(6, kv.Meta)
+	// Badger 之后的版本增加的字段会被跳过
+	m.varint(10, 1)
+	m.fixed64(12, 7)
+	return m.Bytes()
+}
+
+// backup 生成 badger backup 格式的数据，每个参数是一个 KVList
+func backup(lists ...[]*KV) []byte {
+	var out bytes.Buffer
+	for _, list := range lists {
+		var m message
+		for _, kv := range list {
+			m.bytes(1, encodeKV(kv))
+		}
+		m.varint(10, 42)
+		_ = binary.Write(&out, binary.LittleEndian, uint64(m.Len()))
+		out.Write(m.Bytes())
+	}
+	return out.Bytes()
+}
+
+func TestReader(t *testing.T) {
+	want := []*KV{
+		{Key: []byte("a"), Value: []byte("1"), UserMeta: []byte{0}, Version: 9, Meta: []byte{0}},
+		{Key: []byte("a"), Value: []byte("0"), UserMeta: []byte{0}, Version: 3, Meta: []byte{0}},
+		{Key: []byte("b"), Value: []byte("2"), UserMeta: []byte{5}, Version: 4, ExpiresAt: 1893456000, Meta: []byte{0}},
+		{Key: []byte("c"), Value: nil, UserMeta: []byte{0}, Version: 7, Meta: []byte{bitDelete}},
+	}
+	rd := NewReader(bytes.NewReader(backup(want[:2], nil, want[2:])))
+
+	for _, w := range want {
+		kv, err := rd.Next()
+		if err != nil {
+			t.Fatalf("failed to read kv: %v", err)
+		}
+		if !bytes.Equal(kv.Key, w.Key) || !bytes.Equal(kv.Value, w.Value) || !bytes.Equal(kv.UserMeta, w.UserMeta) ||
+			kv.Version != w.Version || kv.ExpiresAt != w.ExpiresAt || kv.Deleted() != w.Deleted() {
+			t.Fatalf("expected %+v, got %+v", w, kv)
+		}
+	}
+	if _, err := rd.Next(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	data := backup([]*KV{{Key: []byte("key"), Value: []byte("value")}})
+
+	rd := NewReader(bytes.NewReader(data[:len(data)-1]))
+	if _, err := rd.Next(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	corrupted := append([]byte(nil), data...)
+	// KVList 中第一个 KV 的长度超过了消息的长度
+	corrupted[9] = 0x7F
+	rd = NewReader(bytes.NewReader(corrupted))
+	if _, err := rd.Next(); err != ErrInvalidBackup {
+		t.Errorf("expected ErrInvalidBackup, got %v", err)
+	}
+
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], maxListSize+1)
+	rd = NewReader(bytes.NewReader(size[:]))
+	if _, err := rd.Next(); err != ErrInvalidBackup {
+		t.Errorf("expected ErrInvalidBackup, got %v", err)
+	}
+}
@@ -0,0 +1,285 @@
+package bolt
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+)
+
+var ErrInvalidFile = errors.New("invalid bolt database file")
+
+const (
+	magic   = 0xED0CDAED
+	version = 2
+
+	// | ID 8 | FLAGS 2 | COUNT 2 | OVERFLOW 4 |
+	pageHeaderSize = 16
+	// 分支页面元素 | POS 4 | KSIZE 4 | PGID 8 |，叶子页面元素 | FLAGS 4 | POS 4 | KSIZE 4 | VSIZE 4 |
+	elementSize = 16
+	// | MAGIC 4 | VERSION 4 | PAGESIZE 4 | FLAGS 4 | ROOT 8 | SEQUENCE 8 | FREELIST 8 | PGID 8 | TXID 8 | CHECKSUM 8 |
+	metaSize = 64
+	// 嵌套 Bucket 的值以 | ROOT 8 | SEQUENCE 8 | 开头，ROOT 为 0 时之后是内联的页面
+	bucketHeaderSize = 16
+)
+
+// 页面和叶子元素的标志位
+const (
+	branchPageFlag = 0x01
+	leafPageFlag   = 0x02
+	metaPageFlag   = 0x04
+	bucketLeafFlag = 0x01
+)
+
+// 页面大小的最大值，元数据页面损坏时按照 2 的幂依次尝试
+const maxPageSize = 64 << 10
+
+// DB 是只读打开的 Bolt 数据库文件，直接按照 bbolt 的页面格式解析，不依赖 bbolt 包
+// bbolt 按照机器的内存布局写入页面，这里按照小端解析，只支持 amd64 和 arm64 等小端机器生成的文件
+// 打开期间不能有其他进程写入这个文件，读取之前需要先停止使用它的应用
+type DB struct {
+	fd       *os.File
+	pageSize int
+	root     uint64
+	txid     uint64
+}
+
+// Open 打开 Bolt 数据库文件，使用两个元数据页面中校验和正确并且事务编号较大的一个
+func Open(path string) (*DB, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	db := &DB{fd: fd}
+	if err := db.loadMeta(); err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *DB) loadMeta() error {
+	buf := make([]byte, pageHeaderSize+metaSize)
+	if _, err := db.fd.ReadAt(buf, 0); err != nil {
+		return ErrInvalidFile
+	}
+
+	var sizes []int
+	m0, err0 := parseMeta(buf)
+	if err0 == nil {
+		sizes = append(sizes, m0.pageSize)
+	}
+	for size := 1 << 10; size <= maxPageSize; size <<= 1 {
+		sizes = append(sizes, size)
+	}
+
+	// 第二个元数据页面的位置取决于页面大小，第一个元数据页面损坏时逐个尝试
+	var m1 *meta
+	for _, size := range sizes {
+		if _, err := db.fd.ReadAt(buf, int64(size)); err != nil {
+			continue
+		}
+		if m, err := parseMeta(buf); err == nil && m.pageSize == size {
+			m1 = m
+			break
+		}
+	}
+
+	m := m0
+	if err0 != nil || (m1 != nil && m1.txid > m0.txid) {
+		m = m1
+	}
+	if m == nil {
+		if err0 != nil {
+			return err0
+		}
+		return ErrInvalidFile
+	}
+	db.pageSize, db.root, db.txid = m.pageSize, m.root, m.txid
+	return nil
+}
+
+type meta struct {
+	pageSize int
+	root     uint64
+	txid     uint64
+}
+
+func parseMeta(b []byte) (*meta, error) {
+	if binary.LittleEndian.Uint16(b[8:])&metaPageFlag == 0 {
+		return nil, ErrInvalidFile
+	}
+	m := b[pageHeaderSize : pageHeaderSize+metaSize]
+	if binary.LittleEndian.Uint32(m) != magic {
+		return nil, ErrInvalidFile
+	}
+	if v := binary.LittleEndian.Uint32(m[4:]); v != version {
+		return nil, fmt.Errorf("unsupported bolt version %d", v)
+	}
+	h := fnv.New64a()
+	_, _ = h.Write(m[:56])
+	if h.Sum64() != binary.LittleEndian.Uint64(m[56:]) {
+		return nil, errors.New("bolt meta page checksum mismatch")
+	}
+	size := int(binary.LittleEndian.Uint32(m[8:]))
+	if size < pageHeaderSize+metaSize || size > maxPageSize {
+		return nil, ErrInvalidFile
+	}
+	return &meta{pageSize: size, root: binary.LittleEndian.Uint64(m[16:]), txid: binary.LittleEndian.Uint64(m[48:])}, nil
+}
+
+// TxID 返回打开时使用的元数据页面的事务编号
+func (db *DB) TxID() uint64 {
+	return db.txid
+}
+
+// Close 关闭数据库文件
+func (db *DB) Close() error {
+	return db.fd.Close()
+}
+
+// readPage 读取页面和它的溢出页面
+func (db *DB) readPage(id uint64) ([]byte, error) {
+	page := make([]byte, db.pageSize)
+	if _, err := db.fd.ReadAt(page, int64(id)*int64(db.pageSize)); err != nil {
+		return nil, fmt.Errorf("failed to read bolt page %d: %w", id, err)
+	}
+	if got := binary.LittleEndian.Uint64(page); got != id {
+		return nil, fmt.Errorf("bolt page %d has id %d", id, got)
+	}
+	if overflow := int(binary.LittleEndian.Uint32(page[12:])); overflow > 0 {
+		page = append(page, make([]byte, overflow*db.pageSize)...)
+		if _, err := db.fd.ReadAt(page[db.pageSize:], int64(id+1)*int64(db.pageSize)); err != nil {
+			return nil, fmt.Errorf("failed to read bolt page %d: %w", id, err)
+		}
+	}
+	return page, nil
+}
+
+// frame 是遍历时正在读取的页面，bucket 为 true 表示这是一个 Bucket 的根页面
+type frame struct {
+	page   []byte
+	flags  uint16
+	count  int
+	index  int
+	bucket bool
+}
+
+// Iterator 按照 Key 的顺序深度优先遍历所有 Bucket 中的键值对，嵌套的 Bucket 在它的名字所在的位置展开
+type Iterator struct {
+	db      *DB
+	stack   []*frame
+	buckets [][]byte
+	key     []byte
+	value   []byte
+	err     error
+}
+
+// NewIterator 从根 Bucket 开始遍历，根 Bucket 中只有 Bucket 没有键值对
+func (db *DB) NewIterator() *Iterator {
+	it := &Iterator{db: db}
+	it.pushPage(db.root, false)
+	return it
+}
+
+func (it *Iterator) pushPage(id uint64, bucket bool) {
+	page, err := it.db.readPage(id)
+	if err != nil {
+		it.err = err
+		return
+	}
+	it.push(page, bucket)
+}
+
+func (it *Iterator) push(page []byte, bucket bool) {
+	if len(page) < pageHeaderSize {
+		it.err = ErrInvalidFile
+		return
+	}
+	f := &frame{
+		page:   page,
+		flags:  binary.LittleEndian.Uint16(page[8:]),
+		count:  int(binary.LittleEndian.Uint16(page[10:])),
+		bucket: bucket,
+	}
+	if f.flags&(branchPageFlag|leafPageFlag) == 0 || pageHeaderSize+f.count*elementSize > len(page) {
+		it.err = fmt.Errorf("invalid bolt page %d", binary.LittleEndian.Uint64(page))
+		return
+	}
+	it.stack = append(it.stack, f)
+}
+
+// Next 移动到下一个键值对，Bucket 本身不会返回
+func (it *Iterator) Next() bool {
+	for it.err == nil && len(it.stack) > 0 {
+		f := it.stack[len(it.stack)-1]
+		if f.index >= f.count {
+			it.stack = it.stack[:len(it.stack)-1]
+			if f.bucket {
+				it.buckets = it.buckets[:len(it.buckets)-1]
+			}
+			continue
+		}
+		elem := f.page[pageHeaderSize+f.index*elementSize:]
+		offset := pageHeaderSize + f.index*elementSize
+		f.index++
+
+		if f.flags&branchPageFlag != 0 {
+			it.pushPage(binary.LittleEndian.Uint64(elem[8:]), false)
+			continue
+		}
+
+		flags := binary.LittleEndian.Uint32(elem)
+		start := offset + int(binary.LittleEndian.Uint32(elem[4:]))
+		ksize := int(binary.LittleEndian.Uint32(elem[8:]))
+		vsize := int(binary.LittleEndian.Uint32(elem[12:]))
+		if start+ksize+vsize > len(f.page) {
+			it.err = fmt.Errorf("invalid bolt page %d", binary.LittleEndian.Uint64(f.page))
+			return false
+		}
+		key := f.page[start : start+ksize]
+		value := f.page[start+ksize : start+ksize+vsize]
+
+		if flags&bucketLeafFlag != 0 {
+			if len(value) < bucketHeaderSize {
+				it.err = fmt.Errorf("invalid bolt bucket %q", key)
+				return false
+			}
+			it.buckets = append(it.buckets, key)
+			if root := binary.LittleEndian.Uint64(value); root != 0 {
+				it.pushPage(root, true)
+			} else {
+				it.push(value[bucketHeaderSize:], true)
+			}
+			if it.err != nil {
+				return false
+			}
+			continue
+		}
+
+		it.key, it.value = key, value
+		return true
+	}
+	return false
+}
+
+// Buckets 返回当前键值对所在的 Bucket 路径，从顶层 Bucket 开始，在下一次 Next 之前有效
+func (it *Iterator) Buckets() [][]byte {
+	return it.buckets
+}
+
+// Key 返回当前的 Key，在下一次 Next 之前有效
+func (it *Iterator) Key() []byte {
+	return it.key
+}
+
+// Value 返回当前的 Value，在下一次 Next 之前有效
+func (it *Iterator) Value() []byte {
+	return it.value
+}
+
+// Err 返回遍历过程中的错误
+func (it *Iterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,175 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testPageSize = 4096
+
+type leaf struct {
+	flags uint32
+	key   string
+	value string
+}
+
+// leafPage 按照 bbolt 的格式生成叶子页面，id 为 0 时用作内联 Bucket
+func leafPage(id uint64, items ...leaf) []byte {
+	b := make([]byte, pageHeaderSize+len(items)*elementSize)
+	binary.LittleEndian.PutUint64(b, id)
+	binary.LittleEndian.PutUint16(b[8:], leafPageFlag)
+	binary.LittleEndian.PutUint16(b[10:], uint16(len(items)))
+	for i, item := range items {
+		elem := b[pageHeaderSize+i*elementSize:]
+		binary.LittleEndian.PutUint32(elem, item.flags)
+		binary.LittleEndian.PutUint32(elem[4:], uint32(len(b)-pageHeaderSize-i*elementSize))
+		binary.LittleEndian.PutUint32(elem[8:], uint32(len(item.key)))
+		binary.LittleEndian.PutUint32(elem[12:], uint32(len(item.value)))
+		b = append(b, item.key...)
+		b = append(b, item.value...)
+	}
+	return b
+}
+
+func branchPage(id uint64, keys []string, children []uint64) []byte {
+	b := make([]byte, pageHeaderSize+len(keys)*elementSize)
+	binary.LittleEndian.PutUint64(b, id)
+	binary.LittleEndian.PutUint16(b[8:], branchPageFlag)
+	binary.LittleEndian.PutUint16(b[10:], uint16(len(keys)))
+	for i, key := range keys {
+		elem := b[pageHeaderSize+i*elementSize:]
+		binary.LittleEndian.PutUint32(elem, uint32(len(b)-pageHeaderSize-i*elementSize))
+		binary.LittleEndian.PutUint32(elem[4:], uint32(len(key)))
+		binary.LittleEndian.PutUint64(elem[8:], children[i])
+		b = append(b, key...)
+	}
+	return b
+}
+
+func bucket(root uint64, inline []byte) leaf {
+	value := make([]byte, bucketHeaderSize, bucketHeaderSize+len(inline))
+	binary.LittleEndian.PutUint64(value, root)
+	return leaf{flags: bucketLeafFlag, value: string(append(value, inline...))}
+}
+
+func withKey(l leaf, key string) leaf {
+	l.key = key
+	return l
+}
+
+func metaPage(id, root, txid uint64) []byte {
+	b := make([]byte, testPageSize)
+	binary.LittleEndian.PutUint64(b, id)
+	binary.LittleEndian.PutUint16(b[8:], metaPageFlag)
+	m := b[pageHeaderSize:]
+	binary.LittleEndian.PutUint32(m, magic)
+	binary.LittleEndian.PutUint32(m[4:], version)
+	binary.LittleEndian.PutUint32(m[8:], testPageSize)
+	binary.LittleEndian.PutUint64(m[16:], root)
+	binary.LittleEndian.PutUint64(m[48:], txid)
+	h := fnv.New64a()
+	_, _ = h.Write(m[:56])
+	binary.LittleEndian.PutUint64(m[56:], h.Sum64())
+	return b
+}
+
+// pad 把页面补齐到页面大小的整数倍，超过一个页面的部分记录为溢出页面
+func pad(b []byte) []byte {
+	pages := (len(b) + testPageSize - 1) / testPageSize
+	binary.LittleEndian.PutUint32(b[12:], uint32(pages-1))
+	return append(b, make([]byte, pages*testPageSize-len(b))...)
+}
+
+func TestIterator(t *testing.T) {
+	large := strings.Repeat("v", 5000)
+	sub := leafPage(0, leaf{key: "x", value: "y"})
+	inline := leafPage(0, leaf{key: "k1", value: "v1"}, withKey(bucket(0, sub), "sub"), leaf{key: "k2", value: "v2"})
+
+	a := withKey(bucket(0, inline), "a")
+	b := withKey(bucket(4, nil), "b")
+
+	var file bytes.Buffer
+	// 第一个元数据页面的事务编号较小，它的根页面是不能遍历的空闲列表页面
+	file.Write(metaPage(0, 2, 1))
+	file.Write(metaPage(1, 3, 2))
+	file.Write(pad(make([]byte, pageHeaderSize)))
+	file.Write(pad(leafPage(3, a, b)))
+	file.Write(pad(branchPage(4, []string{"b1", "b2"}, []uint64{5, 6})))
+	file.Write(pad(leafPage(5, leaf{key: "b1", value: "1"})))
+	file.Write(pad(leafPage(6, leaf{key: "b2", value: large})))
+
+	path := filepath.Join(t.TempDir(), "bolt.db")
+	if err := os.WriteFile(path, file.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("failed to open bolt database: %v", err)
+	}
+	defer db.Close()
+	if db.TxID() != 2 {
+		t.Fatalf("expected txid 2, got %d", db.TxID())
+	}
+
+	var got []string
+	it := db.NewIterator()
+	for it.Next() {
+		var path []string
+		for _, name := range it.Buckets() {
+			path = append(path, string(name))
+		}
+		got = append(got, strings.Join(append(path, string(it.Key())), "/")+"="+string(it.Value()))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("failed to iterate: %v", err)
+	}
+
+	want := []string{"a/k1=v1", "a/sub/x=y", "a/k2=v2", "b/b1=1", "b/b2=" + large}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "invalid.db")
+	if err := os.WriteFile(path, make([]byte, 2*testPageSize), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err != ErrInvalidFile {
+		t.Errorf("expected ErrInvalidFile, got %v", err)
+	}
+
+	// 第一个元数据页面损坏时使用第二个
+	meta := metaPage(0, 2, 5)
+	meta[pageHeaderSize+56] ^= 0xFF
+	file := append(meta, metaPage(1, 2, 4)...)
+	file = append(file, pad(leafPage(2))...)
+	path = filepath.Join(dir, "recovered.db")
+	if err := os.WriteFile(path, file, 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("failed to open bolt database: %v", err)
+	}
+	defer db.Close()
+	if db.TxID() != 4 {
+		t.Errorf("expected txid 4, got %d", db.TxID())
+	}
+
+	it := db.NewIterator()
+	if it.Next() || it.Err() != nil {
+		t.Errorf("expected empty database, got %s %v", it.Key(), it.Err())
+	}
+	// 根页面不是分支或者叶子页面时返回错误
+	db.root = 1
+	if it := db.NewIterator(); it.Next() || it.Err() == nil {
+		t.Errorf("expected error for meta page as root")
+	}
+}
@@ -0,0 +1,113 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ErrNoNodes = errors.New("cluster has no nodes")
+
+// MovedHeader 是服务器重定向请求时携带槽位和正确节点的响应头，格式为 MOVED {slot} {node}
+const MovedHeader = "Cluster-Moved"
+
+// Router 记录每个哈希槽由哪个节点负责，客户端根据它把 Key 发送到对应的实例
+// 收到 MOVED 重定向之后调用 HandleMoved 更新槽位，后面的请求不会再被重定向
+type Router struct {
+	mu    sync.RWMutex
+	slots [SlotCount]string
+}
+
+// NewRouter 把所有的槽位按照顺序平均分配给 nodes，每个节点负责一段连续的槽位
+func NewRouter(nodes ...string) (*Router, error) {
+	if len(nodes) == 0 {
+		return nil, ErrNoNodes
+	}
+
+	r := new(Router)
+	for i, node := range nodes {
+		if node == "" {
+			return nil, errors.New("cluster node address cannot be empty")
+		}
+		start := i * SlotCount / len(nodes)
+		end := (i+1)*SlotCount/len(nodes) - 1
+		for slot := start; slot <= end; slot++ {
+			r.slots[slot] = node
+		}
+	}
+	return r, nil
+}
+
+// Assign 把 [start, end] 范围内的槽位分配给 node，用于迁移槽位或者按照集群配置初始化
+func (r *Router) Assign(start, end uint16, node string) error {
+	if start > end || end >= SlotCount {
+		return fmt.Errorf("invalid slot range %d-%d", start, end)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for slot := int(start); slot <= int(end); slot++ {
+		r.slots[slot] = node
+	}
+	return nil
+}
+
+// Owner 返回负责槽位的节点，没有分配的槽位返回空字符串
+func (r *Router) Owner(slot uint16) string {
+	if slot >= SlotCount {
+		return ""
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.slots[slot]
+}
+
+// Node 返回负责 Key 的节点
+func (r *Router) Node(key string) string {
+	return r.Owner(Slot(key))
+}
+
+// Nodes 按照第一次出现的槽位顺序返回所有的节点
+func (r *Router) Nodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var nodes []string
+	seen := make(map[string]bool)
+	for _, node := range r.slots {
+		if node != "" && !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// HandleMoved 根据 MovedHeader 响应头更新槽位，返回请求应该重新发送到的节点
+func (r *Router) HandleMoved(header string) (string, error) {
+	slot, node, err := ParseMoved(header)
+	if err != nil {
+		return "", err
+	}
+	return node, r.Assign(slot, slot, node)
+}
+
+// FormatMoved 返回 MovedHeader 响应头的值
+func FormatMoved(slot uint16, node string) string {
+	return "MOVED " + strconv.Itoa(int(slot)) + " " + node
+}
+
+// ParseMoved 解析 MovedHeader 响应头中的槽位和节点
+func ParseMoved(header string) (uint16, string, error) {
+	fields := strings.Fields(header)
+	if len(fields) != 3 || fields[0] != "MOVED" {
+		return 0, "", fmt.Errorf("invalid moved header %q", header)
+	}
+	slot, err := strconv.ParseUint(fields[1], 10, 16)
+	if err != nil || slot >= SlotCount {
+		return 0, "", fmt.Errorf("invalid moved header %q", header)
+	}
+	return uint16(slot), fields[2], nil
+}
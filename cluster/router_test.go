@@ -0,0 +1,42 @@
+package cluster
+
+import "testing"
+
+func TestRouter(t *testing.T) {
+	if _, err := NewRouter(); err != ErrNoNodes {
+		t.Fatalf("expected ErrNoNodes, got %v", err)
+	}
+
+	r, err := NewRouter("node-a:2468", "node-b:2468", "node-c:2468")
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+	if nodes := r.Nodes(); len(nodes) != 3 || nodes[0] != "node-a:2468" || nodes[2] != "node-c:2468" {
+		t.Fatalf("unexpected nodes: %v", nodes)
+	}
+	if r.Owner(0) != "node-a:2468" || r.Owner(SlotCount-1) != "node-c:2468" {
+		t.Errorf("unexpected owners of the first and last slots: %s %s", r.Owner(0), r.Owner(SlotCount-1))
+	}
+
+	// 收到重定向之后只更新对应的槽位
+	slot := Slot("user-01")
+	node, err := r.HandleMoved(FormatMoved(slot, "node-d:2468"))
+	if err != nil || node != "node-d:2468" {
+		t.Fatalf("failed to handle moved: %s %v", node, err)
+	}
+	if r.Node("user-01") != "node-d:2468" {
+		t.Errorf("expected user-01 routed to node-d, got %s", r.Node("user-01"))
+	}
+	if slot > 0 && r.Owner(slot-1) == "node-d:2468" {
+		t.Errorf("expected neighbour slot unchanged")
+	}
+
+	for _, header := range []string{"", "MOVED 1", "ASK 1 node", "MOVED 16384 node", "MOVED x node"} {
+		if _, _, err := ParseMoved(header); err == nil {
+			t.Errorf("expected error for moved header %q", header)
+		}
+	}
+	if err := r.Assign(10, 5, "node-a:2468"); err == nil {
+		t.Errorf("expected error for invalid slot range")
+	}
+}
@@ -0,0 +1,41 @@
+package cluster
+
+import "strings"
+
+// SlotCount 是哈希槽的个数，和 Redis Cluster 相同，Key 通过 CRC16 取模映射到其中一个槽
+const SlotCount = 16384
+
+// Slot 返回 Key 所在的哈希槽，Key 中包含非空的 {tag} 时只计算第一个 tag，相同 tag 的 Key 分配到同一个节点
+func Slot(key string) uint16 {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return crc16(key) % SlotCount
+}
+
+// crc16 是 CRC-16/XMODEM 校验，多项式 0x1021，初始值 0，和 Redis Cluster 计算出的槽位一致
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
+
+var crc16Table = func() [256]uint16 {
+	var table [256]uint16
+	for i := range table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
@@ -0,0 +1,28 @@
+package cluster
+
+import "testing"
+
+func TestSlot(t *testing.T) {
+	if crc := crc16("123456789"); crc != 0x31C3 {
+		t.Fatalf("expected crc16 0x31C3, got %#x", crc)
+	}
+
+	// 和 Redis CLUSTER KEYSLOT 的结果一致
+	tests := []struct {
+		key  string
+		slot uint16
+	}{
+		{"foo", 12182},
+		{"bar", 5061},
+		{"{user1000}.following", Slot("user1000")},
+		{"{user1000}.followers", Slot("user1000")},
+		{"foo{}{bar}", crc16("foo{}{bar}") % SlotCount},
+		{"foo{{bar}}zap", Slot("{bar")},
+		{"foo{bar}{zap}", Slot("bar")},
+	}
+	for _, tt := range tests {
+		if slot := Slot(tt.key); slot != tt.slot {
+			t.Errorf("expected slot %d for %q, got %d", tt.slot, tt.key, slot)
+		}
+	}
+}
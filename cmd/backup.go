@@ -0,0 +1,77 @@
+// Copyright 2022 Leon Ding <ding@ibyte.me> https://wiredkv.github.io
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/auula/wiredkv/clog"
+	"github.com/auula/wiredkv/conf"
+	"github.com/auula/wiredkv/s3"
+	"github.com/auula/wiredkv/vfs"
+)
+
+// backupTarget 返回配置中的备份位置，S3 的密钥没有配置时从 AWS 的环境变量读取
+func backupTarget(opt *conf.Backup) (vfs.BackupTarget, error) {
+	if opt.Dir != "" {
+		return &vfs.DirTarget{Path: opt.Dir}, nil
+	}
+
+	accessKey, secretKey := opt.S3.AccessKey, opt.S3.SecretKey
+	if accessKey == "" && secretKey == "" {
+		accessKey, secretKey = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	return s3.NewTarget(s3.Config{
+		Endpoint:      opt.S3.Endpoint,
+		Region:        opt.S3.Region,
+		Bucket:        opt.S3.Bucket,
+		Prefix:        opt.S3.Prefix,
+		AccessKey:     accessKey,
+		SecretKey:     secretKey,
+		SessionToken:  os.Getenv("AWS_SESSION_TOKEN"),
+		VirtualHosted: opt.S3.VirtualHosted,
+		SSE:           opt.S3.SSE,
+		KMSKeyID:      opt.S3.KMSKeyID,
+		PartSize:      opt.S3.PartSize,
+	})
+}
+
+// runBackup 返回管理命令执行的备份，备份文件和定期备份一样按照开始的时间命名
+func runBackup(fss *vfs.LogStructuredFS, target vfs.BackupTarget, incremental bool) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		var (
+			report *vfs.BackupReport
+			err    error
+		)
+		if incremental {
+			report, err = fss.IncrementalBackup(ctx, target, vfs.ManifestName(time.Now()))
+		} else {
+			report, err = fss.Backup(ctx, target, vfs.BackupName(time.Now()))
+		}
+		if err != nil {
+			return err
+		}
+		if incremental {
+			clog.Infof("Incremental backup %s completed: %d regions (%d bytes reused, %d bytes uploaded) in %s",
+				report.Name, report.Regions, report.Reused, report.Bytes, report.Duration.Round(time.Millisecond))
+			return nil
+		}
+		clog.Infof("Backup %s completed: %d records (%d bytes) in %s",
+			report.Name, report.Records, report.Bytes, report.Duration.Round(time.Millisecond))
+		return nil
+	}
+}
@@ -16,7 +16,9 @@
 package cmd
 
 import (
+	"context"
 	_ "embed"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -44,6 +46,8 @@ var (
 	greenFont = color.New(color.FgHiRed)
 	banner    = greenFont.Sprintf(logo, version, website)
 	daemon    = false
+	// 启动时使用的配置文件，收到 SIGHUP 信号时重新加载
+	config = ""
 )
 
 // 初始化全局需要使用的组件
@@ -52,6 +56,7 @@ func init() {
 	fmt.Println(banner)
 	fl := parseFlags()
 
+	config = fl.config
 	if conf.HasCustom(fl.config) {
 		err := conf.Load(fl.config, conf.Settings)
 		if err != nil {
@@ -60,15 +65,22 @@ func init() {
 		clog.Info("Loading custom config file was successfully")
 	}
 
+	// 环境变量覆盖配置文件和内置默认配置，命令行参数的优先级最高
+	err := conf.ApplyEnv(conf.Settings)
+	if err != nil {
+		clog.Failed(err)
+	}
+
 	if fl.debug {
-		conf.Settings.Debug, clog.IsDebug = fl.debug, fl.debug
+		conf.Settings.Debug = fl.debug
 	}
+	clog.IsDebug = conf.Settings.Debug
 
 	// 命令行传入的密码优先级最高
 	if fl.auth != conf.Default.Password {
 		conf.Settings.Password = fl.auth
-	} else {
-		// 如果命令行没有传入密码，系统随机生成一串 20 位的密码
+	} else if conf.Settings.Password == conf.Default.Password {
+		// 如果命令行、配置文件和环境变量都没有设置密码，系统随机生成一串 20 位的密码
 		conf.Settings.Password = utils.RandomString(20)
 		clog.Infof("The default password is: %s", conf.Settings.Password)
 	}
@@ -81,9 +93,6 @@ func init() {
 		conf.Settings.Port = fl.port
 	}
 
-	clog.Debug(conf.Settings)
-
-	var err error = nil
 	// 验证命令参入的参数，即使有默认配置，命令行参数不受约束
 	err = conf.Vaildated(conf.Settings)
 	if err != nil {
@@ -96,6 +105,7 @@ func init() {
 	}
 
 	clog.Info("Logging output initialized successfully")
+	clog.Infof("Effective config: %s", conf.Settings.Redacted())
 }
 
 func StartApp() {
@@ -121,27 +131,108 @@ func runAsDaemon() {
 }
 
 func runServer() {
+	// 配置了 ACL 文件时每个用户使用自己的密码认证，按照 bucket 授权
+	var authorizer server.Authorizer
+	if conf.Settings.ACLFile != "" {
+		acl, err := server.LoadACL(conf.Settings.ACLFile)
+		if err != nil {
+			clog.Failed(err)
+		}
+		authorizer = acl
+		clog.Info("Access control list loaded successfully")
+	}
+
 	hts, err := server.New(&server.Options{
-		Port: conf.Settings.Port,
-		Auth: conf.Settings.Password,
+		Host:           conf.Settings.Host,
+		Port:           conf.Settings.Port,
+		CertFile:       conf.Settings.TLS.CertFile,
+		KeyFile:        conf.Settings.TLS.KeyFile,
+		Auth:           conf.Settings.Password,
+		GracePeriod:    conf.Settings.ShutdownGracePeriod(),
+		Authorizer:     authorizer,
+		AdminAuditLog:  conf.Settings.AdminAuditLog,
+		AllowCommands:  conf.Settings.AllowCommands,
+		DenyCommands:   conf.Settings.DenyCommands,
+		RenameCommands: conf.Settings.RenameCommands,
+		MaxConnections: conf.Settings.Limits.MaxConnections,
+		ReadTimeout:    time.Duration(conf.Settings.Limits.ReadTimeout) * time.Second,
+		WriteTimeout:   time.Duration(conf.Settings.Limits.WriteTimeout) * time.Second,
+		IdleTimeout:    time.Duration(conf.Settings.Limits.IdleTimeout) * time.Second,
+		MaxHeaderBytes: conf.Settings.Limits.MaxHeaderBytes,
+		MaxBodyBytes:   conf.Settings.Limits.MaxBodyBytes,
+		KeepAlive:      time.Duration(conf.Settings.Limits.KeepAlive) * time.Second,
+		ReapInterval:   time.Duration(conf.Settings.Limits.ReapInterval) * time.Second,
 	})
 	if err != nil {
 		clog.Failed(err)
 	}
 
-	fss, err := vfs.OpenFS(&vfs.Options{
-		FsPerm:    conf.FsPerm,
-		Path:      conf.Settings.Path,
-		Threshold: conf.Settings.Region.Threshold,
-	})
+	checksum, err := vfs.ParseChecksum(conf.Settings.Region.Checksum)
+	if err != nil {
+		clog.Failed(err)
+	}
+
+	runtime, err := runtimeOptions(conf.Settings)
+	if err != nil {
+		clog.Failed(err)
+	}
+
+	opt := &vfs.Options{
+		FsPerm:       conf.FsPerm,
+		Path:         conf.Settings.Path,
+		Threshold:    conf.Settings.Region.Threshold,
+		SegmentSize:  conf.Settings.Region.SegmentSize,
+		MaxDiskBytes: conf.Settings.Region.MaxDiskBytes,
+		AuditLog:     conf.Settings.AuditLog,
+		Checksum:     checksum,
+		VerifyOnOpen: conf.Settings.Region.Verify,
+
+		Sync:             runtime.Sync,
+		MaxCacheMemory:   runtime.MaxCacheMemory,
+		GCRatio:          runtime.GCRatio,
+		WriteBytesPerSec: runtime.WriteBytesPerSec,
+		WriteOpsPerSec:   runtime.WriteOpsPerSec,
+	}
+
+	// 压缩和加密需要在 OpenFS 恢复索引之前设置，否则加密的 hint 文件和索引快照不能读取
+	if conf.Settings.IsCompressionEnabled() && !conf.Settings.Compressor.Adaptive {
+		// 设置文件数据使用配置的压缩算法，默认 Snappy
+		opt.Compressor, err = compressorByName(conf.Settings.Compressor.Codec)
+		if err != nil {
+			clog.Failed(err)
+		}
+	}
+
+	if conf.Settings.IsEncryptionEnabled() {
+		// 设置文件数据使用 AES-GCM 加密算法
+		secret, err := conf.Settings.Encryptor.LoadSecret()
+		if err != nil {
+			clog.Failed(err)
+		}
+		opt.Encryptor = vfs.AESGCMEncryptor
+		opt.Secret = []byte(secret)
+	}
+
+	fss, err := vfs.OpenFS(opt)
+	// OpenFS 会复制一份密钥，临时的字节切片用完之后立即清零
+	for i := range opt.Secret {
+		opt.Secret[i] = 0
+	}
 	if err != nil {
 		clog.Failed(err)
 	}
 
-	if conf.Settings.IsCompressionEnabled() {
-		// 设置文件数据使用 Snappy 压缩算法
-		fss.SetCompressor(vfs.SnappyCompressor)
-		clog.Info("Snappy compression activated successfully")
+	if opt.Compressor != nil {
+		clog.Info("Compression activated successfully")
+	}
+	if opt.Encryptor != nil {
+		clog.Info("AES-GCM encryption activated successfully")
+	}
+
+	if conf.Settings.IsCompressionEnabled() && conf.Settings.Compressor.Adaptive {
+		// 每条记录单独选择压缩算法
+		fss.SetAdaptiveCompression(vfs.AdaptiveOptions{})
+		clog.Info("Adaptive compression activated successfully")
 	}
 
 	if conf.Settings.IsRegionGCEnabled() {
@@ -157,6 +248,42 @@ func runServer() {
 	hts.SetupFS(fss)
 	clog.Info("File system setup completed successfully")
 
+	if conf.Settings.IsBackupEnabled() {
+		target, err := backupTarget(&conf.Settings.Backup)
+		if err != nil {
+			clog.Failed(err)
+		}
+		if conf.Settings.Backup.Encryptor.Enable {
+			secret, err := conf.Settings.Backup.Encryptor.LoadSecret()
+			if err != nil {
+				clog.Failed(err)
+			}
+			key := []byte(secret)
+			err = fss.SetBackupKey(key)
+			for i := range key {
+				key[i] = 0
+			}
+			if err != nil {
+				clog.Failed(err)
+			}
+			clog.Info("Backup encryption activated successfully")
+		}
+		hts.HandleAdmin(server.AdminBackup, runBackup(fss, target, conf.Settings.Backup.Incremental))
+		if conf.Settings.Backup.Schedule != "" {
+			err = fss.StartBackupSchedule(vfs.BackupScheduleOptions{
+				Schedule:    conf.Settings.Backup.Schedule,
+				Target:      target,
+				Keep:        conf.Settings.Backup.Keep,
+				MaxAge:      conf.Settings.BackupMaxAge(),
+				Incremental: conf.Settings.Backup.Incremental,
+			})
+			if err != nil {
+				clog.Failed(err)
+			}
+		}
+		clog.Info("Backup target setup completed successfully")
+	}
+
 	go func() {
 		err := hts.Startup()
 		if err != nil {
@@ -166,14 +293,49 @@ func runServer() {
 
 	// 延迟输出正常消息，因为上面的 Startup 方法在正常情况下是一个阻塞方法
 	time.Sleep(500 * time.Millisecond)
-	clog.Infof("HTTP server started at http://%s:%d 🚀", hts.IPv4(), hts.Port())
+	clog.Infof("HTTP server started at %s://%s:%d 🚀", hts.Scheme(), hts.IPv4(), hts.Port())
+
+	// 收到 SIGHUP 信号时重新加载配置文件中可以在运行时修改的配置
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+	go func() {
+		for range reloadChan {
+			err := reloadConfig(fss)
+			if err != nil {
+				clog.Errorf("failed to reload config: %v", err)
+				continue
+			}
+			clog.Info("Reloading config file was successfully")
+		}
+	}()
 
 	// Keep the daemon process alive
 	signalChan := make(chan os.Signal, 1)
 	// 监听指定的信号
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+
+	// 管理命令和信号执行同样的操作，优雅退出会等待退出命令的响应写完
+	hts.HandleAdmin(server.AdminReload, func(ctx context.Context) error {
+		return reloadConfig(fss)
+	})
+	hts.HandleAdmin(server.AdminShutdown, func(ctx context.Context) error {
+		select {
+		case signalChan <- syscall.SIGTERM:
+		default:
+		}
+		return nil
+	})
 	// 阻塞，直到接收到信号
-	<-signalChan
+	sig := <-signalChan
+	clog.Infof("Received signal %s, shutting down within %s", sig, conf.Settings.ShutdownGracePeriod())
+
+	// 优雅退出的过程中再次收到信号就直接退出
+	go func() {
+		sig := <-signalChan
+		clog.Warnf("Received signal %s again, exiting immediately", sig)
+		os.Exit(1)
+	}()
+
 	err = hts.Shutdown()
 	if err != nil {
 		clog.Failed(err)
@@ -181,6 +343,77 @@ func runServer() {
 	clog.Info("process exit")
 }
 
+// compressorByName 返回配置文件中压缩算法名称对应的实现
+func compressorByName(name string) (vfs.Compressor, error) {
+	switch name {
+	case "", "snappy":
+		return vfs.SnappyCompressor, nil
+	case "flate":
+		return vfs.FlateCompressor, nil
+	default:
+		return nil, fmt.Errorf("unsupported compressor codec: %s", name)
+	}
+}
+
+// runtimeOptions 返回配置中可以在运行时修改的存储引擎配置
+func runtimeOptions(opt *conf.ServerOptions) (vfs.RuntimeOptions, error) {
+	sync, err := vfs.ParseSyncPolicy(opt.Sync)
+	if err != nil {
+		return vfs.RuntimeOptions{}, err
+	}
+
+	return vfs.RuntimeOptions{
+		Sync:             sync,
+		MaxCacheMemory:   opt.CacheMemory,
+		GCRatio:          opt.Region.GCRatio,
+		WriteBytesPerSec: opt.WriteBytesPerSec,
+		WriteOpsPerSec:   opt.WriteOpsPerSec,
+	}, nil
+}
+
+// reloadConfig 重新读取配置文件，只应用刷盘策略、缓存大小、gc 阈值、写入限速和日志级别
+// 其他配置需要重启之后才能生效
+func reloadConfig(fss *vfs.LogStructuredFS) error {
+	if !conf.HasCustom(config) {
+		return errors.New("no config file specified with --config")
+	}
+
+	opt := new(conf.ServerOptions)
+	err := opt.Unmarshal([]byte(conf.DefaultConfigJSON))
+	if err != nil {
+		return err
+	}
+
+	err = conf.Load(config, opt)
+	if err != nil {
+		return err
+	}
+
+	err = conf.ApplyEnv(opt)
+	if err != nil {
+		return err
+	}
+
+	runtime, err := runtimeOptions(opt)
+	if err != nil {
+		return err
+	}
+
+	err = fss.SetOptions(runtime)
+	if err != nil {
+		return err
+	}
+
+	conf.Settings.Sync = opt.Sync
+	conf.Settings.CacheMemory = opt.CacheMemory
+	conf.Settings.Region.GCRatio = opt.Region.GCRatio
+	conf.Settings.WriteBytesPerSec = opt.WriteBytesPerSec
+	conf.Settings.WriteOpsPerSec = opt.WriteOpsPerSec
+	conf.Settings.Debug, clog.IsDebug = opt.Debug, opt.Debug
+
+	return nil
+}
+
 type flags struct {
 	auth   string
 	port   int
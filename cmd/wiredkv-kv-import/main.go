@@ -0,0 +1,184 @@
+// Copyright 2022 Leon Ding <ding@ibyte.me> https://wiredkv.github.io
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// wiredkv-kv-import 把 Bolt 数据库或者 Badger 的备份文件通过 Ingest 批量导入到数据目录中，值保存为 Binary 或者 Text
+// Bolt 中嵌套 Bucket 的名字和 Key 使用 --sep 连接成一个 Key，例如 users/1001
+// Badger 需要先通过 badger backup 命令导出备份文件，只导入每个 Key 的最新版本，已经删除和过期的 Key 会被跳过
+// 使用之前必须先停止 wiredkv 服务进程和使用 Bolt 数据库的应用，例如：
+//
+//	wiredkv-kv-import --path /tmp/wiredkv --bolt app.db --sep /
+//	wiredkv-kv-import --path /tmp/wiredkv --badger badger.bak --kind text
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/auula/wiredkv/badger"
+	"github.com/auula/wiredkv/bolt"
+	"github.com/auula/wiredkv/clog"
+	"github.com/auula/wiredkv/vfs"
+)
+
+func main() {
+	path := flag.String("path", "", "--path the data storage directory.")
+	boltFile := flag.String("bolt", "", "--bolt the bolt database file to import.")
+	badgerFile := flag.String("badger", "", "--badger the badger backup file to import.")
+	kindName := flag.String("kind", "binary", "--kind the type of imported values, binary or text.")
+	sep := flag.String("sep", "/", "--sep the separator between bolt bucket names and keys.")
+	threshold := flag.Uint("threshold", 3, "--threshold the region file size in GB.")
+	flag.Parse()
+
+	if *path == "" || (*boltFile == "") == (*badgerFile == "") {
+		clog.Failed("data directory path and exactly one of bolt or badger file must be given")
+	}
+	kind, err := parseKind(*kindName)
+	if err != nil {
+		clog.Failed(err)
+	}
+
+	var it vfs.IngestIterator
+	var source string
+	if *boltFile != "" {
+		db, err := bolt.Open(*boltFile)
+		if err != nil {
+			clog.Failed(err)
+		}
+		defer db.Close()
+		it, source = &boltIterator{it: db.NewIterator(), kind: kind, sep: []byte(*sep)}, "bolt database"
+	} else {
+		fd, err := os.Open(*badgerFile)
+		if err != nil {
+			clog.Failed(err)
+		}
+		defer fd.Close()
+		it, source = &badgerIterator{rd: badger.NewReader(fd), kind: kind, now: uint64(time.Now().Unix())}, "badger backup"
+	}
+
+	lfs, err := vfs.OpenFS(&vfs.Options{Path: *path, FsPerm: 0755, Threshold: uint8(*threshold)})
+	if err != nil {
+		clog.Failed(err)
+	}
+
+	report, err := lfs.Ingest(it)
+	if cerr := lfs.CloseFS(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if err != nil {
+		clog.Failed(err)
+	}
+
+	clog.Infof("Imported %d keys (%d bytes) from %s in %s",
+		report.Records, report.Bytes, source, report.Duration.Round(time.Millisecond))
+	if it, ok := it.(*badgerIterator); ok && it.skipped > 0 {
+		clog.Infof("Skipped %d keys that have been deleted or have already expired", it.skipped)
+	}
+}
+
+func parseKind(name string) (vfs.Kind, error) {
+	switch name {
+	case "binary":
+		return vfs.Binary, nil
+	case "text":
+		return vfs.Text, nil
+	}
+	return vfs.Unknown, fmt.Errorf("unsupported value kind %q", name)
+}
+
+// boltIterator 把 Bolt 中所有 Bucket 的键值对转换为记录交给 Ingest 批量导入
+type boltIterator struct {
+	it   *bolt.Iterator
+	kind vfs.Kind
+	sep  []byte
+	seg  *vfs.Segment
+	err  error
+}
+
+func (it *boltIterator) Next() bool {
+	if it.err != nil || !it.it.Next() {
+		return false
+	}
+	var key []byte
+	for _, name := range it.it.Buckets() {
+		key = append(append(key, name...), it.sep...)
+	}
+	key = append(key, it.it.Key()...)
+	it.seg, it.err = vfs.NewSegmentBytes(string(key), it.kind, it.it.Value(), 0)
+	return it.err == nil
+}
+
+func (it *boltIterator) Segment() *vfs.Segment {
+	return it.seg
+}
+
+func (it *boltIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.it.Err()
+}
+
+// badgerIterator 把备份中每个 Key 的最新版本转换为记录交给 Ingest 批量导入
+type badgerIterator struct {
+	rd      *badger.Reader
+	kind    vfs.Kind
+	now     uint64
+	last    []byte
+	seg     *vfs.Segment
+	err     error
+	skipped int
+}
+
+func (it *badgerIterator) Next() bool {
+	for it.err == nil {
+		kv, err := it.rd.Next()
+		if err == io.EOF {
+			return false
+		}
+		if err != nil {
+			it.err = err
+			return false
+		}
+		// 同一个 Key 的版本从新到旧排列，只有第一个是当前的值
+		if it.last != nil && bytes.Equal(kv.Key, it.last) {
+			continue
+		}
+		it.last = kv.Key
+
+		if kv.Deleted() || (kv.ExpiresAt > 0 && kv.ExpiresAt <= it.now) {
+			it.skipped++
+			continue
+		}
+		var ttl uint64
+		if kv.ExpiresAt > 0 {
+			ttl = kv.ExpiresAt - it.now
+		}
+
+		it.seg, it.err = vfs.NewSegmentBytes(string(kv.Key), it.kind, kv.Value, ttl)
+		return it.err == nil
+	}
+	return false
+}
+
+func (it *badgerIterator) Segment() *vfs.Segment {
+	return it.seg
+}
+
+func (it *badgerIterator) Err() error {
+	return it.err
+}
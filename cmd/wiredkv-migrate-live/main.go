@@ -0,0 +1,334 @@
+// Copyright 2022 Leon Ding <ding@ibyte.me> https://wiredkv.github.io
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// wiredkv-migrate-live 在 wiredkv 服务进程运行期间把数据目录中的数据通过网络写入另一个 wiredkv 服务进程
+// 源数据目录以只读模式打开并且跟随活跃数据文件，先复制所有的 Key，然后周期性的复制新的修改和删除
+// 存储引擎没有单独的变更日志，跟随数据文件读到的新记录就是源服务进程的修改，每一轮从上一轮的水位线继续
+// 写入通过目标服务进程的 /pipeline 接口，Value 使用 base64 编码并且保留数据类型和剩余的过期时间
+// 停止源服务进程的写入之后等待日志输出 caught up，再按 Ctrl+C 退出并且把客户端切换到目标服务进程，例如：
+//
+//	wiredkv-migrate-live --source /tmp/wiredkv --target http://10.0.0.2:2468 --user migrate --auth password --ops-per-sec 5000
+//
+// 目标服务进程开启了 ACL 时 --user 需要有 kv 的写权限，迁移开始之前目标中已经存在的 Key 不会被删除
+// 复制过的 Key 记录在内存中，每一轮检查它们在源数据目录中是否还存在，不存在时从目标服务进程中删除
+// 记录的版本号和 UserFlags 不会复制，目标服务进程写入时重新分配版本号
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/auula/wiredkv/clog"
+	"github.com/auula/wiredkv/vfs"
+)
+
+// 一个 /pipeline 请求中 Value 的最大字节数，不超过服务进程默认的请求体大小限制
+const maxBatchBytes = 8 << 20
+
+// command 和 result 是目标服务进程 /pipeline 接口的请求和结果
+type command struct {
+	Cmd      string `json:"cmd"`
+	Key      string `json:"key"`
+	Value    string `json:"value,omitempty"`
+	TTL      uint64 `json:"ttl,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type result struct {
+	Error string `json:"error,omitempty"`
+}
+
+type response struct {
+	Result  []result `json:"result"`
+	Message string   `json:"message"`
+}
+
+func main() {
+	source := flag.String("source", "", "--source the data storage directory being served.")
+	target := flag.String("target", "", "--target the url of the wiredkv server to write into, for example http://10.0.0.2:2468.")
+	user := flag.String("user", "", "--user the acl user of the target server.")
+	auth := flag.String("auth", "", "--auth the password of the target server.")
+	threshold := flag.Uint("threshold", 3, "--threshold the source region file size in GB.")
+	interval := flag.Duration("interval", time.Second, "--interval how often to copy new changes after the initial copy.")
+	batch := flag.Int("batch", 500, "--batch the number of commands sent in one request.")
+	bytesPerSec := flag.Int64("bytes-per-sec", 0, "--bytes-per-sec limit value bytes sent to the target per second, 0 means unlimited.")
+	opsPerSec := flag.Int64("ops-per-sec", 0, "--ops-per-sec limit commands sent to the target per second, 0 means unlimited.")
+	flag.Parse()
+
+	if *source == "" || *target == "" {
+		clog.Failed("source data directory and target server cannot be empty")
+	}
+	if !strings.HasPrefix(*target, "http://") && !strings.HasPrefix(*target, "https://") {
+		clog.Failed("target must be an http or https url")
+	}
+	if *batch <= 0 || *batch > 1000 {
+		clog.Failed("batch must be between 1 and 1000")
+	}
+
+	src, err := vfs.OpenFS(&vfs.Options{Path: *source, FsPerm: 0755, Threshold: uint8(*threshold), ReadOnly: true})
+	if err != nil {
+		clog.Failed(err)
+	}
+	follower, err := src.Follow(*interval / 2)
+	if err != nil {
+		clog.Failed(err)
+	}
+
+	m := &migration{
+		src:    src,
+		url:    strings.TrimSuffix(*target, "/") + "/pipeline",
+		user:   *user,
+		auth:   *auth,
+		batch:  *batch,
+		limit:  &rateLimit{bytesPerSec: *bytesPerSec, opsPerSec: *opsPerSec},
+		client: &http.Client{Timeout: time.Minute},
+		copied: make(map[string]struct{}),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	err = m.run(ctx, *interval)
+	follower.Stop()
+	_ = src.CloseFS()
+	if err != nil {
+		clog.Failed(err)
+	}
+	clog.Info("Live migration stopped, target server is up to date")
+}
+
+// migration 记录复制到目标服务进程的 Key，源数据目录中被删除的 Key 通过它发现
+type migration struct {
+	src    *vfs.LogStructuredFS
+	url    string
+	user   string
+	auth   string
+	batch  int
+	limit  *rateLimit
+	client *http.Client
+	copied map[string]struct{}
+}
+
+// run 先复制所有的 Key，然后每隔 interval 复制一次新的修改，ctx 取消之后完成最后一次复制再返回
+func (m *migration) run(ctx context.Context, interval time.Duration) error {
+	start := time.Now()
+	watermark, copied, _, err := m.copyChanges(ctx, 0)
+	if err != nil {
+		return err
+	}
+	clog.Infof("Copied %d keys in %s, catching up from version %d", copied, time.Since(start).Round(time.Millisecond), watermark)
+
+	since, idle := watermark, false
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			// 退出之前再复制一次，源服务进程停止写入之后目标服务进程和它完全一致
+			_, _, _, err := m.copyChanges(context.Background(), since)
+			return err
+		}
+
+		// 被取消的复制只完成了一部分，水位线不能前移，退出之前的最后一次复制会从原来的位置重新开始
+		watermark, copied, deleted, err := m.copyChanges(ctx, since)
+		if err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			return err
+		}
+		since = watermark
+
+		if copied == 0 && deleted == 0 {
+			if !idle {
+				clog.Infof("Target caught up at version %d", since)
+			}
+			idle = true
+			continue
+		}
+		idle = false
+		clog.Infof("Copied %d changes and %d deletions up to version %d", copied, deleted, since)
+	}
+}
+
+// copyChanges 把版本号大于 since 的 Key 写入目标服务进程，再删除源数据目录中已经不存在的 Key，返回新的水位线
+func (m *migration) copyChanges(ctx context.Context, since uint64) (uint64, int, int, error) {
+	keys, watermark, err := m.src.ChangedKeys(ctx, since)
+	if err != nil {
+		return since, 0, 0, err
+	}
+
+	var (
+		cmds   []command
+		size   int
+		copied int
+	)
+	flush := func() error {
+		if len(cmds) == 0 {
+			return nil
+		}
+		err := m.send(ctx, cmds, size)
+		cmds, size = cmds[:0], 0
+		return err
+	}
+
+	now := uint64(time.Now().Unix())
+	for _, key := range keys {
+		seg, err := m.src.FetchSegmentContext(ctx, m.src.InodeNum(key))
+		if errors.Is(err, vfs.ErrSegmentNotFound) {
+			continue
+		}
+		if err != nil {
+			return since, copied, 0, err
+		}
+		var ttl uint64
+		if seg.ExpiredAt > 0 {
+			if seg.ExpiredAt <= now {
+				continue
+			}
+			ttl = seg.ExpiredAt - now
+		}
+
+		value := base64.StdEncoding.EncodeToString(seg.Value)
+		if len(cmds) >= m.batch || (len(cmds) > 0 && size+len(value) > maxBatchBytes) {
+			if err := flush(); err != nil {
+				return since, copied, 0, err
+			}
+		}
+		cmds = append(cmds, command{Cmd: "set", Key: key, Value: value, TTL: ttl, Type: seg.Type.String(), Encoding: "base64"})
+		size += len(value)
+		m.copied[key] = struct{}{}
+		copied++
+	}
+
+	// 删除命令发送成功之后才不再记录这些 Key，失败时下一轮会重新删除
+	var removed []string
+	for key := range m.copied {
+		if _, ok := m.src.GetINode(m.src.InodeNum(key)); ok {
+			continue
+		}
+		if len(cmds) >= m.batch {
+			if err := flush(); err != nil {
+				return since, copied, 0, err
+			}
+		}
+		cmds = append(cmds, command{Cmd: "delete", Key: key})
+		removed = append(removed, key)
+	}
+
+	if err := flush(); err != nil {
+		return since, copied, 0, err
+	}
+	for _, key := range removed {
+		delete(m.copied, key)
+	}
+	return watermark, copied, len(removed), nil
+}
+
+// send 按照限速发送一批命令，任何一条命令失败都返回错误
+func (m *migration) send(ctx context.Context, cmds []command, size int) error {
+	if err := m.limit.wait(ctx, len(cmds), size); err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string][]command{"commands": cmds})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if m.user != "" {
+		req.Header.Set("user", m.user)
+	}
+	req.Header.Set("auth", m.auth)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send commands to target: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var out response
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return fmt.Errorf("failed to decode target response (status %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("target rejected commands (status %d): %s", resp.StatusCode, out.Message)
+	}
+	for i, r := range out.Result {
+		if r.Error != "" && i < len(cmds) {
+			return fmt.Errorf("failed to %s key %q on target: %s", cmds[i].Cmd, cmds[i].Key, r.Error)
+		}
+	}
+	return nil
+}
+
+// rateLimit 按照开始之后发送的总量限制平均速率，超过速率时等待到和限制一致
+type rateLimit struct {
+	bytesPerSec int64
+	opsPerSec   int64
+	start       time.Time
+	ops         int64
+	bytes       int64
+}
+
+func (l *rateLimit) wait(ctx context.Context, ops, bytes int) error {
+	if l.start.IsZero() {
+		l.start = time.Now()
+	}
+	l.ops += int64(ops)
+	l.bytes += int64(bytes)
+
+	var due time.Duration
+	if l.opsPerSec > 0 {
+		due = time.Duration(l.ops) * time.Second / time.Duration(l.opsPerSec)
+	}
+	if l.bytesPerSec > 0 {
+		if d := time.Duration(float64(l.bytes) / float64(l.bytesPerSec) * float64(time.Second)); d > due {
+			due = d
+		}
+	}
+
+	// 空闲期间积累的额度最多 1 秒，没有修改的一段时间之后也不会突然发送大量的命令
+	wait := time.Until(l.start.Add(due))
+	if wait < -time.Second {
+		l.start = l.start.Add(-wait - time.Second)
+	}
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2022 Leon Ding <ding@ibyte.me> https://wiredkv.github.io
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// wiredkv-migrate 将数据目录中的数据文件转换为指定的格式版本
+// 使用之前必须先停止 wiredkv 服务进程，例如：
+//
+//	wiredkv-migrate --path /tmp/wiredkv --version 2
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/auula/wiredkv/clog"
+	"github.com/auula/wiredkv/vfs"
+)
+
+func main() {
+	path := flag.String("path", "", "--path the data storage directory.")
+	version := flag.Uint("version", uint(vfs.FormatV4), "--version the target data file format version.")
+	removeBackup := flag.Bool("remove-backup", false, "--remove-backup remove the original data directory after migrated.")
+	flag.Parse()
+
+	if *path == "" {
+		clog.Failed("data directory path cannot be empty")
+	}
+	// 超过 255 的版本号转换为 uint8 之后会变成另一个合法的版本号
+	if *version < uint(vfs.FormatV1) || *version > uint(vfs.FormatV4) {
+		clog.Failed(fmt.Sprintf("unknown data file format version %d, supported versions are %d to %d", *version, vfs.FormatV1, vfs.FormatV4))
+	}
+
+	report, err := vfs.MigrateRegions(*path, uint8(*version))
+	if err != nil {
+		clog.Failed(err)
+	}
+
+	clog.Infof("Migrated %d regions with %d records to format version %d (checksum: %d)",
+		report.Regions, report.Records, *version, report.Checksum)
+
+	if *removeBackup {
+		err := os.RemoveAll(report.Backup)
+		if err != nil {
+			clog.Failed(err)
+		}
+		clog.Info("Original data directory removed")
+	} else {
+		clog.Infof("Original data directory backup at %s", report.Backup)
+	}
+}
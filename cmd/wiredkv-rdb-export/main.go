@@ -0,0 +1,104 @@
+// Copyright 2022 Leon Ding <ding@ibyte.me> https://wiredkv.github.io
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// wiredkv-rdb-export 把数据目录中的所有 Key 导出为 Redis 可以加载的 RDB 文件，过期时间同样会导出
+// 数据目录以只读模式打开，不需要停止 wiredkv 服务进程，导出的是打开时已经封存的数据文件中的数据
+// types 包还没有定义 Tables、List、Set 和 ZSet 的序列化格式，所有的值都按照存储的字节导出为 Redis 字符串，例如：
+//
+//	wiredkv-rdb-export --path /tmp/wiredkv --out dump.rdb
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/auula/wiredkv/clog"
+	"github.com/auula/wiredkv/rdb"
+	"github.com/auula/wiredkv/utils"
+	"github.com/auula/wiredkv/vfs"
+)
+
+func main() {
+	path := flag.String("path", "", "--path the data storage directory.")
+	out := flag.String("out", "", "--out the redis rdb file to write.")
+	db := flag.Int("db", 0, "--db the redis database to export keys into.")
+	threshold := flag.Uint("threshold", 3, "--threshold the region file size in GB.")
+	flag.Parse()
+
+	if *path == "" || *out == "" {
+		clog.Failed("data directory path and rdb file cannot be empty")
+	}
+
+	lfs, err := vfs.OpenFS(&vfs.Options{Path: *path, FsPerm: 0755, Threshold: uint8(*threshold), ReadOnly: true})
+	if err != nil {
+		clog.Failed(err)
+	}
+	defer lfs.CloseFS()
+
+	start := time.Now()
+	n, err := export(lfs, *out, *db)
+	if err != nil {
+		clog.Failed(err)
+	}
+	clog.Infof("Exported %d keys to %s in %s", n, *out, time.Since(start).Round(time.Millisecond))
+}
+
+// export 先写入临时文件，完整写入并且刷盘之后才替换目标文件，导出失败不会留下不完整的 RDB 文件
+func export(lfs *vfs.LogStructuredFS, out string, db int) (int, error) {
+	tmp := out + ".tmp"
+	fd, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp)
+
+	n, err := writeRDB(lfs, fd, db)
+	if err != nil {
+		_ = fd.Close()
+		return 0, err
+	}
+	if err := utils.CloseFile(fd); err != nil {
+		return 0, err
+	}
+	return n, os.Rename(tmp, out)
+}
+
+func writeRDB(lfs *vfs.LogStructuredFS, fd *os.File, db int) (int, error) {
+	wr, err := rdb.NewWriter(fd)
+	if err != nil {
+		return 0, err
+	}
+
+	it := lfs.NewIterator(context.Background(), "")
+	defer it.Close()
+
+	n := 0
+	for it.Next() {
+		seg := it.Segment()
+		entry := &rdb.Entry{DB: db, Key: []byte(it.Key()), Type: rdb.TypeString, Value: it.Value()}
+		if seg.ExpiredAt > 0 {
+			entry.ExpireAt = time.Unix(int64(seg.ExpiredAt), 0)
+		}
+		if err := wr.WriteEntry(entry); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := it.Err(); err != nil {
+		return n, err
+	}
+	return n, wr.Close()
+}
@@ -0,0 +1,142 @@
+// Copyright 2022 Leon Ding <ding@ibyte.me> https://wiredkv.github.io
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// wiredkv-rdb-import 把 Redis 的 RDB 文件导入到数据目录中，字符串保存为 Text 并且保留过期时间
+// types 包中的 Tables、List、Set 和 ZSet 还没有定义序列化格式，遇到 hash、list、set、zset、stream 和模块类型的 Key 时导入失败
+// Ingest 失败时不会留下已经写入的数据，确认只需要导入字符串之后使用 --skip-unsupported 跳过这些 Key 并且在结束时统计
+// 使用之前必须先停止 wiredkv 服务进程，例如：
+//
+//	wiredkv-rdb-import --path /tmp/wiredkv --rdb dump.rdb --db 0
+//	wiredkv-rdb-import --path /tmp/wiredkv --rdb dump.rdb --skip-unsupported
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/auula/wiredkv/clog"
+	"github.com/auula/wiredkv/rdb"
+	"github.com/auula/wiredkv/vfs"
+)
+
+func main() {
+	path := flag.String("path", "", "--path the data storage directory.")
+	file := flag.String("rdb", "", "--rdb the redis rdb file to import.")
+	db := flag.Int("db", -1, "--db only import keys in this redis database, -1 imports all databases.")
+	threshold := flag.Uint("threshold", 3, "--threshold the region file size in GB.")
+	skipUnsupported := flag.Bool("skip-unsupported", false, "--skip-unsupported skip keys whose type has no storage encoding instead of failing.")
+	flag.Parse()
+
+	if *path == "" || *file == "" {
+		clog.Failed("data directory path and rdb file cannot be empty")
+	}
+
+	fd, err := os.Open(*file)
+	if err != nil {
+		clog.Failed(err)
+	}
+	defer fd.Close()
+
+	rd, err := rdb.NewReader(bufio.NewReaderSize(fd, 1<<20))
+	if err != nil {
+		clog.Failed(err)
+	}
+
+	lfs, err := vfs.OpenFS(&vfs.Options{Path: *path, FsPerm: 0755, Threshold: uint8(*threshold)})
+	if err != nil {
+		clog.Failed(err)
+	}
+
+	it := &rdbIterator{rd: rd, db: *db, now: time.Now(), skipUnsupported: *skipUnsupported, skipped: make(map[rdb.Type]int)}
+	report, err := lfs.Ingest(it)
+	if cerr := lfs.CloseFS(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if err != nil {
+		clog.Failed(err)
+	}
+
+	clog.Infof("Imported %d keys (%d bytes) from rdb version %d in %s",
+		report.Records, report.Bytes, rd.Version(), report.Duration.Round(time.Millisecond))
+	if it.expired > 0 {
+		clog.Infof("Skipped %d keys that have already expired", it.expired)
+	}
+	for kind, n := range it.skipped {
+		clog.Warnf("Skipped %d %s keys: no storage encoding for this type yet", n, kind)
+	}
+}
+
+// rdbIterator 把 RDB 文件中的字符串转换为 Text 记录交给 Ingest 批量导入
+type rdbIterator struct {
+	rd              *rdb.Reader
+	db              int
+	now             time.Time
+	seg             *vfs.Segment
+	err             error
+	expired         int
+	skipUnsupported bool
+	skipped         map[rdb.Type]int
+}
+
+func (it *rdbIterator) Next() bool {
+	for it.err == nil {
+		entry, err := it.rd.Next()
+		if err == io.EOF {
+			return false
+		}
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if it.db >= 0 && entry.DB != it.db {
+			continue
+		}
+
+		// 剩余的过期时间向上取整到秒，不会比 Redis 中提前过期
+		var ttl uint64
+		if !entry.ExpireAt.IsZero() {
+			left := entry.ExpireAt.Sub(it.now)
+			if left <= 0 {
+				it.expired++
+				continue
+			}
+			ttl = uint64((left + time.Second - 1) / time.Second)
+		}
+
+		if entry.Type != rdb.TypeString {
+			if !it.skipUnsupported {
+				it.err = fmt.Errorf("key %q is a redis %s, which has no storage encoding yet, use --skip-unsupported to skip such keys", entry.Key, entry.Type)
+				return false
+			}
+			it.skipped[entry.Type]++
+			continue
+		}
+
+		it.seg, it.err = vfs.NewSegmentBytes(string(entry.Key), vfs.Text, entry.Value, ttl)
+		return it.err == nil
+	}
+	return false
+}
+
+func (it *rdbIterator) Segment() *vfs.Segment {
+	return it.seg
+}
+
+func (it *rdbIterator) Err() error {
+	return it.err
+}
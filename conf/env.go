@@ -0,0 +1,151 @@
+package conf
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix 是覆盖配置项的环境变量前缀
+// 环境变量的名称是配置项的名称转换为大写，嵌套的配置项用下划线连接，例如：
+//
+//	FLASCHE_PORT=2468
+//	FLASCHE_AUTH=password
+//	FLASCHE_REGION_SECOND=18000
+//	FLASCHE_TLS_CERTFILE=/etc/wiredkv/cert.pem
+//	FLASCHE_ALLOWIP=192.168.31.1,192.168.31.2
+//	FLASCHE_RENAMECOMMANDS=shutdown=shutdown-8f3a,compact=compact-8f3a
+//
+// 配置的优先级从低到高依次是：内置默认配置、--config 配置文件、FLASCHE_ 环境变量、命令行参数
+const EnvPrefix = "FLASCHE_"
+
+// 输出配置时隐藏敏感信息使用的字符串
+const redacted = "******"
+
+// ApplyEnv 使用 FLASCHE_ 开头的环境变量覆盖 opt 中对应的配置项
+func ApplyEnv(opt *ServerOptions) error {
+	return applyEnv(reflect.ValueOf(opt).Elem(), EnvPrefix, os.LookupEnv)
+}
+
+func applyEnv(v reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		key := prefix + strings.ToUpper(name)
+
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			err := applyEnv(field, key+"_", lookup)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok := lookup(key)
+		if !ok {
+			continue
+		}
+
+		err := setField(field, value)
+		if err != nil {
+			return fmt.Errorf("failed to parse environment variable %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported config type %s", field.Type())
+		}
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	case reflect.Map:
+		if field.Type().Key().Kind() != reflect.String || field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported config type %s", field.Type())
+		}
+		m := make(map[string]string)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			k, v, ok := strings.Cut(item, "=")
+			if !ok {
+				return fmt.Errorf("invalid map item %q, expected key=value", item)
+			}
+			m[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+		field.Set(reflect.ValueOf(m))
+	default:
+		return fmt.Errorf("unsupported config type %s", field.Type())
+	}
+	return nil
+}
+
+// Redacted 返回隐藏了密码和加密密钥的配置副本，用于输出到日志
+func (opt *ServerOptions) Redacted() *ServerOptions {
+	copied := *opt
+	if copied.Password != "" {
+		copied.Password = redacted
+	}
+	if copied.Encryptor.Secret != "" {
+		copied.Encryptor.Secret = redacted
+	}
+	if copied.Backup.Encryptor.Secret != "" {
+		copied.Backup.Encryptor.Secret = redacted
+	}
+	if copied.Backup.S3.SecretKey != "" {
+		copied.Backup.S3.SecretKey = redacted
+	}
+	copied.AllowIP = append([]string(nil), opt.AllowIP...)
+	copied.AllowCommands = append([]string(nil), opt.AllowCommands...)
+	copied.DenyCommands = append([]string(nil), opt.DenyCommands...)
+	// 重命名之后的名字只有运维知道，和密码一样不输出
+	if opt.RenameCommands != nil {
+		copied.RenameCommands = make(map[string]string, len(opt.RenameCommands))
+		for name := range opt.RenameCommands {
+			copied.RenameCommands[name] = redacted
+		}
+	}
+	return &copied
+}
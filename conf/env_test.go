@@ -0,0 +1,72 @@
+package conf
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyEnv(t *testing.T) {
+	t.Setenv("FLASCHE_PORT", "8080")
+	t.Setenv("FLASCHE_AUTH", "env-password")
+	t.Setenv("FLASCHE_DEBUG", "true")
+	t.Setenv("FLASCHE_REGION_THRESHOLD", "5")
+	t.Setenv("FLASCHE_REGION_GCRATIO", "0.25")
+	t.Setenv("FLASCHE_TLS_CERTFILE", "/etc/wiredkv/cert.pem")
+	t.Setenv("FLASCHE_ALLOWIP", "192.168.31.1, 192.168.31.2")
+	t.Setenv("FLASCHE_RENAMECOMMANDS", "shutdown=shutdown-8f3a, compact = compact-8f3a")
+
+	opt := new(ServerOptions)
+	err := opt.Unmarshal([]byte(DefaultConfigJSON))
+	if err != nil {
+		t.Fatalf("Error unmarshal default config: %v", err)
+	}
+
+	err = ApplyEnv(opt)
+	if err != nil {
+		t.Fatalf("Error applying environment variables: %v", err)
+	}
+
+	if opt.Port != 8080 || opt.Password != "env-password" || !opt.Debug {
+		t.Errorf("Expected environment overrides, got %+v", opt)
+	}
+	if opt.Region.Threshold != 5 || opt.Region.GCRatio != 0.25 || opt.Region.Second != Default.Region.Second {
+		t.Errorf("Expected nested region overrides, got %+v", opt.Region)
+	}
+	if opt.TLS.CertFile != "/etc/wiredkv/cert.pem" {
+		t.Errorf("Expected tls certfile override, got %q", opt.TLS.CertFile)
+	}
+	if !reflect.DeepEqual(opt.AllowIP, []string{"192.168.31.1", "192.168.31.2"}) {
+		t.Errorf("Expected allowip override, got %v", opt.AllowIP)
+	}
+	if !reflect.DeepEqual(opt.RenameCommands, map[string]string{"shutdown": "shutdown-8f3a", "compact": "compact-8f3a"}) {
+		t.Errorf("Expected renamecommands override, got %v", opt.RenameCommands)
+	}
+}
+
+func TestApplyEnv_Error(t *testing.T) {
+	t.Setenv("FLASCHE_PORT", "not-a-number")
+
+	err := ApplyEnv(new(ServerOptions))
+	if err == nil || !strings.Contains(err.Error(), "FLASCHE_PORT") {
+		t.Errorf("Expected parse error for FLASCHE_PORT, got %v", err)
+	}
+}
+
+func TestServerOptions_Redacted(t *testing.T) {
+	opt := &ServerOptions{
+		Password:       "password@123",
+		Encryptor:      Encryptor{Secret: "test-secret"},
+		Backup:         Backup{S3: S3{SecretKey: "s3-secret"}, Encryptor: Encryptor{Secret: "backup-secret"}},
+		RenameCommands: map[string]string{"shutdown": "shutdown-8f3a"},
+	}
+
+	s := opt.Redacted().String()
+	if strings.Contains(s, "password@123") || strings.Contains(s, "test-secret") || strings.Contains(s, "s3-secret") ||
+		strings.Contains(s, "backup-secret") || strings.Contains(s, "shutdown-8f3a") {
+		t.Errorf("Expected secrets to be redacted, got %s", s)
+	}
+	if opt.Password != "password@123" || opt.RenameCommands["shutdown"] != "shutdown-8f3a" {
+		t.Error("Expected original config to be unchanged")
+	}
+}
@@ -7,8 +7,10 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
 	"gopkg.in/yaml.v2"
 )
@@ -19,6 +21,8 @@ const (
 	defaultFilePath = ""
 	// 设置默认文件系统权限
 	FsPerm = fs.FileMode(0755)
+	// 优雅退出默认等待正在处理的请求的时间
+	defaultGracePeriod = 10 * time.Second
 	// DefaultConfigJSON configure json string
 	DefaultConfigJSON = `
 	{
@@ -106,11 +110,40 @@ func validatePassword(password string) error {
 	return nil
 }
 
+type TLSValidator struct{}
+
+func (TLSValidator) Validate(opt *ServerOptions) error {
+	if (opt.TLS.CertFile == "") != (opt.TLS.KeyFile == "") {
+		return errors.New("tls certfile and keyfile must be set together")
+	}
+	return nil
+}
+
+type BackupValidator struct{}
+
+func (BackupValidator) Validate(opt *ServerOptions) error {
+	if opt.Backup.Dir != "" && opt.Backup.S3.Bucket != "" {
+		return errors.New("backup dir and s3 bucket cannot be set together")
+	}
+	if opt.Backup.Keep < 0 || opt.Backup.MaxAge < 0 {
+		return errors.New("backup keep and maxage cannot be negative")
+	}
+	if opt.Backup.Schedule != "" && !opt.IsBackupEnabled() {
+		return errors.New("backup schedule requires a backup dir or s3 bucket")
+	}
+	if opt.Backup.Encryptor.Enable && !opt.IsBackupEnabled() {
+		return errors.New("backup encryptor requires a backup dir or s3 bucket")
+	}
+	return nil
+}
+
 func Vaildated(opt *ServerOptions) error {
 	validators := []Validator{
 		PortValidator{},
 		PathValidator{},
 		AuthValidator{},
+		TLSValidator{},
+		BackupValidator{},
 	}
 
 	for _, validator := range validators {
@@ -124,14 +157,21 @@ func Vaildated(opt *ServerOptions) error {
 }
 
 // Load through a configuration file
+// 根据文件扩展名选择 YAML、TOML 或者 JSON 格式，没有扩展名时按照 YAML 解析
+// 配置项的名称和 JSON 格式的字段名称相同，例如 auth、allowip 和 tls.certfile
 func Load(file string, opt *ServerOptions) error {
 	_, err := os.Stat(file)
 	if err != nil {
 		return err
 	}
 
+	format, err := configType(file)
+	if err != nil {
+		return err
+	}
+
 	v := viper.New()
-	v.SetConfigType(extension)
+	v.SetConfigType(format)
 	v.SetConfigFile(file)
 
 	err = v.ReadInConfig()
@@ -139,7 +179,23 @@ func Load(file string, opt *ServerOptions) error {
 		return err
 	}
 
-	return v.Unmarshal(opt)
+	return v.Unmarshal(opt, func(dc *mapstructure.DecoderConfig) {
+		dc.TagName = "json"
+	})
+}
+
+// configType 根据配置文件的扩展名返回配置文件的格式
+func configType(file string) (string, error) {
+	switch ext := strings.ToLower(filepath.Ext(file)); ext {
+	case "", ".yaml", ".yml":
+		return extension, nil
+	case ".toml":
+		return "toml", nil
+	case ".json":
+		return "json", nil
+	default:
+		return "", fmt.Errorf("unsupported config file format: %s", ext)
+	}
 }
 
 func saved(path string, opt *ServerOptions) error {
@@ -171,6 +227,10 @@ func (opt *ServerOptions) IsCompressionEnabled() bool {
 	return opt.Compressor.Enable
 }
 
+func (opt *ServerOptions) IsTLSEnabled() bool {
+	return opt.TLS.CertFile != "" && opt.TLS.KeyFile != ""
+}
+
 func (opt *ServerOptions) IsEncryptionEnabled() bool {
 	return opt.Encryptor.Enable
 }
@@ -183,12 +243,22 @@ func (opt *ServerOptions) RegionGCInterval() time.Duration {
 	return time.Duration(opt.Region.Second) * time.Second
 }
 
+// ShutdownGracePeriod 返回优雅退出时等待正在处理的请求完成的最长时间
+func (opt *ServerOptions) ShutdownGracePeriod() time.Duration {
+	if opt.GracePeriod <= 0 {
+		return defaultGracePeriod
+	}
+	return time.Duration(opt.GracePeriod) * time.Second
+}
+
 func toString(opt *ServerOptions) string {
 	bs, _ := opt.Marshal()
 	return string(bs)
 }
 
 type ServerOptions struct {
+	// Host HTTP 服务监听的地址，空表示监听本机的 IPv4 地址
+	Host       string     `json:"host,omitempty"`
 	Port       int        `json:"port"`
 	Path       string     `json:"path"`
 	Debug      bool       `json:"debug"`
@@ -198,19 +268,152 @@ type ServerOptions struct {
 	Encryptor  Encryptor  `json:"encryptor"`
 	Compressor Compressor `json:"compressor"`
 	AllowIP    []string   `json:"allowip"`
+	TLS        TLS        `json:"tls"`
+	// AuditLog 审计日志文件的路径，空表示不开启
+	AuditLog string `json:"auditlog,omitempty"`
+	// AdminAuditLog 管理命令审计日志文件的路径，和数据的审计日志分开保存，空表示不开启
+	AdminAuditLog string `json:"adminauditlog,omitempty"`
+	// AllowCommands 不为空时只允许执行其中的命令，DenyCommands 中的命令总是被禁用
+	// RenameCommands 把命令重命名为新名字，原来的名字不能再使用
+	AllowCommands  []string          `json:"allowcommands,omitempty"`
+	DenyCommands   []string          `json:"denycommands,omitempty"`
+	RenameCommands map[string]string `json:"renamecommands,omitempty"`
+	// ACLFile JSON 格式的访问控制文件，设置之后每个用户使用自己的密码认证并且按照 bucket 授权
+	ACLFile string `json:"aclfile,omitempty"`
+	// GracePeriod 收到退出信号之后等待正在处理的请求完成的秒数，0 表示使用默认的 10 秒
+	GracePeriod int64 `json:"graceperiod,omitempty"`
+	// Limits 限制连接数、读写超时和请求大小，防止慢速或者恶意的客户端耗尽文件描述符和内存
+	Limits Limits `json:"limits,omitempty"`
+	// Backup 设置备份位置之后可以通过 backup 管理命令备份数据，设置 Schedule 之后定期备份
+	Backup Backup `json:"backup,omitempty"`
+	// 下面的配置可以在运行时通过 SIGHUP 信号重新加载
+	// Sync 写入之后的刷盘策略，可选 never、always 和 interval，默认 never
+	Sync string `json:"sync,omitempty"`
+	// CacheMemory 数据记录缓存的最大字节数，0 表示不开启缓存
+	CacheMemory int64 `json:"cachememory,omitempty"`
+	// WriteBytesPerSec 和 WriteOpsPerSec 限制每秒写入的字节数和次数，0 表示不限制
+	WriteBytesPerSec int64 `json:"writebytespersec,omitempty"`
+	WriteOpsPerSec   int64 `json:"writeopspersec,omitempty"`
 }
 
 type Region struct {
 	Enable    bool  `json:"enable"`
 	Second    int64 `json:"second"`
 	Threshold uint8 `json:"threshold"`
+	// SegmentSize 单个数据文件的最大字节数，设置之后覆盖 Threshold
+	SegmentSize int64 `json:"segmentsize,omitempty"`
+	// MaxDiskBytes 数据文件最多占用的磁盘字节数，0 表示不限制
+	MaxDiskBytes int64 `json:"maxdiskbytes,omitempty"`
+	// Checksum 新数据文件的记录校验码算法，可选 crc32、crc32c 和 xxhash64，默认 crc32
+	Checksum string `json:"checksum,omitempty"`
+	// Verify 启动时校验所有数据文件中的记录，截断最后一个数据文件末尾没有完整写入的记录
+	Verify bool `json:"verify,omitempty"`
+	// GCRatio 只回收垃圾数据比例不低于它的数据文件，0 表示有垃圾数据就回收
+	GCRatio float64 `json:"gcratio,omitempty"`
+}
+
+// Limits 中的超时时间单位是秒，所有的 0 都表示使用 HTTP 服务器的默认值
+type Limits struct {
+	MaxConnections int   `json:"maxconnections,omitempty"`
+	ReadTimeout    int64 `json:"readtimeout,omitempty"`
+	WriteTimeout   int64 `json:"writetimeout,omitempty"`
+	IdleTimeout    int64 `json:"idletimeout,omitempty"`
+	MaxHeaderBytes int   `json:"maxheaderbytes,omitempty"`
+	MaxBodyBytes   int64 `json:"maxbodybytes,omitempty"`
+	// KeepAlive 是 TCP keep-alive 探测的间隔秒数，小于 0 表示关闭
+	KeepAlive int64 `json:"keepalive,omitempty"`
+	// ReapInterval 定期回收空闲连接的间隔秒数，小于 0 表示不定期回收
+	ReapInterval int64 `json:"reapinterval,omitempty"`
+}
+
+// Backup 的备份位置是本地目录或者 S3 兼容的对象存储，只能设置一个
+type Backup struct {
+	Dir string `json:"dir,omitempty"`
+	// Schedule 定期备份的 cron 表达式，例如 0 3 * * * 或者 @every 6h，空表示只通过管理命令备份
+	Schedule string `json:"schedule,omitempty"`
+	// Keep 定期备份之后最多保留的备份个数，MaxAge 备份最长保留的秒数，0 表示不限制
+	Keep   int   `json:"keep,omitempty"`
+	MaxAge int64 `json:"maxage,omitempty"`
+	// Incremental 按照数据文件增量备份，只上传上一次备份的清单中没有的数据文件
+	Incremental bool `json:"incremental,omitempty"`
+	S3          S3   `json:"s3,omitempty"`
+	// Encryptor 开启之后备份文件使用独立的备份密钥加密，备份密钥不能和静态加密的密钥相同
+	Encryptor Encryptor `json:"encryptor,omitempty"`
+}
+
+// S3 的 AccessKey 和 SecretKey 为空时使用 AWS_ACCESS_KEY_ID 和 AWS_SECRET_ACCESS_KEY 环境变量
+type S3 struct {
+	Endpoint  string `json:"endpoint,omitempty"`
+	Region    string `json:"region,omitempty"`
+	Bucket    string `json:"bucket,omitempty"`
+	Prefix    string `json:"prefix,omitempty"`
+	AccessKey string `json:"accesskey,omitempty"`
+	SecretKey string `json:"secretkey,omitempty"`
+	// VirtualHosted 使用 bucket.endpoint 形式的地址，默认使用 endpoint/bucket 形式的地址
+	VirtualHosted bool `json:"virtualhosted,omitempty"`
+	// SSE 服务端加密方式，可选 AES256 和 aws:kms，KMSKeyID 是 aws:kms 使用的密钥
+	SSE      string `json:"sse,omitempty"`
+	KMSKeyID string `json:"kmskeyid,omitempty"`
+	// PartSize 分片上传时每个分片的字节数，0 表示 16MB
+	PartSize int64 `json:"partsize,omitempty"`
+}
+
+// IsBackupEnabled 返回是否设置了备份位置
+func (opt *ServerOptions) IsBackupEnabled() bool {
+	return opt.Backup.Dir != "" || opt.Backup.S3.Bucket != ""
 }
 
+// BackupMaxAge 返回备份最长保留的时间，0 表示不按照时间删除
+func (opt *ServerOptions) BackupMaxAge() time.Duration {
+	return time.Duration(opt.Backup.MaxAge) * time.Second
+}
+
+// TLS 同时设置证书和私钥文件之后使用 HTTPS 协议提供服务
+type TLS struct {
+	CertFile string `json:"certfile,omitempty"`
+	KeyFile  string `json:"keyfile,omitempty"`
+}
+
+// Encryptor 的密钥可以直接写在配置文件中，也可以从环境变量或者文件中读取
+// 优先级从高到低依次是 SecretEnv、SecretFile 和 Secret
 type Encryptor struct {
 	Enable bool   `json:"enable"`
 	Secret string `json:"secret"`
+	// SecretEnv 保存密钥的环境变量名称
+	SecretEnv string `json:"secretenv,omitempty"`
+	// SecretFile 保存密钥的文件路径，文件末尾的换行符会被去掉
+	SecretFile string `json:"secretfile,omitempty"`
+}
+
+// LoadSecret 按照优先级返回加密使用的密钥
+func (e *Encryptor) LoadSecret() (string, error) {
+	if e.SecretEnv != "" {
+		secret, ok := os.LookupEnv(e.SecretEnv)
+		if !ok || secret == "" {
+			return "", fmt.Errorf("encryptor secret environment variable %s is empty", e.SecretEnv)
+		}
+		return secret, nil
+	}
+
+	if e.SecretFile != "" {
+		data, err := os.ReadFile(e.SecretFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read encryptor secret file: %w", err)
+		}
+		secret := strings.TrimRight(string(data), "\r\n")
+		if secret == "" {
+			return "", fmt.Errorf("encryptor secret file %s is empty", e.SecretFile)
+		}
+		return secret, nil
+	}
+
+	return e.Secret, nil
 }
 
 type Compressor struct {
 	Enable bool `json:"enable"`
+	// Codec 不使用自适应压缩时的压缩算法，可选 snappy 和 flate，默认 snappy
+	Codec string `json:"codec,omitempty"`
+	// Adaptive 每条记录按照可压缩程度选择不压缩、Snappy 或者压缩率更高的算法
+	Adaptive bool `json:"adaptive,omitempty"`
 }
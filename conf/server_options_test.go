@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestConfigLoad(t *testing.T) {
@@ -275,3 +276,106 @@ func TestServerOptions_ToString(t *testing.T) {
 		})
 	}
 }
+
+func TestServerOptions_ShutdownGracePeriod(t *testing.T) {
+	opt := ServerOptions{}
+	if opt.ShutdownGracePeriod() != 10*time.Second {
+		t.Errorf("Expected default grace period to be 10s, but got %s", opt.ShutdownGracePeriod())
+	}
+
+	opt.GracePeriod = 30
+	if opt.ShutdownGracePeriod() != 30*time.Second {
+		t.Errorf("Expected grace period to be 30s, but got %s", opt.ShutdownGracePeriod())
+	}
+}
+
+func TestConfigLoad_TOML(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.toml")
+	testConfigData := []byte(`
+port = 8080
+path = "/test/path"
+auth = "password@123"
+allowip = ["192.168.31.1"]
+
+[tls]
+certfile = "/etc/wiredkv/cert.pem"
+keyfile = "/etc/wiredkv/key.pem"
+
+[region]
+enable = true
+second = 60
+gcratio = 0.5
+
+[encryptor]
+enable = true
+secretenv = "WIREDKV_TEST_SECRET"
+
+[compressor]
+enable = true
+codec = "flate"
+`)
+
+	err := os.WriteFile(configFile, testConfigData, 0644)
+	if err != nil {
+		t.Fatalf("Error writing test config file: %v", err)
+	}
+
+	loadedConfig := new(ServerOptions)
+	err = Load(configFile, loadedConfig)
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+
+	expectedConfig := &ServerOptions{
+		Port:       8080,
+		Path:       "/test/path",
+		Password:   "password@123",
+		AllowIP:    []string{"192.168.31.1"},
+		TLS:        TLS{CertFile: "/etc/wiredkv/cert.pem", KeyFile: "/etc/wiredkv/key.pem"},
+		Region:     Region{Enable: true, Second: 60, GCRatio: 0.5},
+		Encryptor:  Encryptor{Enable: true, SecretEnv: "WIREDKV_TEST_SECRET"},
+		Compressor: Compressor{Enable: true, Codec: "flate"},
+	}
+
+	if !reflect.DeepEqual(loadedConfig, expectedConfig) {
+		t.Errorf("Loaded config is not as expected.\nGot: %+v\nExpected: %+v", loadedConfig, expectedConfig)
+	}
+
+	if !loadedConfig.IsTLSEnabled() {
+		t.Error("Expected TLS to be enabled")
+	}
+}
+
+func TestConfigLoad_UnsupportedFormat(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.ini")
+	err := os.WriteFile(configFile, []byte("port=8080"), 0644)
+	if err != nil {
+		t.Fatalf("Error writing test config file: %v", err)
+	}
+
+	err = Load(configFile, new(ServerOptions))
+	if err == nil {
+		t.Error("Expected unsupported config format error")
+	}
+}
+
+func TestEncryptor_LoadSecret(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	err := os.WriteFile(secretFile, []byte("file-secret\n"), 0600)
+	if err != nil {
+		t.Fatalf("Error writing secret file: %v", err)
+	}
+
+	e := Encryptor{Secret: "inline-secret", SecretFile: secretFile}
+	secret, err := e.LoadSecret()
+	if err != nil || secret != "file-secret" {
+		t.Errorf("Expected secret from file, got %q, %v", secret, err)
+	}
+
+	t.Setenv("WIREDKV_TEST_SECRET", "env-secret")
+	e.SecretEnv = "WIREDKV_TEST_SECRET"
+	secret, err = e.LoadSecret()
+	if err != nil || secret != "env-secret" {
+		t.Errorf("Expected secret from environment, got %q, %v", secret, err)
+	}
+}
@@ -0,0 +1,26 @@
+package rdb
+
+// crc64 是 Redis 使用的 CRC-64/Jones 校验，反射多项式 0x95AC9329AC4BC9B5，初始值和结果都不取反
+// 标准库 hash/crc64 的实现会在开始和结束时取反，计算的结果和 Redis 不一致
+var crc64Table = func() [256]uint64 {
+	var table [256]uint64
+	for i := range table {
+		crc := uint64(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 == 1 {
+				crc = crc>>1 ^ 0x95AC9329AC4BC9B5
+			} else {
+				crc >>= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+func crc64(crc uint64, p []byte) uint64 {
+	for _, b := range p {
+		crc = crc64Table[byte(crc)^b] ^ crc>>8
+	}
+	return crc
+}
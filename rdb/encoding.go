@@ -0,0 +1,319 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var errTruncated = errors.New("truncated encoded value")
+
+// parseZiplist 解析 ziplist，布局为 | ZLBYTES 4 | ZLTAIL 4 | ZLLEN 2 | ENTRY ... | 0xFF |
+// 每个 ENTRY 是 | PREVLEN 1 或者 5 | ENCODING | DATA |，整数编码的元素转换为十进制文本
+func parseZiplist(b []byte) ([][]byte, error) {
+	if len(b) < 11 {
+		return nil, errTruncated
+	}
+	var values [][]byte
+	pos := 10
+	for {
+		if pos >= len(b) {
+			return nil, errTruncated
+		}
+		if b[pos] == 0xFF {
+			return values, nil
+		}
+
+		// 前一个元素的长度，254 表示之后 4 个字节是长度
+		if b[pos] == 254 {
+			pos += 5
+		} else {
+			pos++
+		}
+		if pos >= len(b) {
+			return nil, errTruncated
+		}
+
+		enc := b[pos]
+		var (
+			n     int
+			value []byte
+		)
+		switch enc >> 6 {
+		case 0:
+			n, pos = int(enc&0x3f), pos+1
+		case 1:
+			if pos+2 > len(b) {
+				return nil, errTruncated
+			}
+			n, pos = int(enc&0x3f)<<8|int(b[pos+1]), pos+2
+		case 2:
+			if pos+5 > len(b) {
+				return nil, errTruncated
+			}
+			n, pos = int(binary.BigEndian.Uint32(b[pos+1:])), pos+5
+		default:
+			var v int64
+			var size int
+			switch enc {
+			case 0xC0:
+				size = 2
+			case 0xD0:
+				size = 4
+			case 0xE0:
+				size = 8
+			case 0xF0:
+				size = 3
+			case 0xFE:
+				size = 1
+			default:
+				// 1111xxxx 直接保存 0 到 12
+				if enc < 0xF1 || enc > 0xFD {
+					return nil, fmt.Errorf("invalid ziplist encoding %#x", enc)
+				}
+				v = int64(enc&0x0f) - 1
+			}
+			pos++
+			if pos+size > len(b) {
+				return nil, errTruncated
+			}
+			if size > 0 {
+				v = littleEndianInt(b[pos : pos+size])
+			}
+			pos += size
+			values = append(values, strconv.AppendInt(nil, v, 10))
+			continue
+		}
+
+		if n < 0 || pos+n > len(b) {
+			return nil, errTruncated
+		}
+		value = append([]byte(nil), b[pos:pos+n]...)
+		pos += n
+		values = append(values, value)
+	}
+}
+
+// parseListpack 解析 listpack，布局为 | TOTAL 4 | NUM 2 | ENTRY ... | 0xFF |
+// 每个 ENTRY 是 | ENCODING | DATA | BACKLEN |，BACKLEN 是 ENCODING 和 DATA 的长度，占用 1 到 5 个字节
+func parseListpack(b []byte) ([][]byte, error) {
+	if len(b) < 7 {
+		return nil, errTruncated
+	}
+	var values [][]byte
+	pos := 6
+	for {
+		if pos >= len(b) {
+			return nil, errTruncated
+		}
+		enc := b[pos]
+		if enc == 0xFF {
+			return values, nil
+		}
+
+		var (
+			header, n int
+			value     []byte
+			integer   bool
+			v         int64
+		)
+		switch {
+		case enc&0x80 == 0:
+			header, integer, v = 1, true, int64(enc&0x7f)
+		case enc&0xC0 == 0x80:
+			header, n = 1, int(enc&0x3f)
+		case enc&0xE0 == 0xC0:
+			if pos+2 > len(b) {
+				return nil, errTruncated
+			}
+			header, integer = 2, true
+			v = signExtend(uint64(enc&0x1f)<<8|uint64(b[pos+1]), 13)
+		case enc&0xF0 == 0xE0:
+			if pos+2 > len(b) {
+				return nil, errTruncated
+			}
+			header, n = 2, int(enc&0x0f)<<8|int(b[pos+1])
+		case enc == 0xF0:
+			if pos+5 > len(b) {
+				return nil, errTruncated
+			}
+			header, n = 5, int(binary.LittleEndian.Uint32(b[pos+1:]))
+		case enc >= 0xF1 && enc <= 0xF4:
+			size := [...]int{2, 3, 4, 8}[enc-0xF1]
+			if pos+1+size > len(b) {
+				return nil, errTruncated
+			}
+			header, integer = 1+size, true
+			v = littleEndianInt(b[pos+1 : pos+1+size])
+		default:
+			return nil, fmt.Errorf("invalid listpack encoding %#x", enc)
+		}
+
+		if integer {
+			value = strconv.AppendInt(nil, v, 10)
+		} else {
+			if n < 0 || pos+header+n > len(b) {
+				return nil, errTruncated
+			}
+			value = append([]byte(nil), b[pos+header:pos+header+n]...)
+		}
+		size := header + n
+		pos += size + backlenSize(size)
+		values = append(values, value)
+	}
+}
+
+// backlenSize 返回 listpack 中保存元素长度 size 的 BACKLEN 占用的字节数，每个字节保存 7 位
+func backlenSize(size int) int {
+	switch {
+	case size <= 127:
+		return 1
+	case size < 16383:
+		return 2
+	case size < 2097151:
+		return 3
+	case size < 268435455:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// parseIntset 解析 intset，布局为 | ENCODING 4 | LENGTH 4 | CONTENTS |，ENCODING 是每个整数的字节数
+func parseIntset(b []byte) ([][]byte, error) {
+	if len(b) < 8 {
+		return nil, errTruncated
+	}
+	size := int(binary.LittleEndian.Uint32(b))
+	if size != 2 && size != 4 && size != 8 {
+		return nil, fmt.Errorf("invalid intset encoding %d", size)
+	}
+	n := int(binary.LittleEndian.Uint32(b[4:]))
+	if n < 0 || 8+n*size > len(b) {
+		return nil, errTruncated
+	}
+	values := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		pos := 8 + i*size
+		values = append(values, strconv.AppendInt(nil, littleEndianInt(b[pos:pos+size]), 10))
+	}
+	return values, nil
+}
+
+// parseZipmap 解析 Redis 2.6 之前的 zipmap，布局为 | ZMLEN 1 | LEN KEY LEN FREE VALUE ... | 0xFF |
+// 长度小于 254 时占用 1 个字节，254 表示之后 4 个字节是长度，返回交替排列的字段和值
+func parseZipmap(b []byte) ([][]byte, error) {
+	if len(b) < 2 {
+		return nil, errTruncated
+	}
+	var values [][]byte
+	pos := 1
+	readLen := func() (int, bool, error) {
+		if pos >= len(b) {
+			return 0, false, errTruncated
+		}
+		switch c := b[pos]; c {
+		case 0xFF:
+			return 0, true, nil
+		case 254:
+			if pos+5 > len(b) {
+				return 0, false, errTruncated
+			}
+			n := int(binary.LittleEndian.Uint32(b[pos+1:]))
+			pos += 5
+			return n, false, nil
+		default:
+			pos++
+			return int(c), false, nil
+		}
+	}
+
+	for {
+		n, end, err := readLen()
+		if err != nil {
+			return nil, err
+		}
+		if end {
+			return values, nil
+		}
+		if n < 0 || pos+n > len(b) {
+			return nil, errTruncated
+		}
+		values = append(values, append([]byte(nil), b[pos:pos+n]...))
+		pos += n
+
+		n, end, err = readLen()
+		if err != nil {
+			return nil, err
+		}
+		if end || pos >= len(b) {
+			return nil, errTruncated
+		}
+		free := int(b[pos])
+		pos++
+		if pos+n+free > len(b) {
+			return nil, errTruncated
+		}
+		values = append(values, append([]byte(nil), b[pos:pos+n]...))
+		pos += n + free
+	}
+}
+
+// lzfDecompress 解压 LZF 压缩的字符串，控制字节小于 32 时之后是字面量，否则是对已经解压的数据的引用
+func lzfDecompress(in []byte, size int) ([]byte, error) {
+	out := make([]byte, 0, size)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 {
+			n := ctrl + 1
+			if i+n > len(in) || len(out)+n > size {
+				return nil, errors.New("invalid lzf data")
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return nil, errors.New("invalid lzf data")
+			}
+			n += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errors.New("invalid lzf data")
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		n += 2
+		if ref < 0 || len(out)+n > size {
+			return nil, errors.New("invalid lzf data")
+		}
+		// 引用的范围可以和正在写入的数据重叠，只能逐个字节复制
+		for j := 0; j < n; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != size {
+		return nil, errors.New("invalid lzf data")
+	}
+	return out, nil
+}
+
+// littleEndianInt 把 1 到 8 个字节的小端补码转换为有符号整数
+func littleEndianInt(b []byte) int64 {
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return signExtend(v, uint(len(b)*8))
+}
+
+func signExtend(v uint64, bits uint) int64 {
+	shift := 64 - bits
+	return int64(v<<shift) >> shift
+}
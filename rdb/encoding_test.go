@@ -0,0 +1,89 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func expectStrings(t *testing.T, name string, got [][]byte, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("expected %s %q, got %q", name, want, got)
+	}
+	for i := range want {
+		if string(got[i]) != want[i] {
+			t.Fatalf("expected %s %q, got %q", name, want, got)
+		}
+	}
+}
+
+func TestParseListpackIntegers(t *testing.T) {
+	long := bytes.Repeat([]byte("x"), 100)
+	var body []byte
+	// 13 位有符号整数 -5
+	body = append(body, 0xDF, 0xFB, 2)
+	// int16、int24、int32 和 int64
+	body = append(body, 0xF1, 0xE8, 0x03, 3)
+	body = append(body, 0xF2, 0x00, 0x00, 0x80, 4)
+	body = append(body, 0xF3, 0xFF, 0xFF, 0xFF, 0xFF, 5)
+	body = append(body, 0xF4, 1, 0, 0, 0, 0, 0, 0, 0x80, 9)
+	// 12 位长度的字符串，BACKLEN 占用 1 个字节
+	body = append(body, 0xE0, byte(len(long)))
+	body = append(body, long...)
+	body = append(body, byte(len(long)+2))
+	body = append(body, 0xFF)
+
+	b := make([]byte, 6)
+	binary.LittleEndian.PutUint32(b, uint32(6+len(body)))
+	binary.LittleEndian.PutUint16(b[4:], 6)
+	values, err := parseListpack(append(b, body...))
+	if err != nil {
+		t.Fatalf("failed to parse listpack: %v", err)
+	}
+	expectStrings(t, "listpack", values, "-5", "1000", "-8388608", "-1", "-9223372036854775807", string(long))
+
+	if _, err := parseListpack(b); err == nil {
+		t.Errorf("expected error for truncated listpack")
+	}
+}
+
+func TestParseZiplistIntegers(t *testing.T) {
+	body := []byte{
+		0, 0xF1, // 立即数 0
+		2, 0xFD, // 立即数 12
+		2, 0xFE, 0x80, // int8 -128
+		3, 0xF0, 0xFF, 0xFF, 0x7F, // int24 8388607
+		5, 0xD0, 0x00, 0x00, 0x00, 0x80, // int32 -2147483648
+		6, 0xE0, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, // int64 -1
+		0xFF,
+	}
+	b := make([]byte, 10)
+	binary.LittleEndian.PutUint32(b, uint32(10+len(body)))
+	values, err := parseZiplist(append(b, body...))
+	if err != nil {
+		t.Fatalf("failed to parse ziplist: %v", err)
+	}
+	expectStrings(t, "ziplist", values, "0", "12", "-128", "8388607", "-2147483648", "-1")
+
+	if _, err := parseZiplist(append(b, 0, 5, 'a')); err == nil {
+		t.Errorf("expected error for truncated ziplist")
+	}
+}
+
+func TestLZFDecompress(t *testing.T) {
+	// 字面量 a，之后 8 个字节引用前一个字节，引用范围和写入的数据重叠
+	out, err := lzfDecompress([]byte{0x00, 'a', 0xC0, 0x00}, 9)
+	if err != nil || string(out) != "aaaaaaaaa" {
+		t.Fatalf("expected aaaaaaaaa, got %q %v", out, err)
+	}
+
+	for _, in := range [][]byte{{0x05, 'a'}, {0x20, 0x05}, {0x00, 'a', 0x20, 0x05}} {
+		if _, err := lzfDecompress(in, 9); err == nil {
+			t.Errorf("expected error for invalid lzf data %v", in)
+		}
+	}
+	if _, err := lzfDecompress([]byte{0x00, 'a'}, 2); err == nil {
+		t.Errorf("expected error for short lzf data")
+	}
+}
@@ -0,0 +1,764 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrInvalidFile = errors.New("invalid rdb file")
+	ErrChecksum    = errors.New("rdb checksum mismatch")
+)
+
+const (
+	// 支持的最高 RDB 版本，Redis 7.4 写入的是版本 12
+	maxVersion = 12
+	// Redis 中字符串的最大长度，超过这个长度的记录一定是损坏的文件，不按照它分配内存
+	maxStringLen = 512 << 20
+)
+
+// RDB 文件中的操作码
+const (
+	opSlotInfo      = 244
+	opFunction2     = 245
+	opFunctionPreGA = 246
+	opModuleAux     = 247
+	opIdle          = 248
+	opFreq          = 249
+	opAux           = 250
+	opResizeDB      = 251
+	opExpireTimeMs  = 252
+	opExpireTime    = 253
+	opSelectDB      = 254
+	opEOF           = 255
+)
+
+// RDB 文件中值的编码类型
+const (
+	rdbString           = 0
+	rdbList             = 1
+	rdbSet              = 2
+	rdbZSet             = 3
+	rdbHash             = 4
+	rdbZSet2            = 5
+	rdbModule           = 6
+	rdbModule2          = 7
+	rdbHashZipmap       = 9
+	rdbListZiplist      = 10
+	rdbSetIntset        = 11
+	rdbZSetZiplist      = 12
+	rdbHashZiplist      = 13
+	rdbListQuicklist    = 14
+	rdbStreamListpacks  = 15
+	rdbHashListpack     = 16
+	rdbZSetListpack     = 17
+	rdbListQuicklist2   = 18
+	rdbStreamListpacks2 = 19
+	rdbSetListpack      = 20
+	rdbStreamListpacks3 = 21
+)
+
+// quicklist 2 中节点的容器类型
+const (
+	quicklistPlain  = 1
+	quicklistPacked = 2
+)
+
+// Type 是 Redis 中的数据类型，同一种类型的不同编码解析之后是一样的
+type Type uint8
+
+const (
+	TypeString Type = iota
+	TypeList
+	TypeSet
+	TypeZSet
+	TypeHash
+	TypeStream // 只跳过，不解析其中的消息
+	TypeModule // 只跳过，不解析模块的数据
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeString:
+		return "string"
+	case TypeList:
+		return "list"
+	case TypeSet:
+		return "set"
+	case TypeZSet:
+		return "zset"
+	case TypeHash:
+		return "hash"
+	case TypeStream:
+		return "stream"
+	case TypeModule:
+		return "module"
+	default:
+		return "unknown"
+	}
+}
+
+// ZMember 是有序集合中的一个成员
+type ZMember struct {
+	Member []byte
+	Score  float64
+}
+
+// Entry 是 RDB 文件中的一个 Key，按照 Type 使用对应的字段
+type Entry struct {
+	DB       int
+	Key      []byte
+	Type     Type
+	ExpireAt time.Time         // 零值表示没有过期时间
+	Value    []byte            // TypeString
+	Values   [][]byte          // TypeList 和 TypeSet
+	Fields   map[string][]byte // TypeHash
+	Members  []ZMember         // TypeZSet
+}
+
+// Reader 按顺序读取 RDB 文件中的 Key，读到文件末尾时校验 CRC64 校验和
+type Reader struct {
+	r       *bufio.Reader
+	crc     uint64
+	version int
+	db      int
+	done    bool
+}
+
+// NewReader 读取并检查 RDB 文件头，支持 Redis 2.x 到 7.4 写入的版本 1 到 12
+func NewReader(r io.Reader) (*Reader, error) {
+	rd := &Reader{r: bufio.NewReader(r)}
+	header, err := rd.readFull(9)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rdb header: %w", err)
+	}
+	if string(header[:5]) != "REDIS" {
+		return nil, ErrInvalidFile
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil || version < 1 {
+		return nil, ErrInvalidFile
+	}
+	if version > maxVersion {
+		return nil, fmt.Errorf("unsupported rdb version %d", version)
+	}
+	rd.version = version
+	return rd, nil
+}
+
+// Version 返回 RDB 文件的版本
+func (rd *Reader) Version() int {
+	return rd.version
+}
+
+// Next 返回下一个 Key，读完所有的 Key 之后返回 io.EOF
+// Stream 和模块的值只返回 Key 和类型，Redis 4.0 RC 写入的旧格式模块数据无法跳过，遇到时返回错误
+func (rd *Reader) Next() (*Entry, error) {
+	if rd.done {
+		return nil, io.EOF
+	}
+
+	var expireAt time.Time
+	for {
+		op, err := rd.readByte()
+		if err != nil {
+			return nil, rd.unexpected(err)
+		}
+
+		switch op {
+		case opAux:
+			if _, err := rd.readString(); err != nil {
+				return nil, rd.unexpected(err)
+			}
+			if _, err := rd.readString(); err != nil {
+				return nil, rd.unexpected(err)
+			}
+		case opResizeDB:
+			if err := rd.skipLengths(2); err != nil {
+				return nil, rd.unexpected(err)
+			}
+		case opSlotInfo:
+			if err := rd.skipLengths(3); err != nil {
+				return nil, rd.unexpected(err)
+			}
+		case opExpireTimeMs:
+			b, err := rd.readFull(8)
+			if err != nil {
+				return nil, rd.unexpected(err)
+			}
+			expireAt = time.UnixMilli(int64(binary.LittleEndian.Uint64(b)))
+		case opExpireTime:
+			b, err := rd.readFull(4)
+			if err != nil {
+				return nil, rd.unexpected(err)
+			}
+			expireAt = time.Unix(int64(binary.LittleEndian.Uint32(b)), 0)
+		case opSelectDB:
+			db, err := rd.readLen()
+			if err != nil {
+				return nil, rd.unexpected(err)
+			}
+			rd.db = int(db)
+		case opIdle:
+			if err := rd.skipLengths(1); err != nil {
+				return nil, rd.unexpected(err)
+			}
+		case opFreq:
+			if _, err := rd.readByte(); err != nil {
+				return nil, rd.unexpected(err)
+			}
+		case opFunction2:
+			if _, err := rd.readString(); err != nil {
+				return nil, rd.unexpected(err)
+			}
+		case opModuleAux:
+			// 模块 ID、写入的时机和时机的取值，之后是模块自己描述格式的数据
+			if err := rd.skipLengths(3); err != nil {
+				return nil, rd.unexpected(err)
+			}
+			if err := rd.skipModule(); err != nil {
+				return nil, rd.unexpected(err)
+			}
+		case opFunctionPreGA:
+			return nil, fmt.Errorf("unsupported rdb opcode %d", op)
+		case opEOF:
+			return nil, rd.finish()
+		default:
+			key, err := rd.readString()
+			if err != nil {
+				return nil, rd.unexpected(err)
+			}
+			entry := &Entry{DB: rd.db, Key: key, ExpireAt: expireAt}
+			err = rd.readValue(op, entry)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read rdb key %q: %w", key, rd.unexpected(err))
+			}
+			return entry, nil
+		}
+	}
+}
+
+// finish 校验文件末尾的 CRC64，版本 5 之前没有校验和，写入时关闭了校验的文件校验和为 0
+func (rd *Reader) finish() error {
+	rd.done = true
+	if rd.version < 5 {
+		return io.EOF
+	}
+	expected := rd.crc
+	b, err := rd.readFull(8)
+	if err != nil {
+		return rd.unexpected(err)
+	}
+	if sum := binary.LittleEndian.Uint64(b); sum != 0 && sum != expected {
+		return ErrChecksum
+	}
+	return io.EOF
+}
+
+func (rd *Reader) readValue(kind byte, entry *Entry) error {
+	var err error
+	switch kind {
+	case rdbString:
+		entry.Type = TypeString
+		entry.Value, err = rd.readString()
+	case rdbList, rdbSet:
+		entry.Type = TypeList
+		if kind == rdbSet {
+			entry.Type = TypeSet
+		}
+		entry.Values, err = rd.readStrings()
+	case rdbSetIntset:
+		entry.Type = TypeSet
+		entry.Values, err = rd.readEncoded(parseIntset)
+	case rdbSetListpack:
+		entry.Type = TypeSet
+		entry.Values, err = rd.readEncoded(parseListpack)
+	case rdbListZiplist:
+		entry.Type = TypeList
+		entry.Values, err = rd.readEncoded(parseZiplist)
+	case rdbListQuicklist, rdbListQuicklist2:
+		entry.Type = TypeList
+		entry.Values, err = rd.readQuicklist(kind == rdbListQuicklist2)
+	case rdbZSet, rdbZSet2:
+		entry.Type = TypeZSet
+		entry.Members, err = rd.readZSet(kind == rdbZSet2)
+	case rdbZSetZiplist, rdbZSetListpack:
+		entry.Type = TypeZSet
+		parse := parseZiplist
+		if kind == rdbZSetListpack {
+			parse = parseListpack
+		}
+		var values [][]byte
+		values, err = rd.readEncoded(parse)
+		if err == nil {
+			entry.Members, err = pairMembers(values)
+		}
+	case rdbHash:
+		entry.Type = TypeHash
+		var values [][]byte
+		values, err = rd.readPairs()
+		if err == nil {
+			entry.Fields, err = pairFields(values)
+		}
+	case rdbHashZipmap, rdbHashZiplist, rdbHashListpack:
+		entry.Type = TypeHash
+		parse := parseZipmap
+		switch kind {
+		case rdbHashZiplist:
+			parse = parseZiplist
+		case rdbHashListpack:
+			parse = parseListpack
+		}
+		var values [][]byte
+		values, err = rd.readEncoded(parse)
+		if err == nil {
+			entry.Fields, err = pairFields(values)
+		}
+	case rdbStreamListpacks, rdbStreamListpacks2, rdbStreamListpacks3:
+		entry.Type = TypeStream
+		err = rd.skipStream(kind)
+	case rdbModule2:
+		entry.Type = TypeModule
+		err = rd.skipLengths(1)
+		if err == nil {
+			err = rd.skipModule()
+		}
+	case rdbModule:
+		err = errors.New("module values written by Redis 4.0 RC are not supported")
+	default:
+		err = fmt.Errorf("unsupported rdb value type %d", kind)
+	}
+	return err
+}
+
+// readStrings 读取长度前缀之后的字符串序列，用于 list 和 set 的原始编码
+func (rd *Reader) readStrings() ([][]byte, error) {
+	n, err := rd.readLen()
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]byte, 0, capacity(n))
+	for i := uint64(0); i < n; i++ {
+		value, err := rd.readString()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// readPairs 读取 hash 原始编码中的字段和值，返回交替排列的字段和值
+func (rd *Reader) readPairs() ([][]byte, error) {
+	n, err := rd.readLen()
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]byte, 0, capacity(n*2))
+	for i := uint64(0); i < n*2; i++ {
+		value, err := rd.readString()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func (rd *Reader) readZSet(binaryScore bool) ([]ZMember, error) {
+	n, err := rd.readLen()
+	if err != nil {
+		return nil, err
+	}
+	members := make([]ZMember, 0, capacity(n))
+	for i := uint64(0); i < n; i++ {
+		member, err := rd.readString()
+		if err != nil {
+			return nil, err
+		}
+		var score float64
+		if binaryScore {
+			b, err := rd.readFull(8)
+			if err != nil {
+				return nil, err
+			}
+			score = math.Float64frombits(binary.LittleEndian.Uint64(b))
+		} else {
+			score, err = rd.readDouble()
+			if err != nil {
+				return nil, err
+			}
+		}
+		members = append(members, ZMember{Member: member, Score: score})
+	}
+	return members, nil
+}
+
+// readEncoded 读取一个字符串并且按照 ziplist、listpack、intset 或者 zipmap 的格式解析
+func (rd *Reader) readEncoded(parse func([]byte) ([][]byte, error)) ([][]byte, error) {
+	blob, err := rd.readString()
+	if err != nil {
+		return nil, err
+	}
+	return parse(blob)
+}
+
+// readQuicklist 读取 quicklist 的所有节点，版本 1 的节点都是 ziplist，版本 2 的节点是 listpack 或者单个元素
+func (rd *Reader) readQuicklist(v2 bool) ([][]byte, error) {
+	n, err := rd.readLen()
+	if err != nil {
+		return nil, err
+	}
+	var values [][]byte
+	for i := uint64(0); i < n; i++ {
+		container := uint64(quicklistPacked)
+		if v2 {
+			container, err = rd.readLen()
+			if err != nil {
+				return nil, err
+			}
+		}
+		blob, err := rd.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case container == quicklistPlain:
+			values = append(values, blob)
+		case container != quicklistPacked:
+			return nil, fmt.Errorf("unknown quicklist container %d", container)
+		case v2:
+			node, err := parseListpack(blob)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, node...)
+		default:
+			node, err := parseZiplist(blob)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, node...)
+		}
+	}
+	return values, nil
+}
+
+// skipStream 跳过 Stream 的消息、消费组和消费者，版本 2 和 3 增加了一些元数据字段
+func (rd *Reader) skipStream(kind byte) error {
+	n, err := rd.readLen()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < n; i++ {
+		if _, err := rd.readString(); err != nil {
+			return err
+		}
+		if _, err := rd.readString(); err != nil {
+			return err
+		}
+	}
+
+	// 消息数，最后一条消息的 ID，版本 2 之后还有第一条消息的 ID、最大删除 ID 和添加过的消息数
+	fields := 3
+	if kind >= rdbStreamListpacks2 {
+		fields += 5
+	}
+	if err := rd.skipLengths(fields); err != nil {
+		return err
+	}
+
+	groups, err := rd.readLen()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < groups; i++ {
+		if _, err := rd.readString(); err != nil {
+			return err
+		}
+		// 最后投递的 ID，版本 2 之后还有已经读取的消息数
+		fields := 2
+		if kind >= rdbStreamListpacks2 {
+			fields++
+		}
+		if err := rd.skipLengths(fields); err != nil {
+			return err
+		}
+
+		// 消费组的待确认消息：16 字节的 ID、8 字节的投递时间和投递次数
+		pending, err := rd.readLen()
+		if err != nil {
+			return err
+		}
+		for j := uint64(0); j < pending; j++ {
+			if _, err := rd.readFull(24); err != nil {
+				return err
+			}
+			if err := rd.skipLengths(1); err != nil {
+				return err
+			}
+		}
+
+		consumers, err := rd.readLen()
+		if err != nil {
+			return err
+		}
+		for j := uint64(0); j < consumers; j++ {
+			if _, err := rd.readString(); err != nil {
+				return err
+			}
+			// 最后一次出现的时间，版本 3 之后还有最后一次活跃的时间
+			size := 8
+			if kind >= rdbStreamListpacks3 {
+				size += 8
+			}
+			if _, err := rd.readFull(size); err != nil {
+				return err
+			}
+			pending, err := rd.readLen()
+			if err != nil {
+				return err
+			}
+			for k := uint64(0); k < pending; k++ {
+				if _, err := rd.readFull(16); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// 模块数据中每个值之前的类型
+const (
+	moduleEOF    = 0
+	moduleSInt   = 1
+	moduleUInt   = 2
+	moduleFloat  = 3
+	moduleDouble = 4
+	moduleString = 5
+)
+
+// skipModule 跳过版本 2 的模块数据，每个值之前都有类型，直到 moduleEOF 结束
+func (rd *Reader) skipModule() error {
+	for {
+		opcode, err := rd.readLen()
+		if err != nil {
+			return err
+		}
+		switch opcode {
+		case moduleEOF:
+			return nil
+		case moduleSInt, moduleUInt:
+			err = rd.skipLengths(1)
+		case moduleFloat:
+			_, err = rd.readFull(4)
+		case moduleDouble:
+			_, err = rd.readFull(8)
+		case moduleString:
+			_, err = rd.readString()
+		default:
+			err = fmt.Errorf("unknown module opcode %d", opcode)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readLength 读取长度编码，encoded 为 true 时返回的是字符串的特殊编码类型
+func (rd *Reader) readLength() (uint64, bool, error) {
+	b, err := rd.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3f), false, nil
+	case 1:
+		next, err := rd.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(b&0x3f)<<8 | uint64(next), false, nil
+	case 2:
+		switch b {
+		case 0x80:
+			buf, err := rd.readFull(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(buf)), false, nil
+		case 0x81:
+			buf, err := rd.readFull(8)
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(buf), false, nil
+		default:
+			return 0, false, fmt.Errorf("invalid length encoding %#x", b)
+		}
+	default:
+		return uint64(b & 0x3f), true, nil
+	}
+}
+
+func (rd *Reader) readLen() (uint64, error) {
+	n, encoded, err := rd.readLength()
+	if err == nil && encoded {
+		err = errors.New("unexpected encoded length")
+	}
+	return n, err
+}
+
+func (rd *Reader) skipLengths(n int) error {
+	for i := 0; i < n; i++ {
+		if _, err := rd.readLen(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readString 读取字符串，整数编码的字符串转换为十进制文本，LZF 压缩的字符串解压之后返回
+func (rd *Reader) readString() ([]byte, error) {
+	n, encoded, err := rd.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		return rd.readFull(int(n))
+	}
+
+	switch n {
+	case 0:
+		b, err := rd.readFull(1)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(nil, int64(int8(b[0])), 10), nil
+	case 1:
+		b, err := rd.readFull(2)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(nil, int64(int16(binary.LittleEndian.Uint16(b))), 10), nil
+	case 2:
+		b, err := rd.readFull(4)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(nil, int64(int32(binary.LittleEndian.Uint32(b))), 10), nil
+	case 3:
+		clen, err := rd.readLen()
+		if err != nil {
+			return nil, err
+		}
+		ulen, err := rd.readLen()
+		if err != nil {
+			return nil, err
+		}
+		if ulen > maxStringLen {
+			return nil, fmt.Errorf("string length %d too large", ulen)
+		}
+		compressed, err := rd.readFull(int(clen))
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, int(ulen))
+	default:
+		return nil, fmt.Errorf("invalid string encoding %d", n)
+	}
+}
+
+// readDouble 读取旧版本有序集合中文本格式的分数，253、254 和 255 分别表示 NaN、正无穷和负无穷
+func (rd *Reader) readDouble() (float64, error) {
+	n, err := rd.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	b, err := rd.readFull(int(n))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(b), 64)
+}
+
+func (rd *Reader) readByte() (byte, error) {
+	b, err := rd.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	rd.crc = crc64(rd.crc, []byte{b})
+	return b, nil
+}
+
+func (rd *Reader) readFull(n int) ([]byte, error) {
+	if n < 0 || n > maxStringLen {
+		return nil, fmt.Errorf("string length %d too large", n)
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(rd.r, b)
+	if err != nil {
+		return nil, err
+	}
+	rd.crc = crc64(rd.crc, b)
+	return b, nil
+}
+
+// unexpected 把文件中间的 io.EOF 转换为 io.ErrUnexpectedEOF，调用方不会把截断的文件当作正常结束
+func (rd *Reader) unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// capacity 限制按照文件中的元素个数预先分配的容量，损坏的文件不会导致分配大量内存
+func capacity(n uint64) int {
+	if n > 1024 {
+		return 1024
+	}
+	return int(n)
+}
+
+func pairFields(values [][]byte) (map[string][]byte, error) {
+	if len(values)%2 != 0 {
+		return nil, errors.New("odd number of hash fields and values")
+	}
+	fields := make(map[string][]byte, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		fields[string(values[i])] = values[i+1]
+	}
+	return fields, nil
+}
+
+func pairMembers(values [][]byte) ([]ZMember, error) {
+	if len(values)%2 != 0 {
+		return nil, errors.New("odd number of zset members and scores")
+	}
+	members := make([]ZMember, 0, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		score, err := strconv.ParseFloat(string(values[i+1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid zset score %q", values[i+1])
+		}
+		members = append(members, ZMember{Member: values[i], Score: score})
+	}
+	return members, nil
+}
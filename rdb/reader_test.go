@@ -0,0 +1,343 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"testing"
+	"time"
+)
+
+// rdbWriter 按照 Redis 的格式生成测试用的 RDB 文件
+type rdbWriter struct {
+	bytes.Buffer
+}
+
+func newRDBWriter(version string) *rdbWriter {
+	w := new(rdbWriter)
+	w.WriteString("REDIS" + version)
+	return w
+}
+
+func (w *rdbWriter) length(n int) {
+	switch {
+	case n < 1<<6:
+		w.WriteByte(byte(n))
+	case n < 1<<14:
+		w.WriteByte(byte(n>>8) | 0x40)
+		w.WriteByte(byte(n))
+	default:
+		w.WriteByte(0x80)
+		_ = binary.Write(w, binary.BigEndian, uint32(n))
+	}
+}
+
+func (w *rdbWriter) str(s string) {
+	w.length(len(s))
+	w.WriteString(s)
+}
+
+func (w *rdbWriter) blob(b []byte) {
+	w.length(len(b))
+	w.Write(b)
+}
+
+// finish 写入 EOF 和 CRC64 校验和
+func (w *rdbWriter) finish() []byte {
+	w.WriteByte(opEOF)
+	_ = binary.Write(w, binary.LittleEndian, crc64(0, w.Bytes()))
+	return w.Bytes()
+}
+
+// listpack 生成字符串长度小于 64 的 listpack，整数使用 7 位的编码
+func listpack(items ...interface{}) []byte {
+	var body bytes.Buffer
+	for _, item := range items {
+		switch v := item.(type) {
+		case string:
+			body.WriteByte(0x80 | byte(len(v)))
+			body.WriteString(v)
+			body.WriteByte(byte(1 + len(v)))
+		case int:
+			body.WriteByte(byte(v))
+			body.WriteByte(1)
+		}
+	}
+	body.WriteByte(0xFF)
+
+	b := make([]byte, 6, 6+body.Len())
+	binary.LittleEndian.PutUint32(b, uint32(6+body.Len()))
+	binary.LittleEndian.PutUint16(b[4:], uint16(len(items)))
+	return append(b, body.Bytes()...)
+}
+
+// ziplist 生成字符串长度小于 64 的 ziplist，整数使用 int16 编码
+func ziplist(items ...interface{}) []byte {
+	var body bytes.Buffer
+	for _, item := range items {
+		body.WriteByte(0)
+		switch v := item.(type) {
+		case string:
+			body.WriteByte(byte(len(v)))
+			body.WriteString(v)
+		case int:
+			body.WriteByte(0xC0)
+			_ = binary.Write(&body, binary.LittleEndian, int16(v))
+		}
+	}
+	body.WriteByte(0xFF)
+
+	b := make([]byte, 10, 10+body.Len())
+	binary.LittleEndian.PutUint32(b, uint32(10+body.Len()))
+	binary.LittleEndian.PutUint16(b[8:], uint16(len(items)))
+	return append(b, body.Bytes()...)
+}
+
+func TestCRC64(t *testing.T) {
+	if crc := crc64(0, []byte("123456789")); crc != 0xe9c6d914c4b8d9ca {
+		t.Fatalf("expected crc64 0xe9c6d914c4b8d9ca, got %#x", crc)
+	}
+}
+
+func TestReader(t *testing.T) {
+	w := newRDBWriter("0011")
+	w.WriteByte(opAux)
+	w.str("redis-ver")
+	w.str("7.2.4")
+	w.WriteByte(opSelectDB)
+	w.length(0)
+	w.WriteByte(opResizeDB)
+	w.length(10)
+	w.length(1)
+
+	// 带有毫秒过期时间的字符串
+	expireAt := time.UnixMilli(1893456000123)
+	w.WriteByte(opExpireTimeMs)
+	_ = binary.Write(w, binary.LittleEndian, uint64(expireAt.UnixMilli()))
+	w.WriteByte(rdbString)
+	w.str("session")
+	w.str("token")
+
+	// 整数编码的字符串
+	w.WriteByte(rdbString)
+	w.str("counter")
+	w.WriteByte(0xC1)
+	_ = binary.Write(w, binary.LittleEndian, int16(-1234))
+
+	// LZF 压缩的字符串：字面量 abc 之后引用前面 3 个字节的数据 6 次
+	w.WriteByte(rdbString)
+	w.str("compressed")
+	w.WriteByte(0xC3)
+	w.length(6)
+	w.length(9)
+	w.Write([]byte{0x02, 'a', 'b', 'c', 0x80, 0x02})
+
+	w.WriteByte(rdbList)
+	w.str("list")
+	w.length(2)
+	w.str("a")
+	w.str("b")
+
+	w.WriteByte(rdbListQuicklist2)
+	w.str("quicklist")
+	w.length(2)
+	w.length(quicklistPacked)
+	w.blob(listpack("x", 7))
+	w.length(quicklistPlain)
+	w.str("large")
+
+	w.WriteByte(rdbSetIntset)
+	w.str("intset")
+	intset := []byte{2, 0, 0, 0, 2, 0, 0, 0, 0xFF, 0xFF, 5, 0}
+	w.blob(intset)
+
+	w.WriteByte(rdbZSet2)
+	w.str("zset2")
+	w.length(1)
+	w.str("alice")
+	_ = binary.Write(w, binary.LittleEndian, math.Float64bits(2.5))
+
+	w.WriteByte(rdbZSet)
+	w.str("zset")
+	w.length(2)
+	w.str("bob")
+	w.WriteByte(3)
+	w.WriteString("1.5")
+	w.str("carol")
+	w.WriteByte(254)
+
+	w.WriteByte(rdbZSetZiplist)
+	w.str("zset-ziplist")
+	w.blob(ziplist("dave", 3))
+
+	w.WriteByte(rdbHash)
+	w.str("hash")
+	w.length(1)
+	w.str("field")
+	w.str("value")
+
+	w.WriteByte(rdbHashListpack)
+	w.str("hash-listpack")
+	w.blob(listpack("name", "flasche", "age", 3))
+
+	w.WriteByte(rdbHashZipmap)
+	w.str("zipmap")
+	w.blob([]byte{1, 1, 'k', 1, 0, 'v', 0xFF})
+
+	// Stream 只跳过数据
+	w.WriteByte(rdbStreamListpacks)
+	w.str("stream")
+	w.length(0)
+	w.length(0)
+	w.length(0)
+	w.length(0)
+	w.length(0)
+
+	w.WriteByte(opSelectDB)
+	w.length(3)
+	w.WriteByte(opExpireTime)
+	_ = binary.Write(w, binary.LittleEndian, uint32(1893456000))
+	w.WriteByte(rdbSetListpack)
+	w.str("set")
+	w.blob(listpack("m1", "m2"))
+
+	rd, err := NewReader(bytes.NewReader(w.finish()))
+	if err != nil {
+		t.Fatalf("failed to open rdb: %v", err)
+	}
+	if rd.Version() != 11 {
+		t.Fatalf("expected version 11, got %d", rd.Version())
+	}
+
+	entries := make(map[string]*Entry)
+	for {
+		entry, err := rd.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read entry: %v", err)
+		}
+		entries[string(entry.Key)] = entry
+	}
+
+	expectValues := func(key string, kind Type, values ...string) {
+		t.Helper()
+		entry := entries[key]
+		if entry == nil || entry.Type != kind {
+			t.Errorf("expected %s %s, got %+v", kind, key, entry)
+			return
+		}
+		got := entry.Values
+		if kind == TypeString {
+			got = [][]byte{entry.Value}
+		}
+		if len(got) != len(values) {
+			t.Errorf("expected %s values %q, got %q", key, values, got)
+			return
+		}
+		for i := range values {
+			if string(got[i]) != values[i] {
+				t.Errorf("expected %s values %q, got %q", key, values, got)
+				return
+			}
+		}
+	}
+	expectValues("session", TypeString, "token")
+	expectValues("counter", TypeString, "-1234")
+	expectValues("compressed", TypeString, "abcabcabc")
+	expectValues("list", TypeList, "a", "b")
+	expectValues("quicklist", TypeList, "x", "7", "large")
+	expectValues("intset", TypeSet, "-1", "5")
+	expectValues("set", TypeSet, "m1", "m2")
+
+	if !entries["session"].ExpireAt.Equal(expireAt) || !entries["counter"].ExpireAt.IsZero() {
+		t.Errorf("unexpected expire times: %v %v", entries["session"].ExpireAt, entries["counter"].ExpireAt)
+	}
+	if set := entries["set"]; set.DB != 3 || set.ExpireAt.Unix() != 1893456000 || entries["list"].DB != 0 {
+		t.Errorf("unexpected db or expire time of set: %d %v", set.DB, set.ExpireAt)
+	}
+
+	zsets := map[string][]ZMember{
+		"zset2":        {{Member: []byte("alice"), Score: 2.5}},
+		"zset":         {{Member: []byte("bob"), Score: 1.5}, {Member: []byte("carol"), Score: math.Inf(1)}},
+		"zset-ziplist": {{Member: []byte("dave"), Score: 3}},
+	}
+	for key, members := range zsets {
+		entry := entries[key]
+		if entry == nil || entry.Type != TypeZSet || len(entry.Members) != len(members) {
+			t.Errorf("expected zset %s, got %+v", key, entry)
+			continue
+		}
+		for i, m := range members {
+			if string(entry.Members[i].Member) != string(m.Member) || entry.Members[i].Score != m.Score {
+				t.Errorf("expected %s member %s %v, got %s %v", key, m.Member, m.Score, entry.Members[i].Member, entry.Members[i].Score)
+			}
+		}
+	}
+
+	hashes := map[string]map[string]string{
+		"hash":          {"field": "value"},
+		"hash-listpack": {"name": "flasche", "age": "3"},
+		"zipmap":        {"k": "v"},
+	}
+	for key, fields := range hashes {
+		entry := entries[key]
+		if entry == nil || entry.Type != TypeHash || len(entry.Fields) != len(fields) {
+			t.Errorf("expected hash %s, got %+v", key, entry)
+			continue
+		}
+		for field, value := range fields {
+			if string(entry.Fields[field]) != value {
+				t.Errorf("expected %s field %s=%s, got %s", key, field, value, entry.Fields[field])
+			}
+		}
+	}
+
+	if entry := entries["stream"]; entry == nil || entry.Type != TypeStream {
+		t.Errorf("expected stream to be skipped, got %+v", entry)
+	}
+	if _, err := rd.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF after the end, got %v", err)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	if _, err := NewReader(bytes.NewReader([]byte("NOTREDIS0011"))); err != ErrInvalidFile {
+		t.Errorf("expected ErrInvalidFile, got %v", err)
+	}
+	if _, err := NewReader(bytes.NewReader([]byte("REDIS0099"))); err == nil {
+		t.Errorf("expected error for unsupported version")
+	}
+
+	w := newRDBWriter("0009")
+	w.WriteByte(rdbString)
+	w.str("key")
+	w.str("value")
+	data := w.finish()
+
+	// 修改一个字节之后校验和不匹配
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)-12] ^= 0xFF
+	rd, err := NewReader(bytes.NewReader(corrupted))
+	if err != nil {
+		t.Fatalf("failed to open rdb: %v", err)
+	}
+	if _, err := rd.Next(); err != nil {
+		t.Fatalf("failed to read entry: %v", err)
+	}
+	if _, err := rd.Next(); err != ErrChecksum {
+		t.Errorf("expected ErrChecksum, got %v", err)
+	}
+
+	// 截断的文件不会被当作正常结束
+	rd, err = NewReader(bytes.NewReader(data[:len(data)-12]))
+	if err != nil {
+		t.Fatalf("failed to open rdb: %v", err)
+	}
+	if _, err := rd.Next(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
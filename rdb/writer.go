@@ -0,0 +1,153 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// 写入的 RDB 版本，Redis 5.0 及之后的版本都可以加载
+const writeVersion = 9
+
+// Writer 按照 Redis 的格式写入 RDB 文件，所有的值都使用不压缩的原始编码，Close 时写入 CRC64 校验和
+type Writer struct {
+	w   *bufio.Writer
+	crc uint64
+	db  int
+	err error
+}
+
+// NewWriter 写入 RDB 文件头
+func NewWriter(w io.Writer) (*Writer, error) {
+	wr := &Writer{w: bufio.NewWriter(w), db: -1}
+	wr.write([]byte(fmt.Sprintf("REDIS%04d", writeVersion)))
+	return wr, wr.err
+}
+
+// WriteEntry 写入一个 Key，DB 和上一个 Key 不同时先写入 SELECTDB，TypeStream 和 TypeModule 不能写入
+func (wr *Writer) WriteEntry(e *Entry) error {
+	if wr.err != nil {
+		return wr.err
+	}
+	if e.DB < 0 {
+		return fmt.Errorf("invalid redis database %d", e.DB)
+	}
+	// 写入任何数据之前检查类型，不能写入的 Key 不会在文件中留下一半的记录
+	if e.Type > TypeHash {
+		return fmt.Errorf("cannot write %s value for key %q", e.Type, e.Key)
+	}
+
+	if e.DB != wr.db {
+		wr.writeByte(opSelectDB)
+		wr.writeLength(uint64(e.DB))
+		wr.db = e.DB
+	}
+	if !e.ExpireAt.IsZero() {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], uint64(e.ExpireAt.UnixMilli()))
+		wr.writeByte(opExpireTimeMs)
+		wr.write(b[:])
+	}
+
+	switch e.Type {
+	case TypeString:
+		wr.writeByte(rdbString)
+		wr.writeString(e.Key)
+		wr.writeString(e.Value)
+	case TypeList, TypeSet:
+		kind := byte(rdbList)
+		if e.Type == TypeSet {
+			kind = rdbSet
+		}
+		wr.writeByte(kind)
+		wr.writeString(e.Key)
+		wr.writeLength(uint64(len(e.Values)))
+		for _, value := range e.Values {
+			wr.writeString(value)
+		}
+	case TypeZSet:
+		wr.writeByte(rdbZSet2)
+		wr.writeString(e.Key)
+		wr.writeLength(uint64(len(e.Members)))
+		var b [8]byte
+		for _, m := range e.Members {
+			wr.writeString(m.Member)
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(m.Score))
+			wr.write(b[:])
+		}
+	case TypeHash:
+		wr.writeByte(rdbHash)
+		wr.writeString(e.Key)
+		wr.writeLength(uint64(len(e.Fields)))
+		// 按照字段排序，同样的数据每次生成的文件都一样
+		fields := make([]string, 0, len(e.Fields))
+		for field := range e.Fields {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			wr.writeString([]byte(field))
+			wr.writeString(e.Fields[field])
+		}
+	}
+	return wr.err
+}
+
+// Close 写入 EOF 和校验和并且刷新缓冲区，不会关闭底层的 io.Writer
+func (wr *Writer) Close() error {
+	if wr.err != nil {
+		return wr.err
+	}
+	wr.writeByte(opEOF)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], wr.crc)
+	if _, err := wr.w.Write(b[:]); err != nil {
+		return err
+	}
+	if err := wr.w.Flush(); err != nil {
+		return err
+	}
+	wr.err = errors.New("rdb writer closed")
+	return nil
+}
+
+// writeLength 写入长度编码，和 Redis 一样选择能放下长度的最短编码
+func (wr *Writer) writeLength(n uint64) {
+	switch {
+	case n < 1<<6:
+		wr.writeByte(byte(n))
+	case n < 1<<14:
+		wr.write([]byte{byte(n>>8) | 0x40, byte(n)})
+	case n <= math.MaxUint32:
+		var b [5]byte
+		b[0] = 0x80
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		wr.write(b[:])
+	default:
+		var b [9]byte
+		b[0] = 0x81
+		binary.BigEndian.PutUint64(b[1:], n)
+		wr.write(b[:])
+	}
+}
+
+func (wr *Writer) writeString(s []byte) {
+	wr.writeLength(uint64(len(s)))
+	wr.write(s)
+}
+
+func (wr *Writer) writeByte(b byte) {
+	wr.write([]byte{b})
+}
+
+func (wr *Writer) write(b []byte) {
+	if wr.err != nil {
+		return
+	}
+	_, wr.err = wr.w.Write(b)
+	wr.crc = crc64(wr.crc, b)
+}
@@ -0,0 +1,64 @@
+package rdb
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriterRoundTrip(t *testing.T) {
+	expireAt := time.UnixMilli(1893456000123)
+	long := strings.Repeat("v", 20000)
+	entries := []*Entry{
+		{DB: 0, Key: []byte("session"), Type: TypeString, Value: []byte("token"), ExpireAt: expireAt},
+		{DB: 0, Key: []byte("large"), Type: TypeString, Value: []byte(long)},
+		{DB: 0, Key: []byte("list"), Type: TypeList, Values: [][]byte{[]byte("a"), []byte("b")}},
+		{DB: 2, Key: []byte("set"), Type: TypeSet, Values: [][]byte{[]byte("m1")}},
+		{DB: 2, Key: []byte("zset"), Type: TypeZSet, Members: []ZMember{{Member: []byte("alice"), Score: math.Inf(-1)}}},
+		{DB: 2, Key: []byte("hash"), Type: TypeHash, Fields: map[string][]byte{"b": []byte("2"), "a": []byte("1")}},
+	}
+
+	var buf bytes.Buffer
+	wr, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("failed to create writer: %v", err)
+	}
+	for _, e := range entries {
+		if err := wr.WriteEntry(e); err != nil {
+			t.Fatalf("failed to write entry %s: %v", e.Key, err)
+		}
+	}
+	if err := wr.WriteEntry(&Entry{Key: []byte("stream"), Type: TypeStream}); err == nil {
+		t.Errorf("expected error for writing a stream")
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatalf("failed to close writer: %v", err)
+	}
+
+	rd, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("failed to open rdb: %v", err)
+	}
+	if rd.Version() != writeVersion {
+		t.Errorf("expected version %d, got %d", writeVersion, rd.Version())
+	}
+	for _, want := range entries {
+		got, err := rd.Next()
+		if err != nil {
+			t.Fatalf("failed to read entry %s: %v", want.Key, err)
+		}
+		if got.DB != want.DB || string(got.Key) != string(want.Key) || got.Type != want.Type || !got.ExpireAt.Equal(want.ExpireAt) {
+			t.Errorf("expected %+v, got %+v", want, got)
+		}
+		if string(got.Value) != string(want.Value) || len(got.Values) != len(want.Values) ||
+			len(got.Members) != len(want.Members) || len(got.Fields) != len(want.Fields) {
+			t.Errorf("unexpected value of %s: %+v", want.Key, got)
+		}
+	}
+	if _, err := rd.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF with a valid checksum, got %v", err)
+	}
+}
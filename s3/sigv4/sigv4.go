@@ -0,0 +1,136 @@
+// Package sigv4 按照 AWS Signature Version 4 给 S3 请求签名，vfs.S3Backend 和备份使用的 s3.Target 共用同一个实现
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	algorithm     = "AWS4-HMAC-SHA256"
+	amzDateFormat = "20060102T150405Z"
+	// EmptyPayloadHash 是空请求体的 SHA256
+	EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	// UnsignedPayload 是不计算请求体哈希值时使用的占位符，只能在 HTTPS 连接中使用
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// Signer 保存签名使用的凭证，SessionToken 只有临时凭证才需要
+type Signer struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Region       string
+}
+
+// Sign 设置 X-Amz-Date、X-Amz-Content-Sha256 和 Authorization 请求头，请求体的 SHA256 同样参与签名，S3 会校验它
+// 参与签名的请求头是 Host、Content-MD5、Content-Type、Range 和所有的 X-Amz- 请求头
+func (s *Signer) Sign(req *http.Request, payloadHash string, now time.Time) {
+	date := now.UTC().Format(amzDateFormat)
+	req.Header.Set("X-Amz-Date", date)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		switch {
+		case name == "content-md5", name == "content-type", name == "range", strings.HasPrefix(name, "x-amz-"):
+			trimmed := make([]string, len(values))
+			for i := range values {
+				trimmed[i] = strings.TrimSpace(values[i])
+			}
+			headers[name] = strings.Join(trimmed, ",")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonical strings.Builder
+	canonical.WriteString(req.Method + "\n")
+	canonical.WriteString(canonicalURI(req.URL) + "\n")
+	canonical.WriteString(CanonicalQuery(req.URL.Query()) + "\n")
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonical.WriteString("\n" + signed + "\n" + payloadHash)
+
+	scope := date[:8] + "/" + s.Region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical.String()))
+	toSign := algorithm + "\n" + date + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date[:8])
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", algorithm+" Credential="+s.AccessKey+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+signature)
+}
+
+// canonicalURI 编码解码之后的路径，保留分隔符 /，空路径是 /
+func canonicalURI(u *url.URL) string {
+	if u.Path == "" {
+		return "/"
+	}
+	return URIEncode(u.Path, false)
+}
+
+// CanonicalQuery 按照参数名排序并且编码查询参数，没有值的参数写成 name=
+// 请求的 RawQuery 使用同样的编码，发送的查询参数和签名的一致
+func CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, URIEncode(key, true)+"="+URIEncode(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// URIEncode 只保留 A-Z、a-z、0-9、-、.、_ 和 ~，其他字节编码为大写的 %XX，encodeSlash 为 false 时保留路径中的 /
+func URIEncode(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&0x0f])
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,404 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/auula/wiredkv/s3/sigv4"
+	"github.com/auula/wiredkv/vfs"
+)
+
+const (
+	// S3 要求除了最后一个分片之外每个分片至少 5 MB，最多 10000 个分片
+	minPartSize     = 5 << 20
+	defaultPartSize = 16 << 20
+	maxParts        = 10000
+	// 上传分片失败时的重试次数，分片在内存中，可以直接重新发送
+	partRetries = 3
+)
+
+// 第 n 次重试之前等待 n 倍的 partRetryDelay
+var partRetryDelay = time.Second
+
+// 服务端加密方式，对应 x-amz-server-side-encryption 请求头
+const (
+	SSEAES256 = "AES256"
+	SSEKMS    = "aws:kms"
+)
+
+// Config 是 S3 兼容的对象存储的连接配置
+type Config struct {
+	// Endpoint 对象存储的地址，例如 https://s3.us-east-1.amazonaws.com 或者 http://127.0.0.1:9000
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix 备份文件的对象名前缀，例如 wiredkv/
+	Prefix       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// VirtualHosted 为 true 时使用 bucket.endpoint 形式的地址，否则使用 endpoint/bucket 形式的地址
+	VirtualHosted bool
+	// SSE 服务端加密方式，可选 AES256 和 aws:kms，空表示使用存储桶的默认设置
+	SSE string
+	// KMSKeyID 使用 aws:kms 加密时的密钥，空表示使用账户的默认密钥
+	KMSKeyID string
+	// PartSize 每个分片的字节数，0 表示 16 MB
+	PartSize int64
+	// Client 发送请求使用的 HTTP 客户端，nil 表示 http.DefaultClient
+	Client *http.Client
+}
+
+// Target 通过分片上传把备份文件写入 S3 兼容的对象存储
+// 每个分片带有 Content-MD5 和签名的 SHA256，对象存储会校验分片的数据，不使用 KMS 加密时还会校验返回的 ETag
+type Target struct {
+	cfg    Config
+	base   *url.URL
+	signer *sigv4.Signer
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewTarget 检查配置并且创建 Target
+func NewTarget(cfg Config) (*Target, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3 bucket cannot be empty")
+	}
+	if cfg.Region == "" {
+		return nil, errors.New("s3 region cannot be empty")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("s3 access key and secret key cannot be empty")
+	}
+	switch cfg.SSE {
+	case "", SSEAES256, SSEKMS:
+	default:
+		return nil, fmt.Errorf("unsupported s3 server-side encryption %q", cfg.SSE)
+	}
+	if cfg.KMSKeyID != "" && cfg.SSE != SSEKMS {
+		return nil, errors.New("s3 kms key id requires aws:kms server-side encryption")
+	}
+	if cfg.PartSize == 0 {
+		cfg.PartSize = defaultPartSize
+	}
+	if cfg.PartSize < minPartSize {
+		return nil, fmt.Errorf("s3 part size must be at least %d bytes", minPartSize)
+	}
+
+	base, err := url.Parse(cfg.Endpoint)
+	if err != nil || base.Host == "" || (base.Scheme != "http" && base.Scheme != "https") {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", cfg.Endpoint)
+	}
+	if cfg.VirtualHosted {
+		base.Host = cfg.Bucket + "." + base.Host
+	}
+
+	client := cfg.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Target{
+		cfg:    cfg,
+		base:   base,
+		signer: &sigv4.Signer{AccessKey: cfg.AccessKey, SecretKey: cfg.SecretKey, SessionToken: cfg.SessionToken, Region: cfg.Region},
+		client: client,
+		now:    time.Now,
+	}, nil
+}
+
+// Create 开始一次分片上传，对象名是 Prefix 加上 name
+func (t *Target) Create(ctx context.Context, name string) (vfs.BackupWriter, error) {
+	w := &writer{t: t, ctx: ctx, key: t.cfg.Prefix + name, buf: make([]byte, 0, t.cfg.PartSize)}
+
+	header := make(http.Header)
+	header.Set("Content-Type", "application/octet-stream")
+	if t.cfg.SSE != "" {
+		header.Set("X-Amz-Server-Side-Encryption", t.cfg.SSE)
+	}
+	if t.cfg.KMSKeyID != "" {
+		header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", t.cfg.KMSKeyID)
+	}
+
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err := t.do(ctx, http.MethodPost, w.key, url.Values{"uploads": {""}}, header, nil, &result, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+	if result.UploadID == "" {
+		return nil, errors.New("failed to create multipart upload: empty upload id")
+	}
+	w.uploadID = result.UploadID
+	return w, nil
+}
+
+// List 通过 ListObjectsV2 返回 Prefix 下的所有对象，返回的名字去掉了 Prefix，不包括更深一层目录中的对象
+func (t *Target) List(ctx context.Context) ([]string, error) {
+	var (
+		names []string
+		token string
+	)
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {t.cfg.Prefix}, "delimiter": {"/"}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		var result struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err := t.do(ctx, http.MethodGet, "", query, nil, nil, &result, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+		for _, obj := range result.Contents {
+			names = append(names, strings.TrimPrefix(obj.Key, t.cfg.Prefix))
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return names, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Delete 删除 Prefix 加上 name 的对象
+func (t *Target) Delete(ctx context.Context, name string) error {
+	err := t.do(ctx, http.MethodDelete, t.cfg.Prefix+name, nil, nil, nil, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+	return nil
+}
+
+// Open 读取 Prefix 加上 name 的对象，增量备份通过它读取之前的清单，恢复时读取清单和数据文件
+func (t *Target) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := t.send(ctx, http.MethodGet, t.cfg.Prefix+name, nil, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object: %w", err)
+	}
+	return resp.Body, nil
+}
+
+// writer 把写入的数据按照 PartSize 切分为分片依次上传
+type writer struct {
+	t        *Target
+	ctx      context.Context
+	key      string
+	uploadID string
+	buf      []byte
+	parts    []completedPart
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		written += n
+		p = p[n:]
+		if len(w.buf) == cap(w.buf) {
+			if err := w.uploadPart(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (w *writer) uploadPart() error {
+	number := len(w.parts) + 1
+	if number > maxParts {
+		return fmt.Errorf("backup exceeds %d s3 parts, increase the part size", maxParts)
+	}
+
+	sum := md5.Sum(w.buf)
+	header := make(http.Header)
+	header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {w.uploadID}}
+
+	var (
+		etag string
+		err  error
+	)
+	for retry := 0; retry < partRetries; retry++ {
+		if retry > 0 {
+			select {
+			case <-w.ctx.Done():
+				return w.ctx.Err()
+			case <-time.After(time.Duration(retry) * partRetryDelay):
+			}
+		}
+		var resp http.Header
+		err = w.t.do(w.ctx, http.MethodPut, w.key, query, header, w.buf, nil, &resp)
+		if err == nil {
+			etag = resp.Get("ETag")
+			break
+		}
+		var se *Error
+		if errors.As(err, &se) && se.StatusCode < 500 {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload part %d: %w", number, err)
+	}
+
+	// KMS 加密之后 ETag 不是分片的 MD5
+	if w.t.cfg.SSE != SSEKMS && strings.Trim(etag, `"`) != hex.EncodeToString(sum[:]) {
+		return fmt.Errorf("failed to upload part %d: etag %s does not match the part checksum", number, etag)
+	}
+	w.parts = append(w.parts, completedPart{PartNumber: number, ETag: etag})
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Commit 上传最后一个分片并且合并所有的分片，合并成功之后对象才可见
+func (w *writer) Commit() error {
+	if len(w.buf) > 0 || len(w.parts) == 0 {
+		if err := w.uploadPart(); err != nil {
+			_ = w.Abort()
+			return err
+		}
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: w.parts})
+	if err != nil {
+		return err
+	}
+	header := make(http.Header)
+	header.Set("Content-Type", "application/xml")
+	var result struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	err = w.t.do(w.ctx, http.MethodPost, w.key, url.Values{"uploadId": {w.uploadID}}, header, body, &result, nil)
+	// 合并请求返回 200 之后仍然可能在响应体中返回错误
+	if err == nil && result.XMLName.Local == "Error" {
+		err = &Error{StatusCode: http.StatusOK, Code: result.Code, Message: result.Message}
+	}
+	if err != nil {
+		_ = w.Abort()
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	return nil
+}
+
+// Abort 取消分片上传，对象存储会删除已经上传的分片
+func (w *writer) Abort() error {
+	// 调用方的 ctx 取消之后同样需要清理已经上传的分片
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	err := w.t.do(ctx, http.MethodDelete, w.key, url.Values{"uploadId": {w.uploadID}}, nil, nil, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to abort multipart upload: %w", err)
+	}
+	return nil
+}
+
+// Error 是对象存储返回的错误
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3 request failed with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("s3 request failed with status %d: %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// do 发送签名之后的请求，result 不为 nil 时解析 XML 响应体，respHeader 不为 nil 时返回响应头
+func (t *Target) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte, result interface{}, respHeader *http.Header) error {
+	resp, err := t.send(ctx, method, key, query, header, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if respHeader != nil {
+		*respHeader = resp.Header
+	}
+	if result != nil {
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if err := xml.Unmarshal(data, result); err != nil {
+			return fmt.Errorf("failed to parse s3 response: %w", err)
+		}
+	}
+	return nil
+}
+
+// send 发送签名之后的请求，状态码不是 2xx 时返回 *Error，否则调用方需要关闭响应体
+func (t *Target) send(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := *t.base
+	objectPath := "/" + key
+	if !t.cfg.VirtualHosted {
+		objectPath = "/" + t.cfg.Bucket + objectPath
+	}
+	u.Path = path.Join(t.base.Path, objectPath)
+	u.RawPath = sigv4.URIEncode(u.Path, false)
+	u.RawQuery = sigv4.CanonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = append([]string(nil), values...)
+	}
+	req.ContentLength = int64(len(body))
+
+	payloadHash := sigv4.EmptyPayloadHash
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	t.signer.Sign(req, payloadHash, t.now())
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		var body struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		_ = xml.Unmarshal(data, &body)
+		return nil, &Error{StatusCode: resp.StatusCode, Code: body.Code, Message: body.Message}
+	}
+	return resp, nil
+}
@@ -0,0 +1,277 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 实现分片上传用到的接口，校验签名相关的请求头和分片的校验和
+type fakeS3 struct {
+	t        *testing.T
+	mu       sync.Mutex
+	parts    map[int][]byte
+	objects  map[string][]byte
+	headers  http.Header
+	aborted  bool
+	failures int    // 上传分片时先返回多少次 500
+	badETag  bool   // 上传分片时返回错误的 ETag
+	complete string // 合并分片时在 200 的响应体中返回的错误码
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	f := &fakeS3{t: t, parts: make(map[int][]byte), objects: make(map[string][]byte)}
+	return f, httptest.NewServer(f)
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") {
+		http.Error(w, "missing signature", http.StatusForbidden)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	sum := sha256.Sum256(body)
+	if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+		http.Error(w, "<Error><Code>XAmzContentSHA256Mismatch</Code></Error>", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.headers = r.Header.Clone()
+		fmt.Fprint(w, "<InitiateMultipartUploadResult><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>")
+	case r.Method == http.MethodPut && query.Get("uploadId") == "upload-1":
+		if f.failures > 0 {
+			f.failures--
+			http.Error(w, "<Error><Code>InternalError</Code></Error>", http.StatusInternalServerError)
+			return
+		}
+		md := md5.Sum(body)
+		if r.Header.Get("Content-MD5") != base64.StdEncoding.EncodeToString(md[:]) {
+			http.Error(w, "<Error><Code>BadDigest</Code></Error>", http.StatusBadRequest)
+			return
+		}
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		f.parts[number] = body
+		etag := hex.EncodeToString(md[:])
+		if f.badETag {
+			etag = "0000"
+		}
+		w.Header().Set("ETag", `"`+etag+`"`)
+	case r.Method == http.MethodPost && query.Get("uploadId") == "upload-1":
+		if f.complete != "" {
+			fmt.Fprintf(w, "<Error><Code>%s</Code><Message>failed</Message></Error>", f.complete)
+			return
+		}
+		var req struct {
+			Parts []completedPart `xml:"Part"`
+		}
+		if err := xml.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var object []byte
+		for i, part := range req.Parts {
+			if part.PartNumber != i+1 {
+				http.Error(w, "<Error><Code>InvalidPartOrder</Code></Error>", http.StatusBadRequest)
+				return
+			}
+			object = append(object, f.parts[part.PartNumber]...)
+		}
+		f.objects[r.URL.Path] = object
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == http.MethodDelete && query.Get("uploadId") == "upload-1":
+		f.aborted = true
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && r.URL.Path == "/backups" && query.Get("list-type") == "2":
+		// 每页只返回一个对象，continuation-token 是上一页的对象名
+		var keys []string
+		for name := range f.objects {
+			key := strings.TrimPrefix(name, "/backups/")
+			rest := strings.TrimPrefix(key, query.Get("prefix"))
+			if strings.HasPrefix(key, query.Get("prefix")) && !strings.Contains(rest, query.Get("delimiter")) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for len(keys) > 0 && keys[0] <= query.Get("continuation-token") {
+			keys = keys[1:]
+		}
+		fmt.Fprint(w, "<ListBucketResult>")
+		if len(keys) > 0 {
+			fmt.Fprintf(w, "<Contents><Key>%s</Key></Contents>", keys[0])
+		}
+		if len(keys) > 1 {
+			fmt.Fprintf(w, "<IsTruncated>true</IsTruncated><NextContinuationToken>%s</NextContinuationToken>", keys[0])
+		}
+		fmt.Fprint(w, "</ListBucketResult>")
+	case r.Method == http.MethodGet && len(query) == 0:
+		object, ok := f.objects[r.URL.Path]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(object)
+	case r.Method == http.MethodDelete && len(query) == 0:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unexpected request", http.StatusNotImplemented)
+	}
+}
+
+func newTestTarget(t *testing.T, endpoint string, sse string) *Target {
+	t.Helper()
+	target, err := NewTarget(Config{
+		Endpoint:  endpoint,
+		Region:    "us-east-1",
+		Bucket:    "backups",
+		Prefix:    "wiredkv/",
+		AccessKey: "access",
+		SecretKey: "secret",
+		SSE:       sse,
+		PartSize:  minPartSize,
+	})
+	if err != nil {
+		t.Fatalf("failed to create target: %v", err)
+	}
+	return target
+}
+
+func TestMultipartUpload(t *testing.T) {
+	f, srv := newFakeS3(t)
+	defer srv.Close()
+	target := newTestTarget(t, srv.URL, SSEAES256)
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), (2*minPartSize+1024)/16)
+	w, err := target.Create(context.Background(), "backup 1.wdb")
+	if err != nil {
+		t.Fatalf("failed to create upload: %v", err)
+	}
+	// 分多次写入，分片的边界和写入的边界不一致
+	for chunk := data; len(chunk) > 0; {
+		n := 1 << 20
+		if n > len(chunk) {
+			n = len(chunk)
+		}
+		if _, err := w.Write(chunk[:n]); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+		chunk = chunk[n:]
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	if got := f.objects["/backups/wiredkv/backup 1.wdb"]; !bytes.Equal(got, data) {
+		t.Fatalf("expected object of %d bytes, got %d bytes", len(data), len(got))
+	}
+	if len(f.parts) != 3 || f.headers.Get("X-Amz-Server-Side-Encryption") != SSEAES256 {
+		t.Errorf("unexpected upload: %d parts, headers %v", len(f.parts), f.headers)
+	}
+	if !strings.Contains(f.headers.Get("Authorization"), "x-amz-server-side-encryption") {
+		t.Errorf("expected encryption header to be signed: %s", f.headers.Get("Authorization"))
+	}
+}
+
+func TestMultipartUploadErrors(t *testing.T) {
+	partRetryDelay = time.Millisecond
+	defer func() { partRetryDelay = time.Second }()
+
+	f, srv := newFakeS3(t)
+	defer srv.Close()
+	target := newTestTarget(t, srv.URL, "")
+
+	// 服务端错误重试之后成功
+	f.failures = partRetries - 1
+	w, err := target.Create(context.Background(), "retry.wdb")
+	if err != nil {
+		t.Fatalf("failed to create upload: %v", err)
+	}
+	_, _ = w.Write([]byte("data"))
+	if err := w.Commit(); err != nil {
+		t.Fatalf("expected upload to succeed after retries: %v", err)
+	}
+
+	// ETag 和分片的 MD5 不一致
+	f.badETag = true
+	w, _ = target.Create(context.Background(), "etag.wdb")
+	_, _ = w.Write([]byte("data"))
+	if err := w.Commit(); err == nil || !strings.Contains(err.Error(), "checksum") || !f.aborted {
+		t.Errorf("expected checksum error and aborted upload, got %v aborted=%v", err, f.aborted)
+	}
+
+	// 合并分片返回 200 但是响应体是错误
+	f.badETag, f.aborted, f.complete = false, false, "InternalError"
+	w, _ = target.Create(context.Background(), "complete.wdb")
+	_, _ = w.Write([]byte("data"))
+	if err := w.Commit(); err == nil || !strings.Contains(err.Error(), "InternalError") || !f.aborted {
+		t.Errorf("expected complete error and aborted upload, got %v aborted=%v", err, f.aborted)
+	}
+
+	if _, err := NewTarget(Config{Endpoint: srv.URL, Region: "us-east-1", Bucket: "b", AccessKey: "a", SecretKey: "s", SSE: "des"}); err == nil {
+		t.Errorf("expected error for unsupported server-side encryption")
+	}
+	if _, err := NewTarget(Config{Endpoint: srv.URL, Region: "us-east-1", Bucket: "b", AccessKey: "a", SecretKey: "s", PartSize: 1024}); err == nil {
+		t.Errorf("expected error for small part size")
+	}
+}
+
+func TestListOpenDelete(t *testing.T) {
+	f, srv := newFakeS3(t)
+	defer srv.Close()
+	target := newTestTarget(t, srv.URL, "")
+
+	f.objects["/backups/wiredkv/a.wdb"] = []byte("a")
+	f.objects["/backups/wiredkv/b.wdb"] = []byte("b")
+	f.objects["/backups/wiredkv/c.wdb"] = []byte("c")
+	f.objects["/backups/wiredkv/nested/d.wdb"] = []byte("d")
+	f.objects["/backups/other/e.wdb"] = []byte("e")
+
+	names, err := target.List(context.Background())
+	if err != nil {
+		t.Fatalf("failed to list: %v", err)
+	}
+	if want := []string{"a.wdb", "b.wdb", "c.wdb"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("expected %v, got %v", want, names)
+	}
+
+	r, err := target.Open(context.Background(), "a.wdb")
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "a" {
+		t.Errorf("expected object a, got %q", data)
+	}
+	if _, err := target.Open(context.Background(), "missing.wdb"); err == nil || !strings.Contains(err.Error(), "NoSuchKey") {
+		t.Errorf("expected NoSuchKey error, got %v", err)
+	}
+
+	if err := target.Delete(context.Background(), "b.wdb"); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if _, ok := f.objects["/backups/wiredkv/b.wdb"]; ok {
+		t.Errorf("expected object to be deleted")
+	}
+}
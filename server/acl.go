@@ -0,0 +1,179 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Operation 是一次请求需要的权限，权限按照 OpRead、OpWrite、OpAdmin 的顺序依次包含
+type Operation uint8
+
+const (
+	OpNone  Operation = iota // 没有任何权限，ACL 中用来从更宽泛的授权里排除 bucket 或者 key 前缀
+	OpRead                   // 读取数据
+	OpWrite                  // 写入和删除数据
+	OpAdmin                  // 统计信息和调试接口这类管理操作
+)
+
+func (op Operation) String() string {
+	switch op {
+	case OpNone:
+		return "none"
+	case OpRead:
+		return "read"
+	case OpWrite:
+		return "write"
+	case OpAdmin:
+		return "admin"
+	default:
+		return fmt.Sprintf("operation(%d)", op)
+	}
+}
+
+// parseOperation 解析 ACL 文件中的权限名字
+func parseOperation(name string) (Operation, error) {
+	switch strings.ToLower(name) {
+	case "none":
+		return OpNone, nil
+	case "read":
+		return OpRead, nil
+	case "write":
+		return OpWrite, nil
+	case "admin":
+		return OpAdmin, nil
+	default:
+		return 0, fmt.Errorf("unknown acl permission %q", name)
+	}
+}
+
+var ErrForbidden = errors.New("permission denied")
+
+// Authorizer 在每个请求通过认证之后调用，返回错误时拒绝请求
+// bucket 是请求路径的第一段，也就是数据类型，key 是第二段，管理接口的 bucket 为空，key 是请求路径
+type Authorizer interface {
+	Authorize(identity string, op Operation, bucket, key string) error
+}
+
+// Authenticator 由可以自己校验客户端凭证的 Authorizer 实现，返回 false 时拒绝请求
+// 没有实现 Authenticator 时所有客户端共享 Options.Auth 密码，identity 是客户端在 user 请求头中声明的名字
+type Authenticator interface {
+	Authenticate(identity, password string) bool
+}
+
+// ACL 是内置的 Authorizer，每个用户使用自己的密码认证，按照 bucket 和 key 前缀授予权限
+// ACL 文件是 JSON 格式，grants 的键是 "*"、"bucket" 或者 "bucket/key前缀"，匹配的最长的键生效：
+//
+//	{
+//	  "users": {
+//	    "alice": {"password": "...", "grants": {"*": "read", "zset": "write", "table/user-": "admin", "table/secret-": "none"}}
+//	  }
+//	}
+//
+// 权限 none 拒绝所有操作，更长的键优先，所以可以从更宽泛的授权中排除一部分 Key，管理接口需要 "*" 的 admin 权限
+type ACL struct {
+	users map[string]*aclUser
+}
+
+type aclUser struct {
+	password string
+	grants   map[string]Operation
+}
+
+// aclFile 是 ACL 文件的 JSON 结构
+type aclFile struct {
+	Users map[string]struct {
+		Password string            `json:"password"`
+		Grants   map[string]string `json:"grants"`
+	} `json:"users"`
+}
+
+// LoadACL 读取并校验 ACL 文件
+func LoadACL(path string) (*ACL, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read acl file: %w", err)
+	}
+	return ParseACL(data)
+}
+
+// ParseACL 解析 JSON 格式的 ACL，没有密码的用户、没有 bucket 的授权和未知的权限名字都会返回错误
+func ParseACL(data []byte) (*ACL, error) {
+	var file aclFile
+	err := json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse acl file: %w", err)
+	}
+
+	acl := &ACL{users: make(map[string]*aclUser, len(file.Users))}
+	for name, u := range file.Users {
+		if name == "" || u.Password == "" {
+			return nil, fmt.Errorf("acl user %q must have a name and a password", name)
+		}
+		user := &aclUser{password: u.Password, grants: make(map[string]Operation, len(u.Grants))}
+		for pattern, permission := range u.Grants {
+			if pattern == "" || strings.HasPrefix(pattern, "/") {
+				return nil, fmt.Errorf("acl user %q: invalid grant pattern %q", name, pattern)
+			}
+			op, err := parseOperation(permission)
+			if err != nil {
+				return nil, fmt.Errorf("acl user %q: %w", name, err)
+			}
+			user.grants[pattern] = op
+		}
+		acl.users[name] = user
+	}
+
+	return acl, nil
+}
+
+// Authenticate 使用常数时间比较用户的密码，不存在的用户也会比较一次，耗时不会泄露用户是否存在
+func (acl *ACL) Authenticate(identity, password string) bool {
+	user, ok := acl.users[identity]
+	if !ok {
+		verifyPassword(password, "")
+		return false
+	}
+	return verifyPassword(password, user.password)
+}
+
+// Authorize 按照匹配的最长的授权检查权限，没有匹配的授权时拒绝
+func (acl *ACL) Authorize(identity string, op Operation, bucket, key string) error {
+	user, ok := acl.users[identity]
+	if !ok {
+		return fmt.Errorf("%w: unknown user %q", ErrForbidden, identity)
+	}
+
+	granted, matched := OpNone, -1
+	for pattern, permission := range user.grants {
+		// "*" 比任何具体的 bucket 都更宽泛
+		specificity := len(pattern)
+		if pattern == "*" {
+			specificity = 0
+		}
+		if specificity > matched && grantMatches(pattern, op, bucket, key) {
+			granted, matched = permission, specificity
+		}
+	}
+	if granted < op {
+		return fmt.Errorf("%w: user %q cannot %s %s/%s", ErrForbidden, identity, op, bucket, key)
+	}
+	return nil
+}
+
+// grantMatches 判断授权的键是否覆盖请求的 bucket 和 key，管理接口只匹配 "*"
+func grantMatches(pattern string, op Operation, bucket, key string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if op == OpAdmin && bucket == "" {
+		return false
+	}
+	name, prefix, ok := strings.Cut(pattern, "/")
+	if name != bucket {
+		return false
+	}
+	return !ok || strings.HasPrefix(key, prefix)
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestACLAuthorize(t *testing.T) {
+	acl, err := ParseACL([]byte(`{"users": {
+		"alice": {"password": "alice-password", "grants": {
+			"*": "read",
+			"table": "write",
+			"table/user-": "admin",
+			"table/user-secret-": "none",
+			"zset/": "write"
+		}},
+		"bob": {"password": "bob-password", "grants": {"*": "admin", "table": "none"}}
+	}}`))
+	if err != nil {
+		t.Fatalf("failed to parse acl: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		identity string
+		op       Operation
+		bucket   string
+		key      string
+		allowed  bool
+	}{
+		{"wildcard read", "alice", OpRead, "set", "k", true},
+		{"wildcard denies write", "alice", OpWrite, "set", "k", false},
+		{"bucket overrides wildcard", "alice", OpWrite, "table", "order-1", true},
+		{"longer prefix overrides bucket", "alice", OpAdmin, "table", "user-1", true},
+		{"longest prefix denies", "alice", OpRead, "table", "user-secret-1", false},
+		{"deny only covers its prefix", "alice", OpWrite, "table", "user-secre", true},
+		{"empty key prefix matches bucket", "alice", OpWrite, "zset", "", true},
+		{"prefix does not match other bucket", "alice", OpWrite, "tables", "user-1", false},
+		{"admin path needs wildcard admin", "alice", OpAdmin, "", "/admin/compact", false},
+		{"bucket deny overrides wildcard admin", "bob", OpRead, "table", "user-1", false},
+		{"wildcard admin covers admin path", "bob", OpAdmin, "", "/admin/compact", true},
+		{"wildcard admin covers data", "bob", OpWrite, "set", "k", true},
+		{"unknown user", "carol", OpRead, "set", "k", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := acl.Authorize(tt.identity, tt.op, tt.bucket, tt.key)
+			if tt.allowed && err != nil {
+				t.Errorf("expected allowed, got %v", err)
+			}
+			if !tt.allowed && !errors.Is(err, ErrForbidden) {
+				t.Errorf("expected ErrForbidden, got %v", err)
+			}
+		})
+	}
+}
+
+func TestParseACLMalformed(t *testing.T) {
+	tests := map[string]string{
+		"invalid json":       `{"users": `,
+		"missing password":   `{"users": {"alice": {"grants": {"*": "read"}}}}`,
+		"empty user":         `{"users": {"": {"password": "p"}}}`,
+		"unknown permission": `{"users": {"alice": {"password": "p", "grants": {"*": "root"}}}}`,
+		"empty pattern":      `{"users": {"alice": {"password": "p", "grants": {"": "read"}}}}`,
+		"missing bucket":     `{"users": {"alice": {"password": "p", "grants": {"/user-": "read"}}}}`,
+		"wrong grant type":   `{"users": {"alice": {"password": "p", "grants": ["read"]}}}`,
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseACL([]byte(data)); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+
+	// 权限名字不区分大小写
+	if _, err := ParseACL([]byte(`{"users": {"alice": {"password": "p", "grants": {"*": "READ", "table": "None"}}}}`)); err != nil {
+		t.Errorf("expected permission names to be case insensitive, got %v", err)
+	}
+}
+
+func TestACLAuthenticate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.json")
+	err := os.WriteFile(path, []byte(`{"users": {"alice": {"password": "alice-password"}}}`), 0600)
+	if err != nil {
+		t.Fatalf("failed to write acl: %v", err)
+	}
+	acl, err := LoadACL(path)
+	if err != nil {
+		t.Fatalf("failed to load acl: %v", err)
+	}
+
+	if !acl.Authenticate("alice", "alice-password") {
+		t.Error("expected alice to authenticate")
+	}
+	if acl.Authenticate("alice", "wrong") || acl.Authenticate("bob", "alice-password") || acl.Authenticate("bob", "") {
+		t.Error("expected wrong password and unknown user to be rejected")
+	}
+	// 没有任何授权的用户可以认证，但是所有操作都被拒绝
+	if err := acl.Authorize("alice", OpRead, "set", "k"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected ErrForbidden without grants, got %v", err)
+	}
+	if _, err := LoadACL(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("expected error for missing acl file")
+	}
+}
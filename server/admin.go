@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/auula/wiredkv/clog"
+	"github.com/gorilla/mux"
+)
+
+// AdminCommand 是只有管理员可以执行的命令，数据命令之外的请求都需要 OpAdmin 权限
+type AdminCommand string
+
+const (
+	AdminCompact  AdminCommand = "compact"  // 立即压缩存在垃圾数据的数据文件
+	AdminBackup   AdminCommand = "backup"   // 备份数据目录
+	AdminReload   AdminCommand = "reload"   // 重新加载配置文件
+	AdminShutdown AdminCommand = "shutdown" // 优雅退出
+)
+
+// adminHandlers 保存进程注册的管理命令，没有注册的命令返回 501
+var adminHandlers = struct {
+	sync.RWMutex
+	m map[AdminCommand]func(ctx context.Context) error
+}{
+	m: make(map[AdminCommand]func(ctx context.Context) error),
+}
+
+// HandleAdmin 注册管理命令的实现，通过 POST /admin/{command} 调用，重复注册会替换之前的实现
+// 存储引擎初始化之后 compact 默认压缩所有存在垃圾数据的数据文件，其他命令依赖进程的配置，需要调用方注册
+func (hs *HttpServer) HandleAdmin(command AdminCommand, fn func(ctx context.Context) error) {
+	adminHandlers.Lock()
+	defer adminHandlers.Unlock()
+	adminHandlers.m[command] = fn
+}
+
+func lookupAdmin(command AdminCommand) (func(ctx context.Context) error, bool) {
+	adminHandlers.RLock()
+	fn, ok := adminHandlers.m[command]
+	adminHandlers.RUnlock()
+	if ok {
+		return fn, true
+	}
+	if command == AdminCompact && storage != nil {
+		return storage.CompactContext, true
+	}
+	return nil, false
+}
+
+// isAdminPath 判断请求是不是管理命令，统计信息和调试接口也只有管理员可以访问
+func isAdminPath(path string) bool {
+	return path == "/stats" || strings.HasPrefix(path, "/debug/") || strings.HasPrefix(path, "/admin/")
+}
+
+// mountAdmin 在 /admin 下挂载管理命令，和其他接口一样需要通过鉴权
+func mountAdmin(router *mux.Router) {
+	router.HandleFunc("/admin/audit", auditController).Methods("GET")
+	router.HandleFunc("/admin/{command}", adminController).Methods("POST")
+}
+
+type identityKey struct{}
+
+// requestIdentity 返回通过认证之后的客户端身份
+func requestIdentity(r *http.Request) string {
+	identity, _ := r.Context().Value(identityKey{}).(string)
+	return identity
+}
+
+// auditController 返回管理命令审计日志中最近的记录，limit 参数控制返回的条数
+func auditController(w http.ResponseWriter, r *http.Request) {
+	if adminAudit == nil {
+		okResponse(w, http.StatusNotImplemented, nil, "admin audit log is not enabled!")
+		return
+	}
+
+	limit := defaultAuditLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > maxAuditLimit {
+			okResponse(w, http.StatusBadRequest, nil, fmt.Sprintf("limit must be between 1 and %d", maxAuditLimit))
+			return
+		}
+		limit = n
+	}
+
+	entries, err := adminAudit.recent(limit)
+	if err != nil {
+		okResponse(w, http.StatusInternalServerError, nil, err.Error())
+		return
+	}
+	result := make([]interface{}, len(entries))
+	for i := range entries {
+		result[i] = entries[i]
+	}
+	okResponse(w, http.StatusOK, result, "request processed successfully!")
+}
+
+// adminController 同步执行管理命令，命令执行完成之后才返回
+func adminController(w http.ResponseWriter, r *http.Request) {
+	// 中间件已经拒绝了被重命名的命令原来的名字，这里把新名字转换成原来的命令
+	name, _ := commands.resolve(mux.Vars(r)["command"])
+	command := AdminCommand(name)
+	identity, client := requestIdentity(r), clientAddr(r.RemoteAddr)
+	switch command {
+	case AdminCompact, AdminBackup, AdminReload, AdminShutdown:
+	default:
+		okResponse(w, http.StatusNotFound, nil, "unknown admin command!")
+		return
+	}
+
+	fn, ok := lookupAdmin(command)
+	if !ok {
+		auditAdmin(identity, client, string(command), "not supported")
+		okResponse(w, http.StatusNotImplemented, nil, "admin command is not supported!")
+		return
+	}
+
+	// 先执行命令再记录结果，退出命令在响应写完之后才会关闭审计日志
+	err := fn(r.Context())
+	if err != nil {
+		auditAdmin(identity, client, string(command), err.Error())
+		clog.Errorf("failed to execute admin command %s: %v", command, err)
+		okResponse(w, http.StatusInternalServerError, nil, err.Error())
+		return
+	}
+	auditAdmin(identity, client, string(command), "ok")
+	clog.Infof("Admin command %s executed successfully", command)
+	okResponse(w, http.StatusOK, nil, "ok")
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// adminRequests 遍历 root 上注册的路由，返回所有管理接口的请求，新增的管理接口会自动被覆盖
+func adminRequests(t *testing.T) []*http.Request {
+	t.Helper()
+	var reqs []*http.Request
+	err := root.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{http.MethodGet}
+		}
+
+		var paths []string
+		switch {
+		case strings.Contains(template, "{command}"):
+			for _, c := range []AdminCommand{AdminCompact, AdminBackup, AdminReload, AdminShutdown} {
+				paths = append(paths, strings.Replace(template, "{command}", string(c), 1))
+			}
+		case strings.HasSuffix(template, "/"):
+			// PathPrefix 注册的路由，例如 /debug/pprof/ 下通过名称访问的 profile
+			paths = append(paths, template+"heap")
+		default:
+			paths = append(paths, template)
+		}
+		for _, path := range paths {
+			if !isAdminPath(path) {
+				continue
+			}
+			for _, method := range methods {
+				reqs = append(reqs, httptest.NewRequest(method, path, nil))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk routes: %v", err)
+	}
+	return reqs
+}
+
+func TestAdminRoutesForbidden(t *testing.T) {
+	acl, err := ParseACL([]byte(`{"users": {
+		"alice": {"password": "alice-password", "grants": {"*": "write", "kv": "admin", "pubsub": "admin"}},
+		"root": {"password": "root-password", "grants": {"*": "admin"}}
+	}}`))
+	if err != nil {
+		t.Fatalf("failed to parse acl: %v", err)
+	}
+	authorizer = acl
+	defer func() { authorizer = nil }()
+
+	// 被拒绝的管理命令不会执行
+	var executed int32
+	saved := adminHandlers.m
+	defer func() { adminHandlers.m = saved }()
+	adminHandlers.m = make(map[AdminCommand]func(ctx context.Context) error)
+	for _, c := range []AdminCommand{AdminCompact, AdminBackup, AdminReload, AdminShutdown} {
+		adminHandlers.m[c] = func(ctx context.Context) error {
+			atomic.AddInt32(&executed, 1)
+			return nil
+		}
+	}
+
+	reqs := adminRequests(t)
+	seen := make(map[string]bool)
+	for _, req := range reqs {
+		seen[req.URL.Path] = true
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("user", "alice")
+		req.Header.Set("auth", "alice-password")
+		rec := httptest.NewRecorder()
+		root.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("expected 403 for %s %s without admin permission, got %d", req.Method, req.URL.Path, rec.Code)
+		}
+	}
+	if n := atomic.LoadInt32(&executed); n != 0 {
+		t.Errorf("expected forbidden admin commands not to run, %d executed", n)
+	}
+	for _, path := range []string{"/stats", "/debug/runtime", "/debug/pprof/profile", "/debug/pprof/heap", "/admin/audit", "/admin/compact", "/admin/shutdown"} {
+		if !seen[path] {
+			t.Errorf("expected admin route %s to be checked", path)
+		}
+	}
+
+	// 管理员可以执行同样的请求
+	req := httptest.NewRequest(http.MethodPost, "/admin/compact", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("user", "root")
+	req.Header.Set("auth", "root-password")
+	rec := httptest.NewRecorder()
+	root.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || atomic.LoadInt32(&executed) != 1 {
+		t.Errorf("expected admin to run compact, got %d", rec.Code)
+	}
+}
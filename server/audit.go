@@ -0,0 +1,213 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/auula/wiredkv/clog"
+	"github.com/auula/wiredkv/utils"
+)
+
+const (
+	// 管理命令审计日志超过 defaultAuditMaxSize 字节之后轮转
+	defaultAuditMaxSize = 10 << 20
+	// 轮转之后最多保留的旧日志文件个数，例如 admin.log.1 到 admin.log.5
+	defaultAuditBackups = 5
+	// 查询接口默认和最多返回的记录条数
+	defaultAuditLimit = 100
+	maxAuditLimit     = 10000
+)
+
+// AdminAuditEntry 是管理命令审计日志中的一行记录
+type AdminAuditEntry struct {
+	Time     string `json:"time"` // RFC3339Nano
+	Identity string `json:"identity,omitempty"`
+	Client   string `json:"client"`
+	Command  string `json:"command"`
+	Result   string `json:"result"` // ok、forbidden 或者执行失败的错误信息
+}
+
+// adminAuditLog 是只追加的管理命令审计日志，和数据路径的审计日志分开保存，每条记录写入之后立即刷盘
+type adminAuditLog struct {
+	mu      sync.Mutex
+	path    string
+	fd      *os.File
+	size    int64
+	maxSize int64
+	backups int
+}
+
+var adminAudit *adminAuditLog
+
+// openAdminAuditLog 以追加模式打开审计日志，maxSize 和 backups 小于等于 0 时使用默认值
+func openAdminAuditLog(path string, maxSize int64, backups int) (*adminAuditLog, error) {
+	if maxSize <= 0 {
+		maxSize = defaultAuditMaxSize
+	}
+	if backups <= 0 {
+		backups = defaultAuditBackups
+	}
+
+	a := &adminAuditLog{path: path, maxSize: maxSize, backups: backups}
+	err := a.open()
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *adminAuditLog) open() error {
+	fd, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open admin audit log: %w", err)
+	}
+	finfo, err := fd.Stat()
+	if err != nil {
+		_ = utils.CloseFile(fd)
+		return fmt.Errorf("failed to open admin audit log: %w", err)
+	}
+	a.fd, a.size = fd, finfo.Size()
+	return nil
+}
+
+// record 追加一条审计记录，没有开启审计日志时什么都不做
+func (a *adminAuditLog) record(entry *AdminAuditEntry) error {
+	if a == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode admin audit entry: %w", err)
+	}
+	data = append(data, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.size > 0 && a.size+int64(len(data)) > a.maxSize {
+		err := a.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := a.fd.Write(data)
+	a.size += int64(n)
+	if err == nil {
+		err = a.fd.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write admin audit log: %w", err)
+	}
+	return nil
+}
+
+// rotate 把当前的日志改名为 path.1，已有的旧日志依次后移，超过 backups 个的最旧的日志被删除
+func (a *adminAuditLog) rotate() error {
+	err := utils.CloseFile(a.fd)
+	if err != nil {
+		return fmt.Errorf("failed to rotate admin audit log: %w", err)
+	}
+
+	_ = os.Remove(a.backup(a.backups))
+	for i := a.backups - 1; i >= 1; i-- {
+		err := os.Rename(a.backup(i), a.backup(i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to rotate admin audit log: %w", err)
+		}
+	}
+	err = os.Rename(a.path, a.backup(1))
+	if err != nil {
+		return fmt.Errorf("failed to rotate admin audit log: %w", err)
+	}
+
+	return a.open()
+}
+
+func (a *adminAuditLog) backup(i int) string {
+	return a.path + "." + strconv.Itoa(i)
+}
+
+// recent 按照时间顺序返回最近的 limit 条记录，需要时继续读取轮转之后的旧日志
+func (a *adminAuditLog) recent(limit int) ([]AdminAuditEntry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var entries []AdminAuditEntry
+	for i := 0; i <= a.backups && len(entries) < limit; i++ {
+		name := a.path
+		if i > 0 {
+			name = a.backup(i)
+		}
+		older, err := readAdminAuditFile(name)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(older, entries...)
+	}
+
+	if len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	return entries, nil
+}
+
+func readAdminAuditFile(name string) ([]AdminAuditEntry, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []AdminAuditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var entry AdminAuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("failed to read admin audit log %s: %w", name, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read admin audit log %s: %w", name, err)
+	}
+	return entries, nil
+}
+
+func (a *adminAuditLog) close() error {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return utils.CloseFile(a.fd)
+}
+
+// auditAdmin 记录一次管理命令，写入失败只输出日志，不影响命令本身的结果
+func auditAdmin(identity, client, command, result string) {
+	if adminAudit == nil {
+		return
+	}
+	err := adminAudit.record(&AdminAuditEntry{
+		Time:     time.Now().Format(time.RFC3339Nano),
+		Identity: identity,
+		Client:   client,
+		Command:  command,
+		Result:   result,
+	})
+	if err != nil {
+		clog.Errorf("failed to record admin command %s: %v", command, err)
+	}
+}
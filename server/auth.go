@@ -0,0 +1,108 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// 同一个客户端连续认证失败超过 authMaxFailures 次之后开始退避
+	authMaxFailures = 5
+	// 第一次退避的时间，之后每次失败翻倍，最长 authMaxBackoff
+	authBaseBackoff = time.Second
+	authMaxBackoff  = 5 * time.Minute
+	// 记录的客户端超过 authMaxClients 个时清理已经过期的记录
+	authMaxClients = 10000
+)
+
+// authFailure 是一个客户端连续认证失败的次数和退避结束的时间
+type authFailure struct {
+	count int
+	until time.Time
+	last  time.Time
+}
+
+// authLimiter 按照客户端地址记录认证失败的次数，连续失败之后指数退避，防止暴力猜测密码
+type authLimiter struct {
+	mu       sync.Mutex
+	failures map[string]*authFailure
+}
+
+var limiter = &authLimiter{failures: make(map[string]*authFailure)}
+
+// allow 返回客户端是否可以尝试认证，处于退避中时返回剩余的等待时间
+func (l *authLimiter) allow(client string, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, ok := l.failures[client]
+	if !ok || !now.Before(f.until) {
+		return 0, true
+	}
+	return f.until.Sub(now), false
+}
+
+// fail 记录一次认证失败，连续失败超过 authMaxFailures 次之后按照失败次数计算退避时间
+func (l *authLimiter) fail(client string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.failures) >= authMaxClients {
+		l.prune(now)
+	}
+
+	f, ok := l.failures[client]
+	if !ok {
+		f = new(authFailure)
+		l.failures[client] = f
+	}
+	f.count++
+	f.last = now
+
+	if f.count >= authMaxFailures {
+		backoff := authMaxBackoff
+		if shift := f.count - authMaxFailures; shift < 16 {
+			backoff = authBaseBackoff << uint(shift)
+		}
+		if backoff > authMaxBackoff {
+			backoff = authMaxBackoff
+		}
+		f.until = now.Add(backoff)
+	}
+}
+
+// succeed 认证成功之后清除客户端的失败记录
+func (l *authLimiter) succeed(client string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, client)
+}
+
+// prune 删除退避已经结束并且超过 authMaxBackoff 没有再失败的客户端，调用方需要持有 l.mu
+func (l *authLimiter) prune(now time.Time) {
+	for client, f := range l.failures {
+		if !now.Before(f.until) && now.Sub(f.last) > authMaxBackoff {
+			delete(l.failures, client)
+		}
+	}
+}
+
+// verifyPassword 使用常数时间比较密码，先计算摘要再比较，比较的耗时也不会泄露密码的长度
+func verifyPassword(given, expected string) bool {
+	g := sha256.Sum256([]byte(given))
+	e := sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(g[:], e[:]) == 1
+}
+
+// clientAddr 返回限制认证失败次数使用的客户端地址
+// X-Forwarded-For 可以由客户端任意伪造，只使用连接的远端地址
+func clientAddr(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
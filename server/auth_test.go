@@ -0,0 +1,145 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuthLimiter(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		failures int           // 连续失败的次数
+		after    time.Duration // 最后一次失败之后经过的时间
+		allowed  bool
+		wait     time.Duration
+	}{
+		{name: "below threshold", failures: authMaxFailures - 1, allowed: true},
+		{name: "locked out", failures: authMaxFailures, allowed: false, wait: authBaseBackoff},
+		{name: "backoff doubles", failures: authMaxFailures + 2, allowed: false, wait: 4 * authBaseBackoff},
+		{name: "backoff capped", failures: authMaxFailures + 40, allowed: false, wait: authMaxBackoff},
+		{name: "partially waited", failures: authMaxFailures + 1, after: time.Second, allowed: false, wait: time.Second},
+		{name: "backoff expired", failures: authMaxFailures + 1, after: 2 * authBaseBackoff, allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &authLimiter{failures: make(map[string]*authFailure)}
+			for i := 0; i < tt.failures; i++ {
+				l.fail("client", start)
+			}
+
+			wait, ok := l.allow("client", start.Add(tt.after))
+			if ok != tt.allowed || wait != tt.wait {
+				t.Errorf("expected allowed %t wait %s, got %t %s", tt.allowed, tt.wait, ok, wait)
+			}
+			// 其他客户端不受影响
+			if _, ok := l.allow("other", start); !ok {
+				t.Error("expected other client to be allowed")
+			}
+		})
+	}
+}
+
+func TestAuthLimiterReset(t *testing.T) {
+	now := time.Now()
+	l := &authLimiter{failures: make(map[string]*authFailure)}
+	for i := 0; i < authMaxFailures; i++ {
+		l.fail("client", now)
+	}
+	if _, ok := l.allow("client", now); ok {
+		t.Fatal("expected client to be locked out")
+	}
+
+	// 认证成功之后清除失败记录，重新从第一次失败开始计数
+	l.succeed("client")
+	if _, ok := l.allow("client", now); !ok {
+		t.Fatal("expected client to be allowed after success")
+	}
+	for i := 0; i < authMaxFailures-1; i++ {
+		l.fail("client", now)
+	}
+	if _, ok := l.allow("client", now); !ok {
+		t.Error("expected failure count to restart after success")
+	}
+
+	// 记录的客户端太多时清理很久没有失败的客户端
+	for i := 0; i < authMaxClients; i++ {
+		l.fail(fmt.Sprintf("client-%d", i), now.Add(-2*authMaxBackoff))
+	}
+	l.fail("new", now)
+	if _, ok := l.failures["client-0"]; ok {
+		t.Error("expected stale clients to be pruned")
+	}
+	if _, ok := l.failures["client"]; !ok {
+		t.Error("expected recent client to be kept")
+	}
+}
+
+func TestAuthMiddlewareLockout(t *testing.T) {
+	savedPassword, savedLimiter := authPassword, limiter
+	defer func() { authPassword, limiter = savedPassword, savedLimiter }()
+	authPassword = "password"
+	limiter = &authLimiter{failures: make(map[string]*authFailure)}
+
+	request := func(password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("auth", password)
+		rec := httptest.NewRecorder()
+		root.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < authMaxFailures; i++ {
+		if rec := request("wrong"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401 for attempt %d, got %d", i+1, rec.Code)
+		}
+	}
+
+	// 退避期间正确的密码也会被拒绝，并且返回 Retry-After
+	rec := request("password")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 429 with Retry-After, got %d %v", rec.Code, rec.Header())
+	}
+
+	// 退避结束之后认证成功会清除失败记录
+	limiter.failures["192.0.2.1"].until = time.Now().Add(-time.Second)
+	if rec := request("password"); rec.Code == http.StatusUnauthorized || rec.Code == http.StatusTooManyRequests {
+		t.Fatalf("expected correct password to be accepted, got %d", rec.Code)
+	}
+	if _, ok := limiter.failures["192.0.2.1"]; ok {
+		t.Error("expected failures to be reset after success")
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	for _, tt := range []struct {
+		given, expected string
+		ok              bool
+	}{
+		{"secret", "secret", true},
+		{"secret", "secreT", false},
+		{"secret", "secret-longer", false},
+		{"", "", true},
+	} {
+		if got := verifyPassword(tt.given, tt.expected); got != tt.ok {
+			t.Errorf("verifyPassword(%q, %q) = %t", tt.given, tt.expected, got)
+		}
+	}
+}
+
+func TestClientAddr(t *testing.T) {
+	for addr, want := range map[string]string{
+		"192.0.2.1:1234":   "192.0.2.1",
+		"[2001:db8::1]:80": "2001:db8::1",
+		"not-an-addr":      "not-an-addr",
+	} {
+		if got := clientAddr(addr); got != want {
+			t.Errorf("clientAddr(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
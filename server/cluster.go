@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/auula/wiredkv/clog"
+	"github.com/auula/wiredkv/cluster"
+)
+
+// 集群模式中的槽位分配和当前节点的地址，clusterRouter 为空表示单机模式
+var (
+	clusterRouter *cluster.Router
+	clusterNode   string
+)
+
+// redirectMoved 检查数据请求的 Key 是否由当前节点负责，不是的话返回 307 重定向到负责的节点
+// MovedHeader 响应头携带槽位和节点，集群客户端据此更新自己的槽位表，普通的 HTTP 客户端直接跟随 Location
+func redirectMoved(w http.ResponseWriter, r *http.Request) bool {
+	if clusterRouter == nil || isAdminPath(r.URL.Path) {
+		return false
+	}
+
+	// 发布订阅的频道不属于任何槽位，由客户端连接的节点处理
+	// 批量命令接口包含多个 Key，由处理函数按照每条命令的 Key 检查
+	_, bucket, key := requestOperation(r)
+	if bucket == pubsubBucket || key == "" || isCommandPath(r.URL.Path) {
+		return false
+	}
+
+	slot := cluster.Slot(key)
+	owner := clusterRouter.Owner(slot)
+	if owner == "" || owner == clusterNode {
+		return false
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	location := url.URL{Scheme: scheme, Host: owner, Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: r.URL.RawQuery}
+
+	moved := cluster.FormatMoved(slot, owner)
+	clog.Debugf("Request %s %s redirected: %s", r.Method, r.URL.Path, moved)
+	w.Header().Set(cluster.MovedHeader, moved)
+	w.Header().Set("Location", location.String())
+	okResponse(w, http.StatusTemporaryRedirect, nil, moved)
+	return true
+}
+
+// movedKey 检查 Key 是否由当前节点负责，不是的话返回 MOVED 信息，批量命令接口用它检查每条命令的 Key
+func movedKey(key string) (string, bool) {
+	if clusterRouter == nil {
+		return "", false
+	}
+	slot := cluster.Slot(key)
+	owner := clusterRouter.Owner(slot)
+	if owner == "" || owner == clusterNode {
+		return "", false
+	}
+	return cluster.FormatMoved(slot, owner), true
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// 每个请求按照路径和方法对应一个命令名字，运维可以通过配置禁用不需要或者危险的命令
+// 数据命令是 get、set 和 delete，批量命令 pipeline、事务 multi 和脚本 eval 中的每条命令也按照自己的名字检查
+// 发布订阅命令是 publish、subscribe 和按照模式订阅的 psubscribe，管理命令是 stats、debug、audit 和 AdminCommand 中的命令
+var knownCommands = map[string]bool{
+	"get":                 true,
+	"set":                 true,
+	"delete":              true,
+	"pipeline":            true,
+	"eval":                true,
+	"multi":               true,
+	"publish":             true,
+	"subscribe":           true,
+	"psubscribe":          true,
+	"stats":               true,
+	"debug":               true,
+	"audit":               true,
+	string(AdminCompact):  true,
+	string(AdminBackup):   true,
+	string(AdminReload):   true,
+	string(AdminShutdown): true,
+}
+
+// disabledMessage 是请求被禁用的命令时返回的错误信息
+const disabledMessage = "command disabled"
+
+// commandFilter 记录配置中允许、禁用和重命名的命令，allow 为空表示允许所有没有被禁用的命令
+// 和 Redis 的 rename-command 一样，重命名之后的命令只能通过新名字执行，原来的名字按照禁用处理
+type commandFilter struct {
+	allow  map[string]bool
+	deny   map[string]bool
+	rename map[string]string // 原来的名字到新名字
+	alias  map[string]string // 新名字到原来的名字
+}
+
+var commands = new(commandFilter)
+
+// newCommandFilter 校验配置中的命令名字，未知的名字返回错误，避免拼写错误导致命令没有被禁用
+// rename 中的新名字不能为空，不能包含 / 和空白字符，也不能和其他命令的名字或者新名字重复
+func newCommandFilter(allow, deny []string, rename map[string]string) (*commandFilter, error) {
+	f := new(commandFilter)
+	parse := func(names []string) (map[string]bool, error) {
+		if len(names) == 0 {
+			return nil, nil
+		}
+		set := make(map[string]bool, len(names))
+		for _, name := range names {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if !knownCommands[name] {
+				return nil, fmt.Errorf("unknown command %q in command list", name)
+			}
+			set[name] = true
+		}
+		return set, nil
+	}
+
+	var err error
+	f.allow, err = parse(allow)
+	if err != nil {
+		return nil, err
+	}
+	f.deny, err = parse(deny)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, to := range rename {
+		name, to = strings.ToLower(strings.TrimSpace(name)), strings.ToLower(strings.TrimSpace(to))
+		if !knownCommands[name] {
+			return nil, fmt.Errorf("unknown command %q in rename list", name)
+		}
+		if to == "" || strings.ContainsAny(to, "/ \t\r\n") {
+			return nil, fmt.Errorf("invalid new name %q for command %q", to, name)
+		}
+		if knownCommands[to] || f.alias[to] != "" || f.rename[name] != "" {
+			return nil, fmt.Errorf("duplicate name %q in rename list", to)
+		}
+		if f.rename == nil {
+			f.rename, f.alias = make(map[string]string), make(map[string]string)
+		}
+		f.rename[name], f.alias[to] = to, name
+	}
+	return f, nil
+}
+
+// resolve 返回客户端请求的名字对应的命令，被重命名的命令使用原来的名字请求时返回 false
+// 请求中没有命令名字的接口，例如 /{types}/{key} 和 /stats，在命令被重命名之后同样不能访问
+func (f *commandFilter) resolve(name string) (string, bool) {
+	if command, ok := f.alias[name]; ok {
+		return command, true
+	}
+	if _, ok := f.rename[name]; ok {
+		return "", false
+	}
+	return name, true
+}
+
+// enabled 判断命令是否可以执行，同时出现在两个列表中的命令按照禁用处理
+func (f *commandFilter) enabled(command string) bool {
+	if f.deny[command] {
+		return false
+	}
+	return f.allow == nil || f.allow[command]
+}
+
+// requestCommand 返回请求中的命令名字，管理命令是路径中的名字，可能是重命名之后的新名字
+func requestCommand(r *http.Request) string {
+	path := r.URL.Path
+	switch {
+	case path == "/stats":
+		return "stats"
+	case isCommandPath(path):
+		return strings.TrimPrefix(path, "/")
+	case strings.HasPrefix(path, "/debug/"):
+		return "debug"
+	case strings.HasPrefix(path, "/admin/"):
+		return strings.TrimPrefix(path, "/admin/")
+	case strings.HasPrefix(path, "/pubsub/"):
+		if isPatternRequest(r) {
+			return "psubscribe"
+		}
+		if r.Method == http.MethodGet {
+			return "subscribe"
+		}
+		return "publish"
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return "get"
+	case http.MethodDelete:
+		return "delete"
+	default:
+		return "set"
+	}
+}
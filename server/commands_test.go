@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewCommandFilter(t *testing.T) {
+	tests := map[string]struct {
+		allow, deny []string
+		rename      map[string]string
+	}{
+		"unknown allowed":   {allow: []string{"get", "flushall"}},
+		"unknown denied":    {deny: []string{"keys"}},
+		"unknown renamed":   {rename: map[string]string{"flushall": "flush-8f3a"}},
+		"empty new name":    {rename: map[string]string{"shutdown": " "}},
+		"new name path":     {rename: map[string]string{"shutdown": "admin/shutdown"}},
+		"new name space":    {rename: map[string]string{"shutdown": "shut down"}},
+		"new name known":    {rename: map[string]string{"shutdown": "compact"}},
+		"new name repeated": {rename: map[string]string{"shutdown": "op-8f3a", "compact": "op-8f3a"}},
+		"renamed twice":     {rename: map[string]string{"shutdown": "a-8f3a", "SHUTDOWN": "b-8f3a"}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := newCommandFilter(tt.allow, tt.deny, tt.rename); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+
+	// 名字不区分大小写，前后的空白会被忽略
+	f, err := newCommandFilter([]string{" GET ", "Set"}, nil, map[string]string{"Get": " Fetch "})
+	if err != nil {
+		t.Fatalf("failed to create filter: %v", err)
+	}
+	if !f.enabled("get") || !f.enabled("set") || f.enabled("delete") {
+		t.Errorf("expected only get and set to be allowed, got %+v", f.allow)
+	}
+	if command, ok := f.resolve("fetch"); !ok || command != "get" {
+		t.Errorf("expected fetch to resolve to get, got %q %t", command, ok)
+	}
+}
+
+func TestCommandFilterEnabled(t *testing.T) {
+	tests := []struct {
+		name        string
+		allow, deny []string
+		command     string
+		enabled     bool
+	}{
+		{name: "empty lists", command: "shutdown", enabled: true},
+		{name: "denied", deny: []string{"shutdown"}, command: "shutdown", enabled: false},
+		{name: "not denied", deny: []string{"shutdown"}, command: "compact", enabled: true},
+		{name: "allowed", allow: []string{"get"}, command: "get", enabled: true},
+		{name: "not allowed", allow: []string{"get"}, command: "set", enabled: false},
+		{name: "deny beats allow", allow: []string{"get", "set"}, deny: []string{"set"}, command: "set", enabled: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newCommandFilter(tt.allow, tt.deny, nil)
+			if err != nil {
+				t.Fatalf("failed to create filter: %v", err)
+			}
+			if got := f.enabled(tt.command); got != tt.enabled {
+				t.Errorf("expected enabled %t, got %t", tt.enabled, got)
+			}
+		})
+	}
+}
+
+func TestRequestCommand(t *testing.T) {
+	tests := []struct {
+		method, path, command string
+	}{
+		{http.MethodGet, "/table/user-1", "get"},
+		{http.MethodHead, "/table/user-1", "get"},
+		{http.MethodPut, "/table/user-1", "set"},
+		{http.MethodPost, "/table/user-1", "set"},
+		{http.MethodDelete, "/table/user-1", "delete"},
+		{http.MethodPost, "/pipeline", "pipeline"},
+		{http.MethodPost, "/eval", "eval"},
+		{http.MethodPost, "/multi", "multi"},
+		{http.MethodGet, "/pubsub/news", "subscribe"},
+		{http.MethodPost, "/pubsub/news", "publish"},
+		{http.MethodGet, "/pubsub/news.*?pattern=true", "psubscribe"},
+		{http.MethodPost, "/pubsub/news?pattern=true", "publish"},
+		{http.MethodGet, "/stats", "stats"},
+		{http.MethodGet, "/debug/pprof/heap", "debug"},
+		{http.MethodGet, "/admin/audit", "audit"},
+		{http.MethodPost, "/admin/compact", "compact"},
+		{http.MethodPost, "/admin/compact-8f3a", "compact-8f3a"},
+	}
+	for _, tt := range tests {
+		if got := requestCommand(httptest.NewRequest(tt.method, tt.path, nil)); got != tt.command {
+			t.Errorf("requestCommand(%s %s) = %q, want %q", tt.method, tt.path, got, tt.command)
+		}
+	}
+}
+
+func TestDisabledAndRenamedCommands(t *testing.T) {
+	setupStorage(t)
+	filter, err := newCommandFilter(nil, []string{"debug", "delete"}, map[string]string{"compact": "compact-8f3a", "get": "fetch"})
+	if err != nil {
+		t.Fatalf("failed to create filter: %v", err)
+	}
+	commands = filter
+	defer func() { commands = new(commandFilter) }()
+
+	var compacted int
+	saved := adminHandlers.m
+	defer func() { adminHandlers.m = saved }()
+	adminHandlers.m = map[AdminCommand]func(ctx context.Context) error{
+		AdminCompact: func(ctx context.Context) error {
+			compacted++
+			return nil
+		},
+	}
+
+	request := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		rec := httptest.NewRecorder()
+		root.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// 禁用的命令和被重命名的命令原来的名字都返回 command disabled
+	for _, tt := range []struct{ method, path string }{
+		{http.MethodGet, "/debug/runtime"},
+		{http.MethodDelete, "/"},
+		{http.MethodGet, "/"},
+		{http.MethodPost, "/admin/compact"},
+	} {
+		rec := request(tt.method, tt.path)
+		var resp ResponseBody
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if rec.Code != http.StatusForbidden || resp.Message != disabledMessage {
+			t.Errorf("expected %s %s to be disabled, got %d %q", tt.method, tt.path, rec.Code, resp.Message)
+		}
+	}
+	if compacted != 0 {
+		t.Fatal("expected compact not to run under its old name")
+	}
+
+	// 新名字执行原来的命令
+	if rec := request(http.MethodPost, "/admin/compact-8f3a"); rec.Code != http.StatusOK || compacted != 1 {
+		t.Errorf("expected renamed compact to run, got %d", rec.Code)
+	}
+	if rec := request(http.MethodPost, "/admin/unknown-8f3a"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown admin command, got %d", rec.Code)
+	}
+
+	// 批量命令中的每条命令同样按照新名字执行
+	code, results := postCommands(t, "/pipeline", "", pipelineRequest{Commands: []Command{{Cmd: "get", Key: "user-1"}}})
+	if code != http.StatusOK || len(results) != 1 || results[0].Error != disabledMessage {
+		t.Errorf("expected old command name to be disabled in pipeline, got %d: %+v", code, results)
+	}
+	code, results = postCommands(t, "/pipeline", "", pipelineRequest{Commands: []Command{{Cmd: "set", Key: "user-1", Value: "alice"}, {Cmd: "FETCH", Key: "user-1"}}})
+	if code != http.StatusOK || len(results) != 2 || results[1].Value != "alice" {
+		t.Errorf("expected renamed get to read user-1, got %d: %+v", code, results)
+	}
+	code, _ = postCommands(t, "/multi", "", multiRequest{Commands: []Command{{Cmd: "delete", Key: "user-1"}}})
+	if code != http.StatusForbidden {
+		t.Errorf("expected 403 for disabled delete in multi, got %d", code)
+	}
+
+	// 脚本中的步骤没有办法使用新名字，被重命名的命令在脚本中不能执行
+	code, _ = postCommands(t, "/eval", "", scriptRequest{Steps: []Step{{Op: "get", Key: "user-1"}}})
+	if code != http.StatusForbidden {
+		t.Errorf("expected 403 for renamed get in eval, got %d", code)
+	}
+}
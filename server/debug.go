@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+
+	"github.com/auula/wiredkv/vfs"
+	"github.com/gorilla/mux"
+)
+
+// RuntimeInfo 是 /debug/runtime 返回的进程运行时状态
+type RuntimeInfo struct {
+	Goroutines int             `json:"goroutines"`
+	OpenFiles  int             `json:"open_files"` // 不支持统计的平台为 -1
+	HeapAlloc  uint64          `json:"heap_alloc"`
+	HeapInuse  uint64          `json:"heap_inuse"`
+	Sys        uint64          `json:"sys"`
+	NumGC      uint32          `json:"num_gc"`
+	Memory     vfs.MemoryUsage `json:"memory"`
+}
+
+// mountDebug 在 /debug 下挂载 pprof 和运行时状态接口，和其他接口一样需要通过鉴权
+// CPU profile 和 trace 的采样时间不能超过 HTTP 服务器的 WriteTimeout
+func mountDebug(router *mux.Router) {
+	debug := router.PathPrefix("/debug").Subrouter()
+	debug.HandleFunc("/runtime", runtimeController).Methods("GET")
+	debug.HandleFunc("/pprof/cmdline", pprof.Cmdline)
+	debug.HandleFunc("/pprof/profile", pprof.Profile)
+	debug.HandleFunc("/pprof/symbol", pprof.Symbol)
+	debug.HandleFunc("/pprof/trace", pprof.Trace)
+	// Index 同时处理 heap、goroutine 等通过名称访问的 profile
+	debug.PathPrefix("/pprof/").HandlerFunc(pprof.Index)
+}
+
+// runtimeController 返回 goroutine 数量、打开的文件数量和内存索引、缓存占用的内存
+func runtimeController(w http.ResponseWriter, r *http.Request) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	info := RuntimeInfo{
+		Goroutines: runtime.NumGoroutine(),
+		OpenFiles:  openFiles(),
+		HeapAlloc:  stats.HeapAlloc,
+		HeapInuse:  stats.HeapInuse,
+		Sys:        stats.Sys,
+		NumGC:      stats.NumGC,
+	}
+	if storage != nil {
+		info.Memory = storage.Memory()
+	}
+
+	okResponse(w, http.StatusOK, []interface{}{info}, "request processed successfully!")
+}
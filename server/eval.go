@@ -0,0 +1,257 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/auula/wiredkv/vfs"
+)
+
+// 一个脚本最多包含的步骤数
+const maxScriptSteps = 100
+
+// Step 是 /eval 脚本中的一个步骤，所有步骤在同一个事务中执行，任何一步失败都不会写入数据：
+//
+//	get    读取 Key
+//	set    写入 Value，TTL 是过期的秒数
+//	delete 删除 Key
+//	incr   把 Key 的整数值加上 By，Key 不存在时从 0 开始
+//	push   把 Value 追加到 JSON 字符串数组的末尾，Key 不存在时创建数组
+//	pop    弹出 JSON 字符串数组的最后一个元素，To 不为空时追加到 To 的数组末尾，数组为空时返回 nil
+//	expect Key 的当前值不等于 Value 时放弃整个脚本，Key 不存在时同样放弃
+//
+// 后面的步骤可以读到前面步骤的写入，例如 {"op": "pop", "key": "queue:a", "to": "queue:b"} 原子的把一个元素移动到另一个列表
+type Step struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	To    string `json:"to,omitempty"`
+	Value string `json:"value,omitempty"`
+	By    int64  `json:"by,omitempty"`
+	TTL   uint64 `json:"ttl,omitempty"`
+}
+
+// scriptRequest 是 /eval 的请求体
+type scriptRequest struct {
+	Steps []Step `json:"steps"`
+}
+
+var (
+	// errScriptAborted 表示 expect 步骤的检查没有通过
+	errScriptAborted = errors.New("script aborted")
+	// errScriptValue 表示 Key 的值不是步骤需要的整数或者 JSON 字符串数组
+	errScriptValue = errors.New("value has wrong type")
+)
+
+// stepCommand 返回步骤对应的数据命令名字和需要的权限，禁用和重命名的数据命令在脚本中同样不能执行
+func stepCommand(op string) (string, Operation, error) {
+	switch op {
+	case "get", "expect":
+		return "get", OpRead, nil
+	case "delete":
+		return "delete", OpWrite, nil
+	case "set", "incr", "push", "pop":
+		return "set", OpWrite, nil
+	default:
+		return "", 0, fmt.Errorf("unknown script op %q", op)
+	}
+}
+
+// checkScript 执行之前检查每个步骤读写的 Key，所有步骤都通过检查之后才开始事务
+func checkScript(r *http.Request, steps []Step) error {
+	for i := range steps {
+		s := &steps[i]
+		s.Op = strings.ToLower(s.Op)
+		cmd, op, err := stepCommand(s.Op)
+		if _, ok := commands.resolve(cmd); err == nil && !ok {
+			err = errCommandDisabled
+		}
+		if err == nil {
+			err = checkKey(r, cmd, op, s.Key)
+		}
+		if err == nil && s.Op == "pop" && s.To != "" {
+			err = checkKey(r, cmd, op, s.To)
+		}
+		if err != nil {
+			return fmt.Errorf("step %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// scriptState 是脚本执行过程中的 Key，事务的写入在提交之前对 Txn.Get 不可见，后面的步骤从这里读到前面的写入
+type scriptState struct {
+	tx     *vfs.Txn
+	values map[uint64]*string // 按照 KeyTransform 转换之后的 inum 保存，值为 nil 表示 Key 不存在或者已经被删除
+}
+
+func (s *scriptState) get(key string) (*string, error) {
+	inum := storage.InodeNum(key)
+	if value, ok := s.values[inum]; ok {
+		return value, nil
+	}
+	seg, err := s.tx.Get(inum)
+	if errors.Is(err, vfs.ErrSegmentNotFound) {
+		s.values[inum] = nil
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	value := string(seg.Value)
+	s.values[inum] = &value
+	return &value, nil
+}
+
+func (s *scriptState) set(key, value string, ttl uint64) error {
+	seg, err := vfs.NewSegmentBytes(key, vfs.Binary, []byte(value), ttl)
+	if err != nil {
+		return err
+	}
+	inum := storage.InodeNum(key)
+	s.tx.AddSegment(inum, *seg)
+	s.values[inum] = &value
+	return nil
+}
+
+func (s *scriptState) delete(key string) {
+	s.tx.DelSegment(key)
+	s.values[storage.InodeNum(key)] = nil
+}
+
+// list 读取 JSON 字符串数组，Key 不存在时返回空数组
+func (s *scriptState) list(key string) ([]string, error) {
+	value, err := s.get(key)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	var items []string
+	if err := json.Unmarshal([]byte(*value), &items); err != nil {
+		return nil, fmt.Errorf("%w: %q is not a list", errScriptValue, key)
+	}
+	return items, nil
+}
+
+func (s *scriptState) setList(key string, items []string, ttl uint64) error {
+	data, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+	return s.set(key, string(data), ttl)
+}
+
+// run 执行一个步骤，返回这个步骤的结果
+func (s *scriptState) run(step *Step) (CommandResult, error) {
+	switch step.Op {
+	case "get":
+		value, err := s.get(step.Key)
+		if err != nil || value == nil {
+			return CommandResult{Nil: value == nil}, err
+		}
+		return CommandResult{Value: *value}, nil
+	case "set":
+		return CommandResult{}, s.set(step.Key, step.Value, step.TTL)
+	case "delete":
+		s.delete(step.Key)
+		return CommandResult{}, nil
+	case "incr":
+		value, err := s.get(step.Key)
+		if err != nil {
+			return CommandResult{}, err
+		}
+		var n int64
+		if value != nil {
+			n, err = strconv.ParseInt(*value, 10, 64)
+			if err != nil {
+				return CommandResult{}, fmt.Errorf("%w: %q is not an integer", errScriptValue, step.Key)
+			}
+		}
+		result := strconv.FormatInt(n+step.By, 10)
+		return CommandResult{Value: result}, s.set(step.Key, result, step.TTL)
+	case "push":
+		items, err := s.list(step.Key)
+		if err != nil {
+			return CommandResult{}, err
+		}
+		return CommandResult{}, s.setList(step.Key, append(items, step.Value), step.TTL)
+	case "pop":
+		items, err := s.list(step.Key)
+		if err != nil || len(items) == 0 {
+			return CommandResult{Nil: err == nil}, err
+		}
+		last := items[len(items)-1]
+		if err := s.setList(step.Key, items[:len(items)-1], step.TTL); err != nil {
+			return CommandResult{}, err
+		}
+		if step.To != "" {
+			to, err := s.list(step.To)
+			if err != nil {
+				return CommandResult{}, err
+			}
+			if err := s.setList(step.To, append(to, last), step.TTL); err != nil {
+				return CommandResult{}, err
+			}
+		}
+		return CommandResult{Value: last}, nil
+	default:
+		value, err := s.get(step.Key)
+		if err != nil {
+			return CommandResult{}, err
+		}
+		if value == nil || *value != step.Value {
+			return CommandResult{}, fmt.Errorf("%w: %q does not match", errScriptAborted, step.Key)
+		}
+		return CommandResult{Value: *value}, nil
+	}
+}
+
+// evalController 在一个事务中原子的执行脚本中的所有步骤，读取过的 Key 在提交之前被修改时重新执行整个脚本
+// 脚本只能使用 Step 中固定的操作，没有循环和函数调用，执行的时间和步骤数成正比
+func evalController(w http.ResponseWriter, r *http.Request) {
+	if storage == nil {
+		okResponse(w, http.StatusServiceUnavailable, nil, "file storage system is not initialized")
+		return
+	}
+
+	var req scriptRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		okResponse(w, http.StatusBadRequest, nil, fmt.Sprintf("failed to parse script: %v", err))
+		return
+	}
+	if len(req.Steps) == 0 || len(req.Steps) > maxScriptSteps {
+		okResponse(w, http.StatusBadRequest, nil, fmt.Sprintf("steps must contain between 1 and %d entries", maxScriptSteps))
+		return
+	}
+	if err := checkScript(r, req.Steps); err != nil {
+		okResponse(w, checkStatus(err), nil, err.Error())
+		return
+	}
+
+	var results []interface{}
+	err := storage.Update(func(tx *vfs.Txn) error {
+		state := &scriptState{tx: tx, values: make(map[uint64]*string)}
+		results = make([]interface{}, len(req.Steps))
+		for i := range req.Steps {
+			result, err := state.run(&req.Steps[i])
+			if err != nil {
+				return fmt.Errorf("step %d: %w", i+1, err)
+			}
+			results[i] = result
+		}
+		return nil
+	})
+
+	switch {
+	case err == nil:
+		okResponse(w, http.StatusOK, results, "script executed successfully!")
+	case errors.Is(err, errScriptAborted), errors.Is(err, vfs.ErrTxnConflict), errors.Is(err, vfs.ErrTxnUnversioned):
+		okResponse(w, http.StatusConflict, nil, err.Error())
+	case errors.Is(err, errScriptValue):
+		okResponse(w, http.StatusBadRequest, nil, err.Error())
+	default:
+		okResponse(w, http.StatusInternalServerError, nil, err.Error())
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	setupStorage(t)
+
+	eval := func(steps ...Step) (int, []CommandResult) {
+		t.Helper()
+		return postCommands(t, "/eval", "", scriptRequest{Steps: steps})
+	}
+
+	code, results := eval(
+		Step{Op: "push", Key: "queue:a", Value: "job-1"},
+		Step{Op: "push", Key: "queue:a", Value: "job-2"},
+		Step{Op: "incr", Key: "jobs", By: 2},
+	)
+	if code != http.StatusOK || len(results) != 3 || results[2].Value != "2" {
+		t.Fatalf("expected script to succeed, got %d: %+v", code, results)
+	}
+
+	// 从 queue:a 弹出的元素原子的追加到 queue:b，后面的步骤读到前面步骤的写入
+	code, results = eval(
+		Step{Op: "pop", Key: "queue:a", To: "queue:b"},
+		Step{Op: "incr", Key: "jobs", By: -1},
+		Step{Op: "get", Key: "queue:a"},
+		Step{Op: "get", Key: "queue:b"},
+	)
+	if code != http.StatusOK || len(results) != 4 {
+		t.Fatalf("expected script to succeed, got %d: %+v", code, results)
+	}
+	if results[0].Value != "job-2" || results[1].Value != "1" || results[2].Value != `["job-1"]` || results[3].Value != `["job-2"]` {
+		t.Errorf("expected job-2 to move to queue:b, got %+v", results)
+	}
+
+	// expect 没有通过时前面步骤的写入也不会生效
+	code, _ = eval(
+		Step{Op: "pop", Key: "queue:a", To: "queue:b"},
+		Step{Op: "expect", Key: "jobs", Value: "5"},
+	)
+	if code != http.StatusConflict {
+		t.Errorf("expected 409 for failed expect, got %d", code)
+	}
+	_, results = eval(Step{Op: "get", Key: "queue:a"}, Step{Op: "pop", Key: "empty"}, Step{Op: "expect", Key: "jobs", Value: "1"})
+	if len(results) != 3 || results[0].Value != `["job-1"]` || !results[1].Nil || results[2].Value != "1" {
+		t.Errorf("expected aborted script not to write, got %+v", results)
+	}
+
+	for name, steps := range map[string][]Step{
+		"wrong type": {{Op: "pop", Key: "jobs"}},
+		"unknown op": {{Op: "rename", Key: "jobs"}},
+		"no key":     {{Op: "get"}},
+		"no steps":   nil,
+	} {
+		if code, _ := eval(steps...); code != http.StatusBadRequest {
+			t.Errorf("expected 400 for %s, got %d", name, code)
+		}
+	}
+}
+
+func TestEvalAuthorize(t *testing.T) {
+	setupStorage(t)
+	acl, err := ParseACL([]byte(`{"users": {
+		"alice": {"password": "alice-password", "grants": {"kv/queue:a": "write", "kv": "read"}}
+	}}`))
+	if err != nil {
+		t.Fatalf("failed to parse acl: %v", err)
+	}
+	authorizer = acl
+	defer func() { authorizer = nil }()
+
+	// pop 的目标 Key 也需要写权限，任何一个步骤没有权限时整个脚本都不会执行
+	code, _ := postCommands(t, "/eval", "alice", scriptRequest{Steps: []Step{
+		{Op: "push", Key: "queue:a", Value: "job-1"},
+		{Op: "pop", Key: "queue:a", To: "queue:b"},
+	}})
+	if code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", code)
+	}
+	_, results := postCommands(t, "/eval", "alice", scriptRequest{Steps: []Step{{Op: "get", Key: "queue:a"}}})
+	if len(results) != 1 || !results[0].Nil {
+		t.Errorf("expected rejected script not to write, got %+v", results)
+	}
+}
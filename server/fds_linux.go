@@ -0,0 +1,13 @@
+package server
+
+import "os"
+
+// openFiles 返回进程打开的文件描述符数量
+func openFiles() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	// 读取目录本身也会打开一个文件描述符
+	return len(entries) - 1
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package server
+
+// openFiles 其他平台不统计打开的文件描述符数量
+func openFiles() int {
+	return -1
+}
@@ -1,9 +1,12 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/auula/wiredkv/clog"
@@ -16,6 +19,7 @@ const version = "wiredkv/0.1.1"
 var (
 	root         *mux.Router
 	authPassword string
+	authorizer   Authorizer
 	allowIpList  []string
 	allowMethod  = []string{"GET", "POST", "DELETE", "PUT"}
 )
@@ -28,6 +32,13 @@ var (
 func init() {
 	root = mux.NewRouter()
 	root.Use(authMiddleware)
+	root.HandleFunc("/healthz", healthController).Methods("GET")
+	root.HandleFunc("/readyz", readyController).Methods("GET")
+	root.HandleFunc("/stats", statsController).Methods("GET")
+	mountDebug(root)
+	mountAdmin(root)
+	mountPubSub(root)
+	mountCommands(root)
 	root.HandleFunc("/", action).Methods(allowMethod...)
 }
 
@@ -63,6 +74,33 @@ func action(w http.ResponseWriter, r *http.Request) {
 	okResponse(w, http.StatusOK, tables, "request processed successfully!")
 }
 
+// statsController 返回存储引擎的统计信息，包括压缩效果和最近的压缩记录
+func statsController(w http.ResponseWriter, r *http.Request) {
+	if storage == nil {
+		okResponse(w, http.StatusServiceUnavailable, nil, "file storage system is not initialized")
+		return
+	}
+	okResponse(w, http.StatusOK, []interface{}{storage.Stats()}, "request processed successfully!")
+}
+
+// healthController 只要进程能处理 HTTP 请求就返回 200，用于 Kubernetes 的 liveness 探针
+func healthController(w http.ResponseWriter, r *http.Request) {
+	okResponse(w, http.StatusOK, nil, "ok")
+}
+
+// readyController 存储引擎完成恢复并且可以正常写入时返回 200，用于 Kubernetes 的 readiness 探针
+func readyController(w http.ResponseWriter, r *http.Request) {
+	if storage == nil {
+		okResponse(w, http.StatusServiceUnavailable, nil, "file storage system is not initialized")
+		return
+	}
+	if err := storage.Ready(); err != nil {
+		okResponse(w, http.StatusServiceUnavailable, nil, err.Error())
+		return
+	}
+	okResponse(w, http.StatusOK, nil, "ok")
+}
+
 func unauthorizedResponse(w http.ResponseWriter, message string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Server", version)
@@ -82,8 +120,14 @@ func unauthorizedResponse(w http.ResponseWriter, message string) {
 // 中间件函数，进行 BasicAuth 鉴权
 func authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 探针不会携带密码，也不会返回任何数据
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		authHeader := r.Header.Get("auth")
-		clog.Debugf("HTTP request header authorization: %v", r.Header)
+		clog.Debugf("HTTP request %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
 
 		// 获取客户端 IP 地址
 		ip := r.Header.Get("X-Forwarded-For")
@@ -97,14 +141,72 @@ func authMiddleware(next http.Handler) http.Handler {
 			}
 		}
 
-		// 检查认证
-		if authHeader != authPassword {
+		// 连续认证失败的客户端在退避结束之前直接拒绝，不再比较密码
+		client := clientAddr(r.RemoteAddr)
+		now := time.Now()
+		if wait, ok := limiter.allow(client, now); !ok {
+			clog.Warnf("Too many failed authentication attempts from client %s", client)
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			okResponse(w, http.StatusTooManyRequests, nil, "too many failed authentication attempts!")
+			return
+		}
+
+		// 检查认证，Authorizer 实现了 Authenticator 时由它校验每个用户自己的密码
+		identity := r.Header.Get("user")
+		var authorized bool
+		if a, ok := authorizer.(Authenticator); ok {
+			authorized = a.Authenticate(identity, authHeader)
+		} else {
+			authorized = verifyPassword(authHeader, authPassword)
+		}
+		if !authorized {
+			limiter.fail(client, now)
 			clog.Warnf("Unauthorized access attempt from client %s", ip)
 			unauthorizedResponse(w, "access not authorised!")
 			return
 		}
+		limiter.succeed(client)
+
+		// 配置中禁用的命令对所有客户端都不可用，不需要再检查权限
+		command, ok := commands.resolve(requestCommand(r))
+		if !ok || !commands.enabled(command) {
+			clog.Warnf("Disabled command %s requested by client %s", command, ip)
+			okResponse(w, http.StatusForbidden, nil, disabledMessage)
+			return
+		}
+
+		// 批量命令接口在处理函数中按照每条命令的 Key 检查权限
+		if authorizer != nil && !isCommandPath(r.URL.Path) {
+			op, bucket, key := requestOperation(r)
+			if err := authorizer.Authorize(identity, op, bucket, key); err != nil {
+				if r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/admin/") {
+					auditAdmin(identity, client, command, "forbidden")
+				}
+				clog.Warnf("Forbidden %s request from client %s: %v", op, ip, err)
+				okResponse(w, http.StatusForbidden, nil, "access forbidden!")
+				return
+			}
+		}
 
 		clog.Infof("Client %s authorized successfully", ip)
-		next.ServeHTTP(w, r)
+		// 集群模式中由其他节点负责的 Key 在通过鉴权之后重定向，不会向未认证的客户端暴露槽位分配
+		if redirectMoved(w, r) {
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, identity)))
 	})
 }
+
+// requestOperation 返回请求需要的权限和访问的 bucket、key，/{types}/{key} 之外的接口都是管理操作
+// 发布订阅接口的 bucket 是 pubsub，key 是频道名字或者订阅的模式，订阅需要读权限，发布需要写权限
+func requestOperation(r *http.Request) (Operation, string, string) {
+	if isAdminPath(r.URL.Path) {
+		return OpAdmin, "", r.URL.Path
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return OpRead, bucket, key
+	}
+	return OpWrite, bucket, key
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/auula/wiredkv/clog"
+)
+
+// limitListener 最多同时保持 max 个连接，达到上限之后 Accept 阻塞到已有的连接关闭
+// 连接在内核的 backlog 中排队，不会为它们分配文件描述符
+type limitListener struct {
+	net.Listener
+	sem  chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func newLimitListener(l net.Listener, max int) net.Listener {
+	if max <= 0 {
+		return l
+	}
+	return &limitListener{Listener: l, sem: make(chan struct{}, max), done: make(chan struct{})}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	select {
+	case l.sem <- struct{}{}:
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		<-l.sem
+		return nil, err
+	}
+	return &limitConn{Conn: conn, release: func() { <-l.sem }}, nil
+}
+
+func (l *limitListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// limitConn 关闭时归还连接数，重复关闭只归还一次
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
+
+// limitBody 限制请求体的大小，超过 max 字节之后读取返回错误，处理函数不会把过大的请求读入内存
+func limitBody(next http.Handler, max int64) http.Handler {
+	if max <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > max {
+			okResponse(w, http.StatusRequestEntityTooLarge, nil, "request body too large!")
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// connTracker 通过 http.Server.ConnState 记录每个连接的状态和进入这个状态的时间
+// http.Server 只在连接等待下一个请求时检查 IdleTimeout，定期回收可以清理超时之后仍然没有关闭的空闲连接
+type connTracker struct {
+	mu    sync.Mutex
+	conns map[net.Conn]trackedConn
+	done  chan struct{}
+	exit  chan struct{}
+}
+
+type trackedConn struct {
+	state http.ConnState
+	since time.Time
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{conns: make(map[net.Conn]trackedConn)}
+}
+
+// hook 是 http.Server.ConnState 的回调
+func (t *connTracker) hook(conn net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch state {
+	case http.StateHijacked, http.StateClosed:
+		delete(t.conns, conn)
+	default:
+		t.conns[conn] = trackedConn{state: state, since: time.Now()}
+	}
+}
+
+// reap 关闭空闲超过 idle 的连接，返回关闭的连接数
+func (t *connTracker) reap(now time.Time, idle time.Duration) int {
+	var stale []net.Conn
+	t.mu.Lock()
+	for conn, c := range t.conns {
+		if c.state == http.StateIdle && now.Sub(c.since) >= idle {
+			stale = append(stale, conn)
+			delete(t.conns, conn)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, conn := range stale {
+		_ = conn.Close()
+	}
+	return len(stale)
+}
+
+// start 每隔 interval 回收一次空闲超过 idle 的连接
+func (t *connTracker) start(interval, idle time.Duration) {
+	if interval <= 0 || idle <= 0 {
+		return
+	}
+	t.done = make(chan struct{})
+	t.exit = make(chan struct{})
+	go func() {
+		defer close(t.exit)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				if n := t.reap(now, idle); n > 0 {
+					clog.Debugf("Reaped %d idle connections", n)
+				}
+			case <-t.done:
+				return
+			}
+		}
+	}()
+}
+
+// stop 停止定期回收并且等待 goroutine 退出
+func (t *connTracker) stop() {
+	if t.done == nil {
+		return
+	}
+	close(t.done)
+	<-t.exit
+	t.done = nil
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimitListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	if newLimitListener(ln, 0) != ln {
+		t.Error("expected no limit for max 0")
+	}
+	l := newLimitListener(ln, 2)
+	defer l.Close()
+
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		defer conn.Close()
+	}
+
+	accept := func() <-chan net.Conn {
+		ch := make(chan net.Conn, 1)
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				close(ch)
+				return
+			}
+			ch <- conn
+		}()
+		return ch
+	}
+	first, second := <-accept(), <-accept()
+	if first == nil || second == nil {
+		t.Fatal("expected first two connections to be accepted")
+	}
+
+	// 达到上限之后 Accept 阻塞，直到已有的连接关闭
+	third := accept()
+	select {
+	case <-third:
+		t.Fatal("expected third accept to block at the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	first.Close()
+	first.Close()
+	select {
+	case conn := <-third:
+		if conn == nil {
+			t.Fatal("expected third connection to be accepted")
+		}
+		defer conn.Close()
+	case <-time.After(time.Second):
+		t.Fatal("expected third accept to return after a connection closed")
+	}
+	// 重复关闭只归还一次连接数
+	if n := len(l.(*limitListener).sem); n != 2 {
+		t.Errorf("expected 2 connections in use, got %d", n)
+	}
+
+	// 关闭监听之后阻塞的 Accept 返回 net.ErrClosed
+	blocked := make(chan error, 1)
+	go func() {
+		_, err := l.Accept()
+		blocked <- err
+	}()
+	l.Close()
+	select {
+	case err := <-blocked:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("expected net.ErrClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected blocked accept to return after close")
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	handler := limitBody(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		}
+	}), 8)
+
+	tests := []struct {
+		name   string
+		body   string
+		length int64
+		code   int
+	}{
+		{name: "within limit", body: "12345678", length: 8, code: http.StatusOK},
+		{name: "content length too large", body: "123456789", length: 9, code: http.StatusRequestEntityTooLarge},
+		{name: "chunked body too large", body: "123456789", length: -1, code: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/pipeline", strings.NewReader(tt.body))
+			req.ContentLength = tt.length
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.code {
+				t.Errorf("expected %d, got %d", tt.code, rec.Code)
+			}
+		})
+	}
+}
+
+func TestConnTrackerReap(t *testing.T) {
+	tracker := newConnTracker()
+	now := time.Now()
+	idle, active, recent := newPipeConn(t), newPipeConn(t), newPipeConn(t)
+	tracker.conns[idle] = trackedConn{state: http.StateIdle, since: now.Add(-time.Minute)}
+	tracker.conns[active] = trackedConn{state: http.StateActive, since: now.Add(-time.Minute)}
+	tracker.conns[recent] = trackedConn{state: http.StateIdle, since: now}
+
+	// 只回收空闲超过 idle 的连接，正在处理请求的连接不会被关闭
+	if n := tracker.reap(now, time.Second); n != 1 {
+		t.Fatalf("expected 1 connection reaped, got %d", n)
+	}
+	if _, ok := tracker.conns[idle]; ok {
+		t.Error("expected idle connection to be removed")
+	}
+	if _, err := idle.Write([]byte("x")); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("expected idle connection to be closed, got %v", err)
+	}
+	if len(tracker.conns) != 2 {
+		t.Errorf("expected active and recent connections to be kept, got %d", len(tracker.conns))
+	}
+
+	tracker.hook(active, http.StateClosed)
+	tracker.hook(recent, http.StateHijacked)
+	if len(tracker.conns) != 0 {
+		t.Errorf("expected closed and hijacked connections to be untracked, got %d", len(tracker.conns))
+	}
+}
+
+func TestConnTrackerStart(t *testing.T) {
+	tracker := newConnTracker()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Config.ConnState = tracker.hook
+	srv.Start()
+	defer srv.Close()
+
+	tracker.start(10*time.Millisecond, 50*time.Millisecond)
+	defer tracker.stop()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"); err != nil {
+		t.Fatalf("failed to write request: %v", err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	resp.Body.Close()
+
+	// Keep-Alive 连接空闲超过 idle 之后被服务端关闭
+	start := time.Now()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Fatalf("expected idle connection to be closed by the reaper, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("expected connection to stay open until idle timeout, closed after %s", elapsed)
+	}
+
+	// 停止之后可以重复调用 stop
+	tracker.stop()
+	tracker.stop()
+}
+
+// newPipeConn 返回一个内存中的连接，测试结束之后关闭
+func newPipeConn(t *testing.T) net.Conn {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return server
+}
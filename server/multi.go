@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/auula/wiredkv/vfs"
+)
+
+// Watch 是 /multi 中监视的 Key 和客户端之前读取到的版本号，Version 为 0 表示 Key 在读取时不存在
+type Watch struct {
+	Key     string `json:"key"`
+	Version uint64 `json:"version"`
+}
+
+// multiRequest 是 /multi 的请求体，Commands 相当于 MULTI 和 EXEC 之间排队的命令
+type multiRequest struct {
+	Watch    []Watch   `json:"watch,omitempty"`
+	Commands []Command `json:"commands"`
+}
+
+// errWatchFailed 表示监视的 Key 在客户端读取之后被修改，事务没有执行
+var errWatchFailed = errors.New("watched key modified")
+
+// watchCurrent 检查监视的 Key 的版本号有没有变化，检查在事务中执行，提交之前被修改时会重新检查
+func (s *scriptState) watchCurrent(w *Watch) error {
+	seg, err := s.tx.Get(storage.InodeNum(w.Key))
+	if errors.Is(err, vfs.ErrSegmentNotFound) {
+		if w.Version != 0 {
+			return fmt.Errorf("%w: %q", errWatchFailed, w.Key)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if seg.Version != w.Version {
+		return fmt.Errorf("%w: %q", errWatchFailed, w.Key)
+	}
+	return nil
+}
+
+// multiController 和 Redis 的 MULTI/EXEC 一样原子的执行一组命令，所有命令的写入一起提交
+// HTTP 请求没有连接状态，客户端在本地排队命令之后一次发送，相当于 EXEC，不发送就相当于 DISCARD
+// Watch 相当于 WATCH，Version 是之前通过 get 读取到的版本号，任何一个 Key 被修改之后整个事务都不会执行
+func multiController(w http.ResponseWriter, r *http.Request) {
+	if storage == nil {
+		okResponse(w, http.StatusServiceUnavailable, nil, "file storage system is not initialized")
+		return
+	}
+
+	var req multiRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		okResponse(w, http.StatusBadRequest, nil, fmt.Sprintf("failed to parse commands: %v", err))
+		return
+	}
+	if len(req.Commands) == 0 || len(req.Commands) > maxPipelineCommands {
+		okResponse(w, http.StatusBadRequest, nil, fmt.Sprintf("commands must contain between 1 and %d entries", maxPipelineCommands))
+		return
+	}
+	if len(req.Watch) > maxPipelineCommands {
+		okResponse(w, http.StatusBadRequest, nil, fmt.Sprintf("watch must contain at most %d entries", maxPipelineCommands))
+		return
+	}
+
+	// 和 Redis 不同，排队的命令有错误时整个事务都不会执行
+	for i := range req.Commands {
+		if err := checkCommand(r, &req.Commands[i]); err != nil {
+			okResponse(w, checkStatus(err), nil, fmt.Sprintf("command %d: %v", i+1, err))
+			return
+		}
+		// 事务中的命令和脚本一样按照字符串读写，不支持 Type 和 Encoding
+		if req.Commands[i].Type != "" || req.Commands[i].Encoding != "" {
+			okResponse(w, http.StatusBadRequest, nil, fmt.Sprintf("command %d: type and encoding are only supported by /pipeline", i+1))
+			return
+		}
+	}
+	for i := range req.Watch {
+		if err := checkKey(r, "get", OpRead, req.Watch[i].Key); err != nil {
+			okResponse(w, checkStatus(err), nil, fmt.Sprintf("watch %d: %v", i+1, err))
+			return
+		}
+	}
+
+	var results []interface{}
+	err := storage.Update(func(tx *vfs.Txn) error {
+		state := &scriptState{tx: tx, values: make(map[uint64]*string)}
+		for i := range req.Watch {
+			if err := state.watchCurrent(&req.Watch[i]); err != nil {
+				return err
+			}
+		}
+		results = make([]interface{}, len(req.Commands))
+		for i, c := range req.Commands {
+			result, err := state.run(&Step{Op: c.Cmd, Key: c.Key, Value: c.Value, TTL: c.TTL})
+			if err != nil {
+				return fmt.Errorf("command %d: %w", i+1, err)
+			}
+			results[i] = result
+		}
+		return nil
+	})
+
+	switch {
+	case err == nil:
+		okResponse(w, http.StatusOK, results, "transaction committed successfully!")
+	case errors.Is(err, errWatchFailed), errors.Is(err, vfs.ErrTxnConflict), errors.Is(err, vfs.ErrTxnUnversioned):
+		okResponse(w, http.StatusConflict, nil, err.Error())
+	default:
+		okResponse(w, http.StatusInternalServerError, nil, err.Error())
+	}
+}
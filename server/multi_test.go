@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMulti(t *testing.T) {
+	setupStorage(t)
+
+	code, results := postCommands(t, "/multi", "", multiRequest{Commands: []Command{
+		{Cmd: "set", Key: "account:a", Value: "90"},
+		{Cmd: "set", Key: "account:b", Value: "10"},
+		{Cmd: "get", Key: "account:a"},
+	}})
+	if code != http.StatusOK || len(results) != 3 || results[2].Value != "90" {
+		t.Fatalf("expected transaction to commit, got %d: %+v", code, results)
+	}
+
+	_, results = postCommands(t, "/pipeline", "", pipelineRequest{Commands: []Command{{Cmd: "get", Key: "account:a"}}})
+	version := results[0].Version
+
+	// 监视的 Key 没有变化时提交，被修改之后整个事务都不会执行
+	transfer := multiRequest{
+		Watch: []Watch{{Key: "account:a", Version: version}, {Key: "account:c"}},
+		Commands: []Command{
+			{Cmd: "set", Key: "account:a", Value: "80"},
+			{Cmd: "set", Key: "account:b", Value: "20"},
+		},
+	}
+	if code, _ := postCommands(t, "/multi", "", transfer); code != http.StatusOK {
+		t.Fatalf("expected watched transaction to commit, got %d", code)
+	}
+	if code, _ := postCommands(t, "/multi", "", transfer); code != http.StatusConflict {
+		t.Fatalf("expected 409 after watched key changed, got %d", code)
+	}
+	_, results = postCommands(t, "/pipeline", "", pipelineRequest{Commands: []Command{
+		{Cmd: "set", Key: "account:c", Value: "0"},
+		{Cmd: "get", Key: "account:a"},
+	}})
+	transfer.Watch[0].Version = results[1].Version
+	if code, _ := postCommands(t, "/multi", "", transfer); code != http.StatusConflict {
+		t.Fatalf("expected 409 after watched missing key was created, got %d", code)
+	}
+
+	// 排队的命令有错误时其他命令也不会执行
+	code, _ = postCommands(t, "/multi", "", multiRequest{Commands: []Command{
+		{Cmd: "delete", Key: "account:a"},
+		{Cmd: "rename", Key: "account:b"},
+	}})
+	if code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown command, got %d", code)
+	}
+	_, results = postCommands(t, "/pipeline", "", pipelineRequest{Commands: []Command{{Cmd: "get", Key: "account:a"}, {Cmd: "get", Key: "account:b"}}})
+	if results[0].Value != "80" || results[1].Value != "20" {
+		t.Errorf("expected accounts 80 and 20, got %+v", results)
+	}
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/auula/wiredkv/vfs"
+	"github.com/gorilla/mux"
+)
+
+// 批量接口中的 Key 都属于 kv bucket，ACL 通过 "kv" 或者 "kv/key前缀" 授权
+const kvBucket = "kv"
+
+// 一个批量请求最多包含的命令数
+const maxPipelineCommands = 1000
+
+// Command 是批量接口中的一条数据命令，Cmd 是 get、set 或者 delete
+// Value 按照二进制数据原样保存，TTL 是过期的秒数，0 表示永不过期
+// Type 是 set 写入的数据类型，名字和 vfs.Kind.String 相同，空表示 binary，Value 需要是这个类型的编码
+// Encoding 为 base64 时 set 的 Value 和 get 返回的 Value 都是 base64 编码，用于不是合法 UTF-8 的数据
+type Command struct {
+	Cmd      string `json:"cmd"`
+	Key      string `json:"key"`
+	Value    string `json:"value,omitempty"`
+	TTL      uint64 `json:"ttl,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// CommandResult 是一条命令的执行结果，Nil 表示 get 的 Key 不存在，Error 不为空表示命令执行失败
+type CommandResult struct {
+	Value   string `json:"value,omitempty"`
+	Version uint64 `json:"version,omitempty"`
+	Nil     bool   `json:"nil,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// pipelineRequest 是批量接口的请求体
+type pipelineRequest struct {
+	Commands []Command `json:"commands"`
+}
+
+// mountCommands 挂载批量命令接口，命令中的每个 Key 由处理函数单独鉴权
+func mountCommands(router *mux.Router) {
+	router.HandleFunc("/pipeline", pipelineController).Methods("POST")
+	router.HandleFunc("/eval", evalController).Methods("POST")
+	router.HandleFunc("/multi", multiController).Methods("POST")
+}
+
+// isCommandPath 判断请求是不是批量命令接口，这些请求在处理函数中按照每条命令的 Key 检查权限
+func isCommandPath(path string) bool {
+	return path == "/pipeline" || path == "/eval" || path == "/multi"
+}
+
+// decodeCommands 解析请求体中的命令列表，命令数量必须在 1 到 maxPipelineCommands 之间
+func decodeCommands(r *http.Request) ([]Command, error) {
+	var req pipelineRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse commands: %w", err)
+	}
+	if len(req.Commands) == 0 || len(req.Commands) > maxPipelineCommands {
+		return nil, fmt.Errorf("commands must contain between 1 and %d entries", maxPipelineCommands)
+	}
+	return req.Commands, nil
+}
+
+// commandOperation 返回数据命令需要的权限
+func commandOperation(cmd string) (Operation, error) {
+	switch cmd {
+	case "get":
+		return OpRead, nil
+	case "set", "delete":
+		return OpWrite, nil
+	default:
+		return 0, fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+// checkCommand 把重命名之后的命令名字转换成原来的名字，检查命令是否被禁用、客户端有没有 Key 的权限，以及 Key 是否由当前节点负责
+func checkCommand(r *http.Request, c *Command) error {
+	cmd, ok := commands.resolve(strings.ToLower(c.Cmd))
+	if !ok {
+		return errCommandDisabled
+	}
+	c.Cmd = cmd
+	op, err := commandOperation(c.Cmd)
+	if err != nil {
+		return err
+	}
+	if c.Encoding != "" && c.Encoding != "base64" {
+		return fmt.Errorf("unknown value encoding %q", c.Encoding)
+	}
+	if c.Type != "" {
+		if _, err := vfs.ParseKind(c.Type); err != nil {
+			return err
+		}
+	}
+	return checkKey(r, c.Cmd, op, c.Key)
+}
+
+var (
+	errCommandDisabled  = errors.New(disabledMessage)
+	errCommandForbidden = errors.New("access forbidden")
+)
+
+// movedError 表示 Key 由集群中的其他节点负责，错误信息是 MOVED 的格式
+type movedError string
+
+func (e movedError) Error() string {
+	return string(e)
+}
+
+// checkKey 检查命令 cmd 是否被禁用，客户端对 Key 有没有 op 权限，以及 Key 是否由当前节点负责
+func checkKey(r *http.Request, cmd string, op Operation, key string) error {
+	if !commands.enabled(cmd) {
+		return errCommandDisabled
+	}
+	if key == "" {
+		return errors.New("key is required")
+	}
+	if authorizer != nil {
+		if err := authorizer.Authorize(requestIdentity(r), op, kvBucket, key); err != nil {
+			return errCommandForbidden
+		}
+	}
+	if moved, ok := movedKey(key); ok {
+		return movedError(moved)
+	}
+	return nil
+}
+
+// checkStatus 返回拒绝整个请求时使用的状态码，由其他节点负责的 Key 返回 421
+func checkStatus(err error) int {
+	var moved movedError
+	switch {
+	case errors.Is(err, errCommandDisabled), errors.Is(err, errCommandForbidden):
+		return http.StatusForbidden
+	case errors.As(err, &moved):
+		return http.StatusMisdirectedRequest
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// commandValue 返回 set 命令写入的数据类型和解码之后的 Value
+func commandValue(c *Command) (vfs.Kind, []byte, error) {
+	kind := vfs.Binary
+	if c.Type != "" {
+		var err error
+		kind, err = vfs.ParseKind(c.Type)
+		if err != nil {
+			return vfs.Unknown, nil, err
+		}
+	}
+	if c.Encoding != "base64" {
+		return kind, []byte(c.Value), nil
+	}
+	value, err := base64.StdEncoding.DecodeString(c.Value)
+	if err != nil {
+		return vfs.Unknown, nil, fmt.Errorf("failed to decode base64 value: %w", err)
+	}
+	return kind, value, nil
+}
+
+// runCommand 执行一条已经通过 checkCommand 检查的命令
+func runCommand(ctx context.Context, c *Command) CommandResult {
+	inum := storage.InodeNum(c.Key)
+	switch c.Cmd {
+	case "get":
+		seg, err := storage.FetchSegmentContext(ctx, inum)
+		if errors.Is(err, vfs.ErrSegmentNotFound) {
+			return CommandResult{Nil: true}
+		}
+		if err != nil {
+			return CommandResult{Error: err.Error()}
+		}
+		value := string(seg.Value)
+		if c.Encoding == "base64" {
+			value = base64.StdEncoding.EncodeToString(seg.Value)
+		}
+		return CommandResult{Value: value, Version: seg.Version}
+	case "set":
+		kind, value, err := commandValue(c)
+		if err != nil {
+			return CommandResult{Error: err.Error()}
+		}
+		seg, err := vfs.NewSegmentBytes(c.Key, kind, value, c.TTL)
+		if err != nil {
+			return CommandResult{Error: err.Error()}
+		}
+		version, err := storage.AddSegmentVersion(ctx, inum, *seg, c.TTL)
+		if err != nil {
+			return CommandResult{Error: err.Error()}
+		}
+		return CommandResult{Version: version}
+	default:
+		version, err := storage.AddSegmentVersion(ctx, inum, *vfs.NewTombstoneSegment([]byte(c.Key)), 0)
+		if err != nil {
+			return CommandResult{Error: err.Error()}
+		}
+		return CommandResult{Version: version}
+	}
+}
+
+// pipelineController 在一次请求中按照顺序执行多条命令，和 Redis 的管道一样不是原子的
+// 每条命令单独返回结果，一条命令失败不影响之后的命令，需要原子执行请使用 /multi
+func pipelineController(w http.ResponseWriter, r *http.Request) {
+	if storage == nil {
+		okResponse(w, http.StatusServiceUnavailable, nil, "file storage system is not initialized")
+		return
+	}
+
+	cmds, err := decodeCommands(r)
+	if err != nil {
+		okResponse(w, http.StatusBadRequest, nil, err.Error())
+		return
+	}
+
+	ctx := vfs.WithClientID(r.Context(), requestIdentity(r))
+	results := make([]interface{}, len(cmds))
+	for i := range cmds {
+		if err := checkCommand(r, &cmds[i]); err != nil {
+			results[i] = CommandResult{Error: err.Error()}
+			continue
+		}
+		results[i] = runCommand(ctx, &cmds[i])
+	}
+	okResponse(w, http.StatusOK, results, "request processed successfully!")
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/auula/wiredkv/vfs"
+)
+
+// setupStorage 打开临时目录中的存储引擎作为服务使用的 storage，测试结束之后关闭
+func setupStorage(t *testing.T) {
+	t.Helper()
+	fss, err := vfs.OpenFS(&vfs.Options{Path: t.TempDir(), FsPerm: 0755, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	storage = fss
+	t.Cleanup(func() {
+		storage = nil
+		if err := fss.CloseFS(); err != nil {
+			t.Errorf("failed to close fs: %v", err)
+		}
+	})
+}
+
+// postCommands 通过 root 路由发送批量命令请求，返回状态码和每条命令的结果
+func postCommands(t *testing.T, path, user string, body interface{}) (int, []CommandResult) {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("failed to encode request: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+	req.RemoteAddr = "192.0.2.1:1234"
+	// 没有 ACL 时使用共享的密码
+	if user != "" {
+		req.Header.Set("user", user)
+		req.Header.Set("auth", user+"-password")
+	}
+	rec := httptest.NewRecorder()
+	root.ServeHTTP(rec, req)
+
+	var resp struct {
+		Result []CommandResult `json:"result"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, resp.Result
+}
+
+func TestPipeline(t *testing.T) {
+	setupStorage(t)
+
+	code, results := postCommands(t, "/pipeline", "", pipelineRequest{Commands: []Command{
+		{Cmd: "set", Key: "user-1", Value: "alice"},
+		{Cmd: "GET", Key: "user-1"},
+		{Cmd: "get", Key: "user-2"},
+		{Cmd: "rename", Key: "user-1"},
+		{Cmd: "delete", Key: "user-1"},
+		{Cmd: "get", Key: "user-1"},
+		{Cmd: "set"},
+	}})
+	if code != http.StatusOK || len(results) != 7 {
+		t.Fatalf("expected 7 results, got %d: %+v", code, results)
+	}
+	if results[0].Error != "" || results[0].Version == 0 {
+		t.Errorf("expected set to return a version, got %+v", results[0])
+	}
+	if results[1].Value != "alice" || results[1].Version != results[0].Version {
+		t.Errorf("expected get to return alice at version %d, got %+v", results[0].Version, results[1])
+	}
+	if !results[2].Nil {
+		t.Errorf("expected missing key to return nil, got %+v", results[2])
+	}
+	// 一条命令失败不影响之后的命令
+	if results[3].Error == "" || results[4].Error != "" || !results[5].Nil {
+		t.Errorf("expected only unknown command to fail, got %+v", results[3:6])
+	}
+	if results[6].Error != "key is required" {
+		t.Errorf("expected missing key error, got %+v", results[6])
+	}
+
+	for _, body := range []interface{}{pipelineRequest{}, "not commands", pipelineRequest{Commands: make([]Command, maxPipelineCommands+1)}} {
+		if code, _ := postCommands(t, "/pipeline", "", body); code != http.StatusBadRequest {
+			t.Errorf("expected 400 for %T, got %d", body, code)
+		}
+	}
+}
+
+func TestPipelineAuthorize(t *testing.T) {
+	setupStorage(t)
+	acl, err := ParseACL([]byte(`{"users": {
+		"alice": {"password": "alice-password", "grants": {"kv/user-": "write", "kv": "read"}}
+	}}`))
+	if err != nil {
+		t.Fatalf("failed to parse acl: %v", err)
+	}
+	authorizer = acl
+	defer func() { authorizer = nil }()
+
+	// 每条命令按照自己的 Key 和操作鉴权
+	code, results := postCommands(t, "/pipeline", "alice", pipelineRequest{Commands: []Command{
+		{Cmd: "set", Key: "user-1", Value: "alice"},
+		{Cmd: "set", Key: "order-1", Value: "book"},
+		{Cmd: "get", Key: "order-1"},
+	}})
+	if code != http.StatusOK || len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d: %+v", code, results)
+	}
+	if results[0].Error != "" || results[1].Error != "access forbidden" || !results[2].Nil {
+		t.Errorf("expected only the write outside user- to be forbidden, got %+v", results)
+	}
+
+	// 禁用的命令在批量接口中同样不能执行
+	saved := commands
+	defer func() { commands = saved }()
+	commands, _ = newCommandFilter(nil, []string{"delete"}, nil)
+	_, results = postCommands(t, "/pipeline", "alice", pipelineRequest{Commands: []Command{{Cmd: "delete", Key: "user-1"}}})
+	if len(results) != 1 || results[0].Error != disabledMessage {
+		t.Errorf("expected disabled delete, got %+v", results)
+	}
+}
+
+func TestPipelineValueEncoding(t *testing.T) {
+	setupStorage(t)
+
+	binary := base64.StdEncoding.EncodeToString([]byte{0xff, 0x00, 0xfe})
+	code, results := postCommands(t, "/pipeline", "", pipelineRequest{Commands: []Command{
+		{Cmd: "set", Key: "blob", Value: binary, Encoding: "base64"},
+		{Cmd: "set", Key: "note", Value: "hello", Type: "text"},
+		{Cmd: "get", Key: "blob", Encoding: "base64"},
+		{Cmd: "set", Key: "bad", Value: "!!!", Encoding: "base64"},
+		{Cmd: "set", Key: "bad", Value: "x", Encoding: "hex"},
+		{Cmd: "set", Key: "bad", Value: "x", Type: "document"},
+	}})
+	if code != http.StatusOK || len(results) != 6 {
+		t.Fatalf("expected 6 results, got %d: %+v", code, results)
+	}
+	if results[2].Value != binary {
+		t.Errorf("expected base64 value %q, got %q", binary, results[2].Value)
+	}
+	for i, r := range results[3:] {
+		if r.Error == "" {
+			t.Errorf("expected command %d to fail, got %+v", i+4, r)
+		}
+	}
+
+	seg, err := storage.FetchSegment(storage.InodeNum("note"))
+	if err != nil || seg.Type != vfs.Text || string(seg.Value) != "hello" {
+		t.Errorf("expected text value hello, got %v %v", seg, err)
+	}
+	if _, err := storage.FetchSegment(storage.InodeNum("bad")); !errors.Is(err, vfs.ErrSegmentNotFound) {
+		t.Errorf("expected invalid commands not to write, got %v", err)
+	}
+
+	// 事务不支持 Type 和 Encoding，不会把编码之后的数据当作字符串写入
+	code, _ = postCommands(t, "/multi", "", multiRequest{Commands: []Command{{Cmd: "set", Key: "blob", Value: binary, Encoding: "base64"}}})
+	if code != http.StatusBadRequest {
+		t.Errorf("expected 400 for encoding in multi, got %d", code)
+	}
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/auula/wiredkv/clog"
+	"github.com/gorilla/mux"
+)
+
+// 发布订阅接口鉴权时使用的 bucket，key 是频道名字或者订阅的模式
+const pubsubBucket = "pubsub"
+
+// 每个订阅者最多缓存的消息数，处理不过来的订阅者会丢弃新的消息，不会阻塞发布者
+const subscriberBuffer = 256
+
+// Message 是发布到频道中的一条消息，订阅者按照 Server-Sent Events 的格式接收，data 是它的 JSON 编码
+// 通过模式订阅收到的消息 Pattern 是订阅时使用的模式
+type Message struct {
+	Channel string `json:"channel"`
+	Pattern string `json:"pattern,omitempty"`
+	Message string `json:"message"`
+	Time    string `json:"time"`
+}
+
+type subscriber struct {
+	channel string // 订阅的频道或者模式
+	pattern bool
+	ch      chan *Message
+	dropped uint64 // 缓冲区满时丢弃的消息数，在 broker.mu 的保护下修改
+}
+
+// broker 是进程内的发布订阅消息代理，和存储引擎以及键空间通知无关，消息不会持久化
+// channels 按照频道名字保存订阅者，patterns 按照 path.Match 的模式保存订阅者，和 Redis 的 PSUBSCRIBE 一样
+type broker struct {
+	mu       sync.Mutex
+	channels map[string]map[*subscriber]struct{}
+	patterns map[string]map[*subscriber]struct{}
+	closed   bool
+}
+
+var pubsub = newBroker()
+
+func newBroker() *broker {
+	return &broker{
+		channels: make(map[string]map[*subscriber]struct{}),
+		patterns: make(map[string]map[*subscriber]struct{}),
+	}
+}
+
+// subscribe 订阅频道，pattern 为 true 时 channel 是 path.Match 的模式，例如 news.* 订阅所有 news. 开头的频道
+// 模式的语法错误返回 path.ErrBadPattern，返回 nil 表示代理已经关闭
+func (b *broker) subscribe(channel string, pattern bool) (*subscriber, error) {
+	groups := b.channels
+	if pattern {
+		if _, err := path.Match(channel, ""); err != nil {
+			return nil, err
+		}
+		groups = b.patterns
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, nil
+	}
+
+	sub := &subscriber{channel: channel, pattern: pattern, ch: make(chan *Message, subscriberBuffer)}
+	subs, ok := groups[channel]
+	if !ok {
+		subs = make(map[*subscriber]struct{})
+		groups[channel] = subs
+	}
+	subs[sub] = struct{}{}
+	return sub, nil
+}
+
+// unsubscribe 取消订阅，频道或者模式中没有订阅者之后删除它
+func (b *broker) unsubscribe(sub *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	groups := b.channels
+	if sub.pattern {
+		groups = b.patterns
+	}
+	subs, ok := groups[sub.channel]
+	if !ok {
+		return
+	}
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+	delete(subs, sub)
+	close(sub.ch)
+	if len(subs) == 0 {
+		delete(groups, sub.channel)
+	}
+}
+
+// publish 把消息发送给频道的所有订阅者和匹配频道的模式订阅者，返回收到消息的订阅者数量
+// 订阅者的缓冲区满时丢弃这条消息，一个处理不过来的订阅者不会阻塞发布者和其他订阅者
+func (b *broker) publish(msg *Message) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	received := 0
+	send := func(sub *subscriber, msg *Message) {
+		select {
+		case sub.ch <- msg:
+			received++
+		default:
+			sub.dropped++
+		}
+	}
+	for sub := range b.channels[msg.Channel] {
+		send(sub, msg)
+	}
+	for pattern, subs := range b.patterns {
+		if ok, _ := path.Match(pattern, msg.Channel); !ok {
+			continue
+		}
+		matched := *msg
+		matched.Pattern = pattern
+		for sub := range subs {
+			send(sub, &matched)
+		}
+	}
+	return received
+}
+
+// close 结束所有的订阅，长连接的订阅请求在优雅退出时不会一直阻塞 HTTP 服务器关闭
+func (b *broker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for _, groups := range []map[string]map[*subscriber]struct{}{b.channels, b.patterns} {
+		for channel, subs := range groups {
+			for sub := range subs {
+				close(sub.ch)
+			}
+			delete(groups, channel)
+		}
+	}
+}
+
+// isPatternRequest 判断订阅请求是不是模式订阅，GET /pubsub/{pattern}?pattern=true
+func isPatternRequest(r *http.Request) bool {
+	pattern, _ := strconv.ParseBool(r.URL.Query().Get("pattern"))
+	return r.Method == http.MethodGet && pattern
+}
+
+// mountPubSub 挂载发布订阅接口，POST /pubsub/{channel} 发布，GET /pubsub/{channel} 订阅
+// GET /pubsub/{pattern}?pattern=true 按照模式订阅，模式中的 ? 需要编码为 %3F
+func mountPubSub(router *mux.Router) {
+	router.HandleFunc("/pubsub/{channel}", publishController).Methods("POST")
+	router.HandleFunc("/pubsub/{channel}", subscribeController).Methods("GET")
+}
+
+// publishController 把请求体作为消息发布到频道，返回收到消息的订阅者数量
+func publishController(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		okResponse(w, http.StatusRequestEntityTooLarge, nil, "failed to read message!")
+		return
+	}
+
+	msg := &Message{
+		Channel: mux.Vars(r)["channel"],
+		Message: string(body),
+		Time:    time.Now().Format(time.RFC3339Nano),
+	}
+	okResponse(w, http.StatusOK, []interface{}{pubsub.publish(msg)}, "message published successfully!")
+}
+
+// subscribeController 使用 Server-Sent Events 持续推送频道中的消息，直到客户端断开或者服务器关闭
+func subscribeController(w http.ResponseWriter, r *http.Request) {
+	channel := mux.Vars(r)["channel"]
+	pattern, identity := isPatternRequest(r), requestIdentity(r)
+
+	// 订阅是长连接，不受 WriteTimeout 的限制
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		okResponse(w, http.StatusNotImplemented, nil, "streaming is not supported!")
+		return
+	}
+
+	sub, err := pubsub.subscribe(channel, pattern)
+	if err != nil {
+		okResponse(w, http.StatusBadRequest, nil, fmt.Sprintf("invalid channel pattern: %v", err))
+		return
+	}
+	if sub == nil {
+		okResponse(w, http.StatusServiceUnavailable, nil, "server is shutting down!")
+		return
+	}
+	defer pubsub.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Server", version)
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	for {
+		select {
+		case msg, ok := <-sub.ch:
+			if !ok {
+				return
+			}
+			// 鉴权只检查了模式本身，模式匹配到的频道中没有读权限的消息不会发送给订阅者
+			if pattern && authorizer != nil && authorizer.Authorize(identity, OpRead, pubsubBucket, msg.Channel) != nil {
+				continue
+			}
+			data, err := json.Marshal(msg)
+			if err != nil {
+				clog.Error(err)
+				continue
+			}
+			_, err = fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+			if err == nil {
+				err = rc.Flush()
+			}
+			if err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
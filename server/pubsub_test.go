@@ -0,0 +1,252 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// subscribeTo 订阅频道或者模式，失败时结束测试
+func subscribeTo(t *testing.T, b *broker, channel string, pattern bool) *subscriber {
+	t.Helper()
+	sub, err := b.subscribe(channel, pattern)
+	if err != nil || sub == nil {
+		t.Fatalf("failed to subscribe %q: %v", channel, err)
+	}
+	return sub
+}
+
+// receive 返回订阅者缓冲区中的下一条消息，没有消息时返回 nil
+func receive(sub *subscriber) *Message {
+	select {
+	case msg := <-sub.ch:
+		return msg
+	default:
+		return nil
+	}
+}
+
+func TestBrokerPublish(t *testing.T) {
+	b := newBroker()
+	first, second := subscribeTo(t, b, "news", false), subscribeTo(t, b, "news", false)
+	other := subscribeTo(t, b, "weather", false)
+
+	if n := b.publish(&Message{Channel: "news", Message: "hello"}); n != 2 {
+		t.Fatalf("expected 2 subscribers to receive, got %d", n)
+	}
+	for _, sub := range []*subscriber{first, second} {
+		if msg := receive(sub); msg == nil || msg.Message != "hello" || msg.Pattern != "" {
+			t.Errorf("expected hello, got %+v", msg)
+		}
+	}
+	if msg := receive(other); msg != nil {
+		t.Errorf("expected no message on other channel, got %+v", msg)
+	}
+
+	// 取消订阅之后不再收到消息，重复取消不会重复关闭
+	b.unsubscribe(first)
+	b.unsubscribe(first)
+	if _, ok := <-first.ch; ok {
+		t.Error("expected unsubscribed channel to be closed")
+	}
+	if n := b.publish(&Message{Channel: "news", Message: "again"}); n != 1 {
+		t.Errorf("expected 1 subscriber to receive, got %d", n)
+	}
+	b.unsubscribe(second)
+	if _, ok := b.channels["news"]; ok {
+		t.Error("expected empty channel to be removed")
+	}
+	if n := b.publish(&Message{Channel: "nobody"}); n != 0 {
+		t.Errorf("expected no subscribers, got %d", n)
+	}
+}
+
+func TestBrokerPattern(t *testing.T) {
+	b := newBroker()
+	all := subscribeTo(t, b, "news.*", true)
+	single := subscribeTo(t, b, "news.?", true)
+	exact := subscribeTo(t, b, "news.*", false)
+
+	tests := []struct {
+		channel     string
+		all, single bool
+	}{
+		{"news.sport", true, false},
+		{"news.a", true, true},
+		{"news.", true, false},
+		{"news", false, false},
+		{"weather.sport", false, false},
+	}
+	for _, tt := range tests {
+		b.publish(&Message{Channel: tt.channel, Message: tt.channel})
+		for _, c := range []struct {
+			sub      *subscriber
+			expected bool
+		}{{all, tt.all}, {single, tt.single}} {
+			msg := receive(c.sub)
+			if !c.expected {
+				if msg != nil {
+					t.Errorf("expected %q not to match %q", tt.channel, c.sub.channel)
+				}
+				continue
+			}
+			if msg == nil || msg.Channel != tt.channel || msg.Pattern != c.sub.channel {
+				t.Errorf("expected %q to match %q, got %+v", tt.channel, c.sub.channel, msg)
+			}
+		}
+	}
+	// 频道名字中的通配符没有特殊含义
+	if msg := receive(exact); msg != nil {
+		t.Errorf("expected exact subscriber not to match, got %+v", msg)
+	}
+	if b.publish(&Message{Channel: "news.*"}) != 3 || receive(exact) == nil {
+		t.Error("expected exact subscriber to receive its own channel")
+	}
+
+	if _, err := b.subscribe("news.[", true); !errors.Is(err, path.ErrBadPattern) {
+		t.Errorf("expected ErrBadPattern, got %v", err)
+	}
+	b.unsubscribe(all)
+	b.unsubscribe(single)
+	if len(b.patterns) != 0 {
+		t.Errorf("expected empty patterns to be removed, got %v", b.patterns)
+	}
+}
+
+func TestBrokerSlowSubscriber(t *testing.T) {
+	b := newBroker()
+	slow, fast := subscribeTo(t, b, "news", false), subscribeTo(t, b, "news", false)
+
+	// 缓冲区满之后丢弃新的消息，发布者不会阻塞，处理得过来的订阅者不受影响
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < subscriberBuffer+10; i++ {
+			b.publish(&Message{Channel: "news"})
+			receive(fast)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected publish not to block on a slow subscriber")
+	}
+
+	b.mu.Lock()
+	dropped, fastDropped := slow.dropped, fast.dropped
+	b.mu.Unlock()
+	if dropped != 10 || fastDropped != 0 || len(slow.ch) != subscriberBuffer {
+		t.Errorf("expected slow subscriber to drop 10 messages, got %d with %d buffered, fast dropped %d", dropped, len(slow.ch), fastDropped)
+	}
+	if n := b.publish(&Message{Channel: "news"}); n != 1 {
+		t.Errorf("expected only fast subscriber to receive, got %d", n)
+	}
+}
+
+func TestBrokerClose(t *testing.T) {
+	b := newBroker()
+	sub, psub := subscribeTo(t, b, "news", false), subscribeTo(t, b, "news.*", true)
+	b.close()
+
+	for _, s := range []*subscriber{sub, psub} {
+		if _, ok := <-s.ch; ok {
+			t.Errorf("expected %q to be closed", s.channel)
+		}
+	}
+	// 关闭之后取消订阅不会重复关闭，新的订阅返回 nil
+	b.unsubscribe(sub)
+	if s, err := b.subscribe("news", false); s != nil || err != nil {
+		t.Errorf("expected nil subscriber after close, got %v %v", s, err)
+	}
+}
+
+func TestSubscribePatternAuthorize(t *testing.T) {
+	acl, err := ParseACL([]byte(`{"users": {
+		"alice": {"password": "alice-password", "grants": {"pubsub": "read", "pubsub/news.secret": "none"}},
+		"bob": {"password": "bob-password", "grants": {"pubsub": "write"}}
+	}}`))
+	if err != nil {
+		t.Fatalf("failed to parse acl: %v", err)
+	}
+	saved := pubsub
+	authorizer, pubsub = acl, newBroker()
+	defer func() { authorizer, pubsub = nil, saved }()
+
+	srv := httptest.NewServer(root)
+	defer srv.Close()
+
+	request := func(user, method, path string, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		req.Header.Set("user", user)
+		req.Header.Set("auth", user+"-password")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+		return resp
+	}
+
+	resp := request("alice", http.MethodGet, "/pubsub/news.%5B?pattern=true", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for bad pattern, got %d", resp.StatusCode)
+	}
+
+	resp = request("alice", http.MethodGet, "/pubsub/news.*?pattern=true", "")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected subscription, got %d", resp.StatusCode)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pubsub.mu.Lock()
+		n := len(pubsub.patterns)
+		pubsub.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected pattern subscription to be registered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// 模式匹配到的频道中没有读权限的消息不会推送
+	for _, channel := range []string{"news.secret", "news.sport"} {
+		r := request("bob", http.MethodPost, "/pubsub/"+channel, channel)
+		r.Body.Close()
+		if r.StatusCode != http.StatusOK {
+			t.Fatalf("expected publish to %s to succeed, got %d", channel, r.StatusCode)
+		}
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read event: %v", err)
+		}
+		data, ok := strings.CutPrefix(strings.TrimSpace(line), "data: ")
+		if !ok {
+			continue
+		}
+		var msg Message
+		if err := json.Unmarshal([]byte(data), &msg); err != nil {
+			t.Fatalf("failed to decode event %q: %v", data, err)
+		}
+		if msg.Channel != "news.sport" || msg.Pattern != "news.*" {
+			t.Errorf("expected news.sport through news.*, got %+v", msg)
+		}
+		return
+	}
+}
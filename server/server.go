@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/auula/wiredkv/clog"
+	"github.com/auula/wiredkv/cluster"
 	"github.com/auula/wiredkv/vfs"
 )
 
@@ -23,6 +24,11 @@ const (
 	minPort = 1024
 	maxPort = 1 << 16
 	timeout = time.Second * 3
+	// 空闲的 Keep-Alive 连接默认保持的时间
+	idleTimeout = time.Minute
+	// 请求头和请求体默认的最大字节数
+	maxHeaderBytes = 1 << 20
+	maxBodyBytes   = 32 << 20
 )
 
 func init() {
@@ -49,15 +55,61 @@ func init() {
 }
 
 type HttpServer struct {
-	serv *http.Server
-	port int
+	serv        *http.Server
+	host        string
+	port        int
+	gracePeriod time.Duration
+	certFile    string
+	keyFile     string
+	maxConns    int
+	keepAlive   time.Duration
+	reap        time.Duration
+	tracker     *connTracker
 }
 
 type Options struct {
+	// Host 监听的地址，空表示监听本机的 IPv4 地址
+	Host string
 	Port int
 	Auth string
+	// GracePeriod 关闭时等待正在处理的请求完成的最长时间，0 表示一直等待
+	GracePeriod time.Duration
+	// CertFile 和 KeyFile 同时设置之后使用 HTTPS 协议提供服务
 	// 可以考虑 CertMagic 自动管理证书并启动 HTTPS 服务
-	// certs *tls.Config
+	CertFile string
+	KeyFile  string
+	// Authorizer 在每个请求通过认证之后检查权限，nil 表示通过认证的客户端拥有所有权限，包括管理命令
+	Authorizer Authorizer
+	// AdminAuditLog 管理命令审计日志的路径，空表示不开启，超过 AdminAuditMaxSize 字节之后轮转
+	// 最多保留 AdminAuditBackups 个旧日志，0 分别表示 10MB 和 5 个
+	AdminAuditLog     string
+	AdminAuditMaxSize int64
+	AdminAuditBackups int
+	// AllowCommands 不为空时只允许执行其中的命令，DenyCommands 中的命令总是被禁用
+	// 命令名字是 get、set、delete、pipeline、multi、eval、publish、subscribe、psubscribe、stats、debug、audit、compact、backup、reload 和 shutdown
+	// RenameCommands 把命令重命名为新名字，客户端只能通过新名字执行，例如 {"shutdown": "shutdown-8f3a"}
+	AllowCommands  []string
+	DenyCommands   []string
+	RenameCommands map[string]string
+	// MaxConnections 同时保持的最大连接数，达到上限之后新的连接在内核中排队，0 表示不限制
+	MaxConnections int
+	// ReadTimeout 和 WriteTimeout 是读取整个请求和写入响应的最长时间，IdleTimeout 是 Keep-Alive 连接的最长空闲时间
+	// 0 分别表示 3 秒、3 秒和 1 分钟，CPU profile 和 trace 的采样时间不能超过 WriteTimeout
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// MaxHeaderBytes 和 MaxBodyBytes 是请求头和请求体的最大字节数，0 分别表示 1MB 和 32MB
+	MaxHeaderBytes int
+	MaxBodyBytes   int64
+	// KeepAlive 是 TCP keep-alive 探测的间隔，对端已经失效的连接会被操作系统发现并关闭
+	// 0 表示使用 Go 默认的 15 秒，小于 0 表示关闭 TCP keep-alive
+	KeepAlive time.Duration
+	// ReapInterval 是定期回收空闲超过 IdleTimeout 的连接的间隔，0 表示 IdleTimeout 的一半，小于 0 表示不定期回收
+	ReapInterval time.Duration
+	// Cluster 不为空时按照哈希槽检查数据请求的 Key，由其他节点负责的请求返回 MOVED 重定向
+	// ClusterNode 是当前节点在 Cluster 中的地址，为以后的集群模式预留
+	Cluster     *cluster.Router
+	ClusterNode string
 }
 
 // New 创建一个新的 HTTP 服务器
@@ -69,16 +121,76 @@ func New(opt *Options) (*HttpServer, error) {
 	if opt.Auth != "" {
 		authPassword = opt.Auth
 	}
+	authorizer = opt.Authorizer
 
+	filter, err := newCommandFilter(opt.AllowCommands, opt.DenyCommands, opt.RenameCommands)
+	if err != nil {
+		return nil, err
+	}
+	commands = filter
+	clusterRouter, clusterNode = opt.Cluster, opt.ClusterNode
+
+	if opt.AdminAuditLog != "" {
+		audit, err := openAdminAuditLog(opt.AdminAuditLog, opt.AdminAuditMaxSize, opt.AdminAuditBackups)
+		if err != nil {
+			return nil, err
+		}
+		adminAudit = audit
+	}
+
+	host := opt.Host
+	if host == "" {
+		host = ipv4
+	}
+
+	reap := opt.ReapInterval
+	if opt.MaxConnections < 0 || opt.ReadTimeout < 0 || opt.WriteTimeout < 0 || opt.IdleTimeout < 0 || opt.MaxHeaderBytes < 0 || opt.MaxBodyBytes < 0 {
+		return nil, errors.New("HTTP server limits cannot be negative")
+	}
+
+	readTimeout, writeTimeout, idle := opt.ReadTimeout, opt.WriteTimeout, opt.IdleTimeout
+	if readTimeout == 0 {
+		readTimeout = timeout
+	}
+	if writeTimeout == 0 {
+		writeTimeout = timeout
+	}
+	if idle == 0 {
+		idle = idleTimeout
+	}
+	if reap == 0 {
+		reap = idle / 2
+	}
+	headerBytes, bodyBytes := opt.MaxHeaderBytes, opt.MaxBodyBytes
+	if headerBytes == 0 {
+		headerBytes = maxHeaderBytes
+	}
+	if bodyBytes == 0 {
+		bodyBytes = maxBodyBytes
+	}
+
+	// 慢速的客户端在 ReadTimeout 之内没有发完请求就会被断开，不会一直占用连接
 	hs := HttpServer{
 		serv: &http.Server{
-			Handler:      root,
-			Addr:         net.JoinHostPort(ipv4, strconv.Itoa(opt.Port)),
-			WriteTimeout: timeout,
-			ReadTimeout:  timeout,
+			Handler:           limitBody(root, bodyBytes),
+			Addr:              net.JoinHostPort(host, strconv.Itoa(opt.Port)),
+			ReadHeaderTimeout: readTimeout,
+			ReadTimeout:       readTimeout,
+			WriteTimeout:      writeTimeout,
+			IdleTimeout:       idle,
+			MaxHeaderBytes:    headerBytes,
 		},
-		port: opt.Port,
+		host:        host,
+		port:        opt.Port,
+		gracePeriod: opt.GracePeriod,
+		certFile:    opt.CertFile,
+		keyFile:     opt.KeyFile,
+		maxConns:    opt.MaxConnections,
+		keepAlive:   opt.KeepAlive,
+		reap:        reap,
+		tracker:     newConnTracker(),
 	}
+	hs.serv.ConnState = hs.tracker.hook
 
 	// 开启 HTTP Keep-Alive 长连接
 	hs.serv.SetKeepAlivesEnabled(true)
@@ -100,7 +212,15 @@ func (hs *HttpServer) Port() int {
 
 // IPv4 return local IPv4 address
 func (hs *HttpServer) IPv4() string {
-	return ipv4
+	return hs.host
+}
+
+// Scheme 返回服务使用的协议，配置了证书时是 https
+func (hs *HttpServer) Scheme() string {
+	if hs.certFile != "" && hs.keyFile != "" {
+		return "https"
+	}
+	return "http"
 }
 
 // Startup blocking goroutine
@@ -109,8 +229,20 @@ func (hs *HttpServer) Startup() error {
 		return errors.New("file storage system is not initialized")
 	}
 
+	lc := net.ListenConfig{KeepAlive: hs.keepAlive}
+	listener, err := lc.Listen(context.Background(), "tcp", hs.serv.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to start http api server :%w", err)
+	}
+	listener = newLimitListener(listener, hs.maxConns)
+	hs.tracker.start(hs.reap, hs.serv.IdleTimeout)
+
 	// 这个函数是一个阻塞函数
-	err := hs.serv.ListenAndServe()
+	if hs.Scheme() == "https" {
+		err = hs.serv.ServeTLS(listener, hs.certFile, hs.keyFile)
+	} else {
+		err = hs.serv.Serve(listener)
+	}
 	if err != nil && err != http.ErrServerClosed {
 		return fmt.Errorf("failed to start http api server :%w", err)
 	}
@@ -118,23 +250,43 @@ func (hs *HttpServer) Startup() error {
 	return nil
 }
 
+// Shutdown 停止接受新的连接，在 GracePeriod 之内等待正在处理的请求完成，最后关闭存储引擎
+// 超过 GracePeriod 还没有完成的请求会被强制断开，存储引擎依然会正常关闭
 func (hs *HttpServer) Shutdown() error {
+	ctx := context.Background()
+	if hs.gracePeriod > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hs.gracePeriod)
+		defer cancel()
+	}
+
 	// 先关闭 http 服务器停止接受数据请求
-	err := hs.serv.Shutdown(context.Background())
+	hs.tracker.stop()
+	// 订阅请求是长连接，需要先结束订阅，否则会一直等到 GracePeriod 超时
+	pubsub.close()
+	err := hs.serv.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		clog.Warnf("HTTP server did not finish in-flight requests within %s, closing connections", hs.gracePeriod)
+		err = hs.serv.Close()
+	}
 	if err != nil && err != http.ErrServerClosed {
-		// 这里发生了错误，外层处理这个错误时也要关闭文件存储系统
-		return err
+		err = fmt.Errorf("failed to shutdown the http server: %w", err)
+	} else {
+		err = nil
+	}
+
+	// 已经没有正在处理的管理命令，可以关闭审计日志
+	if aerr := adminAudit.close(); aerr != nil {
+		err = errors.Join(err, aerr)
 	}
 
-	// 再关闭文件存储系统
+	// 再关闭文件存储系统，http 服务器关闭失败时也要关闭，保证索引快照写入磁盘
 	if storage != nil {
-		err := storage.CloseFS()
-		if err != nil {
-			return fmt.Errorf("failed to shutdown the storage engine: %w", err)
+		cerr := storage.CloseFS()
+		if cerr != nil {
+			return errors.Join(err, fmt.Errorf("failed to shutdown the storage engine: %w", cerr))
 		}
 	}
 
-	storage.ExportSnapshotIndex()
-
-	return nil
+	return err
 }
@@ -0,0 +1,66 @@
+package utils
+
+// MatchGlob 判断 key 是否匹配 glob 模式，* 匹配任意长度的字符，? 匹配单个字节，\ 转义下一个字节
+// 和 path.Match 不同的是 / 没有特殊含义，Key 可以包含任意字节，包括 * 和 ? 本身
+func MatchGlob(pattern, key string) bool {
+	// 记录最近一次 * 的位置，匹配失败时回溯到这里让 * 多匹配一个字节
+	px, kx := 0, 0
+	star, next := -1, 0
+
+	for kx < len(key) {
+		if px < len(pattern) {
+			switch pattern[px] {
+			case '*':
+				star, next = px, kx
+				px++
+				continue
+			case '?':
+				px++
+				kx++
+				continue
+			case '\\':
+				if px+1 < len(pattern) && pattern[px+1] == key[kx] {
+					px += 2
+					kx++
+					continue
+				}
+			default:
+				if pattern[px] == key[kx] {
+					px++
+					kx++
+					continue
+				}
+			}
+		}
+
+		if star < 0 {
+			return false
+		}
+		next++
+		px, kx = star+1, next
+	}
+
+	for px < len(pattern) && pattern[px] == '*' {
+		px++
+	}
+
+	return px == len(pattern)
+}
+
+// GlobPrefix 返回 glob 模式中第一个通配符之前的固定前缀，转义的字节按照原样放入前缀
+func GlobPrefix(pattern string) string {
+	prefix := make([]byte, 0, len(pattern))
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?':
+			return string(prefix)
+		case '\\':
+			if i+1 == len(pattern) {
+				return string(prefix)
+			}
+			i++
+		}
+		prefix = append(prefix, pattern[i])
+	}
+	return string(prefix)
+}
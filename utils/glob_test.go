@@ -0,0 +1,47 @@
+package utils
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{pattern: "*", key: "", want: true},
+		{pattern: "*", key: "user/01", want: true},
+		{pattern: "user:*", key: "user:01", want: true},
+		{pattern: "user:*", key: "order:01", want: false},
+		{pattern: "user:??", key: "user:01", want: true},
+		{pattern: "user:??", key: "user:001", want: false},
+		{pattern: "*:01", key: "user:01", want: true},
+		{pattern: "a*b*c", key: "axxbyyc", want: true},
+		{pattern: "a*b*c", key: "axxbyy", want: false},
+		{pattern: "a**", key: "a", want: true},
+		{pattern: "exact", key: "exact", want: true},
+		{pattern: "exact", key: "exactly", want: false},
+		{pattern: `user\*`, key: "user*", want: true},
+		{pattern: `user\*`, key: "user01", want: false},
+		{pattern: `\?*`, key: "?x", want: true},
+		{pattern: "a\x00*", key: "a\x00\xff", want: true},
+		{pattern: "a?c", key: "a\xffc", want: true},
+	}
+
+	for _, tt := range tests {
+		if got := MatchGlob(tt.pattern, tt.key); got != tt.want {
+			t.Errorf("MatchGlob(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestGlobPrefix(t *testing.T) {
+	if got := GlobPrefix("user:*:name"); got != "user:" {
+		t.Errorf("expected prefix user:, got %s", got)
+	}
+	if got := GlobPrefix("user"); got != "user" {
+		t.Errorf("expected prefix user, got %s", got)
+	}
+	if got := GlobPrefix(`a\*b*`); got != "a*b" {
+		t.Errorf("expected prefix a*b, got %s", got)
+	}
+}
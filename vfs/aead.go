@@ -0,0 +1,154 @@
+package vfs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// 加密记录的 Value 统一使用下面的布局，nonce 在密文之前，认证标签紧跟在密文之后
+// | NONCE ? | CIPHERTEXT ? | TAG ? |
+// nonce 和认证标签的长度由 cipher.AEAD 的 NonceSize 和 Overhead 决定，AES-GCM 分别是 12 和 16 字节
+// 布局由存储引擎决定而不是由每个 Encryptor 决定，不同的实现之间可以互相读取，修复工具也能按照结构解析
+
+var ErrSealedValueTooShort = errors.New("sealed value is too short")
+
+// AEADEncryptor 只需要根据密钥创建 cipher.AEAD，nonce 的生成和记录布局由 Transformer 负责
+// 同时实现了 Encryptor 和 AEADEncryptor 的加密算法，Transformer 会优先使用 AEAD
+type AEADEncryptor interface {
+	AEAD(secret []byte) (cipher.AEAD, error)
+}
+
+// SealedValue 是拆分之后的加密 Value，三个字段都引用原来的字节切片
+type SealedValue struct {
+	Nonce      []byte
+	Ciphertext []byte
+	Tag        []byte
+}
+
+// ParseSealedValue 按照固定的布局拆分加密 Value，不需要密钥
+func ParseSealedValue(data []byte, nonceSize, tagSize int) (*SealedValue, error) {
+	if nonceSize < 0 || tagSize < 0 || len(data) < nonceSize+tagSize {
+		return nil, fmt.Errorf("%w: %d bytes with nonce size %d and tag size %d", ErrSealedValueTooShort, len(data), nonceSize, tagSize)
+	}
+
+	return &SealedValue{
+		Nonce:      data[:nonceSize],
+		Ciphertext: data[nonceSize : len(data)-tagSize],
+		Tag:        data[len(data)-tagSize:],
+	}, nil
+}
+
+// sealValue 使用随机的 nonce 加密 data，返回 | NONCE | CIPHERTEXT | TAG |
+func sealValue(aead cipher.AEAD, data []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	// cipher.AEAD.Seal 把认证标签追加在密文之后，正好符合记录的布局
+	return aead.Seal(nonce, nonce, data, nil), nil
+}
+
+// openValue 校验认证标签并解密 sealValue 生成的数据
+func openValue(aead cipher.AEAD, data []byte) ([]byte, error) {
+	sealed, err := ParseSealedValue(data, aead.NonceSize(), aead.Overhead())
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Open(nil, sealed.Nonce, data[len(sealed.Nonce):], nil)
+}
+
+var AESGCMEncryptor = new(AESGCM)
+
+// AESGCM 使用 AES-256-GCM 加密，密钥是 secret 的 SHA-256 摘要，任意长度的 secret 都可以使用
+type AESGCM struct{}
+
+func (e *AESGCM) Name() string {
+	return "aes-gcm"
+}
+
+// AEAD 派生的密钥在创建 cipher.Block 之后立即清零
+func (e *AESGCM) AEAD(secret []byte) (cipher.AEAD, error) {
+	key := sha256.Sum256(secret)
+	defer wipe(key[:])
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (e *AESGCM) Encode(secret, data []byte) ([]byte, error) {
+	aead, err := e.AEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+	return sealValue(aead, data)
+}
+
+func (e *AESGCM) Decode(secret, data []byte) ([]byte, error) {
+	aead, err := e.AEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+	return openValue(aead, data)
+}
+
+// hint 文件、索引快照和稀疏索引中保存了 Key，开启加密时使用和 Value 相同的密钥加密
+// 加密方式保存在元数据中，读取时不依赖 Transformer 当前是否开启加密
+const (
+	metadataPlain   byte = iota // 没有加密
+	metadataSealed              // 使用 AEAD 按照 | NONCE | CIPHERTEXT | TAG | 的布局加密
+	metadataEncoded             // 使用没有实现 AEADEncryptor 的 Encryptor 加密
+)
+
+// encryptMetadata 使用当前的加密设置加密元数据，返回加密方式和加密之后的数据
+func (t *Transformer) encryptMetadata(data []byte) (byte, []byte, error) {
+	if !t.IsEncryptionEnabled() || t.Encryptor == nil {
+		return metadataPlain, data, nil
+	}
+
+	if t.aead != nil {
+		sealed, err := sealValue(t.aead, data)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to encrypt metadata: %w", err)
+		}
+		return metadataSealed, sealed, nil
+	}
+
+	encoded, err := t.Encryptor.Encode(t.secret, data)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to encrypt metadata: %w", err)
+	}
+	return metadataEncoded, encoded, nil
+}
+
+// decryptMetadata 按照 encryptMetadata 返回的加密方式解密元数据，没有配置对应的 Encryptor 时返回错误
+func (t *Transformer) decryptMetadata(mode byte, data []byte) ([]byte, error) {
+	var (
+		decrypted []byte
+		err       error
+	)
+	switch {
+	case mode == metadataPlain:
+		return data, nil
+	case mode == metadataSealed && t.aead != nil:
+		decrypted, err = openValue(t.aead, data)
+	case mode == metadataEncoded && t.Encryptor != nil:
+		decrypted, err = t.Encryptor.Decode(t.secret, data)
+	case mode == metadataSealed || mode == metadataEncoded:
+		err = errors.New("no encryptor configured")
+	default:
+		err = fmt.Errorf("unknown encryption mode %d", mode)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt metadata: %w", err)
+	}
+	return decrypted, nil
+}
@@ -0,0 +1,236 @@
+package vfs
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// gcmOnly 只提供 cipher.AEAD，不自己实现 Encode 和 Decode 的记录布局
+type gcmOnly struct{}
+
+func (gcmOnly) AEAD(secret []byte) (cipher.AEAD, error) {
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (gcmOnly) Encode(secret, data []byte) ([]byte, error) {
+	panic("transformer must seal with AEAD")
+}
+
+func (gcmOnly) Decode(secret, data []byte) ([]byte, error) {
+	panic("transformer must open with AEAD")
+}
+
+func TestSealedValueLayout(t *testing.T) {
+	secret := []byte("1234567890abcdef")
+	plain := []byte("example-data")
+
+	writer := NewTransformer()
+	err := writer.SetEncryptor(AESGCMEncryptor, secret)
+	if err != nil {
+		t.Fatalf("failed to set encryptor: %v", err)
+	}
+
+	data, flags, err := writer.encode(Text, plain)
+	if err != nil {
+		t.Fatalf("failed to encode data: %v", err)
+	}
+	if flags&flagEncrypted == 0 {
+		t.Errorf("expected encrypted flag, got %08b", flags)
+	}
+
+	sealed, err := ParseSealedValue(data, 12, 16)
+	if err != nil {
+		t.Fatalf("failed to parse sealed value: %v", err)
+	}
+	if len(sealed.Ciphertext) != len(plain) {
+		t.Errorf("expected ciphertext length %d, got %d", len(plain), len(sealed.Ciphertext))
+	}
+
+	// 另一个 AEADEncryptor 实现按照同样的布局解密
+	reader := NewTransformer()
+	err = reader.SetEncryptor(gcmOnly{}, secret)
+	if err != nil {
+		t.Fatalf("failed to set encryptor: %v", err)
+	}
+	decoded, err := reader.Decode(data)
+	if err != nil {
+		t.Fatalf("failed to decode data: %v", err)
+	}
+	if !bytes.Equal(decoded, plain) {
+		t.Errorf("expected %s, got %s", plain, decoded)
+	}
+
+	// 修改认证标签之后解密失败
+	sealed.Tag[0] ^= 0xFF
+	if _, err := reader.Decode(data); err == nil {
+		t.Error("expected tampered tag to fail authentication")
+	}
+
+	if _, err := ParseSealedValue(make([]byte, 20), 12, 16); err == nil {
+		t.Error("expected error for too short sealed value")
+	}
+}
+
+// 测试开启加密之后 hint 文件、索引快照和稀疏索引中不会出现明文的 Key
+// 通过 Options 设置的密钥在恢复之前生效，重新打开时直接从加密的索引快照恢复
+func TestEncryptedMetadata(t *testing.T) {
+	saved := *transformer
+	defer func() { *transformer = saved }()
+
+	path := t.TempDir()
+	opt := &Options{Path: path, FsPerm: fsPerm, Threshold: 1, Encryptor: AESGCMEncryptor, Secret: []byte("0123456789abcdef")}
+	lfs, err := OpenFS(opt)
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("secret-key-%03d", i)
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value"), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+		if i == 99 {
+			if err := lfs.ChangeRegions(); err != nil {
+				t.Fatalf("failed to change regions: %v", err)
+			}
+		}
+	}
+	mustCloseFS(t, lfs)
+
+	region := filepath.Join(path, formatDataFileName(1))
+	plaintext := func(names ...string) {
+		t.Helper()
+		for _, name := range names {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatalf("failed to read %s: %v", name, err)
+			}
+			if bytes.Contains(data, []byte("secret-key")) {
+				t.Errorf("expected %s to be encrypted", filepath.Base(name))
+			}
+		}
+	}
+	plaintext(filepath.Join(path, indexFileName), hintFileName(region))
+
+	// 删除 hint 文件并且损坏第一个数据文件，只有从索引快照恢复才能成功打开
+	original, err := os.ReadFile(region)
+	if err != nil {
+		t.Fatalf("failed to read region: %v", err)
+	}
+	hint, err := os.ReadFile(hintFileName(region))
+	if err != nil {
+		t.Fatalf("failed to read hint: %v", err)
+	}
+	corrupted := append([]byte(nil), original...)
+	corrupted[len(dataFileMetadata)+30] ^= 0xFF
+	if err := os.WriteFile(region, corrupted, fsPerm); err != nil {
+		t.Fatalf("failed to corrupt region: %v", err)
+	}
+	if err := os.Remove(hintFileName(region)); err != nil {
+		t.Fatalf("failed to remove hint: %v", err)
+	}
+
+	lfs, err = OpenFS(opt)
+	if err != nil {
+		t.Fatalf("expected encrypted snapshot to be loaded: %v", err)
+	}
+	if n := lfs.Count(); n != 200 {
+		t.Errorf("expected 200 keys from encrypted snapshot, got %d", n)
+	}
+
+	idx := &sparseIndex{size: 128, last: "secret-key-099", blocks: []sparseBlock{{Key: "secret-key-000"}, {Key: "secret-key-050", Offset: 64}}}
+	if err := saveSparseIndex(region, idx, fsPerm); err != nil {
+		t.Fatalf("failed to save sparse index: %v", err)
+	}
+	plaintext(sparseFileName(region))
+	loaded, err := loadSparseIndex(region)
+	if err != nil || loaded.last != idx.last || len(loaded.blocks) != 2 || loaded.blocks[1] != idx.blocks[1] {
+		t.Fatalf("failed to load encrypted sparse index: %+v, %v", loaded, err)
+	}
+	mustCloseFS(t, lfs)
+
+	if err := os.WriteFile(region, original, fsPerm); err != nil {
+		t.Fatalf("failed to restore region: %v", err)
+	}
+	if err := os.WriteFile(hintFileName(region), hint, fsPerm); err != nil {
+		t.Fatalf("failed to restore hint: %v", err)
+	}
+
+	// 关闭之后密钥被清零，没有密钥时不能读取加密的快照和 hint 文件，全局扫描数据文件重建索引
+	if _, err := loadSparseIndex(region); err == nil {
+		t.Error("expected error loading encrypted sparse index without encryptor")
+	}
+	removeSparseIndex(region)
+	if _, _, err := parseHint(region, 1); err == nil {
+		t.Error("expected error parsing encrypted hint without encryptor")
+	}
+	lfs, err = OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to reopen fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+	if n := lfs.Count(); n != 200 {
+		t.Errorf("expected 200 keys without encryptor, got %d", n)
+	}
+}
+
+// 测试最后一个实例关闭之后密钥被清零，更换密钥时之前的密钥也被清零
+func TestWipeSecret(t *testing.T) {
+	saved := *transformer
+	defer func() { *transformer = saved }()
+
+	tf := NewTransformer()
+	if err := tf.SetEncryptor(AESGCMEncryptor, []byte("0123456789abcdef")); err != nil {
+		t.Fatalf("failed to set encryptor: %v", err)
+	}
+	rotated := tf.secret
+	if err := tf.SetEncryptor(AESGCMEncryptor, []byte("fedcba9876543210")); err != nil {
+		t.Fatalf("failed to rotate secret: %v", err)
+	}
+	if !bytes.Equal(rotated, make([]byte, len(rotated))) {
+		t.Errorf("expected rotated secret to be wiped, got %q", rotated)
+	}
+
+	// OpenFS 复制 Options 中的密钥，调用方清零自己的 Secret 不影响打开的实例
+	key := []byte("fedcba9876543210")
+	opt := &Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1, Encryptor: AESGCMEncryptor, Secret: key}
+	writer, err := OpenFS(opt)
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	wipe(key)
+	reader, err := OpenFS(&Options{Path: opt.Path, FsPerm: fsPerm, Threshold: 1, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("failed to open read-only fs: %v", err)
+	}
+	secret := transformer.secret
+
+	// 还有打开的实例时保留密钥
+	mustCloseFS(t, reader)
+	if !transformer.IsEncryptionEnabled() || !bytes.Equal(secret, []byte("fedcba9876543210")) {
+		t.Fatal("expected secret to be kept while writer is open")
+	}
+	err = writer.AddSegment(InodeNum("key"), *testSegment("key", "value"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+
+	mustCloseFS(t, writer)
+	if transformer.secret != nil || transformer.aead != nil || transformer.Encryptor != nil || transformer.IsEncryptionEnabled() {
+		t.Error("expected secret not to be reachable after close")
+	}
+	if !bytes.Equal(secret, make([]byte, len(secret))) {
+		t.Errorf("expected secret to be wiped, got %q", secret)
+	}
+}
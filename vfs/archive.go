@@ -0,0 +1,40 @@
+package vfs
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+)
+
+// isExpired 判断过期时间为 expiredAt 的记录是否已经过期，0 表示永不过期
+func isExpired(expiredAt uint64) bool {
+	return expiredAt > 0 && expiredAt <= uint64(time.Now().Unix())
+}
+
+// dropExpired 从内存索引中删除已经过期的记录，迁移期间被更新了就保留新的记录
+func (lfs *LogStructuredFS) dropExpired(inum uint64, inode *INode) {
+	shard := lfs.indexs[inum%uint64(indexShard)]
+	shard.mu.Lock()
+	current, ok := shard.get(inum)
+	if ok && sameINode(current, inode) {
+		shard.remove(inum)
+	}
+	shard.mu.Unlock()
+
+	if ok && sameINode(current, inode) {
+		lfs.indexBytes.Add(-inodeMemory(len(inode.Key)))
+		lfs.markDead(inode)
+		lfs.cache.remove(inum)
+	}
+}
+
+// archiveRegion 把即将被删除的数据文件完整地复制到归档 Backend 中，归档失败时数据文件不会被删除
+func (lfs *LogStructuredFS) archiveRegion(fd vfsFile, size int64) error {
+	name := filepath.Base(fd.Name())
+	err := lfs.archive.Put(name, io.NewSectionReader(fd, 0, size), size)
+	if err != nil {
+		return fmt.Errorf("failed to archive region %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package vfs
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestArchiveRegion(t *testing.T) {
+	archive := NewLocalBackend(t.TempDir())
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1, Archive: archive})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer lfs.CloseFS()
+
+	expired := testSegment("key-01", "value-01")
+	expired.ExpiredAt = uint64(time.Now().Add(-time.Minute).Unix())
+	err = lfs.AddSegment(InodeNum("key-01"), *expired, 0)
+	if err != nil {
+		t.Fatalf("failed to add expired segment: %v", err)
+	}
+
+	err = lfs.AddSegment(InodeNum("key-02"), *testSegment("key-02", "value-01"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+
+	err = lfs.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+
+	err = lfs.AddSegment(InodeNum("key-02"), *testSegment("key-02", "value-02"), 0)
+	if err != nil {
+		t.Fatalf("failed to update segment: %v", err)
+	}
+
+	// 第一个数据文件中只有过期的记录和被覆盖的记录
+	err = lfs.compactRegion(1)
+	if err != nil {
+		t.Fatalf("failed to compact region: %v", err)
+	}
+
+	names, err := archive.List()
+	if err != nil {
+		t.Fatalf("failed to list archive: %v", err)
+	}
+	if len(names) != 1 || names[0] != formatDataFileName(1) {
+		t.Fatalf("expected region 1 to be archived, got %v", names)
+	}
+
+	_, err = lfs.FetchSegment(InodeNum("key-01"))
+	if !errors.Is(err, ErrSegmentNotFound) {
+		t.Errorf("expected expired segment to be dropped, got: %v", err)
+	}
+
+	seg, err := lfs.FetchSegment(InodeNum("key-02"))
+	if err != nil || string(seg.Value) != "value-02" {
+		t.Errorf("expected value-02, got %v (err: %v)", seg, err)
+	}
+}
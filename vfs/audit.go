@@ -0,0 +1,268 @@
+package vfs
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var ErrAuditChainBroken = errors.New("audit log hash chain broken")
+
+// AuditOp 审计日志记录的修改操作
+type AuditOp string
+
+const (
+	AuditPut         AuditOp = "put"
+	AuditDelete      AuditOp = "delete"
+	AuditDeleteRange AuditOp = "delete_range"
+	AuditExpire      AuditOp = "expire"
+)
+
+// AuditEntry 是审计日志中的一行记录，Hash 覆盖记录的所有字段和上一条记录的 Hash
+// 修改、插入或者删除中间的任何一条记录都会让之后的哈希链校验失败
+type AuditEntry struct {
+	Seq    uint64  `json:"seq"`
+	Time   int64   `json:"time"` // UNIX 纳秒时间戳
+	Client string  `json:"client,omitempty"`
+	Op     AuditOp `json:"op"`
+	Key    string  `json:"key"`
+	End    string  `json:"end,omitempty"` // 范围删除的结束位置
+	Prev   string  `json:"prev"`
+	Hash   string  `json:"hash"`
+}
+
+// digest 计算记录的 SHA-256 哈希，变长字段带上长度前缀，字段之间的边界不会产生歧义
+func (e *AuditEntry) digest() string {
+	h := sha256.New()
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], e.Seq)
+	h.Write(buf[:])
+	binary.LittleEndian.PutUint64(buf[:], uint64(e.Time))
+	h.Write(buf[:])
+	for _, field := range []string{e.Client, string(e.Op), e.Key, e.End, e.Prev} {
+		binary.LittleEndian.PutUint64(buf[:], uint64(len(field)))
+		h.Write(buf[:])
+		h.Write([]byte(field))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// auditEntryJSON 是 AuditEntry 在审计日志中的 JSON 格式
+type auditEntryJSON AuditEntry
+
+// auditBase64 是 Key 和 End 使用 base64 编码时 encoding 字段的值
+const auditBase64 = "base64"
+
+// MarshalJSON 在 Key 或者 End 不是合法的 UTF-8 时使用 base64 编码这两个字段
+// JSON 字符串会把非法的字节替换为 U+FFFD，直接编码会让读取之后的哈希校验失败
+func (e AuditEntry) MarshalJSON() ([]byte, error) {
+	v := struct {
+		auditEntryJSON
+		Encoding string `json:"encoding,omitempty"`
+	}{auditEntryJSON: auditEntryJSON(e)}
+	if !utf8.ValidString(e.Key) || !utf8.ValidString(e.End) {
+		v.Key = base64.StdEncoding.EncodeToString([]byte(e.Key))
+		v.End = base64.StdEncoding.EncodeToString([]byte(e.End))
+		v.Encoding = auditBase64
+	}
+	return json.Marshal(&v)
+}
+
+func (e *AuditEntry) UnmarshalJSON(data []byte) error {
+	var v struct {
+		auditEntryJSON
+		Encoding string `json:"encoding,omitempty"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	switch v.Encoding {
+	case "":
+	case auditBase64:
+		key, err := base64.StdEncoding.DecodeString(v.Key)
+		if err != nil {
+			return fmt.Errorf("invalid base64 key: %w", err)
+		}
+		end, err := base64.StdEncoding.DecodeString(v.End)
+		if err != nil {
+			return fmt.Errorf("invalid base64 end: %w", err)
+		}
+		v.Key, v.End = string(key), string(end)
+	default:
+		return fmt.Errorf("unknown audit entry encoding: %s", v.Encoding)
+	}
+
+	*e = AuditEntry(v.auditEntryJSON)
+	return nil
+}
+
+type clientIDKey struct{}
+
+// WithClientID 返回携带客户端标识的 ctx，通过它写入的修改操作会在审计日志中记录这个标识
+func WithClientID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, clientIDKey{}, id)
+}
+
+// ClientID 返回 ctx 中携带的客户端标识，没有设置时返回空字符串
+func ClientID(ctx context.Context) string {
+	id, _ := ctx.Value(clientIDKey{}).(string)
+	return id
+}
+
+// auditLog 是只追加的审计日志文件，每行是一条 JSON 格式的 AuditEntry
+type auditLog struct {
+	mu   sync.Mutex
+	fd   *os.File
+	seq  uint64
+	prev string
+}
+
+// openAuditLog 打开审计日志，已有的日志会先校验整条哈希链，新的记录接在最后一条记录之后
+// 哈希链校验失败时拒绝打开，不会在被篡改过的日志后面继续追加
+func openAuditLog(path string, perm fs.FileMode) (*auditLog, error) {
+	a := new(auditLog)
+
+	file, err := os.Open(path)
+	if err == nil {
+		a.seq, a.prev, err = readAuditLog(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify audit log: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	a.fd, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, perm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	return a, nil
+}
+
+// record 追加一条审计记录，没有开启审计日志时什么都不做
+func (a *auditLog) record(client string, op AuditOp, key, end string) error {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	entry := AuditEntry{
+		Seq:    a.seq + 1,
+		Time:   time.Now().UnixNano(),
+		Client: client,
+		Op:     op,
+		Key:    key,
+		End:    end,
+		Prev:   a.prev,
+	}
+	entry.Hash = entry.digest()
+
+	data, err := json.Marshal(&entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+
+	_, err = a.fd.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+
+	a.seq, a.prev = entry.Seq, entry.Hash
+
+	return nil
+}
+
+func (a *auditLog) close() error {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return closeFile(a.fd)
+}
+
+// VerifyAuditLog 校验审计日志的哈希链，返回日志中的记录数量
+func VerifyAuditLog(path string) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	seq, _, err := readAuditLog(file)
+	return seq, err
+}
+
+// readAuditLog 逐行校验审计记录，返回最后一条记录的序号和 Hash
+func readAuditLog(r io.Reader) (uint64, string, error) {
+	var (
+		seq  uint64
+		prev string
+	)
+
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return seq, prev, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, "", err
+		}
+
+		// 没有换行符结尾的记录是不完整的写入
+		if err == io.EOF {
+			return 0, "", fmt.Errorf("%w: incomplete entry after seq %d", ErrAuditChainBroken, seq)
+		}
+
+		var entry AuditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return 0, "", fmt.Errorf("%w: invalid entry after seq %d: %v", ErrAuditChainBroken, seq, err)
+		}
+		if entry.Seq != seq+1 || entry.Prev != prev || entry.Hash != entry.digest() {
+			return 0, "", fmt.Errorf("%w: at seq %d", ErrAuditChainBroken, seq+1)
+		}
+
+		seq, prev = entry.Seq, entry.Hash
+	}
+}
+
+// auditSegments 为已经写入的记录追加审计记录
+func (lfs *LogStructuredFS) auditSegments(ctx context.Context, segs ...*Segment) error {
+	if lfs.audit == nil {
+		return nil
+	}
+
+	client := ClientID(ctx)
+	for _, seg := range segs {
+		op := AuditPut
+		if seg.IsTombstone() {
+			op = AuditDelete
+		}
+		err := lfs.audit.record(client, op, string(seg.Key), "")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
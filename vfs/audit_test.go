@@ -0,0 +1,86 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	lfs, err := OpenFS(&Options{Path: dir, FsPerm: fsPerm, Threshold: 1, AuditLog: path})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+
+	ctx := WithClientID(context.Background(), "client-01")
+	err = lfs.AddSegmentContext(ctx, InodeNum("key-01"), *testSegment("key-01", "value"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+	err = lfs.DelSegment("key-01")
+	if err != nil {
+		t.Fatalf("failed to delete segment: %v", err)
+	}
+	err = lfs.DeletePrefix("key-")
+	if err != nil {
+		t.Fatalf("failed to delete prefix: %v", err)
+	}
+	mustCloseFS(t, lfs)
+
+	n, err := VerifyAuditLog(path)
+	if err != nil {
+		t.Fatalf("failed to verify audit log: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 audit entries, got %d", n)
+	}
+
+	// 重新打开之后新的记录接在原来的哈希链后面
+	lfs, err = OpenFS(&Options{Path: dir, FsPerm: fsPerm, Threshold: 1, AuditLog: path})
+	if err != nil {
+		t.Fatalf("failed to reopen fs: %v", err)
+	}
+	err = lfs.AddSegment(InodeNum("key-02"), *testSegment("key-02", "value"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+	mustCloseFS(t, lfs)
+
+	n, err = VerifyAuditLog(path)
+	if err != nil {
+		t.Fatalf("failed to verify audit log: %v", err)
+	}
+	if n != 4 {
+		t.Errorf("expected 4 audit entries, got %d", n)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+	if !bytes.Contains(data, []byte(`"client":"client-01","op":"put","key":"key-01"`)) {
+		t.Errorf("expected client id in audit log, got %s", data)
+	}
+
+	// 修改任何一条记录都会破坏哈希链，存储引擎拒绝继续追加
+	tampered := bytes.Replace(data, []byte(`"op":"delete"`), []byte(`"op":"put"`), 1)
+	err = os.WriteFile(path, tampered, fsPerm)
+	if err != nil {
+		t.Fatalf("failed to tamper audit log: %v", err)
+	}
+
+	_, err = VerifyAuditLog(path)
+	if !errors.Is(err, ErrAuditChainBroken) {
+		t.Errorf("expected ErrAuditChainBroken, got: %v", err)
+	}
+
+	_, err = OpenFS(&Options{Path: dir, FsPerm: fsPerm, Threshold: 1, AuditLog: path})
+	if !errors.Is(err, ErrAuditChainBroken) {
+		t.Errorf("expected open to fail with ErrAuditChainBroken, got: %v", err)
+	}
+}
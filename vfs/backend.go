@@ -0,0 +1,263 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/auula/wiredkv/utils"
+)
+
+// Backend 存放只读的非活跃数据文件，活跃数据文件和内存索引始终保存在本地
+// 例如把冷数据放到 S3 兼容的对象存储中，只在读取时按照位置读取需要的部分
+type Backend interface {
+	// Open 打开一个只读的数据文件
+	Open(name string) (BackendFile, error)
+	// Put 写入一个完整的数据文件，size 为数据文件的字节数
+	Put(name string, r io.Reader, size int64) error
+	// List 返回所有数据文件的名称，按照字典序排序
+	List() ([]string, error)
+	// Delete 删除一个数据文件，数据文件不存在时不返回错误
+	Delete(name string) error
+}
+
+// BackendFile 是 Backend 中一个只读的数据文件
+type BackendFile interface {
+	io.ReaderAt
+	io.Closer
+	Name() string
+	Size() int64
+}
+
+var ErrBackendFileNotFound = errors.New("backend file not found")
+
+// LocalBackend 把数据文件保存在本地目录中，是默认的 Backend
+type LocalBackend struct {
+	Dir string
+}
+
+// NewLocalBackend 创建一个使用本地目录 dir 的 Backend
+func NewLocalBackend(dir string) *LocalBackend {
+	return &LocalBackend{Dir: dir}
+}
+
+type localFile struct {
+	*os.File
+	size int64
+}
+
+func (f *localFile) Size() int64 {
+	return f.size
+}
+
+// sealedFile 把本地的数据文件包装成 BackendFile，崩溃恢复时和 Backend 中的数据文件一起扫描
+type sealedFile struct {
+	vfsFile
+	size int64
+}
+
+func (f *sealedFile) Size() int64 {
+	return f.size
+}
+
+func (b *LocalBackend) Open(name string) (BackendFile, error) {
+	fd, err := os.Open(filepath.Join(b.Dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrBackendFileNotFound, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	finfo, err := fd.Stat()
+	if err != nil {
+		_ = utils.CloseFile(fd)
+		return nil, err
+	}
+
+	return &localFile{File: fd, size: finfo.Size()}, nil
+}
+
+func (b *LocalBackend) Put(name string, r io.Reader, size int64) error {
+	err := os.MkdirAll(b.Dir, fsPerm)
+	if err != nil {
+		return err
+	}
+
+	// 先写入临时文件再重命名，其他读取方不会看到只写入一半的数据文件
+	path := filepath.Join(b.Dir, name)
+	out, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fsPerm)
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(out, r)
+	if err == nil && n != size {
+		err = fmt.Errorf("short write %d of %d bytes", n, size)
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := utils.CloseFile(out); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(path + ".tmp")
+		return fmt.Errorf("failed to put backend file %s: %w", name, err)
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+func (b *LocalBackend) List() ([]string, error) {
+	files, err := os.ReadDir(b.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, file := range files {
+		if !file.IsDir() && filepath.Ext(file.Name()) != ".tmp" {
+			names = append(names, file.Name())
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (b *LocalBackend) Delete(name string) error {
+	err := os.Remove(filepath.Join(b.Dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// regionSources 返回所有本地和 Backend 中的数据文件，调用方需要持有 lfs.mu
+func (lfs *LogStructuredFS) regionSources() (map[uint64]BackendFile, error) {
+	sources := make(map[uint64]BackendFile, len(lfs.regions)+len(lfs.cold))
+	for regionID, fd := range lfs.regions {
+		finfo, err := fd.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get region file info: %w", err)
+		}
+		sources[regionID] = &sealedFile{vfsFile: fd, size: finfo.Size()}
+	}
+	for regionID, fd := range lfs.cold {
+		sources[regionID] = fd
+	}
+	return sources, nil
+}
+
+// regionHoles 只有本地数据文件才会有空洞文件，迁移到 Backend 之前数据文件不能有空洞
+func regionHoles(fd BackendFile) (holeMap, error) {
+	if local, ok := fd.(*sealedFile); ok {
+		return fileHoles(local.vfsFile)
+	}
+	return make(holeMap), nil
+}
+
+// recoverColdRegions 打开 Backend 中所有的数据文件，调用方需要持有 lfs.mu
+// 本地和 Backend 中同时存在的数据文件说明上一次迁移没有完成，以本地的数据文件为准
+func (lfs *LogStructuredFS) recoverColdRegions() error {
+	if lfs.backend == nil {
+		return nil
+	}
+
+	names, err := lfs.backend.List()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if !isRegionFileName(name) {
+			continue
+		}
+
+		regionID, err := parseDataFileName(name)
+		if err != nil {
+			return err
+		}
+		if _, ok := lfs.regions[regionID]; ok {
+			continue
+		}
+
+		fd, err := lfs.backend.Open(name)
+		if err != nil {
+			return err
+		}
+
+		version, checksum, err := readFileVersion(fd)
+		if err != nil {
+			_ = fd.Close()
+			return fmt.Errorf("failed to read backend region %s version: %w", name, err)
+		}
+
+		lfs.cold[regionID] = fd
+		lfs.versions[regionID] = version
+		lfs.checksums[regionID] = checksum
+	}
+
+	return nil
+}
+
+// OffloadRegion 把一个非活跃数据文件迁移到 Backend 中，之后的读取会直接访问 Backend
+// 内存索引保持不变，迁移之后的数据文件不会再被压缩，有空洞的数据文件需要先压缩
+func (lfs *LogStructuredFS) OffloadRegion(regionID uint64) error {
+	if lfs.backend == nil {
+		return errors.New("no backend configured for offloading regions")
+	}
+	if lfs.readOnly {
+		return ErrReadOnly
+	}
+
+	lfs.compactMu.Lock()
+	defer lfs.compactMu.Unlock()
+
+	lfs.mu.Lock()
+	fd, ok := lfs.regions[regionID]
+	lfs.mu.Unlock()
+	if !ok || regionID == lfs.activeRegionID() {
+		return fmt.Errorf("region %d is not a sealed local region", regionID)
+	}
+
+	if lfs.hasHoles(regionID) {
+		return fmt.Errorf("region %d has punched holes, compact it before offloading", regionID)
+	}
+
+	finfo, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Base(fd.Name())
+	err = lfs.backend.Put(name, io.NewSectionReader(fd, 0, finfo.Size()), finfo.Size())
+	if err != nil {
+		return fmt.Errorf("failed to offload region %d: %w", regionID, err)
+	}
+
+	cold, err := lfs.backend.Open(name)
+	if err != nil {
+		return err
+	}
+
+	lfs.mu.Lock()
+	delete(lfs.regions, regionID)
+	lfs.cold[regionID] = cold
+	lfs.mu.Unlock()
+
+	err = closeFile(fd)
+	if err != nil {
+		return err
+	}
+
+	// 先切换到 Backend 再删除本地文件，崩溃之后本地文件仍然存在时以本地文件为准
+	return removeRegionFile(fd)
+}
@@ -0,0 +1,205 @@
+package vfs
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 是一个只支持 path-style 地址的内存对象存储
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/bucket":
+		var keys []string
+		for k := range s.objects {
+			if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		io.WriteString(w, "<ListBucketResult>")
+		for _, k := range keys {
+			io.WriteString(w, "<Contents><Key>"+k+"</Key></Contents>")
+		}
+		io.WriteString(w, "<IsTruncated>false</IsTruncated></ListBucketResult>")
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		s.objects[key] = data
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		data, ok := s.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.ServeContent(w, r, key, time.Time{}, bytes.NewReader(data))
+	}
+}
+
+func TestBackends(t *testing.T) {
+	server := httptest.NewServer(&fakeS3{objects: make(map[string][]byte)})
+	defer server.Close()
+
+	backends := map[string]Backend{
+		"Local": NewLocalBackend(t.TempDir()),
+		"S3": &S3Backend{
+			Endpoint:  server.URL,
+			Region:    "us-east-1",
+			Bucket:    "bucket",
+			Prefix:    "wiredkv/",
+			AccessKey: "access",
+			SecretKey: "secret",
+		},
+	}
+
+	for name, backend := range backends {
+		t.Run(name, func(t *testing.T) {
+			data := []byte("0123456789abcdef")
+			for _, file := range []string{formatDataFileName(2), formatDataFileName(1)} {
+				err := backend.Put(file, bytes.NewReader(data), int64(len(data)))
+				if err != nil {
+					t.Fatalf("failed to put %s: %v", file, err)
+				}
+			}
+
+			names, err := backend.List()
+			if err != nil {
+				t.Fatalf("failed to list: %v", err)
+			}
+			if len(names) != 2 || names[0] != formatDataFileName(1) {
+				t.Fatalf("unexpected file list: %v", names)
+			}
+
+			file, err := backend.Open(formatDataFileName(1))
+			if err != nil {
+				t.Fatalf("failed to open: %v", err)
+			}
+			defer file.Close()
+
+			if file.Size() != int64(len(data)) {
+				t.Errorf("expected size %d, got %d", len(data), file.Size())
+			}
+
+			buf := make([]byte, 4)
+			n, err := file.ReadAt(buf, 10)
+			if err != nil || string(buf[:n]) != "abcd" {
+				t.Errorf("expected abcd, got %q (err: %v)", buf[:n], err)
+			}
+
+			// 读取超过文件末尾的部分返回 io.EOF
+			n, err = file.ReadAt(buf, 14)
+			if err != io.EOF || string(buf[:n]) != "ef" {
+				t.Errorf("expected ef with io.EOF, got %q (err: %v)", buf[:n], err)
+			}
+
+			err = backend.Delete(formatDataFileName(2))
+			if err != nil {
+				t.Fatalf("failed to delete: %v", err)
+			}
+
+			_, err = backend.Open(formatDataFileName(2))
+			if err == nil {
+				t.Errorf("expected deleted file to be missing")
+			}
+		})
+	}
+}
+
+func TestOffloadRegion(t *testing.T) {
+	server := httptest.NewServer(&fakeS3{objects: make(map[string][]byte)})
+	defer server.Close()
+
+	backends := map[string]Backend{
+		"Local": NewLocalBackend(t.TempDir()),
+		"S3":    &S3Backend{Endpoint: server.URL, Region: "us-east-1", Bucket: "bucket", AccessKey: "access", SecretKey: "secret"},
+	}
+
+	for name, backend := range backends {
+		t.Run(name, func(t *testing.T) {
+			path := t.TempDir()
+			opt := &Options{Path: path, FsPerm: fsPerm, Threshold: 1, Backend: backend}
+			lfs, err := OpenFS(opt)
+			if err != nil {
+				t.Fatalf("failed to open fs: %v", err)
+			}
+
+			keys := []string{"key-01", "key-02", "key-03"}
+			for _, key := range keys {
+				err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value-"+key), 0)
+				if err != nil {
+					t.Fatalf("failed to add segment: %v", err)
+				}
+			}
+
+			err = lfs.ChangeRegions()
+			if err != nil {
+				t.Fatalf("failed to change regions: %v", err)
+			}
+
+			err = lfs.OffloadRegion(1)
+			if err != nil {
+				t.Fatalf("failed to offload region: %v", err)
+			}
+
+			if _, err := os.Stat(filepath.Join(path, formatDataFileName(1))); !os.IsNotExist(err) {
+				t.Fatalf("expected local region file to be removed, got: %v", err)
+			}
+
+			check := func(lfs *LogStructuredFS) {
+				t.Helper()
+				for _, key := range keys {
+					seg, err := lfs.FetchSegment(InodeNum(key))
+					if err != nil {
+						t.Fatalf("failed to fetch %s from backend: %v", key, err)
+					}
+					if string(seg.Value) != "value-"+key {
+						t.Errorf("unexpected value for %s: %s", key, seg.Value)
+					}
+				}
+			}
+			check(lfs)
+			mustCloseFS(t, lfs)
+
+			// 删除索引快照，崩溃恢复需要扫描 Backend 中的数据文件
+			err = os.Remove(filepath.Join(path, indexFileName))
+			if err != nil {
+				t.Fatalf("failed to remove index snapshot: %v", err)
+			}
+
+			lfs, err = OpenFS(opt)
+			if err != nil {
+				t.Fatalf("failed to reopen fs: %v", err)
+			}
+			defer lfs.CloseFS()
+			check(lfs)
+
+			if lfs.activeRegionID() <= 1 {
+				t.Errorf("expected active region after backend regions, got %d", lfs.activeRegionID())
+			}
+		})
+	}
+}
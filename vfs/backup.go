@@ -0,0 +1,329 @@
+package vfs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 备份时累积到这个大小之后写入一次 BackupWriter
+const backupChunk = 1 << 20
+
+// BackupName 生成的备份文件名的前缀、时间格式和扩展名，例如 wiredkv-20240101T080000Z.wdb
+const (
+	backupPrefix     = "wiredkv-"
+	backupTimeLayout = "20060102T150405Z"
+)
+
+var ErrBackupRunning = errors.New("another backup is already running")
+
+// BackupTarget 是备份文件的写入位置，例如本地目录或者对象存储
+type BackupTarget interface {
+	// Create 创建名为 name 的备份文件，写入的数据在 Commit 成功之后才可见
+	Create(ctx context.Context, name string) (BackupWriter, error)
+}
+
+// BackupWriter 按顺序写入一个备份文件，写入失败之后调用 Abort 清理已经写入的数据
+type BackupWriter interface {
+	io.Writer
+	Commit() error
+	Abort() error
+}
+
+// BackupPruner 是可以列出和删除备份文件的 BackupTarget，定期备份按照保留策略删除旧的备份
+type BackupPruner interface {
+	// List 返回所有备份文件的名字，包括不是 BackupName 生成的名字
+	List(ctx context.Context) ([]string, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// BackupSource 是可以读取备份文件的 BackupTarget，增量备份读取之前的清单，恢复增量备份时读取清单和数据文件
+type BackupSource interface {
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// BackupName 返回 t 时刻开始的备份使用的文件名，定期备份只会按照保留策略删除这种格式的备份
+func BackupName(t time.Time) string {
+	return backupPrefix + t.UTC().Format(backupTimeLayout) + fileExtension
+}
+
+// parseBackupName 返回 BackupName 生成的文件名中的时间
+func parseBackupName(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, fileExtension) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(backupTimeLayout, name[len(backupPrefix):len(name)-len(fileExtension)])
+	return t, err == nil
+}
+
+// BackupReport 记录一次备份的结果
+type BackupReport struct {
+	Name      string        // 备份文件的名字
+	Records   int           // 写入备份文件的记录条数
+	Bytes     int64         // 备份文件的字节数
+	Watermark uint64        // 备份开始时最大的版本号，之后写入的修改不一定包含在备份中
+	Encrypted bool          // 是否使用备份密钥加密
+	Regions   int           // 增量备份清单中的数据文件个数
+	Reused    int64         // 增量备份复用之前上传的数据文件字节数，Bytes 只包括这一次上传的字节数
+	Duration  time.Duration // 备份花费的时间
+}
+
+// Backup 把所有没有过期的记录写入 target 中名为 name 的备份文件，备份期间不会阻塞读写
+// 备份文件本身就是一个数据文件，记录没有重新编码，保留压缩和加密之后的数据
+// 恢复时通过 RestoreBackup 写入空的数据目录，打开数据目录时需要使用和备份时一样的加密密钥
+// 通过 SetBackupKey 设置备份密钥之后，记录改为使用备份密钥加密，整个备份文件也使用备份密钥分块加密
+// 同一时间只能执行一个备份，已经有备份在执行时返回 ErrBackupRunning，备份的结果通过 Stats 返回
+func (lfs *LogStructuredFS) Backup(ctx context.Context, target BackupTarget, name string) (*BackupReport, error) {
+	return lfs.runBackup(func(start time.Time) (*BackupReport, error) {
+		return lfs.backup(ctx, target, name, start)
+	})
+}
+
+// runBackup 保证同一时间只执行一个备份，并且记录备份的状态
+func (lfs *LogStructuredFS) runBackup(backup func(start time.Time) (*BackupReport, error)) (*BackupReport, error) {
+	if !lfs.backupMu.TryLock() {
+		return nil, ErrBackupRunning
+	}
+	defer lfs.backupMu.Unlock()
+
+	start := time.Now()
+	lfs.backups.start(start)
+	report, err := backup(start)
+	lfs.backups.finish(report, err)
+	return report, err
+}
+
+func (lfs *LogStructuredFS) backup(ctx context.Context, target BackupTarget, name string, start time.Time) (*BackupReport, error) {
+	entries, watermark, err := lfs.liveEntries(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := target.Create(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create backup: %w", err)
+	}
+	report := &BackupReport{Name: name, Watermark: watermark, Encrypted: lfs.backupAEAD != nil}
+	cw := &countingWriter{w: w}
+	if lfs.backupAEAD == nil {
+		err = lfs.writeBackup(ctx, cw, entries, nil, report)
+	} else {
+		sealer := newBackupSealer(cw, lfs.backupAEAD)
+		err = lfs.writeBackup(ctx, sealer, entries, lfs.backupAEAD, report)
+		if err == nil {
+			err = sealer.Close()
+		}
+	}
+	report.Bytes = cw.n
+	if err != nil {
+		_ = w.Abort()
+		return nil, err
+	}
+	err = w.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit backup: %w", err)
+	}
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+// writeBackup 把记录写入 w，aead 不为空时使用备份密钥重新加密每条记录的 Value
+func (lfs *LogStructuredFS) writeBackup(ctx context.Context, w io.Writer, entries []copyEntry, aead cipher.AEAD, report *BackupReport) error {
+	buf := fileMetadata(currentFormat, lfs.checksum)
+	flush := func() error {
+		_, err := w.Write(buf)
+		if err != nil {
+			return fmt.Errorf("failed to write backup: %w", err)
+		}
+		buf = buf[:0]
+		return nil
+	}
+
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		seg, err := lfs.readRawINode(e.inum, e.inode)
+		if errors.Is(err, ErrSegmentNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		// 和 CopyChanges 一样去掉批量写入的标志位，备份文件中没有对应的提交记录
+		rec := *seg
+		rec.Flags &^= flagBatch
+		if aead != nil {
+			rec.Value, rec.Flags, err = resealValue(aead, rec.Flags, rec.Value)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt backup record: %w", err)
+			}
+			rec.ValueSize = uint32(len(rec.Value))
+		}
+		buf, err = appendEncodedSegment(buf, &rec, currentFormat, lfs.checksum)
+		if err != nil {
+			return err
+		}
+		report.Records++
+		if len(buf) >= backupChunk {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// RestoreBackup 把 Backup 生成的备份文件写入 path 作为第一个数据文件，path 中不能已经有数据文件
+// 恢复之后第一次打开数据目录时通过扫描数据文件重建内存索引
+func RestoreBackup(r io.Reader, path string) error {
+	if err := prepareRestore(path); err != nil {
+		return err
+	}
+
+	br := bufio.NewReaderSize(r, backupChunk)
+	metadata := make([]byte, len(dataFileMetadata))
+	if _, err := io.ReadFull(br, metadata); err != nil {
+		return fmt.Errorf("failed to read backup metadata: %w", err)
+	}
+	if bytes.Equal(metadata, sealedBackupMetadata) {
+		return fmt.Errorf("failed to restore backup: %w, use RestoreEncryptedBackup", ErrBackupEncrypted)
+	}
+	if _, _, err := parseFileMetadata(metadata); err != nil {
+		return fmt.Errorf("failed to restore backup: %w", err)
+	}
+
+	name := filepath.Join(path, formatDataFileName(1))
+	fd, err := os.OpenFile(name+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fsPerm)
+	if err != nil {
+		return fmt.Errorf("failed to create restore region: %w", err)
+	}
+	defer os.Remove(name + ".tmp")
+
+	_, err = fd.Write(metadata)
+	if err == nil {
+		_, err = io.Copy(fd, br)
+	}
+	if err != nil {
+		_ = fd.Close()
+		return fmt.Errorf("failed to write restore region: %w", err)
+	}
+	if err := closeFile(fd); err != nil {
+		return fmt.Errorf("failed to close restore region: %w", err)
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+// countingWriter 记录写入 BackupWriter 的字节数
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// prepareRestore 创建恢复使用的数据目录，目录中已经有数据文件时返回错误
+func prepareRestore(path string) error {
+	err := os.MkdirAll(path, fsPerm)
+	if err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+	files, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if filepath.Ext(file.Name()) == fileExtension {
+			return fmt.Errorf("failed to restore backup: data directory %s is not empty", path)
+		}
+	}
+	return nil
+}
+
+// DirTarget 把备份文件写入本地目录，写入临时文件并且刷盘之后才改名为最终的名字
+type DirTarget struct {
+	Path string
+}
+
+// Create 在目录中创建 name.tmp，已经存在的同名备份在 Commit 时被替换
+func (t *DirTarget) Create(ctx context.Context, name string) (BackupWriter, error) {
+	err := os.MkdirAll(t.Path, fsPerm)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(t.Path, name)
+	fd, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &dirWriter{fd: fd, w: bufio.NewWriterSize(fd, backupChunk), path: path}, nil
+}
+
+// List 返回目录中所有的备份文件，不包括还没有提交的临时文件
+func (t *DirTarget) List(ctx context.Context) ([]string, error) {
+	files, err := os.ReadDir(t.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		if file.Type().IsRegular() && !strings.HasSuffix(file.Name(), ".tmp") {
+			names = append(names, file.Name())
+		}
+	}
+	return names, nil
+}
+
+// Delete 删除目录中的备份文件
+func (t *DirTarget) Delete(ctx context.Context, name string) error {
+	return os.Remove(filepath.Join(t.Path, filepath.Base(name)))
+}
+
+// Open 打开目录中的备份文件
+func (t *DirTarget) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(t.Path, filepath.Base(name)))
+}
+
+type dirWriter struct {
+	fd   *os.File
+	w    *bufio.Writer
+	path string
+}
+
+func (w *dirWriter) Write(p []byte) (int, error) {
+	return w.w.Write(p)
+}
+
+func (w *dirWriter) Commit() error {
+	err := w.w.Flush()
+	if err != nil {
+		_ = w.Abort()
+		return err
+	}
+	err = closeFile(w.fd)
+	if err != nil {
+		_ = os.Remove(w.fd.Name())
+		return err
+	}
+	return os.Rename(w.fd.Name(), w.path)
+}
+
+func (w *dirWriter) Abort() error {
+	_ = w.fd.Close()
+	return os.Remove(w.fd.Name())
+}
@@ -0,0 +1,223 @@
+package vfs
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 加密的备份文件头，和数据文件头的第二、三个字节不同，RestoreBackup 可以区分两种备份文件
+// 文件头之后是若干个加密分块，每个分块最多 backupChunk 字节明文，最后一个分块带有结束标志
+// | FINAL 1 | LEN 4 | NONCE ? | CIPHERTEXT ? | TAG ? |
+// 附加数据是 | INDEX 8 | FINAL 1 |，分块被删除、调换顺序或者文件被截断都会导致认证失败
+var sealedBackupMetadata = []byte{0xDB, 0xBE, 0xEB, 0x1}
+
+var ErrBackupEncrypted = errors.New("backup is encrypted")
+
+// SetBackupKey 设置备份使用的独立密钥，之后的 Backup 生成加密的备份文件，secret 为空时关闭备份加密
+// 备份密钥不能和静态加密的密钥相同，只持有备份密钥就可以通过 RestoreEncryptedBackup 恢复，不需要静态加密的密钥
+// 正在执行备份时等待备份完成之后再更换密钥
+func (lfs *LogStructuredFS) SetBackupKey(secret []byte) error {
+	lfs.backupMu.Lock()
+	defer lfs.backupMu.Unlock()
+
+	if len(secret) == 0 {
+		lfs.backupAEAD, lfs.backupKeyID = nil, ""
+		return nil
+	}
+	if len(secret) < 16 {
+		return errors.New("backup secret char length too short")
+	}
+	if subtle.ConstantTimeCompare(secret, transformer.secret) == 1 {
+		return errors.New("backup secret must be different from the encryption secret")
+	}
+	aead, err := AESGCMEncryptor.AEAD(secret)
+	if err != nil {
+		return fmt.Errorf("failed to create aead cipher: %w", err)
+	}
+	lfs.backupAEAD, lfs.backupKeyID = aead, backupKeyID(secret)
+	return nil
+}
+
+// backupKeyID 返回备份密钥的指纹，不能通过指纹反推出密钥
+func backupKeyID(secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("wiredkv backup key"))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// resealValue 去掉 Value 的静态加密之后使用备份密钥加密，压缩保持不变
+// 没有 flagTransform 标志位的旧记录按照当前的全局设置完整解码
+func resealValue(aead cipher.AEAD, flags uint8, data []byte) ([]byte, uint8, error) {
+	var err error
+	switch {
+	case flags&flagTransform == 0:
+		data, err = transformer.decode(flags, data)
+		flags &^= flagCompressed | flagCodec
+	case flags&flagEncrypted != 0:
+		data, err = transformer.decode(flags&^(flagCompressed|flagCodec), data)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sealed, err := sealValue(aead, data)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to encrypt data: %w", err)
+	}
+	return sealed, flags | flagTransform | flagEncrypted, nil
+}
+
+// backupSealer 把写入的数据按照 backupChunk 切分为加密分块，Close 写入最后一个分块
+type backupSealer struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	buf   []byte
+	index uint64
+}
+
+func newBackupSealer(w io.Writer, aead cipher.AEAD) *backupSealer {
+	return &backupSealer{w: w, aead: aead}
+}
+
+func (s *backupSealer) Write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+	for len(s.buf) > backupChunk {
+		if err := s.seal(s.buf[:backupChunk], false); err != nil {
+			return 0, err
+		}
+		s.buf = append(s.buf[:0], s.buf[backupChunk:]...)
+	}
+	return len(p), nil
+}
+
+// Close 写入剩余的数据作为最后一个分块，没有剩余的数据时写入一个空的分块
+func (s *backupSealer) Close() error {
+	err := s.seal(s.buf, true)
+	s.buf = nil
+	return err
+}
+
+func (s *backupSealer) seal(data []byte, final bool) error {
+	size := s.aead.NonceSize() + len(data) + s.aead.Overhead()
+	frame := make([]byte, 0, len(sealedBackupMetadata)+5+size)
+	if s.index == 0 {
+		frame = append(frame, sealedBackupMetadata...)
+	}
+	frame = append(frame, chunkFlag(final))
+	frame = binary.LittleEndian.AppendUint32(frame, uint32(size))
+
+	nonce := frame[len(frame) : len(frame)+s.aead.NonceSize()]
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	frame = s.aead.Seal(frame[:len(frame)+len(nonce)], nonce, data, chunkAD(s.index, final))
+	s.index++
+
+	_, err := s.w.Write(frame)
+	return err
+}
+
+// backupOpener 按顺序解密 backupSealer 生成的分块，读到最后一个分块之后返回 io.EOF
+type backupOpener struct {
+	r     io.Reader
+	aead  cipher.AEAD
+	buf   []byte
+	index uint64
+	final bool
+}
+
+func (o *backupOpener) Read(p []byte) (int, error) {
+	for len(o.buf) == 0 {
+		if o.final {
+			// 最后一个分块之后不能有多余的数据
+			if _, err := io.ReadFull(o.r, make([]byte, 1)); err == nil {
+				return 0, errors.New("failed to decrypt backup: unexpected data after the final chunk")
+			}
+			return 0, io.EOF
+		}
+		if err := o.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, o.buf)
+	o.buf = o.buf[n:]
+	return n, nil
+}
+
+func (o *backupOpener) open() error {
+	header := make([]byte, 5)
+	_, err := io.ReadFull(o.r, header)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("failed to decrypt backup: %w", err)
+	}
+	final := header[0] == chunkFlag(true)
+	size := binary.LittleEndian.Uint32(header[1:])
+	if header[0] > 1 || size > uint32(o.aead.NonceSize()+backupChunk+o.aead.Overhead()) {
+		return errors.New("failed to decrypt backup: invalid chunk header")
+	}
+
+	sealed := make([]byte, size)
+	_, err = io.ReadFull(o.r, sealed)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("failed to decrypt backup: %w", err)
+	}
+	parts, err := ParseSealedValue(sealed, o.aead.NonceSize(), o.aead.Overhead())
+	if err != nil {
+		return fmt.Errorf("failed to decrypt backup: %w", err)
+	}
+	o.buf, err = o.aead.Open(sealed[len(parts.Nonce):len(parts.Nonce)], parts.Nonce, sealed[len(parts.Nonce):], chunkAD(o.index, final))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt backup chunk %d: %w", o.index, err)
+	}
+	o.index++
+	o.final = final
+	return nil
+}
+
+func chunkFlag(final bool) byte {
+	if final {
+		return 1
+	}
+	return 0
+}
+
+func chunkAD(index uint64, final bool) []byte {
+	ad := binary.LittleEndian.AppendUint64(make([]byte, 0, 9), index)
+	return append(ad, chunkFlag(final))
+}
+
+// RestoreEncryptedBackup 使用 SetBackupKey 设置的备份密钥解密备份文件，然后和 RestoreBackup 一样写入 path
+// 恢复之后记录的 Value 仍然使用备份密钥加密，打开数据目录时使用备份密钥作为静态加密的密钥
+func RestoreEncryptedBackup(r io.Reader, path string, secret []byte) error {
+	if len(secret) < 16 {
+		return errors.New("backup secret char length too short")
+	}
+	aead, err := AESGCMEncryptor.AEAD(secret)
+	if err != nil {
+		return fmt.Errorf("failed to create aead cipher: %w", err)
+	}
+
+	metadata := make([]byte, len(sealedBackupMetadata))
+	if _, err := io.ReadFull(r, metadata); err != nil {
+		return fmt.Errorf("failed to read backup metadata: %w", err)
+	}
+	if !bytes.Equal(metadata, sealedBackupMetadata) {
+		return errors.New("failed to restore backup: backup is not encrypted")
+	}
+	return RestoreBackup(&backupOpener{r: r, aead: aead}, path)
+}
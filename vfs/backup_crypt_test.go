@@ -0,0 +1,127 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptedBackup(t *testing.T) {
+	saved := *transformer
+	defer func() { *transformer = saved }()
+	liveKey, backupKey := []byte("live-secret-0123"), []byte("backup-secret-01")
+	if err := transformer.SetEncryptor(AESGCMEncryptor, liveKey); err != nil {
+		t.Fatalf("failed to set encryptor: %v", err)
+	}
+
+	src, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open source fs: %v", err)
+	}
+	values := map[string]string{"secret-key-01": "secret-value-01", "secret-key-02": "secret-value-02"}
+	for key, value := range values {
+		seg, err := NewSegmentBytes(key, Binary, []byte(value), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := src.AddSegment(InodeNum(key), *seg, 0); err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+
+	if err := src.SetBackupKey(liveKey); err == nil {
+		t.Errorf("expected error when the backup key is the encryption key")
+	}
+	if err := src.SetBackupKey([]byte("short")); err == nil {
+		t.Errorf("expected error for short backup key")
+	}
+	if err := src.SetBackupKey(backupKey); err != nil {
+		t.Fatalf("failed to set backup key: %v", err)
+	}
+
+	dir := t.TempDir()
+	report, err := src.Backup(context.Background(), &DirTarget{Path: dir}, "backup.wdb")
+	if err != nil {
+		t.Fatalf("failed to backup: %v", err)
+	}
+	mustCloseFS(t, src)
+	data, err := os.ReadFile(filepath.Join(dir, "backup.wdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Encrypted || report.Records != 2 || report.Bytes != int64(len(data)) {
+		t.Fatalf("unexpected backup report: %+v, file %d bytes", report, len(data))
+	}
+	if bytes.Contains(data, []byte("secret-key")) {
+		t.Errorf("expected keys to be encrypted in the backup")
+	}
+
+	path := filepath.Join(t.TempDir(), "restored")
+	if err := RestoreBackup(bytes.NewReader(data), path); !errors.Is(err, ErrBackupEncrypted) {
+		t.Errorf("expected ErrBackupEncrypted, got %v", err)
+	}
+	if err := RestoreEncryptedBackup(bytes.NewReader(data), path, liveKey); err == nil {
+		t.Errorf("expected error when restoring with the encryption key")
+	}
+	if err := RestoreEncryptedBackup(bytes.NewReader(data[:len(data)-1]), path, backupKey); err == nil {
+		t.Errorf("expected error when restoring a truncated backup")
+	}
+	if err := RestoreEncryptedBackup(bytes.NewReader(data), path, backupKey); err != nil {
+		t.Fatalf("failed to restore backup: %v", err)
+	}
+
+	// 恢复之后只需要备份密钥就可以读取
+	if err := transformer.SetEncryptor(AESGCMEncryptor, backupKey); err != nil {
+		t.Fatalf("failed to set encryptor: %v", err)
+	}
+	dst, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open restored fs: %v", err)
+	}
+	defer mustCloseFS(t, dst)
+	for key, value := range values {
+		seg, err := dst.FetchSegment(InodeNum(key))
+		if err != nil || string(seg.Value) != value {
+			t.Errorf("expected %s=%s in restored fs, got %v %v", key, value, seg, err)
+		}
+	}
+}
+
+func TestBackupSealer(t *testing.T) {
+	aead, err := AESGCMEncryptor.AEAD([]byte("backup-secret-01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := bytes.Repeat([]byte("0123456789"), backupChunk/4)
+	var buf bytes.Buffer
+	sealer := newBackupSealer(&buf, aead)
+	if _, err := sealer.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := sealer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sealed := buf.Bytes()[len(sealedBackupMetadata):]
+
+	var out bytes.Buffer
+	if _, err := out.ReadFrom(&backupOpener{r: bytes.NewReader(sealed), aead: aead}); err != nil {
+		t.Fatalf("failed to open sealed backup: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), plain) {
+		t.Fatalf("expected %d bytes, got %d bytes", len(plain), out.Len())
+	}
+
+	// 删除第一个分块之后第二个分块的序号不匹配
+	first := 5 + aead.NonceSize() + backupChunk + aead.Overhead()
+	if _, err := out.ReadFrom(&backupOpener{r: bytes.NewReader(sealed[first:]), aead: aead}); err == nil {
+		t.Errorf("expected error when the first chunk is removed")
+	}
+	// 最后一个分块之后有多余的数据
+	extra := append(append([]byte(nil), sealed...), 0)
+	if _, err := out.ReadFrom(&backupOpener{r: bytes.NewReader(extra), aead: aead}); err == nil {
+		t.Errorf("expected error for trailing data")
+	}
+}
@@ -0,0 +1,538 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 增量备份清单的扩展名，例如 wiredkv-20240101T080000Z.manifest
+const manifestExtension = ".manifest"
+
+// 读取清单时最多读取的字节数，防止损坏的清单占用过多内存
+const maxManifestSize = 64 << 20
+
+// 清单中记录数据文件开头这么多字节的校验码，数据文件的名字被重新使用之后不会复用之前的对象
+const manifestHeadSize = 4 << 10
+
+// BackupManifest 是一次增量备份的清单，按照数据文件记录恢复时需要的对象
+// 非活跃数据文件不会再被修改，活跃数据文件只会追加，所以每个数据文件都是若干个按顺序拼接的对象
+// 之后的增量备份复用清单中已经上传的对象，只上传新的数据文件和活跃数据文件新追加的部分
+type BackupManifest struct {
+	Created   time.Time        `json:"created"`
+	Watermark uint64           `json:"watermark"`
+	KeyID     string           `json:"keyid,omitempty"` // 备份密钥的指纹，没有加密时为空
+	Regions   []ManifestRegion `json:"regions"`
+}
+
+// ManifestRegion 是清单中的一个数据文件
+type ManifestRegion struct {
+	Name  string         `json:"name"`
+	Size  int64          `json:"size"`            // 备份时数据文件的大小
+	Head  uint32         `json:"head"`            // 数据文件开头 manifestHeadSize 字节的 CRC32
+	Holes [][2]int64     `json:"holes,omitempty"` // 数据文件中被打洞的区间
+	Parts []ManifestPart `json:"parts"`
+}
+
+// ManifestPart 是数据文件中 [Offset, Offset + Size) 区间对应的对象
+type ManifestPart struct {
+	Object string `json:"object"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+}
+
+// ManifestName 返回 t 时刻开始的增量备份使用的清单名字，定期备份按照保留策略删除这种格式的清单
+func ManifestName(t time.Time) string {
+	return backupPrefix + t.UTC().Format(backupTimeLayout) + manifestExtension
+}
+
+// parseManifestName 返回 ManifestName 生成的清单名字中的时间
+func parseManifestName(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, manifestExtension) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(backupTimeLayout, name[len(backupPrefix):len(name)-len(manifestExtension)])
+	return t, err == nil
+}
+
+// partName 返回清单 name 中数据文件 region 的对象名，例如 wiredkv-20240101T080000Z.000000001.wdb
+func partName(name, region string) string {
+	return strings.TrimSuffix(name, manifestExtension) + "." + region
+}
+
+// parsePartName 返回 ManifestName 生成的清单中对象名的时间
+func parsePartName(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, backupPrefix) || len(name) < len(backupPrefix)+len(backupTimeLayout) {
+		return time.Time{}, false
+	}
+	stamp, region := name[len(backupPrefix):len(backupPrefix)+len(backupTimeLayout)], name[len(backupPrefix)+len(backupTimeLayout):]
+	if !strings.HasPrefix(region, ".") {
+		return time.Time{}, false
+	}
+	if _, err := parseDataFileName(region[1:]); err != nil || !strings.HasSuffix(region, fileExtension) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(backupTimeLayout, stamp)
+	return t, err == nil
+}
+
+// backupRegion 是增量备份开始时的一个数据文件
+type backupRegion struct {
+	name     string
+	fd       io.ReaderAt
+	size     int64
+	version  uint8
+	checksum Checksum
+	holes    holeMap
+}
+
+// IncrementalBackup 把所有数据文件按照文件备份到 target，name 是这一次备份的清单名字
+// target 同时实现了 BackupPruner 和 BackupSource 时，读取最新的 ManifestName 格式的清单，复用其中已经上传的对象
+// 备份期间暂停压缩和打洞，数据文件不会被删除或者修改，不会阻塞读写
+// 设置了备份密钥时每个对象都和 Backup 一样使用备份密钥加密，更换备份密钥之后第一次增量备份会上传所有的数据文件
+// 恢复时通过 RestoreIncrementalBackup 写入空的数据目录，不支持 EngineLSM
+func (lfs *LogStructuredFS) IncrementalBackup(ctx context.Context, target BackupTarget, name string) (*BackupReport, error) {
+	return lfs.runBackup(func(start time.Time) (*BackupReport, error) {
+		return lfs.incrementalBackup(ctx, target, name, start)
+	})
+}
+
+func (lfs *LogStructuredFS) incrementalBackup(ctx context.Context, target BackupTarget, name string, start time.Time) (*BackupReport, error) {
+	if lfs.lsm != nil {
+		return nil, errors.New("incremental backup does not support EngineLSM")
+	}
+	previous, err := latestManifest(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	reusable := make(map[string]*ManifestRegion)
+	if previous != nil && previous.KeyID == lfs.backupKeyID {
+		for i := range previous.Regions {
+			reusable[previous.Regions[i].Name] = &previous.Regions[i]
+		}
+	}
+
+	lfs.compactMu.Lock()
+	defer lfs.compactMu.Unlock()
+	regions, watermark, err := lfs.captureRegions()
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &BackupManifest{Created: start.UTC(), Watermark: watermark, KeyID: lfs.backupKeyID}
+	report := &BackupReport{Name: name, Watermark: watermark, Encrypted: lfs.backupAEAD != nil}
+	for _, region := range regions {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		head, err := regionHead(region.fd, region.size)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read region %s: %w", region.name, err)
+		}
+		entry := ManifestRegion{Name: region.name, Size: region.size, Head: head, Holes: region.holes.ranges()}
+		var covered int64
+		ok, err := lfs.reusable(reusable[region.name], region, &entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read region %s: %w", region.name, err)
+		}
+		if ok {
+			old := reusable[region.name]
+			entry.Parts = append(entry.Parts, old.Parts...)
+			covered = old.Size
+			report.Reused += covered
+		}
+		if covered < region.size {
+			part := ManifestPart{Object: partName(name, region.name), Offset: covered, Size: region.size - covered}
+			n, err := lfs.uploadRegion(ctx, target, part.Object, region, covered)
+			report.Bytes += n
+			if err != nil {
+				return nil, err
+			}
+			entry.Parts = append(entry.Parts, part)
+		}
+		manifest.Regions = append(manifest.Regions, entry)
+		report.Regions++
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	w, err := target.Create(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create backup manifest: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		_ = w.Abort()
+		return nil, fmt.Errorf("failed to write backup manifest: %w", err)
+	}
+	if err := w.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit backup manifest: %w", err)
+	}
+	report.Bytes += int64(len(data))
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+// reusable 判断之前的清单中的数据文件 old 是不是 region 的开头部分，可以复用已经上传的对象
+// 加密的对象中记录的位置和数据文件不同，空洞区间变化之后需要重新上传
+func (lfs *LogStructuredFS) reusable(old *ManifestRegion, region backupRegion, entry *ManifestRegion) (bool, error) {
+	if old == nil || old.Size > region.size {
+		return false, nil
+	}
+	if lfs.backupAEAD != nil && !equalRanges(old.Holes, entry.Holes) {
+		return false, nil
+	}
+	head := entry.Head
+	if old.Size < manifestHeadSize && old.Size < region.size {
+		var err error
+		if head, err = regionHead(region.fd, old.Size); err != nil {
+			return false, err
+		}
+	}
+	return head == old.Head, nil
+}
+
+// regionHead 返回数据文件开头 manifestHeadSize 字节的 CRC32，size 小于 manifestHeadSize 时只计算 size 字节
+func regionHead(fd io.ReaderAt, size int64) (uint32, error) {
+	if size > manifestHeadSize {
+		size = manifestHeadSize
+	}
+	buf := make([]byte, size)
+	if _, err := fd.ReadAt(buf, 0); err != nil && !(errors.Is(err, io.EOF) && len(buf) == 0) {
+		return 0, err
+	}
+	return crc32.ChecksumIEEE(buf), nil
+}
+
+// captureRegions 返回当前所有的数据文件，活跃数据文件只包括写入位置之前的部分，调用方需要持有 compactMu
+func (lfs *LogStructuredFS) captureRegions() ([]backupRegion, uint64, error) {
+	type sealedRegion struct {
+		id uint64
+		fd vfsFile
+	}
+	var (
+		regions []backupRegion
+		sealed  []sealedRegion
+	)
+	lfs.appendMu.Lock()
+	lfs.mu.Lock()
+	watermark := lfs.LastSequence()
+	for regionID, fd := range lfs.regions {
+		if regionID != lfs.regionID || lfs.active == nil {
+			sealed = append(sealed, sealedRegion{id: regionID, fd: fd})
+		}
+	}
+	for regionID, fd := range lfs.cold {
+		regions = append(regions, backupRegion{name: formatDataFileName(regionID), fd: fd, size: fd.Size(),
+			version: lfs.versions[regionID], checksum: lfs.checksums[regionID]})
+	}
+	if lfs.active != nil {
+		regions = append(regions, backupRegion{name: formatDataFileName(lfs.regionID), fd: lfs.active, size: lfs.offset,
+			version: currentFormat, checksum: lfs.checksum})
+	}
+	versions := make(map[uint64]uint8, len(sealed))
+	checksums := make(map[uint64]Checksum, len(sealed))
+	for _, r := range sealed {
+		versions[r.id], checksums[r.id] = lfs.versions[r.id], lfs.checksums[r.id]
+	}
+	lfs.mu.Unlock()
+	lfs.appendMu.Unlock()
+
+	// 持有 compactMu 时非活跃数据文件的大小和空洞不会变化
+	for _, r := range sealed {
+		finfo, err := r.fd.Stat()
+		if err != nil {
+			return nil, 0, err
+		}
+		region := backupRegion{name: formatDataFileName(r.id), fd: r.fd, size: finfo.Size(),
+			version: versions[r.id], checksum: checksums[r.id]}
+		if _, ok := r.fd.(*os.File); ok {
+			region.holes, err = loadHoles(r.fd.Name())
+			if err != nil {
+				return nil, 0, fmt.Errorf("failed to load holes: %w", err)
+			}
+		}
+		regions = append(regions, region)
+	}
+	sort.Slice(regions, func(i, j int) bool {
+		return regions[i].name < regions[j].name
+	})
+	return regions, watermark, nil
+}
+
+// uploadRegion 把数据文件中 start 之后的部分上传为 object，返回上传的字节数
+func (lfs *LogStructuredFS) uploadRegion(ctx context.Context, target BackupTarget, object string, region backupRegion, start int64) (int64, error) {
+	w, err := target.Create(ctx, object)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create backup object: %w", err)
+	}
+	cw := &countingWriter{w: w}
+	if lfs.backupAEAD == nil {
+		_, err = io.Copy(cw, io.NewSectionReader(region.fd, start, region.size-start))
+	} else {
+		sealer := newBackupSealer(cw, lfs.backupAEAD)
+		err = resealRegion(ctx, sealer, lfs.backupAEAD, region, start)
+		if err == nil {
+			err = sealer.Close()
+		}
+	}
+	if err != nil {
+		_ = w.Abort()
+		return cw.n, fmt.Errorf("failed to backup region %s: %w", region.name, err)
+	}
+	if err := w.Commit(); err != nil {
+		return cw.n, fmt.Errorf("failed to commit backup object: %w", err)
+	}
+	return cw.n, nil
+}
+
+// resealRegion 逐条读取数据文件中 start 之后的记录，使用备份密钥重新加密之后写入 w，跳过空洞区间
+// 格式版本 1 的记录不能保存标志位，重新加密之后按照格式版本 2 写入
+func resealRegion(ctx context.Context, w io.Writer, aead cipher.AEAD, region backupRegion, start int64) error {
+	version := region.version
+	if version == FormatV1 {
+		version = FormatV2
+	}
+	var buf []byte
+	offset := start
+	if start == 0 {
+		buf = fileMetadata(version, region.checksum)
+		offset = int64(len(dataFileMetadata))
+	}
+	for offset < region.size {
+		if end, ok := region.holes[offset]; ok {
+			offset = end
+			continue
+		}
+		seg, n, err := decodeSegment(region.fd, offset, region.version, region.checksum)
+		if err != nil {
+			return fmt.Errorf("failed to read segment at %d: %w", offset, err)
+		}
+		offset += n
+		// 删除记录和提交记录的 Value 不是用户数据
+		if !seg.IsTombstone() && seg.Flags&flagBatchCommit == 0 {
+			seg.Value, seg.Flags, err = resealValue(aead, seg.Flags, seg.Value)
+			if err != nil {
+				return err
+			}
+			seg.ValueSize = uint32(len(seg.Value))
+		}
+		buf, err = appendEncodedSegment(buf, seg, version, region.checksum)
+		if err != nil {
+			return err
+		}
+		if len(buf) >= backupChunk {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// ranges 返回按照开始位置排序的空洞区间
+func (h holeMap) ranges() [][2]int64 {
+	var ranges [][2]int64
+	for start, end := range h {
+		ranges = append(ranges, [2]int64{start, end})
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i][0] < ranges[j][0]
+	})
+	return ranges
+}
+
+func equalRanges(a, b [][2]int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// latestManifest 返回 target 中最新的 ManifestName 格式的清单，target 不能列出或者读取备份文件时返回 nil
+func latestManifest(ctx context.Context, target BackupTarget) (*BackupManifest, error) {
+	pruner, ok := target.(BackupPruner)
+	if !ok {
+		return nil, nil
+	}
+	source, ok := target.(BackupSource)
+	if !ok {
+		return nil, nil
+	}
+	names, err := pruner.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+	var (
+		latest string
+		at     time.Time
+	)
+	for _, name := range names {
+		if t, ok := parseManifestName(name); ok && t.After(at) {
+			latest, at = name, t
+		}
+	}
+	if latest == "" {
+		return nil, nil
+	}
+	return readManifest(ctx, source, latest)
+}
+
+func readManifest(ctx context.Context, source BackupSource, name string) (*BackupManifest, error) {
+	r, err := source.Open(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup manifest %s: %w", name, err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(io.LimitReader(r, maxManifestSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup manifest %s: %w", name, err)
+	}
+	if len(data) > maxManifestSize {
+		return nil, fmt.Errorf("backup manifest %s is too large", name)
+	}
+	manifest := new(BackupManifest)
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse backup manifest %s: %w", name, err)
+	}
+	return manifest, nil
+}
+
+// RestoreIncrementalBackup 按照 source 中名为 name 的清单把数据文件写入 path，path 中不能已经有数据文件
+// 加密的增量备份需要使用备份时的备份密钥 secret，恢复之后和 RestoreEncryptedBackup 一样使用备份密钥打开数据目录
+// 所有的数据文件都写入成功之后才改为最终的名字，第一次打开数据目录时通过扫描数据文件重建内存索引
+func RestoreIncrementalBackup(ctx context.Context, source BackupSource, name, path string, secret []byte) error {
+	manifest, err := readManifest(ctx, source, name)
+	if err != nil {
+		return err
+	}
+	var aead cipher.AEAD
+	if manifest.KeyID != "" {
+		if len(secret) == 0 {
+			return fmt.Errorf("failed to restore backup: %w, backup secret is required", ErrBackupEncrypted)
+		}
+		if backupKeyID(secret) != manifest.KeyID {
+			return errors.New("failed to restore backup: backup secret does not match the manifest")
+		}
+		aead, err = AESGCMEncryptor.AEAD(secret)
+		if err != nil {
+			return fmt.Errorf("failed to create aead cipher: %w", err)
+		}
+	}
+	if err := prepareRestore(path); err != nil {
+		return err
+	}
+
+	var restored []string
+	defer func() {
+		for _, name := range restored {
+			_ = os.Remove(name + ".tmp")
+		}
+	}()
+	for _, region := range manifest.Regions {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := parseDataFileName(region.Name); err != nil || filepath.Base(region.Name) != region.Name {
+			return fmt.Errorf("failed to restore backup: invalid region name %q", region.Name)
+		}
+		name := filepath.Join(path, region.Name)
+		restored = append(restored, name)
+		if err := restoreRegion(ctx, source, name+".tmp", region, aead); err != nil {
+			return fmt.Errorf("failed to restore region %s: %w", region.Name, err)
+		}
+		// 加密的对象中已经跳过了空洞区间
+		if aead == nil && len(region.Holes) > 0 {
+			holes := make(holeMap, len(region.Holes))
+			for _, r := range region.Holes {
+				holes[r[0]] = r[1]
+			}
+			if err := saveHoles(name, holes, fsPerm); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range restored {
+		if err := os.Rename(name+".tmp", name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func restoreRegion(ctx context.Context, source BackupSource, name string, region ManifestRegion, aead cipher.AEAD) error {
+	fd, err := os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fsPerm)
+	if err != nil {
+		return err
+	}
+	var offset int64
+	for _, part := range region.Parts {
+		if part.Offset != offset {
+			err = fmt.Errorf("part %s starts at %d, expected %d", part.Object, part.Offset, offset)
+			break
+		}
+		err = restorePart(ctx, source, fd, part, aead)
+		if err != nil {
+			break
+		}
+		offset += part.Size
+	}
+	if err == nil && offset != region.Size {
+		err = fmt.Errorf("parts cover %d bytes, expected %d", offset, region.Size)
+	}
+	if err != nil {
+		_ = fd.Close()
+		return err
+	}
+	return closeFile(fd)
+}
+
+func restorePart(ctx context.Context, source BackupSource, w io.Writer, part ManifestPart, aead cipher.AEAD) error {
+	r, err := source.Open(ctx, part.Object)
+	if err != nil {
+		return fmt.Errorf("failed to open backup object %s: %w", part.Object, err)
+	}
+	defer r.Close()
+
+	if aead == nil {
+		n, err := io.Copy(w, r)
+		if err != nil {
+			return err
+		}
+		if n != part.Size {
+			return fmt.Errorf("backup object %s has %d bytes, expected %d", part.Object, n, part.Size)
+		}
+		return nil
+	}
+
+	metadata := make([]byte, len(sealedBackupMetadata))
+	if _, err := io.ReadFull(r, metadata); err != nil {
+		return fmt.Errorf("failed to read backup object %s: %w", part.Object, err)
+	}
+	if !bytes.Equal(metadata, sealedBackupMetadata) {
+		return fmt.Errorf("backup object %s is not encrypted", part.Object)
+	}
+	_, err = io.Copy(w, &backupOpener{r: r, aead: aead})
+	return err
+}
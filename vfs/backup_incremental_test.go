@@ -0,0 +1,197 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIncrementalBackup(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, SegmentSize: minSegmentSize})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	value := string(bytes.Repeat([]byte("v"), 64*KB))
+	put := func(from, to int) {
+		t.Helper()
+		for i := from; i < to; i++ {
+			key := fmt.Sprintf("key-%03d", i)
+			if err := lfs.AddSegment(InodeNum(key), *testSegment(key, value), 0); err != nil {
+				t.Fatalf("failed to add segment: %v", err)
+			}
+		}
+	}
+	put(0, 40)
+
+	dir := t.TempDir()
+	target := &DirTarget{Path: dir}
+	start := time.Date(2024, 5, 10, 3, 0, 0, 0, time.UTC)
+	first, err := lfs.IncrementalBackup(context.Background(), target, ManifestName(start))
+	if err != nil {
+		t.Fatalf("failed to backup: %v", err)
+	}
+	if first.Regions < 3 || first.Reused != 0 {
+		t.Fatalf("unexpected first backup report: %+v", first)
+	}
+
+	// 第二次备份只上传新的数据文件和活跃数据文件新追加的部分
+	put(40, 45)
+	if err := lfs.DelSegment("key-000"); err != nil {
+		t.Fatalf("failed to delete segment: %v", err)
+	}
+	second, err := lfs.IncrementalBackup(context.Background(), target, ManifestName(start.Add(time.Hour)))
+	if err != nil {
+		t.Fatalf("failed to backup: %v", err)
+	}
+	if second.Reused == 0 || second.Bytes >= first.Bytes/2 {
+		t.Fatalf("expected second backup to reuse objects: first %+v, second %+v", first, second)
+	}
+
+	path := filepath.Join(t.TempDir(), "restored")
+	err = RestoreIncrementalBackup(context.Background(), target, ManifestName(start.Add(time.Hour)), path, nil)
+	if err != nil {
+		t.Fatalf("failed to restore backup: %v", err)
+	}
+	restored, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, SegmentSize: minSegmentSize})
+	if err != nil {
+		t.Fatalf("failed to open restored fs: %v", err)
+	}
+	defer mustCloseFS(t, restored)
+	if _, err := restored.FetchSegment(InodeNum("key-000")); err == nil {
+		t.Errorf("expected key-000 to be deleted in restored fs")
+	}
+	for _, key := range []string{"key-001", "key-039", "key-044"} {
+		seg, err := restored.FetchSegment(InodeNum(key))
+		if err != nil || string(seg.Value) != value {
+			t.Errorf("expected %s in restored fs, got %v", key, err)
+		}
+	}
+
+	// 只保留最新的清单，第一次备份中没有被引用的对象也会被删除
+	n, err := pruneBackups(context.Background(), target, 1, 0, start.Add(2*time.Hour))
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 pruned backup, got %d %v", n, err)
+	}
+	manifest, err := readManifest(context.Background(), target, ManifestName(start.Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	referenced := map[string]bool{ManifestName(start.Add(time.Hour)): true}
+	for _, region := range manifest.Regions {
+		for _, part := range region.Parts {
+			referenced[part.Object] = true
+		}
+	}
+	files, _ := os.ReadDir(dir)
+	for _, file := range files {
+		if !referenced[file.Name()] {
+			t.Errorf("expected unreferenced %s to be pruned", file.Name())
+		}
+	}
+	if len(files) != len(referenced) {
+		t.Errorf("expected %d files after prune, got %d", len(referenced), len(files))
+	}
+	err = RestoreIncrementalBackup(context.Background(), target, ManifestName(start.Add(time.Hour)), filepath.Join(t.TempDir(), "pruned"), nil)
+	if err != nil {
+		t.Errorf("failed to restore backup after prune: %v", err)
+	}
+}
+
+func TestEncryptedIncrementalBackup(t *testing.T) {
+	saved := *transformer
+	defer func() { *transformer = saved }()
+	backupKey := []byte("backup-secret-01")
+
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, SegmentSize: minSegmentSize})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("secret-key-%03d", i)
+		seg, err := NewSegmentBytes(key, Binary, bytes.Repeat([]byte("s"), 64*KB), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := lfs.AddSegment(InodeNum(key), *seg, 0); err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+
+	dir := t.TempDir()
+	target := &DirTarget{Path: dir}
+	start := time.Date(2024, 5, 10, 3, 0, 0, 0, time.UTC)
+	if _, err := lfs.IncrementalBackup(context.Background(), target, ManifestName(start)); err != nil {
+		t.Fatalf("failed to backup: %v", err)
+	}
+
+	// 设置备份密钥之后不能复用没有加密的对象
+	if err := lfs.SetBackupKey(backupKey); err != nil {
+		t.Fatalf("failed to set backup key: %v", err)
+	}
+	name := ManifestName(start.Add(time.Hour))
+	report, err := lfs.IncrementalBackup(context.Background(), target, name)
+	if err != nil {
+		t.Fatalf("failed to backup: %v", err)
+	}
+	mustCloseFS(t, lfs)
+	if !report.Encrypted || report.Reused != 0 {
+		t.Fatalf("unexpected encrypted backup report: %+v", report)
+	}
+	manifest, err := readManifest(context.Background(), target, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, region := range manifest.Regions {
+		data, err := os.ReadFile(filepath.Join(dir, region.Parts[0].Object))
+		if err != nil || bytes.Contains(data, []byte("secret-key")) {
+			t.Fatalf("expected %s to be encrypted: %v", region.Parts[0].Object, err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "restored")
+	if err := RestoreIncrementalBackup(context.Background(), target, name, path, nil); err == nil {
+		t.Errorf("expected error when restoring without the backup key")
+	}
+	if err := RestoreIncrementalBackup(context.Background(), target, name, path, []byte("wrong-secret-0123")); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("expected key mismatch error, got %v", err)
+	}
+	if err := RestoreIncrementalBackup(context.Background(), target, name, path, backupKey); err != nil {
+		t.Fatalf("failed to restore backup: %v", err)
+	}
+
+	if err := transformer.SetEncryptor(AESGCMEncryptor, backupKey); err != nil {
+		t.Fatalf("failed to set encryptor: %v", err)
+	}
+	restored, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, SegmentSize: minSegmentSize})
+	if err != nil {
+		t.Fatalf("failed to open restored fs: %v", err)
+	}
+	defer mustCloseFS(t, restored)
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("secret-key-%03d", i)
+		seg, err := restored.FetchSegment(InodeNum(key))
+		if err != nil || len(seg.Value) != 64*KB {
+			t.Fatalf("expected %s in restored fs, got %v", key, err)
+		}
+	}
+}
+
+func TestParsePartName(t *testing.T) {
+	at := time.Date(2024, 5, 10, 3, 0, 0, 0, time.UTC)
+	name := partName(ManifestName(at), formatDataFileName(3))
+	if got, ok := parsePartName(name); !ok || !got.Equal(at) {
+		t.Errorf("expected %s to be a part at %s, got %v %v", name, at, got, ok)
+	}
+	for _, name := range []string{BackupName(at), ManifestName(at), "wiredkv-20240510T030000Z.x.wdb", "other.wdb"} {
+		if _, ok := parsePartName(name); ok {
+			t.Errorf("expected %s not to be a part", name)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/auula/wiredkv/clog"
+)
+
+// BackupStatus 是备份的运行状态，通过 Stats 返回
+type BackupStatus struct {
+	Schedule     string        // 定期备份的 cron 表达式，空表示没有开启定期备份
+	NextRun      time.Time     // 下一次定期备份的时间
+	Running      bool          // 是否有备份正在执行
+	LastStart    time.Time     // 最近一次备份开始的时间
+	LastSuccess  time.Time     // 最近一次成功的备份开始的时间
+	LastName     string        // 最近一次成功的备份文件
+	LastRecords  int           // 最近一次成功的备份中的记录条数
+	LastBytes    int64         // 最近一次成功的备份的字节数
+	LastDuration time.Duration // 最近一次成功的备份花费的时间
+	LastError    string        // 最近一次备份的错误，成功之后清空
+	Succeeded    uint64        // 打开以来成功的备份次数
+	Failed       uint64        // 打开以来失败的备份次数
+	Pruned       uint64        // 打开以来按照保留策略删除的备份个数
+}
+
+type backupState struct {
+	mu     sync.Mutex
+	status BackupStatus
+}
+
+func (s *backupState) start(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Running = true
+	s.status.LastStart = t
+}
+
+func (s *backupState) finish(report *BackupReport, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Running = false
+	if err != nil {
+		s.status.Failed++
+		s.status.LastError = err.Error()
+		return
+	}
+	s.status.Succeeded++
+	s.status.LastError = ""
+	s.status.LastSuccess = s.status.LastStart
+	s.status.LastName = report.Name
+	s.status.LastRecords = report.Records
+	s.status.LastBytes = report.Bytes
+	s.status.LastDuration = report.Duration
+}
+
+func (s *backupState) schedule(spec string, next time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Schedule = spec
+	s.status.NextRun = next
+}
+
+func (s *backupState) pruned(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Pruned += uint64(n)
+}
+
+func (s *backupState) snapshot() BackupStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// BackupScheduleOptions 是定期备份的配置
+type BackupScheduleOptions struct {
+	// Schedule 是 ParseCron 支持的 cron 表达式，例如 0 3 * * * 表示每天凌晨 3 点
+	Schedule string
+	Target   BackupTarget
+	// Keep 最多保留的备份个数，0 表示不按照个数删除
+	Keep int
+	// MaxAge 备份最长保留的时间，0 表示不按照时间删除
+	MaxAge time.Duration
+	// Incremental 使用 IncrementalBackup 只上传之前的清单中没有的数据文件，清单通过 ManifestName 命名
+	Incremental bool
+}
+
+// StartBackupSchedule 按照 cron 表达式在后台定期执行 Backup，备份文件按照开始的时间通过 BackupName 命名
+// Target 实现了 BackupPruner 时，每次备份成功之后按照 Keep 和 MaxAge 删除 BackupName 格式的旧备份和 ManifestName 格式的旧清单，备份失败时不会删除
+// 上一次备份还没有完成时跳过这一次，StopBackupSchedule 和 CloseFS 会取消正在执行的备份
+func (lfs *LogStructuredFS) StartBackupSchedule(opt BackupScheduleOptions) error {
+	if opt.Target == nil {
+		return errors.New("backup target cannot be nil")
+	}
+	if opt.Keep < 0 || opt.MaxAge < 0 {
+		return errors.New("backup retention cannot be negative")
+	}
+	schedule, err := ParseCron(opt.Schedule)
+	if err != nil {
+		return err
+	}
+	if lfs.backupdone != nil {
+		return errors.New("backup schedule is already running")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lfs.backupdone = make(chan struct{})
+	lfs.backupexit = make(chan struct{})
+	lfs.backupcancel = cancel
+	go func() {
+		defer close(lfs.backupexit)
+		defer lfs.backups.schedule("", time.Time{})
+		for {
+			next := schedule.Next(time.Now())
+			if next.IsZero() {
+				clog.Errorf("backup schedule %q never runs again", opt.Schedule)
+				return
+			}
+			lfs.backups.schedule(opt.Schedule, next)
+
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-timer.C:
+				lfs.scheduledBackup(ctx, opt)
+			case <-lfs.backupdone:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// StopBackupSchedule 停止定期备份并且等待正在执行的备份取消
+func (lfs *LogStructuredFS) StopBackupSchedule() {
+	if lfs.backupdone == nil {
+		return
+	}
+	close(lfs.backupdone)
+	lfs.backupcancel()
+	<-lfs.backupexit
+	lfs.backupdone = nil
+}
+
+func (lfs *LogStructuredFS) scheduledBackup(ctx context.Context, opt BackupScheduleOptions) {
+	var (
+		start  = time.Now()
+		report *BackupReport
+		err    error
+	)
+	if opt.Incremental {
+		report, err = lfs.IncrementalBackup(ctx, opt.Target, ManifestName(start))
+	} else {
+		report, err = lfs.Backup(ctx, opt.Target, BackupName(start))
+	}
+	if err != nil {
+		clog.Errorf("failed to run scheduled backup: %v", err)
+		return
+	}
+	clog.Infof("Backup %s completed: %d records (%d bytes) in %s",
+		report.Name, report.Records, report.Bytes, report.Duration.Round(time.Millisecond))
+
+	pruner, ok := opt.Target.(BackupPruner)
+	if !ok || (opt.Keep == 0 && opt.MaxAge == 0) {
+		return
+	}
+	n, err := pruneBackups(ctx, pruner, opt.Keep, opt.MaxAge, start)
+	lfs.backups.pruned(n)
+	if err != nil {
+		clog.Errorf("failed to prune old backups: %v", err)
+	}
+}
+
+// pruneBackups 删除超过 keep 个之外的旧备份和开始时间早于 now - maxAge 的备份，返回删除的备份个数
+// 完整备份和增量备份的清单按照开始的时间一起排序，不是 BackupName 或者 ManifestName 格式的文件不会删除
+// pruner 实现了 BackupSource 时，删除剩下的清单都没有引用并且早于 now 上传的增量备份对象
+func pruneBackups(ctx context.Context, pruner BackupPruner, keep int, maxAge time.Duration, now time.Time) (int, error) {
+	names, err := pruner.List(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	type backup struct {
+		name string
+		at   time.Time
+	}
+	var backups []backup
+	for _, name := range names {
+		if at, ok := parseBackupName(name); ok {
+			backups = append(backups, backup{name: name, at: at})
+		} else if at, ok := parseManifestName(name); ok {
+			backups = append(backups, backup{name: name, at: at})
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].at.After(backups[j].at)
+	})
+
+	deleted := 0
+	var manifests []string
+	for i, b := range backups {
+		if (keep == 0 || i < keep) && (maxAge == 0 || !b.at.Before(now.Add(-maxAge))) {
+			if strings.HasSuffix(b.name, manifestExtension) {
+				manifests = append(manifests, b.name)
+			}
+			continue
+		}
+		if err := pruner.Delete(ctx, b.name); err != nil {
+			return deleted, fmt.Errorf("failed to delete backup %s: %w", b.name, err)
+		}
+		deleted++
+	}
+
+	if source, ok := pruner.(BackupSource); ok {
+		if err := pruneParts(ctx, pruner, source, names, manifests, now); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// pruneParts 删除 manifests 都没有引用的增量备份对象
+// 和 now 同一秒或者更晚上传的对象可能属于还没有写入清单的增量备份，不会删除
+func pruneParts(ctx context.Context, pruner BackupPruner, source BackupSource, names, manifests []string, now time.Time) error {
+	referenced := make(map[string]bool)
+	for _, name := range manifests {
+		manifest, err := readManifest(ctx, source, name)
+		if err != nil {
+			return err
+		}
+		for _, region := range manifest.Regions {
+			for _, part := range region.Parts {
+				referenced[part.Object] = true
+			}
+		}
+	}
+	for _, name := range names {
+		at, ok := parsePartName(name)
+		if !ok || referenced[name] || !at.Before(now.Truncate(time.Second)) {
+			continue
+		}
+		if err := pruner.Delete(ctx, name); err != nil {
+			return fmt.Errorf("failed to delete backup object %s: %w", name, err)
+		}
+	}
+	return nil
+}
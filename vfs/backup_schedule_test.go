@@ -0,0 +1,106 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	target := &DirTarget{Path: dir}
+	now := time.Date(2024, 5, 10, 3, 0, 0, 0, time.UTC)
+	for _, days := range []int{0, 1, 2, 3, 10} {
+		name := BackupName(now.AddDate(0, 0, -days))
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 不是 BackupName 格式的文件不会删除
+	for _, name := range []string{"manual.wdb", "wiredkv-20000101T000000Z.wdb.tmp"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list := func() []string {
+		names, err := target.List(context.Background())
+		if err != nil {
+			t.Fatalf("failed to list backups: %v", err)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	// 按照时间删除 10 天前的备份
+	n, err := pruneBackups(context.Background(), target, 0, 7*24*time.Hour, now)
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 pruned backup, got %d %v", n, err)
+	}
+	// 按照个数保留最新的 2 个备份
+	n, err = pruneBackups(context.Background(), target, 2, 0, now)
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 pruned backups, got %d %v", n, err)
+	}
+	want := []string{"manual.wdb", BackupName(now.AddDate(0, 0, -1)), BackupName(now)}
+	sort.Strings(want)
+	if got := list(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestBackupSchedule(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+	err = lfs.AddSegment(InodeNum("key-01"), *testSegment("key-01", "value"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+
+	// 已经有备份在执行时返回 ErrBackupRunning
+	lfs.backupMu.Lock()
+	_, err = lfs.Backup(context.Background(), &DirTarget{Path: t.TempDir()}, "busy.wdb")
+	lfs.backupMu.Unlock()
+	if !errors.Is(err, ErrBackupRunning) {
+		t.Fatalf("expected ErrBackupRunning, got %v", err)
+	}
+
+	dir := t.TempDir()
+	opt := BackupScheduleOptions{Schedule: "@every 20ms", Target: &DirTarget{Path: dir}, Keep: 1}
+	if err := lfs.StartBackupSchedule(opt); err != nil {
+		t.Fatalf("failed to start backup schedule: %v", err)
+	}
+	if err := lfs.StartBackupSchedule(opt); err == nil {
+		t.Errorf("expected error when the backup schedule is already running")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for lfs.Stats().Backup.Pruned == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	lfs.StopBackupSchedule()
+
+	status := lfs.Stats().Backup
+	if status.Succeeded < 2 || status.Pruned == 0 || status.LastRecords != 1 || status.LastError != "" {
+		t.Fatalf("unexpected backup status: %+v", status)
+	}
+	if status.Schedule != "" || !status.NextRun.IsZero() {
+		t.Errorf("expected schedule to be cleared after stop: %+v", status)
+	}
+	files, _ := os.ReadDir(dir)
+	if len(files) != 1 || files[0].Name() != status.LastName {
+		t.Errorf("expected only %s to be kept, got %v", status.LastName, files)
+	}
+
+	if err := lfs.StartBackupSchedule(BackupScheduleOptions{Schedule: "0 3 * *", Target: opt.Target}); err == nil {
+		t.Errorf("expected error for invalid cron spec")
+	}
+}
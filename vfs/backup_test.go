@@ -0,0 +1,83 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupRestore(t *testing.T) {
+	src, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open source fs: %v", err)
+	}
+	defer mustCloseFS(t, src)
+
+	for _, key := range []string{"key-01", "key-02"} {
+		err := src.AddSegment(InodeNum(key), *testSegment(key, "value"), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+	err = src.Batch(func(b *Batch) error {
+		b.AddSegment(InodeNum("key-03"), *testSegment("key-03", "batched"))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to commit batch: %v", err)
+	}
+	err = src.DelSegment("key-02")
+	if err != nil {
+		t.Fatalf("failed to delete segment: %v", err)
+	}
+
+	dir := t.TempDir()
+	report, err := src.Backup(context.Background(), &DirTarget{Path: dir}, "backup.wdb")
+	if err != nil {
+		t.Fatalf("failed to backup: %v", err)
+	}
+	// 水位线是备份开始时的序列号，删除记录也会占用一个序列号
+	if report.Records != 2 || report.Watermark != src.LastSequence() {
+		t.Fatalf("unexpected backup report: %+v", report)
+	}
+	info, err := os.Stat(filepath.Join(dir, "backup.wdb"))
+	if err != nil || info.Size() != report.Bytes {
+		t.Fatalf("expected backup file of %d bytes, got %v %v", report.Bytes, info, err)
+	}
+
+	fd, err := os.Open(filepath.Join(dir, "backup.wdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	path := filepath.Join(t.TempDir(), "restored")
+	err = RestoreBackup(fd, path)
+	if err != nil {
+		t.Fatalf("failed to restore backup: %v", err)
+	}
+
+	dst, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open restored fs: %v", err)
+	}
+	defer mustCloseFS(t, dst)
+	for key, value := range map[string]string{"key-01": "value", "key-03": "batched"} {
+		seg, err := dst.FetchSegment(InodeNum(key))
+		if err != nil || string(seg.Value) != value {
+			t.Errorf("expected %s=%s in restored fs, got %v %v", key, value, seg, err)
+		}
+	}
+	if _, err := dst.FetchSegment(InodeNum("key-02")); !errors.Is(err, ErrSegmentNotFound) {
+		t.Errorf("expected key-02 not in backup, got: %v", err)
+	}
+
+	// 已经有数据文件的目录不能恢复
+	if _, err := fd.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := RestoreBackup(fd, path); err == nil {
+		t.Errorf("expected error when restoring into a non-empty data directory")
+	}
+}
@@ -0,0 +1,102 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrEmptyBatch = errors.New("batch has no segments to commit")
+
+// Batch 收集需要原子提交的多条写入和删除操作
+// 编码方式为：| SEG FLAG=BATCH | ... | SEG FLAG=BATCH | COMMIT FLAG=BATCH_COMMIT |
+// 所有记录通过一次写入追加到同一个数据文件中，崩溃恢复时没有提交记录的批量写入会被丢弃并且从数据文件中截断
+type Batch struct {
+	inums []uint64
+	segs  []*Segment
+}
+
+type batchRecord struct {
+	inum  uint64
+	seg   *Segment
+	inode *INode
+}
+
+// AddSegment 向批量写入中添加一条 Segment 记录
+func (b *Batch) AddSegment(inum uint64, seg Segment) {
+	seg.Flags |= flagBatch
+	seg.Version = 0
+	b.inums = append(b.inums, inum)
+	b.segs = append(b.segs, &seg)
+}
+
+// DelSegment 向批量写入中添加一条删除记录
+func (b *Batch) DelSegment(key string) {
+	b.AddSegment(InodeNum(key), *NewTombstoneSegment([]byte(key)))
+}
+
+// Len 返回批量写入中的操作个数
+func (b *Batch) Len() int {
+	return len(b.segs)
+}
+
+// Batch 执行 fn 收集批量写入操作，如果 fn 返回错误就放弃所有操作
+// 否则所有的写入和删除操作会被原子的提交，崩溃之后不会出现只生效一部分的情况
+func (lfs *LogStructuredFS) Batch(fn func(b *Batch) error) error {
+	b := new(Batch)
+	err := fn(b)
+	if err != nil {
+		return err
+	}
+
+	return lfs.commitBatch(b, nil)
+}
+
+// commitBatch 在持有所有相关索引分片锁的情况下提交批量写入，reads 不为空时先检查
+// 读取过的 Key 有没有被修改，被修改过就放弃提交并且返回 ErrTxnConflict
+func (lfs *LogStructuredFS) commitBatch(b *Batch, reads map[uint64]txnRead) error {
+	if b.Len() == 0 {
+		return ErrEmptyBatch
+	}
+
+	for i, seg := range b.segs {
+		b.inums[i] = lfs.transformSegment(b.inums[i], seg)
+	}
+
+	if lfs.maxIndexMem > 0 {
+		var added int64
+		for i, inum := range b.inums {
+			if _, ok := lfs.GetINode(inum); !ok && !b.segs[i].IsTombstone() {
+				added += inodeMemory(len(b.segs[i].Key))
+			}
+		}
+		if added > 0 && lfs.indexMemory()+added > lfs.maxIndexMem {
+			return ErrIndexMemoryExceeded
+		}
+	}
+
+	commit := &Segment{Type: Unknown, Flags: flagBatchCommit}
+	segs := append(b.segs, commit)
+
+	size := 0
+	for _, seg := range segs {
+		if err := checkSegmentSize(seg); err != nil {
+			return err
+		}
+		size += int(seg.Size())
+	}
+
+	if err := lfs.checkDiskQuota(b.segs...); err != nil {
+		return err
+	}
+	lfs.limiter.wait(size)
+
+	lfs.appendMu.RLock()
+	err := lfs.commitTxnLocked(b, segs, reads)
+	lfs.appendMu.RUnlock()
+	if err != nil {
+		lfs.limiter.refund(size)
+		return err
+	}
+
+	return lfs.auditSegments(context.Background(), b.segs...)
+}
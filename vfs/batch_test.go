@@ -0,0 +1,302 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBatchCommitAndRecovery(t *testing.T) {
+	path := t.TempDir()
+	lfs, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+
+	err = lfs.AddSegment(InodeNum("key-03"), *testSegment("key-03", "value-03"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+
+	err = lfs.Batch(func(b *Batch) error {
+		b.AddSegment(InodeNum("key-01"), *testSegment("key-01", "value-01"))
+		b.AddSegment(InodeNum("key-02"), *testSegment("key-02", "value-02"))
+		b.DelSegment("key-03")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to commit batch: %v", err)
+	}
+
+	// 回调函数返回错误时不会写入任何数据
+	abort := errors.New("abort")
+	err = lfs.Batch(func(b *Batch) error {
+		b.AddSegment(InodeNum("key-04"), *testSegment("key-04", "value-04"))
+		return abort
+	})
+	if !errors.Is(err, abort) {
+		t.Fatalf("expected abort error, got %v", err)
+	}
+
+	// 模拟崩溃时只写入了一半的批量记录
+	torn := testSegment("key-05", "value-05")
+	torn.Flags = flagBatch
+	err = appendBinaryToFile(lfs.active, lfs.checksum, torn)
+	if err != nil {
+		t.Fatalf("failed to write torn batch: %v", err)
+	}
+
+	mustCloseFS(t, lfs)
+	os.Remove(filepath.Join(path, indexFileName))
+
+	lfs, err = OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to reopen fs: %v", err)
+	}
+	defer lfs.CloseFS()
+
+	for _, key := range []string{"key-01", "key-02"} {
+		if _, err := lfs.FetchSegment(InodeNum(key)); err != nil {
+			t.Errorf("expected %s to be committed, got: %v", key, err)
+		}
+	}
+
+	for _, key := range []string{"key-03", "key-04", "key-05"} {
+		if _, err := lfs.FetchSegment(InodeNum(key)); !errors.Is(err, ErrSegmentNotFound) {
+			t.Errorf("expected %s not found, got: %v", key, err)
+		}
+	}
+}
+
+func TestAddAndFetchSegments(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer lfs.CloseFS()
+
+	segs := make(map[uint64]Segment)
+	for _, key := range []string{"key-01", "key-02", "key-03"} {
+		segs[InodeNum(key)] = *testSegment(key, "value-"+key)
+	}
+
+	err = lfs.AddSegments(segs)
+	if err != nil {
+		t.Fatalf("failed to add segments: %v", err)
+	}
+
+	result, err := lfs.FetchSegments(InodeNum("key-01"), InodeNum("key-03"), InodeNum("missing"))
+	if err != nil {
+		t.Fatalf("failed to fetch segments: %v", err)
+	}
+
+	if len(result) != 2 {
+		t.Fatalf("expected 2 segments, got %d", len(result))
+	}
+
+	for _, key := range []string{"key-01", "key-03"} {
+		seg, ok := result[InodeNum(key)]
+		if !ok || string(seg.Value) != "value-"+key {
+			t.Errorf("unexpected segment for %s: %v", key, seg)
+		}
+	}
+}
+
+func TestFetchSegmentsDuringCompaction(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	var inums []uint64
+	for i := 0; i < 64; i++ {
+		key := fmt.Sprintf("key-%02d", i)
+		inums = append(inums, InodeNum(key))
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value-"+key), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+
+	done := make(chan struct{})
+	errs := make(chan error, 4)
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				result, err := lfs.FetchSegments(inums...)
+				if err != nil {
+					errs <- err
+					return
+				}
+				for i, inum := range inums {
+					key := fmt.Sprintf("key-%02d", i)
+					seg, ok := result[inum]
+					if !ok || string(seg.Value) != "value-"+key {
+						errs <- fmt.Errorf("unexpected segment for %s: %v", key, seg)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	// 每一轮覆盖一半的 Key，压缩把另一半有效记录迁移到新的数据文件并且删除旧的数据文件
+	for round := 0; round < 50; round++ {
+		for i := round % 2; i < len(inums); i += 2 {
+			key := fmt.Sprintf("key-%02d", i)
+			err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value-"+key), 0)
+			if err != nil {
+				t.Fatalf("failed to update segment: %v", err)
+			}
+		}
+		err := lfs.ChangeRegions()
+		if err != nil {
+			t.Fatalf("failed to change regions: %v", err)
+		}
+		err = lfs.CompactContext(context.Background())
+		if err != nil {
+			t.Fatalf("failed to compact: %v", err)
+		}
+	}
+	close(done)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("failed to fetch segments during compaction: %v", err)
+	}
+}
+
+func TestBatchIndexMemoryLimit(t *testing.T) {
+	key := strings.Repeat("k", 512)
+	lfs, err := OpenFS(&Options{
+		Path:           t.TempDir(),
+		FsPerm:         fsPerm,
+		Threshold:      1,
+		MaxIndexMemory: inodeMemory(len(key)) + 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	// 内存限制按照每个新增 Key 的长度估算，只能放下一个长 Key
+	err = lfs.Batch(func(b *Batch) error {
+		b.AddSegment(InodeNum(key+"1"), *testSegment(key+"1", "value"))
+		b.AddSegment(InodeNum(key+"2"), *testSegment(key+"2", "value"))
+		return nil
+	})
+	if !errors.Is(err, ErrIndexMemoryExceeded) {
+		t.Errorf("expected ErrIndexMemoryExceeded, got: %v", err)
+	}
+}
+
+func TestBatchTornTail(t *testing.T) {
+	for _, snapshot := range []bool{true, false} {
+		t.Run(fmt.Sprintf("snapshot=%t", snapshot), func(t *testing.T) {
+			path := t.TempDir()
+			lfs, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+			if err != nil {
+				t.Fatalf("failed to open fs: %v", err)
+			}
+
+			err = lfs.AddSegment(InodeNum("key-01"), *testSegment("key-01", "value-01"), 0)
+			if err != nil {
+				t.Fatalf("failed to add segment: %v", err)
+			}
+			err = lfs.ExportSnapshotIndex()
+			if err != nil {
+				t.Fatalf("failed to export snapshot: %v", err)
+			}
+			index, err := os.ReadFile(filepath.Join(path, indexFileName))
+			if err != nil {
+				t.Fatalf("failed to read snapshot: %v", err)
+			}
+
+			err = lfs.Batch(func(b *Batch) error {
+				b.AddSegment(InodeNum("key-02"), *testSegment("key-02", "value-02"))
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("failed to commit batch: %v", err)
+			}
+			committed := lfs.offset
+
+			// 模拟崩溃时批量写入的第一条记录写完了，第二条记录只写入了一半
+			first := testSegment("key-03", "value-03")
+			first.Flags = flagBatch
+			second := testSegment("key-04", "value-04")
+			second.Flags = flagBatch
+			data, err := appendEncodedSegment(nil, second, currentFormat, lfs.checksum)
+			if err != nil {
+				t.Fatalf("failed to encode segment: %v", err)
+			}
+			if err := appendBinaryToFile(lfs.active, lfs.checksum, first); err != nil {
+				t.Fatalf("failed to write torn batch: %v", err)
+			}
+			if _, err := lfs.active.Write(data[:len(data)/2]); err != nil {
+				t.Fatalf("failed to write torn batch: %v", err)
+			}
+			region := lfs.active.Name()
+			mustCloseFS(t, lfs)
+
+			// 崩溃之前导出的快照只覆盖到批量写入之前，否则没有快照需要全局扫描
+			os.Remove(filepath.Join(path, indexFileName))
+			if snapshot {
+				if err := os.WriteFile(filepath.Join(path, indexFileName), index, fsPerm); err != nil {
+					t.Fatalf("failed to restore snapshot: %v", err)
+				}
+			}
+
+			lfs, err = OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+			if err != nil {
+				t.Fatalf("failed to reopen fs with torn batch: %v", err)
+			}
+			if finfo, err := os.Stat(region); err != nil || finfo.Size() != committed {
+				t.Fatalf("expected region truncated to %d, got %v (%v)", committed, finfo.Size(), err)
+			}
+
+			// 截断之后再提交的批量写入不会把没有提交的记录一起提交
+			err = lfs.Batch(func(b *Batch) error {
+				b.AddSegment(InodeNum("key-05"), *testSegment("key-05", "value-05"))
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("failed to commit batch: %v", err)
+			}
+			mustCloseFS(t, lfs)
+			os.Remove(filepath.Join(path, indexFileName))
+
+			lfs, err = OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+			if err != nil {
+				t.Fatalf("failed to reopen fs: %v", err)
+			}
+			defer mustCloseFS(t, lfs)
+
+			for _, key := range []string{"key-01", "key-02", "key-05"} {
+				if _, err := lfs.FetchSegment(InodeNum(key)); err != nil {
+					t.Errorf("expected %s to be committed, got: %v", key, err)
+				}
+			}
+			for _, key := range []string{"key-03", "key-04"} {
+				if _, err := lfs.FetchSegment(InodeNum(key)); !errors.Is(err, ErrSegmentNotFound) {
+					t.Errorf("expected %s not found, got: %v", key, err)
+				}
+			}
+		})
+	}
+}
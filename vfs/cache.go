@@ -0,0 +1,230 @@
+package vfs
+
+import (
+	"container/heap"
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// EvictionPolicy 数据记录缓存的淘汰策略
+type EvictionPolicy int8
+
+const (
+	EvictionLRU    EvictionPolicy = iota // 淘汰最近最少使用的记录
+	EvictionLFU                          // 淘汰使用频率最低的记录
+	EvictionRandom                       // 随机淘汰记录
+)
+
+// 每条内存索引除了 Key 之外大约占用的字节数
+// INode 结构体 56 字节 + map 的 key 和指针 16 字节 + map 桶的额外开销
+const inodeMemorySize = int64(80)
+
+// inodeMemory 估算一条 Key 长度为 klen 的内存索引占用的字节数
+func inodeMemory(klen int) int64 {
+	return inodeMemorySize + int64(klen)
+}
+
+type cacheEntry struct {
+	inum  uint64
+	seg   *Segment
+	size  int64
+	hits  uint64
+	index int           // 在 LFU 堆中的位置
+	elem  *list.Element // 在 LRU 链表中的位置
+}
+
+// segmentCache 是按字节数限制大小的 Segment 缓存
+type segmentCache struct {
+	mu       sync.Mutex
+	policy   EvictionPolicy
+	capacity atomic.Int64 // 运行时可以通过 resize 修改
+	used     int64
+	entries  map[uint64]*cacheEntry
+	lru      *list.List
+	lfu      lfuHeap
+}
+
+func newSegmentCache(capacity int64, policy EvictionPolicy) *segmentCache {
+	c := &segmentCache{
+		policy:  policy,
+		entries: make(map[uint64]*cacheEntry),
+		lru:     list.New(),
+	}
+	c.capacity.Store(capacity)
+	return c
+}
+
+func (c *segmentCache) enabled() bool {
+	return c != nil && c.capacity.Load() > 0
+}
+
+func (c *segmentCache) get(inum uint64) (*Segment, bool) {
+	if !c.enabled() {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[inum]
+	if !ok {
+		return nil, false
+	}
+
+	c.touch(entry)
+
+	return entry.seg, true
+}
+
+func (c *segmentCache) add(inum uint64, seg *Segment) {
+	if !c.enabled() {
+		return
+	}
+
+	size := int64(seg.Size())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// 单条记录比整个缓存都大就没有缓存的意义了，持有锁之后再检查，resize 关闭缓存之后不会再放入记录
+	capacity := c.capacity.Load()
+	if size > capacity {
+		return
+	}
+
+	// 更新已有的记录保留它的命中次数
+	var hits uint64
+	if entry, ok := c.entries[inum]; ok {
+		hits = entry.hits
+		c.delete(entry)
+	}
+
+	// 先腾出足够的空间再放入新的记录，防止新记录被立即淘汰
+	for c.used+size > capacity && len(c.entries) > 0 {
+		c.evict()
+	}
+
+	entry := &cacheEntry{inum: inum, seg: seg, size: size, hits: hits}
+	c.entries[inum] = entry
+	c.used += size
+	switch c.policy {
+	case EvictionLRU:
+		entry.elem = c.lru.PushFront(entry)
+	case EvictionLFU:
+		heap.Push(&c.lfu, entry)
+	}
+}
+
+func (c *segmentCache) remove(inum uint64) {
+	if !c.enabled() {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[inum]; ok {
+		c.delete(entry)
+	}
+}
+
+// resize 修改缓存的最大字节数并且淘汰超出的记录，0 表示关闭缓存并清空所有的记录
+// 关闭之后的写入不会再清理缓存，留下的记录在重新开启之后可能已经过期
+func (c *segmentCache) resize(capacity int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.capacity.Store(capacity)
+	for c.used > capacity && len(c.entries) > 0 {
+		c.evict()
+	}
+}
+
+// clear 清空缓存中所有的记录，缓存的最大字节数保持不变
+func (c *segmentCache) clear() {
+	if !c.enabled() {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.entries) > 0 {
+		c.evict()
+	}
+}
+
+// usage 返回缓存中记录的字节数和数量
+func (c *segmentCache) usage() (int64, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.used, len(c.entries)
+}
+
+// touch 记录一次命中，调用方需要持有锁
+func (c *segmentCache) touch(entry *cacheEntry) {
+	entry.hits++
+	switch c.policy {
+	case EvictionLRU:
+		c.lru.MoveToFront(entry.elem)
+	case EvictionLFU:
+		heap.Fix(&c.lfu, entry.index)
+	}
+}
+
+// evict 根据淘汰策略淘汰一条记录，调用方需要持有锁
+func (c *segmentCache) evict() {
+	var victim *cacheEntry
+	switch c.policy {
+	case EvictionLRU:
+		victim = c.lru.Back().Value.(*cacheEntry)
+	case EvictionLFU:
+		victim = c.lfu[0]
+	default:
+		// map 的遍历顺序本身就是随机的
+		for _, entry := range c.entries {
+			victim = entry
+			break
+		}
+	}
+	c.delete(victim)
+}
+
+func (c *segmentCache) delete(entry *cacheEntry) {
+	switch c.policy {
+	case EvictionLRU:
+		c.lru.Remove(entry.elem)
+	case EvictionLFU:
+		heap.Remove(&c.lfu, entry.index)
+	}
+	delete(c.entries, entry.inum)
+	c.used -= entry.size
+}
+
+// lfuHeap 是按命中次数排序的小顶堆
+type lfuHeap []*cacheEntry
+
+func (h lfuHeap) Len() int           { return len(h) }
+func (h lfuHeap) Less(i, j int) bool { return h[i].hits < h[j].hits }
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap) Push(x interface{}) {
+	entry := x.(*cacheEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return entry
+}
@@ -0,0 +1,183 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func testSegment(key, value string) *Segment {
+	return &Segment{
+		Type:      Binary,
+		KeySize:   uint32(len(key)),
+		ValueSize: uint32(len(value)),
+		Key:       []byte(key),
+		Value:     []byte(value),
+	}
+}
+
+func TestSegmentCacheEviction(t *testing.T) {
+	seg := testSegment("key", "value")
+	// 每个缓存只能放下两条记录
+	capacity := int64(seg.Size()) * 2
+
+	t.Run("LRU", func(t *testing.T) {
+		c := newSegmentCache(capacity, EvictionLRU)
+		c.add(1, seg)
+		c.add(2, seg)
+		c.get(1)
+		c.add(3, seg)
+
+		if _, ok := c.get(2); ok {
+			t.Errorf("expected inum 2 to be evicted")
+		}
+		if _, ok := c.get(1); !ok {
+			t.Errorf("expected inum 1 to be cached")
+		}
+	})
+
+	t.Run("LFU", func(t *testing.T) {
+		c := newSegmentCache(capacity, EvictionLFU)
+		c.add(1, seg)
+		c.add(2, seg)
+		c.get(2)
+		c.get(2)
+		c.get(1)
+		c.add(3, seg)
+
+		if _, ok := c.get(1); ok {
+			t.Errorf("expected inum 1 to be evicted")
+		}
+		if _, ok := c.get(2); !ok {
+			t.Errorf("expected inum 2 to be cached")
+		}
+	})
+
+	t.Run("Random", func(t *testing.T) {
+		c := newSegmentCache(capacity, EvictionRandom)
+		for i := uint64(0); i < 10; i++ {
+			c.add(i, seg)
+		}
+		if len(c.entries) != 2 || c.used > capacity {
+			t.Errorf("expected 2 entries within capacity, got %d entries using %d bytes", len(c.entries), c.used)
+		}
+	})
+}
+
+func TestFetchSegmentWithLimits(t *testing.T) {
+	seg := testSegment("key-01", "value-01")
+	lfs, err := OpenFS(&Options{
+		Path:           t.TempDir(),
+		FsPerm:         fsPerm,
+		Threshold:      1,
+		MaxIndexMemory: inodeMemory(len(seg.Key)),
+		MaxCacheMemory: int64(seg.Size()),
+		Eviction:       EvictionLRU,
+	})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer lfs.CloseFS()
+
+	inum := InodeNum(string(seg.Key))
+	err = lfs.AddSegment(inum, *seg, 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+
+	// 更新已经存在的 key 不会受到索引内存限制
+	err = lfs.AddSegment(inum, *seg, 0)
+	if err != nil {
+		t.Fatalf("failed to update segment: %v", err)
+	}
+
+	err = lfs.AddSegment(InodeNum("key-02"), *testSegment("key-02", "value-02"), 0)
+	if !errors.Is(err, ErrIndexMemoryExceeded) {
+		t.Errorf("expected ErrIndexMemoryExceeded, got: %v", err)
+	}
+
+	fetched, err := lfs.FetchSegment(inum)
+	if err != nil {
+		t.Fatalf("failed to fetch segment: %v", err)
+	}
+	if string(fetched.Value) != "value-01" {
+		t.Errorf("expected value-01, got %s", fetched.Value)
+	}
+
+	if _, ok := lfs.cache.get(inum); !ok {
+		t.Errorf("expected segment to be cached after fetch")
+	}
+}
+
+// interleavedSpan 在 fetchSegment 读取磁盘之后、放入缓存之前执行 miss，模拟并发的写入
+type interleavedSpan struct {
+	recordedSpan
+	miss func()
+}
+
+func (s *interleavedSpan) SetAttributes(attrs ...Attribute) {
+	for _, attr := range attrs {
+		if attr.Key == "vfs.cache_hit" && attr.Value == false && s.miss != nil {
+			s.miss()
+		}
+	}
+}
+
+type interleavedTracer struct {
+	miss func()
+}
+
+func (t *interleavedTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	if name != spanGet {
+		return ctx, &recordedSpan{name: name, attrs: make(map[string]interface{})}
+	}
+	return ctx, &interleavedSpan{miss: t.miss}
+}
+
+func TestFetchSegmentConcurrentPut(t *testing.T) {
+	tracer := &interleavedTracer{}
+	lfs, err := OpenFS(&Options{
+		Path:           t.TempDir(),
+		FsPerm:         fsPerm,
+		Threshold:      1,
+		MaxCacheMemory: 1 << 20,
+		Eviction:       EvictionLRU,
+		Tracer:         tracer,
+	})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer lfs.CloseFS()
+
+	inum := InodeNum("key")
+	err = lfs.AddSegment(inum, *testSegment("key", "value-01"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+
+	// 读取磁盘之后、放入缓存之前写入新的 Value，旧的记录不能留在缓存中
+	var once sync.Once
+	tracer.miss = func() {
+		once.Do(func() {
+			if err := lfs.AddSegment(inum, *testSegment("key", "value-02"), 0); err != nil {
+				t.Errorf("failed to add segment: %v", err)
+			}
+		})
+	}
+	seg, err := lfs.FetchSegment(inum)
+	if err != nil {
+		t.Fatalf("failed to fetch segment: %v", err)
+	}
+	if string(seg.Value) != "value-01" {
+		t.Fatalf("expected value-01 read before the put, got %s", seg.Value)
+	}
+
+	seg, err = lfs.FetchSegment(inum)
+	if err != nil {
+		t.Fatalf("failed to fetch segment: %v", err)
+	}
+	if string(seg.Value) != "value-02" {
+		t.Errorf("expected value-02 after concurrent put, got %s", seg.Value)
+	}
+}
@@ -0,0 +1,42 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrVersionMismatch = errors.New("segment version mismatch")
+	ErrKeyExists       = errors.New("key already exists")
+)
+
+// PutIfVersion 只有 Key 当前的版本号等于 expected 时才写入，返回新的版本号
+// Key 不存在或者已经过期时同样返回 ErrVersionMismatch，不存在的 Key 使用 PutIfAbsent 写入
+func (lfs *LogStructuredFS) PutIfVersion(inum uint64, seg Segment, ttl uint64, expected uint64) (uint64, error) {
+	return lfs.PutIfVersionContext(context.Background(), inum, seg, ttl, expected)
+}
+
+// PutIfVersionContext 和 PutIfVersion 一样，但是在写入限速等待时会响应 ctx 的取消和超时
+func (lfs *LogStructuredFS) PutIfVersionContext(ctx context.Context, inum uint64, seg Segment, ttl uint64, expected uint64) (uint64, error) {
+	return lfs.putSegment(ctx, inum, seg, func(inode *INode) error {
+		if inode == nil || inode.Version != expected {
+			return ErrVersionMismatch
+		}
+		return nil
+	})
+}
+
+// PutIfAbsent 只有 Key 不存在或者已经过期时才写入，返回新的版本号
+func (lfs *LogStructuredFS) PutIfAbsent(inum uint64, seg Segment, ttl uint64) (uint64, error) {
+	return lfs.PutIfAbsentContext(context.Background(), inum, seg, ttl)
+}
+
+// PutIfAbsentContext 和 PutIfAbsent 一样，但是在写入限速等待时会响应 ctx 的取消和超时
+func (lfs *LogStructuredFS) PutIfAbsentContext(ctx context.Context, inum uint64, seg Segment, ttl uint64) (uint64, error) {
+	return lfs.putSegment(ctx, inum, seg, func(inode *INode) error {
+		if inode != nil {
+			return ErrKeyExists
+		}
+		return nil
+	})
+}
@@ -0,0 +1,93 @@
+package vfs
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestPutIfAbsent(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	inum := InodeNum("key-01")
+	version, err := lfs.PutIfAbsent(inum, *testSegment("key-01", "first"), 0)
+	if err != nil {
+		t.Fatalf("failed to put absent key: %v", err)
+	}
+
+	_, err = lfs.PutIfAbsent(inum, *testSegment("key-01", "second"), 0)
+	if err != ErrKeyExists {
+		t.Fatalf("expected ErrKeyExists, got: %v", err)
+	}
+
+	seg, err := lfs.FetchSegment(inum)
+	if err != nil {
+		t.Fatalf("failed to fetch segment: %v", err)
+	}
+	if string(seg.Value) != "first" || seg.Version != version {
+		t.Errorf("expected first at version %d, got %s at version %d", version, seg.Value, seg.Version)
+	}
+
+	// 删除之后 Key 又可以重新写入
+	err = lfs.DelSegment("key-01")
+	if err != nil {
+		t.Fatalf("failed to delete segment: %v", err)
+	}
+	_, err = lfs.PutIfAbsent(inum, *testSegment("key-01", "third"), 0)
+	if err != nil {
+		t.Errorf("failed to put deleted key: %v", err)
+	}
+}
+
+func TestPutIfVersion(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	inum := InodeNum("counter")
+	_, err = lfs.PutIfVersion(inum, *testSegment("counter", "0"), 0, 1)
+	if err != ErrVersionMismatch {
+		t.Fatalf("expected ErrVersionMismatch for missing key, got: %v", err)
+	}
+
+	version, err := lfs.PutIfAbsent(inum, *testSegment("counter", "0"), 0)
+	if err != nil {
+		t.Fatalf("failed to put absent key: %v", err)
+	}
+
+	// 多个 goroutine 使用相同的版本号竞争写入，只有一个能成功
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		success int
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := lfs.PutIfVersion(inum, *testSegment("counter", "1"), 0, version)
+			if err == nil {
+				mu.Lock()
+				success++
+				mu.Unlock()
+			} else if err != ErrVersionMismatch {
+				t.Errorf("expected ErrVersionMismatch, got: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if success != 1 {
+		t.Errorf("expected exactly one successful write, got %d", success)
+	}
+
+	current, ok := lfs.Version(inum)
+	if !ok || current == version {
+		t.Errorf("expected version to change from %d, got %d", version, current)
+	}
+}
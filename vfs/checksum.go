@@ -0,0 +1,99 @@
+package vfs
+
+import (
+	"fmt"
+	"hash"
+	"hash/crc32"
+)
+
+// Checksum 是数据文件中记录校验码使用的算法，保存在数据文件头的第二个字节
+// 同一个数据文件中的记录使用同一种算法，不同的数据文件可以使用不同的算法
+type Checksum uint8
+
+const (
+	// ChecksumCRC32 使用 IEEE 多项式的 CRC32，旧的数据文件头中这个字节都是 0
+	ChecksumCRC32 Checksum = iota
+	// ChecksumCRC32C 使用 Castagnoli 多项式的 CRC32，支持 SSE4.2 和 ARMv8 CRC 指令的平台上有硬件加速
+	ChecksumCRC32C
+	// ChecksumXXHash64 使用 xxHash64，记录中只保存低 32 位
+	ChecksumXXHash64
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+func (c Checksum) String() string {
+	switch c {
+	case ChecksumCRC32:
+		return "crc32"
+	case ChecksumCRC32C:
+		return "crc32c"
+	case ChecksumXXHash64:
+		return "xxhash64"
+	default:
+		return fmt.Sprintf("checksum(%d)", uint8(c))
+	}
+}
+
+// ParseChecksum 按照名称返回校验码算法，空字符串表示默认的 CRC32
+func ParseChecksum(name string) (Checksum, error) {
+	switch name {
+	case "", "crc32":
+		return ChecksumCRC32, nil
+	case "crc32c":
+		return ChecksumCRC32C, nil
+	case "xxhash64":
+		return ChecksumXXHash64, nil
+	default:
+		return 0, fmt.Errorf("unsupported checksum algorithm: %s", name)
+	}
+}
+
+// validate 检查是否是支持的校验码算法
+func (c Checksum) validate() error {
+	if c > ChecksumXXHash64 {
+		return fmt.Errorf("unsupported checksum algorithm: %d", uint8(c))
+	}
+	return nil
+}
+
+// new 创建校验码算法对应的 hash.Hash32
+func (c Checksum) new() hash.Hash32 {
+	switch c {
+	case ChecksumCRC32C:
+		return crc32.New(castagnoliTable)
+	case ChecksumXXHash64:
+		return &xxhash32{xxh64: newXXHash64()}
+	default:
+		return crc32.NewIEEE()
+	}
+}
+
+// sum 计算 data 的校验码
+func (c Checksum) sum(data []byte) uint32 {
+	switch c {
+	case ChecksumCRC32C:
+		return crc32.Checksum(data, castagnoliTable)
+	case ChecksumXXHash64:
+		return uint32(xxhash64(data))
+	default:
+		return crc32.ChecksumIEEE(data)
+	}
+}
+
+// xxhash32 把 xxHash64 截断为 32 位，记录中的校验码字段只有 4 个字节
+type xxhash32 struct {
+	*xxh64
+}
+
+func (h *xxhash32) Sum32() uint32 {
+	return uint32(h.Sum64())
+}
+
+func (h *xxhash32) Size() int {
+	return 4
+}
+
+func (h *xxhash32) Sum(b []byte) []byte {
+	s := h.Sum32()
+	return append(b, byte(s>>24), byte(s>>16), byte(s>>8), byte(s))
+}
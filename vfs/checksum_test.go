@@ -0,0 +1,114 @@
+package vfs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestXXHash64(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected uint64
+	}{
+		{"", 0xEF46DB3751D8E999},
+		{"a", 0xD24EC4F1A98C6E5B},
+		{"abc", 0x44BC2CF5AD770999},
+	}
+
+	for _, tt := range tests {
+		if sum := xxhash64([]byte(tt.input)); sum != tt.expected {
+			t.Errorf("expected xxhash64(%q) %x, got %x", tt.input, tt.expected, sum)
+		}
+	}
+
+	// 分多次写入和一次写入的结果一致
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	h := newXXHash64()
+	for i := 0; i < len(data); i += 7 {
+		end := i + 7
+		if end > len(data) {
+			end = len(data)
+		}
+		h.Write(data[i:end])
+	}
+	if h.Sum64() != xxhash64(data) {
+		t.Errorf("expected streaming hash %x, got %x", xxhash64(data), h.Sum64())
+	}
+}
+
+func TestChecksumAlgorithms(t *testing.T) {
+	for _, checksum := range []Checksum{ChecksumCRC32, ChecksumCRC32C, ChecksumXXHash64} {
+		t.Run(checksum.String(), func(t *testing.T) {
+			data, err := encodeSegment(testSegment("key", "value"), currentFormat, checksum)
+			if err != nil {
+				t.Fatalf("failed to encode segment: %v", err)
+			}
+
+			seg, _, err := decodeSegment(bytes.NewReader(data), 0, currentFormat, checksum)
+			if err != nil {
+				t.Fatalf("failed to decode segment: %v", err)
+			}
+			if string(seg.Value) != "value" {
+				t.Errorf("expected value, got %s", seg.Value)
+			}
+
+			data[len(data)-5] ^= 0xFF
+			if _, _, err := decodeSegment(bytes.NewReader(data), 0, currentFormat, checksum); err == nil {
+				t.Error("expected checksum mismatch for corrupted segment")
+			}
+		})
+	}
+
+	if _, err := ParseChecksum("md5"); err == nil {
+		t.Error("expected error for unsupported checksum name")
+	}
+}
+
+func TestChecksumRecordedInHeader(t *testing.T) {
+	dir := t.TempDir()
+	lfs, err := OpenFS(&Options{Path: dir, FsPerm: fsPerm, Threshold: 1, Checksum: ChecksumCRC32C})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	err = lfs.AddSegment(InodeNum("key-01"), *testSegment("key-01", "value"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+	mustCloseFS(t, lfs)
+
+	// 修改校验码算法之后旧的数据文件仍然按照文件头中的算法读取，新的记录写入新的数据文件
+	lfs, err = OpenFS(&Options{Path: dir, FsPerm: fsPerm, Threshold: 1, Checksum: ChecksumXXHash64})
+	if err != nil {
+		t.Fatalf("failed to reopen fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	err = lfs.AddSegment(InodeNum("key-02"), *testSegment("key-02", "value"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+
+	for _, key := range []string{"key-01", "key-02"} {
+		if _, err := lfs.FetchSegment(InodeNum(key)); err != nil {
+			t.Errorf("failed to fetch %s: %v", key, err)
+		}
+	}
+
+	for regionID, expected := range map[uint64]Checksum{1: ChecksumCRC32C, 2: ChecksumXXHash64} {
+		fd, err := os.Open(filepath.Join(dir, formatDataFileName(regionID)))
+		if err != nil {
+			t.Fatalf("failed to open region %d: %v", regionID, err)
+		}
+		_, checksum, err := readFileVersion(fd)
+		fd.Close()
+		if err != nil || checksum != expected {
+			t.Errorf("expected region %d checksum %s, got %s (%v)", regionID, expected, checksum, err)
+		}
+	}
+
+	if _, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1, Checksum: 99}); err == nil {
+		t.Error("expected error for unsupported checksum")
+	}
+}
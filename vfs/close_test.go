@@ -0,0 +1,61 @@
+package vfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCloseFSPersistsSnapshot(t *testing.T) {
+	path := t.TempDir()
+	snapshot := filepath.Join(path, indexFileName)
+
+	lfs, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+
+	err = lfs.AddSegment(InodeNum("key"), *testSegment("key", "value"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+
+	err = lfs.Close()
+	if err != nil {
+		t.Fatalf("failed to close fs: %v", err)
+	}
+
+	err = lfs.AddSegment(InodeNum("key-02"), *testSegment("key-02", "value"), 0)
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed after close, got %v", err)
+	}
+	if err := lfs.CloseFS(); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed on second close, got %v", err)
+	}
+
+	if _, err := os.Stat(snapshot); err != nil {
+		t.Fatalf("expected index snapshot after close: %v", err)
+	}
+	if _, err := os.Stat(snapshot + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("expected no temporary snapshot, got %v", err)
+	}
+
+	lfs, err = OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to reopen fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	// 快照记录了写入位置，恢复之后继续保留给非正常关闭之后的打开使用
+	if _, err := os.Stat(snapshot); err != nil {
+		t.Errorf("expected index snapshot to be kept on open, got %v", err)
+	}
+
+	if _, err := lfs.FetchSegment(InodeNum("key")); err != nil {
+		t.Errorf("failed to fetch segment after reopen: %v", err)
+	}
+	if lfs.LastSequence() != 1 {
+		t.Errorf("expected sequence 1, got %d", lfs.LastSequence())
+	}
+}
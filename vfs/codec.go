@@ -0,0 +1,269 @@
+package vfs
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Codec 是压缩算法的编号，自适应压缩时保存在压缩数据的第一个字节
+// | CODEC 1 | COMPRESSED ? |
+type Codec uint8
+
+const (
+	CodecRaw    Codec = iota // 不压缩
+	CodecSnappy              // 速度优先
+	CodecFlate               // 压缩率优先
+	CodecZstd                // 预留给 zstd，需要通过 RegisterCodec 注册实现
+)
+
+var ErrUnknownCodec = errors.New("unknown compression codec")
+
+var codecs = struct {
+	sync.RWMutex
+	m map[Codec]Compressor
+}{
+	m: map[Codec]Compressor{
+		CodecSnappy: SnappyCompressor,
+		CodecFlate:  FlateCompressor,
+	},
+}
+
+// RegisterCodec 注册或者替换一个压缩算法，读取时按照记录中保存的编号选择解压算法
+// 已经写入的记录依赖这个编号，替换之后的实现必须能解压之前写入的数据
+func RegisterCodec(id Codec, compressor Compressor) error {
+	if id == CodecRaw || compressor == nil {
+		return fmt.Errorf("failed to register codec %d: invalid codec", id)
+	}
+
+	codecs.Lock()
+	defer codecs.Unlock()
+	codecs.m[id] = compressor
+
+	return nil
+}
+
+func lookupCodec(id Codec) (Compressor, bool) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	compressor, ok := codecs.m[id]
+	return compressor, ok
+}
+
+// AdaptiveOptions 配置自适应压缩如何为每条记录选择压缩算法
+type AdaptiveOptions struct {
+	// SampleSize 用 Value 前面多少字节估算压缩率，默认 4KB
+	SampleSize int
+	// MaxRatio 样本压缩之后和压缩之前的比例超过它就认为数据不可压缩直接保存，默认 0.9
+	MaxRatio float64
+	// LargeValue 超过这个字节数的 Value 使用压缩率优先的算法，否则使用速度优先的 Snappy，默认 64KB
+	LargeValue int
+}
+
+func (opt AdaptiveOptions) withDefaults() AdaptiveOptions {
+	if opt.SampleSize <= 0 {
+		opt.SampleSize = 4 * KB
+	}
+	if opt.MaxRatio <= 0 {
+		opt.MaxRatio = 0.9
+	}
+	if opt.LargeValue <= 0 {
+		opt.LargeValue = 64 * KB
+	}
+	return opt
+}
+
+// choose 使用 Snappy 压缩样本估算压缩率，然后根据 Value 的大小选择压缩算法
+func (opt *AdaptiveOptions) choose(data []byte) Codec {
+	if len(data) == 0 {
+		return CodecRaw
+	}
+
+	sample := data
+	if len(sample) > opt.SampleSize {
+		sample = sample[:opt.SampleSize]
+	}
+
+	// 样本压缩之后就丢弃了，使用池中的缓冲区
+	compressed, scratch := snappyScratch(sample)
+	ratio := float64(len(compressed)) / float64(len(sample))
+	putBuffer(scratch)
+	if ratio > opt.MaxRatio {
+		return CodecRaw
+	}
+
+	if len(data) >= opt.LargeValue {
+		// 注册了 zstd 就优先使用 zstd
+		if _, ok := lookupCodec(CodecZstd); ok {
+			return CodecZstd
+		}
+		return CodecFlate
+	}
+
+	return CodecSnappy
+}
+
+// compressAdaptive 按照选择的压缩算法压缩 data，返回的数据带有压缩算法编号
+// 不值得压缩的数据原样返回并且 ok 为 false
+func (opt *AdaptiveOptions) compress(kind Kind, data []byte) ([]byte, bool, error) {
+	codec := opt.choose(data)
+	if codec == CodecRaw {
+		return data, false, nil
+	}
+
+	compressor, ok := lookupCodec(codec)
+	if !ok {
+		return nil, false, fmt.Errorf("%w: %d", ErrUnknownCodec, codec)
+	}
+
+	// Snappy 先压缩到池中的缓冲区，确定要保存之后才分配结果
+	var compressed []byte
+	if _, ok := compressor.(*Snappy); ok {
+		var scratch *[]byte
+		compressed, scratch = snappyScratch(data)
+		defer putBuffer(scratch)
+	} else {
+		var err error
+		compressed, err = compressor.Compress(data)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	// 样本的估算不一定准确，整体压缩之后没有变小就原样保存
+	if len(compressed)+1 >= len(data) {
+		compressionStats.record(kind, compressorName(compressor), len(data), len(data))
+		return data, false, nil
+	}
+	compressionStats.record(kind, compressorName(compressor), len(data), len(compressed)+1)
+
+	result := make([]byte, len(compressed)+1)
+	result[0] = byte(codec)
+	copy(result[1:], compressed)
+	return result, true, nil
+}
+
+// decompressCodec 按照数据第一个字节的压缩算法编号解压
+func decompressCodec(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: empty data", ErrUnknownCodec)
+	}
+
+	compressor, ok := lookupCodec(Codec(data[0]))
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownCodec, data[0])
+	}
+
+	return compressor.Decompress(data[1:])
+}
+
+var FlateCompressor = new(Flate)
+
+// Flate 使用标准库的 DEFLATE 算法，压缩率比 Snappy 高但是速度更慢
+type Flate struct{}
+
+func (f *Flate) Name() string {
+	return "flate"
+}
+
+func (f *Flate) Compress(data []byte) ([]byte, error) {
+	return flateCompress(data)
+}
+
+func (f *Flate) Decompress(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// metadataCompressor 表示 hint 文件和索引快照使用 Transformer 当前配置的 Compressor 压缩，读取时也需要同样的配置
+// 其他非零的值是内置或者注册的 Codec 编号，0 表示没有压缩
+const metadataCompressor = 0xFF
+
+// compressMetadata 使用当前的压缩设置压缩 hint 文件和索引快照这类元数据，返回压缩方式和压缩之后的数据
+// 没有开启压缩或者压缩之后没有变小时原样返回，自适应压缩时使用 Snappy
+func (t *Transformer) compressMetadata(data []byte) (byte, []byte, error) {
+	if !t.IsCompressionEnabled() {
+		return byte(CodecRaw), data, nil
+	}
+
+	compressor := t.Compressor
+	if t.adaptive != nil {
+		compressor = SnappyCompressor
+	}
+	if compressor == nil {
+		return byte(CodecRaw), data, nil
+	}
+	mode := metadataCodec(compressor)
+
+	compressed, err := compressor.Compress(data)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to compress metadata: %w", err)
+	}
+	if len(compressed) >= len(data) {
+		return byte(CodecRaw), data, nil
+	}
+	return mode, compressed, nil
+}
+
+// decompressMetadata 按照 compressMetadata 返回的压缩方式解压元数据
+func (t *Transformer) decompressMetadata(mode byte, data []byte) ([]byte, error) {
+	switch mode {
+	case byte(CodecRaw):
+		return data, nil
+	case metadataCompressor:
+		if t.Compressor == nil {
+			return nil, errors.New("failed to decompress metadata: no compressor configured")
+		}
+		return t.Compressor.Decompress(data)
+	}
+
+	compressor, ok := lookupCodec(Codec(mode))
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownCodec, mode)
+	}
+	return compressor.Decompress(data)
+}
+
+// encodeMetadata 依次压缩和加密元数据，返回 | CODEC 1 | CRYPT 1 | DATA ? |
+func (t *Transformer) encodeMetadata(data []byte) ([]byte, error) {
+	codec, data, err := t.compressMetadata(data)
+	if err != nil {
+		return nil, err
+	}
+	crypt, data, err := t.encryptMetadata(data)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 0, 2+len(data))
+	buf = append(buf, codec, crypt)
+	return append(buf, data...), nil
+}
+
+// decodeMetadata 解析 encodeMetadata 生成的数据，先解密再解压
+func (t *Transformer) decodeMetadata(data []byte) ([]byte, error) {
+	if len(data) < 2 {
+		return nil, errors.New("failed to decode metadata: missing codec")
+	}
+	decrypted, err := t.decryptMetadata(data[1], data[2:])
+	if err != nil {
+		return nil, err
+	}
+	return t.decompressMetadata(data[0], decrypted)
+}
+
+// metadataCodec 返回内置压缩算法的 Codec 编号，读取时不依赖当前配置的 Compressor
+func metadataCodec(compressor Compressor) byte {
+	switch compressor.(type) {
+	case *Snappy:
+		return byte(CodecSnappy)
+	case *Flate:
+		return byte(CodecFlate)
+	default:
+		return metadataCompressor
+	}
+}
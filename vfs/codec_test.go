@@ -0,0 +1,79 @@
+package vfs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestAdaptiveCompression(t *testing.T) {
+	tf := NewTransformer()
+	tf.SetAdaptiveCompression(AdaptiveOptions{LargeValue: 4 * KB})
+
+	random := make([]byte, 2*KB)
+	_, err := rand.Read(random)
+	if err != nil {
+		t.Fatalf("failed to generate random data: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		data  []byte
+		codec Codec
+	}{
+		{"incompressible", random, CodecRaw},
+		{"small", bytes.Repeat([]byte("wiredkv"), 64), CodecSnappy},
+		{"large", bytes.Repeat([]byte("wiredkv"), 4*KB), CodecFlate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, flags, err := tf.encode(Binary, tt.data)
+			if err != nil {
+				t.Fatalf("failed to encode data: %v", err)
+			}
+
+			codec := CodecRaw
+			if flags&flagCodec != 0 {
+				codec = Codec(data[0])
+			}
+			if codec != tt.codec {
+				t.Errorf("expected codec %d, got %d", tt.codec, codec)
+			}
+
+			// 读取时只依赖记录中保存的压缩算法编号
+			decoded, err := NewTransformer().decode(flags, data)
+			if err != nil {
+				t.Fatalf("failed to decode data: %v", err)
+			}
+			if !bytes.Equal(decoded, tt.data) {
+				t.Errorf("expected %d bytes decoded, got %d", len(tt.data), len(decoded))
+			}
+		})
+	}
+}
+
+func TestRegisterCodec(t *testing.T) {
+	if err := RegisterCodec(CodecRaw, SnappyCompressor); err == nil {
+		t.Error("expected error when registering raw codec")
+	}
+
+	err := RegisterCodec(CodecZstd, FlateCompressor)
+	if err != nil {
+		t.Fatalf("failed to register codec: %v", err)
+	}
+	t.Cleanup(func() {
+		codecs.Lock()
+		delete(codecs.m, CodecZstd)
+		codecs.Unlock()
+	})
+
+	opt := AdaptiveOptions{}.withDefaults()
+	if codec := opt.choose(bytes.Repeat([]byte("a"), 128*KB)); codec != CodecZstd {
+		t.Errorf("expected registered zstd codec for large value, got %d", codec)
+	}
+
+	if _, err := decompressCodec([]byte{0xEE, 1, 2}); err == nil {
+		t.Error("expected error for unknown codec")
+	}
+}
@@ -0,0 +1,183 @@
+package vfs
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// CompactionStatus 是当前压缩任务的进度
+type CompactionStatus struct {
+	Running        bool          // 是否有数据文件正在被压缩
+	Paused         bool          // 压缩是否被暂停
+	RegionID       uint64        // 正在压缩的数据文件
+	BytesProcessed int64         // 已经扫描的字节数
+	BytesTotal     int64         // 数据文件的总字节数
+	StartedAt      time.Time     // 开始压缩这个数据文件的时间
+	ETA            time.Duration // 按照目前的速度估算的剩余时间，还没有进度时为 0
+}
+
+// CompactOptions 选择 Compact 需要压缩的数据文件
+type CompactOptions struct {
+	// FileIDs 需要压缩的数据文件编号，即使没有垃圾数据也会被重写，不能包含活跃数据文件
+	FileIDs []uint64
+	// MaxDeadRatio 没有指定 FileIDs 时，只压缩垃圾数据比例不低于它的数据文件，0 表示所有存在垃圾数据的数据文件
+	MaxDeadRatio float64
+	// Sorted 把选中数据文件中的有效记录按照 Key 排序合并到新的非活跃数据文件中，并且生成稀疏块索引
+	// 之后范围遍历这些记录时按块顺序读取数据文件，不需要每条记录单独读取一次
+	Sorted bool
+}
+
+// CompactionController 可以在流量高峰时暂停压缩，高峰过后再恢复
+// 暂停只会在两条记录之间生效，正在迁移的记录会先完成
+type CompactionController struct {
+	mu        sync.Mutex
+	paused    bool
+	resume    chan struct{}
+	running   bool
+	regionID  uint64
+	processed int64
+	total     int64
+	started   time.Time
+}
+
+// Compaction 返回控制后台和手动压缩的 CompactionController
+func (lfs *LogStructuredFS) Compaction() *CompactionController {
+	return &lfs.compaction
+}
+
+// Pause 暂停压缩，已经暂停时不做任何事情
+// 暂停期间压缩仍然持有数据文件，停止后台 gc 会一直等到恢复之后当前的数据文件压缩完成
+func (c *CompactionController) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.paused {
+		c.paused = true
+		c.resume = make(chan struct{})
+	}
+}
+
+// Resume 恢复被暂停的压缩
+func (c *CompactionController) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused {
+		c.paused = false
+		close(c.resume)
+	}
+}
+
+// Status 返回当前压缩任务的进度
+func (c *CompactionController) Status() CompactionStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status := CompactionStatus{
+		Running:        c.running,
+		Paused:         c.paused,
+		RegionID:       c.regionID,
+		BytesProcessed: c.processed,
+		BytesTotal:     c.total,
+		StartedAt:      c.started,
+	}
+	if c.running && c.processed > 0 {
+		elapsed := time.Since(c.started)
+		status.ETA = time.Duration(float64(elapsed) / float64(c.processed) * float64(c.total-c.processed))
+	}
+
+	return status
+}
+
+// wait 在压缩被暂停时阻塞，直到恢复或者 ctx 被取消
+func (c *CompactionController) wait(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		if !c.paused {
+			c.mu.Unlock()
+			return nil
+		}
+		resume := c.resume
+		c.mu.Unlock()
+
+		select {
+		case <-resume:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *CompactionController) begin(regionID uint64, total int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running = true
+	c.regionID = regionID
+	c.processed = 0
+	c.total = total
+	c.started = time.Now()
+}
+
+func (c *CompactionController) progress(processed int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.processed = processed
+}
+
+func (c *CompactionController) end() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running = false
+}
+
+// 压缩历史最多保留的记录数，更早的记录会被丢弃
+const compactionHistorySize = 64
+
+// CompactionRecord 是一次数据文件压缩的统计信息
+type CompactionRecord struct {
+	RegionID       uint64
+	StartedAt      time.Time
+	Duration       time.Duration
+	BytesRead      int64 // 被压缩的数据文件的字节数
+	BytesWritten   int64 // 迁移到活跃数据文件的字节数
+	BytesReclaimed int64 // 压缩之后释放的字节数
+	RecordsMoved   int64
+	Dropped        DroppedRecords
+}
+
+// DroppedRecords 按照原因统计压缩时丢弃的记录数
+type DroppedRecords struct {
+	Overwritten int64 // 已经被更新或者删除的旧记录
+	Expired     int64 // 已经过期的记录
+	Tombstones  int64 // 不再需要的删除记录
+	Commits     int64 // 批量写入的提交记录
+}
+
+// compactionHistory 是固定大小的压缩记录环形缓冲区，零值可以直接使用
+type compactionHistory struct {
+	mu      sync.Mutex
+	records []CompactionRecord
+	next    int
+}
+
+func (h *compactionHistory) add(record CompactionRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.records) < compactionHistorySize {
+		h.records = append(h.records, record)
+		return
+	}
+	h.records[h.next] = record
+	h.next = (h.next + 1) % compactionHistorySize
+}
+
+// snapshot 按照从旧到新的顺序返回压缩记录的副本
+func (h *compactionHistory) snapshot() []CompactionRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	result := make([]CompactionRecord, 0, len(h.records))
+	result = append(result, h.records[h.next:]...)
+	result = append(result, h.records[:h.next]...)
+	return result
+}
@@ -0,0 +1,221 @@
+package vfs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompactionPauseResume(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	for _, key := range []string{"key-01", "key-02", "key-03"} {
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value"), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+	err = lfs.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+
+	controller := lfs.Compaction()
+	controller.Pause()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- lfs.compactRegion(1)
+	}()
+
+	// 暂停之后压缩停在第一条记录之前
+	deadline := time.Now().Add(3 * time.Second)
+	for !controller.Status().Running {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected compaction to start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case err := <-done:
+		t.Fatalf("expected compaction to be paused, got: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	status := controller.Status()
+	if !status.Paused || status.RegionID != 1 || status.BytesTotal == 0 {
+		t.Errorf("unexpected paused status: %+v", status)
+	}
+
+	controller.Resume()
+	if err := <-done; err != nil {
+		t.Fatalf("failed to compact region: %v", err)
+	}
+
+	status = controller.Status()
+	if status.Running || status.Paused {
+		t.Errorf("expected compaction to be finished, got: %+v", status)
+	}
+}
+
+func TestCompactWithOptions(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	// region 1 一半是垃圾数据，region 2 全部是有效数据
+	for _, key := range []string{"key-01", "key-02"} {
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value"), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+	err = lfs.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+	err = lfs.AddSegment(InodeNum("key-01"), *testSegment("key-01", "value"), 0)
+	if err != nil {
+		t.Fatalf("failed to update segment: %v", err)
+	}
+	err = lfs.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+
+	// 垃圾比例不够的数据文件不会被压缩
+	err = lfs.Compact(context.Background(), CompactOptions{MaxDeadRatio: 0.9})
+	if err != nil {
+		t.Fatalf("failed to compact: %v", err)
+	}
+	if _, ok := lfs.regions[1]; !ok {
+		t.Errorf("expected region 1 to be kept below dead ratio")
+	}
+
+	// 指定的数据文件即使没有垃圾数据也会被重写
+	err = lfs.Compact(context.Background(), CompactOptions{FileIDs: []uint64{2}})
+	if err != nil {
+		t.Fatalf("failed to compact region 2: %v", err)
+	}
+	if _, ok := lfs.regions[2]; ok {
+		t.Errorf("expected region 2 to be compacted")
+	}
+	if _, ok := lfs.regions[1]; !ok {
+		t.Errorf("expected region 1 to be untouched")
+	}
+
+	err = lfs.Compact(context.Background(), CompactOptions{FileIDs: []uint64{lfs.activeRegionID()}})
+	if err == nil {
+		t.Errorf("expected error when compacting the active region")
+	}
+}
+
+func TestCompactionHistory(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	for _, key := range []string{"key-01", "key-02"} {
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value"), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+	err = lfs.AddSegment(InodeNum("key-01"), *testSegment("key-01", "updated"), 0)
+	if err != nil {
+		t.Fatalf("failed to update segment: %v", err)
+	}
+	err = lfs.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+
+	err = lfs.compactRegion(1)
+	if err != nil {
+		t.Fatalf("failed to compact region: %v", err)
+	}
+
+	history := lfs.Stats().Compactions
+	if len(history) != 1 {
+		t.Fatalf("expected 1 compaction record, got %d", len(history))
+	}
+	record := history[0]
+	if record.RegionID != 1 || record.RecordsMoved != 2 || record.Dropped.Overwritten != 1 {
+		t.Errorf("unexpected compaction record: %+v", record)
+	}
+	if record.BytesReclaimed <= 0 || record.BytesReclaimed != record.BytesRead-record.BytesWritten {
+		t.Errorf("unexpected reclaimed bytes: %+v", record)
+	}
+
+	// 超过上限之后只保留最新的记录
+	var h compactionHistory
+	for i := 0; i < compactionHistorySize+3; i++ {
+		h.add(CompactionRecord{RegionID: uint64(i)})
+	}
+	records := h.snapshot()
+	if len(records) != compactionHistorySize || records[0].RegionID != 3 || records[len(records)-1].RegionID != compactionHistorySize+2 {
+		t.Errorf("unexpected bounded history: %d records from %d to %d", len(records), records[0].RegionID, records[len(records)-1].RegionID)
+	}
+}
+
+func TestCompactionMoveAfterWrite(t *testing.T) {
+	path := t.TempDir()
+	lfs, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+
+	inum := InodeNum("key")
+	err = lfs.AddSegment(inum, *testSegment("key", "value-01"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+	err = lfs.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+
+	// 压缩读取了记录和索引之后，迁移之前 Key 被更新了
+	inode, _ := lfs.GetINode(inum)
+	fd, version, checksum, _ := lfs.regionFile(inode.RegionID)
+	_, segment, _, err := readRawSegment(fd, inode.Position, version, checksum)
+	if err != nil {
+		t.Fatalf("failed to read segment: %v", err)
+	}
+	err = lfs.AddSegment(inum, *testSegment("key", "value-02"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+	err = lfs.moveSegment(inum, inode, segment)
+	if err != nil {
+		t.Fatalf("failed to move segment: %v", err)
+	}
+	mustCloseFS(t, lfs)
+
+	// 删除索引快照模拟崩溃，重放数据文件之后仍然是更新的写入
+	os.Remove(filepath.Join(path, indexFileName))
+	lfs, err = OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to reopen fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	seg, err := lfs.FetchSegment(inum)
+	if err != nil {
+		t.Fatalf("failed to fetch segment: %v", err)
+	}
+	if string(seg.Value) != "value-02" {
+		t.Errorf("expected value-02 after recovery, got %s", seg.Value)
+	}
+}
@@ -0,0 +1,210 @@
+package vfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/auula/wiredkv/utils"
+)
+
+// Comparator 定义 Key 的顺序，遍历、范围删除和排序数据文件都按照这个顺序
+// Name 会保存在数据目录的 manifest 文件中，同一个数据目录必须一直使用同名的 Comparator 打开
+type Comparator interface {
+	// Name 返回 Comparator 的名字，顺序改变时必须使用新的名字
+	Name() string
+	// Compare 在 a 小于、等于、大于 b 时分别返回 -1、0、1
+	Compare(a, b string) int
+}
+
+type bytewiseComparator struct{}
+
+func (bytewiseComparator) Name() string { return "wiredkv.bytewise" }
+
+func (bytewiseComparator) Compare(a, b string) int { return strings.Compare(a, b) }
+
+type reverseComparator struct{}
+
+func (reverseComparator) Name() string { return "wiredkv.reverse-bytewise" }
+
+func (reverseComparator) Compare(a, b string) int { return strings.Compare(b, a) }
+
+type numericComparator struct{}
+
+func (numericComparator) Name() string { return "wiredkv.numeric" }
+
+// Compare 两个 Key 都是十进制非负整数时按照数值比较，数字排在其他 Key 前面，其他 Key 按照字节序
+func (numericComparator) Compare(a, b string) int {
+	na, nb := isDecimal(a), isDecimal(b)
+	switch {
+	case na && nb:
+		a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+		if len(a) != len(b) {
+			if len(a) < len(b) {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a, b)
+	case na:
+		return -1
+	case nb:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func isDecimal(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < '0' || key[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	// BytewiseComparator 默认的字节序，大端编码的整数和时间戳按照数值从小到大排列
+	BytewiseComparator Comparator = bytewiseComparator{}
+	// ReverseBytewiseComparator 和字节序相反，例如大端编码的时间戳从新到旧排列
+	ReverseBytewiseComparator Comparator = reverseComparator{}
+	// NumericComparator 把十进制整数的 Key 按照数值排序，例如 2 排在 10 前面
+	NumericComparator Comparator = numericComparator{}
+)
+
+// builtinComparators 没有配置 Comparator 时按照 manifest 中的名字选择内置的 Comparator
+var builtinComparators = map[string]Comparator{
+	BytewiseComparator.Name():        BytewiseComparator,
+	ReverseBytewiseComparator.Name(): ReverseBytewiseComparator,
+	NumericComparator.Name():         NumericComparator,
+}
+
+// compareKeys 使用 cmp 比较两个 Key，cmp 为 nil 时按照字节序
+func compareKeys(cmp Comparator, a, b string) int {
+	if cmp == nil {
+		return strings.Compare(a, b)
+	}
+	return cmp.Compare(a, b)
+}
+
+// manifest 文件记录数据目录的 Comparator，打开数据目录时校验，格式：| SIGN 4 | NLEN 4 | NAME ? | CRC32 4 |
+// manifest 中没有 Key，开启加密之后也不需要加密，打开数据目录时配置密钥之前就可以读取
+const manifestFileName = "wiredkv.manifest"
+
+// manifest 文件头，第三个字节和数据文件头不同，最后一个字节为 manifest 文件的格式版本
+var manifestFileMetadata = []byte{0xDB, 0x0, 0x3, 0x1}
+
+var ErrComparatorMismatch = errors.New("key comparator mismatch")
+
+// loadManifest 读取数据目录中记录的 Comparator 名字，没有 manifest 文件时返回 os.ErrNotExist
+func loadManifest(path string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(path, manifestFileName))
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) < len(manifestFileMetadata)+8 || !bytes.Equal(data[:len(manifestFileMetadata)], manifestFileMetadata) {
+		return "", errors.New("invalid manifest file signature")
+	}
+	body := data[:len(data)-4]
+	if binary.LittleEndian.Uint32(data[len(body):]) != crc32.ChecksumIEEE(body) {
+		return "", errors.New("failed to manifest file crc32 checksum mismatch")
+	}
+
+	nlen := int(binary.LittleEndian.Uint32(body[len(manifestFileMetadata):]))
+	name := body[len(manifestFileMetadata)+4:]
+	if nlen != len(name) {
+		return "", fmt.Errorf("invalid manifest comparator name length %d", nlen)
+	}
+
+	return string(name), nil
+}
+
+// saveManifest 先写入临时文件再重命名，保证 manifest 文件不会只写入一半
+func saveManifest(path, comparator string, perm fs.FileMode) error {
+	buf := append([]byte(nil), manifestFileMetadata...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(comparator)))
+	buf = append(buf, comparator...)
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+
+	name := filepath.Join(path, manifestFileName)
+	tmp, err := os.OpenFile(name+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(buf)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := utils.CloseFile(tmp); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(name + ".tmp")
+		return fmt.Errorf("failed to write manifest file: %w", err)
+	}
+
+	return os.Rename(name+".tmp", name)
+}
+
+// openComparator 返回打开数据目录使用的 Comparator，第一次打开时把它的名字写入 manifest 文件
+// 没有 manifest 文件但是已经有数据文件的目录是按照字节序写入的，只能使用 BytewiseComparator 打开
+// cmp 为 nil 时使用 manifest 中记录的内置 Comparator，只读实例不会写入 manifest 文件
+func openComparator(path string, cmp Comparator, readOnly bool, perm fs.FileMode) (Comparator, error) {
+	name, err := loadManifest(path)
+	if errors.Is(err, os.ErrNotExist) {
+		name = ""
+		if cmp != nil && cmp.Name() != BytewiseComparator.Name() && hasRegionFiles(path) {
+			return nil, fmt.Errorf("%w: data directory was written with %s, opened with %s", ErrComparatorMismatch, BytewiseComparator.Name(), cmp.Name())
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to load manifest: %w", err)
+	}
+
+	if cmp == nil {
+		cmp = BytewiseComparator
+		if name != "" {
+			builtin, ok := builtinComparators[name]
+			if !ok {
+				return nil, fmt.Errorf("%w: data directory requires comparator %s", ErrComparatorMismatch, name)
+			}
+			cmp = builtin
+		}
+	}
+	if name != "" && name != cmp.Name() {
+		return nil, fmt.Errorf("%w: data directory was written with %s, opened with %s", ErrComparatorMismatch, name, cmp.Name())
+	}
+
+	if name == "" && !readOnly {
+		err = saveManifest(path, cmp.Name(), perm)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return cmp, nil
+}
+
+// hasRegionFiles 判断数据目录中是否已经有数据文件
+func hasRegionFiles(path string) bool {
+	files, err := os.ReadDir(path)
+	if err != nil {
+		return false
+	}
+	for _, file := range files {
+		if !file.IsDir() && isRegionFileName(file.Name()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNumericComparator(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"2", "10", -1},
+		{"010", "10", 0},
+		{"99", "100", -1},
+		{"100", "abc", -1},
+		{"abc", "abd", -1},
+		{"b", "15", 1},
+	}
+	for _, tt := range tests {
+		if got := NumericComparator.Compare(tt.a, tt.b); got != tt.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestComparatorOrdering(t *testing.T) {
+	path := t.TempDir()
+	opt := &Options{Path: path, FsPerm: fsPerm, Threshold: 1, Comparator: NumericComparator}
+	lfs, err := OpenFS(opt)
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+
+	for _, key := range []string{"100", "9", "25", "3", "1000"} {
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, key), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+
+	// 数值顺序中 [10, 200) 只包含 25 和 100
+	err = lfs.DeleteRange("10", "200")
+	if err != nil {
+		t.Fatalf("failed to delete range: %v", err)
+	}
+	if err := lfs.DeletePrefix("1"); err == nil {
+		t.Fatalf("expected delete prefix to be rejected by numeric comparator")
+	}
+
+	check := func(lfs *LogStructuredFS) {
+		t.Helper()
+		var keys []string
+		it := lfs.NewIterator(context.Background(), "")
+		for it.Next() {
+			keys = append(keys, it.Key())
+		}
+		if err := it.Err(); err != nil {
+			t.Fatalf("failed to iterate: %v", err)
+		}
+		if fmt.Sprint(keys) != "[3 9 1000]" {
+			t.Fatalf("expected keys [3 9 1000], got %v", keys)
+		}
+
+		it.Seek("10")
+		if !it.Next() || it.Key() != "1000" {
+			t.Fatalf("expected seek to 1000, got %q", it.Key())
+		}
+		it.Close()
+
+		page, err := lfs.ScanPage("", "", 2)
+		if err != nil || fmt.Sprint(page.Keys) != "[3 9]" {
+			t.Fatalf("expected first page [3 9], got %v %v", page, err)
+		}
+		page, err = lfs.ScanPage("", page.Cursor, 2)
+		if err != nil || fmt.Sprint(page.Keys) != "[1000]" {
+			t.Fatalf("expected second page [1000], got %v %v", page, err)
+		}
+	}
+	check(lfs)
+
+	err = lfs.CloseFS()
+	if err != nil {
+		t.Fatalf("failed to close fs: %v", err)
+	}
+
+	// 崩溃恢复重放范围删除记录时也要按照数值顺序
+	err = os.Remove(filepath.Join(path, indexFileName))
+	if err != nil {
+		t.Fatalf("failed to remove index snapshot: %v", err)
+	}
+
+	_, err = OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1, Comparator: ReverseBytewiseComparator})
+	if !errors.Is(err, ErrComparatorMismatch) {
+		t.Fatalf("expected ErrComparatorMismatch, got %v", err)
+	}
+
+	// 没有配置 Comparator 时使用 manifest 中记录的内置 Comparator
+	lfs, err = OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to reopen fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+	if lfs.comparator != NumericComparator {
+		t.Fatalf("expected numeric comparator, got %s", lfs.comparator.Name())
+	}
+	check(lfs)
+}
+
+func TestComparatorExistingData(t *testing.T) {
+	path := t.TempDir()
+	lfs, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	err = lfs.AddSegment(InodeNum("key"), *testSegment("key", "value"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+	mustCloseFS(t, lfs)
+
+	// 没有 manifest 文件的旧数据目录是按照字节序写入的
+	err = os.Remove(filepath.Join(path, manifestFileName))
+	if err != nil {
+		t.Fatalf("failed to remove manifest: %v", err)
+	}
+	_, err = OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1, Comparator: NumericComparator})
+	if !errors.Is(err, ErrComparatorMismatch) {
+		t.Fatalf("expected ErrComparatorMismatch, got %v", err)
+	}
+
+	lfs, err = OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to reopen fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+	name, err := loadManifest(path)
+	if err != nil || name != BytewiseComparator.Name() {
+		t.Fatalf("expected manifest with %s, got %q %v", BytewiseComparator.Name(), name, err)
+	}
+}
@@ -0,0 +1,157 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// CopyReport 记录一次 CopyChanges 的结果
+type CopyReport struct {
+	Copied    int    // 写入目标实例的记录条数
+	Deleted   int    // 从目标实例中删除的 Key 个数
+	Bytes     int64  // 写入目标实例的字节数
+	Watermark uint64 // 这次复制开始之前源实例已经写入的最大版本号，下一次复制从这里继续
+}
+
+type copyEntry struct {
+	inum  uint64
+	inode *INode
+}
+
+// CopyChanges 把版本号大于 since 的记录原样写入 dst，since 为 0 时复制所有的记录，包括旧格式版本中版本号为 0 的记录
+// 记录不会重新编码，两个实例使用同一个进程中的压缩和加密配置，dst 的写入限速同样生效
+// 源实例可以是开启了 Follower 的只读实例，成功返回的 Watermark 作为下一次的 since 就能持续追上写入进程的修改
+// prune 为 true 时删除 dst 中源实例已经不存在的 Key，dst 必须是只用于接收复制的实例
+func (lfs *LogStructuredFS) CopyChanges(ctx context.Context, dst *LogStructuredFS, since uint64, prune bool) (*CopyReport, error) {
+	report := &CopyReport{Watermark: since}
+
+	entries, watermark, err := lfs.liveEntries(ctx, since)
+	if err != nil {
+		return report, err
+	}
+	if watermark > report.Watermark {
+		report.Watermark = watermark
+	}
+
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		seg, err := lfs.readRawINode(e.inum, e.inode)
+		if errors.Is(err, ErrSegmentNotFound) {
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		// 批量写入的记录单独写入目标实例，不能再带有批量写入的标志位，否则恢复时会被当作没有提交的记录丢弃
+		rec := *seg
+		rec.Flags &^= flagBatch
+		_, err = dst.putSegment(ctx, e.inum, rec, nil)
+		if err != nil {
+			return report, fmt.Errorf("failed to copy segment (inum: %d): %w", e.inum, err)
+		}
+		report.Copied++
+		report.Bytes += int64(rec.Size())
+	}
+
+	if !prune {
+		return report, nil
+	}
+
+	var stale []string
+	for _, shard := range dst.indexs {
+		shard.mu.RLock()
+		shard.each(func(inum uint64, inode *INode) bool {
+			if _, ok := lfs.GetINode(inum); !ok {
+				stale = append(stale, inode.Key)
+			}
+			return true
+		})
+		shard.mu.RUnlock()
+	}
+	for _, key := range stale {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		err := dst.DelSegment(key)
+		if err != nil {
+			return report, fmt.Errorf("failed to delete copied segment: %w", err)
+		}
+		report.Deleted++
+	}
+	return report, nil
+}
+
+// ChangedKeys 返回版本号大于 since 并且没有过期的 Key，以及遍历之前的水位线，since 为 0 时返回所有的 Key
+// 和 CopyChanges 一样把返回的水位线作为下一次的 since，用于把修改复制到不能直接写入的实例，例如通过网络写入另一个服务进程
+// 被删除的 Key 不会出现在结果中，需要调用方自己通过 GetINode 检查之前复制过的 Key 是否还存在
+func (lfs *LogStructuredFS) ChangedKeys(ctx context.Context, since uint64) ([]string, uint64, error) {
+	entries, watermark, err := lfs.liveEntries(ctx, since)
+	if err != nil {
+		return nil, since, err
+	}
+	if watermark < since {
+		watermark = since
+	}
+	keys := make([]string, len(entries))
+	for i, e := range entries {
+		keys[i] = e.inode.Key
+	}
+	return keys, watermark, nil
+}
+
+// liveEntries 返回版本号大于 since 并且没有过期的索引，since 为 0 时返回所有的索引，同时返回遍历之前的水位线
+// 和快照一样在 appendMu 的写锁下读取序列号，写入在分配版本号到更新索引期间都持有 appendMu 的读锁，
+// 所以不大于水位线的记录遍历时都已经在索引中，遍历期间写入的记录版本号更大，下一次复制时还会被复制
+// 不能使用遍历时看到的最大版本号，已经遍历过的分片中写入的较小版本号会被跳过
+func (lfs *LogStructuredFS) liveEntries(ctx context.Context, since uint64) ([]copyEntry, uint64, error) {
+	lfs.appendMu.Lock()
+	watermark := lfs.LastSequence()
+	lfs.appendMu.Unlock()
+
+	var entries []copyEntry
+	for _, shard := range lfs.indexs {
+		if err := ctx.Err(); err != nil {
+			return nil, watermark, err
+		}
+		shard.mu.RLock()
+		shard.each(func(inum uint64, inode *INode) bool {
+			if (since == 0 || inode.Version > since) && !isExpired(inode.ExpiredAt) {
+				entries = append(entries, copyEntry{inum: inum, inode: inode})
+			}
+			return true
+		})
+		shard.mu.RUnlock()
+	}
+	return entries, watermark, nil
+}
+
+// readRawINode 读取 inode 指向的没有解码的记录，数据文件被压缩之后重新查找一次索引，Key 已经被删除时返回 ErrSegmentNotFound
+func (lfs *LogStructuredFS) readRawINode(inum uint64, inode *INode) (*Segment, error) {
+	for retry := 0; ; retry++ {
+		if seg := lfs.lsm.get(inode); seg != nil {
+			return seg, nil
+		}
+
+		fd, version, checksum, ok := lfs.regionFile(inode.RegionID)
+		if ok {
+			_, seg, _, err := readRawSegment(fd, inode.Position, version, checksum)
+			if err == nil {
+				return seg, nil
+			}
+			if !errors.Is(err, os.ErrClosed) || retry > 0 {
+				return nil, fmt.Errorf("failed to read segment (inum: %d): %w", inum, err)
+			}
+		} else if retry > 0 {
+			return nil, fmt.Errorf("region file not found for region id: %d", inode.RegionID)
+		}
+
+		inode, ok = lfs.GetINode(inum)
+		if !ok || isExpired(inode.ExpiredAt) {
+			return nil, ErrSegmentNotFound
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCopyChanges(t *testing.T) {
+	src, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open source fs: %v", err)
+	}
+	defer mustCloseFS(t, src)
+
+	path := t.TempDir()
+	dst, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open target fs: %v", err)
+	}
+
+	for _, key := range []string{"key-01", "key-02"} {
+		err := src.AddSegment(InodeNum(key), *testSegment(key, "value"), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+	err = src.Batch(func(b *Batch) error {
+		b.AddSegment(InodeNum("key-03"), *testSegment("key-03", "batched"))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to commit batch: %v", err)
+	}
+
+	ctx := context.Background()
+	report, err := src.CopyChanges(ctx, dst, 0, true)
+	if err != nil {
+		t.Fatalf("failed to copy changes: %v", err)
+	}
+	if report.Copied != 3 || report.Deleted != 0 || report.Watermark != src.LastSequence() {
+		t.Fatalf("unexpected full copy report: %+v", report)
+	}
+
+	// 只复制水位线之后的修改，源实例中删除的 Key 同样从目标实例中删除
+	err = src.AddSegment(InodeNum("key-01"), *testSegment("key-01", "updated"), 0)
+	if err != nil {
+		t.Fatalf("failed to update segment: %v", err)
+	}
+	err = src.DelSegment("key-02")
+	if err != nil {
+		t.Fatalf("failed to delete segment: %v", err)
+	}
+	report, err = src.CopyChanges(ctx, dst, report.Watermark, true)
+	if err != nil {
+		t.Fatalf("failed to copy changes: %v", err)
+	}
+	if report.Copied != 1 || report.Deleted != 1 {
+		t.Fatalf("unexpected incremental copy report: %+v", report)
+	}
+
+	// 重新打开目标实例，批量写入的记录在恢复之后依然存在
+	mustCloseFS(t, dst)
+	os.Remove(filepath.Join(path, indexFileName))
+	dst, err = OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to reopen target fs: %v", err)
+	}
+	defer mustCloseFS(t, dst)
+
+	for key, value := range map[string]string{"key-01": "updated", "key-03": "batched"} {
+		seg, err := dst.FetchSegment(InodeNum(key))
+		if err != nil || string(seg.Value) != value {
+			t.Errorf("expected %s=%s in target, got %v %v", key, value, seg, err)
+		}
+	}
+	if _, err := dst.FetchSegment(InodeNum("key-02")); !errors.Is(err, ErrSegmentNotFound) {
+		t.Errorf("expected key-02 deleted in target, got: %v", err)
+	}
+}
+
+func TestCopyChangesConcurrentWriters(t *testing.T) {
+	src, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open source fs: %v", err)
+	}
+	defer mustCloseFS(t, src)
+	dst, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open target fs: %v", err)
+	}
+	defer mustCloseFS(t, dst)
+
+	// 多个写入者同时写入不同分片，复制和写入交替进行，每次都从上一次的水位线继续
+	const writers, writes = 8, 200
+	var (
+		wg      sync.WaitGroup
+		stopped atomic.Bool
+	)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				key := fmt.Sprintf("key-%d-%d", w, i)
+				if err := src.AddSegment(InodeNum(key), *testSegment(key, key), 0); err != nil {
+					t.Errorf("failed to add segment: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+	go func() {
+		wg.Wait()
+		stopped.Store(true)
+	}()
+
+	ctx := context.Background()
+	var watermark uint64
+	for done := false; !done; {
+		done = stopped.Load()
+		report, err := src.CopyChanges(ctx, dst, watermark, false)
+		if err != nil {
+			t.Fatalf("failed to copy changes: %v", err)
+		}
+		if report.Watermark < watermark {
+			t.Fatalf("watermark moved backwards from %d to %d", watermark, report.Watermark)
+		}
+		watermark = report.Watermark
+	}
+
+	for w := 0; w < writers; w++ {
+		for i := 0; i < writes; i++ {
+			key := fmt.Sprintf("key-%d-%d", w, i)
+			if _, err := dst.FetchSegment(InodeNum(key)); err != nil {
+				t.Fatalf("expected %s to be copied: %v", key, err)
+			}
+		}
+	}
+}
+
+func TestChangedKeys(t *testing.T) {
+	fss, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, fss)
+
+	for _, key := range []string{"key-01", "key-02"} {
+		if err := fss.AddSegment(InodeNum(key), *testSegment(key, "value"), 0); err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+	ctx := context.Background()
+	keys, watermark, err := fss.ChangedKeys(ctx, 0)
+	if err != nil || len(keys) != 2 || watermark != fss.LastSequence() {
+		t.Fatalf("expected 2 keys up to %d, got %v %d %v", fss.LastSequence(), keys, watermark, err)
+	}
+
+	// 只返回水位线之后修改的 Key，没有修改时水位线不变
+	if err := fss.AddSegment(InodeNum("key-02"), *testSegment("key-02", "updated"), 0); err != nil {
+		t.Fatalf("failed to update segment: %v", err)
+	}
+	keys, next, err := fss.ChangedKeys(ctx, watermark)
+	if err != nil || len(keys) != 1 || keys[0] != "key-02" || next <= watermark {
+		t.Fatalf("expected key-02 after %d, got %v %d %v", watermark, keys, next, err)
+	}
+	keys, again, err := fss.ChangedKeys(ctx, next)
+	if err != nil || len(keys) != 0 || again != next {
+		t.Errorf("expected no changes after %d, got %v %d %v", next, keys, again, err)
+	}
+}
@@ -0,0 +1,62 @@
+package vfs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DebugRecord 是某个数据文件指定位置上的原始记录，用来排查内存索引和数据文件不一致的问题
+type DebugRecord struct {
+	RegionID uint64
+	Offset   int64
+	Length   int64    // 记录在磁盘上占用的字节数
+	Format   uint8    // 数据文件的格式版本
+	Checksum Checksum // 数据文件的校验码算法
+	Inum     uint64
+	Indexed  bool     // 内存索引是否指向这条记录，false 说明它是垃圾数据或者偏移量不对
+	Segment  *Segment // Value 保持压缩和加密之后的状态
+}
+
+// DebugRecordAt 解码 regionID 数据文件中 offset 处的记录，offset 不是记录的起始位置时会返回解码错误
+func (lfs *LogStructuredFS) DebugRecordAt(regionID uint64, offset int64) (*DebugRecord, error) {
+	fd, version, checksum, ok := lfs.regionFile(regionID)
+	if !ok {
+		return nil, fmt.Errorf("region file not found for region id: %d", regionID)
+	}
+
+	if offset < int64(len(dataFileMetadata)) {
+		return nil, fmt.Errorf("offset %d is inside the data file metadata", offset)
+	}
+
+	inum, seg, length, err := readRawSegment(fd, offset, version, checksum)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode record at region %d offset %d: %w", regionID, offset, err)
+	}
+
+	record := &DebugRecord{
+		RegionID: regionID,
+		Offset:   offset,
+		Length:   length,
+		Format:   version,
+		Checksum: checksum,
+		Inum:     inum,
+		Segment:  seg,
+	}
+	if inode, ok := lfs.GetINode(inum); ok {
+		record.Indexed = inode.RegionID == regionID && inode.Position == offset
+	}
+
+	return record, nil
+}
+
+// String 返回适合直接打印的多行文本，记录本身的内容通过 Segment.Dump 输出
+func (r *DebugRecord) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "region:     %d (format v%d, %s)\n", r.RegionID, r.Format, r.Checksum)
+	fmt.Fprintf(&b, "offset:     %d\n", r.Offset)
+	fmt.Fprintf(&b, "length:     %d\n", r.Length)
+	fmt.Fprintf(&b, "inum:       %d\n", r.Inum)
+	fmt.Fprintf(&b, "indexed:    %t\n", r.Indexed)
+	_ = r.Segment.Dump(&b)
+	return b.String()
+}
@@ -0,0 +1,53 @@
+package vfs
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDebugRecordAt(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	inum := InodeNum("key")
+	for _, value := range []string{"old", "new"} {
+		err = lfs.AddSegment(inum, *testSegment("key", value), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+
+	inode, _ := lfs.GetINode(inum)
+	first := int64(len(dataFileMetadata))
+
+	// 被覆盖的旧记录仍然可以读到，但是内存索引已经不指向它了
+	stale, err := lfs.DebugRecordAt(inode.RegionID, first)
+	if err != nil {
+		t.Fatalf("failed to debug stale record: %v", err)
+	}
+	if stale.Indexed || string(stale.Segment.Value) != "old" {
+		t.Errorf("expected unindexed old record, got indexed %t value %s", stale.Indexed, stale.Segment.Value)
+	}
+
+	live, err := lfs.DebugRecordAt(inode.RegionID, inode.Position)
+	if err != nil {
+		t.Fatalf("failed to debug live record: %v", err)
+	}
+	if !live.Indexed || live.Inum != inum || live.Length != int64(inode.Length) {
+		t.Errorf("expected indexed record for inum %d, got %+v", inum, live)
+	}
+	if !strings.Contains(live.String(), "indexed:    true") {
+		t.Errorf("expected key in debug output, got:\n%s", live)
+	}
+
+	// 不是记录起始位置的偏移量无法解码
+	if _, err := lfs.DebugRecordAt(inode.RegionID, first+1); err == nil {
+		t.Error("expected error for misaligned offset")
+	}
+	if _, err := lfs.DebugRecordAt(999, first); err == nil {
+		t.Error("expected error for missing region")
+	}
+}
@@ -0,0 +1,101 @@
+package vfs
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// dumpLimit 是 Dump 输出 Key 和 Value 十六进制内容的最大字节数，超过的部分只输出长度
+const dumpLimit = 256
+
+// 标志位的名称，按照位从低到高排列
+var flagNames = []struct {
+	flag uint8
+	name string
+}{
+	{flagBatch, "batch"},
+	{flagBatchCommit, "batch-commit"},
+	{flagRangeTombstone, "range-tombstone"},
+	{flagEncrypted, "encrypted"},
+	{flagCompressed, "compressed"},
+	{flagTransform, "transform"},
+	{flagCodec, "codec"},
+}
+
+// flagString 返回标志位的名称列表，没有名称的位按照 bitN 输出
+func flagString(flags uint8) string {
+	if flags == 0 {
+		return "none"
+	}
+
+	var names []string
+	for _, f := range flagNames {
+		if flags&f.flag != 0 {
+			names = append(names, f.name)
+			flags &^= f.flag
+		}
+	}
+	for bit := 0; bit < 8; bit++ {
+		if flags&(1<<bit) != 0 {
+			names = append(names, fmt.Sprintf("bit%d", bit))
+		}
+	}
+
+	return strings.Join(names, "|")
+}
+
+// String 返回一行记录摘要，不包含 Value 的内容
+func (s *Segment) String() string {
+	return fmt.Sprintf("Segment{key: %q, type: %s, tombstone: %t, flags: %s, version: %d, ttl: %d, value: %d bytes}",
+		s.Key, s.Type, s.IsTombstone(), flagString(s.Flags), s.Version, s.TTL(), s.ValueSize)
+}
+
+// Dump 输出记录头部的所有字段、标志位、剩余的过期时间以及 Key 和 Value 的十六进制内容
+// Key 和 Value 各自最多输出 dumpLimit 字节，适合命令行工具和应用自己的诊断日志使用
+func (s *Segment) Dump(w io.Writer) error {
+	ttl := "never"
+	if s.ExpiredAt > 0 {
+		ttl = "expired"
+		if remaining := s.TTL(); remaining > 0 {
+			ttl = fmt.Sprintf("%ds", remaining)
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "tombstone:  %d\ntype:       %s (%d)\nflags:      %08b (%s)\nuserflags:  %08b\nversion:    %d\ncreated:    %d\nexpired:    %d (ttl: %s)\nkey size:   %d\nvalue size: %d\n",
+		s.Tombstone, s.Type, s.Type, s.Flags, flagString(s.Flags), s.UserFlags, s.Version, s.CreatedAt, s.ExpiredAt, ttl, s.KeySize, s.ValueSize)
+	if err != nil {
+		return err
+	}
+
+	err = dumpBytes(w, "key", s.Key)
+	if err != nil {
+		return err
+	}
+
+	return dumpBytes(w, "value", s.Value)
+}
+
+func dumpBytes(w io.Writer, name string, data []byte) error {
+	_, err := fmt.Fprintf(w, "%s:\n", name)
+	if err != nil {
+		return err
+	}
+
+	shown := data
+	if len(shown) > dumpLimit {
+		shown = shown[:dumpLimit]
+	}
+
+	_, err = io.WriteString(w, hex.Dump(shown))
+	if err != nil {
+		return err
+	}
+
+	if more := len(data) - len(shown); more > 0 {
+		_, err = fmt.Fprintf(w, "... %d more bytes\n", more)
+	}
+
+	return err
+}
@@ -0,0 +1,37 @@
+package vfs
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSegmentDump(t *testing.T) {
+	seg := testSegment("key", strings.Repeat("v", dumpLimit+10))
+	seg.Flags = flagCompressed | flagTransform | 0x80
+	seg.ExpiredAt = uint64(time.Now().Add(time.Hour).Unix())
+
+	var buf bytes.Buffer
+	err := seg.Dump(&buf)
+	if err != nil {
+		t.Fatalf("failed to dump segment: %v", err)
+	}
+
+	out := buf.String()
+	for _, expected := range []string{
+		"type:       binary",
+		"(compressed|transform|bit7)",
+		"ttl: 3",
+		"6b 65 79", // key 的十六进制
+		"... 10 more bytes",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in dump, got:\n%s", expected, out)
+		}
+	}
+
+	if s := seg.String(); !strings.Contains(s, `key: "key"`) || strings.Contains(s, "vvvv") {
+		t.Errorf("expected one line summary without value, got %s", s)
+	}
+}
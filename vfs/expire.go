@@ -0,0 +1,195 @@
+package vfs
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"github.com/auula/wiredkv/clog"
+)
+
+// expireEntry 记录一个 Key 的过期时间，Key 被更新之后旧的条目不会被删除
+// 弹出时和内存索引中的过期时间比较，不一致就说明已经失效了直接丢弃
+type expireEntry struct {
+	inum      uint64
+	expiredAt uint64
+}
+
+// expireHeap 是按过期时间排序的小顶堆
+type expireHeap []expireEntry
+
+func (h expireHeap) Len() int           { return len(h) }
+func (h expireHeap) Less(i, j int) bool { return h[i].expiredAt < h[j].expiredAt }
+func (h expireHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *expireHeap) Push(x interface{}) {
+	*h = append(*h, x.(expireEntry))
+}
+
+func (h *expireHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	*h = old[:n-1]
+	return entry
+}
+
+// expireQueue 在写入时维护即将过期的 Key，后台 goroutine 在最早的过期时间到达时删除它们
+// 不需要像抽样过期那样反复扫描整个索引，Key 的数量再多也能在过期之后很快被删除
+type expireQueue struct {
+	mu      sync.Mutex
+	entries expireHeap
+	wake    chan struct{}
+	done    chan struct{}
+	exit    chan struct{}
+}
+
+func newExpireQueue() *expireQueue {
+	return &expireQueue{wake: make(chan struct{}, 1)}
+}
+
+// memory 返回过期队列占用的字节数，每个条目 16 字节
+func (q *expireQueue) memory() int64 {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return int64(cap(q.entries)) * 16
+}
+
+// push 加入一个带有过期时间的 Key，比当前最早的过期时间更早时唤醒后台 goroutine 重新计时
+func (q *expireQueue) push(inum, expiredAt uint64) {
+	if expiredAt == 0 {
+		return
+	}
+
+	q.mu.Lock()
+	heap.Push(&q.entries, expireEntry{inum: inum, expiredAt: expiredAt})
+	earliest := q.entries[0].expiredAt == expiredAt
+	q.mu.Unlock()
+
+	if earliest {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// popExpired 弹出所有在 now 之前过期的条目
+func (q *expireQueue) popExpired(now uint64) []expireEntry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var expired []expireEntry
+	for len(q.entries) > 0 && q.entries[0].expiredAt <= now {
+		expired = append(expired, heap.Pop(&q.entries).(expireEntry))
+	}
+	return expired
+}
+
+// next 返回最早的过期时间，没有等待过期的 Key 时返回 false
+func (q *expireQueue) next() (uint64, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.entries) == 0 {
+		return 0, false
+	}
+	return q.entries[0].expiredAt, true
+}
+
+// expireKeys 删除所有已经过期的 Key，返回删除的数量
+func (lfs *LogStructuredFS) expireKeys() int {
+	var expired int
+	for _, entry := range lfs.expiry.popExpired(uint64(time.Now().Unix())) {
+		ok, err := lfs.expireKey(entry)
+		if err != nil {
+			clog.Warnf("failed to expire key (inum: %d): %v", entry.inum, err)
+			continue
+		}
+		if ok {
+			expired++
+		}
+	}
+	return expired
+}
+
+// expireKey 为过期的 Key 写入一条删除记录，否则崩溃恢复时更旧的数据文件中同一个 Key 的旧版本会复活
+// 持有分片锁写入删除记录，检查过期之后 Key 不会被其他写入操作修改
+func (lfs *LogStructuredFS) expireKey(entry expireEntry) (bool, error) {
+	shard := lfs.indexs[entry.inum%uint64(indexShard)]
+	lfs.appendMu.RLock()
+	defer lfs.appendMu.RUnlock()
+	shard.mu.Lock()
+	inode, ok := shard.get(entry.inum)
+	if !ok || inode.ExpiredAt != entry.expiredAt {
+		shard.mu.Unlock()
+		return false, nil
+	}
+
+	tombstone := NewTombstoneSegment([]byte(inode.Key))
+	tinode, err := lfs.appendSegment(tombstone)
+	if err != nil {
+		shard.mu.Unlock()
+		return false, err
+	}
+	shard.remove(entry.inum)
+	shard.mu.Unlock()
+
+	lfs.indexBytes.Add(-inodeMemory(len(inode.Key)))
+	lfs.markDead(inode)
+	lfs.markDead(tinode)
+	lfs.cache.remove(entry.inum)
+
+	return true, lfs.audit.record("", AuditExpire, inode.Key, "")
+}
+
+// startExpireDaemon 启动按照最早过期时间计时的后台 goroutine
+func (lfs *LogStructuredFS) startExpireDaemon() {
+	q := lfs.expiry
+	q.done = make(chan struct{})
+	q.exit = make(chan struct{})
+	go func() {
+		defer close(q.exit)
+		timer := time.NewTimer(time.Hour)
+		defer timer.Stop()
+		for {
+			// 过期时间的精度是秒，在下一秒开始时删除
+			wait := time.Hour
+			if expiredAt, ok := q.next(); ok {
+				wait = time.Until(time.Unix(int64(expiredAt)+1, 0))
+				if wait < 0 {
+					wait = 0
+				}
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(wait)
+
+			select {
+			case <-timer.C:
+				lfs.expireKeys()
+			case <-q.wake:
+			case <-q.done:
+				return
+			}
+		}
+	}()
+}
+
+// stopExpireDaemon 停止后台删除过期 Key 的 goroutine 并且等待它退出
+func (lfs *LogStructuredFS) stopExpireDaemon() {
+	q := lfs.expiry
+	if q == nil || q.done == nil {
+		return
+	}
+	close(q.done)
+	<-q.exit
+	q.done = nil
+}
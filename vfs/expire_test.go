@@ -0,0 +1,85 @@
+package vfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExpireKeys(t *testing.T) {
+	path := t.TempDir()
+	lfs, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+
+	inum := InodeNum("key")
+	err = lfs.AddSegment(inum, *testSegment("key", "v1"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+	err = lfs.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+
+	// 已经过期的记录写入之后会立即唤醒后台 goroutine
+	seg := testSegment("key", "v2")
+	seg.ExpiredAt = uint64(time.Now().Unix()) - 1
+	err = lfs.AddSegment(inum, *seg, 0)
+	if err != nil {
+		t.Fatalf("failed to add expiring segment: %v", err)
+	}
+	if _, err := lfs.FetchSegment(inum); !errors.Is(err, ErrSegmentNotFound) {
+		t.Errorf("expected expired segment to be invisible, got: %v", err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if _, ok := lfs.GetINode(inum); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected expired key to be removed from index")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 更新之后旧的过期时间不再生效
+	future := testSegment("other", "value")
+	future.ExpiredAt = uint64(time.Now().Unix()) + 3600
+	err = lfs.AddSegment(InodeNum("other"), *future, 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+	err = lfs.AddSegment(InodeNum("other"), *testSegment("other", "value"), 0)
+	if err != nil {
+		t.Fatalf("failed to update segment: %v", err)
+	}
+	ok, err := lfs.expireKey(expireEntry{inum: InodeNum("other"), expiredAt: future.ExpiredAt})
+	if err != nil || ok {
+		t.Errorf("expected stale expire entry to be ignored, got %t: %v", ok, err)
+	}
+
+	mustCloseFS(t, lfs)
+
+	// 删除索引快照强制全量扫描，删除记录保证旧版本不会复活
+	err = os.Remove(filepath.Join(path, indexFileName))
+	if err != nil {
+		t.Fatalf("failed to remove index snapshot: %v", err)
+	}
+	lfs, err = OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to reopen fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	if _, ok := lfs.GetINode(inum); ok {
+		t.Errorf("expected expired key to stay deleted after recovery")
+	}
+	if _, ok := lfs.GetINode(InodeNum("other")); !ok {
+		t.Errorf("expected updated key to survive recovery")
+	}
+}
@@ -0,0 +1,173 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/auula/wiredkv/clog"
+)
+
+// 没有指定周期时 Follower 读取活跃数据文件的周期
+const defaultFollowInterval = 100 * time.Millisecond
+
+// tailState 记录只读实例在写入进程的活跃数据文件中已经重放到的位置
+type tailState struct {
+	fd       *os.File
+	version  uint8
+	checksum Checksum
+	offset   int64
+	replay   *segmentReplay
+}
+
+// Follower 周期性地读取写入进程追加到活跃数据文件中的记录，并且更新只读实例的内存索引
+// 只读实例默认只能看到已经封存的数据文件，开启 Follower 之后延迟只有一个读取周期
+type Follower struct {
+	lfs  *LogStructuredFS
+	done chan struct{}
+	exit chan struct{}
+}
+
+// Follow 开始跟随写入进程的活跃数据文件，interval 为读取周期，0 使用默认的 100 毫秒
+// 写入进程还没有写完整的记录会在下一个周期重新读取，一个只读实例同时只能有一个 Follower
+func (lfs *LogStructuredFS) Follow(interval time.Duration) (*Follower, error) {
+	if !lfs.readOnly {
+		return nil, errors.New("failed to follow active region: not a read-only instance")
+	}
+	if interval <= 0 {
+		interval = defaultFollowInterval
+	}
+
+	f := &Follower{lfs: lfs, done: make(chan struct{}), exit: make(chan struct{})}
+	lfs.refreshMu.Lock()
+	if lfs.follower != nil {
+		lfs.refreshMu.Unlock()
+		return nil, errors.New("failed to follow active region: already following")
+	}
+	lfs.follower = f
+	lfs.refreshMu.Unlock()
+
+	err := lfs.Refresh()
+	if err != nil {
+		f.stopFollowing()
+		return nil, err
+	}
+
+	go func() {
+		defer close(f.exit)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := lfs.Refresh()
+				if err != nil && !errors.Is(err, ErrClosed) {
+					clog.Errorf("failed to follow active region: %v", err)
+				}
+			case <-f.done:
+				return
+			}
+		}
+	}()
+
+	return f, nil
+}
+
+// Stop 停止跟随活跃数据文件，已经重放的记录保留在内存索引中，之后只在数据文件封存时刷新
+// 关闭只读实例时会自动停止
+func (f *Follower) Stop() {
+	select {
+	case <-f.done:
+		return
+	default:
+	}
+	close(f.done)
+	<-f.exit
+	f.stopFollowing()
+}
+
+func (f *Follower) stopFollowing() {
+	f.lfs.refreshMu.Lock()
+	if f.lfs.follower == f {
+		f.lfs.follower = nil
+	}
+	f.lfs.refreshMu.Unlock()
+}
+
+// followActive 从上一次的位置继续重放活跃数据文件，数据文件切换之后从新的活跃数据文件开头重放
+// 调用方需要持有 refreshMu，比 active 旧的数据文件都已经加载
+func (lfs *LogStructuredFS) followActive(active uint64, r replayer) error {
+	if lfs.tail == nil || lfs.tail.replay.regionID != active {
+		fd, err := os.Open(filepath.Join(lfs.directory, formatDataFileName(active)))
+		if err != nil {
+			return err
+		}
+
+		// 写入进程刚刚创建的数据文件可能还没有写入文件头
+		version, checksum, err := readFileVersion(fd)
+		if err != nil {
+			_ = fd.Close()
+			return nil
+		}
+
+		lfs.mu.Lock()
+		lfs.regions[active] = fd
+		lfs.versions[active] = version
+		lfs.checksums[active] = checksum
+		lfs.mu.Unlock()
+
+		lfs.tail = &tailState{
+			fd:       fd,
+			version:  version,
+			checksum: checksum,
+			offset:   int64(len(dataFileMetadata)),
+			replay:   &segmentReplay{regionID: active, r: r},
+		}
+	}
+
+	return lfs.tailRegion(lfs.tail, false)
+}
+
+// finishTail 数据文件封存之后重放剩下的记录，然后把它作为已经加载的数据文件
+func (lfs *LogStructuredFS) finishTail() error {
+	err := lfs.tailRegion(lfs.tail, true)
+	if err != nil {
+		return err
+	}
+
+	lfs.mu.Lock()
+	lfs.regionID = lfs.tail.replay.regionID
+	lfs.mu.Unlock()
+	lfs.tail = nil
+
+	return nil
+}
+
+// tailRegion 重放 t.offset 之后所有完整的记录，sealed 为 true 时数据文件末尾不能有不完整的记录
+func (lfs *LogStructuredFS) tailRegion(t *tailState, sealed bool) error {
+	finfo, err := t.fd.Stat()
+	if err != nil {
+		return err
+	}
+
+	for t.offset < finfo.Size() {
+		inum, segment, length, err := readRawSegment(t.fd, t.offset, t.version, t.checksum)
+		if err != nil {
+			if sealed {
+				return fmt.Errorf("failed to parse data file segment: %w", err)
+			}
+			// 写入进程还没有写完整的记录，下一个周期重新读取
+			break
+		}
+		t.replay.apply(inum, segment, t.offset, length)
+		t.offset += length
+	}
+
+	if t.replay.sequence > lfs.sequence.Load() {
+		lfs.sequence.Store(t.replay.sequence)
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package vfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFollower(t *testing.T) {
+	path := t.TempDir()
+	writer, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, writer)
+
+	put := func(key, value string) {
+		t.Helper()
+		err := writer.AddSegment(InodeNum(key), *testSegment(key, value), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+	visible := func(reader *LogStructuredFS, key string) bool {
+		t.Helper()
+		_, err := reader.FetchSegment(InodeNum(key))
+		if err != nil && !errors.Is(err, ErrSegmentNotFound) {
+			t.Fatalf("failed to fetch %s: %v", key, err)
+		}
+		return err == nil
+	}
+
+	put("key-01", "value-01")
+
+	reader, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("failed to open read-only fs: %v", err)
+	}
+	defer mustCloseFS(t, reader)
+
+	if visible(reader, "key-01") {
+		t.Errorf("expected active region to be invisible without follower")
+	}
+
+	follower, err := reader.Follow(0)
+	if err != nil {
+		t.Fatalf("failed to follow: %v", err)
+	}
+	if _, err := reader.Follow(0); err == nil {
+		t.Errorf("expected second follower to fail")
+	}
+	if !visible(reader, "key-01") {
+		t.Errorf("expected key-01 after follow")
+	}
+
+	put("key-02", "value-02")
+	err = writer.DelSegment("key-01")
+	if err != nil {
+		t.Fatalf("failed to delete segment: %v", err)
+	}
+	err = writer.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+	put("key-03", "value-03")
+
+	err = reader.Refresh()
+	if err != nil {
+		t.Fatalf("failed to refresh: %v", err)
+	}
+	if visible(reader, "key-01") || !visible(reader, "key-02") || !visible(reader, "key-03") {
+		t.Errorf("expected key-02 and key-03 after switching active region")
+	}
+	if reader.LastSequence() != writer.LastSequence() {
+		t.Errorf("expected sequence %d, got %d", writer.LastSequence(), reader.LastSequence())
+	}
+
+	follower.Stop()
+	put("key-04", "value-04")
+	err = reader.Refresh()
+	if err != nil {
+		t.Fatalf("failed to refresh: %v", err)
+	}
+	if visible(reader, "key-04") {
+		t.Errorf("expected key-04 to be invisible after follower stopped")
+	}
+}
+
+func TestTailIncompleteRecord(t *testing.T) {
+	name := filepath.Join(t.TempDir(), formatDataFileName(1))
+	fd, err := os.OpenFile(name, RWCA, fsPerm)
+	if err != nil {
+		t.Fatalf("failed to create region: %v", err)
+	}
+	defer fd.Close()
+
+	first, err := encodeSegment(testSegment("key-01", "value-01"), currentFormat, ChecksumCRC32)
+	if err != nil {
+		t.Fatalf("failed to encode segment: %v", err)
+	}
+	second, err := encodeSegment(testSegment("key-02", "value-02"), currentFormat, ChecksumCRC32)
+	if err != nil {
+		t.Fatalf("failed to encode segment: %v", err)
+	}
+
+	data := append(fileMetadata(currentFormat, ChecksumCRC32), first...)
+	data = append(data, second[:10]...)
+	if _, err := fd.Write(data); err != nil {
+		t.Fatalf("failed to write region: %v", err)
+	}
+
+	indexs := []*indexMap{{index: make(map[uint64]*INode)}}
+	shards := indexShard
+	indexShard = 1
+	defer func() { indexShard = shards }()
+
+	lfs := new(LogStructuredFS)
+	tail := &tailState{
+		fd:       fd,
+		version:  currentFormat,
+		checksum: ChecksumCRC32,
+		offset:   int64(len(dataFileMetadata)),
+		replay:   &segmentReplay{regionID: 1, r: &indexReplayer{indexs: indexs}},
+	}
+
+	err = lfs.tailRegion(tail, false)
+	if err != nil {
+		t.Fatalf("failed to tail region: %v", err)
+	}
+	if len(indexs[0].index) != 1 || tail.offset != int64(len(dataFileMetadata)+len(first)) {
+		t.Errorf("expected to stop before incomplete record, got %d entries at offset %d", len(indexs[0].index), tail.offset)
+	}
+	if err := lfs.tailRegion(tail, true); err == nil {
+		t.Errorf("expected error for incomplete record in sealed region")
+	}
+
+	if _, err := fd.Write(second[10:]); err != nil {
+		t.Fatalf("failed to write region: %v", err)
+	}
+	err = lfs.tailRegion(tail, false)
+	if err != nil {
+		t.Fatalf("failed to tail region: %v", err)
+	}
+	if len(indexs[0].index) != 2 {
+		t.Errorf("expected 2 entries after record completed, got %d", len(indexs[0].index))
+	}
+}
@@ -0,0 +1,300 @@
+package vfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+)
+
+// 数据文件的格式版本号，保存在数据文件头 dataFileMetadata 的最后一个字节
+// 数据文件头为 | SIGN 1 | CHECKSUM 1 | RESERVED 1 | VERSION 1 |，CHECKSUM 是记录校验码使用的算法
+const (
+	// FormatV1 | DEL 1 | KIND 1 | EAT 8 | CAT 8 | KLEN 4 | VLEN 4 | KEY ? | VALUE ? | CRC32 4 |
+	FormatV1 uint8 = 1
+	// FormatV2 | DEL 1 | KIND 1 | FLAG 1 | EAT 8 | CAT 8 | KLEN 4 | VLEN 4 | KEY ? | VALUE ? | CRC32 4 |
+	FormatV2 uint8 = 2
+	// FormatV3 | DEL 1 | KIND 1 | FLAG 1 | UFLAG 1 | EAT 8 | CAT 8 | KLEN 4 | VLEN 4 | KEY ? | VALUE ? | CRC32 4 |
+	FormatV3 uint8 = 3
+	// FormatV4 | DEL 1 | KIND 1 | FLAG 1 | UFLAG 1 | VER 8 | EAT 8 | CAT 8 | KLEN 4 | VLEN 4 | KEY ? | VALUE ? | CRC32 4 |
+	FormatV4 uint8 = 4
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported data file format version")
+	ErrCorruptedSegment  = errors.New("corrupted segment")
+)
+
+// Key 和 Value 的总长度超过 checkedBodySize 时才检查剩余的文件大小
+// 正常大小的记录不需要每次读取都调用 Stat，损坏的长度字段也不会导致分配过大的内存
+const checkedBodySize = int64(1 * MB)
+
+// segmentHeaderSize 返回不同格式版本 Segment 记录头部的大小
+func segmentHeaderSize(version uint8) (int, error) {
+	switch version {
+	case FormatV1:
+		return 26, nil
+	case FormatV2:
+		return 27, nil
+	case FormatV3:
+		return 28, nil
+	case FormatV4:
+		return 36, nil
+	default:
+		return 0, fmt.Errorf("%w: %d", ErrUnsupportedFormat, version)
+	}
+}
+
+// fileMetadata 返回对应格式版本和校验码算法的数据文件头
+func fileMetadata(version uint8, checksum Checksum) []byte {
+	metadata := make([]byte, len(dataFileMetadata))
+	copy(metadata, dataFileMetadata)
+	metadata[1] = byte(checksum)
+	metadata[len(metadata)-1] = version
+	return metadata
+}
+
+// parseFileMetadata 校验数据文件头并返回数据文件的格式版本和校验码算法
+func parseFileMetadata(metadata []byte) (uint8, Checksum, error) {
+	if len(metadata) != len(dataFileMetadata) {
+		return 0, 0, errors.New("file is too short to contain valid signature")
+	}
+
+	if metadata[0] != dataFileMetadata[0] || metadata[2] != dataFileMetadata[2] {
+		return 0, 0, errors.New("invalid data file signature")
+	}
+
+	checksum := Checksum(metadata[1])
+	if err := checksum.validate(); err != nil {
+		return 0, 0, err
+	}
+
+	version := metadata[len(metadata)-1]
+	if _, err := segmentHeaderSize(version); err != nil {
+		return 0, 0, err
+	}
+
+	return version, checksum, nil
+}
+
+// encodeSegment 按照指定的格式版本和校验码算法序列化 Segment 记录
+func encodeSegment(seg *Segment, version uint8, checksum Checksum) ([]byte, error) {
+	return appendEncodedSegment(nil, seg, version, checksum)
+}
+
+// appendEncodedSegment 把序列化之后的记录追加到 dst 之后，dst 的容量足够时不需要分配内存
+func appendEncodedSegment(dst []byte, seg *Segment, version uint8, checksum Checksum) ([]byte, error) {
+	hsize, err := segmentHeaderSize(version)
+	if err != nil {
+		return nil, err
+	}
+
+	if version == FormatV1 && seg.Flags != 0 {
+		return nil, fmt.Errorf("segment flags %08b cannot be stored in format version %d", seg.Flags, version)
+	}
+
+	if version < FormatV3 && seg.UserFlags != 0 {
+		return nil, fmt.Errorf("segment user flags %08b cannot be stored in format version %d", seg.UserFlags, version)
+	}
+
+	start := len(dst)
+	size := hsize + len(seg.Key) + len(seg.Value) + 4
+	if cap(dst)-start < size {
+		grown := make([]byte, start, start+size)
+		copy(grown, dst)
+		dst = grown
+	}
+	dst = dst[:start+size]
+	buf := dst[start:]
+	pos := 0
+
+	buf[pos] = byte(seg.Tombstone)
+	pos++
+	buf[pos] = byte(seg.Type)
+	pos++
+
+	if version >= FormatV2 {
+		buf[pos] = seg.Flags
+		pos++
+	}
+
+	if version >= FormatV3 {
+		buf[pos] = seg.UserFlags
+		pos++
+	}
+
+	// 版本号是存储引擎分配的元数据，迁移到旧格式版本时直接丢弃
+	if version >= FormatV4 {
+		binary.LittleEndian.PutUint64(buf[pos:], seg.Version)
+		pos += 8
+	}
+
+	binary.LittleEndian.PutUint64(buf[pos:], seg.ExpiredAt)
+	pos += 8
+	binary.LittleEndian.PutUint64(buf[pos:], seg.CreatedAt)
+	pos += 8
+	binary.LittleEndian.PutUint32(buf[pos:], seg.KeySize)
+	pos += 4
+	binary.LittleEndian.PutUint32(buf[pos:], seg.ValueSize)
+	pos += 4
+
+	pos += copy(buf[pos:], seg.Key)
+	pos += copy(buf[pos:], seg.Value)
+
+	// 校验码覆盖记录头部、Key 和 Value
+	binary.LittleEndian.PutUint32(buf[pos:], checksum.sum(buf[:pos]))
+
+	return dst, nil
+}
+
+// decodeSegment 按照指定的格式版本和校验码算法从 offset 处读取一条原始的 Segment 记录
+// 返回的 Value 保持 transformer 编码之后的状态，第二个返回值为记录在磁盘上的长度
+func decodeSegment(fd io.ReaderAt, offset int64, version uint8, checksum Checksum) (*Segment, int64, error) {
+	hsize, err := segmentHeaderSize(version)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// 记录头部解析之后就不再需要了，使用池中的缓冲区
+	pooled := getBuffer(hsize)
+	defer putBuffer(pooled)
+	header := *pooled
+	_, err = fd.ReadAt(header, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	seg, err := parseSegmentHeader(header, version)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// 32 位平台上 int 只有 4 个字节，超过 math.MaxInt 的长度不能分配切片
+	bsize64 := int64(seg.KeySize) + int64(seg.ValueSize) + 4
+	if bsize64 > math.MaxInt {
+		return nil, 0, fmt.Errorf("segment size %d exceeds platform limit", bsize64)
+	}
+
+	// 记录的长度不能超过文件剩余的字节数，否则一定是长度字段损坏了
+	if bsize64 > checkedBodySize {
+		if size, ok := readerSize(fd); ok && bsize64 > size-offset-int64(hsize) {
+			return nil, 0, fmt.Errorf("%w: segment size %d exceeds remaining file size at offset %d", ErrCorruptedSegment, bsize64, offset)
+		}
+	}
+
+	body := make([]byte, bsize64)
+	_, err = fd.ReadAt(body, offset+int64(hsize))
+	if errors.Is(err, io.EOF) {
+		// 长度字段被修改或者记录没有完整写入，都说明这条记录已经损坏
+		return nil, 0, fmt.Errorf("%w: segment body truncated at offset %d: %v", ErrCorruptedSegment, offset, err)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read segment body: %w", err)
+	}
+
+	bsize := len(body) - 4
+	expected := binary.LittleEndian.Uint32(body[bsize:])
+
+	// 校验码覆盖整个记录头部，删除标记、类型、标志位、过期时间和长度字段被修改都能发现
+	h := checksum.new()
+	h.Write(header)
+	h.Write(body[:bsize])
+	if expected != h.Sum32() {
+		return nil, 0, fmt.Errorf("%w: failed to %s checksum mismatch: %d", ErrCorruptedSegment, checksum, expected)
+	}
+
+	seg.Key = body[:seg.KeySize]
+	seg.Value = body[seg.KeySize:bsize]
+
+	return seg, int64(hsize) + int64(len(body)), nil
+}
+
+// parseSegmentHeader 解析并校验记录头部，返回的 Segment 还没有 Key 和 Value
+func parseSegmentHeader(header []byte, version uint8) (*Segment, error) {
+	var seg Segment
+	err := parseSegmentHeaderInto(header, version, &seg)
+	if err != nil {
+		return nil, err
+	}
+	return &seg, nil
+}
+
+// parseSegmentHeaderInto 和 parseSegmentHeader 一样，但是解析到调用方提供的 seg 中
+func parseSegmentHeaderInto(header []byte, version uint8, seg *Segment) error {
+	pos := 0
+
+	seg.Tombstone = int8(header[pos])
+	pos++
+	seg.Type = Kind(header[pos])
+	pos++
+
+	if version >= FormatV2 {
+		seg.Flags = header[pos]
+		pos++
+	}
+
+	if version >= FormatV3 {
+		seg.UserFlags = header[pos]
+		pos++
+	}
+
+	if version >= FormatV4 {
+		seg.Version = binary.LittleEndian.Uint64(header[pos:])
+		pos += 8
+	}
+
+	seg.ExpiredAt = binary.LittleEndian.Uint64(header[pos:])
+	pos += 8
+	seg.CreatedAt = binary.LittleEndian.Uint64(header[pos:])
+	pos += 8
+	seg.KeySize = binary.LittleEndian.Uint32(header[pos:])
+	pos += 4
+	seg.ValueSize = binary.LittleEndian.Uint32(header[pos:])
+
+	return validateSegmentHeader(seg)
+}
+
+// validateSegmentHeader 拒绝不可能由存储引擎写出的记录头部
+func validateSegmentHeader(seg *Segment) error {
+	if seg.Tombstone != 0 && seg.Tombstone != 1 {
+		return fmt.Errorf("%w: invalid tombstone marker %d", ErrCorruptedSegment, seg.Tombstone)
+	}
+	if seg.Type < Set || seg.Type > Unknown {
+		return fmt.Errorf("%w: invalid data type %d", ErrCorruptedSegment, seg.Type)
+	}
+	// 批量写入的提交记录没有 Key 和 Value
+	if seg.Flags&flagBatchCommit != 0 && (seg.KeySize != 0 || seg.ValueSize != 0) {
+		return fmt.Errorf("%w: batch commit record with key size %d and value size %d", ErrCorruptedSegment, seg.KeySize, seg.ValueSize)
+	}
+	// 只有范围删除记录的 Value 保存了结束位置，普通的删除记录没有 Value
+	if seg.Tombstone == 1 && seg.Flags&flagRangeTombstone == 0 && seg.ValueSize != 0 {
+		return fmt.Errorf("%w: tombstone record with value size %d", ErrCorruptedSegment, seg.ValueSize)
+	}
+	// Key 的长度在读取记录之前检查，损坏的长度字段不会导致分配过大的内存
+	limit := decodeKeyLimit()
+	if int64(seg.KeySize) > limit {
+		return fmt.Errorf("%w: key size %d exceeds limit %d", ErrCorruptedSegment, seg.KeySize, limit)
+	}
+	if seg.Flags&flagRangeTombstone != 0 && int64(seg.ValueSize) > limit {
+		return fmt.Errorf("%w: range end size %d exceeds limit %d", ErrCorruptedSegment, seg.ValueSize, limit)
+	}
+	return nil
+}
+
+// readerSize 尽量获取 fd 的总字节数，不支持的 io.ReaderAt 返回 false
+func readerSize(fd io.ReaderAt) (int64, bool) {
+	switch f := fd.(type) {
+	case interface{ Size() int64 }:
+		return f.Size(), true
+	case interface{ Stat() (fs.FileInfo, error) }:
+		finfo, err := f.Stat()
+		if err != nil {
+			return 0, false
+		}
+		return finfo.Size(), true
+	default:
+		return 0, false
+	}
+}
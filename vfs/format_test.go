@@ -0,0 +1,257 @@
+package vfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/auula/wiredkv/vfs/fstest"
+)
+
+func TestRollingFormatUpgrade(t *testing.T) {
+	path := t.TempDir()
+
+	// 构造一个旧格式版本的数据文件
+	data := fileMetadata(FormatV1, ChecksumCRC32)
+	for _, key := range []string{"key-01", "key-02"} {
+		bytes, err := encodeSegment(testSegment(key, "value-v1"), FormatV1, ChecksumCRC32)
+		if err != nil {
+			t.Fatalf("failed to encode segment: %v", err)
+		}
+		data = append(data, bytes...)
+	}
+
+	err := os.WriteFile(filepath.Join(path, formatDataFileName(1)), data, fsPerm)
+	if err != nil {
+		t.Fatalf("failed to write v1 region: %v", err)
+	}
+
+	lfs, err := OpenFS(&Options{
+		Path:      path,
+		FsPerm:    fsPerm,
+		Threshold: 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to open fs with v1 region: %v", err)
+	}
+	defer lfs.CloseFS()
+
+	// 新的记录不会写入旧格式版本的数据文件
+	if lfs.regionID != 2 || lfs.versions[1] != FormatV1 || lfs.versions[2] != currentFormat {
+		t.Fatalf("expected new active region with current format, got region %d versions %v", lfs.regionID, lfs.versions)
+	}
+
+	err = lfs.AddSegment(InodeNum("key-02"), *testSegment("key-02", "value-v2"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+
+	err = lfs.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+
+	dirty := lfs.dirtyRegions(gcBatchSize)
+	if len(dirty) == 0 || dirty[0] != 1 {
+		t.Fatalf("expected v1 region to be compacted first, got %v", dirty)
+	}
+
+	err = lfs.compactRegion(1)
+	if err != nil {
+		t.Fatalf("failed to compact v1 region: %v", err)
+	}
+
+	for key, want := range map[string]string{"key-01": "value-v1", "key-02": "value-v2"} {
+		seg, err := lfs.FetchSegment(InodeNum(key))
+		if err != nil {
+			t.Fatalf("failed to fetch %s: %v", key, err)
+		}
+		if string(seg.Value) != want {
+			t.Errorf("expected %s to be %s, got %s", key, want, seg.Value)
+		}
+	}
+
+	for regionID, version := range lfs.versions {
+		if version != currentFormat {
+			t.Errorf("expected region %d to be rewritten to format %d, got %d", regionID, currentFormat, version)
+		}
+	}
+}
+
+func TestDecodeSegmentFromMemoryFile(t *testing.T) {
+	f := fstest.NewFile(formatDataFileName(1), fileMetadata(currentFormat, ChecksumCRC32))
+	// 和没有使用 O_APPEND 打开的文件一样，追加之前需要先移动到文件末尾
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		t.Fatalf("failed to seek memory file: %v", err)
+	}
+
+	var offsets []int64
+	for _, key := range []string{"key-01", "key-02"} {
+		bytes, err := encodeSegment(testSegment(key, "value"), currentFormat, ChecksumCRC32)
+		if err != nil {
+			t.Fatalf("failed to encode segment: %v", err)
+		}
+		offsets = append(offsets, f.Size())
+		_, err = f.Write(bytes)
+		if err != nil {
+			t.Fatalf("failed to write segment: %v", err)
+		}
+	}
+
+	version, _, err := readFileVersion(f)
+	if err != nil || version != currentFormat {
+		t.Fatalf("expected format version %d, got %d: %v", currentFormat, version, err)
+	}
+
+	// 从第二条记录的位置直接读取
+	seg, _, err := decodeSegment(f, offsets[1], version, ChecksumCRC32)
+	if err != nil {
+		t.Fatalf("failed to decode segment: %v", err)
+	}
+	if string(seg.Key) != "key-02" {
+		t.Errorf("expected key-02, got %s", seg.Key)
+	}
+}
+
+func TestDecodeCorruptedSegment(t *testing.T) {
+	data, err := encodeSegment(testSegment("key", "value"), currentFormat, ChecksumCRC32)
+	if err != nil {
+		t.Fatalf("failed to encode segment: %v", err)
+	}
+
+	// 把 VLEN 改成接近 4GB，没有校验时解码会分配 4GB 的内存
+	hsize, _ := segmentHeaderSize(currentFormat)
+	huge := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint32(huge[hsize-4:], math.MaxUint32)
+	_, _, err = decodeSegment(bytes.NewReader(huge), 0, currentFormat, ChecksumCRC32)
+	if !errors.Is(err, ErrCorruptedSegment) {
+		t.Errorf("expected ErrCorruptedSegment for huge value size, got: %v", err)
+	}
+
+	// KLEN 超过 Key 的最大字节数时在读取记录之前就被拒绝，不依赖文件大小
+	key := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint32(key[hsize-8:], uint32(defaultMaxKeySize+1))
+	_, err = parseSegmentHeader(key[:hsize], currentFormat)
+	if !errors.Is(err, ErrCorruptedSegment) {
+		t.Errorf("expected ErrCorruptedSegment for huge key size, got: %v", err)
+	}
+
+	// 不存在的数据类型
+	kind := append([]byte(nil), data...)
+	kind[1] = 0x7f
+	_, _, err = decodeSegment(bytes.NewReader(kind), 0, currentFormat, ChecksumCRC32)
+	if !errors.Is(err, ErrCorruptedSegment) {
+		t.Errorf("expected ErrCorruptedSegment for invalid kind, got: %v", err)
+	}
+
+	// Value 中的任意一个字节损坏都会导致校验失败
+	value := append([]byte(nil), data...)
+	value[len(value)-5] ^= 0xff
+	_, _, err = decodeSegment(bytes.NewReader(value), 0, currentFormat, ChecksumCRC32)
+	if !errors.Is(err, ErrCorruptedSegment) {
+		t.Errorf("expected ErrCorruptedSegment for checksum mismatch, got: %v", err)
+	}
+}
+
+// FuzzDecodeSegment 保证任意损坏的数据文件都不会导致解码 panic 或者分配过大的内存
+// 记录头部的任何一个字节被修改都必须被发现，不能被当成另一条合法的记录读出来
+func TestHeaderByteFlipDetected(t *testing.T) {
+	for _, version := range []uint8{FormatV1, FormatV2, FormatV3, FormatV4} {
+		seg := testSegment("key", "value")
+		seg.ExpiredAt = 1700000000
+		seg.CreatedAt = 1600000000
+		if version >= FormatV2 {
+			seg.Flags = flagBatch
+		}
+		if version >= FormatV3 {
+			seg.UserFlags = 0x5A
+		}
+		if version >= FormatV4 {
+			seg.Version = 42
+		}
+
+		data, err := encodeSegment(seg, version, ChecksumCRC32)
+		if err != nil {
+			t.Fatalf("failed to encode segment in format %d: %v", version, err)
+		}
+
+		hsize, _ := segmentHeaderSize(version)
+		for i := 0; i < hsize; i++ {
+			for _, mask := range []byte{0x01, 0x80, 0xFF} {
+				corrupted := append([]byte(nil), data...)
+				corrupted[i] ^= mask
+				_, _, err := decodeSegment(bytes.NewReader(corrupted), 0, version, ChecksumCRC32)
+				if !errors.Is(err, ErrCorruptedSegment) {
+					t.Errorf("format %d: expected ErrCorruptedSegment after flipping header byte %d with %02x, got: %v", version, i, mask, err)
+				}
+			}
+		}
+	}
+}
+
+func FuzzDecodeSegment(f *testing.F) {
+	for _, seg := range []*Segment{
+		testSegment("key", "value"),
+		NewTombstoneSegment([]byte("key")),
+		NewRangeTombstoneSegment([]byte("a"), []byte("z")),
+		{Type: Unknown, Flags: flagBatchCommit},
+	} {
+		data, err := encodeSegment(seg, currentFormat, ChecksumCRC32)
+		if err != nil {
+			f.Fatalf("failed to encode seed segment: %v", err)
+		}
+		f.Add(data, currentFormat)
+	}
+	v1, err := encodeSegment(testSegment("key", "value"), FormatV1, ChecksumCRC32)
+	if err != nil {
+		f.Fatalf("failed to encode seed segment: %v", err)
+	}
+	f.Add(v1, FormatV1)
+
+	f.Fuzz(func(t *testing.T, data []byte, version uint8) {
+		seg, length, err := decodeSegment(bytes.NewReader(data), 0, version, ChecksumCRC32)
+		if err != nil {
+			return
+		}
+		if length > int64(len(data)) {
+			t.Fatalf("decoded length %d exceeds input size %d", length, len(data))
+		}
+
+		// 能够解码的记录重新编码之后必须和原始数据完全一致
+		encoded, err := encodeSegment(seg, version, ChecksumCRC32)
+		if err != nil {
+			t.Fatalf("failed to re-encode decoded segment: %v", err)
+		}
+		if !bytes.Equal(encoded, data[:length]) {
+			t.Fatalf("re-encoded segment differs from input")
+		}
+	})
+}
+
+// FuzzDeserializedIndex 保证损坏的索引快照记录只会返回错误
+func FuzzDeserializedIndex(f *testing.F) {
+	data, err := serializedIndex(1, &INode{RegionID: 1, Position: 4, Length: 40, Key: "key"})
+	if err != nil {
+		f.Fatalf("failed to serialize seed index: %v", err)
+	}
+	f.Add(data)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		inum, inode, err := deserializedIndex(data)
+		if err != nil {
+			return
+		}
+		encoded, err := serializedIndex(inum, inode)
+		if err != nil {
+			t.Fatalf("failed to re-serialize index: %v", err)
+		}
+		if !bytes.Equal(encoded, data) {
+			t.Fatalf("re-serialized index differs from input")
+		}
+	})
+}
@@ -0,0 +1,167 @@
+// Package fstest 提供只存在于内存中的文件实现，用来在不访问文件系统的情况下测试编解码和 Transformer 的逻辑
+package fstest
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// File 是并发安全的内存文件，实现了 io.ReadWriteCloser、io.ReaderAt、io.WriterAt 和 io.Seeker
+// ReadAt 和 WriteAt 不会移动读写位置，和 *os.File 的语义保持一致
+// 关闭之后所有的操作都返回 os.ErrClosed
+type File struct {
+	mu     sync.RWMutex
+	name   string
+	data   []byte
+	offset int64
+	closed bool
+}
+
+// NewFile 创建一个名为 name 的内存文件，data 会被复制一份作为文件的初始内容
+func NewFile(name string, data []byte) *File {
+	return &File{name: name, data: append([]byte(nil), data...)}
+}
+
+func (f *File) Name() string {
+	return f.name
+}
+
+// Size 返回文件当前的字节数
+func (f *File) Size() int64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return int64(len(f.data))
+}
+
+// Bytes 返回文件内容的副本
+func (f *File) Bytes() []byte {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return append([]byte(nil), f.data...)
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.offset >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, f.data[f.offset:])
+	f.offset += int64(n)
+
+	return n, nil
+}
+
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if off < 0 {
+		return 0, errors.New("failed to read file: negative offset")
+	}
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+
+	// 和 *os.File 一样，读取的字节数不够时返回 io.EOF
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	n := f.writeAt(p, f.offset)
+	f.offset += int64(n)
+
+	return n, nil
+}
+
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if off < 0 {
+		return 0, errors.New("failed to write file: negative offset")
+	}
+
+	return f.writeAt(p, off), nil
+}
+
+// writeAt 在 off 处写入 p，超过文件末尾的部分用 0 填充，调用方需要持有锁
+func (f *File) writeAt(p []byte, off int64) int {
+	if end := off + int64(len(p)); end > int64(len(f.data)) {
+		f.data = append(f.data, make([]byte, end-int64(len(f.data)))...)
+	}
+	return copy(f.data[off:], p)
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.data))
+	default:
+		return 0, errors.New("failed to seek file: invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("failed to seek file: negative position")
+	}
+	f.offset = offset
+
+	return offset, nil
+}
+
+// Sync 内存文件没有需要刷新的数据，只检查文件是否已经关闭
+func (f *File) Sync() error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.closed {
+		return os.ErrClosed
+	}
+	return nil
+}
+
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package fstest
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestFile(t *testing.T) {
+	f := NewFile("00000001.wdb", nil)
+
+	n, err := f.Write([]byte("hello world"))
+	if err != nil || n != 11 {
+		t.Fatalf("failed to write file: %d, %v", n, err)
+	}
+
+	// ReadAt 不会移动读写位置
+	buf := make([]byte, 5)
+	_, err = f.ReadAt(buf, 6)
+	if err != nil || string(buf) != "world" {
+		t.Fatalf("expected world, got %q: %v", buf, err)
+	}
+
+	// 读取的字节数不够时返回读到的部分和 io.EOF
+	n, err = f.ReadAt(buf, 8)
+	if !errors.Is(err, io.EOF) || n != 3 || string(buf[:n]) != "rld" {
+		t.Errorf("expected short read with io.EOF, got %d %q: %v", n, buf[:n], err)
+	}
+
+	_, err = f.WriteAt([]byte("H"), 0)
+	if err != nil {
+		t.Fatalf("failed to write at offset: %v", err)
+	}
+
+	// 在文件末尾之后写入会用 0 填充中间的空白
+	_, err = f.WriteAt([]byte("!"), 12)
+	if err != nil {
+		t.Fatalf("failed to write past end: %v", err)
+	}
+	if string(f.Bytes()) != "Hello world\x00!" {
+		t.Errorf("unexpected file content: %q", f.Bytes())
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		t.Fatalf("failed to seek file: %v", err)
+	}
+	all, err := io.ReadAll(f)
+	if err != nil || len(all) != 13 {
+		t.Errorf("expected 13 bytes, got %d: %v", len(all), err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		t.Fatalf("failed to close file: %v", err)
+	}
+	if _, err := f.ReadAt(buf, 0); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("expected os.ErrClosed, got: %v", err)
+	}
+}
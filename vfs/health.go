@@ -0,0 +1,24 @@
+package vfs
+
+import "fmt"
+
+// Ready 返回存储引擎不能正常处理请求的原因，可以正常读写时返回 nil
+// OpenFS 返回之前已经完成了数据文件和索引的恢复，之后只有关闭和磁盘配额耗尽两种情况不可用
+// 磁盘配额耗尽时只能读取和删除，需要等待紧急压缩释放空间
+func (lfs *LogStructuredFS) Ready() error {
+	lfs.mu.Lock()
+	closed := lfs.closed
+	full := lfs.maxDiskBytes > 0 && lfs.diskUsage() >= lfs.maxDiskBytes
+	lfs.mu.Unlock()
+
+	if closed {
+		return ErrClosed
+	}
+	if lfs.emergency.Load() {
+		return fmt.Errorf("%w: emergency compaction in progress", ErrDiskQuotaExceeded)
+	}
+	if full {
+		return fmt.Errorf("%w: disk usage reached limit %d", ErrDiskQuotaExceeded, lfs.maxDiskBytes)
+	}
+	return nil
+}
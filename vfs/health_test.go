@@ -0,0 +1,29 @@
+package vfs
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReady(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+
+	if err := lfs.Ready(); err != nil {
+		t.Errorf("expected ready after open, got %v", err)
+	}
+
+	lfs.maxDiskBytes = 1
+	if err := lfs.Ready(); !errors.Is(err, ErrDiskQuotaExceeded) {
+		t.Errorf("expected ErrDiskQuotaExceeded when disk is full, got %v", err)
+	}
+	lfs.maxDiskBytes = 0
+
+	mustCloseFS(t, lfs)
+
+	if err := lfs.Ready(); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed after close, got %v", err)
+	}
+}
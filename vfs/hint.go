@@ -0,0 +1,363 @@
+package vfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/auula/wiredkv/clog"
+	"github.com/auula/wiredkv/utils"
+)
+
+// hint 文件按照写入顺序保存一个非活跃数据文件重放之后的索引操作，例如 00000001.hint
+// 写入进程封存数据文件之后在后台生成，只读实例刷新索引时优先读取它，不需要读取记录的 Value
+// | SIGN 4 | SEQ 8 | CODEC 1 | CRYPT 1 | OP 1 | PLEN 4 | PAYLOAD ? | CRC32 4 | ...
+// CRYPT 之后的所有记录使用 Transformer 当前的压缩和加密设置整体压缩和加密
+// 格式版本 1 的 hint 文件没有 CODEC 和 CRYPT，版本 2 只有 CODEC，它们都没有加密
+// 写入操作的 PAYLOAD 是 serializedIndex 格式的索引，删除操作是 | INUM 8 |，范围删除是 | SLEN 4 | START ? | END ? |
+const hintExtension = ".hint"
+
+// hint 文件头，第三个字节和数据文件头不同，最后一个字节为 hint 文件的格式版本
+var hintFileMetadata = []byte{0xDB, 0x0, 0x1, 0x3}
+
+var ErrCorruptedHint = errors.New("corrupted hint file")
+
+const (
+	hintPut byte = iota + 1
+	hintDelete
+	hintDeleteRange
+)
+
+// replayer 接收 replayRegion 按照写入顺序重放的索引操作，从 hint 文件重放的删除操作没有 key
+type replayer interface {
+	put(inum uint64, inode *INode)
+	remove(inum uint64, key string)
+	removeRange(start, end string)
+}
+
+// indexReplayer 把重放的操作应用到内存索引，崩溃恢复时不需要上锁
+// 只读实例刷新索引时其他 goroutine 还在读取，locked 为 true 时持有分片的锁并且清理缓存
+type indexReplayer struct {
+	indexs []*indexMap
+	locked bool
+	cache  *segmentCache
+	cmp    Comparator // 范围删除使用的 Key 顺序，nil 表示字节序
+	tail   bool       // 和 opRecorder 一样，重放的是崩溃之前的活跃数据文件
+	torn   int64
+}
+
+func (r *indexReplayer) truncate(offset int64) bool {
+	if r.tail {
+		r.torn = offset
+	}
+	return r.tail
+}
+
+func (r *indexReplayer) put(inum uint64, inode *INode) {
+	imap := r.indexs[inum%uint64(indexShard)]
+	if r.locked {
+		imap.mu.Lock()
+		defer imap.mu.Unlock()
+	}
+	imap.set(inum, inode)
+	r.cache.remove(inum)
+}
+
+func (r *indexReplayer) remove(inum uint64, key string) {
+	imap := r.indexs[inum%uint64(indexShard)]
+	if r.locked {
+		imap.mu.Lock()
+		defer imap.mu.Unlock()
+	}
+	imap.remove(inum)
+	r.cache.remove(inum)
+}
+
+func (r *indexReplayer) removeRange(start, end string) {
+	if !r.locked {
+		deleteRange(r.indexs, r.cmp, start, end)
+		return
+	}
+	for _, imap := range r.indexs {
+		imap.mu.Lock()
+		imap.each(func(inum uint64, inode *INode) bool {
+			if inRange(r.cmp, inode.Key, start, end) {
+				imap.remove(inum)
+				r.cache.remove(inum)
+			}
+			return true
+		})
+		imap.mu.Unlock()
+	}
+}
+
+// hintWriter 把重放的操作编码为 hint 文件的记录
+type hintWriter struct {
+	buf bytes.Buffer
+	err error
+}
+
+func (w *hintWriter) put(inum uint64, inode *INode) {
+	data, err := serializedIndex(inum, inode)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	w.write(hintPut, data)
+}
+
+func (w *hintWriter) remove(inum uint64, key string) {
+	var payload [8]byte
+	binary.LittleEndian.PutUint64(payload[:], inum)
+	w.write(hintDelete, payload[:])
+}
+
+func (w *hintWriter) removeRange(start, end string) {
+	payload := make([]byte, 4, 4+len(start)+len(end))
+	binary.LittleEndian.PutUint32(payload, uint32(len(start)))
+	payload = append(append(payload, start...), end...)
+	w.write(hintDeleteRange, payload)
+}
+
+func (w *hintWriter) write(op byte, payload []byte) {
+	header := make([]byte, 5, 5+len(payload)+4)
+	header[0] = op
+	binary.LittleEndian.PutUint32(header[1:], uint32(len(payload)))
+	record := append(header, payload...)
+	record = binary.LittleEndian.AppendUint32(record, crc32.ChecksumIEEE(record))
+	w.buf.Write(record)
+}
+
+// hintFileName 返回数据文件对应的 hint 文件路径
+func hintFileName(regionName string) string {
+	return strings.TrimSuffix(regionName, fileExtension) + hintExtension
+}
+
+// writeHint 扫描数据文件生成 hint 文件，先写入临时文件再重命名，保证 hint 文件不会只写入一半
+func writeHint(regionName string, fd BackendFile, regionID uint64, version uint8, checksum Checksum, perm fs.FileMode) error {
+	w := new(hintWriter)
+	sequence, err := replayRegion(fd, regionID, version, checksum, w)
+	if err != nil {
+		return err
+	}
+	return saveHint(regionName, sequence, w, perm)
+}
+
+// saveHint 把 w 中编码的操作写入数据文件对应的 hint 文件，sequence 是数据文件中最大的版本号，perm 是文件的权限
+func saveHint(regionName string, sequence uint64, w *hintWriter, perm fs.FileMode) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	records, err := transformer.encodeMetadata(w.buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 0, len(hintFileMetadata)+8+len(records))
+	buf = append(buf, hintFileMetadata...)
+	buf = binary.LittleEndian.AppendUint64(buf, sequence)
+	buf = append(buf, records...)
+
+	path := hintFileName(regionName)
+	tmp, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(buf)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := utils.CloseFile(tmp); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(path + ".tmp")
+		return fmt.Errorf("failed to write hint file: %w", err)
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+type hintOp struct {
+	op         byte
+	inum       uint64
+	inode      *INode
+	start, end string
+}
+
+// readHint 校验整个 hint 文件之后再把操作重放给 r，损坏的 hint 文件不会留下只重放了一半的索引
+// 返回数据文件中最大的版本号，没有 hint 文件时返回 os.ErrNotExist
+func readHint(regionName string, regionID uint64, r replayer) (uint64, error) {
+	sequence, ops, err := parseHint(regionName, regionID)
+	if err != nil {
+		return 0, err
+	}
+	replayOps(ops, r)
+	return sequence, nil
+}
+
+// parseHint 读取并校验整个 hint 文件，按照写入顺序返回其中的操作
+func parseHint(regionName string, regionID uint64) (uint64, []hintOp, error) {
+	data, err := os.ReadFile(hintFileName(regionName))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	header := len(hintFileMetadata) + 8
+	if len(data) < header || !bytes.Equal(data[:len(hintFileMetadata)-1], hintFileMetadata[:len(hintFileMetadata)-1]) {
+		return 0, nil, fmt.Errorf("%w: invalid hint file signature", ErrCorruptedHint)
+	}
+	sequence := binary.LittleEndian.Uint64(data[len(hintFileMetadata):])
+
+	switch version := data[len(hintFileMetadata)-1]; version {
+	case 1:
+		data = data[header:]
+	case 2:
+		if len(data) == header {
+			return 0, nil, fmt.Errorf("%w: missing codec", ErrCorruptedHint)
+		}
+		data, err = transformer.decompressMetadata(data[header], data[header+1:])
+		if err != nil {
+			return 0, nil, fmt.Errorf("%w: %v", ErrCorruptedHint, err)
+		}
+	case hintFileMetadata[len(hintFileMetadata)-1]:
+		data, err = transformer.decodeMetadata(data[header:])
+		if err != nil {
+			return 0, nil, fmt.Errorf("%w: %v", ErrCorruptedHint, err)
+		}
+	default:
+		return 0, nil, fmt.Errorf("%w: unsupported hint file version %d", ErrCorruptedHint, version)
+	}
+
+	var ops []hintOp
+	for pos := 0; pos < len(data); {
+		if len(data)-pos < 9 {
+			return 0, nil, fmt.Errorf("%w: truncated record at offset %d", ErrCorruptedHint, pos)
+		}
+		plen := int(binary.LittleEndian.Uint32(data[pos+1:]))
+		if plen > len(data)-pos-9 {
+			return 0, nil, fmt.Errorf("%w: payload length %d exceeds file size at offset %d", ErrCorruptedHint, plen, pos)
+		}
+		record := data[pos : pos+5+plen]
+		if binary.LittleEndian.Uint32(data[pos+5+plen:]) != crc32.ChecksumIEEE(record) {
+			return 0, nil, fmt.Errorf("%w: crc32 checksum mismatch at offset %d", ErrCorruptedHint, pos)
+		}
+		pos += len(record) + 4
+
+		op := hintOp{op: record[0]}
+		payload := record[5:]
+		switch op.op {
+		case hintPut:
+			op.inum, op.inode, err = deserializedIndex(payload)
+			if err != nil {
+				return 0, nil, fmt.Errorf("%w: %v", ErrCorruptedHint, err)
+			}
+			if op.inode.RegionID != regionID {
+				return 0, nil, fmt.Errorf("%w: index of region %d in hint file of region %d", ErrCorruptedHint, op.inode.RegionID, regionID)
+			}
+		case hintDelete:
+			if len(payload) != 8 {
+				return 0, nil, fmt.Errorf("%w: invalid delete record size %d", ErrCorruptedHint, len(payload))
+			}
+			op.inum = binary.LittleEndian.Uint64(payload)
+		case hintDeleteRange:
+			if len(payload) < 4 || int(binary.LittleEndian.Uint32(payload)) > len(payload)-4 {
+				return 0, nil, fmt.Errorf("%w: invalid range delete record", ErrCorruptedHint)
+			}
+			slen := int(binary.LittleEndian.Uint32(payload))
+			op.start, op.end = string(payload[4:4+slen]), string(payload[4+slen:])
+		default:
+			return 0, nil, fmt.Errorf("%w: unknown operation %d", ErrCorruptedHint, op.op)
+		}
+		ops = append(ops, op)
+	}
+
+	return sequence, ops, nil
+}
+
+// replayOps 按照顺序把 ops 重放给 r
+func replayOps(ops []hintOp, r replayer) {
+	for _, op := range ops {
+		switch op.op {
+		case hintPut:
+			r.put(op.inum, op.inode)
+		case hintDelete:
+			r.remove(op.inum, "")
+		case hintDeleteRange:
+			r.removeRange(op.start, op.end)
+		}
+	}
+}
+
+// opRecorder 按照顺序记录重放的操作，并行扫描的数据文件先记录下来，再按照数据文件的顺序应用到索引
+type opRecorder struct {
+	ops  []hintOp
+	tail bool  // 崩溃之前的活跃数据文件，末尾没有提交的批量写入需要截断
+	torn int64 // 没有提交的批量写入的开始位置
+}
+
+func (r *opRecorder) truncate(offset int64) bool {
+	if r.tail {
+		r.torn = offset
+	}
+	return r.tail
+}
+
+func (r *opRecorder) put(inum uint64, inode *INode) {
+	r.ops = append(r.ops, hintOp{op: hintPut, inum: inum, inode: inode})
+}
+
+func (r *opRecorder) remove(inum uint64, key string) {
+	r.ops = append(r.ops, hintOp{op: hintDelete, inum: inum})
+}
+
+func (r *opRecorder) removeRange(start, end string) {
+	r.ops = append(r.ops, hintOp{op: hintDeleteRange, start: start, end: end})
+}
+
+// removeHint 删除数据文件对应的 hint 文件
+func removeHint(regionName string) error {
+	err := os.Remove(hintFileName(regionName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// generateHint 在后台为刚刚封存的数据文件生成 hint 文件，调用方需要持有 lfs.mu 锁
+// 生成期间持有 compactMu，数据文件不会在扫描时被压缩删除，CloseFS 会等待它完成
+func (lfs *LogStructuredFS) generateHint(regionID uint64, fd vfsFile) {
+	if lfs.inMemory {
+		return
+	}
+
+	version, checksum := lfs.versions[regionID], lfs.checksums[regionID]
+	lfs.hintWg.Add(1)
+	go func() {
+		defer lfs.hintWg.Done()
+
+		lfs.compactMu.Lock()
+		defer lfs.compactMu.Unlock()
+
+		lfs.mu.Lock()
+		current, ok := lfs.regions[regionID]
+		lfs.mu.Unlock()
+		if !ok || current != fd {
+			return
+		}
+
+		finfo, err := fd.Stat()
+		if err == nil {
+			err = writeHint(fd.Name(), &sealedFile{vfsFile: fd, size: finfo.Size()}, regionID, version, checksum, lfs.fsPerm)
+		}
+		if err != nil {
+			clog.Warnf("failed to generate hint file for region %d: %v", regionID, err)
+		}
+	}()
+}
@@ -0,0 +1,215 @@
+package vfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/auula/wiredkv/utils"
+)
+
+// 打洞的最小单位，文件系统只能以块为单位释放磁盘空间
+const holeBlockSize = int64(4 * KB)
+
+// 记录数据文件中空洞位置的文件扩展名，例如 00000001.holes
+const holesExtension = ".holes"
+
+var ErrPunchHoleUnsupported = errors.New("punch hole is not supported by the file system")
+
+// holeMap 记录数据文件中被打洞的区间，key 是区间开始的位置，value 是区间结束的位置
+// 区间的开始和结束都是记录的边界，扫描数据文件遇到区间开始的位置时直接跳到区间结束的位置
+type holeMap map[int64]int64
+
+// holesFileName 返回数据文件对应的空洞文件路径
+func holesFileName(regionName string) string {
+	return strings.TrimSuffix(regionName, fileExtension) + holesExtension
+}
+
+// loadHoles 读取数据文件对应的空洞文件，没有空洞文件时返回空的 holeMap
+// 空洞文件格式：| START 8 | END 8 | ... | CRC32 4 |
+func loadHoles(regionName string) (holeMap, error) {
+	holes := make(holeMap)
+	data, err := os.ReadFile(holesFileName(regionName))
+	if errors.Is(err, os.ErrNotExist) {
+		return holes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 4 || (len(data)-4)%16 != 0 {
+		return nil, fmt.Errorf("invalid holes file size: %d", len(data))
+	}
+
+	body := data[:len(data)-4]
+	if binary.LittleEndian.Uint32(data[len(body):]) != crc32.ChecksumIEEE(body) {
+		return nil, errors.New("failed to holes file crc32 checksum mismatch")
+	}
+
+	for i := 0; i < len(body); i += 16 {
+		holes[int64(binary.LittleEndian.Uint64(body[i:]))] = int64(binary.LittleEndian.Uint64(body[i+8:]))
+	}
+
+	return holes, nil
+}
+
+// saveHoles 先写入临时文件再重命名，保证空洞文件不会只写入一半
+func saveHoles(regionName string, holes holeMap, perm fs.FileMode) error {
+	starts := make([]int64, 0, len(holes))
+	for start := range holes {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	buf := make([]byte, len(starts)*16+4)
+	for i, start := range starts {
+		binary.LittleEndian.PutUint64(buf[i*16:], uint64(start))
+		binary.LittleEndian.PutUint64(buf[i*16+8:], uint64(holes[start]))
+	}
+	body := buf[:len(buf)-4]
+	binary.LittleEndian.PutUint32(buf[len(body):], crc32.ChecksumIEEE(body))
+
+	path := holesFileName(regionName)
+	tmp, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(buf)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := utils.CloseFile(tmp); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write holes file: %w", err)
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// removeHoles 删除数据文件对应的空洞文件
+func removeHoles(regionName string) error {
+	err := os.Remove(holesFileName(regionName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// PunchHoles 在非活跃数据文件中把连续的垃圾记录打洞，不需要重写有效数据就可以把磁盘空间还给文件系统
+// 删除记录和批量写入的提交记录需要保留，崩溃恢复时依赖它们的顺序，返回释放的字节数
+// 打洞之前先持久化空洞文件，崩溃恢复和压缩扫描数据文件时都会跳过空洞区间
+func (lfs *LogStructuredFS) PunchHoles(regionID uint64) (int64, error) {
+	if lfs.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	lfs.compactMu.Lock()
+	defer lfs.compactMu.Unlock()
+
+	lfs.mu.Lock()
+	fd, ok := lfs.regions[regionID]
+	version := lfs.versions[regionID]
+	checksum := lfs.checksums[regionID]
+	lfs.mu.Unlock()
+	if !ok || regionID == lfs.activeRegionID() {
+		return 0, fmt.Errorf("region %d is not a sealed region", regionID)
+	}
+
+	// 内存中的数据文件没有可以归还给文件系统的磁盘空间
+	file, ok := fd.(*os.File)
+	if !ok {
+		return 0, ErrPunchHoleUnsupported
+	}
+
+	finfo, err := fd.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	holes, err := loadHoles(fd.Name())
+	if err != nil {
+		return 0, fmt.Errorf("failed to load holes: %w", err)
+	}
+
+	// 找到所有连续的垃圾记录区间，已经存在的空洞会和相邻的垃圾记录合并
+	type run struct{ start, end int64 }
+	var runs []run
+	var current *run
+	offset := int64(len(dataFileMetadata))
+	for offset < finfo.Size() {
+		if end, ok := holes[offset]; ok {
+			if current == nil {
+				current = &run{start: offset}
+			}
+			offset, current.end = end, end
+			continue
+		}
+
+		inum, segment, length, err := readRawSegment(fd, offset, version, checksum)
+		if err != nil {
+			return 0, err
+		}
+
+		dead := !segment.IsTombstone() && segment.Flags&flagBatchCommit == 0
+		if dead {
+			inode, ok := lfs.GetINode(inum)
+			dead = !ok || inode.RegionID != regionID || inode.Position != offset
+		}
+
+		if dead {
+			if current == nil {
+				current = &run{start: offset}
+			}
+			current.end = offset + length
+		} else if current != nil {
+			runs = append(runs, *current)
+			current = nil
+		}
+
+		offset += length
+	}
+	if current != nil {
+		runs = append(runs, *current)
+	}
+
+	// 只有至少包含一个完整块的区间才有打洞的意义
+	type block struct{ start, end int64 }
+	var blocks []block
+	punched := make(holeMap, len(runs))
+	for _, r := range runs {
+		start := (r.start + holeBlockSize - 1) / holeBlockSize * holeBlockSize
+		end := r.end / holeBlockSize * holeBlockSize
+		if end > start {
+			blocks = append(blocks, block{start: start, end: end})
+		}
+		punched[r.start] = r.end
+	}
+
+	if len(blocks) == 0 {
+		return 0, nil
+	}
+
+	err = saveHoles(fd.Name(), punched, lfs.fsPerm)
+	if err != nil {
+		return 0, err
+	}
+
+	var reclaimed int64
+	for _, b := range blocks {
+		err := punchHole(file, b.start, b.end-b.start)
+		if err != nil {
+			return reclaimed, err
+		}
+		reclaimed += b.end - b.start
+	}
+
+	return reclaimed, nil
+}
@@ -0,0 +1,34 @@
+//go:build linux
+
+package vfs
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// FALLOC_FL_PUNCH_HOLE 必须和 FALLOC_FL_KEEP_SIZE 一起使用
+const fallocPunchHole = 0x2
+
+// punchHole 释放 [offset, offset+size) 区间的磁盘空间，文件大小保持不变
+func punchHole(f *os.File, offset, size int64) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+	err = conn.Control(func(fd uintptr) {
+		serr = syscall.Fallocate(int(fd), fallocPunchHole|fallocKeepSize, offset, size)
+	})
+	if err != nil {
+		return err
+	}
+
+	if errors.Is(serr, syscall.EOPNOTSUPP) || errors.Is(serr, syscall.ENOSYS) {
+		return ErrPunchHoleUnsupported
+	}
+
+	return serr
+}
@@ -0,0 +1,95 @@
+//go:build linux
+
+package vfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPunchHoles(t *testing.T) {
+	path := t.TempDir()
+	opt := &Options{Path: path, FsPerm: fsPerm, Threshold: 1}
+	lfs, err := OpenFS(opt)
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+
+	value := strings.Repeat("v", 8*KB)
+	keys := []string{"key-01", "key-02", "key-03", "key-04", "key-05", "key-06", "key-07", "key-08"}
+	for _, key := range keys {
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, value), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+
+	err = lfs.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+
+	// 覆盖中间连续的记录，第一个数据文件中间产生一段连续的垃圾记录
+	for _, key := range keys[1:7] {
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value-02"), 0)
+		if err != nil {
+			t.Fatalf("failed to update segment: %v", err)
+		}
+	}
+
+	reclaimed, err := lfs.PunchHoles(1)
+	if errors.Is(err, ErrPunchHoleUnsupported) {
+		t.Skipf("skip punch hole test: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("failed to punch holes: %v", err)
+	}
+	if reclaimed < int64(5*8*KB) {
+		t.Errorf("expected at least %d bytes reclaimed, got %d", 5*8*KB, reclaimed)
+	}
+
+	check := func(lfs *LogStructuredFS) {
+		t.Helper()
+		for i, key := range keys {
+			seg, err := lfs.FetchSegment(InodeNum(key))
+			if err != nil {
+				t.Fatalf("failed to fetch %s: %v", key, err)
+			}
+			want := value
+			if i >= 1 && i < 7 {
+				want = "value-02"
+			}
+			if string(seg.Value) != want {
+				t.Errorf("unexpected value for %s", key)
+			}
+		}
+	}
+	check(lfs)
+	mustCloseFS(t, lfs)
+
+	// 删除索引快照强制扫描数据文件恢复索引，扫描时需要跳过空洞
+	err = os.Remove(filepath.Join(path, indexFileName))
+	if err != nil {
+		t.Fatalf("failed to remove index snapshot: %v", err)
+	}
+
+	lfs, err = OpenFS(opt)
+	if err != nil {
+		t.Fatalf("failed to reopen fs: %v", err)
+	}
+	defer lfs.CloseFS()
+	check(lfs)
+
+	err = lfs.compactRegion(1)
+	if err != nil {
+		t.Fatalf("failed to compact region with holes: %v", err)
+	}
+	check(lfs)
+
+	if _, err := os.Stat(holesFileName(filepath.Join(path, formatDataFileName(1)))); !os.IsNotExist(err) {
+		t.Errorf("expected holes file to be removed after compaction, got: %v", err)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package vfs
+
+import "os"
+
+// punchHole 在不支持 FALLOC_FL_PUNCH_HOLE 的平台直接返回错误
+func punchHole(f *os.File, offset, size int64) error {
+	return ErrPunchHoleUnsupported
+}
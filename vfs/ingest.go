@@ -0,0 +1,355 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/auula/wiredkv/clog"
+)
+
+// 批量导入时每次分配版本号并写入数据文件的记录条数
+const ingestChunk = 1024
+
+// IngestIterator 提供批量导入的记录，NewIterator 返回的 Iterator 也实现了这个接口
+type IngestIterator interface {
+	Next() bool
+	Segment() *Segment
+	Err() error
+}
+
+// IngestReport 记录一次批量导入的结果
+type IngestReport struct {
+	Records  int           // 导入的记录条数
+	Bytes    int64         // 写入数据文件的字节数
+	Regions  []uint64      // 导入生成的数据文件编号
+	Duration time.Duration // 导入花费的时间
+}
+
+// ingestFile 是正在写入或者等待提交的导入数据文件，排序压缩也使用它写入新的数据文件
+type ingestFile struct {
+	tmp    string
+	fd     *os.File
+	size   int64
+	inums  []uint64
+	inodes []*INode
+}
+
+// Ingest 把 it 中的记录直接写入新的非活跃数据文件并且生成 hint 文件，适合初始化时导入大量数据
+// 写入数据文件时不持有索引和数据文件的锁，也不经过写入限速，全部写入之后一次性加入存储引擎并更新内存索引
+// 记录和 AddSegment 一样需要通过 NewSegment 创建，不支持删除记录，同一个 Key 出现多次时以最后一次为准
+// 导入的记录会覆盖导入期间写入的同名 Key，失败时已经写入的临时文件都会被删除
+func (lfs *LogStructuredFS) Ingest(it IngestIterator) (*IngestReport, error) {
+	if lfs.readOnly {
+		return nil, ErrReadOnly
+	}
+	if lfs.inMemory {
+		return nil, errors.New("failed to ingest: in-memory file system does not support ingestion")
+	}
+
+	start := time.Now()
+	var (
+		files   []*ingestFile
+		current *ingestFile
+		chunk   []*Segment
+	)
+	defer func() {
+		for _, file := range files {
+			if file.tmp == "" {
+				continue
+			}
+			if file.fd != nil {
+				_ = file.fd.Close()
+			}
+			_ = os.Remove(file.tmp)
+		}
+	}()
+
+	// 每一批记录只获取一次 lfs.mu 分配版本号，然后通过一次写入追加到导入数据文件中
+	// 每一批记录都序列化到同一个缓冲区中
+	var buf []byte
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+
+		lfs.mu.Lock()
+		base := lfs.sequence.Load()
+		lfs.sequence.Store(base + uint64(len(chunk)))
+		lfs.mu.Unlock()
+
+		buf = buf[:0]
+		for i, seg := range chunk {
+			seg.Version = base + uint64(i) + 1
+			if current == nil || current.size+int64(seg.Size()) > regionThreshold {
+				if len(buf) > 0 {
+					if _, err := current.fd.Write(buf); err != nil {
+						return fmt.Errorf("failed to write ingest region: %w", err)
+					}
+					buf = buf[:0]
+				}
+				file, err := lfs.createIngestFile("ingest", len(files))
+				if err != nil {
+					return err
+				}
+				files = append(files, file)
+				current = file
+			}
+
+			start := len(buf)
+			var err error
+			buf, err = appendEncodedSegment(buf, seg, currentFormat, lfs.checksum)
+			if err != nil {
+				return err
+			}
+			current.inums = append(current.inums, InodeNum(string(seg.Key)))
+			current.inodes = append(current.inodes, &INode{
+				Position:  current.size,
+				Length:    seg.Size(),
+				CreatedAt: seg.CreatedAt,
+				ExpiredAt: seg.ExpiredAt,
+				Version:   seg.Version,
+				Key:       string(seg.Key),
+			})
+			current.size += int64(len(buf) - start)
+		}
+		chunk = chunk[:0]
+
+		if _, err := current.fd.Write(buf); err != nil {
+			return fmt.Errorf("failed to write ingest region: %w", err)
+		}
+		return nil
+	}
+
+	for it.Next() {
+		seg := *it.Segment()
+		lfs.transformSegment(0, &seg)
+		if seg.IsTombstone() {
+			return nil, errors.New("failed to ingest: tombstone segments cannot be ingested")
+		}
+		if err := checkSegmentSize(&seg); err != nil {
+			return nil, err
+		}
+		chunk = append(chunk, &seg)
+		if len(chunk) >= ingestChunk {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, fmt.Errorf("failed to ingest: %w", err)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	report := &IngestReport{}
+	for _, file := range files {
+		err := closeFile(file.fd)
+		file.fd = nil
+		if err != nil {
+			return nil, fmt.Errorf("failed to close ingest region: %w", err)
+		}
+		report.Records += len(file.inums)
+		report.Bytes += file.size
+	}
+	if len(files) == 0 {
+		report.Duration = time.Since(start)
+		return report, nil
+	}
+
+	if err := lfs.checkIngestLimits(files, report.Bytes); err != nil {
+		return nil, err
+	}
+
+	regions, err := lfs.commitIngest(files)
+	if err != nil {
+		return nil, err
+	}
+	files = nil
+	report.Regions = regions
+	report.Duration = time.Since(start)
+
+	return report, nil
+}
+
+// createIngestFile 在数据目录中创建临时数据文件，例如 ingest-0.wdb.tmp，崩溃恢复不会扫描这些文件
+func (lfs *LogStructuredFS) createIngestFile(prefix string, n int) (*ingestFile, error) {
+	tmp := filepath.Join(lfs.directory, fmt.Sprintf("%s-%d%s.tmp", prefix, n, fileExtension))
+	fd, err := os.OpenFile(tmp, os.O_CREATE|os.O_RDWR|os.O_TRUNC, lfs.fsPerm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ingest region: %w", err)
+	}
+
+	metadata := fileMetadata(currentFormat, lfs.checksum)
+	_, err = fd.Write(metadata)
+	if err != nil {
+		_ = fd.Close()
+		return nil, fmt.Errorf("failed to write ingest region metadata: %w", err)
+	}
+
+	return &ingestFile{tmp: tmp, fd: fd, size: int64(len(metadata))}, nil
+}
+
+// checkIngestLimits 提交之前检查导入之后是否会超过 MaxIndexMemory 和 MaxDiskBytes
+func (lfs *LogStructuredFS) checkIngestLimits(files []*ingestFile, size int64) error {
+	if lfs.maxIndexMem > 0 {
+		var added int64
+		for _, file := range files {
+			for i, inum := range file.inums {
+				if _, ok := lfs.GetINode(inum); !ok {
+					added += inodeMemory(len(file.inodes[i].Key))
+				}
+			}
+		}
+		if added > 0 && lfs.indexMemory()+added > lfs.maxIndexMem {
+			return ErrIndexMemoryExceeded
+		}
+	}
+
+	if lfs.maxDiskBytes > 0 {
+		lfs.mu.Lock()
+		used := lfs.diskUsage()
+		lfs.mu.Unlock()
+		if used+size > lfs.maxDiskBytes {
+			return fmt.Errorf("%w: %d bytes used, ingesting %d bytes exceeds limit %d", ErrDiskQuotaExceeded, used, size, lfs.maxDiskBytes)
+		}
+	}
+
+	return nil
+}
+
+// commitIngest 提交导入数据文件并且更新内存索引
+// 持有 compactMu 防止压缩在提交期间把旧的记录迁移到导入数据文件之后
+func (lfs *LogStructuredFS) commitIngest(files []*ingestFile) ([]uint64, error) {
+	lfs.compactMu.Lock()
+	defer lfs.compactMu.Unlock()
+
+	regions, err := lfs.commitRegions(files)
+	if err != nil {
+		return nil, err
+	}
+
+	// 提交之后的写入在更新索引之前完成时，它们在导入数据文件之后，不能被导入的记录覆盖
+	last := regions[len(regions)-1]
+	for _, file := range files {
+		for i, inum := range file.inums {
+			inode := file.inodes[i]
+			shard := lfs.indexs[inum%uint64(indexShard)]
+			shard.mu.Lock()
+			old, ok := shard.get(inum)
+			if ok && old.RegionID > last {
+				shard.mu.Unlock()
+				lfs.markDead(inode)
+				continue
+			}
+			shard.set(inum, inode)
+			shard.mu.Unlock()
+
+			if old != nil {
+				lfs.indexBytes.Add(-inodeMemory(len(old.Key)))
+			}
+			lfs.indexBytes.Add(inodeMemory(len(inode.Key)))
+			lfs.expiry.push(inum, inode.ExpiredAt)
+			lfs.markDead(old)
+			lfs.cache.remove(inum)
+
+			if err := lfs.audit.record("", AuditPut, inode.Key, ""); err != nil {
+				return nil, err
+			}
+		}
+
+		file.saveHint(lfs.fsPerm)
+	}
+
+	return regions, nil
+}
+
+// commitRegions 封存当前的活跃数据文件，把写好的数据文件按顺序编号在它之后，然后创建新的活跃数据文件
+// 调用方需要持有 compactMu，返回之后这些数据文件中的记录还没有加入内存索引
+func (lfs *LogStructuredFS) commitRegions(files []*ingestFile) ([]uint64, error) {
+	lfs.mu.Lock()
+	if lfs.closed {
+		lfs.mu.Unlock()
+		return nil, ErrClosed
+	}
+
+	err := lfs.sealActive()
+	if err != nil {
+		lfs.mu.Unlock()
+		return nil, fmt.Errorf("failed to sync active region: %w", err)
+	}
+
+	regions := make([]uint64, len(files))
+	for i, file := range files {
+		regionID := lfs.regionID + 1
+		name := filepath.Join(lfs.directory, formatDataFileName(regionID))
+		err := os.Rename(file.tmp, name)
+		if err == nil {
+			file.fd, err = os.OpenFile(name, os.O_RDWR, lfs.fsPerm)
+		}
+		if err != nil {
+			// 已经加入的数据文件还没有更新索引，需要删除，否则崩溃恢复时会重放它们
+			err = fmt.Errorf("failed to commit region %d: %w", regionID, err)
+			for _, committed := range files[:i] {
+				id := committed.inodes[0].RegionID
+				delete(lfs.regions, id)
+				delete(lfs.usage, id)
+				delete(lfs.versions, id)
+				delete(lfs.checksums, id)
+				delete(lfs.accessed, id)
+				if rerr := committed.fd.Close(); rerr == nil {
+					rerr = removeRegionFile(committed.fd)
+					err = errors.Join(err, rerr)
+				}
+			}
+			if cerr := lfs.createActiveRegion(); cerr != nil {
+				err = errors.Join(err, cerr)
+			}
+			lfs.mu.Unlock()
+			return nil, err
+		}
+		file.tmp = ""
+
+		lfs.regionID = regionID
+		lfs.regions[regionID] = file.fd
+		lfs.versions[regionID] = currentFormat
+		lfs.checksums[regionID] = lfs.checksum
+		lfs.touchRegion(regionID)
+		usage := lfs.regionUsage(regionID)
+		for _, inode := range file.inodes {
+			inode.RegionID = regionID
+			usage.live += int64(inode.Length)
+		}
+		regions[i] = regionID
+	}
+
+	err = lfs.createActiveRegion()
+	lfs.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return regions, nil
+}
+
+// saveHint 为已经提交的数据文件生成 hint 文件，hint 文件只用来加速只读实例的加载，失败时只记录日志
+func (file *ingestFile) saveHint(perm fs.FileMode) {
+	w := new(hintWriter)
+	var sequence uint64
+	for i, inum := range file.inums {
+		w.put(inum, file.inodes[i])
+		if file.inodes[i].Version > sequence {
+			sequence = file.inodes[i].Version
+		}
+	}
+
+	err := saveHint(file.fd.Name(), sequence, w, perm)
+	if err != nil {
+		clog.Warnf("failed to write hint file for region %d: %v", file.inodes[0].RegionID, err)
+	}
+}
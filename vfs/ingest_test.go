@@ -0,0 +1,116 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/auula/wiredkv/utils"
+)
+
+type sliceIngestIterator struct {
+	segments []*Segment
+	pos      int
+}
+
+func (it *sliceIngestIterator) Next() bool {
+	it.pos++
+	return it.pos <= len(it.segments)
+}
+
+func (it *sliceIngestIterator) Segment() *Segment {
+	return it.segments[it.pos-1]
+}
+
+func (it *sliceIngestIterator) Err() error {
+	return nil
+}
+
+func TestIngest(t *testing.T) {
+	path := t.TempDir()
+	lfs, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+
+	err = lfs.AddSegment(InodeNum("key-0000"), *testSegment("key-0000", "old"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+
+	it := new(sliceIngestIterator)
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprintf("key-%04d", i)
+		it.segments = append(it.segments, testSegment(key, "value-"+key))
+	}
+	report, err := lfs.Ingest(it)
+	if err != nil {
+		t.Fatalf("failed to ingest: %v", err)
+	}
+	if report.Records != 2000 || len(report.Regions) == 0 {
+		t.Fatalf("expected 2000 records in at least one region, got %+v", report)
+	}
+	for _, id := range report.Regions {
+		if id <= 1 {
+			t.Errorf("expected ingest regions after active region, got %d", id)
+		}
+		name := filepath.Join(path, formatDataFileName(id))
+		if !utils.IsExist(hintFileName(name)) {
+			t.Errorf("expected hint file for ingest region %d", id)
+		}
+	}
+
+	// 导入之后的写入仍然追加到新的活跃数据文件
+	err = lfs.AddSegment(InodeNum("key-0001"), *testSegment("key-0001", "new"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment after ingest: %v", err)
+	}
+	mustCloseFS(t, lfs)
+
+	lfs, err = OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to reopen fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	if lfs.Count() != 2000 {
+		t.Errorf("expected 2000 keys, got %d", lfs.Count())
+	}
+	for key, value := range map[string]string{"key-0000": "value-key-0000", "key-0001": "new", "key-1999": "value-key-1999"} {
+		seg, err := lfs.FetchSegment(InodeNum(key))
+		if err != nil {
+			t.Fatalf("failed to fetch %s: %v", key, err)
+		}
+		if string(seg.Value) != value {
+			t.Errorf("expected %s for %s, got %s", value, key, seg.Value)
+		}
+	}
+
+	tombstone := testSegment("key-0002", "")
+	tombstone.Tombstone = 1
+	_, err = lfs.Ingest(&sliceIngestIterator{segments: []*Segment{tombstone}})
+	if err == nil {
+		t.Errorf("expected error for tombstone segment")
+	}
+}
+
+func TestIngestReadOnly(t *testing.T) {
+	path := t.TempDir()
+	lfs, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	mustCloseFS(t, lfs)
+
+	reader, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("failed to open read-only fs: %v", err)
+	}
+	defer mustCloseFS(t, reader)
+
+	_, err = reader.Ingest(&sliceIngestIterator{segments: []*Segment{testSegment("key", "value")}})
+	if !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+}
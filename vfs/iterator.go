@@ -0,0 +1,219 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var ErrScanCanceled = errors.New("scan canceled")
+
+// scanCanceled 把 ctx 的错误包装成 ErrScanCanceled，调用方仍然可以使用 errors.Is 判断具体的原因
+func scanCanceled(ctx context.Context) error {
+	return fmt.Errorf("%w: %w", ErrScanCanceled, ctx.Err())
+}
+
+// Iterator 按照 Comparator 的顺序遍历以 prefix 开头的记录
+// 创建时复制一份匹配的 Key 快照，之后每读取一条记录都会检查 ctx
+// 遍历过程中被删除的 Key 会被跳过，遍历过程中新增的 Key 不会出现在结果中
+type Iterator struct {
+	ctx      context.Context
+	lfs      *LogStructuredFS
+	keys     []string
+	pos      int
+	key      string
+	seg      *Segment
+	inode    *INode
+	keysOnly bool         // 只返回 Key 和内存索引中的元数据，不读取数据文件
+	reuse    bool         // 只读取 Value 到 value 中，每次 Next 复用同一个缓冲区
+	value    []byte       // reuse 为 true 时当前记录的 Value
+	block    *sortedBlock // 最近一次从排序数据文件中读取的块
+	err      error
+}
+
+// NewIterator 创建一个遍历以 prefix 开头的记录的迭代器，prefix 为空时遍历所有记录
+func (lfs *LogStructuredFS) NewIterator(ctx context.Context, prefix string) *Iterator {
+	it := &Iterator{ctx: ctx, lfs: lfs}
+	prefix = lfs.transformKey(prefix)
+	for _, shard := range lfs.indexs {
+		if ctx.Err() != nil {
+			it.err = scanCanceled(ctx)
+			return it
+		}
+
+		shard.mu.RLock()
+		shard.each(func(_ uint64, inode *INode) bool {
+			if strings.HasPrefix(inode.Key, prefix) {
+				it.keys = append(it.keys, inode.Key)
+			}
+			return true
+		})
+		shard.mu.RUnlock()
+	}
+	sort.Slice(it.keys, func(i, j int) bool {
+		return lfs.comparator.Compare(it.keys[i], it.keys[j]) < 0
+	})
+	return it
+}
+
+// NewKeyIterator 创建一个只遍历 Key 和元数据的迭代器，元数据来自内存索引，不会读取数据文件
+// 遍历所有 Key 的速度和读取索引快照差不多，Segment 总是返回 nil，需要 Value 时再调用 FetchSegment
+func (lfs *LogStructuredFS) NewKeyIterator(ctx context.Context, prefix string) *Iterator {
+	it := lfs.NewIterator(ctx, prefix)
+	it.keysOnly = true
+	return it
+}
+
+// NewValueIterator 创建一个只读取 Value 的迭代器，Value 返回的切片复用迭代器内部的缓冲区
+// 返回的 Value 只在下一次调用 Next 之前有效，需要保存时调用方要自己复制，Segment 总是返回 nil
+func (lfs *LogStructuredFS) NewValueIterator(ctx context.Context, prefix string) *Iterator {
+	it := lfs.NewIterator(ctx, prefix)
+	it.reuse = true
+	return it
+}
+
+// Next 移动到下一条记录，没有更多记录或者发生错误时返回 false，需要通过 Err 区分两种情况
+func (it *Iterator) Next() bool {
+	for it.err == nil && it.pos < len(it.keys) {
+		if it.ctx.Err() != nil {
+			it.err = scanCanceled(it.ctx)
+			break
+		}
+
+		key := it.keys[it.pos]
+		it.pos++
+
+		if it.keysOnly {
+			inode, ok := it.lfs.GetINode(InodeNum(key))
+			if !ok || isExpired(inode.ExpiredAt) {
+				continue
+			}
+			it.key, it.inode = key, inode
+			return true
+		}
+
+		if it.reuse {
+			value, err := it.lfs.ReadValue(InodeNum(key), it.value)
+			if errors.Is(err, ErrSegmentNotFound) {
+				continue
+			}
+			if err != nil {
+				it.err = err
+				break
+			}
+			it.key, it.value = key, value
+			return true
+		}
+
+		seg, err := it.fetch(key)
+		if errors.Is(err, ErrSegmentNotFound) {
+			continue
+		}
+		if err != nil {
+			it.err = err
+			break
+		}
+
+		it.key, it.seg = key, seg
+		return true
+	}
+
+	it.key, it.seg, it.inode = "", nil, nil
+	it.value = it.value[:0]
+	return false
+}
+
+// fetch 读取 key 的记录，记录在排序数据文件中时按块读取，相邻的 Key 不需要再读取数据文件
+func (it *Iterator) fetch(key string) (*Segment, error) {
+	inum := InodeNum(key)
+	inode, ok := it.lfs.GetINode(inum)
+	if !ok || isExpired(inode.ExpiredAt) {
+		return nil, ErrSegmentNotFound
+	}
+
+	if it.block == nil || !it.block.contains(inode) {
+		// 读取块失败时数据文件可能刚刚被压缩，交给 FetchSegmentContext 重新查找索引
+		block, err := it.lfs.readSortedBlock(inode)
+		if err != nil {
+			block = nil
+		}
+		it.block = block
+	}
+
+	if it.block != nil && it.block.contains(inode) {
+		_, seg, _, err := readRawSegment(it.block, inode.Position, it.block.version, it.block.checksum)
+		if err == nil {
+			seg.Value, err = transformer.decode(seg.Flags, seg.Value)
+		}
+		if err == nil {
+			return seg, nil
+		}
+	}
+
+	return it.lfs.FetchSegmentContext(it.ctx, inum)
+}
+
+// Seek 把迭代器移动到第一个大于等于 key 的位置，之后的 Next 从这里开始遍历
+// 可以向前或者向后跳转，key 不需要在 prefix 范围内，中断之后传入上一次的 Key 就能继续遍历
+func (it *Iterator) Seek(key string) {
+	key = it.lfs.transformKey(key)
+	it.pos = sort.Search(len(it.keys), func(i int) bool {
+		return it.lfs.comparator.Compare(it.keys[i], key) >= 0
+	})
+	it.key, it.seg, it.inode = "", nil, nil
+}
+
+// seekAfter 移动到第一个排在 key 之后的位置
+func (it *Iterator) seekAfter(key string) {
+	it.pos = sort.Search(len(it.keys), func(i int) bool {
+		return it.lfs.comparator.Compare(it.keys[i], key) > 0
+	})
+	it.key, it.seg, it.inode = "", nil, nil
+}
+
+// Key 返回当前记录的 Key
+func (it *Iterator) Key() string {
+	return it.key
+}
+
+// Segment 返回当前记录
+func (it *Iterator) Segment() *Segment {
+	return it.seg
+}
+
+// Value 返回当前记录解码之后的 Value，NewValueIterator 创建的迭代器返回的切片在下一次 Next 之前有效
+func (it *Iterator) Value() []byte {
+	if it.reuse {
+		return it.value
+	}
+	if it.seg == nil {
+		return nil
+	}
+	return it.seg.Value
+}
+
+// INode 返回只遍历 Key 时当前记录在内存索引中的元数据，包括创建时间、过期时间、版本号和记录长度
+// 返回的是副本，修改它不会影响内存索引
+func (it *Iterator) INode() *INode {
+	if it.inode == nil {
+		return nil
+	}
+	inode := *it.inode
+	return &inode
+}
+
+// Err 返回遍历过程中发生的错误，ctx 取消或者超时时返回 ErrScanCanceled
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close 释放迭代器持有的 Key 快照，之后 Next 总是返回 false
+func (it *Iterator) Close() {
+	it.keys = nil
+	it.pos = 0
+	it.block = nil
+	it.value = nil
+	it.key, it.seg, it.inode = "", nil, nil
+}
@@ -0,0 +1,170 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIterator(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer lfs.CloseFS()
+
+	for _, key := range []string{"user:03", "user:01", "order:01", "user:02"} {
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value-"+key), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+
+	it := lfs.NewIterator(context.Background(), "user:")
+	defer it.Close()
+
+	// 遍历过程中删除的 Key 会被跳过
+	err = lfs.DelSegment("user:02")
+	if err != nil {
+		t.Fatalf("failed to delete segment: %v", err)
+	}
+
+	var keys []string
+	for it.Next() {
+		if string(it.Segment().Value) != "value-"+it.Key() {
+			t.Errorf("unexpected value for %s: %s", it.Key(), it.Segment().Value)
+		}
+		keys = append(keys, it.Key())
+	}
+	if it.Err() != nil {
+		t.Fatalf("failed to iterate: %v", it.Err())
+	}
+
+	if len(keys) != 2 || keys[0] != "user:01" || keys[1] != "user:03" {
+		t.Errorf("expected [user:01 user:03], got %v", keys)
+	}
+}
+
+func TestIteratorCanceled(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer lfs.CloseFS()
+
+	for _, key := range []string{"key-01", "key-02", "key-03"} {
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value"), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	it := lfs.NewIterator(ctx, "")
+	defer it.Close()
+
+	if !it.Next() {
+		t.Fatalf("failed to read first record: %v", it.Err())
+	}
+	cancel()
+
+	if it.Next() {
+		t.Fatalf("expected iterator to stop after cancel")
+	}
+	if !errors.Is(it.Err(), ErrScanCanceled) || !errors.Is(it.Err(), context.Canceled) {
+		t.Errorf("expected ErrScanCanceled, got: %v", it.Err())
+	}
+
+	err = lfs.ScanKeysContext(ctx, "*", func(key string) bool { return true })
+	if !errors.Is(err, ErrScanCanceled) {
+		t.Errorf("expected ErrScanCanceled from ScanKeysContext, got: %v", err)
+	}
+}
+
+func TestIteratorSeek(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer lfs.CloseFS()
+
+	for _, key := range []string{"user:01", "user:02", "user:04", "user:05"} {
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value"), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+
+	it := lfs.NewIterator(context.Background(), "user:")
+	defer it.Close()
+
+	// 不存在的 Key 会定位到下一个更大的 Key
+	it.Seek("user:03")
+	if !it.Next() || it.Key() != "user:04" {
+		t.Fatalf("expected user:04 after seek, got %q: %v", it.Key(), it.Err())
+	}
+
+	// 可以向前跳转重新遍历
+	it.Seek("user:02")
+	var keys []string
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	if it.Err() != nil {
+		t.Fatalf("failed to iterate: %v", it.Err())
+	}
+	if strings.Join(keys, ",") != "user:02,user:04,user:05" {
+		t.Errorf("unexpected keys after seek: %v", keys)
+	}
+
+	it.Seek("user:99")
+	if it.Next() {
+		t.Errorf("expected no keys after seeking past the end, got %s", it.Key())
+	}
+}
+
+func TestKeyIterator(t *testing.T) {
+	dir := t.TempDir()
+	lfs, err := OpenFS(&Options{Path: dir, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer lfs.CloseFS()
+
+	for _, key := range []string{"user:01", "user:02", "order:01"} {
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value"), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+
+	// 截断数据文件之后读取 Value 一定会失败，只遍历 Key 不受影响
+	err = os.Truncate(filepath.Join(dir, formatDataFileName(1)), int64(len(dataFileMetadata)))
+	if err != nil {
+		t.Fatalf("failed to truncate region: %v", err)
+	}
+
+	it := lfs.NewKeyIterator(context.Background(), "user:")
+	defer it.Close()
+
+	var keys []string
+	for it.Next() {
+		if it.Segment() != nil {
+			t.Errorf("expected no segment in key-only iteration")
+		}
+		inode := it.INode()
+		if inode == nil || inode.Key != it.Key() || inode.Version == 0 {
+			t.Errorf("expected index metadata for %s, got %+v", it.Key(), inode)
+		}
+		keys = append(keys, it.Key())
+	}
+	if it.Err() != nil {
+		t.Fatalf("failed to iterate keys: %v", it.Err())
+	}
+	if strings.Join(keys, ",") != "user:01,user:02" {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+}
@@ -0,0 +1,135 @@
+package vfs
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/auula/wiredkv/utils"
+)
+
+// 估算 Key 数量时每个索引分片最多采样的 Key 个数
+var countSampleSize = 1024
+
+// Count 返回内存索引中 Key 的精确数量，已经过期但是还没有被后台删除的 Key 和 Get 一样不可见
+func (lfs *LogStructuredFS) Count() int {
+	count := 0
+	for _, shard := range lfs.indexs {
+		shard.mu.RLock()
+		shard.each(func(_ uint64, inode *INode) bool {
+			if !isExpired(inode.ExpiredAt) {
+				count++
+			}
+			return true
+		})
+		shard.mu.RUnlock()
+	}
+	return count
+}
+
+// EstimateCount 通过采样估算以 prefix 开头的 Key 数量，不需要全量扫描索引
+// 每个分片最多采样 countSampleSize 个 Key，用匹配的比例乘以分片的大小
+// 分片大小不超过采样数量时返回的就是精确值
+func (lfs *LogStructuredFS) EstimateCount(prefix string) int {
+	if prefix == "" {
+		return lfs.Count()
+	}
+	prefix = lfs.transformKey(prefix)
+
+	var estimate float64
+	for _, shard := range lfs.indexs {
+		sampled, matched := 0, 0
+		shard.mu.RLock()
+		total := shard.len()
+		// map 的遍历顺序本身就是随机的，可以直接作为采样
+		shard.each(func(_ uint64, inode *INode) bool {
+			if sampled >= countSampleSize {
+				return false
+			}
+			sampled++
+			if strings.HasPrefix(inode.Key, prefix) && !isExpired(inode.ExpiredAt) {
+				matched++
+			}
+			return true
+		})
+		shard.mu.RUnlock()
+
+		if sampled > 0 {
+			estimate += float64(matched) / float64(sampled) * float64(total)
+		}
+	}
+
+	return int(estimate + 0.5)
+}
+
+// Keys 返回所有匹配 glob 模式的 Key 并且按照 Comparator 的顺序排序，* 匹配任意字符，? 匹配单个字节
+// 只会访问内存索引，不会读取数据文件中的 Value
+func (lfs *LogStructuredFS) Keys(pattern string) []string {
+	var keys []string
+	lfs.ScanKeys(pattern, func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		return lfs.comparator.Compare(keys[i], keys[j]) < 0
+	})
+	return keys
+}
+
+// ScanKeys 流式遍历所有匹配 glob 模式的 Key，fn 返回 false 时停止遍历
+// 每次只在一个索引分片上持有读锁复制匹配的 Key，回调函数不会在持有锁的时候执行，已经过期的 Key 不会返回
+// 遍历的顺序是不确定的，适合结果集非常大不能一次性放到内存中的场景
+func (lfs *LogStructuredFS) ScanKeys(pattern string, fn func(key string) bool) {
+	_ = lfs.ScanKeysContext(context.Background(), pattern, fn)
+}
+
+// ScanKeysContext 和 ScanKeys 一样，但是在每个索引分片和每个 Key 之间检查 ctx
+// ctx 取消或者超时时停止遍历并返回 ErrScanCanceled
+func (lfs *LogStructuredFS) ScanKeysContext(ctx context.Context, pattern string, fn func(key string) bool) error {
+	defer lfs.latency.observe(LatencyScan, time.Now())
+
+	ctx, span := lfs.startSpan(ctx, spanScan)
+	if span == nil {
+		return lfs.scanKeys(ctx, pattern, fn)
+	}
+
+	var keys int64
+	err := lfs.scanKeys(ctx, pattern, func(key string) bool {
+		keys++
+		return fn(key)
+	})
+	span.SetAttributes(stringAttr("vfs.pattern", pattern), int64Attr("vfs.keys", keys))
+	endSpan(span, err)
+	return err
+}
+
+func (lfs *LogStructuredFS) scanKeys(ctx context.Context, pattern string, fn func(key string) bool) error {
+	pattern = lfs.transformKey(pattern)
+	prefix := utils.GlobPrefix(pattern)
+	for _, shard := range lfs.indexs {
+		if ctx.Err() != nil {
+			return scanCanceled(ctx)
+		}
+
+		var keys []string
+		shard.mu.RLock()
+		shard.each(func(_ uint64, inode *INode) bool {
+			if strings.HasPrefix(inode.Key, prefix) && utils.MatchGlob(pattern, inode.Key) && !isExpired(inode.ExpiredAt) {
+				keys = append(keys, inode.Key)
+			}
+			return true
+		})
+		shard.mu.RUnlock()
+
+		for _, key := range keys {
+			if ctx.Err() != nil {
+				return scanCanceled(ctx)
+			}
+			if !fn(key) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestKeysWithGlobPattern(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer lfs.CloseFS()
+
+	for _, key := range []string{"user:01", "user:02", "user:100", "order:01"} {
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value"), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+
+	if got := lfs.Keys("user:??"); !reflect.DeepEqual(got, []string{"user:01", "user:02"}) {
+		t.Errorf("unexpected keys for user:??: %v", got)
+	}
+
+	if got := lfs.Keys("*:01"); !reflect.DeepEqual(got, []string{"order:01", "user:01"}) {
+		t.Errorf("unexpected keys for *:01: %v", got)
+	}
+
+	count := 0
+	lfs.ScanKeys("*", func(key string) bool {
+		count++
+		return count < 2
+	})
+	if count != 2 {
+		t.Errorf("expected scan to stop after 2 keys, got %d", count)
+	}
+}
+
+func TestCountAndEstimateCount(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer lfs.CloseFS()
+
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprintf("order:%04d", i)
+		if i%4 == 0 {
+			key = fmt.Sprintf("user:%04d", i)
+		}
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, "v"), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+
+	if count := lfs.Count(); count != 2000 {
+		t.Errorf("expected count 2000, got %d", count)
+	}
+
+	// 数据量小于采样数量时估算值就是精确值
+	if count := lfs.EstimateCount("user:"); count != 500 {
+		t.Errorf("expected estimate count 500, got %d", count)
+	}
+
+	countSampleSize = 100
+	defer func() { countSampleSize = 1024 }()
+	if count := lfs.EstimateCount("user:"); count < 300 || count > 700 {
+		t.Errorf("expected estimate count near 500, got %d", count)
+	}
+}
+
+func TestBinaryKeys(t *testing.T) {
+	path := t.TempDir()
+	audit := filepath.Join(t.TempDir(), "audit.log")
+	opt := &Options{Path: path, FsPerm: fsPerm, Threshold: 1, AuditLog: audit}
+	lfs, err := OpenFS(opt)
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+
+	keys := []string{"a", "a\x00", "a\x00b", "a\x00\xff", "\xff\xfe", "*?\\"}
+	for _, key := range keys {
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, key), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment %q: %v", key, err)
+		}
+	}
+	// [a\x00b, a\x00\xff) 只包含 a\x00b
+	err = lfs.DeleteRange("a\x00b", "a\x00\xff")
+	if err != nil {
+		t.Fatalf("failed to delete range: %v", err)
+	}
+	err = lfs.DelSegment("\xff\xfe")
+	if err != nil {
+		t.Fatalf("failed to delete segment: %v", err)
+	}
+	err = lfs.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+
+	expected := []string{"*?\\", "a", "a\x00", "a\x00\xff"}
+	check := func(lfs *LogStructuredFS) {
+		t.Helper()
+		var got []string
+		it := lfs.NewIterator(context.Background(), "")
+		for it.Next() {
+			if string(it.Segment().Value) != it.Key() {
+				t.Fatalf("expected value %q, got %q", it.Key(), it.Segment().Value)
+			}
+			got = append(got, it.Key())
+		}
+		if err := it.Err(); err != nil {
+			t.Fatalf("failed to iterate: %v", err)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("expected keys %q, got %q", expected, got)
+		}
+		if got := lfs.Keys("a\x00*"); !reflect.DeepEqual(got, []string{"a\x00", "a\x00\xff"}) {
+			t.Fatalf("unexpected keys for a\\x00*: %q", got)
+		}
+		if got := lfs.Keys(`\*\?*`); !reflect.DeepEqual(got, []string{"*?\\"}) {
+			t.Fatalf("unexpected keys for escaped pattern: %q", got)
+		}
+	}
+	check(lfs)
+
+	// 只读实例从 hint 文件或者数据文件加载封存的数据文件
+	lfs.hintWg.Wait()
+	reader, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("failed to open read-only fs: %v", err)
+	}
+	check(reader)
+	mustCloseFS(t, reader)
+
+	mustCloseFS(t, lfs)
+	if _, err := VerifyAuditLog(audit); err != nil {
+		t.Fatalf("failed to verify audit log with binary keys: %v", err)
+	}
+
+	// 从索引快照恢复，然后删除快照通过崩溃恢复重放数据文件
+	lfs, err = OpenFS(opt)
+	if err != nil {
+		t.Fatalf("failed to reopen fs: %v", err)
+	}
+	check(lfs)
+	mustCloseFS(t, lfs)
+
+	err = os.Remove(filepath.Join(path, indexFileName))
+	if err != nil {
+		t.Fatalf("failed to remove index snapshot: %v", err)
+	}
+	lfs, err = OpenFS(opt)
+	if err != nil {
+		t.Fatalf("failed to reopen fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+	check(lfs)
+}
+
+func TestKeysSkipExpired(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer lfs.CloseFS()
+
+	// 停止后台删除，过期的 Key 留在内存索引中
+	lfs.stopExpireDaemon()
+
+	err = lfs.AddSegment(InodeNum("user:01"), *testSegment("user:01", "value"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+	expired := testSegment("user:02", "value")
+	expired.ExpiredAt = uint64(time.Now().Unix()) - 1
+	err = lfs.AddSegment(InodeNum("user:02"), *expired, 0)
+	if err != nil {
+		t.Fatalf("failed to add expired segment: %v", err)
+	}
+	if _, ok := lfs.GetINode(InodeNum("user:02")); !ok {
+		t.Fatal("expected expired key to stay in index")
+	}
+
+	if got := lfs.Keys("user:*"); !reflect.DeepEqual(got, []string{"user:01"}) {
+		t.Errorf("expected expired key to be skipped, got %v", got)
+	}
+	if got := lfs.Count(); got != 1 {
+		t.Errorf("expected count 1, got %d", got)
+	}
+	if got := lfs.EstimateCount("user:"); got != 1 {
+		t.Errorf("expected estimate count 1, got %d", got)
+	}
+}
@@ -0,0 +1,83 @@
+package vfs
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// KeyTransform 在每个操作使用 Key 之前转换它，例如转换为小写实现不区分大小写的 Key
+// 写入、删除、范围删除、遍历的 prefix、Keys 的模式以及 InodeNum 都会经过转换
+// 遍历返回的是转换之后的 Key，所以 KeyTransform 必须是幂等的，转换之后的 Key 再次转换不能改变
+// 通过 inum 读取记录时需要使用 LogStructuredFS.InodeNum 计算 inum，直接使用 InodeNum 会跳过转换
+type KeyTransform func(key string) string
+
+// LowercaseKeys 把 Key 转换为小写，不是合法 UTF-8 的 Key 只转换 ASCII 字母，其他字节保持不变
+func LowercaseKeys(key string) string {
+	if utf8.ValidString(key) {
+		return strings.ToLower(key)
+	}
+
+	lower := []byte(key)
+	for i, c := range lower {
+		if 'A' <= c && c <= 'Z' {
+			lower[i] = c + 'a' - 'A'
+		}
+	}
+	return string(lower)
+}
+
+// NFCKeys 把 Key 转换为 Unicode NFC 规范化形式，组合字符的不同写法会得到同一个 Key
+// 不是合法 UTF-8 的 Key 保持不变
+func NFCKeys(key string) string {
+	if !utf8.ValidString(key) {
+		return key
+	}
+	return norm.NFC.String(key)
+}
+
+// PrefixKeys 返回给每个 Key 加上 prefix 的 KeyTransform，例如多个应用共享一个数据目录
+// 已经以 prefix 开头的 Key 保持不变，遍历返回的 Key 可以直接再次传入
+func PrefixKeys(prefix string) KeyTransform {
+	return func(key string) string {
+		if strings.HasPrefix(key, prefix) {
+			return key
+		}
+		return prefix + key
+	}
+}
+
+// ChainKeyTransforms 返回按照顺序依次执行 transforms 的 KeyTransform
+func ChainKeyTransforms(transforms ...KeyTransform) KeyTransform {
+	return func(key string) string {
+		for _, transform := range transforms {
+			key = transform(key)
+		}
+		return key
+	}
+}
+
+// transformKey 返回转换之后的 Key，没有配置 KeyTransform 时原样返回
+func (lfs *LogStructuredFS) transformKey(key string) string {
+	if lfs.keyTransform == nil {
+		return key
+	}
+	return lfs.keyTransform(key)
+}
+
+// transformSegment 转换记录的 Key 并且返回转换之后的 inum，没有配置 KeyTransform 时返回 inum
+func (lfs *LogStructuredFS) transformSegment(inum uint64, seg *Segment) uint64 {
+	if lfs.keyTransform == nil {
+		return inum
+	}
+	key := lfs.keyTransform(string(seg.Key))
+	seg.Key = []byte(key)
+	seg.KeySize = uint32(len(key))
+	return InodeNum(key)
+}
+
+// InodeNum 返回 key 经过 KeyTransform 转换之后的 inum，配置了 KeyTransform 时读取记录需要使用它
+func (lfs *LogStructuredFS) InodeNum(key string) uint64 {
+	return InodeNum(lfs.transformKey(key))
+}
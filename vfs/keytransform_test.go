@@ -0,0 +1,101 @@
+package vfs
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestKeyTransforms(t *testing.T) {
+	tests := []struct {
+		transform KeyTransform
+		key, want string
+	}{
+		{LowercaseKeys, "User:ABC", "user:abc"},
+		{LowercaseKeys, "ÄBC", "äbc"},
+		{LowercaseKeys, "KEY\xff", "key\xff"},
+		{NFCKeys, "e\u0301", "\u00e9"},
+		{NFCKeys, "\xff\xfe", "\xff\xfe"},
+		{PrefixKeys("app:"), "user", "app:user"},
+		{PrefixKeys("app:"), "app:user", "app:user"},
+		{ChainKeyTransforms(LowercaseKeys, PrefixKeys("app:")), "User", "app:user"},
+	}
+	for _, tt := range tests {
+		if got := tt.transform(tt.key); got != tt.want {
+			t.Errorf("transform(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+		// 转换必须是幂等的
+		if got := tt.transform(tt.want); got != tt.want {
+			t.Errorf("transform(%q) = %q, expected idempotent", tt.want, got)
+		}
+	}
+}
+
+func TestKeyTransformOperations(t *testing.T) {
+	lfs, err := OpenFS(&Options{
+		Path:         t.TempDir(),
+		FsPerm:       fsPerm,
+		Threshold:    1,
+		KeyTransform: ChainKeyTransforms(LowercaseKeys, PrefixKeys("app:")),
+	})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	err = lfs.AddSegment(InodeNum("User:01"), *testSegment("User:01", "v1"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+	err = lfs.AddSegments(map[uint64]Segment{InodeNum("USER:02"): *testSegment("USER:02", "v2")})
+	if err != nil {
+		t.Fatalf("failed to add segments: %v", err)
+	}
+	err = lfs.Batch(func(b *Batch) error {
+		b.AddSegment(InodeNum("user:03"), *testSegment("user:03", "v3"))
+		b.AddSegment(InodeNum("Order:01"), *testSegment("Order:01", "v4"))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to commit batch: %v", err)
+	}
+
+	seg, err := lfs.FetchSegment(lfs.InodeNum("user:01"))
+	if err != nil || string(seg.Value) != "v1" {
+		t.Fatalf("expected v1, got %v %v", seg, err)
+	}
+	if string(seg.Key) != "app:user:01" {
+		t.Errorf("expected stored key app:user:01, got %q", seg.Key)
+	}
+
+	if got := lfs.Keys("USER:*"); !reflect.DeepEqual(got, []string{"app:user:01", "app:user:02", "app:user:03"}) {
+		t.Errorf("unexpected keys for USER:*: %q", got)
+	}
+
+	page, err := lfs.ScanPage("User:", "", 2)
+	if err != nil || len(page.Keys) != 2 {
+		t.Fatalf("expected first page with 2 keys, got %v %v", page, err)
+	}
+	page, err = lfs.ScanPage("User:", page.Cursor, 2)
+	if err != nil || !reflect.DeepEqual(page.Keys, []string{"app:user:03"}) {
+		t.Fatalf("expected second page [app:user:03], got %v %v", page, err)
+	}
+
+	// 遍历返回的 Key 可以直接再次传入
+	err = lfs.DelSegment("app:user:02")
+	if err != nil {
+		t.Fatalf("failed to delete segment: %v", err)
+	}
+	err = lfs.DeletePrefix("ORDER:")
+	if err != nil {
+		t.Fatalf("failed to delete prefix: %v", err)
+	}
+	for _, key := range []string{"user:02", "order:01"} {
+		if _, err := lfs.FetchSegment(lfs.InodeNum(key)); !errors.Is(err, ErrSegmentNotFound) {
+			t.Errorf("expected %s to be deleted, got %v", key, err)
+		}
+	}
+	if lfs.Count() != 2 {
+		t.Errorf("expected 2 keys, got %d", lfs.Count())
+	}
+}
@@ -0,0 +1,245 @@
+package vfs
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LatencyOp 统计延迟的操作类型
+type LatencyOp string
+
+const (
+	LatencyGet     LatencyOp = "get"
+	LatencyPut     LatencyOp = "put"
+	LatencyDelete  LatencyOp = "delete"
+	LatencyScan    LatencyOp = "scan"
+	LatencyCompact LatencyOp = "compact"
+)
+
+var latencyOps = []LatencyOp{LatencyGet, LatencyPut, LatencyDelete, LatencyScan, LatencyCompact}
+
+// 延迟直方图按照 HDR 的思路分桶，每个 2 的幂次区间再均分为 latencySubBuckets 个桶
+// 相对误差不超过 1/latencySubBuckets，超过 latencyMaxBits 位纳秒（约 18 分钟）的延迟计入最后一个桶
+const (
+	latencySubBits    = 4
+	latencySubBuckets = 1 << latencySubBits
+	latencyMaxBits    = 40
+	latencyBuckets    = latencySubBuckets*(latencyMaxBits-latencySubBits) + 2*latencySubBuckets
+
+	// 最近一分钟的统计由 latencySlots 个 latencySlot 长度的时间片组成，每个时间片过期之后整体清空
+	latencySlots = 12
+	latencySlot  = 5 * time.Second
+)
+
+// latencyIndex 返回 ns 所在的桶，前 2*latencySubBuckets 个桶的宽度是 1 纳秒
+func latencyIndex(ns uint64) int {
+	if ns >= 1<<latencyMaxBits {
+		return latencyBuckets - 1
+	}
+	if ns < 2*latencySubBuckets {
+		return int(ns)
+	}
+	shift := bits.Len64(ns) - latencySubBits - 1
+	return latencySubBuckets*shift + int(ns>>shift)
+}
+
+// latencyUpperBound 返回桶中最大的纳秒数
+func latencyUpperBound(index int) uint64 {
+	if index < 2*latencySubBuckets {
+		return uint64(index)
+	}
+	shift := index/latencySubBuckets - 1
+	low := uint64(index-latencySubBuckets*shift) << shift
+	return low + 1<<shift - 1
+}
+
+type latencyCounts struct {
+	counts [latencyBuckets]uint64
+	total  uint64
+	sum    uint64
+	min    uint64
+	max    uint64
+}
+
+func (c *latencyCounts) record(ns uint64) {
+	c.counts[latencyIndex(ns)]++
+	if c.total == 0 || ns < c.min {
+		c.min = ns
+	}
+	if ns > c.max {
+		c.max = ns
+	}
+	c.total++
+	c.sum += ns
+}
+
+func (c *latencyCounts) merge(other *latencyCounts) {
+	if other.total == 0 {
+		return
+	}
+	for i, n := range other.counts {
+		c.counts[i] += n
+	}
+	if c.total == 0 || other.min < c.min {
+		c.min = other.min
+	}
+	if other.max > c.max {
+		c.max = other.max
+	}
+	c.total += other.total
+	c.sum += other.sum
+}
+
+func (c *latencyCounts) histogram() LatencyHistogram {
+	h := LatencyHistogram{
+		Count: c.total,
+		Min:   time.Duration(c.min),
+		Max:   time.Duration(c.max),
+	}
+	if c.total > 0 {
+		h.Mean = time.Duration(c.sum / c.total)
+	}
+	for i, n := range c.counts {
+		if n > 0 {
+			h.Buckets = append(h.Buckets, LatencyBucket{UpperBound: time.Duration(latencyUpperBound(i)), Count: n})
+		}
+	}
+	return h
+}
+
+// latencyRecorder 记录一种操作从打开以来和最近一分钟的延迟
+type latencyRecorder struct {
+	mu     sync.Mutex
+	total  latencyCounts
+	slots  [latencySlots]latencyCounts
+	epochs [latencySlots]int64 // 每个时间片对应的时间，不是当前这一分钟的时间片已经过期
+}
+
+func (r *latencyRecorder) record(now time.Time, d time.Duration) {
+	ns := uint64(0)
+	if d > 0 {
+		ns = uint64(d)
+	}
+
+	epoch := now.UnixNano() / int64(latencySlot)
+	slot := int(epoch % latencySlots)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.epochs[slot] != epoch {
+		r.slots[slot] = latencyCounts{}
+		r.epochs[slot] = epoch
+	}
+	r.slots[slot].record(ns)
+	r.total.record(ns)
+}
+
+func (r *latencyRecorder) snapshot(now time.Time) (LatencyHistogram, LatencyHistogram) {
+	epoch := now.UnixNano() / int64(latencySlot)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var recent latencyCounts
+	for i := range r.slots {
+		if epoch-r.epochs[i] < latencySlots {
+			recent.merge(&r.slots[i])
+		}
+	}
+
+	return r.total.histogram(), recent.histogram()
+}
+
+// latencyStats 保存每种操作的延迟直方图
+type latencyStats struct {
+	recorders map[LatencyOp]*latencyRecorder
+}
+
+func newLatencyStats() *latencyStats {
+	s := &latencyStats{recorders: make(map[LatencyOp]*latencyRecorder, len(latencyOps))}
+	for _, op := range latencyOps {
+		s.recorders[op] = new(latencyRecorder)
+	}
+	return s
+}
+
+// observe 记录从 start 开始到现在的延迟，map 创建之后不会再修改，不需要加锁
+func (s *latencyStats) observe(op LatencyOp, start time.Time) {
+	if s == nil {
+		return
+	}
+	now := time.Now()
+	s.recorders[op].record(now, now.Sub(start))
+}
+
+// LatencyBucket 是直方图中的一个桶，Count 是延迟不超过 UpperBound 并且大于上一个桶的次数
+type LatencyBucket struct {
+	UpperBound time.Duration
+	Count      uint64
+}
+
+// LatencyHistogram 是一段时间内的延迟分布，只包含有数据的桶
+type LatencyHistogram struct {
+	Count   uint64
+	Min     time.Duration
+	Max     time.Duration
+	Mean    time.Duration
+	Buckets []LatencyBucket
+}
+
+// Percentile 返回 p 分位的延迟，p 的范围是 0 到 100，返回的是所在桶的上界
+func (h *LatencyHistogram) Percentile(p float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(p / 100 * float64(h.Count)))
+	if rank == 0 {
+		rank = 1
+	}
+
+	var seen uint64
+	for _, bucket := range h.Buckets {
+		seen += bucket.Count
+		if seen >= rank {
+			// 桶的上界可能超过实际出现过的最大值
+			if bucket.UpperBound > h.Max {
+				return h.Max
+			}
+			return bucket.UpperBound
+		}
+	}
+
+	return h.Max
+}
+
+// OperationLatency 是一种操作从打开以来和最近一分钟的延迟分布
+type OperationLatency struct {
+	Op         LatencyOp
+	Total      LatencyHistogram
+	LastMinute LatencyHistogram
+}
+
+// Latency 返回每种操作的延迟直方图，不需要外部的监控系统也能分析长尾延迟
+func (lfs *LogStructuredFS) Latency() []OperationLatency {
+	if lfs.latency == nil {
+		return nil
+	}
+
+	now := time.Now()
+	result := make([]OperationLatency, 0, len(latencyOps))
+	for _, op := range latencyOps {
+		total, recent := lfs.latency.recorders[op].snapshot(now)
+		result = append(result, OperationLatency{Op: op, Total: total, LastMinute: recent})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Op < result[j].Op
+	})
+
+	return result
+}
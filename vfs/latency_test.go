@@ -0,0 +1,84 @@
+package vfs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyBuckets(t *testing.T) {
+	for _, ns := range []uint64{0, 1, 31, 32, 33, 63, 64, 1000, 123456789, 1<<latencyMaxBits - 1} {
+		index := latencyIndex(ns)
+		if index < 0 || index >= latencyBuckets {
+			t.Fatalf("index %d of %d out of range", index, ns)
+		}
+		if upper := latencyUpperBound(index); ns > upper {
+			t.Errorf("expected %d within bucket %d upper bound %d", ns, index, upper)
+		}
+		if index > 0 && ns <= latencyUpperBound(index-1) {
+			t.Errorf("expected %d above previous bucket upper bound %d", ns, latencyUpperBound(index-1))
+		}
+		// 桶的宽度不超过下界的 1/latencySubBuckets
+		if lower := latencyUpperBound(index-1) + 1; index > 2*latencySubBuckets && float64(latencyUpperBound(index)-lower) > float64(lower)/latencySubBuckets {
+			t.Errorf("bucket %d is too wide: [%d, %d]", index, lower, latencyUpperBound(index))
+		}
+	}
+}
+
+func TestLatencyRecorder(t *testing.T) {
+	var r latencyRecorder
+	now := time.Now()
+
+	// 两分钟之前的记录只出现在 Total 中
+	r.record(now.Add(-2*time.Minute), time.Second)
+	for i := 1; i <= 100; i++ {
+		r.record(now, time.Duration(i)*time.Millisecond)
+	}
+
+	total, recent := r.snapshot(now)
+	if total.Count != 101 || total.Max != time.Second {
+		t.Errorf("expected 101 records with max 1s, got %d with max %s", total.Count, total.Max)
+	}
+	if recent.Count != 100 || recent.Min != time.Millisecond || recent.Max != 100*time.Millisecond {
+		t.Errorf("expected 100 recent records in [1ms, 100ms], got %d in [%s, %s]", recent.Count, recent.Min, recent.Max)
+	}
+
+	p99 := recent.Percentile(99)
+	if p99 < 99*time.Millisecond || p99 > 99*time.Millisecond*(latencySubBuckets+1)/latencySubBuckets {
+		t.Errorf("expected p99 close to 99ms, got %s", p99)
+	}
+	if p100 := recent.Percentile(100); p100 != 100*time.Millisecond {
+		t.Errorf("expected p100 to be 100ms, got %s", p100)
+	}
+}
+
+func TestLatencyStats(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	err = lfs.AddSegment(InodeNum("key"), *testSegment("key", "value"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+	_, err = lfs.FetchSegment(InodeNum("key"))
+	if err != nil {
+		t.Fatalf("failed to fetch segment: %v", err)
+	}
+	err = lfs.DelSegment("key")
+	if err != nil {
+		t.Fatalf("failed to delete segment: %v", err)
+	}
+
+	counts := make(map[LatencyOp]uint64)
+	for _, op := range lfs.Stats().Latency {
+		counts[op.Op] = op.LastMinute.Count
+		if op.Total.Count != op.LastMinute.Count {
+			t.Errorf("expected %s total and last minute counts to match, got %d and %d", op.Op, op.Total.Count, op.LastMinute.Count)
+		}
+	}
+	if counts[LatencyPut] != 1 || counts[LatencyGet] != 1 || counts[LatencyDelete] != 1 || counts[LatencyScan] != 0 {
+		t.Errorf("unexpected latency counts: %v", counts)
+	}
+}
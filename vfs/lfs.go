@@ -1,20 +1,24 @@
 package vfs
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/cipher"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash/crc32"
-	"hash/fnv"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/auula/wiredkv/clog"
@@ -45,102 +49,764 @@ var (
 	fileExtension    = ".wdb"
 	indexFileName    = "index.wdb"
 	regionThreshold  = int64(1 * GB) // 1GB
+	minSegmentSize   = int64(1 * MB)
+	maxSegmentSize   = int64(255 * GB)
+	gcBatchSize      = 2 // 每个 gc 周期最多回收的数据文件个数
 	dataFileMetadata = []byte{0xDB, 0x0, 0x0, 0x1}
-	transformer      = NewTransformer()
+	// 索引快照文件头，最后一个字节为索引快照的格式版本
+	indexFileMetadata = []byte{0xDB, 0x0, 0x0, 0x6}
+	currentFormat     = FormatV4 // 新创建的数据文件使用的格式版本
+	transformer       = NewTransformer()
+	// 打开的实例共享 transformer，最后一个实例关闭时清零密钥
+	openInstances atomic.Int64
+)
+
+var (
+	ErrIndexMemoryExceeded = errors.New("index memory limit exceeded")
+	ErrSegmentNotFound     = errors.New("segment not found")
+	ErrSegmentTooLarge     = errors.New("segment too large")
+	ErrClosed              = errors.New("log structured file system closed")
 )
 
 type Options struct {
 	Path      string
 	FsPerm    os.FileMode
 	Threshold uint8 // 这个的大小会影响到垃圾回收执行的时间
+	// SegmentSize 单个数据文件的最大字节数，设置之后会覆盖以 GB 为单位的 Threshold
+	// 数据文件按照 00000001.wdb、00000002.wdb 的格式编号，编号越大的数据文件越新
+	SegmentSize int64
+	// MaxIndexMemory 内存索引可以使用的最大字节数，0 表示不限制
+	MaxIndexMemory int64
+	// MaxCacheMemory 数据记录缓存可以使用的最大字节数，0 表示不开启缓存
+	MaxCacheMemory int64
+	// Eviction 缓存达到 MaxCacheMemory 之后的淘汰策略
+	Eviction EvictionPolicy
+	// WriteBytesPerSec 和 WriteOpsPerSec 限制每秒写入的字节数和次数，0 表示不限制
+	WriteBytesPerSec int64
+	WriteOpsPerSec   int64
+	// Sync 写入之后的刷盘策略，SyncInterval 策略按照 SyncInterval 周期在后台刷盘
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+	// SyncMethod 刷盘使用 fsync、fdatasync 或者 O_DSYNC，默认使用 fsync
+	SyncMethod SyncMethod
+	// MaxValueSize 覆盖每种数据类型默认的 Value 最大字节数，0 表示不限制
+	MaxValueSize map[Kind]int64
+	// MaxKeySize Key 的最大字节数，超过时写入返回 ErrKeyTooLarge，0 表示使用默认的 64KB
+	MaxKeySize int64
+	// Backend 存放冷数据文件，非活跃数据文件可以通过 OffloadRegion 迁移过去，nil 表示不使用
+	Backend Backend
+	// Archive 压缩时完全过期或者完全是垃圾数据的数据文件在删除之前会先归档到这里，nil 表示直接删除
+	Archive Backend
+	// Preallocate 创建活跃数据文件时预先分配 Threshold 大小的磁盘空间
+	Preallocate bool
+	// InMemory 所有的数据文件都只保存在内存中，不会读写数据目录也不会刷盘，关闭之后数据全部丢失
+	InMemory bool
+	// MaxDiskBytes 本地数据文件最多占用的字节数，超过之后写入返回 ErrDiskQuotaExceeded，0 表示不限制
+	MaxDiskBytes int64
+	// Paranoid 每次写入之后重新读取并校验记录，压缩之前检查内存索引和数据文件是否一致
+	// 发现不一致时直接 panic 并输出诊断信息，会明显降低性能，只适合开发和测试时使用
+	Paranoid bool
+	// Checksum 新创建的数据文件使用的记录校验码算法，默认 CRC32，已有的数据文件按照文件头中记录的算法校验
+	Checksum Checksum
+	// AuditLog 审计日志文件的路径，记录每次修改的客户端、时间和操作，空表示不开启
+	// 客户端标识通过 WithClientID 设置在写入操作的 ctx 中
+	AuditLog string
+	// VerifyOnOpen 打开时校验所有本地数据文件中的每一条记录，最后写入的数据文件末尾损坏的记录会被截断
+	// 检查结果通过 OpenReport 返回并且输出到日志，数据文件很多时会明显增加启动时间
+	VerifyOnOpen bool
+	// GCRatio 后台 gc 只回收垃圾数据比例不低于 GCRatio 的数据文件，0 表示有垃圾数据就回收
+	GCRatio float64
+	// Tracer 为读写、扫描和压缩创建追踪的 span，nil 表示不追踪
+	Tracer Tracer
+	// ReadOnly 以只读模式打开写入进程正在使用的数据目录，多个只读实例可以同时打开，写入返回 ErrReadOnly
+	// 只读实例只能看到已经封存的数据文件，不会读取 Backend 中的数据文件，也不会删除过期的 Key
+	ReadOnly bool
+	// RefreshInterval 只读实例从 hint 文件刷新内存索引的周期，默认 10 秒
+	RefreshInterval time.Duration
+	// Engine 存储引擎写入和整理数据文件的方式，默认 EngineLog，只读实例忽略这个选项
+	Engine Engine
+	// KeyTransform 在每个操作使用 Key 之前转换它，例如 LowercaseKeys，nil 表示不转换
+	KeyTransform KeyTransform
+	// Index 内存索引保存 Key 的方式，默认 IndexHashMap，Key 有很长的公共前缀时 IndexRadix 占用的内存更少
+	Index IndexKind
+	// Comparator 定义遍历和范围删除的 Key 顺序，nil 表示使用 manifest 中记录的内置 Comparator，新的数据目录使用字节序
+	Comparator Comparator
+	// RecoveryWorkers 打开时并行校验数据文件和没有索引快照时扫描数据文件重建索引的 goroutine 数量，0 表示 runtime.GOMAXPROCS(0)
+	RecoveryWorkers int
+	// SnapshotInterval 定期导出索引快照的周期，崩溃之后只需要重放最后一次导出之后追加的记录，0 表示只在关闭时导出
+	SnapshotInterval time.Duration
+	// Encryptor 和 Secret 在恢复索引之前开启加密，加密的 hint 文件和索引快照才能在打开时读取，nil 表示不加密
+	// Secret 会被复制一份，OpenFS 返回之后调用方可以立即清零自己的 Secret
+	Encryptor Encryptor
+	Secret    []byte
+	// Compressor 在恢复索引之前开启压缩，nil 表示不压缩
+	Compressor Compressor
 }
 
 // INode represents a file system node with metadata.
 type INode struct {
 	RegionID  uint64 // Unique identifier for the region
-	Position  uint64 // Position within the file
+	Position  int64  // Position within the file
 	Length    uint32 // Data record length
 	ExpiredAt uint64 // Expiration time of the INode (UNIX timestamp in seconds)
 	CreatedAt uint64 // Creation time of the INode (UNIX timestamp in seconds)
+	Version   uint64 // Version assigned to the data record when it was written
+	Key       string // Original key of the data record, arbitrary bytes including NUL and invalid UTF-8
 }
 
 type indexMap struct {
 	mu    sync.RWMutex      // 每个分片使用独立的锁
 	index map[uint64]*INode // 存储映射
+	trie  *radixIndex       // 配置 IndexRadix 时使用基数树存储，index 为 nil
+}
+
+// segmentSize 返回单个数据文件的最大字节数并且校验它的范围
+// 数据文件不能小于最小值，否则每条记录都会触发数据文件切换
+// 也不能大于 255GB，一条记录的 Value 最大为 4GB，数据文件至少要能放下一条最大的记录
+func (opt *Options) segmentSize() (int64, error) {
+	size := opt.SegmentSize
+	if size == 0 {
+		size = int64(opt.Threshold) * GB
+	}
+
+	if size < minSegmentSize || size > maxSegmentSize {
+		return 0, fmt.Errorf("invalid segment size %d: must be between %d and %d bytes", size, minSegmentSize, maxSegmentSize)
+	}
+
+	return size, nil
+}
+
+// checkSegmentSize 写入之前检查记录能否放进一个数据文件中，以及 Key 和 Value 是否超过限制
+func checkSegmentSize(seg *Segment) error {
+	if err := checkKeySize(len(seg.Key)); err != nil {
+		return err
+	}
+	if int64(seg.Size()) > regionThreshold-int64(len(dataFileMetadata)) {
+		return fmt.Errorf("%w: %d bytes exceeds segment size %d", ErrSegmentTooLarge, seg.Size(), regionThreshold)
+	}
+	if seg.IsTombstone() {
+		return nil
+	}
+	return checkValueSize(seg.Type, int64(seg.ValueSize))
 }
 
 // LogStructuredFS represents the virtual file storage system.
 type LogStructuredFS struct {
-	mu          sync.Mutex
-	offset      uint64
-	regionID    uint64
-	directory   string
-	indexs      []*indexMap
-	active      *os.File
-	regions     map[uint64]*os.File
-	gcstate     GC_STATUS
-	gcdone      chan struct{}
-	dirtyRegion []*os.File
+	mu           sync.Mutex
+	offset       int64
+	regionID     uint64
+	directory    string
+	fsPerm       fs.FileMode // 创建文件使用的权限，来自 Options.FsPerm，后台生成 hint 文件时不读取全局的 fsPerm
+	indexs       []*indexMap
+	active       vfsFile
+	regions      map[uint64]vfsFile
+	cold         map[uint64]BackendFile // 已经迁移到 Backend 中的数据文件
+	accessed     map[uint64]time.Time   // 每个数据文件最后一次被读取的时间
+	tierdone     chan struct{}
+	tierexit     chan struct{}
+	backend      Backend
+	archive      Backend
+	gcstate      GC_STATUS
+	gcdone       chan struct{}
+	cache        *segmentCache
+	maxIndexMem  int64
+	usage        map[uint64]*regionUsage
+	versions     map[uint64]uint8    // 每个数据文件的格式版本
+	checksums    map[uint64]Checksum // 每个数据文件的校验码算法
+	checksum     Checksum            // 新创建的数据文件使用的校验码算法
+	limiter      *writeLimiter
+	lock         *dirLock     // 数据目录的排他锁
+	compactMu    sync.Mutex   // 压缩和打洞不能同时处理同一个数据文件
+	appendMu     sync.RWMutex // 追加记录到更新索引期间持有读锁，导出索引快照和 CopyChanges 确定水位线时持有写锁
+	compaction   CompactionController
+	history      compactionHistory
+	maxDiskBytes int64
+	emergency    atomic.Bool    // 是否有紧急压缩正在执行
+	emergencyWg  sync.WaitGroup // 关闭之前等待紧急压缩完成
+	indexBytes   atomic.Int64   // 内存索引估算占用的字节数
+	sequence     atomic.Uint64  // 最后一次写入的记录序列号
+	synced       atomic.Uint64  // 已经刷新到磁盘的记录序列号
+	syncPolicy   SyncPolicy
+	syncInterval time.Duration
+	gcRatio      float64    // 后台 gc 回收的最小垃圾数据比例
+	optMu        sync.Mutex // 串行执行 SetOptions
+	tracer       Tracer     // 没有设置 Tracer 时为 nil
+	latency      *latencyStats
+	syncMethod   SyncMethod
+	prealloc     bool
+	inMemory     bool // 数据文件只保存在内存中
+	noSync       bool // 测试使用的临时实例不刷盘
+	paranoid     bool
+	expiry       *expireQueue   // 按照过期时间排序的 Key
+	audit        *auditLog      // 没有开启审计日志时为 nil
+	report       *OpenReport    // 没有开启 VerifyOnOpen 时为 nil
+	closed       bool           // CloseFS 之后为 true，由 lfs.mu 保护
+	hintWg       sync.WaitGroup // 关闭之前等待后台生成的 hint 文件
+	readOnly     bool
+	stale        map[uint64]uint64       // 只读实例中已经被删除的数据文件，加载到对应的数据文件之后重建索引
+	sparse       map[uint64]*sparseIndex // 排序数据文件的稀疏索引，值为 nil 表示不是排序数据文件，由 lfs.mu 保护
+	lsm          *lsmState               // 没有使用 EngineLSM 时为 nil
+	comparator   Comparator              // Key 的顺序，不会为 nil
+	keyTransform KeyTransform            // 没有配置时为 nil
+	indexKind    IndexKind
+	workers      int        // 崩溃恢复时扫描数据文件的并发数
+	refreshMu    sync.Mutex // 串行执行 Refresh
+	follower     *Follower  // 由 refreshMu 保护，没有跟随活跃数据文件时为 nil
+	tail         *tailState // 由 refreshMu 保护
+	refreshdone  chan struct{}
+	refreshexit  chan struct{}
+	syncMu       sync.Mutex
+	syncNotify   chan struct{}
+	syncdone     chan struct{}
+	syncexit     chan struct{}
+	snapshotdone chan struct{}
+	snapshotexit chan struct{}
+	backupMu     sync.Mutex  // 同一时间只执行一个备份
+	backups      backupState // 备份的状态，通过 Stats 返回
+	backupdone   chan struct{}
+	backupexit   chan struct{}
+	backupcancel context.CancelFunc
+	backupAEAD   cipher.AEAD // 不为空时使用备份密钥加密备份文件，通过 backupMu 保护
+	backupKeyID  string      // 备份密钥的指纹，保存在增量备份的清单中
+}
+
+// regionUsage 记录每个数据文件中有效数据和垃圾数据的字节数
+type regionUsage struct {
+	live int64
+	dead int64
+}
+
+// garbageRatio 返回数据文件中垃圾数据所占的比例
+func (u *regionUsage) garbageRatio() float64 {
+	total := u.live + u.dead
+	if total <= 0 {
+		return 0
+	}
+	return float64(u.dead) / float64(total)
 }
 
 // AddSegment 会向 LogStructuredFS 虚拟文件系统插入一条 Segment 记录
 func (lfs *LogStructuredFS) AddSegment(inum uint64, seg Segment, ttl uint64) error {
+	return lfs.AddSegmentContext(context.Background(), inum, seg, ttl)
+}
+
+// AddSegmentContext 和 AddSegment 一样，但是在写入限速等待时会响应 ctx 的取消和超时
+func (lfs *LogStructuredFS) AddSegmentContext(ctx context.Context, inum uint64, seg Segment, ttl uint64) error {
+	_, err := lfs.AddSegmentVersion(ctx, inum, seg, ttl)
+	return err
+}
+
+// AddSegmentVersion 和 AddSegmentContext 一样，并且返回这次写入分配的版本号
+// 版本号在整个存储引擎中单调递增，调用方可以用它实现乐观并发控制和缓存校验
+func (lfs *LogStructuredFS) AddSegmentVersion(ctx context.Context, inum uint64, seg Segment, ttl uint64) (uint64, error) {
+	return lfs.putSegment(ctx, inum, seg, nil)
+}
+
+// putSegment 写入一条记录并更新内存索引，cond 不为空时在分片锁内检查 Key 的当前状态
+// 检查和写入之间持有分片锁，其他写入同一个 Key 的操作不能插入进来
+func (lfs *LogStructuredFS) putSegment(ctx context.Context, inum uint64, seg Segment, cond func(inode *INode) error) (uint64, error) {
+	op := LatencyPut
+	if seg.IsTombstone() {
+		op = LatencyDelete
+	}
+	defer lfs.latency.observe(op, time.Now())
+
+	inum = lfs.transformSegment(inum, &seg)
+	ctx, span := lfs.startSpan(ctx, spanPut)
+	version, err := lfs.writeSegment(ctx, inum, seg, cond)
+	if span != nil {
+		span.SetAttributes(
+			int64Attr("vfs.bytes", int64(seg.Size())),
+			int64Attr("vfs.version", int64(version)),
+			boolAttr("vfs.conditional", cond != nil),
+		)
+	}
+	endSpan(span, err)
+	return version, err
+}
+
+func (lfs *LogStructuredFS) writeSegment(ctx context.Context, inum uint64, seg Segment, cond func(inode *INode) error) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// 版本号只能由存储引擎分配，调用方传入的是上一次读取到的版本号
+	seg.Version = 0
+
 	// 根据某种哈希函数简单的模运算来选择索引分片
 	shard := lfs.indexs[inum%uint64(indexShard)]
 
-	err := appendBinaryToFile(lfs.active, &seg)
+	// 只有新增的 key 才会让索引变大，更新已有的 key 不受限制
+	shard.mu.RLock()
+	_, exists := shard.get(inum)
+	shard.mu.RUnlock()
+	if !exists && lfs.maxIndexMem > 0 && lfs.indexMemory()+inodeMemory(len(seg.Key)) > lfs.maxIndexMem {
+		return 0, ErrIndexMemoryExceeded
+	}
+
+	err := checkSegmentSize(&seg)
 	if err != nil {
-		lfs.mu.Unlock()
-		return err
+		return 0, err
 	}
 
-	lfs.mu.Lock()
-	inode := &INode{
-		RegionID:  lfs.regionID,
-		Position:  lfs.offset,
-		Length:    seg.Size(),
-		CreatedAt: seg.CreatedAt,
-		ExpiredAt: seg.ExpiredAt,
+	err = lfs.checkDiskQuota(&seg)
+	if err != nil {
+		return 0, err
+	}
+
+	// 写入限速在获取锁之前等待，不会阻塞其他的读请求，写入失败时归还预支的令牌
+	err = lfs.limiter.waitContext(ctx, int(seg.Size()))
+	if err != nil {
+		return 0, err
 	}
-	lfs.offset += uint64(seg.Size())
-	lfs.mu.Unlock()
 
+	// 追加记录到更新索引期间持有 appendMu 的读锁，导出索引快照时记录的写入位置之前的记录一定已经更新了索引
+	lfs.appendMu.RLock()
+
+	// 追加记录和替换索引期间持有分片锁，同一个 Key 的索引更新顺序和记录在数据文件中的顺序一致
+	// 并发写入同一个 Key 时索引总是指向版本号最大的记录，和崩溃恢复重放的结果相同
 	shard.mu.Lock()
-	shard.index[inum] = inode
+	if cond != nil {
+		current, ok := shard.get(inum)
+		if ok && isExpired(current.ExpiredAt) {
+			current = nil
+		}
+		err = cond(current)
+		if err != nil {
+			shard.mu.Unlock()
+			lfs.appendMu.RUnlock()
+			lfs.limiter.refund(int(seg.Size()))
+			return 0, err
+		}
+	}
+
+	inode, err := lfs.appendSegment(&seg)
+	if err != nil {
+		shard.mu.Unlock()
+		lfs.appendMu.RUnlock()
+		lfs.limiter.refund(int(seg.Size()))
+		return 0, err
+	}
+	old := replaceIndex(shard, inum, &seg, inode)
 	shard.mu.Unlock()
 
+	lfs.releaseIndex(inum, &seg, inode, old)
+	lfs.appendMu.RUnlock()
+
+	return inode.Version, lfs.auditSegments(ctx, &seg)
+}
+
+// Version 返回 Key 当前的版本号，只访问内存索引，旧格式版本数据文件中的记录版本号为 0
+func (lfs *LogStructuredFS) Version(inum uint64) (uint64, bool) {
+	inode, ok := lfs.GetINode(inum)
+	if !ok || isExpired(inode.ExpiredAt) {
+		return 0, false
+	}
+	return inode.Version, true
+}
+
+// DelSegment 会向 LogStructuredFS 虚拟文件系统插入一条删除记录
+func (lfs *LogStructuredFS) DelSegment(key string) error {
+	return lfs.AddSegment(InodeNum(key), *NewTombstoneSegment([]byte(key)), 0)
+}
+
+// lockShards 按照分片编号从小到大获取 inums 所在索引分片的写锁，返回释放所有锁的函数
+// 一次写入多个 Key 时追加记录到替换索引期间需要持有所有相关分片的锁，按照顺序获取不会死锁
+func (lfs *LogStructuredFS) lockShards(inums ...uint64) func() {
+	set := make(map[int]bool, len(inums))
+	for _, inum := range inums {
+		set[int(inum%uint64(indexShard))] = true
+	}
+	shards := make([]int, 0, len(set))
+	for i := range set {
+		shards = append(shards, i)
+	}
+	sort.Ints(shards)
+
+	for _, i := range shards {
+		lfs.indexs[i].mu.Lock()
+	}
+	return func() {
+		for _, i := range shards {
+			lfs.indexs[i].mu.Unlock()
+		}
+	}
+}
+
+// replaceIndex 用新写入的记录替换分片中的索引，返回被替换的旧索引，调用方需要持有分片锁
+func replaceIndex(shard *indexMap, inum uint64, seg *Segment, inode *INode) *INode {
+	old, _ := shard.get(inum)
+	if seg.IsTombstone() {
+		shard.remove(inum)
+	} else {
+		shard.set(inum, inode)
+	}
+	return old
+}
+
+// releaseIndex 在索引更新之后调整索引内存、过期队列、垃圾统计和缓存
+func (lfs *LogStructuredFS) releaseIndex(inum uint64, seg *Segment, inode, old *INode) {
+	if old != nil {
+		lfs.indexBytes.Add(-inodeMemory(len(old.Key)))
+	}
+	if !seg.IsTombstone() {
+		lfs.indexBytes.Add(inodeMemory(len(inode.Key)))
+		lfs.expiry.push(inum, inode.ExpiredAt)
+	}
+
+	lfs.markDead(old)
+	if seg.IsTombstone() {
+		// 删除记录本身不会被索引引用
+		lfs.markDead(inode)
+	}
+
+	// 旧的缓存记录已经失效了
+	lfs.cache.remove(inum)
+}
+
+// SetWriteLimit 运行时修改每秒写入的字节数和次数限制，0 表示不限制
+// 垃圾回收迁移数据不受写入限速的影响
+func (lfs *LogStructuredFS) SetWriteLimit(bytesPerSec, opsPerSec int64) {
+	lfs.limiter.setLimit(bytesPerSec, opsPerSec)
+}
+
+// appendSegment 将 Segment 追加到活跃的数据文件中，并返回记录所在位置的 INode
+func (lfs *LogStructuredFS) appendSegment(seg *Segment) (*INode, error) {
+	inodes, err := lfs.appendSegments(seg)
+	if err != nil {
+		return nil, err
+	}
+	return inodes[0], nil
+}
+
+// appendSegments 将多个 Segment 通过一次写入追加到活跃的数据文件中，它们一定在同一个数据文件中
+// 如果活跃的数据文件超过了阀值就切换一个新的活跃数据文件
+// 没有版本号的记录会按顺序分配新的版本号，压缩迁移的记录保留原来的版本号
+func (lfs *LogStructuredFS) appendSegments(segs ...*Segment) ([]*INode, error) {
+	lfs.mu.Lock()
+	defer lfs.mu.Unlock()
+
+	if lfs.readOnly {
+		return nil, ErrReadOnly
+	}
+	if lfs.closed {
+		return nil, ErrClosed
+	}
+
+	seq := lfs.sequence.Load()
+	var assigned []*Segment
+	for _, seg := range segs {
+		if seg.Version == 0 {
+			seq++
+			seg.Version = seq
+			assigned = append(assigned, seg)
+		}
+	}
+
+	err := appendBinaryToFile(lfs.active, lfs.checksum, segs...)
+	if err != nil {
+		// 写入失败的记录不能带着已经分配的版本号重试
+		for _, seg := range assigned {
+			seg.Version = 0
+		}
+		return nil, err
+	}
+
+	lfs.sequence.Store(seq)
+	if lfs.syncPolicy == SyncAlways {
+		err = lfs.syncActive()
+		if err != nil {
+			return nil, fmt.Errorf("failed to sync active region: %w", err)
+		}
+		lfs.notifySynced(seq)
+	}
+
+	inodes := make([]*INode, len(segs))
+	for i, seg := range segs {
+		inodes[i] = &INode{
+			RegionID:  lfs.regionID,
+			Position:  lfs.offset,
+			Length:    seg.Size(),
+			CreatedAt: seg.CreatedAt,
+			ExpiredAt: seg.ExpiredAt,
+			Version:   seg.Version,
+			Key:       string(seg.Key),
+		}
+		lfs.offset += int64(seg.Size())
+		lfs.regionUsage(lfs.regionID).live += int64(seg.Size())
+	}
+
+	if lfs.paranoid {
+		lfs.verifyAppended(segs, inodes)
+	}
+
+	if lfs.lsm != nil {
+		lfs.lsm.apply(lfs.regionID, segs, inodes)
+	}
+
+	if lfs.offset >= regionThreshold {
+		err = lfs.changeRegions()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return inodes, nil
+}
+
+// markDead 将被覆盖或者删除的旧记录标记为垃圾数据
+func (lfs *LogStructuredFS) markDead(inode *INode) {
+	if inode == nil {
+		return
+	}
+	lfs.mu.Lock()
+	defer lfs.mu.Unlock()
+	usage := lfs.regionUsage(inode.RegionID)
+	usage.live -= int64(inode.Length)
+	usage.dead += int64(inode.Length)
+}
+
+// regionUsage 返回数据文件的使用情况，调用方需要持有 lfs.mu 锁
+func (lfs *LogStructuredFS) regionUsage(regionID uint64) *regionUsage {
+	usage, ok := lfs.usage[regionID]
+	if !ok {
+		usage = new(regionUsage)
+		lfs.usage[regionID] = usage
+	}
+	return usage
+}
+
+// rebuildRegionUsage 在索引恢复之后根据内存索引重新统计每个数据文件的使用情况和索引占用的内存
+// 数据文件中除了文件头和有效数据之外都是垃圾数据
+func (lfs *LogStructuredFS) rebuildRegionUsage() error {
+	lfs.mu.Lock()
+	defer lfs.mu.Unlock()
+
+	var indexBytes int64
+	lfs.usage = make(map[uint64]*regionUsage, len(lfs.regions)+1)
+	for _, shard := range lfs.indexs {
+		shard.mu.RLock()
+		shard.each(func(inum uint64, inode *INode) bool {
+			lfs.regionUsage(inode.RegionID).live += int64(inode.Length)
+			indexBytes += inodeMemory(len(inode.Key))
+			lfs.expiry.push(inum, inode.ExpiredAt)
+			return true
+		})
+		shard.mu.RUnlock()
+	}
+	lfs.indexBytes.Store(indexBytes)
+
+	files := make(map[uint64]vfsFile, len(lfs.regions)+1)
+	for regionID, fd := range lfs.regions {
+		files[regionID] = fd
+	}
+	files[lfs.regionID] = lfs.active
+
+	sizes := make(map[uint64]int64, len(files)+len(lfs.cold))
+	for regionID, fd := range files {
+		finfo, err := fd.Stat()
+		if err != nil {
+			return fmt.Errorf("failed to get region file info: %w", err)
+		}
+		sizes[regionID] = finfo.Size()
+	}
+	for regionID, fd := range lfs.cold {
+		sizes[regionID] = fd.Size()
+	}
+
+	for regionID, size := range sizes {
+		usage := lfs.regionUsage(regionID)
+		usage.dead = size - int64(len(dataFileMetadata)) - usage.live
+		if usage.dead < 0 {
+			usage.dead = 0
+		}
+	}
+
 	return nil
 }
 
+// FetchSegment 通过 inum 找到对应的 INode 并且从数据文件中读取 Segment 记录
+func (lfs *LogStructuredFS) FetchSegment(inum uint64) (*Segment, error) {
+	return lfs.FetchSegmentContext(context.Background(), inum)
+}
+
+// FetchSegmentContext 和 FetchSegment 一样，但是 ctx 已经取消时不会再读取磁盘
+func (lfs *LogStructuredFS) FetchSegmentContext(ctx context.Context, inum uint64) (*Segment, error) {
+	defer lfs.latency.observe(LatencyGet, time.Now())
+
+	ctx, span := lfs.startSpan(ctx, spanGet)
+	seg, err := lfs.fetchSegment(ctx, inum, span)
+	endSpan(span, err)
+	return seg, err
+}
+
+// fetchSegment 读取 Segment 记录，span 不为空时记录缓存命中、读取的字节数和解码耗时
+func (lfs *LogStructuredFS) fetchSegment(ctx context.Context, inum uint64, span Span) (*Segment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// 已经过期但是还没有被后台删除的记录对调用方不可见
+	if seg, ok := lfs.cache.get(inum); ok && !isExpired(seg.ExpiredAt) {
+		if span != nil {
+			span.SetAttributes(boolAttr("vfs.cache_hit", true), int64Attr("vfs.bytes", int64(len(seg.Value))))
+		}
+		return seg, nil
+	}
+
+	inode, ok := lfs.GetINode(inum)
+	if !ok || isExpired(inode.ExpiredAt) {
+		return nil, ErrSegmentNotFound
+	}
+	return lfs.readINode(inum, inode, span)
+}
+
+// readINode 读取 inode 指向的记录并且解码，放入缓存之后返回，FetchSegment 和 FetchSegments 共用
+// 读取的过程中数据文件可能被压缩或者迁移到 Backend 中关闭了，重新查找索引，索引没有变化时才返回错误
+func (lfs *LogStructuredFS) readINode(inum uint64, inode *INode, span Span) (*Segment, error) {
+	for {
+		var (
+			seg    *Segment
+			length int64
+			err    error
+		)
+		fd, version, checksum, ok := lfs.regionFile(inode.RegionID)
+		if !ok {
+			err = fmt.Errorf("region file not found for region id: %d", inode.RegionID)
+		} else if seg = lfs.lsm.get(inode); seg == nil {
+			// LSM 模式中还没有刷新的记录直接从内存表读取，其他记录从数据文件读取
+			_, seg, length, err = readRawSegment(fd, inode.Position, version, checksum)
+			if err != nil && !errors.Is(err, os.ErrClosed) {
+				return nil, fmt.Errorf("failed to fetch segment (inum: %d): %w", inum, err)
+			}
+		}
+
+		if err != nil {
+			// 连续的压缩可能再次删除记录刚刚迁移到的数据文件，只要索引还在变化就继续重试
+			fresh, ok := lfs.GetINode(inum)
+			if !ok || isExpired(fresh.ExpiredAt) {
+				return nil, ErrSegmentNotFound
+			}
+			if fresh.RegionID == inode.RegionID && fresh.Position == inode.Position {
+				return nil, fmt.Errorf("failed to fetch segment (inum: %d): %w", inum, err)
+			}
+			inode = fresh
+			continue
+		}
+
+		// 解码和 readSegment 一样，分开执行才能单独统计解压和解密的耗时
+		start := time.Now()
+		seg.Value, err = transformer.decode(seg.Flags, seg.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch segment (inum: %d): failed to transformer decode value in segment: %w", inum, err)
+		}
+		if span != nil {
+			span.SetAttributes(
+				boolAttr("vfs.cache_hit", false),
+				int64Attr("vfs.region", int64(inode.RegionID)),
+				int64Attr("vfs.bytes", int64(len(seg.Value))),
+				int64Attr("vfs.disk_bytes", length),
+				durationAttr("vfs.decode_us", time.Since(start)),
+			)
+		}
+
+		lfs.cacheSegment(inum, inode, seg)
+
+		// 从 Backend 中读取的记录重新写回活跃数据文件，冷数据被访问之后重新变成热数据
+		if lfs.isColdRegion(inode.RegionID) && !lfs.readOnly {
+			err := lfs.promoteSegment(inum, inode)
+			if err != nil {
+				clog.Warnf("failed to promote cold segment (inum: %d): %v", inum, err)
+			}
+		}
+
+		return seg, nil
+	}
+}
+
+// cacheSegment 把从磁盘读取的记录放入缓存，持有分片的读锁确认索引仍然指向读取的 inode
+// 写入先在分片锁中替换索引再使缓存失效，读取磁盘之后索引被替换时不会把旧的记录放入缓存
+func (lfs *LogStructuredFS) cacheSegment(inum uint64, inode *INode, seg *Segment) {
+	if !lfs.cache.enabled() {
+		return
+	}
+	shard := lfs.indexs[inum%uint64(indexShard)]
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	if current, ok := shard.get(inum); ok && sameINode(current, inode) {
+		lfs.cache.add(inum, seg)
+	}
+}
+
+// regionFile 找到 regionID 对应的数据文件和它的格式版本以及校验码算法，活跃的数据文件不一定在 regions 中
+func (lfs *LogStructuredFS) regionFile(regionID uint64) (io.ReaderAt, uint8, Checksum, bool) {
+	lfs.mu.Lock()
+	defer lfs.mu.Unlock()
+	if regionID == lfs.regionID && lfs.active != nil {
+		return lfs.active, currentFormat, lfs.checksum, true
+	}
+	lfs.touchRegion(regionID)
+	if fd, ok := lfs.regions[regionID]; ok {
+		return fd, lfs.versions[regionID], lfs.checksums[regionID], true
+	}
+	// 已经迁移到 Backend 中的数据文件
+	fd, ok := lfs.cold[regionID]
+	return fd, lfs.versions[regionID], lfs.checksums[regionID], ok
+}
+
+// indexMemory 返回当前内存索引估算占用的字节数
+// 基数树索引共享了 Key 的前缀，直接累加每个分片中基数树的大小
+func (lfs *LogStructuredFS) indexMemory() int64 {
+	if lfs.indexKind != IndexRadix {
+		return lfs.indexBytes.Load()
+	}
+	var bytes int64
+	for _, shard := range lfs.indexs {
+		bytes += shard.trie.bytes.Load()
+	}
+	return bytes
+}
+
+// GetINode 返回 inum 对应的内存索引，使用 IndexRadix 时返回的是重新拼接出 Key 的副本
 func (lfs *LogStructuredFS) GetINode(inum uint64) (*INode, bool) {
 	shard := lfs.indexs[inum%uint64(indexShard)]
 	shard.mu.RLock()
 	defer shard.mu.RUnlock()
-	inode, exists := shard.index[inum]
-	return inode, exists
+	return shard.get(inum)
 }
 
 func (lfs *LogStructuredFS) BatchINodes(inodes ...*INode) {
 
 }
 
+// InodeNum 返回 Key 的 64 位 FNV-1a 哈希，和 hash/fnv 的结果相同，直接计算不需要分配内存
 func InodeNum(key string) uint64 {
-	h := fnv.New64a()
-	h.Write([]byte(key))
-	return h.Sum64()
+	h := uint64(fnvOffset64)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= fnvPrime64
+	}
+	return h
 }
 
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
 func (lfs *LogStructuredFS) ChangeRegions() error {
 	lfs.mu.Lock()
 	defer lfs.mu.Unlock()
+	return lfs.changeRegions()
+}
 
-	err := lfs.active.Sync()
+// changeRegions 将活跃的数据文件封存并创建新的活跃数据文件，调用方需要持有 lfs.mu 锁
+func (lfs *LogStructuredFS) changeRegions() error {
+	err := lfs.sealActive()
 	if err != nil {
 		return fmt.Errorf("failed to change active regions: %w", err)
 	}
 
-	lfs.regions[lfs.regionID] = lfs.active
-
 	err = lfs.createActiveRegion()
 	if err != nil {
 		return fmt.Errorf("failed to chanage active regions: %w", err)
@@ -149,6 +815,27 @@ func (lfs *LogStructuredFS) ChangeRegions() error {
 	return nil
 }
 
+// sealActive 刷盘并封存活跃数据文件，调用方需要持有 lfs.mu 锁，之后需要调用 createActiveRegion
+// LSM 模式中冻结它的内存表，并且预留下一个编号给刷新之后的排序数据文件
+func (lfs *LogStructuredFS) sealActive() error {
+	err := lfs.syncActive()
+	if err != nil {
+		return err
+	}
+	lfs.notifySynced(lfs.LastSequence())
+
+	lfs.regions[lfs.regionID] = lfs.active
+	lfs.touchRegion(lfs.regionID)
+	lfs.generateHint(lfs.regionID, lfs.active)
+
+	if lfs.lsm != nil {
+		lfs.lsm.freeze(lfs.regionID)
+		lfs.regionID++
+	}
+
+	return nil
+}
+
 func (lfs *LogStructuredFS) createActiveRegion() error {
 	lfs.regionID += 1
 	fileName, err := generateFileName(lfs.regionID)
@@ -156,22 +843,32 @@ func (lfs *LogStructuredFS) createActiveRegion() error {
 		return fmt.Errorf("failed to new active region name: %w", err)
 	}
 
-	active, err := os.OpenFile(filepath.Join(lfs.directory, fileName), RWCA, fsPerm)
+	active, err := lfs.openRegionFile(filepath.Join(lfs.directory, fileName))
 	if err != nil {
 		return fmt.Errorf("failed to create active region: %w", err)
 	}
 
-	n, err := active.Write(dataFileMetadata)
+	metadata := fileMetadata(currentFormat, lfs.checksum)
+	n, err := active.Write(metadata)
 	if err != nil {
 		return fmt.Errorf("failed to write active region metadata: %w", err)
 	}
 
-	if n != len(dataFileMetadata) {
+	if n != len(metadata) {
 		return errors.New("failed to active region metadata write")
 	}
 
+	if fd, ok := active.(*os.File); ok && lfs.prealloc {
+		err = preallocate(fd, regionThreshold)
+		if err != nil {
+			return fmt.Errorf("failed to preallocate active region: %w", err)
+		}
+	}
+
 	lfs.active = active
-	lfs.offset = uint64(len(dataFileMetadata))
+	lfs.versions[lfs.regionID] = currentFormat
+	lfs.checksums[lfs.regionID] = lfs.checksum
+	lfs.offset = int64(len(dataFileMetadata))
 
 	return nil
 }
@@ -187,7 +884,7 @@ func (lfs *LogStructuredFS) recoverRegions() error {
 	for _, file := range files {
 		if !file.IsDir() && strings.HasSuffix(file.Name(), fileExtension) {
 			if strings.HasPrefix(file.Name(), "0") {
-				regions, err := os.OpenFile(filepath.Join(lfs.directory, file.Name()), os.O_RDWR, fsPerm)
+				regions, err := os.OpenFile(filepath.Join(lfs.directory, file.Name()), os.O_RDWR, lfs.fsPerm)
 				if err != nil {
 					return fmt.Errorf("failed to open data file: %w", err)
 				}
@@ -196,11 +893,28 @@ func (lfs *LogStructuredFS) recoverRegions() error {
 				if err != nil {
 					return fmt.Errorf("failed to get regions id: %w", err)
 				}
+
+				version, checksum, err := readFileVersion(regions)
+				if err != nil {
+					return fmt.Errorf("failed to get regions format version: %w", err)
+				}
 				lfs.regions[regionID] = regions
+				lfs.versions[regionID] = version
+				lfs.checksums[regionID] = checksum
+
+				// 非活跃数据文件不会再被修改，修改时间可以作为最后一次被读取时间的初始值
+				if finfo, err := file.Info(); err == nil {
+					lfs.accessed[regionID] = finfo.ModTime()
+				}
 			}
 		}
 	}
 
+	err = lfs.recoverColdRegions()
+	if err != nil {
+		return fmt.Errorf("failed to recover backend regions: %w", err)
+	}
+
 	// 只有数据文件大于 1 时才找到最大的那个文件
 	if len(lfs.regions) >= 1 {
 		var regionIds []uint64
@@ -225,17 +939,32 @@ func (lfs *LogStructuredFS) recoverRegions() error {
 			return fmt.Errorf("failed to get region file info: %w", err)
 		}
 
-		if stat.Size() >= regionThreshold {
+		// 旧格式版本的数据文件只读不写，新的记录写入新格式版本的数据文件
+		// 旧格式的数据文件会在垃圾回收时被重写为新格式，校验码算法改变之后同样需要切换数据文件
+		if stat.Size() >= regionThreshold || lfs.versions[lfs.regionID] != currentFormat || lfs.checksums[lfs.regionID] != lfs.checksum {
 			return lfs.createActiveRegion()
 		} else {
+			// O_DSYNC 只能在打开文件的时候指定，需要重新打开一个用于写入的文件描述符
+			if lfs.syncMethod == SyncDsync && dsyncFlag != 0 {
+				active, err = os.OpenFile(active.Name(), lfs.activeFlag(), lfs.fsPerm)
+				if err != nil {
+					return fmt.Errorf("failed to reopen active region with O_DSYNC: %w", err)
+				}
+			}
 			offset, err := active.Seek(0, io.SeekEnd)
 			if err != nil {
 				return fmt.Errorf("failed to get region file offset: %w", err)
 			}
 			lfs.active = active
-			lfs.offset = uint64(offset)
+			lfs.offset = offset
 		}
 	} else {
+		// 本地没有数据文件时新的数据文件编号需要排在 Backend 中的数据文件之后
+		for regionID := range lfs.cold {
+			if regionID > lfs.regionID {
+				lfs.regionID = regionID
+			}
+		}
 		// 如果是空文件夹就创建的一个可写的数据文件
 		return lfs.createActiveRegion()
 	}
@@ -249,8 +978,8 @@ func (lfs *LogStructuredFS) recoverRegions() error {
 // bitcask 中 hint 文件是在压缩过程中生成 hint 快照
 // 并不能代表全部即时内存索引状态
 // vasedb 则完全设计了不同的方案，如果是 close 正常关闭的就会生成 index 文件
-// 如果数据文件有 index 文件则直接从 index 文件中恢复
-// 没有就在启动的全局扫描数据文件重新构建索引文件
+// 如果数据文件有 index 文件则直接从 index 文件中恢复，然后只重放快照导出之后追加的记录
+// 没有快照或者快照损坏、过期时就在启动的全局扫描数据文件重新构建索引文件
 func (lfs *LogStructuredFS) recoveryIndex() error {
 	lfs.mu.Lock()
 	defer lfs.mu.Unlock()
@@ -264,25 +993,49 @@ func (lfs *LogStructuredFS) recoveryIndex() error {
 		}
 		defer file.Close()
 
-		err = recoveryIndex(file, lfs.indexs)
-		if err != nil {
-			return fmt.Errorf("failed to recovery index mapping: %w", err)
+		// 旧格式版本的索引快照不能直接使用，需要全局扫描重建索引
+		if isIndexFileVersion(file) {
+			// 快照记录了导出时的写入位置，恢复之后继续保留，非正常关闭之后再打开只需要重放写入位置之后的记录
+			ok, err := lfs.recoverySnapshot(file)
+			if err != nil {
+				return err
+			}
+			if ok {
+				return nil
+			}
+		} else {
+			clog.Warnf("index snapshot %s version mismatch, rebuilding index from regions", filePath)
 		}
-
-		return nil
 	}
 
 	// 如果不存在索引文件就从 regions 文件全局扫描恢复
 	// 如果数据文件非常大，而且文件非常多，恢复多时间就越长
 	// 如果垃圾回收越频繁，你数据文件就变小，启动时间就越快
 	// 但是如果垃圾回收越频繁，可能会影响到整体数据读取写性能
-	return crashRecoveryAllIndex(lfs.regions, lfs.indexs)
+	sources, err := lfs.regionSources()
+	if err != nil {
+		return err
+	}
+	sequence, torn, err := crashRecoveryAllIndex(sources, lfs.versions, lfs.checksums, lfs.indexs, lfs.comparator, lfs.workers)
+	if err != nil {
+		return err
+	}
+	lfs.sequence.Store(sequence)
+
+	return lfs.truncateTornBatch(torn)
 }
 
 func (lfs *LogStructuredFS) SetCompressor(compressor Compressor) {
 	transformer.SetCompressor(compressor)
 }
 
+// SetAdaptiveCompression 开启自适应压缩，每条记录按照 Value 的可压缩程度选择压缩算法
+func (lfs *LogStructuredFS) SetAdaptiveCompression(opt AdaptiveOptions) {
+	transformer.SetAdaptiveCompression(opt)
+}
+
+// SetEncryptor 开启加密，进程中最后一个实例 CloseFS 之后会清零密钥
+// 打开之后才设置时已经完成了恢复，加密的 hint 文件和索引快照不能使用，重新打开时应该通过 Options.Encryptor 设置
 func (lfs *LogStructuredFS) SetEncryptor(encryptor Encryptor, secret []byte) error {
 	return transformer.SetEncryptor(encryptor, secret)
 }
@@ -306,22 +1059,17 @@ func (lfs *LogStructuredFS) StartRegionGC(cycle_second time.Duration) {
 					continue
 				}
 
-				// 执行 gc 垃圾回收逻辑
-				if len(lfs.regions) >= 3 {
-					var regionIds []uint64
-					for v := range lfs.regions {
-						regionIds = append(regionIds, v)
-					}
-					// 对 regionIds 切片从小到大排序
-					sort.Slice(regionIds, func(i, j int) bool {
-						return regionIds[i] < regionIds[j]
-					})
-					// 找到前两个旧数据文件
-					for i := 0; i < len(regionIds)-1; i++ {
-						lfs.dirtyRegion = append(lfs.dirtyRegion, lfs.regions[regionIds[i]])
+				// 执行 gc 垃圾回收逻辑，优先回收垃圾比例最高的数据文件
+				lfs.gcstate = GC_RUNNING
+				dirtyRegions := lfs.gcRegions(gcBatchSize)
+				if len(dirtyRegions) > 0 {
+					for _, regionID := range dirtyRegions {
+						err := lfs.compactRegion(regionID)
+						if err != nil {
+							clog.Errorf("failed to compact region %d: %v", regionID, err)
+							break
+						}
 					}
-					// 执行对旧数据文件的压缩
-					compressDirtyRegion(lfs.dirtyRegion, lfs.indexs, lfs.active, lfs.regionID)
 				} else {
 					clog.Warnf("dirty region (%d) does not meet garbage collection status", len(lfs.regions))
 				}
@@ -348,64 +1096,345 @@ func (lfs *LogStructuredFS) StopRegionGC() {
 	}
 }
 
-func (lfs *LogStructuredFS) RegionGCStatus() GC_STATUS {
-	return lfs.gcstate
+// CompactContext 立即压缩所有存在垃圾数据的非活跃数据文件，不需要等待 gc 周期
+// 压缩可能持续很长时间，ctx 取消时正在压缩的数据文件会被保留，已经压缩完成的数据文件不受影响
+func (lfs *LogStructuredFS) CompactContext(ctx context.Context) error {
+	return lfs.Compact(ctx, CompactOptions{})
 }
 
-func OpenFS(opt *Options) (*LogStructuredFS, error) {
-	// single region max size = 255GB
-	regionThreshold = int64(opt.Threshold) * GB
-
-	err := checkFileSystem(opt.Path)
+// Compact 按照 opt 选择的数据文件立即压缩，运维和测试可以不等后台 gc 策略直接合并指定的数据文件
+func (lfs *LogStructuredFS) Compact(ctx context.Context, opt CompactOptions) error {
+	regionIds := opt.FileIDs
+	if len(regionIds) == 0 {
+		for _, regionID := range lfs.dirtyRegions(0) {
+			if lfs.garbageRatio(regionID) >= opt.MaxDeadRatio {
+				regionIds = append(regionIds, regionID)
+			}
+		}
+	}
+
+	if opt.Sorted {
+		return lfs.compactSorted(ctx, regionIds, 0)
+	}
+
+	for _, regionID := range regionIds {
+		err := lfs.compactRegionContext(ctx, regionID)
+		if err != nil {
+			return fmt.Errorf("failed to compact region %d: %w", regionID, err)
+		}
+	}
+	return nil
+}
+
+// garbageRatio 返回数据文件中垃圾数据所占的比例
+func (lfs *LogStructuredFS) garbageRatio(regionID uint64) float64 {
+	lfs.mu.Lock()
+	defer lfs.mu.Unlock()
+	return lfs.regionUsage(regionID).garbageRatio()
+}
+
+func (lfs *LogStructuredFS) RegionGCStatus() GC_STATUS {
+	return lfs.gcstate
+}
+
+func OpenFS(opt *Options) (*LogStructuredFS, error) {
+	size, err := opt.segmentSize()
+	if err != nil {
+		return nil, err
+	}
+	regionThreshold = size
+
+	err = opt.Checksum.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateGCRatio(opt.GCRatio)
+	if err != nil {
+		return nil, err
+	}
+
+	if opt.InMemory && opt.ReadOnly {
+		return nil, errors.New("in-memory file system cannot be opened read-only")
+	}
+
+	err = opt.Engine.validate()
+	if err != nil {
+		return nil, err
+	}
+	if opt.InMemory && opt.Engine == EngineLSM {
+		return nil, errors.New("in-memory file system does not support lsm engine")
+	}
+
+	err = opt.Index.validate()
 	if err != nil {
 		return nil, err
 	}
 
+	if !opt.InMemory {
+		err = checkFileSystem(opt.Path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for kind, size := range opt.MaxValueSize {
+		SetMaxValueSize(kind, size)
+	}
+	SetMaxKeySize(opt.MaxKeySize)
+
+	// 恢复索引之前设置加密和压缩，否则加密的 hint 文件和索引快照不能读取，只能全局扫描数据文件
+	if opt.Compressor != nil {
+		transformer.SetCompressor(opt.Compressor)
+	}
+	if opt.Encryptor != nil {
+		err = transformer.SetEncryptor(opt.Encryptor, opt.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set encryptor: %w", err)
+		}
+		// 打开失败并且没有其他打开的实例时清零密钥
+		defer func() {
+			if err != nil && openInstances.Load() <= 0 {
+				transformer.WipeSecret()
+			}
+		}()
+	}
+
+	// 内存模式不使用数据目录，也就不需要目录锁
+	// 只读实例持有共享锁，可以和写入进程以及其他只读实例同时打开数据目录
+	var lock *dirLock
+	if opt.ReadOnly {
+		lock, err = lockDirShared(opt.Path)
+		if err != nil {
+			return nil, err
+		}
+	} else if !opt.InMemory {
+		lock, err = lockDir(opt.Path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	fsPerm = opt.FsPerm
+
+	comparator := opt.Comparator
+	if opt.InMemory {
+		if comparator == nil {
+			comparator = BytewiseComparator
+		}
+	} else {
+		comparator, err = openComparator(opt.Path, comparator, opt.ReadOnly, opt.FsPerm)
+		if err != nil {
+			_ = lock.unlock()
+			return nil, err
+		}
+	}
+
 	instance = &LogStructuredFS{
-		indexs:    make([]*indexMap, indexShard),
-		regions:   make(map[uint64]*os.File, 10),
-		offset:    uint64(len(dataFileMetadata)),
-		regionID:  0,
-		directory: opt.Path,
-		gcstate:   GC_INIT,
+		indexs:       make([]*indexMap, indexShard),
+		regions:      make(map[uint64]vfsFile, 10),
+		offset:       int64(len(dataFileMetadata)),
+		regionID:     0,
+		directory:    opt.Path,
+		fsPerm:       opt.FsPerm,
+		gcstate:      GC_INIT,
+		cache:        newSegmentCache(opt.MaxCacheMemory, opt.Eviction),
+		maxIndexMem:  opt.MaxIndexMemory,
+		usage:        make(map[uint64]*regionUsage, 10),
+		versions:     make(map[uint64]uint8, 10),
+		checksums:    make(map[uint64]Checksum, 10),
+		checksum:     opt.Checksum,
+		lock:         lock,
+		backend:      opt.Backend,
+		archive:      opt.Archive,
+		cold:         make(map[uint64]BackendFile),
+		accessed:     make(map[uint64]time.Time),
+		limiter:      newWriteLimiter(opt.WriteBytesPerSec, opt.WriteOpsPerSec),
+		syncPolicy:   opt.Sync,
+		syncInterval: opt.SyncInterval,
+		gcRatio:      opt.GCRatio,
+		tracer:       opt.Tracer,
+		latency:      newLatencyStats(),
+		syncMethod:   opt.SyncMethod,
+		prealloc:     opt.Preallocate,
+		inMemory:     opt.InMemory,
+		paranoid:     opt.Paranoid,
+		maxDiskBytes: opt.MaxDiskBytes,
+		readOnly:     opt.ReadOnly,
+		stale:        make(map[uint64]uint64),
+		sparse:       make(map[uint64]*sparseIndex),
+		comparator:   comparator,
+		keyTransform: opt.KeyTransform,
+		indexKind:    opt.Index,
+		workers:      opt.RecoveryWorkers,
+		syncNotify:   make(chan struct{}),
+		expiry:       newExpireQueue(),
 	}
 
 	for i := 0; i < indexShard; i++ {
-		instance.indexs[i] = &indexMap{
-			mu:    sync.RWMutex{},
-			index: make(map[uint64]*INode, 100000),
+		instance.indexs[i] = newIndexMap(opt.Index, 100000)
+	}
+
+	if opt.InMemory {
+		// 内存模式每次打开都是空的，直接创建一个活跃数据文件
+		err = instance.createActiveRegion()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create memory region: %w", err)
+		}
+	} else if opt.ReadOnly {
+		// 加载失败时 closeReadOnly 会释放引用
+		openInstances.Add(1)
+		err = instance.Refresh()
+		if err != nil {
+			_ = instance.closeReadOnly()
+			return nil, fmt.Errorf("failed to load sealed regions: %w", err)
+		}
+		instance.startRefreshDaemon(opt.RefreshInterval)
+		return instance, nil
+	} else {
+		// 先对已有的数据文件执行恢复操作，并且初始化内存中的数据版本号
+		err = instance.recoverRegions()
+		if err != nil {
+			_ = lock.unlock()
+			return nil, fmt.Errorf("failed to recover data regions: %w", err)
+		}
+
+		var start time.Time
+		if opt.VerifyOnOpen {
+			start = time.Now()
+			instance.mu.Lock()
+			instance.report, err = instance.verifyRegions()
+			instance.mu.Unlock()
+			if err != nil {
+				_ = lock.unlock()
+				return nil, fmt.Errorf("failed to verify data regions: %w", err)
+			}
+		}
+
+		err = instance.recoveryIndex()
+		if err != nil {
+			_ = lock.unlock()
+			return nil, fmt.Errorf("failed to recover regions index: %w", err)
+		}
+
+		if instance.report != nil {
+			instance.report.IndexEntries = instance.Count()
+			instance.report.Duration = time.Since(start)
+			clog.Infof("startup consistency check: %s", instance.report)
+			for _, c := range instance.report.Corrupted {
+				clog.Warnf("corrupted record in region %d at offset %d (truncated: %t): %v", c.RegionID, c.Offset, c.Truncated, c.Err)
+			}
 		}
 	}
 
-	// 先对已有的数据文件执行恢复操作，并且初始化内存中的数据版本号
-	err = instance.recoverRegions()
+	err = instance.rebuildRegionUsage()
 	if err != nil {
-		return nil, fmt.Errorf("failed to recover data regions: %w", err)
+		_ = lock.unlock()
+		return nil, fmt.Errorf("failed to rebuild regions usage: %w", err)
 	}
 
-	err = instance.recoveryIndex()
-	if err != nil {
-		return nil, fmt.Errorf("failed to recover regions index: %w", err)
+	if opt.AuditLog != "" {
+		instance.audit, err = openAuditLog(opt.AuditLog, opt.FsPerm)
+		if err != nil {
+			_ = lock.unlock()
+			return nil, err
+		}
+	}
+
+	if opt.Sync == SyncInterval {
+		instance.startSyncDaemon(opt.SyncInterval)
 	}
+	instance.startExpireDaemon()
+	instance.startSnapshotDaemon(opt.SnapshotInterval)
+	if opt.Engine == EngineLSM {
+		instance.openLSM()
+	}
+
+	openInstances.Add(1)
 
 	// 单例子模式，但是挡不住其他包通过 new(LogStructuredFS) 也能创建一个实例，那这样根本不起作用了
 	return instance, nil
 }
 
+// releaseSecret 在实例关闭时调用，进程中最后一个打开的实例关闭之后清零 transformer 中的密钥
+func releaseSecret() {
+	if openInstances.Add(-1) <= 0 {
+		transformer.WipeSecret()
+	}
+}
+
 // 关闭之前一定要检查 gc 是否在执行，如果 gc 在执行千万不要盲目的关闭
+// CloseFS 等待正在执行的写入完成，之后的写入返回 ErrClosed，然后刷盘活跃数据文件、导出索引快照并且释放目录锁
+// 正常关闭之后再打开直接从索引快照恢复，不需要全局扫描数据文件
 func (lfs *LogStructuredFS) CloseFS() error {
+	// 定期备份会读取数据文件，关闭之前先取消正在执行的备份
+	lfs.StopBackupSchedule()
+	if lfs.readOnly {
+		return lfs.closeReadOnly()
+	}
+
+	// 后台刷盘和迁移冷数据都需要获取 lfs.mu，必须在加锁之前停止
+	lfs.stopSnapshotDaemon()
+	lfs.stopSyncDaemon()
+	lfs.stopExpireDaemon()
+	lfs.stopLSM()
+	lfs.StopTiering()
+	lfs.emergencyWg.Wait()
+
+	// 所有的写入都在持有 lfs.mu 时追加到活跃数据文件，拿到锁时正在执行的写入已经完成
+	lfs.mu.Lock()
+	if lfs.closed {
+		lfs.mu.Unlock()
+		return ErrClosed
+	}
+	lfs.closed = true
+	seq := lfs.LastSequence()
+	err := lfs.syncActive()
+	lfs.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to sync active region: %w", err)
+	}
+	lfs.notifySynced(seq)
+
+	// hint 文件和索引快照写入之后就不再需要密钥，返回之前清零
+	defer releaseSecret()
+
+	// 已经关闭之后不会再封存新的数据文件
+	lfs.hintWg.Wait()
+
+	// 导出快照需要索引分片的锁，持有 lfs.mu 时获取会和写入的加锁顺序相反
+	// 已经追加完成的写入会在释放分片锁之前更新索引，快照一定包含它们
+	err = lfs.ExportSnapshotIndex()
+	if err != nil {
+		return err
+	}
+
 	lfs.mu.Lock()
 	defer lfs.mu.Unlock()
 	for _, file := range lfs.regions {
-		err := utils.CloseFile(file)
+		err := closeFile(file)
 		if err != nil {
 			return fmt.Errorf("failed to close region file: %w", err)
 		}
 	}
+	for _, file := range lfs.cold {
+		err := file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to close backend region file: %w", err)
+		}
+	}
 
-	// 如果有 index 文件的快照，就从 index 文件快照进行恢复，如果没有就全局扫描
-	return lfs.ExportSnapshotIndex()
+	err = lfs.audit.close()
+	if err != nil {
+		return fmt.Errorf("failed to close audit log: %w", err)
+	}
+
+	return lfs.lock.unlock()
+}
+
+// Close 实现 io.Closer，等同于 CloseFS
+func (lfs *LogStructuredFS) Close() error {
+	return lfs.CloseFS()
 }
 
 // ExportSnapshotIndex 是正常程序退出是所做的操作，导出内存索引快照到磁盘文件
@@ -413,123 +1442,262 @@ func (lfs *LogStructuredFS) CloseFS() error {
 // 例如 RAM 512 MB < 1GB，如果 1GB 快照不能全部序列化到磁盘上，
 // 映射大文件到内存可能不是一个好的选择，因为它会占用大量的虚拟内存空间，会出现 swap 交换内存页。
 func (lfs *LogStructuredFS) ExportSnapshotIndex() error {
+	// 内存模式没有需要恢复的数据文件，也就不需要索引快照
+	if lfs.inMemory {
+		return nil
+	}
+	if lfs.readOnly {
+		return ErrReadOnly
+	}
+
+	// 记录写入位置之后先刷盘活跃数据文件，快照不会指向崩溃之后丢失的记录
+	w := lfs.captureWatermark()
+	err := lfs.Sync()
+	if err != nil {
+		return err
+	}
+
+	// 先写入临时文件再替换，导出过程中崩溃不会留下不完整的快照
 	filePath := filepath.Join(lfs.directory, indexFileName)
-	fd, err := os.OpenFile(filePath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, fsPerm)
+	fd, err := os.OpenFile(filePath+".tmp", os.O_CREATE|os.O_RDWR|os.O_TRUNC, lfs.fsPerm)
 	if err != nil {
 		return fmt.Errorf("failed to generate index snapshot file: %w", err)
 	}
-	defer utils.CloseFile(fd)
 
+	err = lfs.writeSnapshotIndex(fd, w)
+	if err == nil {
+		err = fd.Sync()
+	}
+	if cerr := utils.CloseFile(fd); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(filePath + ".tmp")
+		return err
+	}
+
+	err = os.Rename(filePath+".tmp", filePath)
+	if err != nil {
+		return fmt.Errorf("failed to replace index snapshot file: %w", err)
+	}
+
+	return nil
+}
+
+// writeSnapshotIndex 将文件头、导出时的写入位置和所有的内存索引写入 fd
+// 写入位置之后追加的记录可能已经在索引中，恢复时按照顺序重放这些记录之后结果是一样的
+func (lfs *LogStructuredFS) writeSnapshotIndex(fd io.Writer, w *snapshotWatermark) error {
 	// 写入元数据
-	n, err := fd.Write(dataFileMetadata)
+	n, err := fd.Write(indexFileMetadata)
 	if err != nil {
 		return fmt.Errorf("failed to write index file metadata: %w", err)
 	}
 
-	if n != len(dataFileMetadata) {
+	if n != len(indexFileMetadata) {
 		return errors.New("index file metadata write incomplete")
 	}
 
-	// 遍历分片索引并写入
+	// 元数据之后保存写入位置和最后分配的版本号，恢复之后新的写入继续递增
+	_, err = fd.Write(w.encode())
+	if err != nil {
+		return fmt.Errorf("failed to write index file watermark: %w", err)
+	}
+
+	// 遍历分片索引并写入，每个分片写完之后就释放锁，写入位置之后的修改恢复时会重新重放
+	bw := bufio.NewWriterSize(fd, int(snapshotReadBuffer))
+	block := make([]byte, 0, snapshotBlockSize+4096)
+	flush := func() error {
+		data, err := transformer.encodeMetadata(block)
+		if err != nil {
+			return err
+		}
+		var header [4]byte
+		binary.LittleEndian.PutUint32(header[:], uint32(len(data)))
+		if _, err := bw.Write(header[:]); err != nil {
+			return fmt.Errorf("failed to write index block: %w", err)
+		}
+		if _, err := bw.Write(data); err != nil {
+			return fmt.Errorf("failed to write index block: %w", err)
+		}
+		block = block[:0]
+		return nil
+	}
+
 	for _, indexs := range lfs.indexs {
 		indexs.mu.RLock()
-		defer indexs.mu.RUnlock()
-		for inum, inode := range indexs.index {
-			bytes, err := serializedIndex(inum, inode)
-			if err != nil {
-				return fmt.Errorf("failed to serialized index (inum: %d): %w", inum, err)
-			}
-			_, err = fd.Write(bytes)
-			if err != nil {
-				return fmt.Errorf("failed to write serialized index (inum: %d): %w", inum, err)
+		var err error
+		indexs.each(func(inum uint64, inode *INode) bool {
+			block = appendSerializedIndex(block, inum, inode)
+			if len(block) >= int(snapshotBlockSize) {
+				err = flush()
 			}
+			return err == nil
+		})
+		indexs.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(block) > 0 {
+		err = flush()
+		if err != nil {
+			return err
 		}
 	}
 
+	err = bw.Flush()
+	if err != nil {
+		return fmt.Errorf("failed to write serialized index: %w", err)
+	}
 	return nil
 }
 
-func recoveryIndex(fd *os.File, indexs []*indexMap) error {
-	// 在恢复操作的时候不需要上锁
-	offset := int64(len(dataFileMetadata))
+// 索引快照中的索引按照块保存，每个块使用 Transformer 当前的压缩和加密设置单独处理，恢复时多个块并行解码
+// | BLEN 4 | CODEC 1 | CRYPT 1 | BLOCK ? | ...，BLOCK 解密和解压之后是若干条完整的索引记录
+const (
+	snapshotReadBuffer = 1 * MB
+	snapshotBlockSize  = 1 * MB // 块压缩之前的大小
+)
 
+// recoveryIndex 从索引快照 offset 处开始恢复内存索引
+// 当前 goroutine 顺序读取快照中的块，多个 goroutine 并行解压和校验，每个分片由一个 goroutine 写入，分片之间不需要加锁
+func recoveryIndex(fd *os.File, offset int64, indexs []*indexMap) error {
+	// 在恢复操作的时候不需要上锁
 	finfo, err := fd.Stat()
 	if err != nil {
 		return err
 	}
 
-	type index struct {
-		inum  uint64
-		inode *INode
+	// 只保留第一个错误，出错之后停止读取剩下的块
+	var (
+		mu     sync.Mutex
+		first  error
+		failed atomic.Bool
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if first == nil {
+			first = err
+		}
+		mu.Unlock()
+		failed.Store(true)
 	}
 
-	// 共享并行处理的数据
-	nqueue := make(chan index, (finfo.Size()-offset)/48)
-	// 定义一个错误通道，用于捕获 goroutine 中的错误
-	// 只捕获到第一个错误，一旦有错误全局停止直接返回
-	equeue := make(chan error, 1)
+	var shards sync.WaitGroup
+	queues := make([]chan []snapshotEntry, len(indexs))
+	for i := range indexs {
+		queues[i] = make(chan []snapshotEntry, 4)
+		shards.Add(1)
+		go func(imap *indexMap, queue chan []snapshotEntry) {
+			defer shards.Done()
+			for batch := range queue {
+				for _, entry := range batch {
+					imap.set(entry.inum, entry.inode)
+				}
+			}
+		}(indexs[i], queues[i])
+	}
 
-	// 定义一个 WaitGroup 来等待所有 goroutine 完成
-	var wg sync.WaitGroup
+	workers := runtime.GOMAXPROCS(0)
+	blocks := make(chan []byte, workers)
+	var decoders sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		decoders.Add(1)
+		go func() {
+			defer decoders.Done()
+			for block := range blocks {
+				if failed.Load() {
+					continue
+				}
+				data, err := transformer.decodeMetadata(block)
+				if err != nil {
+					fail(fmt.Errorf("failed to decode index block: %w", err))
+					continue
+				}
+				batches, err := parseSnapshotBlock(data, len(indexs))
+				if err != nil {
+					fail(err)
+					continue
+				}
+				for shard, batch := range batches {
+					if len(batch) > 0 {
+						queues[shard] <- batch
+					}
+				}
+			}
+		}()
+	}
 
-	// 生产者 goroutine
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		defer close(nqueue)
+	reader := bufio.NewReaderSize(io.NewSectionReader(fd, offset, finfo.Size()-offset), int(snapshotReadBuffer))
+	remaining := finfo.Size() - offset
+	for remaining > 0 && !failed.Load() {
+		var header [4]byte
+		_, err := io.ReadFull(reader, header[:])
+		if err != nil {
+			fail(fmt.Errorf("failed to read index block: %w", err))
+			break
+		}
 
-		for offset < finfo.Size() && len(equeue) == 0 {
-			buf := make([]byte, 48)
-			_, err := fd.ReadAt(buf, offset)
-			if err != nil {
-				equeue <- fmt.Errorf("failed to read index node: %w", err)
-				return
-			}
+		// 损坏的 BLEN 不能超过快照文件剩余的字节数，否则会分配过大的内存
+		blen := int64(binary.LittleEndian.Uint32(header[:]))
+		if blen > remaining-int64(len(header)) {
+			fail(fmt.Errorf("failed to read index block: block length %d exceeds snapshot size", blen))
+			break
+		}
 
-			offset += 48
+		block := make([]byte, blen)
+		_, err = io.ReadFull(reader, block)
+		if err != nil {
+			fail(fmt.Errorf("failed to read index block: %w", err))
+			break
+		}
+		remaining -= int64(len(header)) + blen
+		blocks <- block
+	}
 
-			inum, inode, err := deserializedIndex(buf)
-			if err != nil {
-				equeue <- fmt.Errorf("failed to deserialize index (inum: %d): %w", inum, err)
-				return
-			}
+	// 出错时已经解析的索引也会写入分片，调用方需要丢弃整个索引
+	close(blocks)
+	decoders.Wait()
+	for _, queue := range queues {
+		close(queue)
+	}
+	shards.Wait()
+
+	return first
+}
+
+type snapshotEntry struct {
+	inum  uint64
+	inode *INode
+}
 
-			nqueue <- index{inum: inum, inode: inode}
+// parseSnapshotBlock 解析一个解压之后的块中的索引记录，按照索引分片分组返回
+// | INUM 8 | RID 8  | OFS 8 | LEN 4 | EAT 8 | CAT 8 | VER 8 | KLEN 4 | KEY ? | CRC32 4 |
+func parseSnapshotBlock(data []byte, shards int) ([][]snapshotEntry, error) {
+	batches := make([][]snapshotEntry, shards)
+	for pos := 0; pos < len(data); {
+		if len(data)-pos < serializedIndexSize+4 {
+			return nil, fmt.Errorf("failed to read index node: %w", io.ErrUnexpectedEOF)
 		}
-	}()
 
-	// 消费者 goroutine
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for node := range nqueue {
-			imap := indexs[node.inum%uint64(indexShard)]
-			if imap != nil {
-				imap.index[node.inum] = node.inode
-			} else {
-				// 这里对应着 for 循环的 len(equeue) == 0 条件
-				// 防止消费者 goroutine 发生了错误已经停止了
-				// 但是生产者 goroutine 还在读取反序列化索引
-				// 导致不能立即执行 defer wg.Done() 做无意义的工作
-				// 目的是为尽快恢复阻塞的 wg.Wait() 执行主 goroutine 返回
-				equeue <- errors.New("no corresponding index shard")
-				return
-			}
+		// 损坏的 KLEN 不能超过块中剩余的字节数
+		klen := binary.LittleEndian.Uint32(data[pos+52:])
+		if int64(klen) > int64(len(data)-pos-serializedIndexSize-4) {
+			return nil, fmt.Errorf("failed to read index node key: key length %d exceeds block size", klen)
 		}
-	}()
+		size := serializedIndexSize + int(klen) + 4
 
-	// 等待所有 goroutine 完成
-	wg.Wait()
+		inum, inode, err := deserializedIndex(data[pos : pos+size])
+		if err != nil {
+			return nil, fmt.Errorf("failed to deserialize index (inum: %d): %w", inum, err)
+		}
+		pos += size
 
-	// 判断错误通道是否有值，捕获错误
-	select {
-	case err := <-equeue:
-		close(equeue)
-		return err
-	default:
-		close(equeue)
-		return nil
+		shard := inum % uint64(indexShard)
+		batches[shard] = append(batches[shard], snapshotEntry{inum: inum, inode: inode})
 	}
+	return batches, nil
 }
 
 // crashRecoveryAllIndex 会对 regions 文件集合进行解析恢复内存索引，步骤如下：
@@ -539,7 +1707,13 @@ func recoveryIndex(fd *os.File, indexs []*indexMap) error {
 // 4. 如果是 1 则对内存的索引进行删除
 // 5. 否则直接将磁盘元数据重构建为索引
 // | DEL 1 | KIND 1 | EAT 8 | CAT 8 | KLEN 4 | VLEN 4 | KEY ? | VALUE ? | CRC32 4 |
-func crashRecoveryAllIndex(regions map[uint64]*os.File, indexs []*indexMap) error {
+// 每个数据文件按照它自己的格式版本解析，所以新旧格式的数据文件可以同时存在
+// 返回所有记录中最大的版本号，包括已经被删除的记录，保证恢复之后分配的版本号不会重复
+// 数据文件由 workers 个 goroutine 并行扫描，每个 goroutine 一次扫描一个数据文件，扫描的结果按照数据文件的顺序应用到索引
+// 最多只有 workers 个数据文件的扫描结果在内存中等待应用，workers 小于等于 0 时使用 runtime.GOMAXPROCS(0)
+// 最后一个有记录的本地数据文件末尾没有提交的批量写入通过 tornBatch 返回，调用方需要截断数据文件
+func crashRecoveryAllIndex(regions map[uint64]BackendFile, versions map[uint64]uint8, checksums map[uint64]Checksum, indexs []*indexMap, cmp Comparator, workers int) (uint64, tornBatch, error) {
+	var sequence uint64
 	var regionIds []uint64
 	for v := range regions {
 		regionIds = append(regionIds, v)
@@ -550,73 +1724,256 @@ func crashRecoveryAllIndex(regions map[uint64]*os.File, indexs []*indexMap) erro
 		return regionIds[i] < regionIds[j]
 	})
 
-	// 3. 遍历每个数据文件（region）
-	for _, regionId := range regionIds {
-		fd, ok := regions[uint64(regionId)]
-		if !ok {
-			return fmt.Errorf("data file does not exist regions id: %d", regionId)
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	// 打开数据目录时新创建的活跃数据文件还没有记录，崩溃之前的活跃数据文件是最后一个有记录的本地数据文件
+	tail := -1
+	for i := len(regionIds) - 1; i >= 0; i-- {
+		fd := regions[regionIds[i]]
+		if fd.Size() > int64(len(dataFileMetadata)) {
+			if _, ok := fd.(*sealedFile); ok {
+				tail = i
+			}
+			break
 		}
+	}
 
-		finfo, err := fd.Stat()
-		if err != nil {
-			return err
+	type regionReplay struct {
+		sequence uint64
+		ops      []hintOp
+		torn     int64
+		err      error
+	}
+
+	// 每个数据文件的扫描结果通过自己的通道返回，应用时按照 regionIds 的顺序读取
+	results := make([]chan regionReplay, len(regionIds))
+	for i := range results {
+		results[i] = make(chan regionReplay, 1)
+	}
+
+	// tokens 限制同时扫描和等待应用的数据文件数量，应用之后才会归还
+	tokens := make(chan struct{}, workers)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i, regionId := range regionIds {
+			select {
+			case tokens <- struct{}{}:
+			case <-done:
+				return
+			}
+
+			wg.Add(1)
+			go func(i int, regionId uint64) {
+				defer wg.Done()
+				fd := regions[regionId]
+				newest := i == len(regionIds)-1
+				recorder := &opRecorder{tail: i == tail}
+				seq, err := replayRecoveryRegion(fd, regionId, versions[regionId], checksums[regionId], newest, recorder)
+				results[i] <- regionReplay{sequence: seq, ops: recorder.ops, torn: recorder.torn, err: err}
+			}(i, regionId)
 		}
+	}()
 
-		offset := uint64(len(dataFileMetadata))
+	// 3. 按照顺序把每个数据文件（region）的操作应用到索引
+	var torn tornBatch
+	replayer := &indexReplayer{indexs: indexs, cmp: cmp}
+	for i := range regionIds {
+		result := <-results[i]
+		<-tokens
+		if result.err != nil {
+			close(done)
+			wg.Wait()
+			return 0, tornBatch{}, result.err
+		}
 
-		for offset < uint64(finfo.Size()) {
-			inum, segment, err := readSegment(fd, offset, 26)
-			if err != nil {
-				return fmt.Errorf("failed to parse data file segment: %w", err)
+		replayOps(result.ops, replayer)
+		if result.sequence > sequence {
+			sequence = result.sequence
+		}
+		if result.torn > 0 {
+			torn = tornBatch{regionID: regionIds[i], offset: result.torn}
+		}
+	}
+	wg.Wait()
+
+	return sequence, torn, nil
+}
+
+// replayRecoveryRegion 把一个数据文件的记录重放给 r，封存的本地数据文件优先读取 hint 文件
+// 最后一个数据文件可能是崩溃之前的活跃数据文件，总是扫描整个数据文件
+func replayRecoveryRegion(fd BackendFile, regionId uint64, version uint8, checksum Checksum, newest bool, r *opRecorder) (uint64, error) {
+	if local, ok := fd.(*sealedFile); ok && !newest {
+		if file, ok := local.vfsFile.(*os.File); ok {
+			sequence, ops, err := parseHint(file.Name(), regionId)
+			if err == nil {
+				r.ops = ops
+				return sequence, nil
+			}
+			if !errors.Is(err, os.ErrNotExist) {
+				clog.Warnf("failed to read hint file of region %d, scanning data file: %v", regionId, err)
 			}
+		}
+	}
+	return replayRegion(fd, regionId, version, checksum, r)
+}
 
-			imap := indexs[inum%uint64(indexShard)]
-			if imap != nil {
-				// 如果是一条删除操作的记录，就将该记录对应索引删除
-				if segment.IsTombstone() {
-					delete(imap.index, inum)
-					continue
-				}
+// replayRegion 按照写入顺序把一个数据文件中的记录重放给 r，返回数据文件中最大的版本号
+// 批量写入的记录只有读到提交记录之后才会重放，没有提交记录的批量写入直接丢弃
+func replayRegion(fd BackendFile, regionId uint64, version uint8, checksum Checksum, r replayer) (uint64, error) {
+	return replayRegionFrom(fd, regionId, version, checksum, int64(len(dataFileMetadata)), r)
+}
 
-				// 否则继续往下执行，构建重新 inode 索引
-				imap.index[inum] = &INode{
-					RegionID:  regionId,
-					Position:  offset,
-					Length:    segment.Size(),
-					CreatedAt: segment.CreatedAt,
-					ExpiredAt: segment.ExpiredAt,
-				}
+// replayRegionFrom 和 replayRegion 一样，但是从 offset 处的记录开始重放，offset 必须是一条记录的开始位置
+func replayRegionFrom(fd BackendFile, regionId uint64, version uint8, checksum Checksum, offset int64, r replayer) (uint64, error) {
+	holes, err := regionHoles(fd)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load region holes: %w", err)
+	}
+
+	replay := &segmentReplay{regionID: regionId, r: r}
+
+	for offset < fd.Size() {
+		// 空洞区间里面都是已经被打洞释放的垃圾记录
+		if end, ok := holes[offset]; ok {
+			offset = end
+			continue
+		}
+
+		inum, segment, length, err := readRawSegment(fd, offset, version, checksum)
+		if err != nil {
+			// 批量写入的记录没有写完就崩溃了，之前的记录都已经重放，由调用方截断没有写完的批量写入
+			if t, ok := r.(tailReplayer); ok && len(replay.pending) > 0 && isCorruption(err) && t.truncate(replay.batchStart) {
+				return replay.sequence, nil
+			}
+			return 0, fmt.Errorf("failed to parse data file segment: %w", err)
+		}
+		replay.apply(inum, segment, offset, length)
+		offset += length
+	}
+
+	// 末尾完整但是没有提交记录的批量写入同样需要截断，否则之后追加的批量写入重放时会把它们一起提交
+	if t, ok := r.(tailReplayer); ok && len(replay.pending) > 0 {
+		t.truncate(replay.batchStart)
+	}
+
+	return replay.sequence, nil
+}
 
-				offset += uint64(segment.Size())
+// tailReplayer 由崩溃恢复时重放最后一个数据文件的 replayer 实现
+// 批量写入通过一次写入追加到同一个数据文件，没有提交记录的批量写入只可能出现在崩溃之前的活跃数据文件末尾
+// truncate 收到批量写入的开始位置，返回 true 表示由调用方截断数据文件，否则读取失败时返回错误
+type tailReplayer interface {
+	truncate(offset int64) bool
+}
+
+// tornBatch 是崩溃时没有提交的批量写入在数据文件中的开始位置，offset 为 0 表示没有
+type tornBatch struct {
+	regionID uint64
+	offset   int64
+}
+
+// truncateTornBatch 从没有提交的批量写入的开始位置截断数据文件，调用方需要持有 lfs.mu
+func (lfs *LogStructuredFS) truncateTornBatch(torn tornBatch) error {
+	if torn.offset == 0 {
+		return nil
+	}
+	_, err := lfs.truncateRegion(torn.regionID, torn.offset)
+	return err
+}
+
+// segmentReplay 按照写入顺序把一个数据文件中的记录逐条重放给 r，并且记录最大的版本号
+type segmentReplay struct {
+	regionID uint64
+	r        replayer
+	sequence uint64
+	// 批量写入的记录只有读到提交记录之后才会生效，batchStart 是第一条批量写入记录的位置
+	pending    []*batchRecord
+	batchStart int64
+}
+
+func (p *segmentReplay) apply(inum uint64, segment *Segment, offset, length int64) {
+	if segment.Version > p.sequence {
+		p.sequence = segment.Version
+	}
+
+	if segment.Flags&flagBatchCommit != 0 {
+		for _, record := range p.pending {
+			if record.seg.IsTombstone() {
+				p.r.remove(record.inum, string(record.seg.Key))
 			} else {
-				// 找不到索引就抛出异常
-				return errors.New("no corresponding index shard")
+				p.r.put(record.inum, record.inode)
 			}
 		}
+		p.pending = nil
+		return
+	}
 
+	inode := &INode{
+		RegionID:  p.regionID,
+		Position:  offset,
+		Length:    uint32(length),
+		CreatedAt: segment.CreatedAt,
+		ExpiredAt: segment.ExpiredAt,
+		Version:   segment.Version,
+		Key:       string(segment.Key),
 	}
 
-	return nil
+	if segment.Flags&flagBatch != 0 {
+		if len(p.pending) == 0 {
+			p.batchStart = offset
+		}
+		p.pending = append(p.pending, &batchRecord{inum: inum, seg: segment, inode: inode})
+		return
+	}
+
+	// 没有提交记录的批量写入是不完整的，直接丢弃
+	p.pending = nil
+
+	if segment.IsRangeTombstone() {
+		p.r.removeRange(segment.Range())
+		return
+	}
+
+	// 如果是一条删除操作的记录，就将该记录对应索引删除
+	// 否则构建重新 inode 索引
+	if segment.IsTombstone() {
+		p.r.remove(inum, string(segment.Key))
+		return
+	}
+	p.r.put(inum, inode)
 }
 
-func validateFileHeader(file *os.File) error {
+// validateIndexFileHeader 校验索引快照文件的签名，版本号不一致时会在恢复时重建索引
+func validateIndexFileHeader(file *os.File) error {
 	var fileHeader [4]byte
 	n, err := file.Read(fileHeader[:])
 	if err != nil {
 		return err
 	}
 
-	if n != len(dataFileMetadata) {
+	if n != len(indexFileMetadata) {
 		return errors.New("file is too short to contain valid signature")
 	}
 
-	if !bytes.Equal(fileHeader[:], dataFileMetadata[:]) {
-		return fmt.Errorf("unsupported data file version: %v", file.Name())
+	if !bytes.Equal(fileHeader[:3], indexFileMetadata[:3]) {
+		return fmt.Errorf("unsupported index file signature: %v", file.Name())
 	}
 
 	return nil
 }
 
+func isIndexFileVersion(file *os.File) bool {
+	var fileHeader [4]byte
+	_, err := file.ReadAt(fileHeader[:], 0)
+	return err == nil && bytes.Equal(fileHeader[:], indexFileMetadata)
+}
+
 func checkFileSystem(path string) error {
 	if !utils.IsExist(path) {
 		err := os.MkdirAll(path, fsPerm)
@@ -640,7 +1997,7 @@ func checkFileSystem(path string) error {
 					}
 					defer utils.CloseFile(file)
 
-					err = validateFileHeader(file)
+					_, _, err = readFileVersion(file)
 					if err != nil {
 						return fmt.Errorf("failed to validated data file header: %w", err)
 					}
@@ -654,7 +2011,7 @@ func checkFileSystem(path string) error {
 				}
 				defer utils.CloseFile(file)
 
-				err = validateFileHeader(file)
+				err = validateIndexFileHeader(file)
 				if err != nil {
 					return fmt.Errorf("failed to validated index file header: %w", err)
 				}
@@ -665,87 +2022,31 @@ func checkFileSystem(path string) error {
 	return nil
 }
 
-// | DEL 1 | KIND 1 | EAT 8 | CAT 8 | KLEN 4 | VLEN 4 | KEY ? | VALUE ? | CRC32 4 |
-func readSegment(fd *os.File, offset uint64, bufsize int64) (uint64, *Segment, error) {
-	buf := make([]byte, bufsize)
-
-	_, err := fd.ReadAt(buf, int64(offset))
+// readSegment 读取一条 Segment 记录，并且 Value 已经通过 transformer 解码
+func readSegment(fd io.ReaderAt, offset int64, version uint8, checksum Checksum) (uint64, *Segment, error) {
+	inum, seg, _, err := readRawSegment(fd, offset, version, checksum)
 	if err != nil {
 		return 0, nil, err
 	}
 
-	var seg Segment
-	readOffset := 0
-
-	// 解析 Tombstone (1 字节)
-	seg.Tombstone = int8(buf[readOffset])
-	readOffset++
-
-	// 解析 Type (1 字节)
-	seg.Type = Kind(buf[readOffset])
-	readOffset++
-
-	// 解析 ExpiredAt (8 字节)
-	seg.ExpiredAt = binary.LittleEndian.Uint64(buf[readOffset : readOffset+8])
-	readOffset += 8
-
-	// 解析 CreatedAt (8 字节)
-	seg.CreatedAt = binary.LittleEndian.Uint64(buf[readOffset : readOffset+8])
-	readOffset += 8
-
-	// 解析 KeySize (4 字节)
-	seg.KeySize = binary.LittleEndian.Uint32(buf[readOffset : readOffset+4])
-	readOffset += 4
-
-	// 解析 ValueSize (4 字节)
-	seg.ValueSize = binary.LittleEndian.Uint32(buf[readOffset : readOffset+4])
-	readOffset += 4
-
-	// 26 到此结束
-
-	// 读取 Key 数据
-	keybuf := make([]byte, seg.KeySize)
-	_, err = fd.ReadAt(keybuf, int64(offset)+int64(readOffset))
-	if err != nil {
-		return 0, nil, fmt.Errorf("failed to parse key in segment: %w", err)
-	}
-	readOffset += int(seg.KeySize)
-
-	// 读取 Value 数据
-	valuebuf := make([]byte, seg.ValueSize)
-	_, err = fd.ReadAt(valuebuf, int64(offset)+int64(readOffset))
-	if err != nil {
-		return 0, nil, fmt.Errorf("failed to parse value in segment: %w", err)
-	}
-	readOffset += int(seg.ValueSize)
-
-	// 读取 checksum (4 字节)
-	checksumBuf := make([]byte, 4)
-	_, err = fd.ReadAt(checksumBuf, int64(offset)+int64(readOffset))
-	if err != nil {
-		return 0, nil, fmt.Errorf("failed to read checksum in segment: %w", err)
-	}
-
-	// 校验 checksum
-	checksum := binary.LittleEndian.Uint32(checksumBuf)
-
-	buf = append(buf, keybuf...)
-	buf = append(buf, valuebuf...)
-
-	if checksum != crc32.ChecksumIEEE(buf) {
-		return 0, nil, fmt.Errorf("failed to crc32 checksum mismatch: %d", checksum)
-	}
-
 	// 更新 Segment 数据字段为读取的 valuebuf 并且通过 Transformer 处理之后才能使用
-	decodedData, err := transformer.Decode(valuebuf)
+	decodedData, err := transformer.decode(seg.Flags, seg.Value)
 	if err != nil {
 		return 0, nil, fmt.Errorf("failed to transformer decode value in segment: %w", err)
 	}
-
-	seg.Key = keybuf
 	seg.Value = decodedData
 
-	return InodeNum(string(keybuf)), &seg, nil
+	return inum, seg, nil
+}
+
+// readRawSegment 按照数据文件的格式版本和校验码算法读取一条磁盘上原始的 Segment 记录
+// Value 保持 transformer 编码之后的状态，并返回记录在磁盘上占用的长度
+func readRawSegment(fd io.ReaderAt, offset int64, version uint8, checksum Checksum) (uint64, *Segment, int64, error) {
+	seg, length, err := decodeSegment(fd, offset, version, checksum)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	return InodeNum(string(seg.Key)), seg, length, nil
 }
 
 func generateFileName(regionID uint64) (string, error) {
@@ -774,201 +2075,418 @@ func parseDataFileName(fileName string) (uint64, error) {
 	return uint64(number), nil
 }
 
-// formatDataFileName 将 uint16 转换为文件名格式（如 1 转为 0000001.wdb）
+// formatDataFileName 将数据文件编号转换为 8 位的文件名（如 1 转为 00000001.wdb），最多支持 99999999 个数据文件
 func formatDataFileName(number uint64) string {
 	return fmt.Sprintf("%08d%s", number, fileExtension)
 }
 
 // serializedIndex 将索引进行序列化为可以恢复的文件快照记录格式：
-// | INUM 8 | RID 8  | POS 8 | LEN 4 | EAT 8 | CAT 8 | CRC32 4 |
+// | INUM 8 | RID 8  | POS 8 | LEN 4 | EAT 8 | CAT 8 | VER 8 | KLEN 4 | KEY ? | CRC32 4 |
 func serializedIndex(inum uint64, inode *INode) ([]byte, error) {
-	// 创建一个字节缓冲区
-	buf := new(bytes.Buffer)
-
-	// 按顺序写入各个字段
-	binary.Write(buf, binary.LittleEndian, inum)
-	binary.Write(buf, binary.LittleEndian, inode.RegionID)
-	binary.Write(buf, binary.LittleEndian, inode.Position)
-	binary.Write(buf, binary.LittleEndian, inode.Length)
-	binary.Write(buf, binary.LittleEndian, inode.ExpiredAt)
-	binary.Write(buf, binary.LittleEndian, inode.CreatedAt)
-
-	// 计算 CRC32 校验码
-	checksum := crc32.ChecksumIEEE(buf.Bytes())
-
-	// 将 CRC32 校验码写入字节缓冲区（4 字节）
-	binary.Write(buf, binary.LittleEndian, checksum)
+	return appendSerializedIndex(nil, inum, inode), nil
+}
 
-	// 返回包含 CRC32 校验码的字节切片
-	return buf.Bytes(), nil
+// serializedIndexSize 是索引快照记录中 Key 之前的定长部分
+const serializedIndexSize = 56
+
+// appendSerializedIndex 把序列化之后的索引追加到 dst 之后，导出快照时复用同一个缓冲区
+func appendSerializedIndex(dst []byte, inum uint64, inode *INode) []byte {
+	start := len(dst)
+	dst = binary.LittleEndian.AppendUint64(dst, inum)
+	dst = binary.LittleEndian.AppendUint64(dst, inode.RegionID)
+	dst = binary.LittleEndian.AppendUint64(dst, uint64(inode.Position))
+	dst = binary.LittleEndian.AppendUint32(dst, inode.Length)
+	dst = binary.LittleEndian.AppendUint64(dst, inode.ExpiredAt)
+	dst = binary.LittleEndian.AppendUint64(dst, inode.CreatedAt)
+	dst = binary.LittleEndian.AppendUint64(dst, inode.Version)
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(inode.Key)))
+	dst = append(dst, inode.Key...)
+
+	// CRC32 校验码覆盖定长部分和 Key
+	return binary.LittleEndian.AppendUint32(dst, crc32.ChecksumIEEE(dst[start:]))
 }
 
 // deserializedIndex 将索引文件快照恢复为内存结构体：
-// | INUM 8 | RID 8  | OFS 8 | LEN 4 | EAT 8 | CAT 8 | CRC32 4 |
+// | INUM 8 | RID 8  | OFS 8 | LEN 4 | EAT 8 | CAT 8 | VER 8 | KLEN 4 | KEY ? | CRC32 4 |
 func deserializedIndex(data []byte) (uint64, *INode, error) {
-	buf := bytes.NewReader(data)
-	// 反序列化 inum
-	var inum uint64
-	err := binary.Read(buf, binary.LittleEndian, &inum)
-	if err != nil {
-		return 0, nil, err
+	if len(data) < serializedIndexSize+4 {
+		return 0, nil, io.ErrUnexpectedEOF
 	}
 
-	// 反序列化 INode 的各个字段
-	var inode INode
-	err = binary.Read(buf, binary.LittleEndian, &inode.RegionID)
-	if err != nil {
-		return 0, nil, err
+	inum := binary.LittleEndian.Uint64(data[0:])
+	inode := &INode{
+		RegionID:  binary.LittleEndian.Uint64(data[8:]),
+		Position:  int64(binary.LittleEndian.Uint64(data[16:])),
+		Length:    binary.LittleEndian.Uint32(data[24:]),
+		ExpiredAt: binary.LittleEndian.Uint64(data[28:]),
+		CreatedAt: binary.LittleEndian.Uint64(data[36:]),
+		Version:   binary.LittleEndian.Uint64(data[44:]),
 	}
 
-	err = binary.Read(buf, binary.LittleEndian, &inode.Position)
-	if err != nil {
-		return 0, nil, err
+	klen := binary.LittleEndian.Uint32(data[52:])
+	if int64(klen) > int64(len(data)-serializedIndexSize-4) {
+		return 0, nil, errors.New("index key length out of range")
 	}
+	end := serializedIndexSize + int(klen)
 
-	err = binary.Read(buf, binary.LittleEndian, &inode.Length)
-	if err != nil {
-		return 0, nil, err
+	// 计算数据的 CRC32 校验码，如果校验码不一致，返回错误
+	checksum := binary.LittleEndian.Uint32(data[len(data)-4:])
+	if checksum != crc32.ChecksumIEEE(data[:len(data)-4]) {
+		return 0, nil, fmt.Errorf("failed to crc32 checksum mismatch: %d", checksum)
 	}
+	inode.Key = string(data[serializedIndexSize:end])
 
-	err = binary.Read(buf, binary.LittleEndian, &inode.ExpiredAt)
-	if err != nil {
-		return 0, nil, err
-	}
+	return inum, inode, nil
+}
 
-	err = binary.Read(buf, binary.LittleEndian, &inode.CreatedAt)
-	if err != nil {
-		return 0, nil, err
-	}
+// serializedSegment 按照新创建数据文件的格式版本和 checksum 校验码算法序列化 Segment
+func serializedSegment(seg *Segment, checksum Checksum) ([]byte, error) {
+	return encodeSegment(seg, currentFormat, checksum)
+}
 
-	// 反序列化 CRC32 校验码并验证
-	var checksum uint32
-	err = binary.Read(buf, binary.LittleEndian, &checksum)
-	if err != nil {
-		return 0, nil, err
+// 垃圾回收压缩器工作原理
+// 1. 如果磁盘上没有索引快照，就全局扫描恢复索引
+// 2. 当索引恢复之后，运行了一段时间出发垃圾回收
+// 3. 启动 GC 扫描磁盘数据文件和内存索引最新版比较
+// 4. 如果磁盘上的文件记录和索引记录对上就迁移到新文件
+// 5. 如果没有对上说明是旧文件，不要管它重复这个过程
+// 6. 直到 GC 扫描完整个数据文件完成，最后删除这个文件
+// 7. PS：重点是反向扫描，通过磁盘数据文件中 Key 名找内存到记录比较
+// 8. 如果通过内存索引来找，会出现无法确定一个文件是否扫描干净
+// 9. 因为内存索引的对应的数据记录会分配在不同数据文件中
+func (lfs *LogStructuredFS) compactRegion(regionID uint64) error {
+	return lfs.compactRegionContext(context.Background(), regionID)
+}
+
+// compactRegionContext 在迁移每条记录之前检查 ctx，取消时旧数据文件会被保留
+// 已经迁移的记录和旧数据文件中的记录重复不会影响正确性，下一次压缩会继续处理
+func (lfs *LogStructuredFS) compactRegionContext(ctx context.Context, regionID uint64) error {
+	defer lfs.latency.observe(LatencyCompact, time.Now())
+
+	ctx, span := lfs.startSpan(ctx, spanCompact)
+	if span != nil {
+		span.SetAttributes(int64Attr("vfs.region", int64(regionID)))
+	}
+	var err error
+	if lfs.lsm != nil {
+		err = lfs.compactLSM(ctx, regionID)
+	} else {
+		err = lfs.compactRegionLocked(ctx, regionID)
 	}
+	endSpan(span, err)
+	return err
+}
 
-	// 计算数据的 CRC32 校验码，如果校验码不一致，返回错误
-	if checksum != crc32.ChecksumIEEE(data[:len(data)-4]) {
-		return 0, nil, fmt.Errorf("failed to crc32 checksum mismatch: %d", checksum)
+// compactRegionLocked 持有 compactMu 压缩一个数据文件
+func (lfs *LogStructuredFS) compactRegionLocked(ctx context.Context, regionID uint64) error {
+	if lfs.readOnly {
+		return ErrReadOnly
 	}
 
-	return inum, &inode, nil
-}
+	lfs.compactMu.Lock()
+	defer lfs.compactMu.Unlock()
 
-func serializedSegment(seg *Segment) ([]byte, error) {
-	// 创建一个字节缓冲区
-	buf := new(bytes.Buffer)
+	// 1. 对数据文件进行压缩
+	// 2. 通过 region ID 找到数据文件
+	// 3. 从文件头部开始扫描文件的记录
+	// 4. 使用记录的位置和内存索引中的位置比较
+	// 5. 如果一致就迁移文件到新文件中
+	// 6. 最后删除旧数据文件
+	lfs.mu.Lock()
+	fd, ok := lfs.regions[regionID]
+	version := lfs.versions[regionID]
+	checksum := lfs.checksums[regionID]
+	oldest := lfs.isOldestRegion(regionID)
+	lfs.mu.Unlock()
+	if !ok || regionID == lfs.activeRegionID() {
+		return fmt.Errorf("region %d is not a sealed region", regionID)
+	}
 
-	// 序列化 Segment 字段
-	err := binary.Write(buf, binary.LittleEndian, seg.Tombstone)
+	finfo, err := fd.Stat()
 	if err != nil {
-		return nil, fmt.Errorf("failed to write Tombstone: %w", err)
+		return err
 	}
 
-	err = binary.Write(buf, binary.LittleEndian, seg.Type)
+	holes, err := fileHoles(fd)
 	if err != nil {
-		return nil, fmt.Errorf("failed to write Type: %w", err)
+		return fmt.Errorf("failed to load region holes: %w", err)
 	}
 
-	err = binary.Write(buf, binary.LittleEndian, seg.ExpiredAt)
-	if err != nil {
-		return nil, fmt.Errorf("failed to write ExpiredAt: %w", err)
+	if lfs.paranoid {
+		lfs.verifyRegionIndex(regionID, fd, version, checksum, holes)
 	}
 
-	err = binary.Write(buf, binary.LittleEndian, seg.CreatedAt)
-	if err != nil {
-		return nil, fmt.Errorf("failed to write CreatedAt: %w", err)
+	offset := int64(len(dataFileMetadata))
+	record := CompactionRecord{RegionID: regionID, StartedAt: time.Now(), BytesRead: finfo.Size()}
+
+	lfs.compaction.begin(regionID, finfo.Size())
+	defer lfs.compaction.end()
+
+	for offset < finfo.Size() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		lfs.compaction.progress(offset)
+		if err := lfs.compaction.wait(ctx); err != nil {
+			return err
+		}
+
+		if end, ok := holes[offset]; ok {
+			offset = end
+			continue
+		}
+
+		// 旧格式版本的记录迁移时会被重新编码为新的格式版本
+		inum, segment, length, err := readRawSegment(fd, offset, version, checksum)
+		if err != nil {
+			return err
+		}
+		position := offset
+		offset += length
+
+		// 批量写入的提交记录迁移之后就没有意义了
+		if segment.Flags&flagBatchCommit != 0 {
+			record.Dropped.Commits++
+			continue
+		}
+		// 迁移的记录已经是提交过的记录，单独写入不再需要提交记录
+		segment.Flags &^= flagBatch
+
+		// 删除记录只有在没有更旧的数据文件时才可以丢弃
+		// 否则旧数据文件中被删除的记录在崩溃恢复时就会复活
+		if segment.IsTombstone() {
+			if !oldest {
+				err := lfs.moveTombstone(inum, segment)
+				if err != nil {
+					return err
+				}
+			} else {
+				record.Dropped.Tombstones++
+			}
+			continue
+		}
+
+		inode, ok := lfs.GetINode(inum)
+		if !ok || inode.RegionID != regionID || inode.Position != position {
+			record.Dropped.Overwritten++
+			continue
+		}
+
+		// 最旧的数据文件中过期的记录不会有更旧的版本，可以直接丢弃而不需要写入删除记录
+		if oldest && isExpired(inode.ExpiredAt) {
+			lfs.dropExpired(inum, inode)
+			record.Dropped.Expired++
+			continue
+		}
+
+		// 迁移数据到新的数据文件中
+		err = lfs.moveSegment(inum, inode, segment)
+		if err != nil {
+			return err
+		}
+		record.RecordsMoved++
+		record.BytesWritten += int64(segment.Size())
 	}
 
-	err = binary.Write(buf, binary.LittleEndian, seg.KeySize)
-	if err != nil {
-		return nil, fmt.Errorf("failed to write KeySize: %w", err)
+	// 没有任何有效数据的数据文件在删除之前先归档
+	if lfs.archive != nil && record.RecordsMoved == 0 {
+		err = lfs.archiveRegion(fd, finfo.Size())
+		if err != nil {
+			return err
+		}
 	}
 
-	err = binary.Write(buf, binary.LittleEndian, seg.ValueSize)
+	lfs.mu.Lock()
+	defer lfs.mu.Unlock()
+
+	err = lfs.syncActive()
 	if err != nil {
-		return nil, fmt.Errorf("failed to write ValueSize: %w", err)
+		return fmt.Errorf("failed to sync active migrate region: %w", err)
 	}
 
-	// 序列化 Key 和 Value 数据
-	err = binary.Write(buf, binary.LittleEndian, seg.Key)
+	// 删除这个文件
+	delete(lfs.regions, regionID)
+	delete(lfs.usage, regionID)
+	delete(lfs.versions, regionID)
+	delete(lfs.checksums, regionID)
+	delete(lfs.accessed, regionID)
+	delete(lfs.sparse, regionID)
+
+	err = fd.Close()
 	if err != nil {
-		return nil, fmt.Errorf("failed to write Key: %w", err)
+		return fmt.Errorf("failed to close compacted region: %w", err)
 	}
 
-	err = binary.Write(buf, binary.LittleEndian, seg.Value)
+	err = removeRegionFile(fd)
 	if err != nil {
-		return nil, fmt.Errorf("failed to write Value: %w", err)
+		return err
 	}
 
-	// 计算 CRC32 校验码
-	checksum := crc32.ChecksumIEEE(buf.Bytes())
+	record.Duration = time.Since(record.StartedAt)
+	record.BytesReclaimed = record.BytesRead - record.BytesWritten
+	lfs.history.add(record)
+
+	return nil
+}
 
-	// 将 CRC32 校验码写入字节缓冲区（4 字节）
-	err = binary.Write(buf, binary.LittleEndian, checksum)
+// moveSegment 将索引引用的记录迁移到活跃的数据文件中
+// 检查索引、追加记录和替换索引期间一直持有分片锁，迁移的记录在数据文件中不会排在更新的写入后面
+// 否则崩溃恢复按照写入顺序重放时旧的记录会覆盖更新的写入
+func (lfs *LogStructuredFS) moveSegment(inum uint64, inode *INode, segment *Segment) error {
+	shard := lfs.indexs[inum%uint64(indexShard)]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	// 读取记录之后 Key 被更新或者删除了就放弃这次迁移
+	if current, ok := shard.get(inum); !ok || !sameINode(current, inode) {
+		return nil
+	}
+
+	moved, err := lfs.appendSegment(segment)
 	if err != nil {
-		return nil, fmt.Errorf("failed to write checksum: %w", err)
+		return err
 	}
+	shard.set(inum, moved)
+	lfs.markDead(inode)
 
-	// 返回包含 CRC32 校验码的字节切片
-	return buf.Bytes(), nil
+	return nil
 }
 
-// 垃圾回收压缩器工作原理
-// 1. 如果磁盘上没有索引快照，就全局扫描恢复索引
-// 2. 当索引恢复之后，运行了一段时间出发垃圾回收
-// 3. 启动 GC 扫描磁盘数据文件和内存索引最新版比较
-// 4. 如果磁盘上的文件记录和索引记录对上就迁移到新文件
-// 5. 如果没有对上说明是旧文件，不要管它重复这个过程
-// 6. 直到 GC 扫描完整个数据文件完成，最后删除这个文件
-// 7. PS：重点是反向扫描，通过磁盘数据文件中 Key 名找内存到记录比较
-// 8. 如果通过内存索引来找，会出现无法确定一个文件是否扫描干净
-// 9. 因为内存索引的对应的数据记录会分配在不同数据文件中
-func compressDirtyRegion(dirtyRegion []*os.File, indexs []*indexMap, active *os.File, regionID uint64) error {
-	// 1. 对数据文件进行压缩
-	// 2. 通过 region ID 找到数据文件
-	// 3. 从文件头部开始扫描文件的记录
-	// 4. 使用记录的时间戳和内存索引时间戳比较
-	// 5. 如果一致就迁移文件到新文件中
-	// 6. 最后删除旧数据文件
-	for _, fd := range dirtyRegion {
-		finfo, err := fd.Stat()
+// moveTombstone 将删除记录迁移到活跃的数据文件中
+// 迁移之后删除记录排在了比它更新的写入后面，所以需要保证重放的顺序依然正确：
+// 1. 单个删除记录对应的 Key 如果又被写入了，旧的数据不会复活，删除记录可以直接丢弃
+// 2. 范围删除记录迁移之后，范围内还有效的记录都需要再迁移到它的后面
+func (lfs *LogStructuredFS) moveTombstone(inum uint64, segment *Segment) error {
+	if !segment.IsRangeTombstone() {
+		// 和 moveSegment 一样持有分片锁，检查之后写入的 Key 不会被迁移的删除记录覆盖
+		shard := lfs.indexs[inum%uint64(indexShard)]
+		shard.mu.Lock()
+		defer shard.mu.Unlock()
+		if _, ok := shard.get(inum); ok {
+			return nil
+		}
+		inode, err := lfs.appendSegment(segment)
 		if err != nil {
 			return err
 		}
+		lfs.markDead(inode)
+		return nil
+	}
 
-		offset := uint64(len(dataFileMetadata))
+	inode, err := lfs.appendSegment(segment)
+	if err != nil {
+		return err
+	}
+	lfs.markDead(inode)
 
-		for offset < uint64(finfo.Size()) {
-			inum, segment, err := readSegment(fd, uint64(offset), 26)
-			if err != nil {
-				return err
-			}
-			imap := indexs[inum%uint64(indexShard)]
-			if imap.index[inum].CreatedAt == segment.CreatedAt {
-				// 迁移数据到新的数据文件中
-				appendBinaryToFile(active, segment)
-				// 把 inum 和新的 region 映射起来
-				imap.index[inum].RegionID = regionID
-				imap.index[inum].Position = offset
-				offset += uint64(segment.Size())
-			} else {
-				continue
-			}
+	start, end := segment.Range()
+	for inum, inode := range lfs.rangeINodes(start, end) {
+		fd, version, checksum, ok := lfs.regionFile(inode.RegionID)
+		if !ok {
+			return fmt.Errorf("region file not found for region id: %d", inode.RegionID)
+		}
+
+		_, live, _, err := readRawSegment(fd, inode.Position, version, checksum)
+		if err != nil {
+			return err
 		}
+		live.Flags &^= flagBatch
 
-		err = active.Sync()
+		err = lfs.moveSegment(inum, inode, live)
 		if err != nil {
-			return fmt.Errorf("failed to close active migrate region: %w", err)
+			return err
 		}
-		// 删除这个文件
 	}
 
 	return nil
 }
 
-// 开始序列化小端数据，ToLittleEndian(lfs.active,seg)，需要对 seg 进行压缩处理再写入
-func appendBinaryToFile(_ *os.File, _ *Segment) error {
+// dirtyRegions 按照垃圾比例从高到低返回最多 n 个需要回收的数据文件，n 小于等于 0 时返回全部
+func (lfs *LogStructuredFS) dirtyRegions(n int) []uint64 {
+	lfs.mu.Lock()
+	defer lfs.mu.Unlock()
+
+	var regionIds []uint64
+	for regionID := range lfs.regions {
+		if regionID == lfs.regionID {
+			continue
+		}
+		// LSM 模式中等待刷新的预写日志由后台刷新删除
+		if lfs.lsm != nil && lfs.lsm.pending(regionID) {
+			continue
+		}
+		// 旧格式版本的数据文件即使没有垃圾数据也需要被重写
+		if lfs.regionUsage(regionID).dead > 0 || lfs.versions[regionID] != currentFormat {
+			regionIds = append(regionIds, regionID)
+		}
+	}
+
+	sort.Slice(regionIds, func(i, j int) bool {
+		ri := lfs.regionUsage(regionIds[i]).garbageRatio()
+		rj := lfs.regionUsage(regionIds[j]).garbageRatio()
+		if ri == rj {
+			return regionIds[i] < regionIds[j]
+		}
+		return ri > rj
+	})
+
+	if n > 0 && len(regionIds) > n {
+		regionIds = regionIds[:n]
+	}
+
+	return regionIds
+}
+
+// isOldestRegion 判断是否是最旧的数据文件，调用方需要持有 lfs.mu 锁
+func (lfs *LogStructuredFS) isOldestRegion(regionID uint64) bool {
+	for id := range lfs.regions {
+		if id < regionID {
+			return false
+		}
+	}
+	for id := range lfs.cold {
+		if id < regionID {
+			return false
+		}
+	}
+	return true
+}
+
+func (lfs *LogStructuredFS) activeRegionID() uint64 {
+	lfs.mu.Lock()
+	defer lfs.mu.Unlock()
+	return lfs.regionID
+}
+
+// appendBinaryToFile 将 Segment 序列化为小端数据通过一次写入追加到数据文件中
+// Segment 的 Value 在 NewSegment 时已经经过 transformer 编码处理
+// 所有记录序列化到同一个池中的缓冲区，写入之后放回池中
+func appendBinaryToFile(fd io.Writer, checksum Checksum, segs ...*Segment) error {
+	size := 0
+	for _, seg := range segs {
+		size += int(seg.Size())
+	}
+	pooled := getBuffer(size)
+	defer putBuffer(pooled)
+
+	buf := (*pooled)[:0]
+	for _, seg := range segs {
+		var err error
+		buf, err = appendEncodedSegment(buf, seg, currentFormat, checksum)
+		if err != nil {
+			return fmt.Errorf("failed to serialized segment: %w", err)
+		}
+	}
+	*pooled = buf
+
+	n, err := fd.Write(buf)
+	if err != nil {
+		return fmt.Errorf("failed to append segment to region: %w", err)
+	}
+
+	if n != len(buf) {
+		return errors.New("segment write to region incomplete")
+	}
+
 	return nil
 }
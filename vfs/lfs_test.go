@@ -1,6 +1,8 @@
 package vfs
 
 import (
+	"context"
+	"errors"
 	"os"
 	"testing"
 )
@@ -14,10 +16,12 @@ func TestSerializedIndex(t *testing.T) {
 		Length:    100,
 		ExpiredAt: 1617181723,
 		CreatedAt: 1617181623,
+		Version:   42,
+		Key:       "key-01",
 	}
 
 	// 计算预期的字节切片
-	expectedLength := 48
+	expectedLength := 60 + len(inode.Key)
 
 	// 调用 serializeIndex
 	result, err := serializedIndex(1001, inode)
@@ -56,6 +60,12 @@ func TestSerializedIndex(t *testing.T) {
 	if node.CreatedAt != inode.CreatedAt {
 		t.Errorf("expected CreatedAt %d, got %d", inode.CreatedAt, node.CreatedAt)
 	}
+	if node.Version != inode.Version {
+		t.Errorf("expected Version %d, got %d", inode.Version, node.Version)
+	}
+	if node.Key != inode.Key {
+		t.Errorf("expected Key %s, got %s", inode.Key, node.Key)
+	}
 
 }
 
@@ -74,7 +84,7 @@ func TestReadSegment(t *testing.T) {
 	}
 
 	// 将 Segment 数据转化为字节数组
-	bytes, err := serializedSegment(seg)
+	bytes, err := serializedSegment(seg, ChecksumCRC32)
 	if err != nil {
 		t.Fatalf("failed to serialized segment:%v", err)
 	}
@@ -93,8 +103,8 @@ func TestReadSegment(t *testing.T) {
 	}
 
 	// 使用 readSegment 读取并测试数据
-	offset := uint64(0)
-	inum, segment, err := readSegment(tmpFile, offset, 26)
+	offset := int64(0)
+	inum, segment, err := readSegment(tmpFile, offset, currentFormat, ChecksumCRC32)
 	if err != nil {
 		t.Fatalf("expected no error, but got: %v", err)
 	}
@@ -130,3 +140,135 @@ func TestReadSegment(t *testing.T) {
 		t.Errorf("expected InodeNum to be '%s', but got: %d", seg.Key, inum)
 	}
 }
+
+func TestCompactRegionByGarbageRatio(t *testing.T) {
+	lfs, err := OpenFS(&Options{
+		Path:      t.TempDir(),
+		FsPerm:    fsPerm,
+		Threshold: 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer lfs.CloseFS()
+
+	seg := testSegment("key-01", "value-01")
+	// 每个数据文件只能放下 4 条记录
+	regionThreshold = int64(len(dataFileMetadata)) + int64(seg.Size())*4
+
+	keys := []string{"key-01", "key-02", "key-03", "key-04", "key-05", "key-06", "key-07", "key-08"}
+	for _, key := range keys {
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value-01"), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+
+	// 第一个数据文件覆盖 1 条，第二个数据文件覆盖 3 条
+	for _, key := range []string{"key-01", "key-05", "key-06", "key-07"} {
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value-02"), 0)
+		if err != nil {
+			t.Fatalf("failed to update segment: %v", err)
+		}
+	}
+
+	dirty := lfs.dirtyRegions(gcBatchSize)
+	if len(dirty) != 2 || dirty[0] != 2 || dirty[1] != 1 {
+		t.Fatalf("expected dirty regions [2 1], got %v", dirty)
+	}
+
+	for _, regionID := range dirty {
+		err := lfs.compactRegion(regionID)
+		if err != nil {
+			t.Fatalf("failed to compact region %d: %v", regionID, err)
+		}
+	}
+
+	for i, key := range keys {
+		seg, err := lfs.FetchSegment(InodeNum(key))
+		if err != nil {
+			t.Fatalf("failed to fetch %s: %v", key, err)
+		}
+		want := "value-01"
+		if i == 0 || (i >= 4 && i <= 6) {
+			want = "value-02"
+		}
+		if string(seg.Value) != want {
+			t.Errorf("expected %s to be %s, got %s", key, want, seg.Value)
+		}
+	}
+
+	if _, ok := lfs.regions[1]; ok {
+		t.Errorf("expected region 1 to be removed after compaction")
+	}
+}
+
+func TestCompactContextCanceled(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer lfs.CloseFS()
+
+	for _, value := range []string{"value-01", "value-02"} {
+		err := lfs.AddSegment(InodeNum("key-01"), *testSegment("key-01", value), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+
+	err = lfs.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// 取消的压缩不能删除旧的数据文件
+	err = lfs.CompactContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got: %v", err)
+	}
+	if _, ok := lfs.regions[1]; !ok {
+		t.Fatalf("expected region 1 to be kept after canceled compaction")
+	}
+
+	err = lfs.CompactContext(context.Background())
+	if err != nil {
+		t.Fatalf("failed to compact: %v", err)
+	}
+	if _, ok := lfs.regions[1]; ok {
+		t.Errorf("expected region 1 to be removed after compaction")
+	}
+
+	seg, err := lfs.FetchSegmentContext(context.Background(), InodeNum("key-01"))
+	if err != nil || string(seg.Value) != "value-02" {
+		t.Errorf("expected value-02, got %v (err: %v)", seg, err)
+	}
+}
+
+func TestSegmentSize(t *testing.T) {
+	_, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, SegmentSize: 1 * KB})
+	if err == nil {
+		t.Fatalf("expected error for segment size smaller than minimum")
+	}
+
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 3, SegmentSize: 1 * MB})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer lfs.CloseFS()
+
+	// SegmentSize 覆盖以 GB 为单位的 Threshold
+	if regionThreshold != 1*MB {
+		t.Fatalf("expected region threshold %d, got %d", 1*MB, regionThreshold)
+	}
+
+	value := make([]byte, 1*MB)
+	seg := testSegment("key-01", string(value))
+	err = lfs.AddSegment(InodeNum("key-01"), *seg, 0)
+	if !errors.Is(err, ErrSegmentTooLarge) {
+		t.Errorf("expected ErrSegmentTooLarge, got: %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package vfs
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenBucket 令牌桶，每秒生成 rate 个令牌，最多积攒 1 秒的令牌
+// 令牌可以被预支成负数，预支的部分需要等待令牌重新生成之后才能继续写入
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// reserve 预支 n 个令牌并返回需要等待的时间，调用方需要持有锁
+func (tb *tokenBucket) reserve(n float64, now time.Time) time.Duration {
+	if tb.rate <= 0 {
+		return 0
+	}
+
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.rate {
+		tb.tokens = tb.rate
+	}
+	tb.last = now
+
+	tb.tokens -= n
+	if tb.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+// refund 归还预支的 n 个令牌，最多积攒 1 秒的令牌，调用方需要持有锁
+func (tb *tokenBucket) refund(n float64) {
+	if tb.rate <= 0 {
+		return
+	}
+	tb.tokens += n
+	if tb.tokens > tb.rate {
+		tb.tokens = tb.rate
+	}
+}
+
+// writeLimiter 同时按照每秒写入字节数和每秒写入次数限制写入速度
+// 防止批量导入数据时占满磁盘带宽影响到对延迟敏感的读请求
+type writeLimiter struct {
+	mu    sync.Mutex
+	bytes tokenBucket
+	ops   tokenBucket
+}
+
+func newWriteLimiter(bytesPerSec, opsPerSec int64) *writeLimiter {
+	limiter := new(writeLimiter)
+	limiter.setLimit(bytesPerSec, opsPerSec)
+	return limiter
+}
+
+// setLimit 运行时修改写入速度限制，0 表示不限制
+func (l *writeLimiter) setLimit(bytesPerSec, opsPerSec int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.bytes = tokenBucket{rate: float64(bytesPerSec), tokens: float64(bytesPerSec), last: now}
+	l.ops = tokenBucket{rate: float64(opsPerSec), tokens: float64(opsPerSec), last: now}
+}
+
+// limit 返回当前的写入速度限制
+func (l *writeLimiter) limit() (int64, int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int64(l.bytes.rate), int64(l.ops.rate)
+}
+
+// delay 预支一次写入 n 个字节需要的令牌，返回需要等待的时间
+func (l *writeLimiter) delay(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	wait := l.bytes.reserve(float64(n), now)
+	if d := l.ops.reserve(1, now); d > wait {
+		wait = d
+	}
+	return wait
+}
+
+// refund 归还一次没有写入成功的 n 个字节预支的令牌，写入失败之后不会降低之后的写入速度
+func (l *writeLimiter) refund(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.bytes.refund(float64(n))
+	l.ops.refund(1)
+}
+
+// wait 阻塞直到可以写入 n 个字节
+func (l *writeLimiter) wait(n int) {
+	_ = l.waitContext(context.Background(), n)
+}
+
+// waitContext 阻塞直到可以写入 n 个字节，ctx 取消或者超时时提前返回错误并且归还预支的令牌
+func (l *writeLimiter) waitContext(ctx context.Context, n int) error {
+	d := l.delay(n)
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.refund(n)
+		return ctx.Err()
+	}
+}
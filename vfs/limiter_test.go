@@ -0,0 +1,87 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWriteLimiter(t *testing.T) {
+	limiter := newWriteLimiter(0, 10)
+
+	// 前 10 次写入使用积攒的令牌不需要等待
+	for i := 0; i < 10; i++ {
+		if d := limiter.delay(1); d != 0 {
+			t.Fatalf("expected no delay for write %d, got %v", i, d)
+		}
+	}
+
+	if d := limiter.delay(1); d <= 50*time.Millisecond || d > 150*time.Millisecond {
+		t.Errorf("expected about 100ms delay after burst, got %v", d)
+	}
+
+	limiter.setLimit(1024, 0)
+	if d := limiter.delay(2048); d < 900*time.Millisecond {
+		t.Errorf("expected about 1s delay for 2x bytes burst, got %v", d)
+	}
+
+	// 关闭限速之后不需要等待
+	limiter.setLimit(0, 0)
+	if d := limiter.delay(1 << 20); d != 0 {
+		t.Errorf("expected no delay when limiter disabled, got %v", d)
+	}
+}
+
+func TestWriteLimiterWaitContext(t *testing.T) {
+	limiter := newWriteLimiter(0, 1)
+	// 第一次写入消耗掉已经积攒的令牌
+	limiter.wait(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := limiter.waitContext(ctx, 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected wait to return promptly, took %v", elapsed)
+	}
+}
+
+func TestWriteLimiterRefund(t *testing.T) {
+	limiter := newWriteLimiter(0, 1)
+	limiter.wait(1)
+
+	// 取消的等待归还预支的令牌，下一次写入只需要等待一个令牌
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := limiter.waitContext(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got: %v", err)
+	}
+	if d := limiter.delay(1); d > 1500*time.Millisecond {
+		t.Errorf("expected about 1s delay after refund, got %v", d)
+	}
+
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	inum := InodeNum("key")
+	if err := lfs.AddSegment(inum, *testSegment("key", "value"), 0); err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+
+	// 条件写入失败之后令牌被归还，之后的写入不需要等待
+	lfs.SetWriteLimit(0, 1)
+	if _, err := lfs.PutIfAbsent(inum, *testSegment("key", "value"), 0); err == nil {
+		t.Fatal("expected conditional write to fail")
+	}
+	if d := lfs.limiter.delay(1); d != 0 {
+		t.Errorf("expected no delay after failed write, got %v", d)
+	}
+}
@@ -0,0 +1,130 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrValueTooLarge = errors.New("value too large")
+	ErrKeyTooLarge   = errors.New("key too large")
+)
+
+// 默认的 Key 最大字节数，解码记录时不超过这个大小的 Key 总是合法的
+const defaultMaxKeySize = 64 * KB
+
+// 每种数据类型默认的 Value 最大字节数，防止误把几个 GB 的数据写入不合适的类型
+var defaultMaxValueSize = map[Kind]int64{
+	Set:    256 * MB,
+	ZSet:   256 * MB,
+	List:   256 * MB,
+	Text:   16 * MB,
+	Tables: 256 * MB,
+	Binary: 1 * GB,
+	Number: 1 * KB,
+}
+
+var (
+	valueSizeMu   sync.RWMutex
+	maxValueSizes = copyValueSizes(defaultMaxValueSize)
+	maxKeySize    = int64(defaultMaxKeySize)
+)
+
+func copyValueSizes(sizes map[Kind]int64) map[Kind]int64 {
+	copied := make(map[Kind]int64, len(sizes))
+	for kind, size := range sizes {
+		copied[kind] = size
+	}
+	return copied
+}
+
+// String 返回数据类型的名称
+func (k Kind) String() string {
+	switch k {
+	case Set:
+		return "set"
+	case ZSet:
+		return "zset"
+	case List:
+		return "list"
+	case Text:
+		return "text"
+	case Tables:
+		return "tables"
+	case Binary:
+		return "binary"
+	case Number:
+		return "number"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseKind 返回名称对应的数据类型，名称和 Kind.String 的返回值相同
+func ParseKind(name string) (Kind, error) {
+	for kind := Set; kind < Unknown; kind++ {
+		if kind.String() == name {
+			return kind, nil
+		}
+	}
+	return Unknown, fmt.Errorf("unknown value kind %q", name)
+}
+
+// SetMaxValueSize 修改数据类型的 Value 最大字节数，0 表示不限制
+func SetMaxValueSize(kind Kind, size int64) {
+	valueSizeMu.Lock()
+	defer valueSizeMu.Unlock()
+	maxValueSizes[kind] = size
+}
+
+// MaxValueSize 返回数据类型的 Value 最大字节数，0 表示不限制
+func MaxValueSize(kind Kind) int64 {
+	valueSizeMu.RLock()
+	defer valueSizeMu.RUnlock()
+	return maxValueSizes[kind]
+}
+
+// checkValueSize 检查 size 字节的 Value 是否超过数据类型的限制
+func checkValueSize(kind Kind, size int64) error {
+	limit := MaxValueSize(kind)
+	if limit > 0 && size > limit {
+		return fmt.Errorf("%w: %s value of %d bytes exceeds limit of %d bytes", ErrValueTooLarge, kind, size, limit)
+	}
+	return nil
+}
+
+// SetMaxKeySize 修改 Key 的最大字节数，size 小于等于 0 时恢复默认的 64KB
+func SetMaxKeySize(size int64) {
+	if size <= 0 {
+		size = defaultMaxKeySize
+	}
+	valueSizeMu.Lock()
+	defer valueSizeMu.Unlock()
+	maxKeySize = size
+}
+
+// MaxKeySize 返回 Key 的最大字节数
+func MaxKeySize() int64 {
+	valueSizeMu.RLock()
+	defer valueSizeMu.RUnlock()
+	return maxKeySize
+}
+
+// checkKeySize 写入之前检查 size 字节的 Key 是否超过限制
+func checkKeySize(size int) error {
+	limit := MaxKeySize()
+	if int64(size) > limit {
+		return fmt.Errorf("%w: key of %d bytes exceeds limit of %d bytes", ErrKeyTooLarge, size, limit)
+	}
+	return nil
+}
+
+// decodeKeyLimit 返回解码记录时 Key 的最大字节数，损坏的长度字段不会导致分配过大的内存
+// 调小限制之后之前写入的 Key 仍然可以读取，所以不会小于默认的 64KB
+func decodeKeyLimit() int64 {
+	if limit := MaxKeySize(); limit > defaultMaxKeySize {
+		return limit
+	}
+	return defaultMaxKeySize
+}
@@ -0,0 +1,83 @@
+package vfs
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMaxValueSize(t *testing.T) {
+	lfs, err := OpenFS(&Options{
+		Path:         t.TempDir(),
+		FsPerm:       fsPerm,
+		Threshold:    1,
+		MaxValueSize: map[Kind]int64{Binary: 8},
+	})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer lfs.CloseFS()
+	defer SetMaxValueSize(Binary, defaultMaxValueSize[Binary])
+
+	err = lfs.AddSegment(InodeNum("key-01"), *testSegment("key-01", "value-01"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment within limit: %v", err)
+	}
+
+	err = lfs.AddSegment(InodeNum("key-02"), *testSegment("key-02", "value-too-large"), 0)
+	if !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected ErrValueTooLarge, got: %v", err)
+	}
+
+	// 删除记录不受 Value 大小的限制
+	err = lfs.DelSegment("key-01")
+	if err != nil {
+		t.Errorf("failed to delete segment: %v", err)
+	}
+
+	if err := checkValueSize(Text, 17*MB); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("expected default text limit to reject 17MB, got: %v", err)
+	}
+}
+
+func TestMaxKeySize(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1, MaxKeySize: 8})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer lfs.CloseFS()
+	defer SetMaxKeySize(0)
+
+	err = lfs.AddSegment(InodeNum("key-01"), *testSegment("key-01", "value"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment within limit: %v", err)
+	}
+
+	key := "key-too-large"
+	err = lfs.AddSegment(InodeNum(key), *testSegment(key, "value"), 0)
+	if !errors.Is(err, ErrKeyTooLarge) {
+		t.Fatalf("expected ErrKeyTooLarge, got: %v", err)
+	}
+	if err := lfs.DelSegment(key); !errors.Is(err, ErrKeyTooLarge) {
+		t.Errorf("expected ErrKeyTooLarge for tombstone, got: %v", err)
+	}
+	if err := lfs.DeleteRange("a", key); !errors.Is(err, ErrKeyTooLarge) {
+		t.Errorf("expected ErrKeyTooLarge for range end, got: %v", err)
+	}
+
+	// 调小限制之后解码仍然接受不超过默认大小的 Key
+	if limit := decodeKeyLimit(); limit != defaultMaxKeySize {
+		t.Errorf("expected decode limit %d, got %d", defaultMaxKeySize, limit)
+	}
+}
+
+func TestParseKind(t *testing.T) {
+	for kind := Set; kind < Unknown; kind++ {
+		parsed, err := ParseKind(kind.String())
+		if err != nil || parsed != kind {
+			t.Errorf("ParseKind(%q) = %v, %v", kind.String(), parsed, err)
+		}
+	}
+	if _, err := ParseKind("unknown"); err == nil {
+		t.Error("expected error for unknown kind")
+	}
+}
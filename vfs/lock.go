@@ -0,0 +1,75 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/auula/wiredkv/utils"
+)
+
+// 数据目录锁文件，防止多个进程同时打开同一个数据目录
+const lockFileName = "wiredkv.lock"
+
+// 只读进程持有这个锁文件的共享锁，迁移这类会重写数据文件的操作需要获取它的排他锁
+const readersLockFileName = "wiredkv.readers.lock"
+
+var (
+	ErrDirLocked     = errors.New("data directory is locked by another process")
+	ErrReadersActive = errors.New("data directory is opened by read-only processes")
+)
+
+// dirLock 持有数据目录锁文件的排他锁，进程退出时操作系统会自动释放
+type dirLock struct {
+	file *os.File
+}
+
+// lockDir 获取数据目录的排他锁，已经被其他进程或者其他实例锁定时返回 ErrDirLocked
+func lockDir(path string) (*dirLock, error) {
+	return openLock(filepath.Join(path, lockFileName), false)
+}
+
+// lockReaders 获取只读进程锁文件的排他锁，还有只读进程打开数据目录时返回 ErrReadersActive
+func lockReaders(path string) (*dirLock, error) {
+	lock, err := openLock(filepath.Join(path, readersLockFileName), false)
+	if errors.Is(err, ErrDirLocked) {
+		return nil, ErrReadersActive
+	}
+	return lock, err
+}
+
+// lockDirShared 获取只读进程锁文件的共享锁，多个只读进程可以同时持有，不影响写入进程的排他锁
+func lockDirShared(path string) (*dirLock, error) {
+	return openLock(filepath.Join(path, readersLockFileName), true)
+}
+
+func openLock(name string, shared bool) (*dirLock, error) {
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, fsPerm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	err = lockFile(file, shared)
+	if err != nil {
+		_ = utils.CloseFile(file)
+		return nil, err
+	}
+
+	return &dirLock{file: file}, nil
+}
+
+// unlock 释放数据目录锁，锁文件本身保留在数据目录中
+func (l *dirLock) unlock() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+
+	err := unlockFile(l.file)
+	if cerr := utils.CloseFile(l.file); err == nil {
+		err = cerr
+	}
+	l.file = nil
+
+	return err
+}
@@ -0,0 +1,16 @@
+//go:build !unix && !windows
+
+package vfs
+
+import "os"
+
+const unlockBeforeRename = false
+
+// 不支持文件锁的平台不限制多个进程同时打开数据目录
+func lockFile(f *os.File, shared bool) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,34 @@
+package vfs
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLockDir(t *testing.T) {
+	path := t.TempDir()
+	opt := &Options{Path: path, FsPerm: fsPerm, Threshold: 1}
+	lfs, err := OpenFS(opt)
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+
+	// 同一个数据目录不能被打开两次
+	_, err = OpenFS(opt)
+	if !errors.Is(err, ErrDirLocked) {
+		t.Fatalf("expected ErrDirLocked, got: %v", err)
+	}
+
+	_, err = MigrateRegions(path, currentFormat)
+	if !errors.Is(err, ErrDirLocked) {
+		t.Fatalf("expected ErrDirLocked from migrate, got: %v", err)
+	}
+
+	mustCloseFS(t, lfs)
+
+	lfs, err = OpenFS(opt)
+	if err != nil {
+		t.Fatalf("failed to reopen fs after close: %v", err)
+	}
+	mustCloseFS(t, lfs)
+}
@@ -0,0 +1,56 @@
+//go:build unix
+
+package vfs
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// 文件打开时目录可以重命名，替换数据目录的过程中一直持有锁
+const unlockBeforeRename = false
+
+// lockFile 使用 flock 非阻塞地获取排他锁或者共享锁
+func lockFile(f *os.File, shared bool) error {
+	how := syscall.LOCK_EX
+	if shared {
+		how = syscall.LOCK_SH
+	}
+
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+	err = conn.Control(func(fd uintptr) {
+		serr = syscall.Flock(int(fd), how|syscall.LOCK_NB)
+	})
+	if err != nil {
+		return err
+	}
+
+	if errors.Is(serr, syscall.EWOULDBLOCK) {
+		return ErrDirLocked
+	}
+
+	return serr
+}
+
+func unlockFile(f *os.File) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+	err = conn.Control(func(fd uintptr) {
+		serr = syscall.Flock(int(fd), syscall.LOCK_UN)
+	})
+	if err != nil {
+		return err
+	}
+
+	return serr
+}
@@ -0,0 +1,77 @@
+//go:build windows
+
+package vfs
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+// Windows 上打开的文件会导致所在的目录不能重命名，替换数据目录之前需要先释放锁
+const unlockBeforeRename = true
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	errorLockViolation      = syscall.Errno(33)
+)
+
+// lockFile 使用 LockFileEx 非阻塞地锁定文件的第一个字节，没有 LOCKFILE_EXCLUSIVE_LOCK 标志时是共享锁
+func lockFile(f *os.File, shared bool) error {
+	flags := uintptr(lockfileExclusiveLock | lockfileFailImmediately)
+	if shared {
+		flags = lockfileFailImmediately
+	}
+
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+	err = conn.Control(func(fd uintptr) {
+		var overlapped syscall.Overlapped
+		r, _, e := procLockFileEx.Call(fd, flags, 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+		if r == 0 {
+			serr = e
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if errors.Is(serr, errorLockViolation) {
+		return ErrDirLocked
+	}
+
+	return serr
+}
+
+func unlockFile(f *os.File) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+	err = conn.Control(func(fd uintptr) {
+		var overlapped syscall.Overlapped
+		r, _, e := procUnlockFileEx.Call(fd, 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+		if r == 0 {
+			serr = e
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return serr
+}
@@ -0,0 +1,41 @@
+//go:build windows
+
+package vfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWindowsSyncAndPreallocate(t *testing.T) {
+	fd, err := os.OpenFile(filepath.Join(t.TempDir(), formatDataFileName(1)), RWCA, fsPerm)
+	if err != nil {
+		t.Fatalf("failed to create region: %v", err)
+	}
+	defer fd.Close()
+
+	_, err = fd.Write(fileMetadata(currentFormat, ChecksumCRC32))
+	if err != nil {
+		t.Fatalf("failed to write metadata: %v", err)
+	}
+
+	err = preallocate(fd, int64(1*MB))
+	if err != nil {
+		t.Fatalf("failed to preallocate: %v", err)
+	}
+
+	// FileAllocationInfo 不能改变文件大小
+	finfo, err := fd.Stat()
+	if err != nil {
+		t.Fatalf("failed to stat region: %v", err)
+	}
+	if finfo.Size() != int64(len(dataFileMetadata)) {
+		t.Errorf("expected size %d, got %d", len(dataFileMetadata), finfo.Size())
+	}
+
+	err = fdatasync(fd)
+	if err != nil {
+		t.Fatalf("failed to flush file buffers: %v", err)
+	}
+}
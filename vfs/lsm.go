@@ -0,0 +1,777 @@
+package vfs
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/auula/wiredkv/clog"
+)
+
+// Engine 选择存储引擎写入和整理数据文件的方式
+type Engine uint8
+
+const (
+	// EngineLog 默认的日志结构，记录按照写入顺序追加，压缩时迁移到活跃数据文件
+	EngineLog Engine = iota
+	// EngineLSM 适合写入很多的场景，活跃数据文件作为预写日志，同时把记录放入按照 Key 排序的内存表
+	// 活跃数据文件封存之后，内存表在后台写入 level 0 的排序数据文件，然后按层合并到更大的排序数据文件
+	// 内存索引仍然包含所有的 Key，排序数据文件让范围遍历可以顺序读取
+	EngineLSM
+)
+
+const (
+	// level 0 排序数据文件达到这个数量时合并到 level 1
+	lsmL0Files = 4
+	// level n 的大小上限是单个数据文件大小的 lsmLevelRatio^n 倍
+	lsmLevelRatio = 10
+	// 最大的层级，最后一层不再向下合并
+	lsmMaxLevel = 6
+	// 内存表跳表的最大高度
+	memtableMaxHeight = 12
+	// 刷新或者合并失败之后重试的周期
+	lsmRetryInterval = time.Second
+	// 内存表节点除了 Key、记录和每一层的指针之外大约占用的字节数
+	memNodeSize = int64(64)
+)
+
+func (e Engine) validate() error {
+	if e != EngineLog && e != EngineLSM {
+		return fmt.Errorf("invalid storage engine: %d", e)
+	}
+	return nil
+}
+
+// memEntry 是内存表中一个 Key 最后一次写入的记录，删除记录的 inode 为 nil
+type memEntry struct {
+	seg   *Segment
+	inode *INode
+}
+
+type memNode struct {
+	key   string
+	entry memEntry
+	next  []*memNode
+}
+
+// size 估算节点和它保存的记录占用的字节数
+func (n *memNode) size() int64 {
+	return memNodeSize + int64(len(n.key)) + int64(8*len(n.next)) + int64(n.entry.seg.Size())
+}
+
+// memtable 是一个活跃数据文件中每个 Key 最后一次写入的记录，按照 Key 排序的跳表
+// 范围删除记录单独保存，写入排序数据文件时排在所有记录的前面
+type memtable struct {
+	regionID uint64
+	cmp      Comparator
+	// scan 为 true 时内存表不完整，例如重新打开之前写入的数据文件，刷新时扫描数据文件重建
+	scan   bool
+	head   *memNode
+	height int
+	ranges []*Segment
+	seed   uint64
+	bytes  int64 // 节点和记录估算占用的字节数
+}
+
+func newMemtable(regionID uint64, cmp Comparator) *memtable {
+	return &memtable{
+		regionID: regionID,
+		cmp:      cmp,
+		head:     &memNode{next: make([]*memNode, memtableMaxHeight)},
+		height:   1,
+		seed:     uint64(time.Now().UnixNano()) | 1,
+	}
+}
+
+// randomHeight 每一层的概率是上一层的四分之一
+func (m *memtable) randomHeight() int {
+	m.seed ^= m.seed << 13
+	m.seed ^= m.seed >> 7
+	m.seed ^= m.seed << 17
+	height := 1
+	for r := m.seed; height < memtableMaxHeight && r&3 == 0; r >>= 2 {
+		height++
+	}
+	return height
+}
+
+// findPrev 返回每一层中最后一个小于 key 的节点
+func (m *memtable) findPrev(key string) []*memNode {
+	prev := make([]*memNode, memtableMaxHeight)
+	node := m.head
+	for level := m.height - 1; level >= 0; level-- {
+		for node.next[level] != nil && compareKeys(m.cmp, node.next[level].key, key) < 0 {
+			node = node.next[level]
+		}
+		prev[level] = node
+	}
+	return prev
+}
+
+func (m *memtable) get(key string) (memEntry, bool) {
+	node := m.findPrev(key)[0].next[0]
+	if node == nil || node.key != key {
+		return memEntry{}, false
+	}
+	return node.entry, true
+}
+
+func (m *memtable) put(key string, entry memEntry) {
+	prev := m.findPrev(key)
+	if node := prev[0].next[0]; node != nil && node.key == key {
+		m.bytes += int64(entry.seg.Size()) - int64(node.entry.seg.Size())
+		node.entry = entry
+		return
+	}
+
+	height := m.randomHeight()
+	for ; m.height < height; m.height++ {
+		prev[m.height] = m.head
+	}
+	node := &memNode{key: key, entry: entry, next: make([]*memNode, height)}
+	m.bytes += node.size()
+	for level := 0; level < height; level++ {
+		node.next[level] = prev[level].next[level]
+		prev[level].next[level] = node
+	}
+}
+
+// removeRange 删除 [start, end) 范围内的记录并且保存范围删除记录，end 为空表示没有上限
+func (m *memtable) removeRange(seg *Segment) {
+	start, end := seg.Range()
+	prev := m.findPrev(start)
+	for node := prev[0].next[0]; node != nil && inRange(m.cmp, node.key, start, end); node = node.next[0] {
+		for level := 0; level < len(node.next); level++ {
+			prev[level].next[level] = node.next[level]
+		}
+		m.bytes -= node.size()
+	}
+	m.ranges = append(m.ranges, seg)
+	m.bytes += int64(seg.Size())
+}
+
+// each 按照 Key 的顺序遍历内存表中的记录
+func (m *memtable) each(fn func(key string, entry memEntry) error) error {
+	for node := m.head.next[0]; node != nil; node = node.next[0] {
+		if err := fn(node.key, node.entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply 把追加到活跃数据文件的记录放入内存表
+func (m *memtable) apply(seg *Segment, inode *INode) {
+	if m.scan || seg.Flags&flagBatchCommit != 0 {
+		return
+	}
+
+	// 写入的 Segment 之后可能被调用方修改，内存表保存一份副本
+	copied := *seg
+	copied.Key = append([]byte(nil), seg.Key...)
+	copied.Value = append([]byte(nil), seg.Value...)
+	copied.Flags &^= flagBatch
+
+	if copied.IsRangeTombstone() {
+		m.removeRange(&copied)
+		return
+	}
+	if copied.IsTombstone() {
+		m.put(inode.Key, memEntry{seg: &copied})
+		return
+	}
+	m.put(inode.Key, memEntry{seg: &copied, inode: inode})
+}
+
+// memtableReplayer 扫描一个数据文件重建它的内存表
+type memtableReplayer struct {
+	m        *memtable
+	fd       vfsFile
+	version  uint8
+	checksum Checksum
+	err      error
+}
+
+func (r *memtableReplayer) put(inum uint64, inode *INode) {
+	_, seg, _, err := readRawSegment(r.fd, inode.Position, r.version, r.checksum)
+	if err != nil {
+		if r.err == nil {
+			r.err = err
+		}
+		return
+	}
+	seg.Flags &^= flagBatch
+	r.m.put(inode.Key, memEntry{seg: seg, inode: inode})
+}
+
+func (r *memtableReplayer) remove(inum uint64, key string) {
+	r.m.put(key, memEntry{seg: NewTombstoneSegment([]byte(key))})
+}
+
+func (r *memtableReplayer) removeRange(start, end string) {
+	r.m.removeRange(NewRangeTombstoneSegment([]byte(start), []byte(end)))
+}
+
+// lsmState 是 EngineLSM 的内存表和后台刷新的状态，lfs.mu 和 mu 同时持有时先获取 lfs.mu
+type lsmState struct {
+	mu        sync.Mutex
+	active    *memtable   // 活跃数据文件的内存表
+	immutable []*memtable // 已经封存等待写入排序数据文件的内存表，从旧到新
+	cmp       Comparator
+	notify    chan struct{}
+	done      chan struct{}
+	exit      chan struct{}
+}
+
+// apply 把追加到活跃数据文件 regionID 的记录放入内存表，调用方需要持有 lfs.mu 锁
+func (s *lsmState) apply(regionID uint64, segs []*Segment, inodes []*INode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active == nil || s.active.regionID != regionID {
+		s.active = newMemtable(regionID, s.cmp)
+	}
+	for i, seg := range segs {
+		s.active.apply(seg, inodes[i])
+	}
+}
+
+// freeze 冻结刚刚封存的数据文件的内存表，调用方需要持有 lfs.mu 锁
+func (s *lsmState) freeze(regionID uint64) {
+	s.mu.Lock()
+	m := s.active
+	if m == nil || m.regionID != regionID {
+		m = &memtable{regionID: regionID, scan: true}
+	}
+	s.immutable = append(s.immutable, m)
+	s.active = nil
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// memory 返回所有内存表估算占用的字节数
+func (s *lsmState) memory() int64 {
+	if s == nil {
+		return 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var bytes int64
+	if s.active != nil {
+		bytes += s.active.bytes
+	}
+	for _, m := range s.immutable {
+		bytes += m.bytes
+	}
+	return bytes
+}
+
+// pending 判断数据文件是否还在等待写入排序数据文件
+func (s *lsmState) pending(regionID uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active != nil && s.active.regionID == regionID {
+		return true
+	}
+	for _, m := range s.immutable {
+		if m.regionID == regionID {
+			return true
+		}
+	}
+	return false
+}
+
+// get 从内存表中读取 inode 引用的记录，记录不在内存表中时返回 nil
+func (s *lsmState) get(inode *INode) *Segment {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.active
+	if m == nil || m.regionID != inode.RegionID {
+		m = nil
+		for _, frozen := range s.immutable {
+			if frozen.regionID == inode.RegionID {
+				m = frozen
+			}
+		}
+	}
+	if m == nil || m.scan {
+		return nil
+	}
+
+	entry, ok := m.get(inode.Key)
+	if !ok || entry.inode == nil || entry.inode.Position != inode.Position {
+		return nil
+	}
+	seg := *entry.seg
+	return &seg
+}
+
+// openLSM 在恢复索引之后初始化 EngineLSM，重新打开之前没有刷新的数据文件在后台扫描之后刷新
+// 一个非排序数据文件的下一个编号不存在时，说明它是还没有刷新的预写日志，它的排序数据文件使用这个编号
+func (lfs *LogStructuredFS) openLSM() {
+	s := &lsmState{
+		cmp:    lfs.comparator,
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		exit:   make(chan struct{}),
+	}
+
+	lfs.mu.Lock()
+	s.active = newMemtable(lfs.regionID, s.cmp)
+	s.active.scan = lfs.offset > int64(len(dataFileMetadata))
+	var leftover []uint64
+	for id := range lfs.regions {
+		if _, ok := lfs.regions[id+1]; ok || id == lfs.regionID || id+1 == lfs.regionID {
+			continue
+		}
+		leftover = append(leftover, id)
+	}
+	lfs.mu.Unlock()
+
+	sort.Slice(leftover, func(i, j int) bool {
+		return leftover[i] < leftover[j]
+	})
+	for _, id := range leftover {
+		if lfs.regionSparseIndex(id) == nil {
+			s.immutable = append(s.immutable, &memtable{regionID: id, scan: true})
+		}
+	}
+	if len(s.immutable) > 0 {
+		s.notify <- struct{}{}
+	}
+
+	lfs.lsm = s
+	go lfs.runLSM()
+}
+
+// runLSM 在后台刷新冻结的内存表并且按层合并排序数据文件
+func (lfs *LogStructuredFS) runLSM() {
+	s := lfs.lsm
+	defer close(s.exit)
+
+	ticker := time.NewTicker(lsmRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.notify:
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+
+		err := lfs.flushMemtables()
+		if err == nil {
+			err = lfs.compactLevels(context.Background())
+		}
+		if err != nil && !errors.Is(err, ErrClosed) {
+			clog.Errorf("failed to maintain lsm levels: %v", err)
+		}
+	}
+}
+
+// stopLSM 停止后台刷新，还没有刷新的内存表对应的数据文件下次打开时扫描之后刷新
+func (lfs *LogStructuredFS) stopLSM() {
+	if lfs.lsm == nil {
+		return
+	}
+	select {
+	case <-lfs.lsm.done:
+		return
+	default:
+	}
+	close(lfs.lsm.done)
+	<-lfs.lsm.exit
+}
+
+// flushMemtables 按照从旧到新的顺序刷新所有冻结的内存表
+func (lfs *LogStructuredFS) flushMemtables() error {
+	s := lfs.lsm
+	for {
+		s.mu.Lock()
+		if len(s.immutable) == 0 {
+			s.mu.Unlock()
+			return nil
+		}
+		m := s.immutable[0]
+		s.mu.Unlock()
+
+		err := lfs.flushMemtable(m)
+		if err != nil {
+			return fmt.Errorf("failed to flush region %d: %w", m.regionID, err)
+		}
+
+		s.mu.Lock()
+		s.immutable = s.immutable[1:]
+		s.mu.Unlock()
+	}
+}
+
+// flushMemtable 把内存表按照 Key 的顺序写入 level 0 的排序数据文件，然后删除作为预写日志的数据文件
+// 排序数据文件的编号是预写日志的下一个编号，封存时已经预留，崩溃恢复的重放顺序和预写日志完全一致
+func (lfs *LogStructuredFS) flushMemtable(m *memtable) error {
+	lfs.compactMu.Lock()
+	defer lfs.compactMu.Unlock()
+
+	lfs.mu.Lock()
+	if lfs.closed {
+		lfs.mu.Unlock()
+		return ErrClosed
+	}
+	fd, ok := lfs.regions[m.regionID]
+	version, checksum := lfs.versions[m.regionID], lfs.checksums[m.regionID]
+	_, taken := lfs.regions[m.regionID+1]
+	lfs.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if taken {
+		return fmt.Errorf("region %d reserved for sorted region already exists", m.regionID+1)
+	}
+
+	if m.scan {
+		finfo, err := fd.Stat()
+		if err != nil {
+			return err
+		}
+		r := &memtableReplayer{m: newMemtable(m.regionID, m.cmp), fd: fd, version: version, checksum: checksum}
+		_, err = replayRegion(&sealedFile{vfsFile: fd, size: finfo.Size()}, m.regionID, version, checksum, r)
+		if err == nil {
+			err = r.err
+		}
+		if err != nil {
+			return err
+		}
+		m = r.m
+	}
+
+	// 没有任何记录的预写日志不需要生成排序数据文件，例如压缩时封存的空活跃数据文件
+	if m.head.next[0] == nil && len(m.ranges) == 0 {
+		return lfs.removeWAL(m.regionID, fd)
+	}
+
+	file, err := lfs.createIngestFile("flush", 0)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if file.tmp != "" {
+			_ = file.fd.Close()
+			_ = os.Remove(file.tmp)
+		}
+	}()
+
+	flushed, err := lfs.writeMemtable(file, m)
+	if err != nil {
+		return err
+	}
+
+	return lfs.commitFlush(m.regionID, fd, flushed)
+}
+
+// flushedRegion 是内存表写入之后的排序数据文件
+type flushedRegion struct {
+	*ingestFile
+	olds  []*INode // 每条记录在预写日志中的索引
+	dead  int64    // 删除记录的字节数
+	index sparseIndex
+	hint  hintWriter
+}
+
+// writeMemtable 先写入范围删除记录，然后按照 Key 的顺序写入内存表中的记录
+func (lfs *LogStructuredFS) writeMemtable(file *ingestFile, m *memtable) (*flushedRegion, error) {
+	flushed := &flushedRegion{ingestFile: file}
+	w := bufio.NewWriterSize(file.fd, int(sortedBlockSize))
+
+	// 每条记录都序列化到同一个缓冲区中，bufio.Writer 写入时会复制
+	var data []byte
+	write := func(seg *Segment) (int64, error) {
+		var err error
+		data, err = appendEncodedSegment(data[:0], seg, currentFormat, lfs.checksum)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return 0, fmt.Errorf("failed to write sorted region: %w", err)
+		}
+		position := file.size
+		file.size += int64(len(data))
+		return position, nil
+	}
+
+	for _, seg := range m.ranges {
+		if _, err := write(seg); err != nil {
+			return nil, err
+		}
+		flushed.dead += int64(seg.Size())
+		flushed.hint.removeRange(seg.Range())
+	}
+
+	err := m.each(func(key string, entry memEntry) error {
+		blocks := flushed.index.blocks
+		if len(blocks) == 0 || file.size-blocks[len(blocks)-1].Offset >= sortedBlockSize {
+			flushed.index.blocks = append(blocks, sparseBlock{Key: key, Offset: file.size})
+		}
+		flushed.index.last = key
+
+		position, err := write(entry.seg)
+		if err != nil {
+			return err
+		}
+
+		inum := InodeNum(key)
+		if entry.inode == nil {
+			flushed.dead += file.size - position
+			flushed.hint.remove(inum, key)
+			return nil
+		}
+
+		inode := &INode{
+			Position:  position,
+			Length:    uint32(file.size - position),
+			CreatedAt: entry.seg.CreatedAt,
+			ExpiredAt: entry.seg.ExpiredAt,
+			Version:   entry.seg.Version,
+			Key:       key,
+		}
+		file.inums = append(file.inums, inum)
+		file.inodes = append(file.inodes, inode)
+		flushed.olds = append(flushed.olds, entry.inode)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := w.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write sorted region: %w", err)
+	}
+	flushed.index.size = file.size
+
+	err = closeFile(file.fd)
+	file.fd = nil
+	if err != nil {
+		return nil, fmt.Errorf("failed to close sorted region: %w", err)
+	}
+
+	return flushed, nil
+}
+
+// commitFlush 把排序数据文件加入存储引擎，更新索引之后删除预写日志
+func (lfs *LogStructuredFS) commitFlush(walID uint64, wal vfsFile, flushed *flushedRegion) error {
+	regionID := walID + 1
+	name := filepath.Join(lfs.directory, formatDataFileName(regionID))
+
+	lfs.mu.Lock()
+	if lfs.closed {
+		lfs.mu.Unlock()
+		return ErrClosed
+	}
+	err := os.Rename(flushed.tmp, name)
+	if err == nil {
+		flushed.fd, err = os.OpenFile(name, os.O_RDWR, lfs.fsPerm)
+	}
+	if err != nil {
+		lfs.mu.Unlock()
+		return fmt.Errorf("failed to commit region %d: %w", regionID, err)
+	}
+	flushed.tmp = ""
+
+	lfs.regions[regionID] = flushed.fd
+	lfs.versions[regionID] = currentFormat
+	lfs.checksums[regionID] = lfs.checksum
+	lfs.touchRegion(regionID)
+	usage := lfs.regionUsage(regionID)
+	usage.dead += flushed.dead
+	for _, inode := range flushed.inodes {
+		inode.RegionID = regionID
+		usage.live += int64(inode.Length)
+	}
+	lfs.mu.Unlock()
+
+	// 预写日志之后的写入都在更新的数据文件中，索引已经不再引用预写日志中的记录时直接标记为垃圾数据
+	for i, inum := range flushed.inums {
+		inode, old := flushed.inodes[i], flushed.olds[i]
+		shard := lfs.indexs[inum%uint64(indexShard)]
+		shard.mu.Lock()
+		current, ok := shard.get(inum)
+		swapped := ok && current.RegionID == walID && current.Position == old.Position
+		if swapped {
+			shard.set(inum, inode)
+		}
+		shard.mu.Unlock()
+		if !swapped {
+			lfs.markDead(inode)
+		}
+		flushed.hint.put(inum, inode)
+	}
+
+	flushed.index.level = 0
+	err = saveSparseIndex(name, &flushed.index, lfs.fsPerm)
+	if err != nil {
+		clog.Warnf("failed to write sparse index for region %d: %v", regionID, err)
+	} else {
+		lfs.mu.Lock()
+		lfs.sparse[regionID] = &flushed.index
+		lfs.mu.Unlock()
+	}
+
+	var sequence uint64
+	for _, inode := range flushed.inodes {
+		if inode.Version > sequence {
+			sequence = inode.Version
+		}
+	}
+	err = saveHint(name, sequence, &flushed.hint, lfs.fsPerm)
+	if err != nil {
+		clog.Warnf("failed to write hint file for region %d: %v", regionID, err)
+	}
+
+	return lfs.removeWAL(walID, wal)
+}
+
+// removeWAL 删除已经刷新的预写日志
+func (lfs *LogStructuredFS) removeWAL(walID uint64, wal vfsFile) error {
+	lfs.mu.Lock()
+	defer lfs.mu.Unlock()
+	delete(lfs.regions, walID)
+	delete(lfs.usage, walID)
+	delete(lfs.versions, walID)
+	delete(lfs.checksums, walID)
+	delete(lfs.accessed, walID)
+	delete(lfs.sparse, walID)
+
+	err := wal.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close flushed region: %w", err)
+	}
+	return removeRegionFile(wal)
+}
+
+// sortedLevel 是 LSM 模式中一个层级的排序数据文件，按照编号从小到大排序
+type sortedLevel struct {
+	ids     []uint64
+	indexes []*sparseIndex
+	size    int64
+}
+
+// overlapping 返回这一层中和 idx 的 Key 范围有交集的数据文件
+func (l *sortedLevel) overlapping(idx *sparseIndex, cmp Comparator) []uint64 {
+	var ids []uint64
+	for i, other := range l.indexes {
+		if other.overlaps(idx, cmp) {
+			ids = append(ids, l.ids[i])
+		}
+	}
+	return ids
+}
+
+// sortedLevels 按照层级返回所有的排序数据文件
+func (lfs *LogStructuredFS) sortedLevels() []*sortedLevel {
+	lfs.mu.Lock()
+	regionIds := make([]uint64, 0, len(lfs.regions))
+	for id := range lfs.regions {
+		if id != lfs.regionID {
+			regionIds = append(regionIds, id)
+		}
+	}
+	lfs.mu.Unlock()
+	sort.Slice(regionIds, func(i, j int) bool {
+		return regionIds[i] < regionIds[j]
+	})
+
+	levels := make([]*sortedLevel, lsmMaxLevel+1)
+	for i := range levels {
+		levels[i] = new(sortedLevel)
+	}
+	for _, id := range regionIds {
+		idx := lfs.regionSparseIndex(id)
+		if idx == nil || idx.level > lsmMaxLevel {
+			continue
+		}
+		level := levels[idx.level]
+		level.ids = append(level.ids, id)
+		level.indexes = append(level.indexes, idx)
+		level.size += idx.size
+	}
+
+	return levels
+}
+
+// pickLevel 选择下一次需要合并的排序数据文件和合并之后的层级，不需要合并时返回 nil
+// level 0 的数据文件之间 Key 范围会重叠，全部和 level 1 中有交集的数据文件一起合并
+// 其他层级超过大小上限时，把最旧的一个数据文件和下一层中有交集的数据文件合并
+func (lfs *LogStructuredFS) pickLevel() ([]uint64, int) {
+	levels := lfs.sortedLevels()
+
+	if len(levels[0].ids) >= lsmL0Files {
+		merged := new(sparseIndex)
+		for _, idx := range levels[0].indexes {
+			if len(idx.blocks) == 0 {
+				continue
+			}
+			if len(merged.blocks) == 0 {
+				merged.blocks = []sparseBlock{{Key: idx.blocks[0].Key}}
+				merged.last = idx.last
+			}
+			if lfs.comparator.Compare(idx.blocks[0].Key, merged.blocks[0].Key) < 0 {
+				merged.blocks[0].Key = idx.blocks[0].Key
+			}
+			if lfs.comparator.Compare(idx.last, merged.last) > 0 {
+				merged.last = idx.last
+			}
+		}
+		return append(append([]uint64(nil), levels[0].ids...), levels[1].overlapping(merged, lfs.comparator)...), 1
+	}
+
+	limit := regionThreshold
+	for n := 1; n < lsmMaxLevel; n++ {
+		limit *= lsmLevelRatio
+		if levels[n].size > limit && len(levels[n].ids) > 0 {
+			return append([]uint64{levels[n].ids[0]}, levels[n+1].overlapping(levels[n].indexes[0], lfs.comparator)...), n + 1
+		}
+	}
+
+	return nil, 0
+}
+
+// compactLevels 合并排序数据文件，直到每一层都没有超过上限
+func (lfs *LogStructuredFS) compactLevels(ctx context.Context) error {
+	for {
+		regionIds, level := lfs.pickLevel()
+		if len(regionIds) == 0 {
+			return nil
+		}
+		err := lfs.compactSorted(ctx, regionIds, level)
+		if err != nil {
+			return fmt.Errorf("failed to compact level %d: %w", level, err)
+		}
+	}
+}
+
+// compactLSM 是 LSM 模式中压缩单个数据文件的方式，重写为同一层级的排序数据文件
+func (lfs *LogStructuredFS) compactLSM(ctx context.Context, regionID uint64) error {
+	if lfs.lsm.pending(regionID) {
+		return fmt.Errorf("region %d is waiting to be flushed", regionID)
+	}
+	level := 0
+	if idx := lfs.regionSparseIndex(regionID); idx != nil {
+		level = idx.level
+	}
+	return lfs.compactSorted(ctx, []uint64{regionID}, level)
+}
@@ -0,0 +1,234 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitLSM 等待后台刷新完所有冻结的内存表并且完成合并
+func waitLSM(t *testing.T, lfs *LogStructuredFS, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		lfs.lsm.mu.Lock()
+		pending := len(lfs.lsm.immutable)
+		lfs.lsm.mu.Unlock()
+		if pending == 0 && done() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for lsm flush")
+}
+
+func TestMemtable(t *testing.T) {
+	m := newMemtable(1, nil)
+	for i := 9; i >= 0; i-- {
+		key := fmt.Sprintf("key-%d", i)
+		m.apply(testSegment(key, "value"), &INode{RegionID: 1, Position: int64(i), Key: key})
+	}
+	m.apply(testSegment("key-3", "updated"), &INode{RegionID: 1, Position: 10, Key: "key-3"})
+	m.apply(NewRangeTombstoneSegment([]byte("key-5"), []byte("key-8")), nil)
+
+	var keys []string
+	err := m.each(func(key string, entry memEntry) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to iterate memtable: %v", err)
+	}
+	expected := []string{"key-0", "key-1", "key-2", "key-3", "key-4", "key-8", "key-9"}
+	if fmt.Sprint(keys) != fmt.Sprint(expected) {
+		t.Fatalf("expected keys %v, got %v", expected, keys)
+	}
+
+	entry, ok := m.get("key-3")
+	if !ok || string(entry.seg.Value) != "updated" || entry.inode.Position != 10 {
+		t.Fatalf("expected updated key-3, got %+v", entry)
+	}
+	if len(m.ranges) != 1 {
+		t.Fatalf("expected 1 range tombstone, got %d", len(m.ranges))
+	}
+}
+
+func TestLSMFlush(t *testing.T) {
+	path := t.TempDir()
+	opt := &Options{Path: path, FsPerm: fsPerm, Threshold: 1, Engine: EngineLSM}
+	lfs, err := OpenFS(opt)
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+
+	for i := 99; i >= 0; i-- {
+		key := fmt.Sprintf("key-%03d", i)
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, key), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+	err = lfs.AddSegment(InodeNum("key-000"), *testSegment("key-000", "updated"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+	err = lfs.DelSegment("key-001")
+	if err != nil {
+		t.Fatalf("failed to delete segment: %v", err)
+	}
+	err = lfs.DeleteRange("key-090", "")
+	if err != nil {
+		t.Fatalf("failed to delete range: %v", err)
+	}
+
+	// 刷新之前从内存表读取
+	seg, err := lfs.FetchSegment(InodeNum("key-000"))
+	if err != nil || string(seg.Value) != "updated" {
+		t.Fatalf("expected updated value from memtable, got %v %v", seg, err)
+	}
+
+	err = lfs.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+	waitLSM(t, lfs, func() bool { return true })
+
+	if _, err := os.Stat(filepath.Join(path, formatDataFileName(1))); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected flushed wal region to be removed, got %v", err)
+	}
+	idx, err := loadSparseIndex(filepath.Join(path, formatDataFileName(2)))
+	if err != nil {
+		t.Fatalf("failed to load sparse index: %v", err)
+	}
+	if idx.level != 0 || idx.blocks[0].Key != "key-000" || idx.last != "key-089" {
+		t.Fatalf("expected level 0 sorted region key-000..key-089, got level %d %q..%q", idx.level, idx.blocks[0].Key, idx.last)
+	}
+
+	check := func(lfs *LogStructuredFS) {
+		t.Helper()
+		if lfs.Count() != 89 {
+			t.Fatalf("expected 89 keys, got %d", lfs.Count())
+		}
+		seg, err := lfs.FetchSegment(InodeNum("key-000"))
+		if err != nil || string(seg.Value) != "updated" {
+			t.Fatalf("expected updated value, got %v %v", seg, err)
+		}
+		seg, err = lfs.FetchSegment(InodeNum("key-050"))
+		if err != nil || string(seg.Value) != "key-050" {
+			t.Fatalf("expected key-050 value, got %v %v", seg, err)
+		}
+		for _, key := range []string{"key-001", "key-095"} {
+			if _, err := lfs.FetchSegment(InodeNum(key)); !errors.Is(err, ErrSegmentNotFound) {
+				t.Fatalf("expected %s to be deleted, got %v", key, err)
+			}
+		}
+	}
+	check(lfs)
+
+	err = lfs.CloseFS()
+	if err != nil {
+		t.Fatalf("failed to close fs: %v", err)
+	}
+
+	// 没有索引快照时从排序数据文件重放
+	err = os.Remove(filepath.Join(path, indexFileName))
+	if err != nil {
+		t.Fatalf("failed to remove index snapshot: %v", err)
+	}
+	lfs, err = OpenFS(opt)
+	if err != nil {
+		t.Fatalf("failed to reopen fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+	check(lfs)
+}
+
+func TestLSMRecoverWAL(t *testing.T) {
+	path := t.TempDir()
+	lfs, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, key), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+	err = lfs.CloseFS()
+	if err != nil {
+		t.Fatalf("failed to close fs: %v", err)
+	}
+
+	// 之前写入的活跃数据文件在 LSM 模式中作为预写日志，封存之后扫描数据文件刷新
+	lfs, err = OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1, Engine: EngineLSM})
+	if err != nil {
+		t.Fatalf("failed to reopen fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	err = lfs.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+	waitLSM(t, lfs, func() bool { return true })
+
+	if lfs.regionSparseIndex(2) == nil {
+		t.Fatalf("expected region 2 to be a sorted region")
+	}
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		seg, err := lfs.FetchSegment(InodeNum(key))
+		if err != nil || string(seg.Value) != key {
+			t.Fatalf("expected %s value, got %v %v", key, seg, err)
+		}
+	}
+}
+
+func TestLSMLeveledCompaction(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1, Engine: EngineLSM})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	for round := 0; round < lsmL0Files; round++ {
+		for i := 0; i < 50; i++ {
+			key := fmt.Sprintf("key-%03d", i*lsmL0Files+round)
+			err := lfs.AddSegment(InodeNum(key), *testSegment(key, key), 0)
+			if err != nil {
+				t.Fatalf("failed to add segment: %v", err)
+			}
+		}
+		err = lfs.ChangeRegions()
+		if err != nil {
+			t.Fatalf("failed to change regions: %v", err)
+		}
+	}
+
+	waitLSM(t, lfs, func() bool {
+		levels := lfs.sortedLevels()
+		return len(levels[0].ids) == 0 && len(levels[1].ids) > 0
+	})
+
+	if lfs.Count() != 50*lsmL0Files {
+		t.Fatalf("expected %d keys, got %d", 50*lsmL0Files, lfs.Count())
+	}
+	for _, key := range []string{"key-000", "key-101", "key-199"} {
+		seg, err := lfs.FetchSegment(InodeNum(key))
+		if err != nil || string(seg.Value) != key {
+			t.Fatalf("expected %s value, got %v %v", key, seg, err)
+		}
+	}
+}
+
+func TestLSMInMemory(t *testing.T) {
+	_, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, SegmentSize: minSegmentSize, InMemory: true, Engine: EngineLSM})
+	if err == nil {
+		t.Fatalf("expected in-memory lsm engine to be rejected")
+	}
+}
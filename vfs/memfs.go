@@ -0,0 +1,193 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// vfsFile 是数据文件需要支持的操作，磁盘上的数据文件是 *os.File，内存模式下是 memFile
+type vfsFile interface {
+	io.ReaderAt
+	io.Writer
+	io.Seeker
+	io.Closer
+	Name() string
+	Stat() (fs.FileInfo, error)
+	Sync() error
+}
+
+// memFile 是只存在于内存中的数据文件，和使用 O_APPEND 打开的文件一样写入总是追加到末尾
+// 关闭之后读取返回 os.ErrClosed，和磁盘文件被压缩删除时的行为保持一致
+type memFile struct {
+	mu      sync.RWMutex
+	name    string
+	data    []byte
+	offset  int64
+	closed  bool
+	modTime time.Time
+}
+
+func newMemFile(name string) *memFile {
+	return &memFile{name: name, modTime: time.Now()}
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if off < 0 {
+		return 0, errors.New("failed to read memory file: negative offset")
+	}
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	f.data = append(f.data, p...)
+	f.offset = int64(len(f.data))
+	f.modTime = time.Now()
+
+	return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.data))
+	default:
+		return 0, errors.New("failed to seek memory file: invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("failed to seek memory file: negative position")
+	}
+	f.offset = offset
+
+	return offset, nil
+}
+
+// Sync 内存中的数据文件没有需要刷新的数据
+func (f *memFile) Sync() error {
+	return nil
+}
+
+// Close 释放数据文件占用的内存
+func (f *memFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	f.data = nil
+
+	return nil
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.closed {
+		return nil, os.ErrClosed
+	}
+
+	return &memFileInfo{name: f.name, size: int64(len(f.data)), modTime: f.modTime}, nil
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) Mode() fs.FileMode  { return fsPerm }
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *memFileInfo) IsDir() bool        { return false }
+func (fi *memFileInfo) Sys() interface{}   { return nil }
+
+// openRegionFile 创建一个新的活跃数据文件，内存模式下不会在数据目录中创建任何文件
+func (lfs *LogStructuredFS) openRegionFile(path string) (vfsFile, error) {
+	if lfs.inMemory {
+		return newMemFile(path), nil
+	}
+	return os.OpenFile(path, lfs.activeFlag(), lfs.fsPerm)
+}
+
+// closeFile 刷新并关闭数据文件
+func closeFile(fd vfsFile) error {
+	if err := fd.Sync(); err != nil {
+		return err
+	}
+	return fd.Close()
+}
+
+// fileHoles 读取数据文件的空洞区间，内存中的数据文件不会被打洞
+func fileHoles(fd vfsFile) (holeMap, error) {
+	if _, ok := fd.(*memFile); ok {
+		return make(holeMap), nil
+	}
+	return loadHoles(fd.Name())
+}
+
+// removeRegionFile 删除已经关闭的数据文件和它的 hint 文件以及空洞文件
+func removeRegionFile(fd vfsFile) error {
+	if _, ok := fd.(*memFile); ok {
+		return nil
+	}
+
+	err := os.Remove(fd.Name())
+	if err != nil {
+		return err
+	}
+
+	err = removeHint(fd.Name())
+	if err != nil {
+		return err
+	}
+
+	err = removeSparseIndex(fd.Name())
+	if err != nil {
+		return err
+	}
+
+	return removeHoles(fd.Name())
+}
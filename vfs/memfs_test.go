@@ -0,0 +1,71 @@
+package vfs
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestInMemoryMode(t *testing.T) {
+	dir := t.TempDir()
+	lfs, err := OpenFS(&Options{Path: dir, FsPerm: fsPerm, SegmentSize: minSegmentSize, InMemory: true})
+	if err != nil {
+		t.Fatalf("failed to open in-memory fs: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key-%02d", i)
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value"), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+		// 每条记录之后切换数据文件，压缩时会迁移记录
+		err = lfs.ChangeRegions()
+		if err != nil {
+			t.Fatalf("failed to change regions: %v", err)
+		}
+	}
+
+	err = lfs.AddSegment(InodeNum("key-00"), *testSegment("key-00", "updated"), 0)
+	if err != nil {
+		t.Fatalf("failed to update segment: %v", err)
+	}
+
+	err = lfs.compactRegion(1)
+	if err != nil {
+		t.Fatalf("failed to compact memory region: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key-%02d", i)
+		seg, err := lfs.FetchSegment(InodeNum(key))
+		if err != nil {
+			t.Fatalf("failed to fetch segment %s: %v", key, err)
+		}
+		expected := "value"
+		if i == 0 {
+			expected = "updated"
+		}
+		if string(seg.Value) != expected {
+			t.Errorf("expected %s, got %s", expected, seg.Value)
+		}
+	}
+
+	if _, err := lfs.PunchHoles(2); err != ErrPunchHoleUnsupported {
+		t.Errorf("expected ErrPunchHoleUnsupported, got: %v", err)
+	}
+
+	err = lfs.CloseFS()
+	if err != nil {
+		t.Fatalf("failed to close in-memory fs: %v", err)
+	}
+
+	// 内存模式不会在数据目录中留下任何文件
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read data directory: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected empty data directory, got %d entries", len(entries))
+	}
+}
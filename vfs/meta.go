@@ -0,0 +1,73 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// SegmentMeta 是记录头部中的元数据，不包含 Key 和 Value
+type SegmentMeta struct {
+	Type      Kind
+	Flags     uint8
+	UserFlags uint8
+	Version   uint64
+	ExpiredAt uint64
+	CreatedAt uint64
+	KeySize   uint32
+	ValueSize uint32 // 磁盘上经过压缩和加密之后的 Value 字节数
+}
+
+func segmentMeta(seg *Segment) *SegmentMeta {
+	return &SegmentMeta{
+		Type:      seg.Type,
+		Flags:     seg.Flags,
+		UserFlags: seg.UserFlags,
+		Version:   seg.Version,
+		ExpiredAt: seg.ExpiredAt,
+		CreatedAt: seg.CreatedAt,
+		KeySize:   seg.KeySize,
+		ValueSize: seg.ValueSize,
+	}
+}
+
+// FetchSegmentMeta 只读取记录头部返回记录的元数据，不需要读取、解密和解压 Value
+// 适合只需要检查类型、过期时间或者 Key 是否存在的场景
+func (lfs *LogStructuredFS) FetchSegmentMeta(inum uint64) (*SegmentMeta, error) {
+	if seg, ok := lfs.cache.get(inum); ok && !isExpired(seg.ExpiredAt) {
+		return segmentMeta(seg), nil
+	}
+
+	for retry := 0; ; retry++ {
+		inode, ok := lfs.GetINode(inum)
+		if !ok || isExpired(inode.ExpiredAt) {
+			return nil, ErrSegmentNotFound
+		}
+
+		fd, version, _, ok := lfs.regionFile(inode.RegionID)
+		if !ok {
+			return nil, fmt.Errorf("region file not found for region id: %d", inode.RegionID)
+		}
+
+		hsize, err := segmentHeaderSize(version)
+		if err != nil {
+			return nil, err
+		}
+
+		header := make([]byte, hsize)
+		_, err = fd.ReadAt(header, inode.Position)
+		if errors.Is(err, os.ErrClosed) && retry == 0 {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read segment header (inum: %d): %w", inum, err)
+		}
+
+		seg, err := parseSegmentHeader(header, version)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse segment header (inum: %d): %w", inum, err)
+		}
+
+		return segmentMeta(seg), nil
+	}
+}
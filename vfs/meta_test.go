@@ -0,0 +1,41 @@
+package vfs
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFetchSegmentMeta(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	seg := testSegment("key", "value")
+	seg.Type = Text
+	seg.CreatedAt = uint64(time.Now().Unix())
+	seg.ExpiredAt = uint64(time.Now().Add(time.Hour).Unix())
+	inum := InodeNum("key")
+	version, err := lfs.AddSegmentVersion(context.Background(), inum, *seg, 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+
+	meta, err := lfs.FetchSegmentMeta(inum)
+	if err != nil {
+		t.Fatalf("failed to fetch segment meta: %v", err)
+	}
+	if meta.Type != Text || meta.KeySize != 3 || meta.ValueSize != 5 {
+		t.Errorf("expected text with sizes 3/5, got %d with sizes %d/%d", meta.Type, meta.KeySize, meta.ValueSize)
+	}
+	if meta.CreatedAt != seg.CreatedAt || meta.ExpiredAt != seg.ExpiredAt || meta.Version != version {
+		t.Errorf("expected created %d expired %d version %d, got %d %d %d",
+			seg.CreatedAt, seg.ExpiredAt, version, meta.CreatedAt, meta.ExpiredAt, meta.Version)
+	}
+
+	if _, err := lfs.FetchSegmentMeta(InodeNum("missing")); err != ErrSegmentNotFound {
+		t.Errorf("expected ErrSegmentNotFound, got: %v", err)
+	}
+}
@@ -0,0 +1,311 @@
+package vfs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MigrateReport 记录数据文件格式迁移的结果
+type MigrateReport struct {
+	Regions  int    // 迁移的数据文件个数
+	Records  int    // 迁移的记录条数
+	Checksum uint32 // 所有记录内容的校验码，迁移前后必须一致
+	Backup   string // 原始数据目录的备份位置
+}
+
+// MigrateRegions 将 path 目录下的数据文件转换为 version 格式版本，步骤如下：
+// 1. 将所有数据文件逐条记录重新编码写入到 path.migrating 临时目录
+// 2. 重新扫描临时目录，校验记录条数和记录内容的校验码是否和原数据一致
+// 3. 校验通过之后将原目录重命名为备份目录，把临时目录替换为数据目录
+// 索引快照文件记录的是旧格式的偏移量，所以不会迁移，下次启动时会全局扫描重建索引
+// 迁移过程中数据库不能运行，Value 部分会原样迁移不需要 transformer 解码
+// 校验码包括记录的标志位和版本号，迁移到 FormatV4 之前的格式版本时版本号会被丢弃
+func MigrateRegions(path string, version uint8) (*MigrateReport, error) {
+	if _, err := segmentHeaderSize(version); err != nil {
+		return nil, err
+	}
+
+	// 迁移期间不允许其他进程打开数据目录
+	lock, err := lockDir(path)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.unlock()
+
+	// 只读进程打开的数据文件会在迁移之后被替换
+	readers, err := lockReaders(path)
+	if err != nil {
+		return nil, err
+	}
+	defer readers.unlock()
+
+	staging := path + ".migrating"
+	err = os.RemoveAll(staging)
+	if err != nil {
+		return nil, fmt.Errorf("failed to clean staging directory: %w", err)
+	}
+
+	err = os.MkdirAll(staging, fsPerm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+
+	files, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	report := new(MigrateReport)
+	digest := crc32.NewIEEE()
+
+	for _, file := range files {
+		// 迁移之后的数据文件是紧凑的，不再需要空洞文件，hint 文件和稀疏索引文件中的偏移量也会失效
+		if file.IsDir() || file.Name() == indexFileName || file.Name() == lockFileName || file.Name() == readersLockFileName ||
+			strings.HasSuffix(file.Name(), holesExtension) || strings.HasSuffix(file.Name(), hintExtension) ||
+			strings.HasSuffix(file.Name(), sparseExtension) {
+			continue
+		}
+
+		src, dst := filepath.Join(path, file.Name()), filepath.Join(staging, file.Name())
+		if !isRegionFileName(file.Name()) {
+			// 其他文件例如配置文件原样复制
+			err := copyFile(src, dst)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		records, err := migrateRegion(src, dst, version, digest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to migrate region %s: %w", file.Name(), err)
+		}
+
+		report.Regions++
+		report.Records += records
+	}
+	report.Checksum = digest.Sum32()
+
+	records, checksum, err := scanRegions(staging)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify migrated regions: %w", err)
+	}
+
+	if records != report.Records || checksum != report.Checksum {
+		return nil, fmt.Errorf("migrated regions verify failed: records %d/%d, checksum %d/%d",
+			records, report.Records, checksum, report.Checksum)
+	}
+
+	// 其他进程在替换完成之前不能打开数据目录，只有 Windows 需要提前释放锁
+	if unlockBeforeRename {
+		err = readers.unlock()
+		if err != nil {
+			return nil, fmt.Errorf("failed to unlock data directory: %w", err)
+		}
+		err = lock.unlock()
+		if err != nil {
+			return nil, fmt.Errorf("failed to unlock data directory: %w", err)
+		}
+	}
+
+	report.Backup = fmt.Sprintf("%s.backup-%d", path, time.Now().UnixNano())
+	err = os.Rename(path, report.Backup)
+	if err != nil {
+		return nil, fmt.Errorf("failed to backup data directory: %w", err)
+	}
+
+	err = os.Rename(staging, path)
+	if err != nil {
+		// 替换失败需要把原始数据目录恢复回来
+		_ = os.Rename(report.Backup, path)
+		return nil, fmt.Errorf("failed to swap data directory: %w", err)
+	}
+
+	return report, nil
+}
+
+func migrateRegion(src, dst string, version uint8, digest hash.Hash32) (int, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	// 迁移只转换格式版本，校验码算法保持不变
+	from, checksum, err := readFileVersion(in)
+	if err != nil {
+		return 0, err
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fsPerm)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+
+	writer := bufio.NewWriter(out)
+	_, err = writer.Write(fileMetadata(version, checksum))
+	if err != nil {
+		return 0, err
+	}
+
+	records := 0
+	var bytes []byte
+	err = scanRegion(in, from, checksum, func(seg *Segment) error {
+		var err error
+		bytes, err = appendEncodedSegment(bytes[:0], seg, version, checksum)
+		if err != nil {
+			return err
+		}
+		_, err = writer.Write(bytes)
+		if err != nil {
+			return err
+		}
+		// 旧格式版本不保存版本号，校验码按照记录迁移之后应该保存的内容计算
+		if version < FormatV4 {
+			seg.Version = 0
+		}
+		digestSegment(digest, seg)
+		records++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		return 0, err
+	}
+
+	return records, out.Sync()
+}
+
+// scanRegions 统计目录下所有数据文件的记录条数和记录内容的校验码
+func scanRegions(path string) (int, uint32, error) {
+	files, err := os.ReadDir(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	records, digest := 0, crc32.NewIEEE()
+	for _, file := range files {
+		if file.IsDir() || !isRegionFileName(file.Name()) {
+			continue
+		}
+
+		fd, err := os.Open(filepath.Join(path, file.Name()))
+		if err != nil {
+			return 0, 0, err
+		}
+
+		version, checksum, err := readFileVersion(fd)
+		if err == nil {
+			err = scanRegion(fd, version, checksum, func(seg *Segment) error {
+				digestSegment(digest, seg)
+				records++
+				return nil
+			})
+		}
+		fd.Close()
+
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to scan region %s: %w", file.Name(), err)
+		}
+	}
+
+	return records, digest.Sum32(), nil
+}
+
+// scanRegion 依次读取数据文件中的每一条原始记录
+func scanRegion(fd *os.File, version uint8, checksum Checksum, fn func(seg *Segment) error) error {
+	finfo, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+
+	holes, err := loadHoles(fd.Name())
+	if err != nil {
+		return err
+	}
+
+	offset := int64(len(dataFileMetadata))
+	for offset < finfo.Size() {
+		if end, ok := holes[offset]; ok {
+			offset = end
+			continue
+		}
+
+		seg, n, err := decodeSegment(fd, offset, version, checksum)
+		if err != nil {
+			return fmt.Errorf("failed to decode segment at offset %d: %w", offset, err)
+		}
+
+		err = fn(seg)
+		if err != nil {
+			return err
+		}
+
+		offset += n
+	}
+
+	return nil
+}
+
+// digestSegment 计算和格式版本无关的记录内容校验码，标志位和版本号被丢弃或者修改时校验码也会不同
+func digestSegment(h hash.Hash32, seg *Segment) {
+	var header [28]byte
+	header[0] = byte(seg.Tombstone)
+	header[1] = byte(seg.Type)
+	header[2] = seg.Flags
+	header[3] = seg.UserFlags
+	binary.LittleEndian.PutUint64(header[4:], seg.Version)
+	binary.LittleEndian.PutUint64(header[12:], seg.ExpiredAt)
+	binary.LittleEndian.PutUint64(header[20:], seg.CreatedAt)
+	h.Write(header[:])
+	h.Write(seg.Key)
+	h.Write(seg.Value)
+}
+
+// readFileVersion 读取数据文件头中的格式版本和校验码算法
+func readFileVersion(fd io.ReaderAt) (uint8, Checksum, error) {
+	metadata := make([]byte, len(dataFileMetadata))
+	_, err := fd.ReadAt(metadata, 0)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read file metadata: %w", err)
+	}
+	return parseFileMetadata(metadata)
+}
+
+func isRegionFileName(name string) bool {
+	return strings.HasPrefix(name, "0") && strings.HasSuffix(name, fileExtension)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fsPerm)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		return fmt.Errorf("failed to copy file %s: %w", src, err)
+	}
+
+	return out.Sync()
+}
@@ -0,0 +1,120 @@
+package vfs
+
+import (
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateRegions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+	lfs, err := OpenFS(&Options{
+		Path:      path,
+		FsPerm:    fsPerm,
+		Threshold: 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+
+	for _, key := range []string{"key-01", "key-02", "key-03"} {
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value-"+key), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+
+	err = lfs.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+	mustCloseFS(t, lfs)
+
+	records, checksum, err := scanRegions(path)
+	if err != nil {
+		t.Fatalf("failed to scan regions: %v", err)
+	}
+
+	// 迁移到同样保存版本号的格式版本时内容保持不变
+	report, err := MigrateRegions(path, FormatV4)
+	if err != nil {
+		t.Fatalf("failed to migrate regions: %v", err)
+	}
+
+	if report.Records != 3 || report.Records != records || report.Checksum != checksum {
+		t.Errorf("unexpected migrate report: %+v", report)
+	}
+
+	// FormatV2 不保存版本号，校验码按照丢弃版本号之后的内容计算
+	report, err = MigrateRegions(path, FormatV2)
+	if err != nil {
+		t.Fatalf("failed to migrate regions: %v", err)
+	}
+
+	if report.Records != records || report.Checksum == checksum {
+		t.Errorf("expected versions to be dropped, got migrate report: %+v", report)
+	}
+	records, checksum, err = scanRegions(path)
+	if err != nil {
+		t.Fatalf("failed to scan regions: %v", err)
+	}
+
+	fd, err := os.Open(filepath.Join(path, formatDataFileName(1)))
+	if err != nil {
+		t.Fatalf("failed to open migrated region: %v", err)
+	}
+	defer fd.Close()
+
+	version, _, err := readFileVersion(fd)
+	if err != nil || version != FormatV2 {
+		t.Errorf("expected format version %d, got %d (%v)", FormatV2, version, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(path, indexFileName)); !os.IsNotExist(err) {
+		t.Errorf("expected index snapshot not to be migrated")
+	}
+
+	// 迁移回旧版本之后内容保持不变
+	report, err = MigrateRegions(path, FormatV1)
+	if err != nil {
+		t.Fatalf("failed to migrate regions back: %v", err)
+	}
+
+	if report.Records != records || report.Checksum != checksum {
+		t.Errorf("unexpected migrate back report: %+v", report)
+	}
+}
+
+func TestDigestSegment(t *testing.T) {
+	digest := func(seg *Segment) uint32 {
+		h := crc32.NewIEEE()
+		digestSegment(h, seg)
+		return h.Sum32()
+	}
+
+	seg := testSegment("key", "value")
+	seg.Flags, seg.UserFlags, seg.Version = flagTransform, 0x1, 7
+	want := digest(seg)
+
+	// 丢弃或者修改标志位和版本号的迁移不能通过校验
+	for name, change := range map[string]func(seg *Segment){
+		"Flags":     func(seg *Segment) { seg.Flags = 0 },
+		"UserFlags": func(seg *Segment) { seg.UserFlags = 0 },
+		"Version":   func(seg *Segment) { seg.Version = 0 },
+	} {
+		changed := *seg
+		change(&changed)
+		if digest(&changed) == want {
+			t.Errorf("expected digest to change with %s", name)
+		}
+	}
+}
+
+func mustCloseFS(t *testing.T, lfs *LogStructuredFS) {
+	t.Helper()
+	err := lfs.CloseFS()
+	if err != nil {
+		t.Fatalf("failed to close fs: %v", err)
+	}
+}
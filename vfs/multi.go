@@ -0,0 +1,128 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+// AddSegments 一次性写入多条 Segment 记录，所有记录序列化之后通过一次写入追加到数据文件中
+// 相比逐条调用 AddSegment 只需要获取一次数据文件的锁，但是它不是原子的，需要原子写入请使用 Batch
+func (lfs *LogStructuredFS) AddSegments(segs map[uint64]Segment) error {
+	if len(segs) == 0 {
+		return nil
+	}
+
+	inums := make([]uint64, 0, len(segs))
+	records := make([]*Segment, 0, len(segs))
+	size, added := 0, int64(0)
+	for inum, seg := range segs {
+		seg := seg
+		seg.Version = 0
+		inum := lfs.transformSegment(inum, &seg)
+		if err := checkSegmentSize(&seg); err != nil {
+			return err
+		}
+		if _, ok := lfs.GetINode(inum); !ok {
+			added += inodeMemory(len(seg.Key))
+		}
+		inums = append(inums, inum)
+		records = append(records, &seg)
+		size += int(seg.Size())
+	}
+
+	if added > 0 && lfs.maxIndexMem > 0 && lfs.indexMemory()+added > lfs.maxIndexMem {
+		return ErrIndexMemoryExceeded
+	}
+
+	if err := lfs.checkDiskQuota(records...); err != nil {
+		return err
+	}
+
+	lfs.limiter.wait(size)
+
+	lfs.appendMu.RLock()
+	unlock := lfs.lockShards(inums...)
+	inodes, err := lfs.appendSegments(records...)
+	if err != nil {
+		unlock()
+		lfs.appendMu.RUnlock()
+		lfs.limiter.refund(size)
+		return err
+	}
+
+	olds := make([]*INode, len(inums))
+	for i, inum := range inums {
+		olds[i] = replaceIndex(lfs.indexs[inum%uint64(indexShard)], inum, records[i], inodes[i])
+	}
+	unlock()
+	for i, inum := range inums {
+		lfs.releaseIndex(inum, records[i], inodes[i], olds[i])
+	}
+	lfs.appendMu.RUnlock()
+
+	return lfs.auditSegments(context.Background(), records...)
+}
+
+// FetchSegments 一次性读取多条 Segment 记录，不存在的记录不会出现在返回结果中
+// 每个索引分片只获取一次读锁，并且按照数据文件和文件中的位置排序之后再读取磁盘
+// 每条记录和 FetchSegment 一样读取，数据文件在读取的过程中被压缩或者迁移时重新查找索引
+func (lfs *LogStructuredFS) FetchSegments(inums ...uint64) (result map[uint64]*Segment, err error) {
+	defer lfs.latency.observe(LatencyGet, time.Now())
+	_, span := lfs.startSpan(context.Background(), spanGet)
+	defer func() { endSpan(span, err) }()
+	if span != nil {
+		span.SetAttributes(int64Attr("vfs.keys", int64(len(inums))))
+	}
+
+	result = make(map[uint64]*Segment, len(inums))
+
+	// 按照索引分片分组，缓存命中的记录直接返回
+	shards := make(map[int][]uint64, indexShard)
+	for _, inum := range inums {
+		if seg, ok := lfs.cache.get(inum); ok && !isExpired(seg.ExpiredAt) {
+			result[inum] = seg
+			continue
+		}
+		shard := int(inum % uint64(indexShard))
+		shards[shard] = append(shards[shard], inum)
+	}
+
+	type location struct {
+		inum  uint64
+		inode *INode
+	}
+
+	var locations []location
+	for shard, group := range shards {
+		imap := lfs.indexs[shard]
+		imap.mu.RLock()
+		for _, inum := range group {
+			if inode, ok := imap.get(inum); ok && !isExpired(inode.ExpiredAt) {
+				locations = append(locations, location{inum: inum, inode: inode})
+			}
+		}
+		imap.mu.RUnlock()
+	}
+
+	sort.Slice(locations, func(i, j int) bool {
+		if locations[i].inode.RegionID == locations[j].inode.RegionID {
+			return locations[i].inode.Position < locations[j].inode.Position
+		}
+		return locations[i].inode.RegionID < locations[j].inode.RegionID
+	})
+
+	for _, loc := range locations {
+		seg, err := lfs.readINode(loc.inum, loc.inode, nil)
+		if errors.Is(err, ErrSegmentNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result[loc.inum] = seg
+	}
+
+	return result, nil
+}
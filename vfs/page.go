@@ -0,0 +1,92 @@
+package vfs
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid scan cursor")
+
+// Page 是 ScanPage 返回的一页记录，Keys 和 Segments 一一对应并且按照字典序排列
+// Cursor 为空表示已经没有更多的记录了
+type Page struct {
+	Keys     []string
+	Segments []*Segment
+	Cursor   string
+}
+
+// ScanPage 返回以 prefix 开头的下一页记录，最多 limit 条，cursor 为空时从第一条记录开始
+// Cursor 只记录上一页最后一个 Key，不依赖记录在数据文件中的位置，压缩之后仍然有效
+// 翻页之间新增的 Key 只要排在 Cursor 之后就会出现在后面的页中
+func (lfs *LogStructuredFS) ScanPage(prefix, cursor string, limit int) (*Page, error) {
+	return lfs.ScanPageContext(context.Background(), prefix, cursor, limit)
+}
+
+// ScanPageContext 和 ScanPage 一样，但是会响应 ctx 的取消和超时
+func (lfs *LogStructuredFS) ScanPageContext(ctx context.Context, prefix, cursor string, limit int) (*Page, error) {
+	defer lfs.latency.observe(LatencyScan, time.Now())
+
+	ctx, span := lfs.startSpan(ctx, spanScan)
+	page, err := lfs.scanPage(ctx, prefix, cursor, limit)
+	if span != nil && page != nil {
+		var bytes int64
+		for _, seg := range page.Segments {
+			if seg != nil {
+				bytes += int64(len(seg.Value))
+			}
+		}
+		span.SetAttributes(stringAttr("vfs.prefix", prefix), int64Attr("vfs.keys", int64(len(page.Keys))), int64Attr("vfs.bytes", bytes))
+	}
+	endSpan(span, err)
+	return page, err
+}
+
+func (lfs *LogStructuredFS) scanPage(ctx context.Context, prefix, cursor string, limit int) (*Page, error) {
+	if limit <= 0 {
+		return nil, errors.New("scan page limit must be greater than 0")
+	}
+
+	prefix = lfs.transformKey(prefix)
+	it := lfs.NewIterator(ctx, prefix)
+	defer it.Close()
+
+	if cursor != "" {
+		last, err := decodeCursor(prefix, cursor)
+		if err != nil {
+			return nil, err
+		}
+		// 从严格排在上一页最后一个 Key 之后的位置开始
+		it.seekAfter(last)
+	}
+
+	page := new(Page)
+	for it.Next() {
+		if len(page.Keys) == limit {
+			page.Cursor = encodeCursor(page.Keys[len(page.Keys)-1])
+			break
+		}
+		page.Keys = append(page.Keys, it.Key())
+		page.Segments = append(page.Segments, it.Segment())
+	}
+	if it.Err() != nil {
+		return nil, it.Err()
+	}
+
+	return page, nil
+}
+
+func encodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// decodeCursor 解析 Cursor，不是同一个 prefix 的扫描产生的 Cursor 返回 ErrInvalidCursor
+func decodeCursor(prefix, cursor string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(key), prefix) {
+		return "", ErrInvalidCursor
+	}
+	return string(key), nil
+}
@@ -0,0 +1,64 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestScanPage(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("user:%02d", i)
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value"), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+	err = lfs.AddSegment(InodeNum("order:01"), *testSegment("order:01", "value"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+
+	page, err := lfs.ScanPage("user:", "", 2)
+	if err != nil {
+		t.Fatalf("failed to scan first page: %v", err)
+	}
+	if fmt.Sprint(page.Keys) != "[user:00 user:01]" || page.Cursor == "" {
+		t.Fatalf("unexpected first page: %v cursor %q", page.Keys, page.Cursor)
+	}
+
+	// 压缩之后 Cursor 仍然有效
+	err = lfs.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+	err = lfs.compactRegion(1)
+	if err != nil {
+		t.Fatalf("failed to compact region: %v", err)
+	}
+
+	var keys []string
+	cursor := page.Cursor
+	for cursor != "" {
+		page, err := lfs.ScanPage("user:", cursor, 2)
+		if err != nil {
+			t.Fatalf("failed to scan page: %v", err)
+		}
+		keys = append(keys, page.Keys...)
+		cursor = page.Cursor
+	}
+	if fmt.Sprint(keys) != "[user:02 user:03 user:04]" {
+		t.Errorf("unexpected remaining keys: %v", keys)
+	}
+
+	_, err = lfs.ScanPage("order:", encodeCursor("user:01"), 2)
+	if !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor, got: %v", err)
+	}
+}
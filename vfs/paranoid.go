@@ -0,0 +1,60 @@
+package vfs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// invariantViolation 在 Paranoid 模式下发现数据不一致时带着诊断信息直接 panic
+// 继续运行只会把错误的数据写得更多，开发新功能时越早发现问题越容易定位
+func invariantViolation(format string, args ...interface{}) {
+	panic(fmt.Sprintf("vfs: invariant violated: "+format, args...))
+}
+
+// verifyAppended 重新读取刚刚写入活跃数据文件的记录并且校验，调用方需要持有 lfs.mu
+func (lfs *LogStructuredFS) verifyAppended(segs []*Segment, inodes []*INode) {
+	for i, inode := range inodes {
+		seg, length, err := decodeSegment(lfs.active, inode.Position, currentFormat, lfs.checksum)
+		if err != nil {
+			invariantViolation("failed to re-read appended segment (region: %d, position: %d, key: %q): %v",
+				inode.RegionID, inode.Position, inode.Key, err)
+		}
+		if length != int64(inode.Length) || !bytes.Equal(seg.Key, segs[i].Key) || !bytes.Equal(seg.Value, segs[i].Value) {
+			invariantViolation("appended segment differs from written data (region: %d, position: %d, key: %q, length: %d, expected length: %d)",
+				inode.RegionID, inode.Position, inode.Key, length, inode.Length)
+		}
+	}
+}
+
+// verifyRegionIndex 检查所有指向 regionID 的内存索引都能在数据文件中读到对应 Key 的有效记录
+func (lfs *LogStructuredFS) verifyRegionIndex(regionID uint64, fd io.ReaderAt, version uint8, checksum Checksum, holes holeMap) {
+	for _, shard := range lfs.indexs {
+		shard.mu.RLock()
+		shard.each(func(inum uint64, inode *INode) bool {
+			if inode.RegionID != regionID {
+				return true
+			}
+			for start, end := range holes {
+				if inode.Position >= start && inode.Position < end {
+					shard.mu.RUnlock()
+					invariantViolation("index points into punched hole (inum: %d, region: %d, position: %d, hole: [%d, %d))",
+						inum, regionID, inode.Position, start, end)
+				}
+			}
+			seg, length, err := decodeSegment(fd, inode.Position, version, checksum)
+			if err != nil {
+				shard.mu.RUnlock()
+				invariantViolation("index points to unreadable segment (inum: %d, region: %d, position: %d, key: %q): %v",
+					inum, regionID, inode.Position, inode.Key, err)
+			}
+			if seg.IsTombstone() || string(seg.Key) != inode.Key || length != int64(inode.Length) {
+				shard.mu.RUnlock()
+				invariantViolation("index does not match segment (inum: %d, region: %d, position: %d, key: %q, found key: %q, tombstone: %t)",
+					inum, regionID, inode.Position, inode.Key, seg.Key, seg.IsTombstone())
+			}
+			return true
+		})
+		shard.mu.RUnlock()
+	}
+}
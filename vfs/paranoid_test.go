@@ -0,0 +1,58 @@
+package vfs
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParanoidMode(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1, Paranoid: true})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	for _, key := range []string{"key-01", "key-02"} {
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value"), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+	err = lfs.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+
+	// 索引和数据文件一致时压缩正常完成
+	err = lfs.AddSegment(InodeNum("key-01"), *testSegment("key-01", "updated"), 0)
+	if err != nil {
+		t.Fatalf("failed to update segment: %v", err)
+	}
+	err = lfs.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+	err = lfs.compactRegion(1)
+	if err != nil {
+		t.Fatalf("failed to compact region: %v", err)
+	}
+
+	// 人为破坏一条索引的位置，压缩之前的检查会发现它
+	inode, ok := lfs.GetINode(InodeNum("key-01"))
+	if !ok {
+		t.Fatalf("expected key-01 to be indexed")
+	}
+	regionID := inode.RegionID
+	inode.Position += 1
+
+	defer func() {
+		r := recover()
+		msg, _ := r.(string)
+		if !strings.Contains(msg, "invariant violated") {
+			t.Errorf("expected invariant violation panic, got: %v", r)
+		}
+		inode.Position -= 1
+	}()
+	_ = lfs.compactRegion(regionID)
+	t.Errorf("expected compaction to panic")
+}
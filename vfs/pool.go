@@ -0,0 +1,78 @@
+package vfs
+
+import (
+	"bytes"
+	"compress/flate"
+	"sync"
+
+	"github.com/golang/snappy"
+)
+
+// 放回池中的缓冲区最大的容量，更大的缓冲区直接交给 GC 回收，一次很大的写入不会让池长期占用内存
+const maxPooledBuffer = 1 * MB
+
+// bufferPool 复用序列化记录、读取记录头部和压缩时使用的临时缓冲区
+// 只用于不会被调用方持有的数据，返回给调用方的 Key 和 Value 仍然单独分配
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new([]byte) },
+}
+
+// getBuffer 从池中取出一个长度为 size 的缓冲区，内容是未定义的
+func getBuffer(size int) *[]byte {
+	buf := bufferPool.Get().(*[]byte)
+	if cap(*buf) < size {
+		*buf = make([]byte, size)
+	}
+	*buf = (*buf)[:size]
+	return buf
+}
+
+// putBuffer 把缓冲区放回池中，调用之后不能再使用缓冲区
+func putBuffer(buf *[]byte) {
+	if cap(*buf) > int(maxPooledBuffer) {
+		return
+	}
+	*buf = (*buf)[:0]
+	bufferPool.Put(buf)
+}
+
+// snappyScratch 使用池中的缓冲区压缩 data，返回的数据引用 scratch，用完之后需要调用 putBuffer
+func snappyScratch(data []byte) ([]byte, *[]byte) {
+	scratch := getBuffer(snappy.MaxEncodedLen(len(data)))
+	return snappy.Encode(*scratch, data), scratch
+}
+
+// flateEncoder 是可以复用的 DEFLATE 压缩器和输出缓冲区，压缩器内部有几百 KB 的状态
+type flateEncoder struct {
+	w   *flate.Writer
+	buf bytes.Buffer
+}
+
+var flateEncoders = sync.Pool{
+	New: func() interface{} {
+		e := new(flateEncoder)
+		e.w, _ = flate.NewWriter(&e.buf, flate.DefaultCompression)
+		return e
+	},
+}
+
+// flateCompress 使用池中的压缩器压缩 data，返回的数据是单独分配的
+func flateCompress(data []byte) ([]byte, error) {
+	e := flateEncoders.Get().(*flateEncoder)
+	e.buf.Reset()
+	e.w.Reset(&e.buf)
+
+	_, err := e.w.Write(data)
+	if err == nil {
+		err = e.w.Close()
+	}
+	var compressed []byte
+	if err == nil {
+		compressed = append([]byte(nil), e.buf.Bytes()...)
+	}
+
+	if e.buf.Cap() <= int(maxPooledBuffer) {
+		flateEncoders.Put(e)
+	}
+	return compressed, err
+}
@@ -0,0 +1,72 @@
+package vfs
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/golang/snappy"
+)
+
+func TestPooledEncoding(t *testing.T) {
+	segs := []*Segment{testSegment("key-01", "value-01"), testSegment("key-02", "value-02")}
+
+	var buf bytes.Buffer
+	err := appendBinaryToFile(&buf, ChecksumCRC32, segs...)
+	if err != nil {
+		t.Fatalf("failed to append segments: %v", err)
+	}
+	var want []byte
+	for _, seg := range segs {
+		data, err := serializedSegment(seg, ChecksumCRC32)
+		if err != nil {
+			t.Fatalf("failed to serialize segment: %v", err)
+		}
+		want = append(want, data...)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("expected pooled encoding to match serialized segments")
+	}
+
+	// 缓冲区放回池中之后，序列化记录不需要再分配内存
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = appendBinaryToFile(io.Discard, ChecksumCRC32, segs...)
+	})
+	if allocs > 1 {
+		t.Errorf("expected at most 1 allocation per append, got %.1f", allocs)
+	}
+}
+
+func TestPooledCompression(t *testing.T) {
+	data := bytes.Repeat([]byte("wiredkv pooled buffers "), 512)
+
+	compressed, err := SnappyCompressor.Compress(data)
+	if err != nil {
+		t.Fatalf("failed to compress data: %v", err)
+	}
+	if cap(compressed) >= snappy.MaxEncodedLen(len(data)) {
+		t.Errorf("expected compressed data sized to its length, got len %d cap %d", len(compressed), cap(compressed))
+	}
+
+	// 池中的压缩器被复用之后结果仍然正确
+	for i := 0; i < 3; i++ {
+		compressed, err := FlateCompressor.Compress(data)
+		if err != nil {
+			t.Fatalf("failed to compress data: %v", err)
+		}
+		decompressed, err := FlateCompressor.Decompress(compressed)
+		if err != nil || !bytes.Equal(decompressed, data) {
+			t.Fatalf("expected flate round trip, got %d bytes %v", len(decompressed), err)
+		}
+	}
+
+	opt := AdaptiveOptions{}.withDefaults()
+	encoded, ok, err := opt.compress(Text, data)
+	if err != nil || !ok || Codec(encoded[0]) != CodecSnappy {
+		t.Fatalf("expected snappy adaptive compression, got %v %v", ok, err)
+	}
+	decoded, err := decompressCodec(encoded)
+	if err != nil || !bytes.Equal(decoded, data) {
+		t.Fatalf("expected adaptive round trip, got %v", err)
+	}
+}
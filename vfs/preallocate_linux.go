@@ -0,0 +1,36 @@
+//go:build linux
+
+package vfs
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// FALLOC_FL_KEEP_SIZE 只分配磁盘空间不修改文件大小
+// 文件大小保持不变，崩溃恢复时按照文件大小扫描记录的逻辑不受影响
+const fallocKeepSize = 0x1
+
+// preallocate 为数据文件预先分配 size 字节的磁盘空间，减少追加写入时的元数据更新和文件碎片
+// 文件系统不支持 fallocate 时直接返回，不影响正常写入
+func preallocate(f *os.File, size int64) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+	err = conn.Control(func(fd uintptr) {
+		serr = syscall.Fallocate(int(fd), fallocKeepSize, 0, size)
+	})
+	if err != nil {
+		return err
+	}
+
+	if errors.Is(serr, syscall.EOPNOTSUPP) || errors.Is(serr, syscall.ENOSYS) {
+		return nil
+	}
+
+	return serr
+}
@@ -0,0 +1,39 @@
+//go:build linux
+
+package vfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPreallocate(t *testing.T) {
+	fd, err := os.OpenFile(filepath.Join(t.TempDir(), formatDataFileName(1)), RWCA, fsPerm)
+	if err != nil {
+		t.Fatalf("failed to create region: %v", err)
+	}
+	defer fd.Close()
+
+	_, err = fd.Write(fileMetadata(currentFormat, ChecksumCRC32))
+	if err != nil {
+		t.Fatalf("failed to write metadata: %v", err)
+	}
+
+	size := int64(1 * MB)
+	err = preallocate(fd, size)
+	if err != nil {
+		t.Fatalf("failed to preallocate: %v", err)
+	}
+
+	finfo, err := fd.Stat()
+	if err != nil {
+		t.Fatalf("failed to stat region: %v", err)
+	}
+
+	// 预分配不能改变文件大小，否则崩溃恢复会把空洞当作记录读取
+	if finfo.Size() != int64(len(dataFileMetadata)) {
+		t.Errorf("expected size %d, got %d", len(dataFileMetadata), finfo.Size())
+	}
+
+}
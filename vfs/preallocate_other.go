@@ -0,0 +1,11 @@
+//go:build !linux && !windows
+
+package vfs
+
+import "os"
+
+// preallocate 在不支持 fallocate 的平台什么都不做
+// 不能使用 Truncate 代替，数据文件的大小就是最后一条记录的结束位置
+func preallocate(f *os.File, size int64) error {
+	return nil
+}
@@ -0,0 +1,35 @@
+//go:build windows
+
+package vfs
+
+import (
+	"os"
+	"unsafe"
+)
+
+var procSetFileInformationByHandle = kernel32.NewProc("SetFileInformationByHandle")
+
+// FILE_INFO_BY_HANDLE_CLASS 中的 FileAllocationInfo
+const fileAllocationInfo = 5
+
+// preallocate 使用 FileAllocationInfo 只分配磁盘空间，不会修改文件的大小
+func preallocate(f *os.File, size int64) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+	err = conn.Control(func(fd uintptr) {
+		info := struct{ AllocationSize int64 }{AllocationSize: size}
+		r, _, e := procSetFileInformationByHandle.Call(fd, fileAllocationInfo, uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info))
+		if r == 0 {
+			serr = e
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return serr
+}
@@ -0,0 +1,80 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/auula/wiredkv/clog"
+)
+
+var ErrDiskQuotaExceeded = errors.New("disk quota exceeded")
+
+// DiskUsage 返回本地数据文件估算占用的字节数，已经迁移到 Backend 中的数据文件不计算在内
+func (lfs *LogStructuredFS) DiskUsage() int64 {
+	lfs.mu.Lock()
+	defer lfs.mu.Unlock()
+	return lfs.diskUsage()
+}
+
+// diskUsage 通过每个数据文件的有效数据和垃圾数据估算磁盘占用，不需要对每个文件调用 Stat，调用方需要持有 lfs.mu
+func (lfs *LogStructuredFS) diskUsage() int64 {
+	used := lfs.offset
+	for regionID := range lfs.regions {
+		if regionID == lfs.regionID {
+			continue
+		}
+		usage := lfs.regionUsage(regionID)
+		used += int64(len(dataFileMetadata)) + usage.live + usage.dead
+	}
+	return used
+}
+
+// checkDiskQuota 检查写入 segs 之后是否会超过 MaxDiskBytes，删除记录不受限制，它们是释放空间的唯一途径
+// 超过限制时拒绝写入并且在后台触发一次紧急压缩，压缩完成之后写入就可以继续了
+func (lfs *LogStructuredFS) checkDiskQuota(segs ...*Segment) error {
+	if lfs.maxDiskBytes <= 0 {
+		return nil
+	}
+
+	var size int64
+	for _, seg := range segs {
+		if !seg.IsTombstone() {
+			size += int64(seg.Size())
+		}
+	}
+	if size == 0 {
+		return nil
+	}
+
+	lfs.mu.Lock()
+	used := lfs.diskUsage()
+	lfs.mu.Unlock()
+
+	if used+size <= lfs.maxDiskBytes {
+		return nil
+	}
+
+	lfs.emergencyCompact()
+
+	return fmt.Errorf("%w: %d bytes used, writing %d bytes exceeds limit %d", ErrDiskQuotaExceeded, used, size, lfs.maxDiskBytes)
+}
+
+// emergencyCompact 在后台压缩所有存在垃圾数据的数据文件，同一时间只会有一个紧急压缩在执行
+// 压缩迁移记录不受磁盘配额的限制
+func (lfs *LogStructuredFS) emergencyCompact() {
+	if !lfs.emergency.CompareAndSwap(false, true) {
+		return
+	}
+
+	lfs.emergencyWg.Add(1)
+	go func() {
+		defer lfs.emergencyWg.Done()
+		defer lfs.emergency.Store(false)
+
+		err := lfs.CompactContext(context.Background())
+		if err != nil {
+			clog.Errorf("failed to run emergency compaction: %v", err)
+		}
+	}()
+}
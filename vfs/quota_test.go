@@ -0,0 +1,56 @@
+package vfs
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDiskQuota(t *testing.T) {
+	seg := testSegment("key-01", "value")
+	size := int64(seg.Size())
+	header := int64(len(dataFileMetadata))
+	tombstone := int64(NewTombstoneSegment([]byte("key-01")).Size())
+
+	lfs, err := OpenFS(&Options{
+		Path:         t.TempDir(),
+		FsPerm:       fsPerm,
+		Threshold:    1,
+		MaxDiskBytes: 2*header + 2*size + tombstone + size/2,
+	})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	for _, key := range []string{"key-01", "key-02"} {
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value"), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+	err = lfs.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+
+	// 删除记录不受磁盘配额的限制
+	err = lfs.DelSegment("key-01")
+	if err != nil {
+		t.Fatalf("failed to delete segment: %v", err)
+	}
+
+	err = lfs.AddSegment(InodeNum("key-03"), *testSegment("key-03", "value"), 0)
+	if !errors.Is(err, ErrDiskQuotaExceeded) {
+		t.Fatalf("expected ErrDiskQuotaExceeded, got: %v", err)
+	}
+
+	// 紧急压缩回收了 key-01 占用的空间之后可以继续写入
+	lfs.emergencyWg.Wait()
+	err = lfs.AddSegment(InodeNum("key-03"), *testSegment("key-03", "value"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment after emergency compaction: %v", err)
+	}
+	if used := lfs.DiskUsage(); used > lfs.maxDiskBytes {
+		t.Errorf("expected disk usage %d within quota %d", used, lfs.maxDiskBytes)
+	}
+}
@@ -0,0 +1,368 @@
+package vfs
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// IndexKind 选择内存索引保存 Key 的方式
+type IndexKind uint8
+
+const (
+	// IndexHashMap 默认的索引，每个 INode 保存完整的 Key，读取不需要额外的分配
+	IndexHashMap IndexKind = iota
+	// IndexRadix 适合 URL、文件路径这类有很长公共前缀的 Key，每个分片使用一棵基数树共享 Key 的前缀
+	// 每条索引只保存和其他 Key 不同的部分，除此之外大约占用 120 字节，IndexHashMap 是 80 字节加上完整的 Key
+	// 读取索引时需要重新拼接出完整的 Key，读取和遍历会慢一些
+	IndexRadix
+)
+
+const (
+	// radixNode 和 radixValue 结构体的字节数
+	radixNodeSize  = int64(24)
+	radixValueSize = int64(48)
+	// 每条索引在 map 中的 inum 和节点下标加上 map 桶的额外开销大约占用的字节数
+	radixEntrySize = int64(24)
+)
+
+func (k IndexKind) validate() error {
+	if k != IndexHashMap && k != IndexRadix {
+		return fmt.Errorf("invalid index kind: %d", k)
+	}
+	return nil
+}
+
+// radixNode 是基数树的一个节点，使用下标代替指针，0 表示根节点或者没有
+// 边上的字节保存在 labels 的 [off, off+size) 中，包含 Key 的节点 value 为 values 的下标加一
+type radixNode struct {
+	parent uint32
+	child  uint32 // 第一个子节点
+	next   uint32 // 下一个兄弟节点，空闲节点使用它组成空闲链表
+	off    uint32
+	size   uint32
+	value  uint32
+}
+
+// radixValue 是 INode 中除了 Key 之外的字段
+type radixValue struct {
+	RegionID  uint64
+	Position  int64
+	Length    uint32
+	ExpiredAt uint64
+	CreatedAt uint64
+	Version   uint64
+}
+
+// radixIndex 使用基数树保存一个分片中的 Key，节点、边上的字节和 INode 分别保存在连续的数组中
+// 每条索引只需要一个 map 中的下标，不需要为每个节点单独分配内存
+// 和 indexMap 一样由分片的锁保护，bytes 可以在不持有锁的时候读取
+type radixIndex struct {
+	nodes     []radixNode
+	labels    []byte
+	values    []radixValue
+	leaves    map[uint64]uint32 // inum 到包含 Key 的节点
+	freeNode  uint32            // 空闲节点链表的第一个节点
+	freeValue []uint32
+	garbage   int // labels 中已经不再使用的字节数
+	bytes     atomic.Int64
+}
+
+func newRadixIndex() *radixIndex {
+	r := &radixIndex{nodes: make([]radixNode, 1), leaves: make(map[uint64]uint32)}
+	r.account()
+	return r
+}
+
+// account 更新基数树占用的字节数
+func (r *radixIndex) account() {
+	bytes := int64(cap(r.nodes))*radixNodeSize + int64(cap(r.values))*radixValueSize +
+		int64(cap(r.labels)) + int64(len(r.leaves))*radixEntrySize
+	r.bytes.Store(bytes)
+}
+
+func (r *radixIndex) label(n uint32) []byte {
+	node := &r.nodes[n]
+	return r.labels[node.off : node.off+node.size]
+}
+
+// key 从根节点开始拼接出完整的 Key
+func (r *radixIndex) key(n uint32) string {
+	size := 0
+	for p := n; p != 0; p = r.nodes[p].parent {
+		size += int(r.nodes[p].size)
+	}
+	buf := make([]byte, size)
+	for p := n; p != 0; p = r.nodes[p].parent {
+		size -= int(r.nodes[p].size)
+		copy(buf[size:], r.label(p))
+	}
+	return string(buf)
+}
+
+func (r *radixIndex) inode(n uint32) *INode {
+	v := &r.values[r.nodes[n].value-1]
+	return &INode{
+		RegionID:  v.RegionID,
+		Position:  v.Position,
+		Length:    v.Length,
+		ExpiredAt: v.ExpiredAt,
+		CreatedAt: v.CreatedAt,
+		Version:   v.Version,
+		Key:       r.key(n),
+	}
+}
+
+// get 返回 inum 对应的 INode，每次都会分配一个新的 INode
+func (r *radixIndex) get(inum uint64) (*INode, bool) {
+	n, ok := r.leaves[inum]
+	if !ok {
+		return nil, false
+	}
+	return r.inode(n), true
+}
+
+func (r *radixIndex) set(inum uint64, inode *INode) {
+	if old, ok := r.leaves[inum]; ok {
+		r.release(old)
+	}
+
+	n := r.insert(inode.Key)
+	value := radixValue{
+		RegionID:  inode.RegionID,
+		Position:  inode.Position,
+		Length:    inode.Length,
+		ExpiredAt: inode.ExpiredAt,
+		CreatedAt: inode.CreatedAt,
+		Version:   inode.Version,
+	}
+	if free := len(r.freeValue); free > 0 {
+		r.values[r.freeValue[free-1]] = value
+		r.nodes[n].value = r.freeValue[free-1] + 1
+		r.freeValue = r.freeValue[:free-1]
+	} else {
+		r.values = append(r.values, value)
+		r.nodes[n].value = uint32(len(r.values))
+	}
+	r.leaves[inum] = n
+	r.account()
+}
+
+func (r *radixIndex) remove(inum uint64) {
+	if n, ok := r.leaves[inum]; ok {
+		delete(r.leaves, inum)
+		r.release(n)
+		r.account()
+	}
+}
+
+// newNode 分配一个节点，优先使用空闲链表中的节点
+func (r *radixIndex) newNode(node radixNode) uint32 {
+	if n := r.freeNode; n != 0 {
+		r.freeNode = r.nodes[n].next
+		r.nodes[n] = node
+		return n
+	}
+	r.nodes = append(r.nodes, node)
+	return uint32(len(r.nodes) - 1)
+}
+
+// releaseNode 把节点放回空闲链表
+func (r *radixIndex) releaseNode(n uint32) {
+	r.garbage += int(r.nodes[n].size)
+	r.nodes[n] = radixNode{next: r.freeNode}
+	r.freeNode = n
+}
+
+// appendLabel 把边上的字节追加到 labels 中，不会引用调用方的 Key 导致整个 Key 无法回收
+func (r *radixIndex) appendLabel(parts ...[]byte) (uint32, uint32) {
+	off := len(r.labels)
+	for _, part := range parts {
+		r.labels = append(r.labels, part...)
+	}
+	return uint32(off), uint32(len(r.labels) - off)
+}
+
+// insert 返回 key 对应的节点，需要时拆分已有的边
+func (r *radixIndex) insert(key string) uint32 {
+	n := uint32(0)
+	for len(key) > 0 {
+		prev, next := uint32(0), r.nodes[n].child
+		for next != 0 && r.labels[r.nodes[next].off] != key[0] {
+			prev, next = next, r.nodes[next].next
+		}
+
+		if next == 0 {
+			off, size := r.appendLabel([]byte(key))
+			child := r.newNode(radixNode{parent: n, next: r.nodes[n].child, off: off, size: size})
+			r.nodes[n].child = child
+			return child
+		}
+
+		label := r.label(next)
+		common := 0
+		for common < len(label) && common < len(key) && label[common] == key[common] {
+			common++
+		}
+		if common < len(label) {
+			// 拆分边，next 保持原来的下标，leaves 中指向它的 inum 不需要修改
+			node := r.nodes[next]
+			mid := r.newNode(radixNode{parent: n, child: next, next: node.next, off: node.off, size: uint32(common)})
+			if prev == 0 {
+				r.nodes[n].child = mid
+			} else {
+				r.nodes[prev].next = mid
+			}
+			r.nodes[next].parent = mid
+			r.nodes[next].next = 0
+			r.nodes[next].off += uint32(common)
+			r.nodes[next].size -= uint32(common)
+			next = mid
+		}
+		n, key = next, key[common:]
+	}
+	return n
+}
+
+// unlink 把节点从父节点的子节点链表中移除
+func (r *radixIndex) unlink(n uint32) {
+	parent := r.nodes[n].parent
+	if r.nodes[parent].child == n {
+		r.nodes[parent].child = r.nodes[n].next
+		return
+	}
+	for p := r.nodes[parent].child; p != 0; p = r.nodes[p].next {
+		if r.nodes[p].next == n {
+			r.nodes[p].next = r.nodes[n].next
+			return
+		}
+	}
+}
+
+// release 清除节点中的 Key，然后删除不再需要的节点并且合并只有一个子节点的边
+func (r *radixIndex) release(n uint32) {
+	r.freeValue = append(r.freeValue, r.nodes[n].value-1)
+	r.nodes[n].value = 0
+
+	for n != 0 && r.nodes[n].value == 0 {
+		node := r.nodes[n]
+		if node.child == 0 {
+			r.unlink(n)
+			r.releaseNode(n)
+			n = node.parent
+			continue
+		}
+		if child := node.child; r.nodes[child].next == 0 {
+			// 子节点保持原来的下标，合并之后的边重新追加到 labels 中
+			off, size := r.appendLabel(r.label(n), r.label(child))
+			r.garbage += int(r.nodes[child].size)
+			r.nodes[child].off, r.nodes[child].size = off, size
+			r.nodes[child].parent = node.parent
+			r.nodes[child].next = node.next
+			r.replace(n, child)
+			r.releaseNode(n)
+		}
+		break
+	}
+	r.compact()
+}
+
+// replace 在父节点的子节点链表中使用 child 替换 n
+func (r *radixIndex) replace(n, child uint32) {
+	parent := r.nodes[n].parent
+	if r.nodes[parent].child == n {
+		r.nodes[parent].child = child
+		return
+	}
+	for p := r.nodes[parent].child; p != 0; p = r.nodes[p].next {
+		if r.nodes[p].next == n {
+			r.nodes[p].next = child
+			return
+		}
+	}
+}
+
+// compact 在 labels 中一半以上的字节不再使用时重新复制还在使用的字节
+func (r *radixIndex) compact() {
+	if r.garbage < 4096 || r.garbage*2 < len(r.labels) {
+		return
+	}
+	labels := make([]byte, 0, len(r.labels)-r.garbage)
+	for n := range r.nodes {
+		node := &r.nodes[n]
+		if n == 0 || node.size == 0 {
+			continue
+		}
+		off := len(labels)
+		labels = append(labels, r.labels[node.off:node.off+node.size]...)
+		node.off = uint32(off)
+	}
+	r.labels, r.garbage = labels, 0
+}
+
+// newIndexMap 创建一个指定类型的内存索引分片
+func newIndexMap(kind IndexKind, size int) *indexMap {
+	if kind == IndexRadix {
+		return &indexMap{trie: newRadixIndex()}
+	}
+	return &indexMap{index: make(map[uint64]*INode, size)}
+}
+
+// get 返回 inum 对应的 INode，调用方需要持有分片的锁
+// 基数树索引每次返回新的 INode，判断索引是否还指向同一条记录需要使用 sameINode
+func (imap *indexMap) get(inum uint64) (*INode, bool) {
+	if imap.trie != nil {
+		return imap.trie.get(inum)
+	}
+	inode, ok := imap.index[inum]
+	return inode, ok
+}
+
+func (imap *indexMap) set(inum uint64, inode *INode) {
+	if imap.trie != nil {
+		imap.trie.set(inum, inode)
+		return
+	}
+	imap.index[inum] = inode
+}
+
+func (imap *indexMap) remove(inum uint64) {
+	if imap.trie != nil {
+		imap.trie.remove(inum)
+		return
+	}
+	delete(imap.index, inum)
+}
+
+func (imap *indexMap) len() int {
+	if imap.trie != nil {
+		return len(imap.trie.leaves)
+	}
+	return len(imap.index)
+}
+
+// each 遍历分片中的所有索引，fn 返回 false 时停止，fn 中可以调用 remove 删除当前的索引
+func (imap *indexMap) each(fn func(inum uint64, inode *INode) bool) {
+	if imap.trie != nil {
+		for inum, n := range imap.trie.leaves {
+			if !fn(inum, imap.trie.inode(n)) {
+				return
+			}
+		}
+		return
+	}
+	for inum, inode := range imap.index {
+		if !fn(inum, inode) {
+			return
+		}
+	}
+}
+
+// swap 使用 fresh 中的索引替换分片中的索引
+func (imap *indexMap) swap(fresh *indexMap) {
+	imap.index, imap.trie = fresh.index, fresh.trie
+}
+
+// sameINode 判断两个 INode 是否指向同一条记录
+func sameINode(a, b *INode) bool {
+	return a.RegionID == b.RegionID && a.Position == b.Position
+}
@@ -0,0 +1,146 @@
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestRadixIndex(t *testing.T) {
+	r := newRadixIndex()
+	keys := []string{"", "a", "ab", "abc", "abd", "b", "abcdef", "ab\x00", "\xff\xfe"}
+	for i, key := range keys {
+		r.set(InodeNum(key), &INode{RegionID: uint64(i), Position: int64(i), Key: key})
+	}
+	// 覆盖已有的 Key
+	r.set(InodeNum("abc"), &INode{RegionID: 99, Key: "abc"})
+
+	for i, key := range keys {
+		inode, ok := r.get(InodeNum(key))
+		if !ok || inode.Key != key {
+			t.Fatalf("expected key %q, got %v %v", key, inode, ok)
+		}
+		if key != "abc" && inode.RegionID != uint64(i) {
+			t.Errorf("expected region %d for %q, got %d", i, key, inode.RegionID)
+		}
+	}
+	if inode, _ := r.get(InodeNum("abc")); inode.RegionID != 99 {
+		t.Errorf("expected overwritten region 99, got %d", inode.RegionID)
+	}
+
+	for _, key := range keys {
+		r.remove(InodeNum(key))
+		if _, ok := r.get(InodeNum(key)); ok {
+			t.Fatalf("expected %q to be removed", key)
+		}
+	}
+	if len(r.leaves) != 0 || r.nodes[0].child != 0 || len(r.freeValue) != len(r.values) {
+		t.Errorf("expected empty trie, got %d leaves, %d free values", len(r.leaves), len(r.freeValue))
+	}
+
+	// 反复写入和删除之后节点被重复使用，不再使用的边会被回收
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 500; i++ {
+			key := fmt.Sprintf("/data/round-%02d/file-%04d", round, i)
+			r.set(InodeNum(key), &INode{Key: key})
+		}
+		for i := 0; i < 500; i++ {
+			r.remove(InodeNum(fmt.Sprintf("/data/round-%02d/file-%04d", round, i)))
+		}
+	}
+	if len(r.labels)-r.garbage > 0 || len(r.nodes) > 1500 {
+		t.Errorf("expected nodes and labels to be reused, got %d nodes, %d label bytes", len(r.nodes), len(r.labels)-r.garbage)
+	}
+}
+
+func TestRadixIndexOperations(t *testing.T) {
+	path := t.TempDir()
+	opt := &Options{Path: path, FsPerm: fsPerm, Threshold: 1, Index: IndexRadix}
+	lfs, err := OpenFS(opt)
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+
+	var want []string
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("https://example.com/static/assets/images/%03d.png", i)
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, key), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+		if i >= 10 {
+			want = append(want, key)
+		}
+	}
+	err = lfs.DeletePrefix("https://example.com/static/assets/images/00")
+	if err != nil {
+		t.Fatalf("failed to delete prefix: %v", err)
+	}
+
+	check := func(lfs *LogStructuredFS) {
+		t.Helper()
+		var keys []string
+		it := lfs.NewIterator(context.Background(), "https://example.com/")
+		for it.Next() {
+			keys = append(keys, it.Key())
+		}
+		it.Close()
+		if !reflect.DeepEqual(keys, want) {
+			t.Fatalf("expected %d keys, got %v", len(want), keys)
+		}
+		seg, err := lfs.FetchSegment(InodeNum(want[0]))
+		if err != nil || string(seg.Value) != want[0] {
+			t.Fatalf("expected %s, got %v %v", want[0], seg, err)
+		}
+	}
+	check(lfs)
+
+	err = lfs.CloseFS()
+	if err != nil {
+		t.Fatalf("failed to close fs: %v", err)
+	}
+
+	// 从索引快照恢复到基数树中
+	lfs, err = OpenFS(opt)
+	if err != nil {
+		t.Fatalf("failed to reopen fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+	check(lfs)
+
+	keys := lfs.Keys("*")
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, want) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+}
+
+func TestRadixIndexMemory(t *testing.T) {
+	prefix := "https://cdn.example.com/assets/v2/tenants/acme-corporation/projects/website-redesign/releases/2024-12-31/static/images/"
+	memory := func(kind IndexKind) int64 {
+		lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1, Index: kind})
+		if err != nil {
+			t.Fatalf("failed to open fs: %v", err)
+		}
+		defer mustCloseFS(t, lfs)
+
+		err = lfs.Batch(func(b *Batch) error {
+			for i := 0; i < 20000; i++ {
+				key := fmt.Sprintf("%simage-%05d.jpg", prefix, i)
+				b.AddSegment(InodeNum(key), *testSegment(key, "v"))
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("failed to commit batch: %v", err)
+		}
+		return lfs.indexMemory()
+	}
+
+	flat, radix := memory(IndexHashMap), memory(IndexRadix)
+	if radix*3/2 > flat {
+		t.Errorf("expected radix index to use much less memory, got %d bytes vs %d bytes", radix, flat)
+	}
+}
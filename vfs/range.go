@@ -0,0 +1,122 @@
+package vfs
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// DeleteRange 删除 [start, end) 范围内的所有 Key，end 为空表示删除 start 之后的所有 Key
+// 只会写入一条范围删除记录，不需要调用方逐个扫描删除
+func (lfs *LogStructuredFS) DeleteRange(start, end string) error {
+	start = lfs.transformKey(start)
+	if end != "" {
+		end = lfs.transformKey(end)
+	}
+	return lfs.deleteRangeKeys(start, end)
+}
+
+// deleteRangeKeys 删除已经转换过的 [start, end) 范围内的 Key
+func (lfs *LogStructuredFS) deleteRangeKeys(start, end string) error {
+	for _, key := range []string{start, end} {
+		if err := checkKeySize(len(key)); err != nil {
+			return err
+		}
+	}
+	seg := NewRangeTombstoneSegment([]byte(start), []byte(end))
+
+	lfs.limiter.wait(int(seg.Size()))
+
+	// 写入在追加记录到更新索引期间持有 appendMu 的读锁，持有写锁时没有写入到一半的记录
+	// 范围删除记录之前的写入都已经更新了索引，之后的写入要等到删除完索引之后才能追加，内存索引和重放的结果一致
+	lfs.appendMu.Lock()
+	inode, err := lfs.appendSegment(seg)
+	if err != nil {
+		lfs.appendMu.Unlock()
+		lfs.limiter.refund(int(seg.Size()))
+		return err
+	}
+
+	// 范围删除记录本身不会被索引引用
+	lfs.markDead(inode)
+	lfs.deleteRangeIndex(start, end)
+	lfs.appendMu.Unlock()
+
+	return lfs.audit.record("", AuditDeleteRange, start, end)
+}
+
+// DeletePrefix 删除所有以 prefix 开头的 Key，只有字节序中以 prefix 开头的 Key 是连续的一个范围
+func (lfs *LogStructuredFS) DeletePrefix(prefix string) error {
+	if lfs.comparator.Name() != BytewiseComparator.Name() {
+		return fmt.Errorf("failed to delete prefix: keys with the same prefix are not contiguous in comparator %s", lfs.comparator.Name())
+	}
+	prefix = lfs.transformKey(prefix)
+	return lfs.deleteRangeKeys(prefix, prefixEnd(prefix))
+}
+
+// deleteRangeIndex 从内存索引中删除范围内的 Key
+func (lfs *LogStructuredFS) deleteRangeIndex(start, end string) {
+	for _, shard := range lfs.indexs {
+		var deleted []*INode
+		shard.mu.Lock()
+		shard.each(func(inum uint64, inode *INode) bool {
+			if inRange(lfs.comparator, inode.Key, start, end) {
+				shard.remove(inum)
+				deleted = append(deleted, inode)
+				lfs.cache.remove(inum)
+			}
+			return true
+		})
+		shard.mu.Unlock()
+
+		for _, inode := range deleted {
+			lfs.indexBytes.Add(-inodeMemory(len(inode.Key)))
+			lfs.markDead(inode)
+		}
+	}
+}
+
+// rangeINodes 返回范围内所有 Key 对应的 inum 和 INode
+func (lfs *LogStructuredFS) rangeINodes(start, end string) map[uint64]*INode {
+	inodes := make(map[uint64]*INode)
+	for _, shard := range lfs.indexs {
+		shard.mu.RLock()
+		shard.each(func(inum uint64, inode *INode) bool {
+			if inRange(lfs.comparator, inode.Key, start, end) {
+				inodes[inum] = inode
+			}
+			return true
+		})
+		shard.mu.RUnlock()
+	}
+	return inodes
+}
+
+// deleteRange 在崩溃恢复时重放范围删除记录，恢复时不需要上锁
+func deleteRange(indexs []*indexMap, cmp Comparator, start, end string) {
+	for _, imap := range indexs {
+		imap.each(func(inum uint64, inode *INode) bool {
+			if inRange(cmp, inode.Key, start, end) {
+				imap.remove(inum)
+			}
+			return true
+		})
+	}
+}
+
+// inRange 判断 key 是否在 cmp 顺序的 [start, end) 范围内，end 为空表示没有上限
+func inRange(cmp Comparator, key, start, end string) bool {
+	return compareKeys(cmp, key, start) >= 0 && (end == "" || compareKeys(cmp, key, end) < 0)
+}
+
+// prefixEnd 返回比所有以 prefix 开头的 Key 都大的最小 Key
+// 如果 prefix 全部是 0xFF 就没有上限，返回空字符串
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xFF {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return string(bytes.TrimRight(end, "\xff"))
+}
@@ -0,0 +1,167 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeletePrefixWithCompactionAndRecovery(t *testing.T) {
+	path := t.TempDir()
+	lfs, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+
+	put := func(key string) {
+		t.Helper()
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value-"+key), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+
+	put("user:01")
+	put("user:02")
+	put("order:01")
+	if err := lfs.ChangeRegions(); err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+
+	err = lfs.DeletePrefix("user:")
+	if err != nil {
+		t.Fatalf("failed to delete prefix: %v", err)
+	}
+	put("user:03")
+	if err := lfs.ChangeRegions(); err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+
+	// 范围删除记录迁移到新数据文件之后，user:03 依然有效
+	err = lfs.compactRegion(2)
+	if err != nil {
+		t.Fatalf("failed to compact region: %v", err)
+	}
+
+	check := func() {
+		t.Helper()
+		for _, key := range []string{"user:01", "user:02"} {
+			if _, err := lfs.FetchSegment(InodeNum(key)); !errors.Is(err, ErrSegmentNotFound) {
+				t.Errorf("expected %s deleted, got: %v", key, err)
+			}
+		}
+		for _, key := range []string{"user:03", "order:01"} {
+			if _, err := lfs.FetchSegment(InodeNum(key)); err != nil {
+				t.Errorf("expected %s exists, got: %v", key, err)
+			}
+		}
+	}
+	check()
+
+	mustCloseFS(t, lfs)
+	os.Remove(filepath.Join(path, indexFileName))
+
+	lfs, err = OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to reopen fs: %v", err)
+	}
+	defer lfs.CloseFS()
+	check()
+}
+
+func TestPrefixEnd(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{prefix: "user:", want: "user;"},
+		{prefix: "a\xff", want: "b"},
+		{prefix: "\xff\xff", want: ""},
+		{prefix: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := prefixEnd(tt.prefix); got != tt.want {
+			t.Errorf("prefixEnd(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+// scanHookComparator 和字节序相同，第一次比较时调用 hook，范围删除扫描内存索引时用来插入并发的写入
+type scanHookComparator struct {
+	once sync.Once
+	hook func()
+}
+
+func (c *scanHookComparator) Name() string { return "test.scanhook" }
+
+func (c *scanHookComparator) Compare(a, b string) int {
+	if c.hook != nil {
+		c.once.Do(c.hook)
+	}
+	return strings.Compare(a, b)
+}
+
+func TestDeleteRangeConcurrentPut(t *testing.T) {
+	path := t.TempDir()
+	cmp := new(scanHookComparator)
+	lfs, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1, Comparator: cmp})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+
+	// 找到一个分片排在已有 Key 之后的 Key，扫描到已有的 Key 时它所在的分片还没有被扫描
+	first := "user:00"
+	err = lfs.AddSegment(InodeNum(first), *testSegment(first, "value"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+	var later string
+	for i := 1; later == ""; i++ {
+		key := fmt.Sprintf("user:%02d", i)
+		if InodeNum(key)%uint64(indexShard) > InodeNum(first)%uint64(indexShard) {
+			later = key
+		}
+	}
+
+	// 范围删除记录追加之后、扫描内存索引期间写入范围内的 Key
+	done := make(chan error, 1)
+	cmp.hook = func() {
+		go func() {
+			done <- lfs.AddSegment(InodeNum(later), *testSegment(later, "value"), 0)
+		}()
+		select {
+		case err := <-done:
+			done <- err
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	err = lfs.DeleteRange("user:", "user;")
+	if err != nil {
+		t.Fatalf("failed to delete range: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+	cmp.hook = nil
+
+	_, exists := lfs.GetINode(InodeNum(later))
+	mustCloseFS(t, lfs)
+	os.Remove(filepath.Join(path, indexFileName))
+
+	lfs, err = OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1, Comparator: cmp})
+	if err != nil {
+		t.Fatalf("failed to reopen fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	// 内存索引和崩溃恢复重放的结果必须一致
+	if _, ok := lfs.GetINode(InodeNum(later)); ok != exists {
+		t.Errorf("expected %s exists %t as in memory before recovery, got %t", later, exists, ok)
+	}
+}
@@ -0,0 +1,332 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/auula/wiredkv/clog"
+)
+
+// 没有配置 RefreshInterval 时只读实例刷新内存索引的周期
+const defaultRefreshInterval = 10 * time.Second
+
+var ErrReadOnly = errors.New("log structured file system is read-only")
+
+// Refresh 重新扫描数据目录，把写入进程新封存的数据文件加入只读实例的内存索引
+// 编号最大的数据文件是写入进程正在追加的活跃数据文件，只读实例看不到其中的记录
+// 已经加载的数据文件被压缩删除之后会继续使用打开的文件描述符读取，
+// 等到压缩迁移的记录所在的数据文件也被封存之后，再用现有的数据文件重建整个内存索引
+func (lfs *LogStructuredFS) Refresh() error {
+	if !lfs.readOnly {
+		return errors.New("failed to refresh index: not a read-only instance")
+	}
+
+	lfs.refreshMu.Lock()
+	defer lfs.refreshMu.Unlock()
+
+	ids, err := listRegionIDs(lfs.directory)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	active, sealed := ids[len(ids)-1], ids[:len(ids)-1]
+
+	present := make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		present[id] = true
+	}
+
+	lfs.mu.Lock()
+	if lfs.closed {
+		lfs.mu.Unlock()
+		return ErrClosed
+	}
+	for id := range lfs.regions {
+		if _, ok := lfs.stale[id]; !ok && !present[id] {
+			lfs.stale[id] = active
+		}
+	}
+	loaded := lfs.regionID
+	lfs.mu.Unlock()
+
+	replayer := &indexReplayer{indexs: lfs.indexs, locked: true, cache: lfs.cache, cmp: lfs.comparator}
+	for _, id := range sealed {
+		if id <= loaded {
+			continue
+		}
+		var err error
+		if lfs.tail != nil && lfs.tail.replay.regionID == id {
+			err = lfs.finishTail()
+		} else {
+			err = lfs.loadRegion(id, replayer)
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			// 列出数据目录之后才被压缩删除的数据文件，迁移的记录一定在 active 或者之前的数据文件中
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load region %d: %w", id, err)
+		}
+	}
+
+	if lfs.follower != nil {
+		err := lfs.followActive(active, replayer)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to follow region %d: %w", active, err)
+		}
+	}
+
+	// 所有被删除的数据文件迁移的记录都已经加载之后才能重建
+	lfs.mu.Lock()
+	rebuild := len(lfs.stale) > 0
+	for _, until := range lfs.stale {
+		if lfs.regionID < until {
+			rebuild = false
+		}
+	}
+	lfs.mu.Unlock()
+	if rebuild {
+		return lfs.rebuildIndex()
+	}
+
+	return nil
+}
+
+// loadRegion 打开一个封存的数据文件，优先从 hint 文件把它的记录重放到内存索引
+func (lfs *LogStructuredFS) loadRegion(regionID uint64, r replayer) error {
+	fd, err := os.Open(filepath.Join(lfs.directory, formatDataFileName(regionID)))
+	if err != nil {
+		return err
+	}
+
+	version, checksum, err := readFileVersion(fd)
+	if err != nil {
+		_ = fd.Close()
+		return err
+	}
+
+	// 先登记数据文件再更新索引，读取到新索引时一定能找到对应的数据文件
+	lfs.mu.Lock()
+	lfs.regions[regionID] = fd
+	lfs.versions[regionID] = version
+	lfs.checksums[regionID] = checksum
+	lfs.mu.Unlock()
+
+	sequence, err := replaySealedRegion(regionID, fd, version, checksum, r)
+	if err != nil {
+		return err
+	}
+
+	lfs.mu.Lock()
+	lfs.regionID = regionID
+	lfs.mu.Unlock()
+	if sequence > lfs.sequence.Load() {
+		lfs.sequence.Store(sequence)
+	}
+
+	return nil
+}
+
+// replaySealedRegion 读取数据文件的 hint 文件，没有或者已经损坏时扫描整个数据文件
+func replaySealedRegion(regionID uint64, fd *os.File, version uint8, checksum Checksum, r replayer) (uint64, error) {
+	sequence, err := readHint(fd.Name(), regionID, r)
+	if err == nil {
+		return sequence, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		clog.Warnf("failed to read hint file of region %d, scanning data file: %v", regionID, err)
+	}
+
+	finfo, err := fd.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return replayRegion(&sealedFile{vfsFile: fd, size: finfo.Size()}, regionID, version, checksum, r)
+}
+
+// rebuildIndex 使用还存在的封存数据文件重建内存索引，然后关闭已经被压缩删除的数据文件
+func (lfs *LogStructuredFS) rebuildIndex() error {
+	fresh := make([]*indexMap, indexShard)
+	for i := range fresh {
+		fresh[i] = newIndexMap(lfs.indexKind, 0)
+	}
+
+	// 正在跟随的活跃数据文件最后单独重放
+	lfs.mu.Lock()
+	regionIds := make([]uint64, 0, len(lfs.regions))
+	for id := range lfs.regions {
+		if id <= lfs.regionID {
+			regionIds = append(regionIds, id)
+		}
+	}
+	lfs.mu.Unlock()
+	sort.Slice(regionIds, func(i, j int) bool {
+		return regionIds[i] < regionIds[j]
+	})
+
+	replayer := &indexReplayer{indexs: fresh, cmp: lfs.comparator}
+	var stale []*os.File
+	for _, id := range regionIds {
+		lfs.mu.Lock()
+		fd := lfs.regions[id].(*os.File)
+		version, checksum := lfs.versions[id], lfs.checksums[id]
+		lfs.mu.Unlock()
+
+		if _, err := os.Stat(fd.Name()); errors.Is(err, os.ErrNotExist) {
+			stale = append(stale, fd)
+			continue
+		}
+
+		_, err := replaySealedRegion(id, fd, version, checksum, replayer)
+		if err != nil {
+			return fmt.Errorf("failed to rebuild index from region %d: %w", id, err)
+		}
+	}
+
+	var tail *tailState
+	if lfs.tail != nil {
+		tail = &tailState{
+			fd:       lfs.tail.fd,
+			version:  lfs.tail.version,
+			checksum: lfs.tail.checksum,
+			offset:   int64(len(dataFileMetadata)),
+			replay:   &segmentReplay{regionID: lfs.tail.replay.regionID, r: replayer},
+		}
+		err := lfs.tailRegion(tail, false)
+		if err != nil {
+			return fmt.Errorf("failed to rebuild index from region %d: %w", tail.replay.regionID, err)
+		}
+		tail.replay.r = &indexReplayer{indexs: lfs.indexs, locked: true, cache: lfs.cache, cmp: lfs.comparator}
+	}
+
+	for i, shard := range lfs.indexs {
+		shard.mu.Lock()
+		shard.swap(fresh[i])
+		shard.mu.Unlock()
+	}
+	lfs.cache.clear()
+	if tail != nil {
+		lfs.tail = tail
+	}
+
+	lfs.mu.Lock()
+	for _, fd := range stale {
+		for id, region := range lfs.regions {
+			if region == vfsFile(fd) {
+				delete(lfs.regions, id)
+				delete(lfs.versions, id)
+				delete(lfs.checksums, id)
+				delete(lfs.sparse, id)
+			}
+		}
+	}
+	lfs.stale = make(map[uint64]uint64)
+	lfs.mu.Unlock()
+
+	// 正在读取这些数据文件的 FetchSegment 会重新查找一次索引
+	for _, fd := range stale {
+		_ = fd.Close()
+	}
+
+	return nil
+}
+
+// listRegionIDs 返回数据目录中所有本地数据文件的编号，从小到大排序
+func listRegionIDs(path string) ([]uint64, error) {
+	files, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	var ids []uint64
+	for _, file := range files {
+		if file.IsDir() || !isRegionFileName(file.Name()) {
+			continue
+		}
+		id, err := parseDataFileName(file.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to get regions id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	return ids, nil
+}
+
+// startRefreshDaemon 启动只读实例周期性刷新内存索引的 goroutine
+func (lfs *LogStructuredFS) startRefreshDaemon(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultRefreshInterval
+	}
+
+	lfs.refreshdone = make(chan struct{})
+	lfs.refreshexit = make(chan struct{})
+	go func() {
+		defer close(lfs.refreshexit)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := lfs.Refresh()
+				if err != nil {
+					clog.Errorf("failed to refresh read-only index: %v", err)
+				}
+			case <-lfs.refreshdone:
+				return
+			}
+		}
+	}()
+}
+
+// stopRefreshDaemon 停止刷新内存索引的 goroutine 并且等待它退出
+func (lfs *LogStructuredFS) stopRefreshDaemon() {
+	if lfs.refreshdone == nil {
+		return
+	}
+	close(lfs.refreshdone)
+	<-lfs.refreshexit
+	lfs.refreshdone = nil
+}
+
+// closeReadOnly 关闭只读实例打开的数据文件并且释放共享锁
+func (lfs *LogStructuredFS) closeReadOnly() error {
+	lfs.stopRefreshDaemon()
+
+	lfs.refreshMu.Lock()
+	follower := lfs.follower
+	lfs.refreshMu.Unlock()
+	if follower != nil {
+		follower.Stop()
+	}
+
+	lfs.refreshMu.Lock()
+	defer lfs.refreshMu.Unlock()
+
+	lfs.mu.Lock()
+	defer lfs.mu.Unlock()
+	if lfs.closed {
+		return ErrClosed
+	}
+	lfs.closed = true
+	defer releaseSecret()
+
+	for _, file := range lfs.regions {
+		err := closeFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to close region file: %w", err)
+		}
+	}
+
+	return lfs.lock.unlock()
+}
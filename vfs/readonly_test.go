@@ -0,0 +1,201 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/auula/wiredkv/utils"
+)
+
+func TestReadOnlyInstance(t *testing.T) {
+	path := t.TempDir()
+	writer, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, writer)
+
+	put := func(key, value string) {
+		t.Helper()
+		err := writer.AddSegment(InodeNum(key), *testSegment(key, value), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+
+	put("key-01", "value-01")
+	put("key-02", "value-02")
+	err = writer.DelSegment("key-02")
+	if err != nil {
+		t.Fatalf("failed to delete segment: %v", err)
+	}
+	err = writer.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+	put("key-03", "value-03")
+
+	reader, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("failed to open read-only fs: %v", err)
+	}
+
+	// 多个只读实例可以同时打开
+	other, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("failed to open second read-only fs: %v", err)
+	}
+	mustCloseFS(t, other)
+
+	seg, err := reader.FetchSegment(InodeNum("key-01"))
+	if err != nil {
+		t.Fatalf("failed to fetch sealed segment: %v", err)
+	}
+	if string(seg.Value) != "value-01" {
+		t.Errorf("expected value-01, got %s", seg.Value)
+	}
+
+	// 删除记录和活跃数据文件中的记录都不可见
+	for _, key := range []string{"key-02", "key-03"} {
+		_, err = reader.FetchSegment(InodeNum(key))
+		if !errors.Is(err, ErrSegmentNotFound) {
+			t.Errorf("expected ErrSegmentNotFound for %s, got %v", key, err)
+		}
+	}
+
+	err = reader.AddSegment(InodeNum("key-04"), *testSegment("key-04", "value-04"), 0)
+	if !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly, got %v", err)
+	}
+
+	// 封存之后刷新就能看到新的记录
+	err = writer.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+	err = reader.Refresh()
+	if err != nil {
+		t.Fatalf("failed to refresh: %v", err)
+	}
+	seg, err = reader.FetchSegment(InodeNum("key-03"))
+	if err != nil {
+		t.Fatalf("failed to fetch refreshed segment: %v", err)
+	}
+	if string(seg.Value) != "value-03" {
+		t.Errorf("expected value-03, got %s", seg.Value)
+	}
+
+	// 压缩删除已经加载的数据文件之后，迁移的记录封存之前仍然可以读取
+	err = writer.Compact(context.Background(), CompactOptions{FileIDs: []uint64{1}})
+	if err != nil {
+		t.Fatalf("failed to compact region: %v", err)
+	}
+	err = reader.Refresh()
+	if err != nil {
+		t.Fatalf("failed to refresh after compaction: %v", err)
+	}
+	if _, err := reader.FetchSegment(InodeNum("key-01")); err != nil {
+		t.Errorf("failed to fetch compacted segment before seal: %v", err)
+	}
+
+	err = writer.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+	err = reader.Refresh()
+	if err != nil {
+		t.Fatalf("failed to refresh after seal: %v", err)
+	}
+	if _, ok := reader.regions[1]; ok {
+		t.Errorf("expected compacted region to be closed after rebuild")
+	}
+	for _, key := range []string{"key-01", "key-03"} {
+		if _, err := reader.FetchSegment(InodeNum(key)); err != nil {
+			t.Errorf("failed to fetch %s after rebuild: %v", key, err)
+		}
+	}
+	if reader.Count() != 2 {
+		t.Errorf("expected 2 keys, got %d", reader.Count())
+	}
+
+	mustCloseFS(t, reader)
+}
+
+func TestReadersBlockMigrate(t *testing.T) {
+	path := t.TempDir()
+	lfs, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	mustCloseFS(t, lfs)
+
+	reader, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("failed to open read-only fs: %v", err)
+	}
+
+	_, err = MigrateRegions(path, currentFormat)
+	if !errors.Is(err, ErrReadersActive) {
+		t.Errorf("expected ErrReadersActive, got %v", err)
+	}
+
+	mustCloseFS(t, reader)
+}
+
+func TestHintFile(t *testing.T) {
+	path := t.TempDir()
+	lfs, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+
+	for _, key := range []string{"key-01", "key-02", "key-03"} {
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value"), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+	err = lfs.DelSegment("key-01")
+	if err != nil {
+		t.Fatalf("failed to delete segment: %v", err)
+	}
+	err = lfs.DeleteRange("key-03", "")
+	if err != nil {
+		t.Fatalf("failed to delete range: %v", err)
+	}
+	err = lfs.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+	mustCloseFS(t, lfs)
+
+	name := filepath.Join(path, formatDataFileName(1))
+	if !utils.IsExist(hintFileName(name)) {
+		t.Fatalf("expected hint file for sealed region")
+	}
+
+	indexs := make([]*indexMap, indexShard)
+	for i := range indexs {
+		indexs[i] = &indexMap{index: make(map[uint64]*INode)}
+	}
+	_, err = readHint(name, 1, &indexReplayer{indexs: indexs})
+	if err != nil {
+		t.Fatalf("failed to read hint file: %v", err)
+	}
+
+	count := 0
+	for _, imap := range indexs {
+		count += len(imap.index)
+	}
+	inode, ok := indexs[InodeNum("key-02")%uint64(indexShard)].index[InodeNum("key-02")]
+	if count != 1 || !ok || inode.RegionID != 1 {
+		t.Errorf("expected only key-02 in region 1, got %d entries", count)
+	}
+
+	_, err = readHint(name, 2, &indexReplayer{indexs: indexs})
+	if !errors.Is(err, ErrCorruptedHint) {
+		t.Errorf("expected ErrCorruptedHint for wrong region, got %v", err)
+	}
+}
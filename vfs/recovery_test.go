@@ -0,0 +1,96 @@
+package vfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParallelRecovery(t *testing.T) {
+	path := t.TempDir()
+	lfs, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+
+	// 后面的数据文件覆盖、删除和范围删除前面数据文件中的 Key，结果依赖数据文件的重放顺序
+	for region := 0; region < 12; region++ {
+		for i := 0; i < 50; i++ {
+			key := fmt.Sprintf("key:%02d:%03d", i%10, i)
+			err := lfs.AddSegment(InodeNum(key), *testSegment(key, fmt.Sprintf("%d", region)), 0)
+			if err != nil {
+				t.Fatalf("failed to add segment: %v", err)
+			}
+		}
+		if region%3 == 1 {
+			key := fmt.Sprintf("key:%02d:%03d", region%10, region)
+			if err := lfs.AddSegment(InodeNum(key), *NewTombstoneSegment([]byte(key)), 0); err != nil {
+				t.Fatalf("failed to delete segment: %v", err)
+			}
+		}
+		if region == 7 {
+			if err := lfs.DeletePrefix("key:05:"); err != nil {
+				t.Fatalf("failed to delete prefix: %v", err)
+			}
+		}
+		if err := lfs.ChangeRegions(); err != nil {
+			t.Fatalf("failed to change regions: %v", err)
+		}
+	}
+
+	want := make(map[uint64]INode)
+	for _, imap := range lfs.indexs {
+		imap.each(func(inum uint64, inode *INode) bool {
+			want[inum] = *inode
+			return true
+		})
+	}
+	sequence := lfs.sequence.Load()
+	mustCloseFS(t, lfs)
+
+	reopen := func(workers int, hints bool) {
+		t.Helper()
+		os.Remove(filepath.Join(path, indexFileName))
+		if !hints {
+			matches, _ := filepath.Glob(filepath.Join(path, "*"+hintExtension))
+			for _, name := range matches {
+				os.Remove(name)
+			}
+		}
+
+		lfs, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1, RecoveryWorkers: workers})
+		if err != nil {
+			t.Fatalf("failed to reopen fs with %d workers: %v", workers, err)
+		}
+		defer mustCloseFS(t, lfs)
+
+		got := 0
+		for _, imap := range lfs.indexs {
+			imap.each(func(inum uint64, inode *INode) bool {
+				got++
+				if expected, ok := want[inum]; !ok || expected != *inode {
+					t.Errorf("expected %+v, got %+v", expected, *inode)
+				}
+				return true
+			})
+		}
+		if got != len(want) {
+			t.Errorf("expected %d indexes with %d workers, got %d", len(want), workers, got)
+		}
+		if lfs.sequence.Load() != sequence {
+			t.Errorf("expected sequence %d, got %d", sequence, lfs.sequence.Load())
+		}
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(path, "*"+hintExtension))
+	if len(matches) == 0 {
+		t.Fatal("expected hint files of sealed regions")
+	}
+
+	// 优先读取 hint 文件，然后删除 hint 文件扫描数据文件
+	reopen(8, true)
+	reopen(1, false)
+	reopen(3, false)
+	reopen(0, false)
+}
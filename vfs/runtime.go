@@ -0,0 +1,125 @@
+package vfs
+
+import (
+	"fmt"
+	"time"
+)
+
+// RuntimeOptions 是打开之后不需要重新打开就能修改的配置
+// 先通过 RuntimeOptions 获取当前的配置，修改需要的字段之后再交给 SetOptions
+type RuntimeOptions struct {
+	// Sync 和 SyncInterval 写入之后的刷盘策略，含义和 Options 中的相同
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+	// MaxCacheMemory 数据记录缓存可以使用的最大字节数，0 表示关闭缓存，缩小时立即淘汰超出的记录
+	MaxCacheMemory int64
+	// GCRatio 后台 gc 只回收垃圾数据比例不低于 GCRatio 的数据文件
+	GCRatio float64
+	// WriteBytesPerSec 和 WriteOpsPerSec 限制每秒写入的字节数和次数，0 表示不限制
+	WriteBytesPerSec int64
+	WriteOpsPerSec   int64
+}
+
+func (opt *RuntimeOptions) validate() error {
+	if opt.Sync < SyncNever || opt.Sync > SyncInterval {
+		return fmt.Errorf("failed to set options: unsupported sync policy %d", opt.Sync)
+	}
+	if opt.SyncInterval < 0 || opt.MaxCacheMemory < 0 || opt.WriteBytesPerSec < 0 || opt.WriteOpsPerSec < 0 {
+		return fmt.Errorf("failed to set options: negative value in %+v", *opt)
+	}
+	return validateGCRatio(opt.GCRatio)
+}
+
+func validateGCRatio(ratio float64) error {
+	if ratio < 0 || ratio > 1 {
+		return fmt.Errorf("gc ratio %v must be between 0 and 1", ratio)
+	}
+	return nil
+}
+
+// RuntimeOptions 返回当前生效的运行时配置
+func (lfs *LogStructuredFS) RuntimeOptions() RuntimeOptions {
+	lfs.mu.Lock()
+	opt := RuntimeOptions{
+		Sync:           lfs.syncPolicy,
+		SyncInterval:   lfs.syncInterval,
+		MaxCacheMemory: lfs.cache.capacity.Load(),
+		GCRatio:        lfs.gcRatio,
+	}
+	lfs.mu.Unlock()
+
+	opt.WriteBytesPerSec, opt.WriteOpsPerSec = lfs.limiter.limit()
+
+	return opt
+}
+
+// SetOptions 运行时修改刷盘策略、缓存大小、gc 阈值和写入限速，已经写入的数据不受影响
+func (lfs *LogStructuredFS) SetOptions(opt RuntimeOptions) error {
+	err := opt.validate()
+	if err != nil {
+		return err
+	}
+
+	lfs.optMu.Lock()
+	defer lfs.optMu.Unlock()
+
+	lfs.mu.Lock()
+	if lfs.closed {
+		lfs.mu.Unlock()
+		return ErrClosed
+	}
+	changed := lfs.syncPolicy != opt.Sync || lfs.syncInterval != opt.SyncInterval
+	lfs.gcRatio = opt.GCRatio
+	lfs.mu.Unlock()
+
+	lfs.cache.resize(opt.MaxCacheMemory)
+	lfs.limiter.setLimit(opt.WriteBytesPerSec, opt.WriteOpsPerSec)
+
+	if !changed {
+		return nil
+	}
+
+	// 后台刷盘的 goroutine 需要获取 lfs.mu，必须在加锁之前停止
+	lfs.stopSyncDaemon()
+
+	lfs.mu.Lock()
+	lfs.syncPolicy, lfs.syncInterval = opt.Sync, opt.SyncInterval
+	lfs.mu.Unlock()
+
+	if opt.Sync == SyncInterval {
+		lfs.startSyncDaemon(opt.SyncInterval)
+	}
+
+	// 切换到更严格的策略之前写入的记录也要刷盘
+	if opt.Sync == SyncAlways {
+		return lfs.Sync()
+	}
+
+	return nil
+}
+
+// gcRegions 返回后台 gc 需要回收的数据文件，垃圾数据比例低于 gcRatio 的数据文件跳过
+// 旧格式版本的数据文件总是需要重写
+func (lfs *LogStructuredFS) gcRegions(n int) []uint64 {
+	lfs.mu.Lock()
+	ratio := lfs.gcRatio
+	lfs.mu.Unlock()
+
+	var regionIds []uint64
+	for _, regionID := range lfs.dirtyRegions(0) {
+		if n > 0 && len(regionIds) >= n {
+			break
+		}
+		if ratio > 0 && lfs.garbageRatio(regionID) < ratio {
+			lfs.mu.Lock()
+			version := lfs.versions[regionID]
+			lfs.mu.Unlock()
+			if version == currentFormat {
+				continue
+			}
+		}
+		regionIds = append(regionIds, regionID)
+	}
+
+	return regionIds
+}
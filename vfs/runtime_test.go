@@ -0,0 +1,69 @@
+package vfs
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSetOptions(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1, MaxCacheMemory: 1 * MB})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+
+	for _, key := range []string{"key-01", "key-02"} {
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value"), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+		if _, err := lfs.FetchSegment(InodeNum(key)); err != nil {
+			t.Fatalf("failed to fetch segment: %v", err)
+		}
+	}
+	if len(lfs.cache.entries) != 2 {
+		t.Fatalf("expected 2 cached segments, got %d", len(lfs.cache.entries))
+	}
+
+	opt := lfs.RuntimeOptions()
+	opt.Sync = SyncInterval
+	opt.SyncInterval = 10 * time.Millisecond
+	opt.MaxCacheMemory = 0
+	opt.GCRatio = 0.5
+	opt.WriteOpsPerSec = 1000
+	err = lfs.SetOptions(opt)
+	if err != nil {
+		t.Fatalf("failed to set options: %v", err)
+	}
+
+	if got := lfs.RuntimeOptions(); got != opt {
+		t.Errorf("expected options %+v, got %+v", opt, got)
+	}
+	if len(lfs.cache.entries) != 0 || lfs.cache.enabled() {
+		t.Errorf("expected cache disabled and empty, got %d entries", len(lfs.cache.entries))
+	}
+
+	// 切换到后台刷盘之后新的写入会被周期性刷盘
+	err = lfs.AddSegment(InodeNum("key-03"), *testSegment("key-03", "value"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for lfs.LastSyncedSequence() < lfs.LastSequence() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if lfs.LastSyncedSequence() < lfs.LastSequence() {
+		t.Errorf("expected sync daemon to sync sequence %d, got %d", lfs.LastSequence(), lfs.LastSyncedSequence())
+	}
+
+	opt.GCRatio = 2
+	if err := lfs.SetOptions(opt); err == nil {
+		t.Error("expected invalid gc ratio to be rejected")
+	}
+
+	mustCloseFS(t, lfs)
+
+	if err := lfs.SetOptions(lfs.RuntimeOptions()); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed after close, got %v", err)
+	}
+}
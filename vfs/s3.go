@@ -0,0 +1,249 @@
+package vfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/auula/wiredkv/s3/sigv4"
+)
+
+// S3Backend 把数据文件保存在 S3 兼容的对象存储中，使用 path-style 地址和 AWS Signature V4 签名
+// 例如 Endpoint 为 https://s3.us-east-1.amazonaws.com，对象地址为 Endpoint/Bucket/Prefix+name
+type S3Backend struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+}
+
+type s3File struct {
+	backend *S3Backend
+	name    string
+	size    int64
+}
+
+func (f *s3File) Name() string {
+	return f.name
+}
+
+func (f *s3File) Size() int64 {
+	return f.size
+}
+
+func (f *s3File) Close() error {
+	return nil
+}
+
+// ReadAt 使用 Range 请求只读取需要的部分
+func (f *s3File) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if off >= f.size {
+		return 0, io.EOF
+	}
+
+	req, err := f.backend.newRequest(http.MethodGet, f.name, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1))
+
+	resp, err := f.backend.do(req, sigv4.EmptyPayloadHash)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		return 0, io.EOF
+	}
+	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+		return 0, s3Error(resp)
+	}
+
+	n, err := io.ReadFull(resp.Body, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return n, io.EOF
+	}
+
+	return n, err
+}
+
+func (b *S3Backend) Open(name string) (BackendFile, error) {
+	req, err := b.newRequest(http.MethodHead, name, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.do(req, sigv4.EmptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", ErrBackendFileNotFound, name)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, s3Error(resp)
+	}
+
+	return &s3File{backend: b, name: name, size: resp.ContentLength}, nil
+}
+
+func (b *S3Backend) Put(name string, r io.Reader, size int64) error {
+	// 可以重复读取的数据计算请求体的哈希值，否则使用 UNSIGNED-PAYLOAD
+	payloadHash := sigv4.UnsignedPayload
+	if rs, ok := r.(io.ReadSeeker); ok {
+		h := sha256.New()
+		_, err := io.Copy(h, rs)
+		if err != nil {
+			return err
+		}
+		_, err = rs.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		payloadHash = hex.EncodeToString(h.Sum(nil))
+	}
+
+	req, err := b.newRequest(http.MethodPut, name, nil)
+	if err != nil {
+		return err
+	}
+	req.Body = io.NopCloser(r)
+	req.ContentLength = size
+
+	resp, err := b.do(req, payloadHash)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+
+	return nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (b *S3Backend) List() ([]string, error) {
+	var names []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {b.Prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		req, err := b.newRequest(http.MethodGet, "", query)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := b.do(req, sigv4.EmptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			err := s3Error(resp)
+			resp.Body.Close()
+			return nil, err
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode list objects response: %w", err)
+		}
+
+		for _, object := range result.Contents {
+			names = append(names, strings.TrimPrefix(object.Key, b.Prefix))
+		}
+
+		if !result.IsTruncated {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+func (b *S3Backend) Delete(name string) error {
+	req, err := b.newRequest(http.MethodDelete, name, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := b.do(req, sigv4.EmptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp)
+	}
+
+	return nil
+}
+
+// newRequest 创建访问对象 name 的请求，name 为空时访问 Bucket 本身
+func (b *S3Backend) newRequest(method, name string, query url.Values) (*http.Request, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(b.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %w", err)
+	}
+
+	endpoint.Path += "/" + b.Bucket
+	if name != "" {
+		endpoint.Path += "/" + b.Prefix + name
+	}
+	// 发送的路径和查询参数使用和签名相同的编码
+	endpoint.RawPath = sigv4.URIEncode(endpoint.Path, false)
+	endpoint.RawQuery = sigv4.CanonicalQuery(query)
+
+	return http.NewRequest(method, endpoint.String(), nil)
+}
+
+func (b *S3Backend) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	signer := sigv4.Signer{AccessKey: b.AccessKey, SecretKey: b.SecretKey, Region: b.Region}
+	signer.Sign(req, payloadHash, time.Now())
+
+	client := b.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return client.Do(req)
+}
+
+func s3Error(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("s3 request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
@@ -21,15 +21,29 @@ const (
 	Unknown
 )
 
+// Segment.Flags 中由存储引擎使用的标志位
+const (
+	flagBatch          uint8 = 1 << iota // 批量写入的记录，读到对应的提交记录之后才生效
+	flagBatchCommit                      // 批量写入的提交记录，没有 Key 和 Value
+	flagRangeTombstone                   // 范围删除记录，Key 为起始位置，Value 为结束位置
+	flagEncrypted                        // Value 是加密之后的数据，布局为 | NONCE ? | CIPHERTEXT ? | TAG ? |
+	flagCompressed                       // Value 是压缩之后的数据
+	flagTransform                        // flagEncrypted 和 flagCompressed 有效，没有这个标志位的旧记录按照 Transformer 当前的设置解码
+	flagCodec                            // 压缩数据的第一个字节是压缩算法编号 Codec
+)
+
 // | DEL 1 | KIND 1 | EAT 8 | CAT 8 | KLEN 8 | VLEN 8 | KEY ? | VALUE ? | CRC32 4 |
 type Segment struct {
 	Tombstone int8
 	Type      Kind
+	Flags     uint8  // 只有 FormatV2 及以上的格式版本才会保存
+	UserFlags uint8  // 应用自定义的标志位，只有 FormatV3 及以上的格式版本才会保存
+	Version   uint64 // 写入时由存储引擎分配的单调递增版本号，只有 FormatV4 及以上的格式版本才会保存
 	ExpiredAt uint64
 	CreatedAt uint64
 	KeySize   uint32
 	ValueSize uint32
-	Key       []byte
+	Key       []byte // 任意字节，可以包含 NUL 和不是合法 UTF-8 的字节，数据文件中带有长度前缀
 	Value     []byte
 }
 
@@ -38,27 +52,39 @@ type Serializable interface {
 }
 
 // NewSegment 使用数据类型初始化并返回对应的 Segment
+// key 和存储引擎中其他使用 string 的 Key 一样只是字节序列的容器，不要求是合法的 UTF-8
 func NewSegment(key string, data Serializable, ttl uint64) (*Segment, error) {
 	kind, err := toKind(data)
 	if err != nil {
 		return nil, fmt.Errorf("unsupported data type: %w", err)
 	}
 
+	return NewSegmentBytes(key, kind, data.ToBSON(), ttl)
+}
+
+// NewSegmentBytes 使用已经序列化的数据创建 Segment，和 NewSegment 一样通过 transformer 压缩和加密
+// 用于从其他存储系统导入的数据，调用方需要保证 value 是 kind 对应的编码
+func NewSegmentBytes(key string, kind Kind, value []byte, ttl uint64) (*Segment, error) {
+	err := checkValueSize(kind, int64(len(value)))
+	if err != nil {
+		return nil, err
+	}
+
 	timestamp, expiredAt := uint64(time.Now().Unix()), uint64(0)
 	if ttl > 0 {
 		expiredAt = uint64(time.Now().Add(time.Second * time.Duration(ttl)).Unix())
 	}
 
 	// 这个是通过 transformer 编码之后的
-	encodedata, err := transformer.Encode(data.ToBSON())
+	encodedata, flags, err := transformer.encode(kind, value)
 	if err != nil {
 		return nil, fmt.Errorf("transformer encode: %w", err)
 	}
 
-	// 如果类型不匹配，则返回错误
 	return &Segment{
 		Type:      kind,
 		Tombstone: 0,
+		Flags:     flags,
 		CreatedAt: timestamp,
 		ExpiredAt: expiredAt,
 		KeySize:   uint32(len(key)),
@@ -66,7 +92,6 @@ func NewSegment(key string, data Serializable, ttl uint64) (*Segment, error) {
 		Key:       []byte(key),
 		Value:     encodedata,
 	}, nil
-
 }
 
 func NewTombstoneSegment(key []byte) *Segment {
@@ -77,13 +102,33 @@ func NewTombstoneSegment(key []byte) *Segment {
 	return seg
 }
 
+// NewRangeTombstoneSegment 创建删除 [start, end) 范围内所有 Key 的记录，end 为空表示没有上限
+func NewRangeTombstoneSegment(start, end []byte) *Segment {
+	seg := NewTombstoneSegment(start)
+	seg.Flags = flagRangeTombstone
+	seg.Value = end
+	seg.ValueSize = uint32(len(end))
+	return seg
+}
+
 func (s *Segment) IsTombstone() bool {
 	return s.Tombstone == 1
 }
 
+func (s *Segment) IsRangeTombstone() bool {
+	return s.IsTombstone() && s.Flags&flagRangeTombstone != 0
+}
+
+// Range 返回范围删除记录的起始位置和结束位置
+func (s *Segment) Range() (string, string) {
+	return string(s.Key), string(s.Value)
+}
+
+// Size 计算按照新创建数据文件的格式版本编码之后的记录大小
 func (s *Segment) Size() uint32 {
 	// 计算一整块记录的大小，+4 CRC 校验码占用 4 个字节
-	return 26 + s.KeySize + s.ValueSize + 4
+	hsize, _ := segmentHeaderSize(currentFormat)
+	return uint32(hsize) + s.KeySize + s.ValueSize + 4
 }
 
 func (s *Segment) ToSet() *types.Set {
@@ -0,0 +1,307 @@
+package vfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/auula/wiredkv/clog"
+)
+
+// 索引快照文件头之后保存导出时的写入位置，恢复时只需要重放写入位置之后追加的记录
+// | SIGN 4 | SEQ 8 | REGION 8 | OFFSET 8 | N 4 | REGIONS 8*N | INDEX ... |
+// REGIONS 是写入位置之前已经存在的数据文件编号，恢复时用来发现导出之后新生成的编号更小的数据文件
+
+// snapshotWatermark 是导出索引快照时活跃数据文件的写入位置
+type snapshotWatermark struct {
+	sequence uint64   // 最后分配的版本号
+	regionID uint64   // 活跃数据文件的编号
+	offset   int64    // 活跃数据文件的写入位置
+	regions  []uint64 // 编号小于 regionID 的数据文件，从小到大排序
+}
+
+// captureWatermark 记录当前的写入位置，返回时写入位置之前的记录都已经更新了内存索引
+// 持有 compactMu 等待正在执行的压缩完成，持有 appendMu 等待已经追加的记录更新索引
+func (lfs *LogStructuredFS) captureWatermark() *snapshotWatermark {
+	lfs.compactMu.Lock()
+	defer lfs.compactMu.Unlock()
+	lfs.appendMu.Lock()
+	defer lfs.appendMu.Unlock()
+	lfs.mu.Lock()
+	defer lfs.mu.Unlock()
+
+	w := &snapshotWatermark{
+		sequence: lfs.LastSequence(),
+		regionID: lfs.regionID,
+		offset:   lfs.offset,
+	}
+	for regionID := range lfs.regions {
+		if regionID < w.regionID {
+			w.regions = append(w.regions, regionID)
+		}
+	}
+	for regionID := range lfs.cold {
+		if regionID < w.regionID {
+			w.regions = append(w.regions, regionID)
+		}
+	}
+	sort.Slice(w.regions, func(i, j int) bool {
+		return w.regions[i] < w.regions[j]
+	})
+	return w
+}
+
+func (w *snapshotWatermark) encode() []byte {
+	buf := make([]byte, 0, 28+8*len(w.regions))
+	buf = binary.LittleEndian.AppendUint64(buf, w.sequence)
+	buf = binary.LittleEndian.AppendUint64(buf, w.regionID)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(w.offset))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(w.regions)))
+	for _, regionID := range w.regions {
+		buf = binary.LittleEndian.AppendUint64(buf, regionID)
+	}
+	return buf
+}
+
+// readWatermark 读取索引快照文件头之后的写入位置，返回写入位置和索引开始的位置
+func readWatermark(fd io.ReaderAt, size int64) (*snapshotWatermark, int64, error) {
+	offset := int64(len(indexFileMetadata))
+	var header [28]byte
+	_, err := fd.ReadAt(header[:], offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read index file watermark: %w", err)
+	}
+	offset += int64(len(header))
+
+	w := &snapshotWatermark{
+		sequence: binary.LittleEndian.Uint64(header[0:]),
+		regionID: binary.LittleEndian.Uint64(header[8:]),
+		offset:   int64(binary.LittleEndian.Uint64(header[16:])),
+	}
+
+	n := int64(binary.LittleEndian.Uint32(header[24:]))
+	if n*8 > size-offset {
+		return nil, 0, fmt.Errorf("failed to read index file watermark: %d regions exceeds snapshot size", n)
+	}
+	regions := make([]byte, n*8)
+	_, err = fd.ReadAt(regions, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read index file watermark: %w", err)
+	}
+	offset += int64(len(regions))
+
+	w.regions = make([]uint64, n)
+	for i := range w.regions {
+		w.regions[i] = binary.LittleEndian.Uint64(regions[i*8:])
+	}
+
+	return w, offset, nil
+}
+
+// covers 判断快照是否包含所有编号小于写入位置的数据文件
+// LSM 刷新内存表生成的排序数据文件编号可能小于写入位置，它们只能和其他数据文件一起按照编号的顺序重放
+func (w *snapshotWatermark) covers(sources map[uint64]BackendFile) bool {
+	for regionID := range sources {
+		if regionID >= w.regionID {
+			continue
+		}
+		i := sort.Search(len(w.regions), func(i int) bool { return w.regions[i] >= regionID })
+		if i == len(w.regions) || w.regions[i] != regionID {
+			return false
+		}
+	}
+	return true
+}
+
+// 索引快照损坏或者和数据文件不一致时放弃快照，全局扫描数据文件重建索引
+var (
+	errStaleSnapshot     = errors.New("stale index snapshot")
+	errCorruptedSnapshot = errors.New("corrupted index snapshot")
+)
+
+// recoverySnapshot 是打开数据目录的快速路径，调用方需要持有 lfs.mu：
+// 1. 读取快照中导出时的写入位置，检查快照之后没有生成编号更小的数据文件
+// 2. 按照分片并行加载快照中的索引，丢弃指向已经被压缩删除的数据文件的索引
+// 3. 只重放写入位置之后追加的记录，正常关闭之后写入位置就是活跃数据文件的末尾，不需要重放
+// 快照损坏或者和数据文件不一致时返回 false，调用方需要全局扫描数据文件重建索引
+func (lfs *LogStructuredFS) recoverySnapshot(file *os.File) (bool, error) {
+	ok, err := lfs.loadSnapshot(file)
+	if err == nil {
+		return ok, nil
+	}
+	if !errors.Is(err, errStaleSnapshot) && !errors.Is(err, errCorruptedSnapshot) {
+		return false, err
+	}
+
+	clog.Warnf("failed to load index snapshot %s, rebuilding index from regions: %v", file.Name(), err)
+	for _, imap := range lfs.indexs {
+		imap.swap(newIndexMap(lfs.indexKind, 0))
+	}
+	return false, nil
+}
+
+// loadSnapshot 加载索引快照并重放写入位置之后的记录，快照不能使用时返回 errStaleSnapshot 或者 errCorruptedSnapshot
+func (lfs *LogStructuredFS) loadSnapshot(file *os.File) (bool, error) {
+	finfo, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	w, offset, err := readWatermark(file, finfo.Size())
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", errCorruptedSnapshot, err)
+	}
+
+	sources, err := lfs.regionSources()
+	if err != nil {
+		return false, err
+	}
+	if !w.covers(sources) {
+		return false, fmt.Errorf("%w: regions before region %d are not covered", errStaleSnapshot, w.regionID)
+	}
+	if fd, ok := sources[w.regionID]; ok && w.offset > fd.Size() {
+		return false, fmt.Errorf("%w: region %d is shorter than watermark %d", errStaleSnapshot, w.regionID, w.offset)
+	}
+
+	err = recoveryIndex(file, offset, lfs.indexs)
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", errCorruptedSnapshot, err)
+	}
+
+	err = pruneSnapshot(sources, lfs.indexs)
+	if err != nil {
+		return false, err
+	}
+
+	sequence, torn, err := replayWatermark(w, sources, lfs.versions, lfs.checksums, lfs.indexs, lfs.comparator, lfs.workers)
+	if err != nil {
+		return false, err
+	}
+	lfs.sequence.Store(sequence)
+
+	return true, lfs.truncateTornBatch(torn)
+}
+
+// pruneSnapshot 丢弃指向已经被压缩删除的数据文件的索引，这些数据文件中的有效记录已经迁移到写入位置之后
+// 索引指向的记录超出数据文件的末尾说明数据文件在快照之后被截断或者替换了，快照不能使用
+func pruneSnapshot(sources map[uint64]BackendFile, indexs []*indexMap) error {
+	for _, imap := range indexs {
+		var (
+			stale []uint64
+			err   error
+		)
+		imap.each(func(inum uint64, inode *INode) bool {
+			fd, ok := sources[inode.RegionID]
+			if !ok {
+				stale = append(stale, inum)
+				return true
+			}
+			if inode.Position+int64(inode.Length) > fd.Size() {
+				err = fmt.Errorf("%w: index of %q exceeds region %d", errStaleSnapshot, inode.Key, inode.RegionID)
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		for _, inum := range stale {
+			imap.remove(inum)
+		}
+	}
+	return nil
+}
+
+// replayWatermark 把快照导出之后追加的记录重放到已经从快照恢复的内存索引，返回最大的版本号
+// 和 crashRecoveryAllIndex 一样返回崩溃之前的活跃数据文件末尾没有提交的批量写入
+func replayWatermark(w *snapshotWatermark, sources map[uint64]BackendFile, versions map[uint64]uint8, checksums map[uint64]Checksum, indexs []*indexMap, cmp Comparator, workers int) (uint64, tornBatch, error) {
+	newer := make(map[uint64]BackendFile)
+	tail := true
+	for regionID, fd := range sources {
+		if regionID > w.regionID {
+			newer[regionID] = fd
+			if fd.Size() > int64(len(dataFileMetadata)) {
+				tail = false
+			}
+		}
+	}
+
+	var torn tornBatch
+	sequence := w.sequence
+	if fd, ok := sources[w.regionID]; ok && w.offset < fd.Size() {
+		_, local := fd.(*sealedFile)
+		replayer := &indexReplayer{indexs: indexs, cmp: cmp, tail: tail && local}
+		seq, err := replayRegionFrom(fd, w.regionID, versions[w.regionID], checksums[w.regionID], w.offset, replayer)
+		if err != nil {
+			return 0, tornBatch{}, err
+		}
+		if seq > sequence {
+			sequence = seq
+		}
+		if replayer.torn > 0 {
+			torn = tornBatch{regionID: w.regionID, offset: replayer.torn}
+		}
+	}
+
+	seq, newerTorn, err := crashRecoveryAllIndex(newer, versions, checksums, indexs, cmp, workers)
+	if err != nil {
+		return 0, tornBatch{}, err
+	}
+	if seq > sequence {
+		sequence = seq
+	}
+	if newerTorn.offset > 0 {
+		torn = newerTorn
+	}
+
+	return sequence, torn, nil
+}
+
+// startSnapshotDaemon 每隔 interval 导出一次索引快照，崩溃之后只需要重放最后一次导出之后追加的记录
+func (lfs *LogStructuredFS) startSnapshotDaemon(interval time.Duration) {
+	if interval <= 0 || lfs.inMemory {
+		return
+	}
+
+	lfs.snapshotdone = make(chan struct{})
+	lfs.snapshotexit = make(chan struct{})
+	go func() {
+		defer close(lfs.snapshotexit)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		// 打开时已经删除了恢复使用的快照，第一个周期总是导出
+		exported, first := uint64(0), true
+		for {
+			select {
+			case <-ticker.C:
+				// 没有新的写入时快照和上一次导出的完全一样
+				seq := lfs.LastSequence()
+				if !first && seq == exported {
+					continue
+				}
+				err := lfs.ExportSnapshotIndex()
+				if err != nil {
+					clog.Errorf("failed to export index snapshot: %v", err)
+					continue
+				}
+				exported, first = seq, false
+			case <-lfs.snapshotdone:
+				return
+			}
+		}
+	}()
+}
+
+// stopSnapshotDaemon 停止定期导出索引快照的 goroutine 并且等待它退出
+func (lfs *LogStructuredFS) stopSnapshotDaemon() {
+	if lfs.snapshotdone == nil {
+		return
+	}
+	close(lfs.snapshotdone)
+	<-lfs.snapshotexit
+	lfs.snapshotdone = nil
+}
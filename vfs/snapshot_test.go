@@ -0,0 +1,326 @@
+package vfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPeriodicSnapshot(t *testing.T) {
+	path := t.TempDir()
+	snapshot := filepath.Join(path, indexFileName)
+	opt := &Options{Path: path, FsPerm: fsPerm, Threshold: 1, SnapshotInterval: 10 * time.Millisecond}
+	lfs, err := OpenFS(opt)
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+
+	put := func(key, value string) {
+		t.Helper()
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, value), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		put(fmt.Sprintf("key-%03d", i), "v1")
+	}
+	if err := lfs.ChangeRegions(); err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		put(fmt.Sprintf("key-%03d", i), "v2")
+	}
+
+	// 等待后台导出快照，然后保存一份副本模拟导出之后发生崩溃
+	var exported []byte
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if exported, err = os.ReadFile(snapshot); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("expected periodic index snapshot: %v", err)
+	}
+	lfs.stopSnapshotDaemon()
+
+	// 快照之后的覆盖、删除、范围删除和压缩都需要重放
+	for i := 10; i < 30; i++ {
+		put(fmt.Sprintf("key-%03d", i), "v3")
+	}
+	if err := lfs.DelSegment("key-050"); err != nil {
+		t.Fatalf("failed to delete segment: %v", err)
+	}
+	if err := lfs.DeletePrefix("key-09"); err != nil {
+		t.Fatalf("failed to delete prefix: %v", err)
+	}
+	if err := lfs.ChangeRegions(); err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+	if err := lfs.compactRegion(1); err != nil {
+		t.Fatalf("failed to compact region: %v", err)
+	}
+	put("key-100", "v4")
+	mustCloseFS(t, lfs)
+
+	index := func(opt *Options) (map[uint64]INode, uint64) {
+		t.Helper()
+		lfs, err := OpenFS(opt)
+		if err != nil {
+			t.Fatalf("failed to reopen fs: %v", err)
+		}
+		defer mustCloseFS(t, lfs)
+		indexs := make(map[uint64]INode)
+		for _, imap := range lfs.indexs {
+			imap.each(func(inum uint64, inode *INode) bool {
+				indexs[inum] = *inode
+				return true
+			})
+		}
+		return indexs, lfs.LastSequence()
+	}
+
+	err = os.WriteFile(snapshot, exported, fsPerm)
+	if err != nil {
+		t.Fatalf("failed to restore snapshot: %v", err)
+	}
+	got, sequence := index(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+
+	os.Remove(snapshot)
+	want, expected := index(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+
+	if len(got) != len(want) || sequence != expected {
+		t.Fatalf("expected %d indexes with sequence %d, got %d with sequence %d", len(want), expected, len(got), sequence)
+	}
+	for inum, inode := range want {
+		if got[inum] != inode {
+			t.Errorf("expected %+v, got %+v", inode, got[inum])
+		}
+	}
+}
+
+func TestSnapshotWatermark(t *testing.T) {
+	w := &snapshotWatermark{sequence: 7, regionID: 5, offset: 128, regions: []uint64{1, 3, 4}}
+	data := append(append([]byte(nil), indexFileMetadata...), w.encode()...)
+	file := filepath.Join(t.TempDir(), indexFileName)
+	if err := os.WriteFile(file, data, fsPerm); err != nil {
+		t.Fatalf("failed to write snapshot: %v", err)
+	}
+	fd, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("failed to open snapshot: %v", err)
+	}
+	defer fd.Close()
+
+	decoded, offset, err := readWatermark(fd, int64(len(data)))
+	if err != nil || offset != int64(len(data)) {
+		t.Fatalf("failed to read watermark: %v (offset %d)", err, offset)
+	}
+	if decoded.sequence != 7 || decoded.regionID != 5 || decoded.offset != 128 || len(decoded.regions) != 3 {
+		t.Errorf("unexpected watermark: %+v", decoded)
+	}
+
+	// 快照之后压缩删除的数据文件和写入位置之后的数据文件不影响快照的使用
+	if !decoded.covers(map[uint64]BackendFile{1: nil, 4: nil, 5: nil, 6: nil}) {
+		t.Error("expected watermark to cover regions")
+	}
+	// 快照之后生成的编号更小的数据文件需要全局扫描重建索引
+	if decoded.covers(map[uint64]BackendFile{1: nil, 2: nil, 5: nil}) {
+		t.Error("expected watermark not to cover region 2")
+	}
+}
+
+func TestSnapshotFallback(t *testing.T) {
+	path := t.TempDir()
+	snapshot := filepath.Join(path, indexFileName)
+	opt := &Options{Path: path, FsPerm: fsPerm, Threshold: 1}
+
+	lfs, err := OpenFS(opt)
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%03d", i)
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value"), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+	inode, _ := lfs.GetINode(InodeNum("key-050"))
+	mustCloseFS(t, lfs)
+
+	count := func() int {
+		t.Helper()
+		lfs, err := OpenFS(opt)
+		if err != nil {
+			t.Fatalf("failed to reopen fs: %v", err)
+		}
+		defer mustCloseFS(t, lfs)
+		for _, key := range lfs.Keys("*") {
+			if _, err := lfs.FetchSegment(InodeNum(key)); err != nil {
+				t.Errorf("failed to fetch %s: %v", key, err)
+			}
+		}
+		return lfs.Count()
+	}
+
+	// 快照中的索引损坏时全局扫描数据文件
+	data, err := os.ReadFile(snapshot)
+	if err != nil {
+		t.Fatalf("failed to read snapshot: %v", err)
+	}
+	data[len(data)-10] ^= 0xff
+	if err := os.WriteFile(snapshot, data, fsPerm); err != nil {
+		t.Fatalf("failed to corrupt snapshot: %v", err)
+	}
+	if n := count(); n != 100 {
+		t.Errorf("expected 100 keys after corrupted snapshot, got %d", n)
+	}
+
+	// 数据文件在快照之后被截断，快照中的索引指向不存在的记录
+	err = os.Truncate(filepath.Join(path, formatDataFileName(1)), inode.Position)
+	if err != nil {
+		t.Fatalf("failed to truncate region: %v", err)
+	}
+	if n := count(); n != 50 {
+		t.Errorf("expected 50 keys after stale snapshot, got %d", n)
+	}
+}
+
+func BenchmarkOpenSnapshot(b *testing.B) {
+	for _, keys := range []int{100000, 1000000} {
+		b.Run(fmt.Sprintf("keys=%d", keys), func(b *testing.B) {
+			opt := &Options{Path: b.TempDir(), FsPerm: fsPerm, Threshold: 1}
+			lfs, err := OpenFS(opt)
+			if err != nil {
+				b.Fatalf("failed to open fs: %v", err)
+			}
+			for i := 0; i < keys; i += 10000 {
+				err = lfs.Batch(func(batch *Batch) error {
+					for j := i; j < i+10000 && j < keys; j++ {
+						key := fmt.Sprintf("user:%08d", j)
+						batch.AddSegment(InodeNum(key), *testSegment(key, "v"))
+					}
+					return nil
+				})
+				if err != nil {
+					b.Fatalf("failed to commit batch: %v", err)
+				}
+			}
+			if err := lfs.CloseFS(); err != nil {
+				b.Fatalf("failed to close fs: %v", err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				lfs, err := OpenFS(opt)
+				if err != nil {
+					b.Fatalf("failed to reopen fs: %v", err)
+				}
+				b.StopTimer()
+				if lfs.Count() != keys {
+					b.Fatalf("expected %d keys, got %d", keys, lfs.Count())
+				}
+				if err := lfs.CloseFS(); err != nil {
+					b.Fatalf("failed to close fs: %v", err)
+				}
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func TestCompressedMetadata(t *testing.T) {
+	saved := *transformer
+	defer func() { *transformer = saved }()
+
+	write := func(path string) map[uint64]INode {
+		t.Helper()
+		lfs, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+		if err != nil {
+			t.Fatalf("failed to open fs: %v", err)
+		}
+		for i := 0; i < 2000; i++ {
+			key := fmt.Sprintf("user:profile:%08d", i)
+			err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value"), 0)
+			if err != nil {
+				t.Fatalf("failed to add segment: %v", err)
+			}
+			if i == 999 {
+				if err := lfs.ChangeRegions(); err != nil {
+					t.Fatalf("failed to change regions: %v", err)
+				}
+			}
+		}
+		indexs := make(map[uint64]INode)
+		for _, imap := range lfs.indexs {
+			imap.each(func(inum uint64, inode *INode) bool {
+				indexs[inum] = *inode
+				return true
+			})
+		}
+		mustCloseFS(t, lfs)
+		return indexs
+	}
+
+	size := func(path, pattern string) int64 {
+		t.Helper()
+		matches, _ := filepath.Glob(filepath.Join(path, pattern))
+		if len(matches) == 0 {
+			t.Fatalf("expected %s in %s", pattern, path)
+		}
+		var total int64
+		for _, name := range matches {
+			finfo, err := os.Stat(name)
+			if err != nil {
+				t.Fatalf("failed to stat %s: %v", name, err)
+			}
+			total += finfo.Size()
+		}
+		return total
+	}
+
+	raw := t.TempDir()
+	write(raw)
+
+	compressed := t.TempDir()
+	transformer.SetCompressor(SnappyCompressor)
+	want := write(compressed)
+
+	if size(compressed, indexFileName) >= size(raw, indexFileName) {
+		t.Errorf("expected compressed index snapshot to be smaller than %d bytes", size(raw, indexFileName))
+	}
+	if size(compressed, "*"+hintExtension) >= size(raw, "*"+hintExtension) {
+		t.Errorf("expected compressed hint files to be smaller than %d bytes", size(raw, "*"+hintExtension))
+	}
+
+	// 内置压缩算法的编号保存在文件中，关闭压缩之后仍然可以读取
+	transformer.DisableCompression()
+	transformer.Compressor = nil
+	reopen := func() {
+		t.Helper()
+		lfs, err := OpenFS(&Options{Path: compressed, FsPerm: fsPerm, Threshold: 1})
+		if err != nil {
+			t.Fatalf("failed to reopen fs: %v", err)
+		}
+		defer mustCloseFS(t, lfs)
+		if lfs.Count() != len(want) {
+			t.Errorf("expected %d keys, got %d", len(want), lfs.Count())
+		}
+		for _, imap := range lfs.indexs {
+			imap.each(func(inum uint64, inode *INode) bool {
+				if want[inum] != *inode {
+					t.Errorf("expected %+v, got %+v", want[inum], *inode)
+				}
+				return true
+			})
+		}
+	}
+	reopen()
+
+	// 删除索引快照之后从压缩的 hint 文件重建索引
+	os.Remove(filepath.Join(compressed, indexFileName))
+	reopen()
+}
@@ -0,0 +1,656 @@
+package vfs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/auula/wiredkv/clog"
+	"github.com/auula/wiredkv/utils"
+)
+
+// 排序数据文件的稀疏索引每隔多少字节记录一个块，范围遍历每次顺序读取一个块
+const sortedBlockSize = int64(64 * KB)
+
+// 排序数据文件的稀疏索引文件扩展名，例如 00000003.sparse
+// | SIGN 4 | CODEC 1 | CRYPT 1 | SIZE 8 | LEVEL 1 | LKLEN 4 | LAST KEY ? | COUNT 4 | OFFSET 8 | KLEN 4 | KEY ? | ... | CRC32 4 |
+// CRYPT 和 CRC32 之间的内容使用 Transformer 当前的压缩和加密设置整体压缩和加密，CRC32 覆盖压缩和加密之后的数据
+const sparseExtension = ".sparse"
+
+// 稀疏索引文件头，第三个字节和数据文件以及 hint 文件不同，最后一个字节为稀疏索引文件的格式版本
+// 版本 2 增加了 LSM 模式使用的层级和最后一个 Key，版本 3 增加了 CODEC 和 CRYPT，更旧版本的稀疏索引文件会被忽略
+var sparseFileMetadata = []byte{0xDB, 0x0, 0x2, 0x3}
+
+// sparseBlock 是排序数据文件中一个块的第一个 Key 和它的位置
+type sparseBlock struct {
+	Key    string
+	Offset int64
+}
+
+// sparseIndex 是排序数据文件的稀疏块索引，块按照 Key 和位置同时有序
+type sparseIndex struct {
+	blocks []sparseBlock
+	size   int64  // 数据文件的大小，也就是最后一个块结束的位置
+	level  int    // LSM 模式中数据文件所在的层级，其他情况为 0
+	last   string // 数据文件中最大的 Key
+}
+
+// overlaps 判断两个排序数据文件的 Key 范围是否有交集
+func (idx *sparseIndex) overlaps(other *sparseIndex, cmp Comparator) bool {
+	if len(idx.blocks) == 0 || len(other.blocks) == 0 {
+		return false
+	}
+	return compareKeys(cmp, idx.blocks[0].Key, other.last) <= 0 && compareKeys(cmp, other.blocks[0].Key, idx.last) <= 0
+}
+
+// block 返回包含 position 的块的开始和结束位置
+func (idx *sparseIndex) block(position int64) (int64, int64) {
+	i := sort.Search(len(idx.blocks), func(i int) bool {
+		return idx.blocks[i].Offset > position
+	})
+	if i == 0 {
+		return 0, 0
+	}
+	if i == len(idx.blocks) {
+		return idx.blocks[i-1].Offset, idx.size
+	}
+	return idx.blocks[i-1].Offset, idx.blocks[i].Offset
+}
+
+// sparseFileName 返回数据文件对应的稀疏索引文件路径
+func sparseFileName(regionName string) string {
+	return strings.TrimSuffix(regionName, fileExtension) + sparseExtension
+}
+
+// loadSparseIndex 读取数据文件的稀疏索引文件，不是排序数据文件时返回 os.ErrNotExist
+func loadSparseIndex(regionName string) (*sparseIndex, error) {
+	data, err := os.ReadFile(sparseFileName(regionName))
+	if err != nil {
+		return nil, err
+	}
+
+	sign := len(sparseFileMetadata) - 1
+	if len(data) < len(sparseFileMetadata)+4 || !bytes.Equal(data[:sign], sparseFileMetadata[:sign]) {
+		return nil, errors.New("invalid sparse index file signature")
+	}
+	if binary.LittleEndian.Uint32(data[len(data)-4:]) != crc32.ChecksumIEEE(data[:len(data)-4]) {
+		return nil, errors.New("failed to sparse index file crc32 checksum mismatch")
+	}
+
+	body := data[len(sparseFileMetadata) : len(data)-4]
+	switch version := data[sign]; version {
+	case 2:
+	case sparseFileMetadata[sign]:
+		body, err = transformer.decodeMetadata(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode sparse index file: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported sparse index file version %d", version)
+	}
+
+	if len(body) < 13 {
+		return nil, errors.New("invalid sparse index file size")
+	}
+	idx := &sparseIndex{size: int64(binary.LittleEndian.Uint64(body)), level: int(body[8])}
+	klen := int(binary.LittleEndian.Uint32(body[9:]))
+	pos := 13
+	if klen > len(body)-pos-4 {
+		return nil, fmt.Errorf("invalid sparse index last key length %d", klen)
+	}
+	idx.last = string(body[pos : pos+klen])
+	pos += klen
+	count := int(binary.LittleEndian.Uint32(body[pos:]))
+	pos += 4
+
+	for pos < len(body) {
+		if len(body)-pos < 12 {
+			return nil, fmt.Errorf("invalid sparse index block at offset %d", pos)
+		}
+		offset := int64(binary.LittleEndian.Uint64(body[pos:]))
+		klen := int(binary.LittleEndian.Uint32(body[pos+8:]))
+		pos += 12
+		if klen > len(body)-pos {
+			return nil, fmt.Errorf("invalid sparse index key length %d at offset %d", klen, pos)
+		}
+		idx.blocks = append(idx.blocks, sparseBlock{Key: string(body[pos : pos+klen]), Offset: offset})
+		pos += klen
+	}
+	if len(idx.blocks) != count || count == 0 {
+		return nil, fmt.Errorf("invalid sparse index block count: %d/%d", len(idx.blocks), count)
+	}
+
+	return idx, nil
+}
+
+// saveSparseIndex 先写入临时文件再重命名，保证稀疏索引文件不会只写入一半
+func saveSparseIndex(regionName string, idx *sparseIndex, perm fs.FileMode) error {
+	var buf []byte
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(idx.size))
+	buf = append(buf, byte(idx.level))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(idx.last)))
+	buf = append(buf, idx.last...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(idx.blocks)))
+	for _, block := range idx.blocks {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(block.Offset))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(block.Key)))
+		buf = append(buf, block.Key...)
+	}
+
+	body, err := transformer.encodeMetadata(buf)
+	if err != nil {
+		return err
+	}
+	buf = append(append([]byte(nil), sparseFileMetadata...), body...)
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+
+	path := sparseFileName(regionName)
+	tmp, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(buf)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := utils.CloseFile(tmp); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(path + ".tmp")
+		return fmt.Errorf("failed to write sparse index file: %w", err)
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// removeSparseIndex 删除数据文件对应的稀疏索引文件
+func removeSparseIndex(regionName string) error {
+	err := os.Remove(sparseFileName(regionName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// regionSparseIndex 返回排序数据文件的稀疏索引，第一次使用时从磁盘读取，不是排序数据文件时返回 nil
+func (lfs *LogStructuredFS) regionSparseIndex(regionID uint64) *sparseIndex {
+	lfs.mu.Lock()
+	idx, ok := lfs.sparse[regionID]
+	lfs.mu.Unlock()
+	if ok {
+		return idx
+	}
+
+	idx, err := loadSparseIndex(filepath.Join(lfs.directory, formatDataFileName(regionID)))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			clog.Warnf("failed to load sparse index of region %d: %v", regionID, err)
+		}
+		idx = nil
+	}
+
+	lfs.mu.Lock()
+	lfs.sparse[regionID] = idx
+	lfs.mu.Unlock()
+
+	return idx
+}
+
+// sortedBlock 是从排序数据文件中一次读取的一个块，范围遍历时相邻的 Key 直接从块中解析
+type sortedBlock struct {
+	regionID uint64
+	offset   int64
+	data     []byte
+	version  uint8
+	checksum Checksum
+}
+
+// ReadAt 按照数据文件中的位置读取块中的数据，超出块的范围时返回 io.EOF
+func (b *sortedBlock) ReadAt(p []byte, off int64) (int, error) {
+	if off < b.offset || off-b.offset >= int64(len(b.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, b.data[off-b.offset:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// contains 判断 inode 引用的记录是否完整地在块中
+func (b *sortedBlock) contains(inode *INode) bool {
+	return inode.RegionID == b.regionID && inode.Position >= b.offset &&
+		inode.Position+int64(inode.Length) <= b.offset+int64(len(b.data))
+}
+
+// readSortedBlock 读取排序数据文件中包含 inode 的块，不是排序数据文件时返回 nil
+func (lfs *LogStructuredFS) readSortedBlock(inode *INode) (*sortedBlock, error) {
+	idx := lfs.regionSparseIndex(inode.RegionID)
+	if idx == nil {
+		return nil, nil
+	}
+
+	start, end := idx.block(inode.Position)
+	if end <= start {
+		return nil, nil
+	}
+
+	fd, version, checksum, ok := lfs.regionFile(inode.RegionID)
+	if !ok {
+		return nil, fmt.Errorf("region file not found for region id: %d", inode.RegionID)
+	}
+
+	block := &sortedBlock{regionID: inode.RegionID, offset: start, data: make([]byte, end-start), version: version, checksum: checksum}
+	n, err := fd.ReadAt(block.data, start)
+	if err != nil && !(errors.Is(err, io.EOF) && n == len(block.data)) {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// sortedSource 是排序压缩正在合并的一个数据文件
+type sortedSource struct {
+	fd       vfsFile
+	version  uint8
+	checksum Checksum
+	size     int64
+	record   CompactionRecord
+}
+
+// sortedRecord 是排序压缩扫描到的一条有效记录
+type sortedRecord struct {
+	inum  uint64
+	inode *INode
+	src   *sortedSource
+}
+
+// sortedFile 是排序压缩写入的一个新数据文件，olds 是每条记录在原来数据文件中的索引
+type sortedFile struct {
+	*ingestFile
+	olds  []*INode
+	index sparseIndex
+}
+
+// compactSorted 把 regionIds 中的有效记录按照 Key 排序写入新的非活跃数据文件，并且为每个数据文件生成稀疏块索引和 hint 文件
+// 新的数据文件超过阀值时会继续写入下一个数据文件，它们的 Key 范围依次递增，编号排在当前的活跃数据文件之后
+// 排序期间被更新或者删除的 Key 会在新的活跃数据文件中再写一次，保证崩溃恢复时不会被排序数据文件中的旧记录覆盖
+// level 是新数据文件在 LSM 模式中的层级
+func (lfs *LogStructuredFS) compactSorted(ctx context.Context, regionIds []uint64, level int) error {
+	if lfs.readOnly {
+		return ErrReadOnly
+	}
+	if lfs.inMemory {
+		return errors.New("failed to sort regions: in-memory file system does not support sorted regions")
+	}
+
+	lfs.compactMu.Lock()
+	defer lfs.compactMu.Unlock()
+
+	var (
+		sources []*sortedSource
+		live    []sortedRecord
+	)
+	seen := make(map[uint64]bool, len(regionIds))
+	for _, regionID := range regionIds {
+		if seen[regionID] {
+			continue
+		}
+		seen[regionID] = true
+
+		src, records, err := lfs.scanSortedRegion(ctx, regionID)
+		if err != nil {
+			return fmt.Errorf("failed to compact region %d: %w", regionID, err)
+		}
+		sources = append(sources, src)
+		live = append(live, records...)
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+
+	sort.Slice(live, func(i, j int) bool {
+		return lfs.comparator.Compare(live[i].inode.Key, live[j].inode.Key) < 0
+	})
+
+	files, err := lfs.writeSortedRegions(ctx, live, level)
+	defer func() {
+		for _, file := range files {
+			if file.tmp == "" {
+				continue
+			}
+			if file.fd != nil {
+				_ = file.fd.Close()
+			}
+			_ = os.Remove(file.tmp)
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	if len(files) > 0 {
+		err = lfs.commitSorted(files)
+		if err != nil {
+			return err
+		}
+	}
+
+	return lfs.removeSortedSources(sources)
+}
+
+// scanSortedRegion 扫描排序压缩的一个数据文件，删除记录和 compactRegion 一样迁移到活跃数据文件，返回所有有效的记录
+func (lfs *LogStructuredFS) scanSortedRegion(ctx context.Context, regionID uint64) (*sortedSource, []sortedRecord, error) {
+	lfs.mu.Lock()
+	fd, ok := lfs.regions[regionID]
+	src := &sortedSource{fd: fd, version: lfs.versions[regionID], checksum: lfs.checksums[regionID]}
+	oldest := lfs.isOldestRegion(regionID)
+	lfs.mu.Unlock()
+	if !ok || regionID == lfs.activeRegionID() {
+		return nil, nil, fmt.Errorf("region %d is not a sealed region", regionID)
+	}
+
+	finfo, err := fd.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	src.size = finfo.Size()
+
+	holes, err := fileHoles(fd)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load region holes: %w", err)
+	}
+
+	if lfs.paranoid {
+		lfs.verifyRegionIndex(regionID, fd, src.version, src.checksum, holes)
+	}
+
+	src.record = CompactionRecord{RegionID: regionID, StartedAt: time.Now(), BytesRead: src.size}
+	lfs.compaction.begin(regionID, src.size)
+	defer lfs.compaction.end()
+
+	var records []sortedRecord
+	offset := int64(len(dataFileMetadata))
+	for offset < src.size {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		lfs.compaction.progress(offset)
+		if err := lfs.compaction.wait(ctx); err != nil {
+			return nil, nil, err
+		}
+
+		if end, ok := holes[offset]; ok {
+			offset = end
+			continue
+		}
+
+		inum, segment, length, err := readRawSegment(fd, offset, src.version, src.checksum)
+		if err != nil {
+			return nil, nil, err
+		}
+		position := offset
+		offset += length
+
+		if segment.Flags&flagBatchCommit != 0 {
+			src.record.Dropped.Commits++
+			continue
+		}
+
+		if segment.IsTombstone() {
+			if !oldest {
+				err := lfs.moveTombstone(inum, segment)
+				if err != nil {
+					return nil, nil, err
+				}
+			} else {
+				src.record.Dropped.Tombstones++
+			}
+			continue
+		}
+
+		inode, ok := lfs.GetINode(inum)
+		if !ok || inode.RegionID != regionID || inode.Position != position {
+			src.record.Dropped.Overwritten++
+			continue
+		}
+
+		if oldest && isExpired(inode.ExpiredAt) {
+			lfs.dropExpired(inum, inode)
+			src.record.Dropped.Expired++
+			continue
+		}
+
+		records = append(records, sortedRecord{inum: inum, inode: inode, src: src})
+		src.record.RecordsMoved++
+		src.record.BytesWritten += length
+	}
+
+	return src, records, nil
+}
+
+// writeSortedRegions 按照顺序把记录写入临时数据文件，每写入 sortedBlockSize 字节开始一个新的块
+func (lfs *LogStructuredFS) writeSortedRegions(ctx context.Context, live []sortedRecord, level int) ([]*sortedFile, error) {
+	var (
+		files   []*sortedFile
+		current *sortedFile
+		w       *bufio.Writer
+		data    []byte // 每条记录都序列化到同一个缓冲区中，bufio.Writer 写入时会复制
+	)
+
+	for _, record := range live {
+		if err := ctx.Err(); err != nil {
+			return files, err
+		}
+
+		_, segment, _, err := readRawSegment(record.src.fd, record.inode.Position, record.src.version, record.src.checksum)
+		if err != nil {
+			return files, err
+		}
+		segment.Flags &^= flagBatch
+
+		data, err = appendEncodedSegment(data[:0], segment, currentFormat, lfs.checksum)
+		if err != nil {
+			return files, err
+		}
+
+		if current == nil || current.size+int64(len(data)) > regionThreshold {
+			if w != nil {
+				if err := w.Flush(); err != nil {
+					return files, fmt.Errorf("failed to write sorted region: %w", err)
+				}
+			}
+			file, err := lfs.createIngestFile("sorted", len(files))
+			if err != nil {
+				return files, err
+			}
+			current = &sortedFile{ingestFile: file, index: sparseIndex{level: level}}
+			files = append(files, current)
+			w = bufio.NewWriterSize(file.fd, int(sortedBlockSize))
+		}
+
+		blocks := current.index.blocks
+		if len(blocks) == 0 || current.size-blocks[len(blocks)-1].Offset >= sortedBlockSize {
+			current.index.blocks = append(blocks, sparseBlock{Key: record.inode.Key, Offset: current.size})
+		}
+
+		current.inums = append(current.inums, record.inum)
+		current.inodes = append(current.inodes, &INode{
+			Position:  current.size,
+			Length:    uint32(len(data)),
+			CreatedAt: segment.CreatedAt,
+			ExpiredAt: segment.ExpiredAt,
+			Version:   segment.Version,
+			Key:       record.inode.Key,
+		})
+		current.olds = append(current.olds, record.inode)
+		current.index.last = record.inode.Key
+
+		if _, err := w.Write(data); err != nil {
+			return files, fmt.Errorf("failed to write sorted region: %w", err)
+		}
+		current.size += int64(len(data))
+	}
+
+	if w != nil {
+		if err := w.Flush(); err != nil {
+			return files, fmt.Errorf("failed to write sorted region: %w", err)
+		}
+	}
+
+	for _, file := range files {
+		file.index.size = file.size
+		err := closeFile(file.fd)
+		file.fd = nil
+		if err != nil {
+			return files, fmt.Errorf("failed to close sorted region: %w", err)
+		}
+	}
+
+	return files, nil
+}
+
+// commitSorted 加入排序数据文件，然后把索引中仍然引用原来位置的记录指向排序数据文件
+func (lfs *LogStructuredFS) commitSorted(files []*sortedFile) error {
+	pending := make([]*ingestFile, len(files))
+	for i, file := range files {
+		pending[i] = file.ingestFile
+	}
+
+	regions, err := lfs.commitRegions(pending)
+	if err != nil {
+		return err
+	}
+
+	last := regions[len(regions)-1]
+	for _, file := range files {
+		for i, inum := range file.inums {
+			inode, old := file.inodes[i], file.olds[i]
+			shard := lfs.indexs[inum%uint64(indexShard)]
+			shard.mu.Lock()
+			current, ok := shard.get(inum)
+			if ok && sameINode(current, old) {
+				shard.set(inum, inode)
+				shard.mu.Unlock()
+				continue
+			}
+			if !ok {
+				current = nil
+			}
+			err := lfs.reorderSorted(shard, inum, inode.Key, current, last)
+			shard.mu.Unlock()
+			if err != nil {
+				return err
+			}
+			lfs.markDead(inode)
+		}
+
+		err := saveSparseIndex(file.fd.Name(), &file.index, lfs.fsPerm)
+		if err != nil {
+			clog.Warnf("failed to write sparse index for region %d: %v", file.inodes[0].RegionID, err)
+		} else {
+			lfs.mu.Lock()
+			lfs.sparse[file.inodes[0].RegionID] = &file.index
+			lfs.mu.Unlock()
+		}
+		file.saveHint(lfs.fsPerm)
+	}
+
+	return nil
+}
+
+// reorderSorted 处理排序期间被更新或者删除的 Key，调用方需要持有 shard 的锁
+// 新的记录在排序数据文件之前时，崩溃恢复会先重放新的记录再重放排序数据文件中的旧记录，
+// 所以需要把新的记录或者删除记录再写入活跃数据文件一次
+func (lfs *LogStructuredFS) reorderSorted(shard *indexMap, inum uint64, key string, current *INode, last uint64) error {
+	if current == nil {
+		inode, err := lfs.appendSegment(NewTombstoneSegment([]byte(key)))
+		if err != nil {
+			return err
+		}
+		lfs.markDead(inode)
+		return nil
+	}
+	if current.RegionID > last {
+		return nil
+	}
+
+	fd, version, checksum, ok := lfs.regionFile(current.RegionID)
+	if !ok {
+		return fmt.Errorf("region file not found for region id: %d", current.RegionID)
+	}
+	_, segment, _, err := readRawSegment(fd, current.Position, version, checksum)
+	if err != nil {
+		return err
+	}
+	segment.Flags &^= flagBatch
+
+	moved, err := lfs.appendSegment(segment)
+	if err != nil {
+		return err
+	}
+	shard.set(inum, moved)
+	lfs.markDead(current)
+
+	return nil
+}
+
+// removeSortedSources 删除已经合并到排序数据文件中的原数据文件
+func (lfs *LogStructuredFS) removeSortedSources(sources []*sortedSource) error {
+	for _, src := range sources {
+		// 没有任何有效数据的数据文件在删除之前先归档
+		if lfs.archive != nil && src.record.RecordsMoved == 0 {
+			err := lfs.archiveRegion(src.fd, src.size)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	lfs.mu.Lock()
+	defer lfs.mu.Unlock()
+
+	err := lfs.syncActive()
+	if err != nil {
+		return fmt.Errorf("failed to sync active migrate region: %w", err)
+	}
+
+	for _, src := range sources {
+		regionID := src.record.RegionID
+		delete(lfs.regions, regionID)
+		delete(lfs.usage, regionID)
+		delete(lfs.versions, regionID)
+		delete(lfs.checksums, regionID)
+		delete(lfs.accessed, regionID)
+		delete(lfs.sparse, regionID)
+
+		err := src.fd.Close()
+		if err != nil {
+			return fmt.Errorf("failed to close compacted region: %w", err)
+		}
+
+		err = removeRegionFile(src.fd)
+		if err != nil {
+			return err
+		}
+
+		src.record.Duration = time.Since(src.record.StartedAt)
+		src.record.BytesReclaimed = src.record.BytesRead - src.record.BytesWritten
+		lfs.history.add(src.record)
+	}
+
+	return nil
+}
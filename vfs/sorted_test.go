@@ -0,0 +1,190 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/auula/wiredkv/utils"
+)
+
+func TestCompactSorted(t *testing.T) {
+	path := t.TempDir()
+	lfs, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+
+	value := strings.Repeat("v", 1024)
+	keys := make([]string, 200)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%03d", i)
+	}
+	rand.New(rand.NewSource(1)).Shuffle(len(keys), func(i, j int) {
+		keys[i], keys[j] = keys[j], keys[i]
+	})
+
+	for i, key := range keys {
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, value), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+		if i == len(keys)/2 {
+			err = lfs.ChangeRegions()
+			if err != nil {
+				t.Fatalf("failed to change regions: %v", err)
+			}
+		}
+	}
+	err = lfs.AddSegment(InodeNum("key-000"), *testSegment("key-000", "updated"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+	err = lfs.DelSegment("key-001")
+	if err != nil {
+		t.Fatalf("failed to delete segment: %v", err)
+	}
+	err = lfs.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+
+	err = lfs.Compact(context.Background(), CompactOptions{FileIDs: []uint64{1, 2}, Sorted: true})
+	if err != nil {
+		t.Fatalf("failed to compact sorted: %v", err)
+	}
+	for _, id := range []uint64{1, 2} {
+		if _, ok := lfs.regions[id]; ok {
+			t.Errorf("expected region %d to be removed", id)
+		}
+	}
+
+	inode, ok := lfs.GetINode(InodeNum("key-002"))
+	if !ok {
+		t.Fatalf("expected key-002 in index")
+	}
+	sorted := inode.RegionID
+	name := filepath.Join(path, formatDataFileName(sorted))
+	if !utils.IsExist(sparseFileName(name)) || !utils.IsExist(hintFileName(name)) {
+		t.Fatalf("expected sparse index and hint file for sorted region %d", sorted)
+	}
+	mustCloseFS(t, lfs)
+
+	lfs, err = OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to reopen fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	idx := lfs.regionSparseIndex(sorted)
+	if idx == nil || len(idx.blocks) < 2 {
+		t.Fatalf("expected sparse index with multiple blocks, got %+v", idx)
+	}
+	for i := 1; i < len(idx.blocks); i++ {
+		if idx.blocks[i].Key <= idx.blocks[i-1].Key || idx.blocks[i].Offset <= idx.blocks[i-1].Offset {
+			t.Errorf("expected blocks ordered by key and offset, got %+v", idx.blocks[i-1:i+1])
+		}
+	}
+
+	// 排序数据文件中的记录按照 Key 的顺序排列
+	var last int64
+	for i := 2; i < 200; i++ {
+		inode, ok := lfs.GetINode(InodeNum(fmt.Sprintf("key-%03d", i)))
+		if !ok || inode.RegionID != sorted || inode.Position <= last {
+			t.Fatalf("expected key-%03d after offset %d in region %d, got %+v", i, last, sorted, inode)
+		}
+		last = inode.Position
+	}
+
+	it := lfs.NewIterator(context.Background(), "key-")
+	defer it.Close()
+	count := 0
+	for it.Next() {
+		expected := value
+		if it.Key() == "key-000" {
+			expected = "updated"
+		}
+		if string(it.Segment().Value) != expected {
+			t.Errorf("unexpected value for %s", it.Key())
+		}
+		count++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("failed to iterate: %v", err)
+	}
+	if count != 199 {
+		t.Errorf("expected 199 records, got %d", count)
+	}
+}
+
+func TestCompactSortedConcurrentUpdate(t *testing.T) {
+	path := t.TempDir()
+	lfs, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+
+	for _, key := range []string{"key-01", "key-02"} {
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, "old"), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+	err = lfs.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+
+	_, records, err := lfs.scanSortedRegion(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("failed to scan region: %v", err)
+	}
+	files, err := lfs.writeSortedRegions(context.Background(), records, 0)
+	if err != nil {
+		t.Fatalf("failed to write sorted regions: %v", err)
+	}
+
+	// 写入排序数据文件之后提交之前更新和删除的 Key，新的记录在排序数据文件之前
+	err = lfs.AddSegment(InodeNum("key-01"), *testSegment("key-01", "new"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+	err = lfs.DelSegment("key-02")
+	if err != nil {
+		t.Fatalf("failed to delete segment: %v", err)
+	}
+
+	err = lfs.commitSorted(files)
+	if err != nil {
+		t.Fatalf("failed to commit sorted regions: %v", err)
+	}
+	mustCloseFS(t, lfs)
+
+	// 删除索引快照，重新打开时重放所有数据文件
+	err = os.Remove(filepath.Join(path, indexFileName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("failed to remove index snapshot: %v", err)
+	}
+	lfs, err = OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to reopen fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	seg, err := lfs.FetchSegment(InodeNum("key-01"))
+	if err != nil {
+		t.Fatalf("failed to fetch key-01: %v", err)
+	}
+	if string(seg.Value) != "new" {
+		t.Errorf("expected new, got %s", seg.Value)
+	}
+	_, err = lfs.FetchSegment(InodeNum("key-02"))
+	if !errors.Is(err, ErrSegmentNotFound) {
+		t.Errorf("expected ErrSegmentNotFound for key-02, got %v", err)
+	}
+}
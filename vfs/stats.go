@@ -0,0 +1,167 @@
+package vfs
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Stats 存储引擎的运行时统计信息
+type Stats struct {
+	// Compression 按照数据类型和压缩算法统计的压缩效果
+	Compression []CompressionStats
+	// Compactions 最近完成的压缩记录，从旧到新排列，最多保留 compactionHistorySize 条
+	Compactions []CompactionRecord
+	// Latency 每种操作从打开以来和最近一分钟的延迟分布
+	Latency []OperationLatency
+	// Backup 最近一次备份的结果和定期备份的状态
+	Backup BackupStatus
+}
+
+// MemoryUsage 存储引擎各个部分占用内存的估算值
+type MemoryUsage struct {
+	Keys          int   // 内存索引中 Key 的数量
+	IndexBytes    int64 // 内存索引估算占用的字节数
+	CacheBytes    int64 // 数据记录缓存中记录的字节数
+	CacheLimit    int64 // 数据记录缓存的最大字节数，0 表示没有开启缓存
+	CacheValues   int   // 数据记录缓存中记录的数量
+	MemtableBytes int64 // EngineLSM 中还没有写入排序数据文件的内存表占用的字节数，也就是写入缓冲
+	SparseBytes   int64 // 已经加载的排序数据文件稀疏块索引占用的字节数
+	ExpiryBytes   int64 // 过期队列占用的字节数
+	// 没有 Bloom 过滤器占用的字节数，内存索引包含所有的 Key，查找不存在的 Key 不会读取数据文件，
+	// 包括 Ingest 导入的数据文件在内都不需要 Bloom 过滤器
+}
+
+// Total 返回各个部分占用内存的总和
+func (u MemoryUsage) Total() int64 {
+	return u.IndexBytes + u.CacheBytes + u.MemtableBytes + u.SparseBytes + u.ExpiryBytes
+}
+
+// 稀疏块索引中每个块除了 Key 之外占用的字节数
+const sparseBlockSize = int64(24)
+
+// EstimateKeyOverhead 估算每个 Key 在内存索引中占用的字节数，部署之前乘以 Key 的数量就可以预估需要的内存
+// keySize 为 Key 的平均长度，IndexRadix 共享 Key 的前缀，keySize 应该使用 Key 中和其他 Key 不同的部分的平均长度
+// 基数树中每个 Key 平均需要一个叶子节点和一个分叉节点
+func EstimateKeyOverhead(kind IndexKind, keySize int) int64 {
+	if kind == IndexRadix {
+		return 2*radixNodeSize + radixValueSize + radixEntrySize + int64(keySize)
+	}
+	return inodeMemory(keySize)
+}
+
+// CompressionStats 一种数据类型使用一种压缩算法压缩前后的字节数
+type CompressionStats struct {
+	Kind            Kind
+	Algorithm       string
+	Records         uint64
+	RawBytes        uint64
+	CompressedBytes uint64
+}
+
+// Ratio 返回压缩之后的大小和原始大小的比例，越小说明压缩效果越好
+func (s CompressionStats) Ratio() float64 {
+	if s.RawBytes == 0 {
+		return 0
+	}
+	return float64(s.CompressedBytes) / float64(s.RawBytes)
+}
+
+// Stats 返回存储引擎当前统计信息的快照
+func (lfs *LogStructuredFS) Stats() Stats {
+	return Stats{
+		Compression: compressionStats.snapshot(),
+		Compactions: lfs.history.snapshot(),
+		Latency:     lfs.Latency(),
+		Backup:      lfs.backups.snapshot(),
+	}
+}
+
+// Memory 返回内存索引、数据记录缓存、内存表、稀疏块索引和过期队列占用内存的估算值
+func (lfs *LogStructuredFS) Memory() MemoryUsage {
+	usage := MemoryUsage{
+		Keys:          lfs.Count(),
+		IndexBytes:    lfs.indexMemory(),
+		MemtableBytes: lfs.lsm.memory(),
+		ExpiryBytes:   lfs.expiry.memory(),
+	}
+	if lfs.cache != nil {
+		usage.CacheBytes, usage.CacheValues = lfs.cache.usage()
+		usage.CacheLimit = lfs.cache.capacity.Load()
+	}
+
+	lfs.mu.Lock()
+	for _, idx := range lfs.sparse {
+		if idx == nil {
+			continue
+		}
+		usage.SparseBytes += int64(len(idx.last))
+		for _, block := range idx.blocks {
+			usage.SparseBytes += sparseBlockSize + int64(len(block.Key))
+		}
+	}
+	lfs.mu.Unlock()
+
+	return usage
+}
+
+// 压缩是在 NewSegment 中通过全局的 transformer 完成的，所以统计信息也是全局的
+var compressionStats = &compressionCounter{counters: make(map[compressionKey]*CompressionStats)}
+
+type compressionKey struct {
+	kind      Kind
+	algorithm string
+}
+
+type compressionCounter struct {
+	mu       sync.Mutex
+	counters map[compressionKey]*CompressionStats
+}
+
+func (c *compressionCounter) record(kind Kind, algorithm string, raw, compressed int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := compressionKey{kind: kind, algorithm: algorithm}
+	stats, ok := c.counters[key]
+	if !ok {
+		stats = &CompressionStats{Kind: kind, Algorithm: algorithm}
+		c.counters[key] = stats
+	}
+	stats.Records++
+	stats.RawBytes += uint64(raw)
+	stats.CompressedBytes += uint64(compressed)
+}
+
+func (c *compressionCounter) snapshot() []CompressionStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := make([]CompressionStats, 0, len(c.counters))
+	for _, stats := range c.counters {
+		result = append(result, *stats)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Kind == result[j].Kind {
+			return result[i].Algorithm < result[j].Algorithm
+		}
+		return result[i].Kind < result[j].Kind
+	})
+
+	return result
+}
+
+func (c *compressionCounter) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counters = make(map[compressionKey]*CompressionStats)
+}
+
+// compressorName 返回压缩算法的名称，没有实现 Name 方法的压缩算法使用类型名称
+func compressorName(compressor Compressor) string {
+	if named, ok := compressor.(interface{ Name() string }); ok {
+		return named.Name()
+	}
+	return fmt.Sprintf("%T", compressor)
+}
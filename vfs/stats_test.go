@@ -0,0 +1,116 @@
+package vfs
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCompressionStats(t *testing.T) {
+	compressionStats.reset()
+	defer compressionStats.reset()
+
+	tf := NewTransformer()
+	tf.SetCompressor(SnappyCompressor)
+
+	data := bytes.Repeat([]byte("wiredkv"), 1024)
+	for i := 0; i < 2; i++ {
+		_, _, err := tf.encode(Text, data)
+		if err != nil {
+			t.Fatalf("failed to encode data: %v", err)
+		}
+	}
+
+	lfs := &LogStructuredFS{}
+	stats := lfs.Stats().Compression
+	if len(stats) != 1 {
+		t.Fatalf("expected 1 compression stats, got %d", len(stats))
+	}
+
+	s := stats[0]
+	if s.Kind != Text || s.Algorithm != "snappy" || s.Records != 2 {
+		t.Errorf("unexpected compression stats: %+v", s)
+	}
+	if s.RawBytes != uint64(2*len(data)) {
+		t.Errorf("expected %d raw bytes, got %d", 2*len(data), s.RawBytes)
+	}
+	if s.Ratio() <= 0 || s.Ratio() >= 1 {
+		t.Errorf("expected compression ratio between 0 and 1, got %f", s.Ratio())
+	}
+}
+
+func TestMemoryUsage(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1, MaxCacheMemory: 1 * MB})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	err = lfs.AddSegment(InodeNum("key"), *testSegment("key", "value"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+	_, err = lfs.FetchSegment(InodeNum("key"))
+	if err != nil {
+		t.Fatalf("failed to fetch segment: %v", err)
+	}
+
+	usage := lfs.Memory()
+	if usage.Keys != 1 || usage.IndexBytes != inodeMemory(len("key")) {
+		t.Errorf("expected 1 key using %d index bytes, got %+v", inodeMemory(len("key")), usage)
+	}
+	if usage.CacheValues != 1 || usage.CacheBytes <= 0 || usage.CacheLimit != 1*MB {
+		t.Errorf("expected 1 cached value within 1MB, got %+v", usage)
+	}
+}
+
+func TestMemoryBreakdown(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1, Engine: EngineLSM})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	seg := testSegment("key", "value")
+	seg.ExpiredAt = uint64(time.Now().Add(time.Hour).Unix())
+	err = lfs.AddSegment(InodeNum("key"), *seg, 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+
+	usage := lfs.Memory()
+	if usage.MemtableBytes <= 0 || usage.ExpiryBytes <= 0 || usage.SparseBytes != 0 {
+		t.Errorf("expected memtable and expiry memory before flush, got %+v", usage)
+	}
+	if usage.Total() != usage.IndexBytes+usage.CacheBytes+usage.MemtableBytes+usage.ExpiryBytes {
+		t.Errorf("expected total to sum all parts, got %d for %+v", usage.Total(), usage)
+	}
+
+	err = lfs.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+	waitLSM(t, lfs, func() bool { return lfs.Memory().MemtableBytes == 0 })
+	if usage := lfs.Memory(); usage.SparseBytes <= 0 {
+		t.Errorf("expected sparse index memory after flush, got %+v", usage)
+	}
+}
+
+func TestEstimateKeyOverhead(t *testing.T) {
+	if got := EstimateKeyOverhead(IndexHashMap, 32); got != inodeMemory(32) {
+		t.Errorf("expected hash map overhead %d, got %d", inodeMemory(32), got)
+	}
+
+	// 共享前缀之后每个 Key 只有很短的不同部分，估算值应该接近基数树实际占用的内存
+	r := newRadixIndex()
+	n := 10000
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("https://example.com/static/images/%05d.png", i)
+		r.set(InodeNum(key), &INode{Key: key})
+	}
+	estimate := EstimateKeyOverhead(IndexRadix, len("00000.png")) * int64(n)
+	if actual := r.bytes.Load(); actual > estimate*2 || actual < estimate/2 {
+		t.Errorf("expected radix memory close to estimate %d, got %d", estimate, actual)
+	}
+}
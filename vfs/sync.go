@@ -0,0 +1,192 @@
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/auula/wiredkv/clog"
+)
+
+// SyncPolicy 数据写入之后刷新到磁盘的策略
+type SyncPolicy int8
+
+const (
+	SyncNever    SyncPolicy = iota // 由操作系统决定什么时候刷盘
+	SyncAlways                     // 每次写入之后立即刷盘
+	SyncInterval                   // 后台 goroutine 按照 SyncInterval 周期刷盘
+)
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncNever:
+		return "never"
+	case SyncAlways:
+		return "always"
+	case SyncInterval:
+		return "interval"
+	default:
+		return fmt.Sprintf("sync(%d)", int8(p))
+	}
+}
+
+// ParseSyncPolicy 根据名称返回刷盘策略，空字符串返回默认的 SyncNever
+func ParseSyncPolicy(name string) (SyncPolicy, error) {
+	switch name {
+	case "", "never":
+		return SyncNever, nil
+	case "always":
+		return SyncAlways, nil
+	case "interval":
+		return SyncInterval, nil
+	default:
+		return 0, fmt.Errorf("unsupported sync policy: %s", name)
+	}
+}
+
+// SyncMethod 刷盘时使用的系统调用
+type SyncMethod int8
+
+const (
+	SyncFsync     SyncMethod = iota // 使用 fsync 刷新数据和所有元数据
+	SyncFdatasync                   // 使用 fdatasync 只刷新数据和文件大小
+	SyncDsync                       // 使用 O_DSYNC 打开活跃数据文件，每次写入都同步落盘
+)
+
+// 没有配置 SyncInterval 时默认的刷盘周期
+const defaultSyncInterval = time.Second
+
+// LastSequence 返回最后一次写入的记录序列号，每写入一条记录序列号加一
+func (lfs *LogStructuredFS) LastSequence() uint64 {
+	return lfs.sequence.Load()
+}
+
+// LastSyncedSequence 返回已经刷新到磁盘的最大记录序列号
+func (lfs *LogStructuredFS) LastSyncedSequence() uint64 {
+	return lfs.synced.Load()
+}
+
+// Sync 把活跃数据文件中已经写入的记录刷新到磁盘
+func (lfs *LogStructuredFS) Sync() error {
+	lfs.mu.Lock()
+	seq := lfs.LastSequence()
+	err := lfs.syncActive()
+	lfs.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to sync active region: %w", err)
+	}
+
+	lfs.notifySynced(seq)
+
+	return nil
+}
+
+// syncActive 按照 SyncMethod 刷新活跃数据文件，调用方需要持有 lfs.mu
+func (lfs *LogStructuredFS) syncActive() error {
+	// 内存中的数据文件和测试使用的临时实例不需要刷盘
+	active, ok := lfs.active.(*os.File)
+	if !ok || lfs.noSync {
+		return nil
+	}
+
+	switch lfs.syncMethod {
+	case SyncDsync:
+		// 使用 O_DSYNC 打开的文件每次写入都已经落盘了
+		if dsyncFlag != 0 {
+			return nil
+		}
+		return fdatasync(active)
+	case SyncFdatasync:
+		return fdatasync(active)
+	default:
+		return active.Sync()
+	}
+}
+
+// activeFlag 返回打开活跃数据文件使用的标志位
+func (lfs *LogStructuredFS) activeFlag() int {
+	if lfs.syncMethod == SyncDsync {
+		return RWCA | dsyncFlag
+	}
+	return RWCA
+}
+
+// WaitSynced 阻塞直到序列号为 seq 的记录已经刷新到磁盘
+// 只有 SyncInterval 策略会等待后台刷盘，其他策略会直接执行一次刷盘
+func (lfs *LogStructuredFS) WaitSynced(ctx context.Context, seq uint64) error {
+	for {
+		lfs.syncMu.Lock()
+		if lfs.LastSyncedSequence() >= seq {
+			lfs.syncMu.Unlock()
+			return nil
+		}
+		notify := lfs.syncNotify
+		lfs.syncMu.Unlock()
+
+		lfs.mu.Lock()
+		policy := lfs.syncPolicy
+		lfs.mu.Unlock()
+
+		if policy != SyncInterval {
+			return lfs.Sync()
+		}
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// notifySynced 更新已经刷盘的序列号并唤醒所有等待的调用方
+func (lfs *LogStructuredFS) notifySynced(seq uint64) {
+	lfs.syncMu.Lock()
+	defer lfs.syncMu.Unlock()
+
+	if seq > lfs.LastSyncedSequence() {
+		lfs.synced.Store(seq)
+	}
+	close(lfs.syncNotify)
+	lfs.syncNotify = make(chan struct{})
+}
+
+// startSyncDaemon 启动后台刷盘的 goroutine，没有新的写入时跳过本周期
+func (lfs *LogStructuredFS) startSyncDaemon(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultSyncInterval
+	}
+
+	lfs.syncdone = make(chan struct{})
+	lfs.syncexit = make(chan struct{})
+	go func() {
+		defer close(lfs.syncexit)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if lfs.LastSequence() <= lfs.LastSyncedSequence() {
+					continue
+				}
+				err := lfs.Sync()
+				if err != nil {
+					clog.Errorf("failed to sync active region: %v", err)
+				}
+			case <-lfs.syncdone:
+				return
+			}
+		}
+	}()
+}
+
+// stopSyncDaemon 停止后台刷盘的 goroutine 并且等待它退出
+func (lfs *LogStructuredFS) stopSyncDaemon() {
+	if lfs.syncdone == nil {
+		return
+	}
+	close(lfs.syncdone)
+	<-lfs.syncexit
+	lfs.syncdone = nil
+}
@@ -0,0 +1,29 @@
+//go:build linux
+
+package vfs
+
+import (
+	"os"
+	"syscall"
+)
+
+// 追加写入时使用 O_DSYNC 打开活跃数据文件，每次写入返回时数据已经落盘
+const dsyncFlag = syscall.O_DSYNC
+
+// fdatasync 只刷新文件的数据和必要的元数据，不会刷新修改时间之类的元数据
+func fdatasync(f *os.File) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+	err = conn.Control(func(fd uintptr) {
+		serr = syscall.Fdatasync(int(fd))
+	})
+	if err != nil {
+		return err
+	}
+
+	return serr
+}
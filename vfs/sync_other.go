@@ -0,0 +1,13 @@
+//go:build !linux && !windows
+
+package vfs
+
+import "os"
+
+// 不支持 O_DSYNC 的平台回退到每次写入之后调用 fsync
+const dsyncFlag = 0
+
+// fdatasync 在不支持 fdatasync 的平台回退到 fsync
+func fdatasync(f *os.File) error {
+	return f.Sync()
+}
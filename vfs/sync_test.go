@@ -0,0 +1,126 @@
+package vfs
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSyncPolicy(t *testing.T) {
+	t.Run("Interval", func(t *testing.T) {
+		lfs, err := OpenFS(&Options{
+			Path:         t.TempDir(),
+			FsPerm:       fsPerm,
+			Threshold:    1,
+			Sync:         SyncInterval,
+			SyncInterval: 10 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("failed to open fs: %v", err)
+		}
+		defer lfs.CloseFS()
+
+		err = lfs.AddSegment(InodeNum("key-01"), *testSegment("key-01", "value-01"), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+
+		seq := lfs.LastSequence()
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		// 等待后台 goroutine 刷盘
+		err = lfs.WaitSynced(ctx, seq)
+		if err != nil {
+			t.Fatalf("failed to wait synced: %v", err)
+		}
+		if lfs.LastSyncedSequence() < seq {
+			t.Errorf("expected synced sequence >= %d, got %d", seq, lfs.LastSyncedSequence())
+		}
+	})
+
+	t.Run("Always", func(t *testing.T) {
+		lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1, Sync: SyncAlways})
+		if err != nil {
+			t.Fatalf("failed to open fs: %v", err)
+		}
+		defer lfs.CloseFS()
+
+		err = lfs.AddSegment(InodeNum("key-01"), *testSegment("key-01", "value-01"), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+
+		if lfs.LastSyncedSequence() != lfs.LastSequence() {
+			t.Errorf("expected synced sequence %d, got %d", lfs.LastSequence(), lfs.LastSyncedSequence())
+		}
+	})
+}
+
+func TestSyncMethod(t *testing.T) {
+	for name, method := range map[string]SyncMethod{"Fsync": SyncFsync, "Fdatasync": SyncFdatasync, "Dsync": SyncDsync} {
+		t.Run(name, func(t *testing.T) {
+			path := t.TempDir()
+			opt := &Options{Path: path, FsPerm: fsPerm, Threshold: 1, Sync: SyncAlways, SyncMethod: method}
+			lfs, err := OpenFS(opt)
+			if err != nil {
+				t.Fatalf("failed to open fs: %v", err)
+			}
+
+			err = lfs.AddSegment(InodeNum("key-01"), *testSegment("key-01", "value-01"), 0)
+			if err != nil {
+				t.Fatalf("failed to add segment: %v", err)
+			}
+			mustCloseFS(t, lfs)
+
+			// 重新打开之后继续向恢复的活跃数据文件写入
+			lfs, err = OpenFS(opt)
+			if err != nil {
+				t.Fatalf("failed to reopen fs: %v", err)
+			}
+			defer lfs.CloseFS()
+
+			err = lfs.AddSegment(InodeNum("key-02"), *testSegment("key-02", "value-02"), 0)
+			if err != nil {
+				t.Fatalf("failed to add segment after reopen: %v", err)
+			}
+
+			for _, key := range []string{"key-01", "key-02"} {
+				if _, err := lfs.FetchSegment(InodeNum(key)); err != nil {
+					t.Errorf("failed to fetch %s: %v", key, err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSyncMethod(b *testing.B) {
+	methods := []struct {
+		name   string
+		method SyncMethod
+	}{
+		{"Fsync", SyncFsync},
+		{"Fdatasync", SyncFdatasync},
+		{"Dsync", SyncDsync},
+	}
+
+	for _, m := range methods {
+		b.Run(m.name, func(b *testing.B) {
+			lfs, err := OpenFS(&Options{Path: b.TempDir(), FsPerm: fsPerm, Threshold: 1, Sync: SyncAlways, SyncMethod: m.method})
+			if err != nil {
+				b.Fatalf("failed to open fs: %v", err)
+			}
+			defer lfs.CloseFS()
+
+			seg := testSegment("key-01", "value-01")
+			inum := InodeNum("key-01")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := lfs.AddSegment(inum, *seg, 0)
+				if err != nil {
+					b.Fatalf("failed to add segment: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+//go:build windows
+
+package vfs
+
+import (
+	"os"
+	"syscall"
+)
+
+// Windows 没有 O_DSYNC，回退到每次写入之后调用 FlushFileBuffers
+const dsyncFlag = 0
+
+// fdatasync 在 Windows 上使用 FlushFileBuffers 刷新文件的数据和元数据
+func fdatasync(f *os.File) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+	err = conn.Control(func(fd uintptr) {
+		serr = syscall.FlushFileBuffers(syscall.Handle(fd))
+	})
+	if err != nil {
+		return err
+	}
+
+	return serr
+}
@@ -0,0 +1,29 @@
+package vfs
+
+import "testing"
+
+// OpenTemp 在 t.TempDir() 中打开一个临时的 LogStructuredFS，测试结束时自动关闭
+// 临时实例不会刷盘，适合其他项目针对真实的存储引擎编写运行速度较快的集成测试
+// 调用方不需要再调用 CloseFS，数据目录会被测试框架删除
+func OpenTemp(t testing.TB) *LogStructuredFS {
+	t.Helper()
+
+	lfs, err := OpenFS(&Options{
+		Path:      t.TempDir(),
+		FsPerm:    fsPerm,
+		Threshold: 1,
+		Sync:      SyncNever,
+	})
+	if err != nil {
+		t.Fatalf("failed to open temp fs: %v", err)
+	}
+	lfs.noSync = true
+
+	t.Cleanup(func() {
+		if err := lfs.CloseFS(); err != nil {
+			t.Errorf("failed to close temp fs: %v", err)
+		}
+	})
+
+	return lfs
+}
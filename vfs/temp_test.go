@@ -0,0 +1,27 @@
+package vfs
+
+import "testing"
+
+func TestOpenTemp(t *testing.T) {
+	lfs := OpenTemp(t)
+
+	seg := testSegment("key", "value")
+	err := lfs.AddSegment(InodeNum("key"), *seg, 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+
+	// 切换数据文件时也不会刷盘
+	err = lfs.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+
+	fetched, err := lfs.FetchSegment(InodeNum("key"))
+	if err != nil {
+		t.Fatalf("failed to fetch segment: %v", err)
+	}
+	if string(fetched.Value) != "value" {
+		t.Errorf("expected value, got %s", fetched.Value)
+	}
+}
@@ -0,0 +1,141 @@
+package vfs
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/auula/wiredkv/clog"
+)
+
+// touchRegion 记录数据文件最后一次被读取的时间，调用方需要持有 lfs.mu
+func (lfs *LogStructuredFS) touchRegion(regionID uint64) {
+	lfs.accessed[regionID] = time.Now()
+}
+
+// isColdRegion 判断数据文件是否已经迁移到 Backend 中
+func (lfs *LogStructuredFS) isColdRegion(regionID uint64) bool {
+	lfs.mu.Lock()
+	defer lfs.mu.Unlock()
+	_, ok := lfs.cold[regionID]
+	return ok
+}
+
+// idleRegions 返回超过 idle 时间没有被读取过的本地非活跃数据文件，按照编号从小到大排序
+func (lfs *LogStructuredFS) idleRegions(idle time.Duration) []uint64 {
+	lfs.mu.Lock()
+	defer lfs.mu.Unlock()
+
+	deadline := time.Now().Add(-idle)
+	var regionIds []uint64
+	for regionID := range lfs.regions {
+		if regionID == lfs.regionID {
+			continue
+		}
+		if lfs.accessed[regionID].Before(deadline) {
+			regionIds = append(regionIds, regionID)
+		}
+	}
+
+	sort.Slice(regionIds, func(i, j int) bool { return regionIds[i] < regionIds[j] })
+
+	return regionIds
+}
+
+// TierRegions 把超过 idle 时间没有被读取过的非活跃数据文件迁移到 Backend 中，返回迁移的数据文件编号
+// 有空洞的数据文件会先压缩，压缩之后有效数据已经在活跃数据文件中了，不需要再迁移
+func (lfs *LogStructuredFS) TierRegions(idle time.Duration) ([]uint64, error) {
+	if lfs.backend == nil {
+		return nil, fmt.Errorf("no backend configured for tiering regions")
+	}
+
+	var tiered []uint64
+	for _, regionID := range lfs.idleRegions(idle) {
+		if lfs.hasHoles(regionID) {
+			err := lfs.compactRegion(regionID)
+			if err != nil {
+				return tiered, err
+			}
+			continue
+		}
+
+		err := lfs.OffloadRegion(regionID)
+		if err != nil {
+			return tiered, err
+		}
+		tiered = append(tiered, regionID)
+	}
+
+	return tiered, nil
+}
+
+// hasHoles 判断本地数据文件是否被打过洞
+func (lfs *LogStructuredFS) hasHoles(regionID uint64) bool {
+	lfs.mu.Lock()
+	fd, ok := lfs.regions[regionID]
+	lfs.mu.Unlock()
+	if !ok {
+		return false
+	}
+	if _, ok := fd.(*memFile); ok {
+		return false
+	}
+	_, err := os.Stat(holesFileName(fd.Name()))
+	return err == nil
+}
+
+// promoteSegment 把从 Backend 中读取的记录重新写入活跃数据文件，之后的读取不再需要访问 Backend
+func (lfs *LogStructuredFS) promoteSegment(inum uint64, inode *INode) error {
+	fd, version, checksum, ok := lfs.regionFile(inode.RegionID)
+	if !ok {
+		return fmt.Errorf("region file not found for region id: %d", inode.RegionID)
+	}
+
+	_, raw, _, err := readRawSegment(fd, inode.Position, version, checksum)
+	if err != nil {
+		return err
+	}
+	raw.Flags &^= flagBatch
+
+	return lfs.moveSegment(inum, inode, raw)
+}
+
+// StartTiering 启动后台迁移冷数据的 goroutine，每个 cycle 周期检查一次超过 idle 时间没有被读取的数据文件
+func (lfs *LogStructuredFS) StartTiering(cycle, idle time.Duration) {
+	if lfs.tierdone != nil {
+		return
+	}
+
+	lfs.tierdone = make(chan struct{})
+	lfs.tierexit = make(chan struct{})
+	go func() {
+		defer close(lfs.tierexit)
+		ticker := time.NewTicker(cycle)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				tiered, err := lfs.TierRegions(idle)
+				if err != nil {
+					clog.Errorf("failed to tier regions: %v", err)
+				}
+				if len(tiered) > 0 {
+					clog.Infof("tiered %d regions to backend: %v", len(tiered), tiered)
+				}
+			case <-lfs.tierdone:
+				return
+			}
+		}
+	}()
+}
+
+// StopTiering 停止后台迁移冷数据的 goroutine 并且等待正在执行的迁移完成
+func (lfs *LogStructuredFS) StopTiering() {
+	if lfs.tierdone == nil {
+		return
+	}
+	close(lfs.tierdone)
+	<-lfs.tierexit
+	lfs.tierdone = nil
+}
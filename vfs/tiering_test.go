@@ -0,0 +1,62 @@
+package vfs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTierRegions(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1, Backend: NewLocalBackend(t.TempDir())})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer lfs.CloseFS()
+
+	for i, key := range []string{"key-01", "key-02"} {
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value-"+key), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+		// 每个 Key 放在一个单独的数据文件中
+		if i == 0 {
+			if err := lfs.ChangeRegions(); err != nil {
+				t.Fatalf("failed to change regions: %v", err)
+			}
+		}
+	}
+	if err := lfs.ChangeRegions(); err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+
+	// 第一个数据文件很久没有被读取过
+	lfs.mu.Lock()
+	lfs.accessed[1] = time.Now().Add(-48 * time.Hour)
+	lfs.mu.Unlock()
+
+	tiered, err := lfs.TierRegions(24 * time.Hour)
+	if err != nil {
+		t.Fatalf("failed to tier regions: %v", err)
+	}
+	if len(tiered) != 1 || tiered[0] != 1 {
+		t.Fatalf("expected region 1 to be tiered, got %v", tiered)
+	}
+	if !lfs.isColdRegion(1) || lfs.isColdRegion(2) {
+		t.Fatalf("expected only region 1 to be cold")
+	}
+
+	// 读取冷数据之后记录被重新写回活跃数据文件
+	seg, err := lfs.FetchSegment(InodeNum("key-01"))
+	if err != nil || string(seg.Value) != "value-key-01" {
+		t.Fatalf("failed to fetch cold segment: %v", err)
+	}
+
+	inode, ok := lfs.GetINode(InodeNum("key-01"))
+	if !ok || inode.RegionID != lfs.activeRegionID() {
+		t.Errorf("expected cold segment to be promoted to active region, got %+v", inode)
+	}
+
+	seg, err = lfs.FetchSegment(InodeNum("key-01"))
+	if err != nil || string(seg.Value) != "value-key-01" {
+		t.Errorf("failed to fetch promoted segment: %v", err)
+	}
+}
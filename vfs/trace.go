@@ -0,0 +1,76 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Tracer 为读写、扫描和压缩创建追踪的 span，存储引擎不直接依赖 OpenTelemetry
+// 接口和 OpenTelemetry 的 trace.Tracer 一一对应，使用时只需要一个很薄的适配器：
+//
+//	func (t otelTracer) Start(ctx context.Context, name string) (context.Context, vfs.Span) {
+//		ctx, span := t.tracer.Start(ctx, name)
+//		return ctx, otelSpan{span}
+//	}
+//
+// 返回的 ctx 会继续传给存储引擎内部的调用，span 可以挂在调用方的分布式追踪下面
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span 是一次操作的追踪记录，End 之后不能再使用
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	RecordError(err error)
+	End()
+}
+
+// Attribute 是 span 上的一个属性，Value 只会是 string、int64 或者 bool
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// span 的名称
+const (
+	spanGet     = "vfs.Get"
+	spanPut     = "vfs.Put"
+	spanScan    = "vfs.Scan"
+	spanCompact = "vfs.Compact"
+)
+
+func stringAttr(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func int64Attr(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func boolAttr(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func durationAttr(key string, d time.Duration) Attribute {
+	return Attribute{Key: key, Value: d.Microseconds()}
+}
+
+// startSpan 没有设置 Tracer 时返回 nil，调用方需要先判断 span 是否为 nil，不追踪时不会分配属性
+func (lfs *LogStructuredFS) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if lfs.tracer == nil {
+		return ctx, nil
+	}
+	return lfs.tracer.Start(ctx, name)
+}
+
+// endSpan 记录操作的错误并结束 span，Key 不存在不算错误
+func endSpan(span Span, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil && !errors.Is(err, ErrSegmentNotFound) {
+		span.RecordError(err)
+	}
+	span.End()
+}
@@ -0,0 +1,94 @@
+package vfs
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+type recordedSpan struct {
+	name  string
+	attrs map[string]interface{}
+	err   error
+	ended bool
+}
+
+func (s *recordedSpan) SetAttributes(attrs ...Attribute) {
+	for _, attr := range attrs {
+		s.attrs[attr.Key] = attr.Value
+	}
+}
+
+func (s *recordedSpan) RecordError(err error) { s.err = err }
+func (s *recordedSpan) End()                  { s.ended = true }
+
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span := &recordedSpan{name: name, attrs: make(map[string]interface{})}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+// last 返回最后一个名称为 name 的 span
+func (t *recordingTracer) last(name string) *recordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := len(t.spans) - 1; i >= 0; i-- {
+		if t.spans[i].name == name {
+			return t.spans[i]
+		}
+	}
+	return nil
+}
+
+func TestTracerSpans(t *testing.T) {
+	tracer := new(recordingTracer)
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1, MaxCacheMemory: 1 * MB, Tracer: tracer})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	err = lfs.AddSegment(InodeNum("key"), *testSegment("key", "value"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+	put := tracer.last(spanPut)
+	if put == nil || !put.ended || put.attrs["vfs.version"] != int64(1) {
+		t.Fatalf("expected ended put span with version 1, got %+v", put)
+	}
+
+	for _, hit := range []bool{false, true} {
+		_, err = lfs.FetchSegment(InodeNum("key"))
+		if err != nil {
+			t.Fatalf("failed to fetch segment: %v", err)
+		}
+		get := tracer.last(spanGet)
+		if get == nil || !get.ended || get.attrs["vfs.cache_hit"] != hit || get.attrs["vfs.bytes"] != int64(len("value")) {
+			t.Errorf("expected get span with cache hit %t, got %+v", hit, get)
+		}
+		if _, ok := get.attrs["vfs.decode_us"]; !hit && !ok {
+			t.Errorf("expected decode timing on cache miss, got %+v", get.attrs)
+		}
+	}
+
+	// Key 不存在不算错误
+	_, _ = lfs.FetchSegment(InodeNum("missing"))
+	if get := tracer.last(spanGet); get.err != nil {
+		t.Errorf("expected no error recorded for missing key, got %v", get.err)
+	}
+
+	_, err = lfs.ScanPage("k", "", 10)
+	if err != nil {
+		t.Fatalf("failed to scan page: %v", err)
+	}
+	if scan := tracer.last(spanScan); scan == nil || scan.attrs["vfs.keys"] != int64(1) {
+		t.Errorf("expected scan span with 1 key, got %+v", scan)
+	}
+}
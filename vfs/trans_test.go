@@ -1,6 +1,8 @@
 package vfs
 
 import (
+	"bytes"
+	"crypto/rand"
 	"testing"
 )
 
@@ -31,3 +33,59 @@ func TestTransformerWithComplexData(t *testing.T) {
 		t.Fatalf("failed to decode data: got %s, want %s", decodedData, originalString)
 	}
 }
+
+// 测试每条记录保存的压缩标志位，读取时不依赖 Transformer 当前的全局设置
+func TestTransformerPerRecordFlags(t *testing.T) {
+	tf := NewTransformer()
+	tf.SetCompressor(SnappyCompressor)
+
+	compressible := bytes.Repeat([]byte("wiredkv"), 128)
+	incompressible := make([]byte, 256)
+	_, err := rand.Read(incompressible)
+	if err != nil {
+		t.Fatalf("failed to generate random data: %v", err)
+	}
+
+	cdata, cflags, err := tf.encode(Binary, compressible)
+	if err != nil {
+		t.Fatalf("failed to encode compressible data: %v", err)
+	}
+	if cflags&flagCompressed == 0 || cflags&flagTransform == 0 {
+		t.Errorf("expected compressed flags, got %08b", cflags)
+	}
+
+	idata, iflags, err := tf.encode(Binary, incompressible)
+	if err != nil {
+		t.Fatalf("failed to encode incompressible data: %v", err)
+	}
+	if iflags&flagCompressed != 0 {
+		t.Errorf("expected incompressible data stored raw, got flags %08b", iflags)
+	}
+	if !bytes.Equal(idata, incompressible) {
+		t.Error("expected incompressible data unchanged")
+	}
+
+	// 关闭压缩之后之前写入的记录仍然按照自己的标志位解码
+	tf.DisableCompression()
+	for _, tc := range []struct {
+		data, expected []byte
+		flags          uint8
+	}{
+		{cdata, compressible, cflags},
+		{idata, incompressible, iflags},
+	} {
+		decoded, err := tf.decode(tc.flags, tc.data)
+		if err != nil {
+			t.Fatalf("failed to decode data: %v", err)
+		}
+		if !bytes.Equal(decoded, tc.expected) {
+			t.Errorf("expected %d bytes decoded, got %d", len(tc.expected), len(decoded))
+		}
+	}
+
+	// 没有 flagTransform 的旧记录按照全局设置解码
+	decoded, err := tf.decode(0, compressible)
+	if err != nil || !bytes.Equal(decoded, compressible) {
+		t.Errorf("expected legacy record decoded with global flags, got err: %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package vfs
 
 import (
+	"crypto/cipher"
 	"errors"
 	"fmt"
 
@@ -30,8 +31,10 @@ type Encryptor interface {
 type Transformer struct {
 	Encryptor
 	Compressor
-	flags  int
-	secret []byte
+	flags    int
+	secret   []byte
+	aead     cipher.AEAD      // Encryptor 实现了 AEADEncryptor 时使用统一的加密记录布局
+	adaptive *AdaptiveOptions // 不为空时每条记录单独选择压缩算法
 }
 
 func NewTransformer() *Transformer {
@@ -70,49 +73,221 @@ func (t *Transformer) DisableAll() {
 	t.flags = 0
 }
 
+// SetEncryptor 把 secret 复制到 Transformer 自己的缓冲区，调用方可以在返回之后立即清零自己的 secret
+// 更换密钥时清零之前的密钥，之前写入的加密记录需要使用之前的密钥读取
 func (t *Transformer) SetEncryptor(encryptor Encryptor, secret []byte) error {
 	if len(secret) < 16 {
 		return errors.New("secret char length too short")
 	}
-	t.secret = secret
+	owned := make([]byte, len(secret))
+	copy(owned, secret)
+
+	var aead cipher.AEAD
+	if e, ok := encryptor.(AEADEncryptor); ok {
+		var err error
+		aead, err = e.AEAD(owned)
+		if err != nil {
+			wipe(owned)
+			return fmt.Errorf("failed to create aead cipher: %w", err)
+		}
+	}
+	wipe(t.secret)
+	t.aead = aead
+	t.secret = owned
 	t.Encryptor = encryptor
 	t.EnableEncryption()
 	return nil
 }
 
+// WipeSecret 清零 Transformer 保存的密钥并关闭加密，之后读取加密的记录需要重新调用 SetEncryptor
+// cipher.AEAD 内部展开的轮密钥没有办法从外部清零，只能解除引用等待垃圾回收
+func (t *Transformer) WipeSecret() {
+	wipe(t.secret)
+	t.secret = nil
+	t.aead = nil
+	t.Encryptor = nil
+	t.DisableEncryption()
+}
+
+// wipe 把不再使用的密钥清零，不依赖垃圾回收的时机
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
 func (t *Transformer) SetCompressor(compressor Compressor) {
 	t.Compressor = compressor
+	t.adaptive = nil
+	t.EnableCompression()
+}
+
+// SetAdaptiveCompression 开启自适应压缩，根据 Value 的样本为每条记录选择不压缩、Snappy 或者压缩率更高的算法
+// 选择的压缩算法编号保存在记录中，读取时不依赖当前的设置，Encode 和 Decode 仍然使用 Compressor
+func (t *Transformer) SetAdaptiveCompression(opt AdaptiveOptions) {
+	opt = opt.withDefaults()
+	t.adaptive = &opt
 	t.EnableCompression()
 }
 
+// Encode 的结果只能通过 Decode 按照全局设置解码，所以不可压缩的数据也会被压缩
 func (t *Transformer) Encode(data []byte) ([]byte, error) {
-	var err error
+	data, _, err := t.transform(Unknown, data, false)
+	return data, err
+}
+
+// encode 和 Encode 一样，但是会按照数据类型记录压缩前后的字节数
+// 返回的标志位记录了 Value 经过了哪些处理，需要保存在 Segment.Flags 中
+func (t *Transformer) encode(kind Kind, data []byte) ([]byte, uint8, error) {
+	return t.transform(kind, data, true)
+}
+
+// transform 依次压缩和加密 data，skip 为 true 时压缩之后没有变小的数据原样保存
+func (t *Transformer) transform(kind Kind, data []byte, skip bool) ([]byte, uint8, error) {
+	var (
+		err   error
+		flags uint8
+	)
 	// 压缩数据
-	if t.IsCompressionEnabled() && t.Compressor != nil {
-		data, err = t.Compress(data)
+	if t.IsCompressionEnabled() && skip && t.adaptive != nil {
+		compressed, ok, err := t.adaptive.compress(kind, data)
 		if err != nil {
-			return nil, fmt.Errorf("failed to compress data: %w", err)
+			return nil, 0, fmt.Errorf("failed to compress data: %w", err)
+		}
+		if ok {
+			data = compressed
+			flags |= flagCompressed | flagCodec
+		}
+	} else if t.IsCompressionEnabled() && t.Compressor != nil {
+		compressed, err := t.Compress(data)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to compress data: %w", err)
+		}
+		if !skip || len(compressed) < len(data) {
+			compressionStats.record(kind, compressorName(t.Compressor), len(data), len(compressed))
+			data = compressed
+			flags |= flagCompressed
+		} else {
+			compressionStats.record(kind, compressorName(t.Compressor), len(data), len(data))
 		}
-
 	}
 
 	// 加密数据
 	if t.IsEncryptionEnabled() && t.Encryptor != nil {
-		data, err = t.Encryptor.Encode(t.secret, data)
+		if t.aead != nil {
+			data, err = sealValue(t.aead, data)
+		} else {
+			data, err = t.Encryptor.Encode(t.secret, data)
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to encrypt data: %w", err)
+		}
+		flags |= flagEncrypted
+	}
+
+	return data, flags | flagTransform, nil
+}
+
+// decode 按照记录自己的标志位解码 Value，和 Transformer 当前是否开启压缩和加密无关
+// 没有 flagTransform 标志位的旧记录不知道写入时的设置，只能按照当前的全局设置解码
+func (t *Transformer) decode(flags uint8, data []byte) ([]byte, error) {
+	if flags&flagTransform == 0 {
+		return t.Decode(data)
+	}
+
+	var err error
+	if flags&flagEncrypted != 0 {
+		switch {
+		case t.aead != nil:
+			data, err = openValue(t.aead, data)
+		case t.Encryptor != nil:
+			data, err = t.Encryptor.Decode(t.secret, data)
+		default:
+			err = errors.New("no encryptor configured")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt data: %w", err)
+		}
+	}
+
+	if flags&flagCompressed != 0 && flags&flagCodec != 0 {
+		data, err = decompressCodec(data)
 		if err != nil {
-			return nil, fmt.Errorf("failed to encrypt data: %w", err)
+			return nil, fmt.Errorf("failed to decompress data: %w", err)
+		}
+	} else if flags&flagCompressed != 0 {
+		// 关闭压缩之后 Compressor 可能为空，使用默认的 Snappy 解压之前写入的记录
+		compressor := t.Compressor
+		if compressor == nil {
+			compressor = SnappyCompressor
+		}
+		data, err = compressor.Decompress(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress data: %w", err)
 		}
 	}
 
 	return data, nil
 }
 
+// decodeInto 和 decode 一样，但是把解码之后的 Value 追加到 dst 中
+// 没有经过处理和只经过 Snappy 压缩的 Value 直接解码到 dst 中，其他情况需要先解码再复制
+func (t *Transformer) decodeInto(flags uint8, data, dst []byte) ([]byte, error) {
+	if flags&flagTransform != 0 && flags&flagEncrypted == 0 {
+		if flags&flagCompressed == 0 {
+			return append(dst, data...), nil
+		}
+		if flags&flagCodec != 0 && len(data) > 0 && Codec(data[0]) == CodecSnappy {
+			if compressor, ok := lookupCodec(CodecSnappy); ok && isSnappy(compressor) {
+				return snappyDecodeInto(data[1:], dst)
+			}
+		}
+		if flags&flagCodec == 0 && (t.Compressor == nil || isSnappy(t.Compressor)) {
+			return snappyDecodeInto(data, dst)
+		}
+	}
+
+	decoded, err := t.decode(flags, data)
+	if err != nil {
+		return nil, err
+	}
+	return append(dst, decoded...), nil
+}
+
+func isSnappy(compressor Compressor) bool {
+	_, ok := compressor.(*Snappy)
+	return ok
+}
+
+// snappyDecodeInto 把 Snappy 压缩的 data 解压之后追加到 dst 中
+func snappyDecodeInto(data, dst []byte) ([]byte, error) {
+	n, err := snappy.DecodedLen(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress data: %w", err)
+	}
+	start := len(dst)
+	if cap(dst)-start < n {
+		grown := make([]byte, start, start+n)
+		copy(grown, dst)
+		dst = grown
+	}
+	decoded, err := snappy.Decode(dst[start:start+n], data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress data: %w", err)
+	}
+	return dst[:start+len(decoded)], nil
+}
+
 // fd 必须实现 io.ReadWriteCloser 接口
 func (t *Transformer) Decode(data []byte) ([]byte, error) {
 	var err error
 	// 解密数据
 	if t.IsEncryptionEnabled() && t.Encryptor != nil {
-		data, err = t.Encryptor.Decode(t.secret, data)
+		if t.aead != nil {
+			data, err = openValue(t.aead, data)
+		} else {
+			data, err = t.Encryptor.Decode(t.secret, data)
+		}
 		if err != nil {
 			return nil, fmt.Errorf("failed to decrypt data: %w", err)
 		}
@@ -131,10 +306,15 @@ func (t *Transformer) Decode(data []byte) ([]byte, error) {
 
 type Snappy struct{}
 
+func (s *Snappy) Name() string {
+	return "snappy"
+}
+
 func (s *Snappy) Compress(data []byte) ([]byte, error) {
-	// Snappy 压缩数据
-	compressed := snappy.Encode(nil, data)
-	return compressed, nil
+	// 先压缩到池中的缓冲区，结果按照实际大小分配，不会保留 MaxEncodedLen 的容量
+	compressed, scratch := snappyScratch(data)
+	defer putBuffer(scratch)
+	return append([]byte(nil), compressed...), nil
 }
 
 func (s *Snappy) Decompress(data []byte) ([]byte, error) {
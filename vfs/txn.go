@@ -0,0 +1,121 @@
+package vfs
+
+import (
+	"errors"
+)
+
+var (
+	ErrTxnConflict = errors.New("transaction conflict")
+	// 旧格式版本数据文件中的记录没有版本号，事务不能发现它在读取之后被改回原来的状态，重新写入之后才能在事务中读取
+	ErrTxnUnversioned = errors.New("transaction cannot read a segment without version")
+)
+
+// 读取过的 Key 在提交之前被其他写入修改时，Update 重新执行事务函数的最大次数
+const maxTxnRetries = 16
+
+// Txn 是 Update 中的读写事务，Get 记录读取时 Key 的版本号，写入操作和 Batch 一样在提交时原子的追加
+// 写入在提交之前对 Get 不可见，读取到的总是已经提交的数据
+type Txn struct {
+	lfs   *LogStructuredFS
+	reads map[uint64]txnRead
+	batch Batch
+}
+
+// txnRead 是事务读取 Key 时的状态，提交时通过版本号判断 Key 有没有被修改
+type txnRead struct {
+	exists  bool
+	version uint64
+}
+
+// Get 读取 Key 当前的值，inum 和 FetchSegment 一样需要经过 KeyTransform 转换
+// Key 不存在时返回 ErrSegmentNotFound，提交时同样会检查 Key 有没有被其他写入创建
+// FormatV4 之前的数据文件不保存版本号，这些记录的版本号都是 0，被修改之后再改回来时提交无法发现
+// 所以读取到版本号为 0 的记录时返回 ErrTxnUnversioned，事务之外重新写入一次 Key 就会分配新的版本号
+func (tx *Txn) Get(inum uint64) (*Segment, error) {
+	seg, err := tx.lfs.FetchSegment(inum)
+	if errors.Is(err, ErrSegmentNotFound) {
+		tx.reads[inum] = txnRead{}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	if seg.Version == 0 {
+		return nil, ErrTxnUnversioned
+	}
+	tx.reads[inum] = txnRead{exists: true, version: seg.Version}
+	return seg, nil
+}
+
+// AddSegment 在事务中写入一条 Segment 记录
+func (tx *Txn) AddSegment(inum uint64, seg Segment) {
+	tx.batch.AddSegment(inum, seg)
+}
+
+// DelSegment 在事务中删除 Key
+func (tx *Txn) DelSegment(key string) {
+	tx.batch.DelSegment(key)
+}
+
+// Update 在一个事务中原子的读取和修改多个 Key，例如从一个列表弹出元素再追加到另一个列表，不需要客户端多次往返
+// 提交时任何读取过的 Key 已经被修改就重新执行 fn，fn 可能被执行多次，不应该有事务之外的副作用
+// fn 返回错误时放弃所有的写入，超过重试次数之后返回 ErrTxnConflict，没有写入操作的事务直接返回
+func (lfs *LogStructuredFS) Update(fn func(tx *Txn) error) error {
+	for retry := 0; retry < maxTxnRetries; retry++ {
+		tx := &Txn{lfs: lfs, reads: make(map[uint64]txnRead)}
+		err := fn(tx)
+		if err != nil {
+			return err
+		}
+		if tx.batch.Len() == 0 {
+			return nil
+		}
+
+		err = lfs.commitBatch(&tx.batch, tx.reads)
+		if !errors.Is(err, ErrTxnConflict) {
+			return err
+		}
+	}
+	return ErrTxnConflict
+}
+
+// commitTxnLocked 按照分片编号的顺序锁住事务读写的所有索引分片，检查读取过的 Key 之后追加记录并且更新索引
+// 调用方需要持有 appendMu 的读锁，reads 为空时就是普通的批量写入，只锁住写入的 Key 所在的分片
+func (lfs *LogStructuredFS) commitTxnLocked(b *Batch, segs []*Segment, reads map[uint64]txnRead) error {
+	inums := make([]uint64, 0, len(reads)+len(b.inums))
+	for inum := range reads {
+		inums = append(inums, inum)
+	}
+	inums = append(inums, b.inums...)
+	unlock := lfs.lockShards(inums...)
+
+	for inum, read := range reads {
+		current, ok := lfs.indexs[inum%uint64(indexShard)].get(inum)
+		if ok && isExpired(current.ExpiredAt) {
+			ok = false
+		}
+		if ok != read.exists || (ok && current.Version != read.version) {
+			unlock()
+			return ErrTxnConflict
+		}
+	}
+
+	inodes, err := lfs.appendSegments(segs...)
+	if err != nil {
+		unlock()
+		return err
+	}
+
+	olds := make([]*INode, len(b.inums))
+	for i, inum := range b.inums {
+		olds[i] = replaceIndex(lfs.indexs[inum%uint64(indexShard)], inum, b.segs[i], inodes[i])
+	}
+	unlock()
+
+	// 提交记录本身不会被索引引用
+	lfs.markDead(inodes[len(inodes)-1])
+	for i, inum := range b.inums {
+		lfs.releaseIndex(inum, b.segs[i], inodes[i], olds[i])
+	}
+	return nil
+}
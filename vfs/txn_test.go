@@ -0,0 +1,168 @@
+package vfs
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestUpdateMovesValue(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	err = lfs.AddSegment(InodeNum("list-a"), *testSegment("list-a", "item"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+
+	// 从 list-a 取出元素写入 list-b，两个 Key 一起提交
+	err = lfs.Update(func(tx *Txn) error {
+		seg, err := tx.Get(InodeNum("list-a"))
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Get(InodeNum("list-b")); !errors.Is(err, ErrSegmentNotFound) {
+			t.Errorf("expected list-b not found, got: %v", err)
+		}
+		tx.DelSegment("list-a")
+		tx.AddSegment(InodeNum("list-b"), *testSegment("list-b", string(seg.Value)))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+
+	if _, err := lfs.FetchSegment(InodeNum("list-a")); !errors.Is(err, ErrSegmentNotFound) {
+		t.Errorf("expected list-a deleted, got: %v", err)
+	}
+	seg, err := lfs.FetchSegment(InodeNum("list-b"))
+	if err != nil || string(seg.Value) != "item" {
+		t.Errorf("expected list-b item, got: %v %v", seg, err)
+	}
+
+	// 事务函数返回错误时不会写入任何数据
+	abort := errors.New("abort")
+	err = lfs.Update(func(tx *Txn) error {
+		tx.DelSegment("list-b")
+		return abort
+	})
+	if !errors.Is(err, abort) {
+		t.Fatalf("expected abort error, got %v", err)
+	}
+	if _, err := lfs.FetchSegment(InodeNum("list-b")); err != nil {
+		t.Errorf("expected list-b kept after abort, got: %v", err)
+	}
+}
+
+func TestUpdateRetriesOnConflict(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	inum := InodeNum("counter")
+	increment := func(tx *Txn) error {
+		n := 0
+		seg, err := tx.Get(inum)
+		if err == nil {
+			n, _ = strconv.Atoi(string(seg.Value))
+		} else if !errors.Is(err, ErrSegmentNotFound) {
+			return err
+		}
+		tx.AddSegment(inum, *testSegment("counter", strconv.Itoa(n+1)))
+		return nil
+	}
+
+	// 读取之后被其他写入修改的事务需要重新执行
+	calls := 0
+	err = lfs.Update(func(tx *Txn) error {
+		calls++
+		err := increment(tx)
+		if calls == 1 {
+			_ = lfs.AddSegment(inum, *testSegment("counter", "10"), 0)
+		}
+		return err
+	})
+	if err != nil || calls != 2 {
+		t.Fatalf("expected update to retry once, got %d calls: %v", calls, err)
+	}
+
+	var wg sync.WaitGroup
+	var failed sync.Map
+	var committed atomic.Int64
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				err := lfs.Update(increment)
+				if err == nil {
+					committed.Add(1)
+				} else if !errors.Is(err, ErrTxnConflict) {
+					failed.Store(i, err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	failed.Range(func(key, value any) bool {
+		t.Errorf("failed to update counter: %v", value)
+		return true
+	})
+
+	// 每次成功的事务都基于最新的值加一，不会丢失更新
+	seg, err := lfs.FetchSegment(inum)
+	if err != nil {
+		t.Fatalf("failed to fetch counter: %v", err)
+	}
+	n, _ := strconv.Atoi(string(seg.Value))
+	if int64(n) != 11+committed.Load() {
+		t.Errorf("expected counter %d, got %d", 11+committed.Load(), n)
+	}
+}
+
+// 旧格式版本的记录没有版本号，事务不能读取，重新写入之后分配了版本号就可以读取
+func TestUpdateUnversioned(t *testing.T) {
+	path := t.TempDir()
+	opt := &Options{Path: path, FsPerm: fsPerm, Threshold: 1}
+	lfs, err := OpenFS(opt)
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	err = lfs.AddSegment(InodeNum("legacy"), *testSegment("legacy", "v1"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+	mustCloseFS(t, lfs)
+
+	if _, err := MigrateRegions(path, FormatV3); err != nil {
+		t.Fatalf("failed to migrate regions: %v", err)
+	}
+	lfs, err = OpenFS(opt)
+	if err != nil {
+		t.Fatalf("failed to reopen fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	get := func(tx *Txn) error {
+		_, err := tx.Get(InodeNum("legacy"))
+		return err
+	}
+	if err := lfs.Update(get); !errors.Is(err, ErrTxnUnversioned) {
+		t.Fatalf("expected ErrTxnUnversioned, got %v", err)
+	}
+
+	err = lfs.AddSegment(InodeNum("legacy"), *testSegment("legacy", "v2"), 0)
+	if err != nil {
+		t.Fatalf("failed to rewrite segment: %v", err)
+	}
+	if err := lfs.Update(get); err != nil {
+		t.Errorf("expected rewritten segment to be readable in transaction, got %v", err)
+	}
+}
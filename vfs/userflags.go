@@ -0,0 +1,12 @@
+package vfs
+
+// FetchUserFlags 返回记录中应用自定义的标志位，只读取记录头部，不需要读取和解码 Value
+// 标志位在写入时通过 Segment.UserFlags 设置，旧格式版本的数据文件中的记录总是返回 0
+func (lfs *LogStructuredFS) FetchUserFlags(inum uint64) (uint8, error) {
+	meta, err := lfs.FetchSegmentMeta(inum)
+	if err != nil {
+		return 0, err
+	}
+
+	return meta.UserFlags, nil
+}
@@ -0,0 +1,50 @@
+package vfs
+
+import (
+	"context"
+	"testing"
+)
+
+func TestUserFlags(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	seg := testSegment("key", "value")
+	seg.UserFlags = 0x05
+	inum := InodeNum("key")
+	err = lfs.AddSegment(inum, *seg, 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+
+	flags, err := lfs.FetchUserFlags(inum)
+	if err != nil || flags != 0x05 {
+		t.Fatalf("expected user flags 0x05, got %#x: %v", flags, err)
+	}
+
+	// 压缩迁移之后标志位保持不变
+	err = lfs.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+	err = lfs.Compact(context.Background(), CompactOptions{FileIDs: []uint64{1}})
+	if err != nil {
+		t.Fatalf("failed to compact region: %v", err)
+	}
+
+	fetched, err := lfs.FetchSegment(inum)
+	if err != nil {
+		t.Fatalf("failed to fetch segment: %v", err)
+	}
+	if fetched.UserFlags != 0x05 {
+		t.Errorf("expected fetched user flags 0x05, got %#x", fetched.UserFlags)
+	}
+
+	// 旧格式版本没有保存用户标志位的空间
+	if _, err := encodeSegment(seg, FormatV2, ChecksumCRC32); err == nil {
+		t.Errorf("expected error when encoding user flags in format version %d", FormatV2)
+	}
+}
@@ -0,0 +1,108 @@
+package vfs
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ReadValue 读取 inum 对应记录解码之后的 Value，结果写入 buf[:0] 并返回，buf 的容量足够时不需要分配内存
+// 返回的切片引用 buf，调用方可以通过 sync.Pool 复用 buf，热点 Key 的读取不会产生额外的内存分配
+// 从磁盘读取时记录暂存在池中的缓冲区里，只有加密或者使用其他压缩算法的 Value 解码时才需要分配内存
+// 开启了数据记录缓存时，缓存未命中和 FetchSegment 一样读取完整的记录并放入缓存
+func (lfs *LogStructuredFS) ReadValue(inum uint64, buf []byte) ([]byte, error) {
+	defer lfs.latency.observe(LatencyGet, time.Now())
+
+	if seg, ok := lfs.cache.get(inum); ok && !isExpired(seg.ExpiredAt) {
+		return append(buf[:0], seg.Value...), nil
+	}
+
+	if lfs.cache.enabled() {
+		return lfs.fetchValue(inum, buf)
+	}
+
+	for retry := 0; ; retry++ {
+		inode, ok := lfs.GetINode(inum)
+		if !ok || isExpired(inode.ExpiredAt) {
+			return nil, ErrSegmentNotFound
+		}
+
+		// 冷数据需要重新写回活跃数据文件，LSM 模式中还没有刷新的记录在内存表中
+		if lfs.isColdRegion(inode.RegionID) || lfs.lsm.get(inode) != nil {
+			return lfs.fetchValue(inum, buf)
+		}
+
+		fd, version, checksum, ok := lfs.regionFile(inode.RegionID)
+		if !ok {
+			return nil, fmt.Errorf("region file not found for region id: %d", inode.RegionID)
+		}
+
+		value, err := readValue(fd, inode, version, checksum, buf)
+		if errors.Is(err, os.ErrClosed) && retry == 0 {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read value (inum: %d): %w", inum, err)
+		}
+		return value, nil
+	}
+}
+
+// GetInto 读取 key 对应记录解码之后的 Value，和 ReadValue 一样写入 dst[:0] 并返回
+// dst 的容量足够并且记录在缓存中时不会分配内存
+func (lfs *LogStructuredFS) GetInto(key string, dst []byte) ([]byte, error) {
+	return lfs.ReadValue(lfs.InodeNum(key), dst)
+}
+
+// fetchValue 通过 fetchSegment 读取完整的记录，然后把 Value 复制到 buf 中
+func (lfs *LogStructuredFS) fetchValue(inum uint64, buf []byte) ([]byte, error) {
+	seg, err := lfs.fetchSegment(context.Background(), inum, nil)
+	if err != nil {
+		return nil, err
+	}
+	return append(buf[:0], seg.Value...), nil
+}
+
+// readValue 把 inode 引用的整条记录读取到池中的缓冲区，校验之后把解码的 Value 写入 buf
+func readValue(fd io.ReaderAt, inode *INode, version uint8, checksum Checksum, buf []byte) ([]byte, error) {
+	hsize, err := segmentHeaderSize(version)
+	if err != nil {
+		return nil, err
+	}
+	if int(inode.Length) < hsize+4 {
+		return nil, fmt.Errorf("%w: segment length %d shorter than header", ErrCorruptedSegment, inode.Length)
+	}
+
+	pooled := getBuffer(int(inode.Length))
+	defer putBuffer(pooled)
+	data := *pooled
+	_, err = fd.ReadAt(data, inode.Position)
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: segment truncated at offset %d: %v", ErrCorruptedSegment, inode.Position, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var seg Segment
+	err = parseSegmentHeaderInto(data[:hsize], version, &seg)
+	if err != nil {
+		return nil, err
+	}
+	if int64(hsize)+int64(seg.KeySize)+int64(seg.ValueSize)+4 != int64(len(data)) {
+		return nil, fmt.Errorf("%w: segment size does not match index length %d", ErrCorruptedSegment, inode.Length)
+	}
+
+	body := len(data) - 4
+	expected := binary.LittleEndian.Uint32(data[body:])
+	if expected != checksum.sum(data[:body]) {
+		return nil, fmt.Errorf("%w: failed to %s checksum mismatch: %d", ErrCorruptedSegment, checksum, expected)
+	}
+
+	value := data[hsize+int(seg.KeySize) : body]
+	return transformer.decodeInto(seg.Flags, value, buf[:0])
+}
@@ -0,0 +1,195 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hash/fnv"
+	"testing"
+)
+
+func TestReadValue(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	plain := bytes.Repeat([]byte("plain value "), 64)
+	err = lfs.AddSegment(InodeNum("plain"), *testSegment("plain", string(plain)), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+
+	// 每条记录按照自己的标志位解码，分别写入 Snappy 和自适应压缩的记录
+	compressed := bytes.Repeat([]byte("compressed value "), 256)
+	tf := NewTransformer()
+	tf.SetCompressor(SnappyCompressor)
+	tf.EnableCompression()
+	adaptive := NewTransformer()
+	adaptive.SetAdaptiveCompression(AdaptiveOptions{})
+	for key, tf := range map[string]*Transformer{"snappy": tf, "adaptive": adaptive} {
+		value, flags, err := tf.encode(Binary, compressed)
+		if err != nil || flags&flagCompressed == 0 {
+			t.Fatalf("failed to compress value: %v", err)
+		}
+		seg := testSegment(key, string(value))
+		seg.Flags = flags
+		err = lfs.AddSegment(InodeNum(key), *seg, 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+
+	buf := make([]byte, 0, 8*KB)
+	for key, want := range map[string][]byte{"plain": plain, "snappy": compressed, "adaptive": compressed} {
+		value, err := lfs.ReadValue(InodeNum(key), buf)
+		if err != nil || !bytes.Equal(value, want) {
+			t.Fatalf("expected value of %s, got %d bytes %v", key, len(value), err)
+		}
+		if &value[0] != &buf[:1][0] {
+			t.Errorf("expected value of %s to be read into the caller buffer", key)
+		}
+
+		// 读取的过程中只使用调用方和池中的缓冲区
+		allocs := testing.AllocsPerRun(100, func() {
+			_, _ = lfs.ReadValue(InodeNum(key), buf)
+		})
+		if allocs > 0 {
+			t.Errorf("expected no allocations reading %s, got %.1f", key, allocs)
+		}
+	}
+
+	// 缓冲区容量不够时分配新的切片
+	value, err := lfs.ReadValue(InodeNum("plain"), nil)
+	if err != nil || !bytes.Equal(value, plain) {
+		t.Fatalf("expected plain value with new buffer, got %v", err)
+	}
+
+	_, err = lfs.ReadValue(InodeNum("missing"), buf)
+	if !errors.Is(err, ErrSegmentNotFound) {
+		t.Errorf("expected ErrSegmentNotFound, got %v", err)
+	}
+}
+
+func TestReadValueCached(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1, MaxCacheMemory: 1 * MB})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	err = lfs.AddSegment(InodeNum("key"), *testSegment("key", "value"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+
+	buf := make([]byte, 0, 64)
+	for i := 0; i < 2; i++ {
+		value, err := lfs.ReadValue(InodeNum("key"), buf)
+		if err != nil || string(value) != "value" {
+			t.Fatalf("expected value, got %q %v", value, err)
+		}
+	}
+	if _, values := lfs.cache.usage(); values != 1 {
+		t.Errorf("expected value to be cached after first read, got %d cached values", values)
+	}
+
+	// 缓存命中之后直接复制到调用方的缓冲区
+	allocs := testing.AllocsPerRun(100, func() {
+		_, _ = lfs.ReadValue(InodeNum("key"), buf)
+	})
+	if allocs > 0 {
+		t.Errorf("expected no allocations for cached value, got %.1f", allocs)
+	}
+}
+
+func TestInodeNumMatchesFNV(t *testing.T) {
+	for _, key := range []string{"", "key", "user:01", "\xff\x00binary"} {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		if got := InodeNum(key); got != h.Sum64() {
+			t.Errorf("InodeNum(%q) = %d, want %d", key, got, h.Sum64())
+		}
+	}
+}
+
+func TestValueIterator(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1, MaxCacheMemory: 1 * MB})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	for _, key := range []string{"key-01", "key-02", "key-03"} {
+		err := lfs.AddSegment(InodeNum(key), *testSegment(key, "value-"+key), 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+	}
+
+	dst := make([]byte, 0, 64)
+	value, err := lfs.GetInto("key-02", dst)
+	if err != nil || string(value) != "value-key-02" {
+		t.Fatalf("expected value-key-02, got %q %v", value, err)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		_, _ = lfs.GetInto("key-02", dst)
+	})
+	if allocs > 0 {
+		t.Errorf("expected no allocations for cached GetInto, got %.1f", allocs)
+	}
+
+	it := lfs.NewValueIterator(context.Background(), "key-")
+	defer it.Close()
+	var first []byte
+	count := 0
+	for it.Next() {
+		if string(it.Value()) != "value-"+it.Key() || it.Segment() != nil {
+			t.Fatalf("unexpected value %q for %s", it.Value(), it.Key())
+		}
+		// 每次 Next 复用同一个缓冲区
+		if first == nil {
+			first = it.Value()
+		} else if &first[0] != &it.Value()[0] {
+			t.Errorf("expected value buffer to be reused for %s", it.Key())
+		}
+		count++
+	}
+	if err := it.Err(); err != nil || count != 3 {
+		t.Fatalf("expected 3 values, got %d %v", count, err)
+	}
+}
+
+func BenchmarkGetInto(b *testing.B) {
+	for _, kind := range []Kind{Binary, Text} {
+		b.Run(kind.String(), func(b *testing.B) {
+			lfs, err := OpenFS(&Options{Path: b.TempDir(), FsPerm: fsPerm, Threshold: 1, MaxCacheMemory: 1 * MB})
+			if err != nil {
+				b.Fatalf("failed to open fs: %v", err)
+			}
+			defer lfs.CloseFS()
+
+			seg := testSegment("hot-key", string(bytes.Repeat([]byte("v"), 256)))
+			seg.Type = kind
+			err = lfs.AddSegment(InodeNum("hot-key"), *seg, 0)
+			if err != nil {
+				b.Fatalf("failed to add segment: %v", err)
+			}
+			dst := make([]byte, 0, 512)
+			_, err = lfs.GetInto("hot-key", dst)
+			if err != nil {
+				b.Fatalf("failed to read value: %v", err)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				dst, err = lfs.GetInto("hot-key", dst)
+				if err != nil {
+					b.Fatalf("failed to read value: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/auula/wiredkv/clog"
+)
+
+// CorruptRecord 是打开时一致性检查发现的一处损坏记录
+type CorruptRecord struct {
+	RegionID  uint64
+	Offset    int64
+	Err       error
+	Truncated bool  // 是否从损坏的位置截断了数据文件
+	Discarded int64 // 截断丢弃的字节数
+}
+
+// OpenReport 是打开时一致性检查的结果，用来了解非正常关闭之后存储引擎的状态
+type OpenReport struct {
+	Regions      int             // 检查的本地数据文件数量
+	Records      int64           // 校验通过的记录数量
+	Corrupted    []CorruptRecord // 发现的损坏记录，每个数据文件只记录第一处
+	IndexEntries int             // 恢复之后内存索引中的 Key 数量
+	Duration     time.Duration
+}
+
+// Truncated 返回截断数据文件丢弃的总字节数
+func (r *OpenReport) Truncated() int64 {
+	var total int64
+	for _, c := range r.Corrupted {
+		total += c.Discarded
+	}
+	return total
+}
+
+func (r *OpenReport) String() string {
+	return fmt.Sprintf("regions: %d, records: %d, corrupted: %d, truncated: %d bytes, index entries: %d, duration: %s",
+		r.Regions, r.Records, len(r.Corrupted), r.Truncated(), r.IndexEntries, r.Duration)
+}
+
+// OpenReport 返回打开时一致性检查的结果，没有开启 VerifyOnOpen 时返回 nil
+func (lfs *LogStructuredFS) OpenReport() *OpenReport {
+	return lfs.report
+}
+
+// verifyRegions 在恢复索引之前校验所有本地数据文件中的每一条记录，调用方需要持有 lfs.mu
+// 非正常关闭时最后写入的数据文件末尾可能留下不完整的记录，这个数据文件会从损坏的位置截断
+// 其他数据文件中的损坏记录之后的内容无法确定记录边界，只记录在报告中，不会修改数据文件
+func (lfs *LogStructuredFS) verifyRegions() (*OpenReport, error) {
+	report := new(OpenReport)
+
+	var regionIds []uint64
+	for regionID := range lfs.regions {
+		regionIds = append(regionIds, regionID)
+	}
+	sort.Slice(regionIds, func(i, j int) bool {
+		return regionIds[i] < regionIds[j]
+	})
+
+	// 最后写入的是最新的有记录的数据文件，刚刚创建的空数据文件不算
+	var tail uint64
+	for _, regionID := range regionIds {
+		finfo, err := lfs.regions[regionID].Stat()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get region file info: %w", err)
+		}
+		if finfo.Size() > int64(len(dataFileMetadata)) {
+			tail = regionID
+		}
+	}
+
+	results := verifyParallel(lfs, regionIds)
+	for i, regionID := range regionIds {
+		result := results[i]
+		report.Regions++
+		report.Records += result.records
+		err := result.err
+		if err == nil {
+			continue
+		}
+		if !isCorruption(err) {
+			return nil, fmt.Errorf("failed to verify region %d: %w", regionID, err)
+		}
+
+		corrupt := CorruptRecord{RegionID: regionID, Offset: result.offset, Err: err}
+		if regionID == tail {
+			corrupt.Discarded, err = lfs.truncateRegion(regionID, result.offset)
+			if err != nil {
+				return nil, err
+			}
+			corrupt.Truncated = true
+		}
+		report.Corrupted = append(report.Corrupted, corrupt)
+	}
+
+	// 截断之后索引快照可能指向已经丢弃的记录，删除快照从数据文件重建索引
+	if report.Truncated() > 0 {
+		err := os.Remove(filepath.Join(lfs.directory, indexFileName))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to remove stale index snapshot: %w", err)
+		}
+	}
+
+	return report, nil
+}
+
+type regionVerify struct {
+	offset  int64
+	records int64
+	err     error
+}
+
+// verifyParallel 使用 lfs.workers 个 goroutine 同时校验数据文件，每个 goroutine 一次校验一个数据文件
+// 返回的结果和 regionIds 的顺序一致，截断和生成报告由调用方按照顺序处理
+func verifyParallel(lfs *LogStructuredFS, regionIds []uint64) []regionVerify {
+	workers := lfs.workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(regionIds) {
+		workers = len(regionIds)
+	}
+
+	results := make([]regionVerify, len(regionIds))
+	jobs := make(chan int, len(regionIds))
+	for i := range regionIds {
+		jobs <- i
+	}
+	close(jobs)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				regionID := regionIds[i]
+				var result regionVerify
+				result.offset, result.records, result.err = verifyRegion(lfs.regions[regionID], lfs.versions[regionID], lfs.checksums[regionID])
+				results[i] = result
+			}
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// verifyRegion 从头到尾校验数据文件中的记录，返回校验通过的记录数量
+// 遇到错误时同时返回出错记录的位置
+func verifyRegion(fd vfsFile, version uint8, checksum Checksum) (int64, int64, error) {
+	finfo, err := fd.Stat()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get region file info: %w", err)
+	}
+
+	holes, err := fileHoles(fd)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to load region holes: %w", err)
+	}
+
+	var records int64
+	offset := int64(len(dataFileMetadata))
+	for offset < finfo.Size() {
+		if end, ok := holes[offset]; ok {
+			offset = end
+			continue
+		}
+
+		_, length, err := decodeSegment(fd, offset, version, checksum)
+		if err != nil {
+			return offset, records, err
+		}
+		records++
+		offset += length
+	}
+
+	return offset, records, nil
+}
+
+// isCorruption 判断错误是记录本身损坏还是读取文件失败，记录头部没有完整写入时读取会返回 io.EOF
+func isCorruption(err error) bool {
+	return errors.Is(err, ErrCorruptedSegment) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// truncateRegion 从 offset 处截断数据文件，返回丢弃的字节数，调用方需要持有 lfs.mu
+func (lfs *LogStructuredFS) truncateRegion(regionID uint64, offset int64) (int64, error) {
+	file, ok := lfs.regions[regionID].(*os.File)
+	if !ok {
+		return 0, fmt.Errorf("failed to truncate region %d: not a local file", regionID)
+	}
+
+	finfo, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get region file info: %w", err)
+	}
+
+	err = file.Truncate(offset)
+	if err != nil {
+		return 0, fmt.Errorf("failed to truncate region %d: %w", regionID, err)
+	}
+
+	err = file.Sync()
+	if err != nil {
+		return 0, fmt.Errorf("failed to sync truncated region %d: %w", regionID, err)
+	}
+
+	// 活跃数据文件的写入位置要移动到截断之后的末尾
+	if regionID == lfs.regionID {
+		_, err = lfs.active.Seek(offset, io.SeekStart)
+		if err != nil {
+			return 0, fmt.Errorf("failed to seek active region: %w", err)
+		}
+		lfs.offset = offset
+	}
+
+	clog.Warnf("truncated region %d at offset %d, discarded %d bytes", regionID, offset, finfo.Size()-offset)
+
+	return finfo.Size() - offset, nil
+}
@@ -0,0 +1,134 @@
+package vfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyOnOpenTruncatesTornTail(t *testing.T) {
+	path := t.TempDir()
+
+	data := fileMetadata(currentFormat, ChecksumCRC32)
+	for _, key := range []string{"key-01", "key-02"} {
+		seg := testSegment(key, "value")
+		seg.Version = 1
+		bytes, err := encodeSegment(seg, currentFormat, ChecksumCRC32)
+		if err != nil {
+			t.Fatalf("failed to encode segment: %v", err)
+		}
+		data = append(data, bytes...)
+	}
+	valid := int64(len(data))
+
+	// 模拟非正常关闭时只写入了一半的记录
+	torn, err := encodeSegment(testSegment("key-03", "value"), currentFormat, ChecksumCRC32)
+	if err != nil {
+		t.Fatalf("failed to encode segment: %v", err)
+	}
+	data = append(data, torn[:len(torn)/2]...)
+
+	file := filepath.Join(path, formatDataFileName(1))
+	err = os.WriteFile(file, data, fsPerm)
+	if err != nil {
+		t.Fatalf("failed to write region: %v", err)
+	}
+
+	lfs, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1, VerifyOnOpen: true})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+
+	report := lfs.OpenReport()
+	if report == nil {
+		t.Fatal("expected open report, got nil")
+	}
+	if report.Regions != 1 || report.Records != 2 || report.IndexEntries != 2 {
+		t.Errorf("unexpected report: %s", report)
+	}
+	if len(report.Corrupted) != 1 || !report.Corrupted[0].Truncated || report.Corrupted[0].Offset != valid {
+		t.Fatalf("expected truncation at offset %d, got %+v", valid, report.Corrupted)
+	}
+	if report.Truncated() != int64(len(torn)/2) {
+		t.Errorf("expected %d truncated bytes, got %d", len(torn)/2, report.Truncated())
+	}
+
+	// 截断之后的活跃数据文件可以继续写入
+	err = lfs.AddSegment(InodeNum("key-04"), *testSegment("key-04", "value"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+	mustCloseFS(t, lfs)
+
+	lfs, err = OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1, VerifyOnOpen: true})
+	if err != nil {
+		t.Fatalf("failed to reopen fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	if report := lfs.OpenReport(); len(report.Corrupted) != 0 || report.Records != 3 {
+		t.Errorf("expected clean report with 3 records, got %s", report)
+	}
+	for _, key := range []string{"key-01", "key-02", "key-04"} {
+		if _, err := lfs.FetchSegment(InodeNum(key)); err != nil {
+			t.Errorf("failed to fetch %s: %v", key, err)
+		}
+	}
+}
+
+func TestOpenReportDisabled(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	if lfs.OpenReport() != nil {
+		t.Error("expected nil report without VerifyOnOpen")
+	}
+}
+
+func TestVerifyOnOpenParallel(t *testing.T) {
+	path := t.TempDir()
+
+	var torn int
+	for regionID := uint64(1); regionID <= 8; regionID++ {
+		data := fileMetadata(currentFormat, ChecksumCRC32)
+		for i := 0; i < 3; i++ {
+			seg := testSegment(fmt.Sprintf("key-%d-%d", regionID, i), "value")
+			seg.Version = regionID*10 + uint64(i)
+			bytes, err := encodeSegment(seg, currentFormat, ChecksumCRC32)
+			if err != nil {
+				t.Fatalf("failed to encode segment: %v", err)
+			}
+			data = append(data, bytes...)
+		}
+		if regionID == 8 {
+			tail, err := encodeSegment(testSegment("key-torn", "value"), currentFormat, ChecksumCRC32)
+			if err != nil {
+				t.Fatalf("failed to encode segment: %v", err)
+			}
+			torn = len(tail) / 2
+			data = append(data, tail[:torn]...)
+		}
+		err := os.WriteFile(filepath.Join(path, formatDataFileName(regionID)), data, fsPerm)
+		if err != nil {
+			t.Fatalf("failed to write region: %v", err)
+		}
+	}
+
+	lfs, err := OpenFS(&Options{Path: path, FsPerm: fsPerm, Threshold: 1, VerifyOnOpen: true, RecoveryWorkers: 4})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	report := lfs.OpenReport()
+	if report.Regions != 8 || report.Records != 24 || report.IndexEntries != 24 {
+		t.Errorf("unexpected report: %s", report)
+	}
+	if len(report.Corrupted) != 1 || report.Corrupted[0].RegionID != 8 || report.Truncated() != int64(torn) {
+		t.Fatalf("expected truncation of region 8, got %+v", report.Corrupted)
+	}
+}
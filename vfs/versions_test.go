@@ -0,0 +1,123 @@
+package vfs
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestSegmentVersion(t *testing.T) {
+	dir := t.TempDir()
+	lfs, err := OpenFS(&Options{Path: dir, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+
+	inum := InodeNum("key-01")
+	var last uint64
+	for i := 0; i < 3; i++ {
+		seg := testSegment("key-01", "value")
+		// 调用方传入的版本号会被忽略
+		seg.Version = 1000
+		version, err := lfs.AddSegmentVersion(context.Background(), inum, *seg, 0)
+		if err != nil {
+			t.Fatalf("failed to add segment: %v", err)
+		}
+		if version <= last {
+			t.Errorf("expected version greater than %d, got %d", last, version)
+		}
+		last = version
+	}
+
+	err = lfs.AddSegment(InodeNum("key-02"), *testSegment("key-02", "value"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+
+	seg, err := lfs.FetchSegment(inum)
+	if err != nil {
+		t.Fatalf("failed to fetch segment: %v", err)
+	}
+	if seg.Version != last {
+		t.Errorf("expected fetched version %d, got %d", last, seg.Version)
+	}
+
+	// 压缩迁移的记录保留原来的版本号
+	err = lfs.ChangeRegions()
+	if err != nil {
+		t.Fatalf("failed to change regions: %v", err)
+	}
+	err = lfs.compactRegion(1)
+	if err != nil {
+		t.Fatalf("failed to compact region: %v", err)
+	}
+	if version, ok := lfs.Version(inum); !ok || version != last {
+		t.Errorf("expected version %d after compaction, got %d", last, version)
+	}
+
+	mustCloseFS(t, lfs)
+
+	lfs, err = OpenFS(&Options{Path: dir, FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to reopen fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	if version, ok := lfs.Version(inum); !ok || version != last {
+		t.Errorf("expected version %d after reopen, got %d", last, version)
+	}
+
+	// 重新打开之后分配的版本号继续递增
+	version, err := lfs.AddSegmentVersion(context.Background(), inum, *testSegment("key-01", "value"), 0)
+	if err != nil {
+		t.Fatalf("failed to add segment: %v", err)
+	}
+	if version <= last+1 {
+		t.Errorf("expected version greater than %d, got %d", last+1, version)
+	}
+
+	if _, ok := lfs.Version(InodeNum("missing")); ok {
+		t.Error("expected no version for missing key")
+	}
+}
+
+func TestConcurrentPutVersion(t *testing.T) {
+	lfs, err := OpenFS(&Options{Path: t.TempDir(), FsPerm: fsPerm, Threshold: 1})
+	if err != nil {
+		t.Fatalf("failed to open fs: %v", err)
+	}
+	defer mustCloseFS(t, lfs)
+
+	// 并发写入同一个 Key 之后索引必须指向版本号最大的记录，和崩溃恢复重放的结果一致
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(8))
+	inum := InodeNum("key")
+	for round := 0; round < 500; round++ {
+		var (
+			wg     sync.WaitGroup
+			mu     sync.Mutex
+			latest uint64
+		)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				version, err := lfs.AddSegmentVersion(context.Background(), inum, *testSegment("key", "value"), 0)
+				if err != nil {
+					t.Errorf("failed to add segment: %v", err)
+					return
+				}
+				mu.Lock()
+				if version > latest {
+					latest = version
+				}
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+
+		if version, _ := lfs.Version(inum); version != latest {
+			t.Fatalf("round %d: expected index version %d, got %d", round, latest, version)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package vfs
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// xxHash64 的纯 Go 实现，参考 https://github.com/Cyan4973/xxHash/blob/dev/doc/xxhash_spec.md
+// 常量相加会溢出，声明为变量之后按照 uint64 回绕计算
+var (
+	prime64v1 uint64 = 11400714785074694791
+	prime64v2 uint64 = 14029467366897019727
+	prime64v3 uint64 = 1609587929392839161
+	prime64v4 uint64 = 9650029242287828579
+	prime64v5 uint64 = 2870177450012600261
+)
+
+// xxhash64 计算种子为 0 的 xxHash64
+func xxhash64(data []byte) uint64 {
+	h := newXXHash64()
+	h.Write(data)
+	return h.Sum64()
+}
+
+// xxh64 是流式计算的 xxHash64，每次凑满 32 字节处理一轮
+type xxh64 struct {
+	v1, v2, v3, v4 uint64
+	total          uint64
+	mem            [32]byte
+	n              int
+}
+
+func newXXHash64() *xxh64 {
+	h := new(xxh64)
+	h.Reset()
+	return h
+}
+
+func (h *xxh64) Reset() {
+	h.v1 = prime64v1 + prime64v2
+	h.v2 = prime64v2
+	h.v3 = 0
+	h.v4 = -prime64v1
+	h.total = 0
+	h.n = 0
+}
+
+func (h *xxh64) BlockSize() int {
+	return 32
+}
+
+func (h *xxh64) Write(b []byte) (int, error) {
+	n := len(b)
+	h.total += uint64(n)
+
+	if h.n+len(b) < 32 {
+		h.n += copy(h.mem[h.n:], b)
+		return n, nil
+	}
+
+	if h.n > 0 {
+		c := copy(h.mem[h.n:], b)
+		h.round(h.mem[:])
+		b = b[c:]
+		h.n = 0
+	}
+
+	for len(b) >= 32 {
+		h.round(b[:32])
+		b = b[32:]
+	}
+	h.n = copy(h.mem[:], b)
+
+	return n, nil
+}
+
+func (h *xxh64) round(b []byte) {
+	h.v1 = xxround(h.v1, binary.LittleEndian.Uint64(b[0:8]))
+	h.v2 = xxround(h.v2, binary.LittleEndian.Uint64(b[8:16]))
+	h.v3 = xxround(h.v3, binary.LittleEndian.Uint64(b[16:24]))
+	h.v4 = xxround(h.v4, binary.LittleEndian.Uint64(b[24:32]))
+}
+
+func (h *xxh64) Sum64() uint64 {
+	var acc uint64
+	if h.total >= 32 {
+		acc = bits.RotateLeft64(h.v1, 1) + bits.RotateLeft64(h.v2, 7) +
+			bits.RotateLeft64(h.v3, 12) + bits.RotateLeft64(h.v4, 18)
+		acc = xxmerge(acc, h.v1)
+		acc = xxmerge(acc, h.v2)
+		acc = xxmerge(acc, h.v3)
+		acc = xxmerge(acc, h.v4)
+	} else {
+		acc = prime64v5
+	}
+	acc += h.total
+
+	b := h.mem[:h.n]
+	for ; len(b) >= 8; b = b[8:] {
+		acc ^= xxround(0, binary.LittleEndian.Uint64(b))
+		acc = bits.RotateLeft64(acc, 27)*prime64v1 + prime64v4
+	}
+	if len(b) >= 4 {
+		acc ^= uint64(binary.LittleEndian.Uint32(b)) * prime64v1
+		acc = bits.RotateLeft64(acc, 23)*prime64v2 + prime64v3
+		b = b[4:]
+	}
+	for ; len(b) > 0; b = b[1:] {
+		acc ^= uint64(b[0]) * prime64v5
+		acc = bits.RotateLeft64(acc, 11) * prime64v1
+	}
+
+	acc ^= acc >> 33
+	acc *= prime64v2
+	acc ^= acc >> 29
+	acc *= prime64v3
+	acc ^= acc >> 32
+
+	return acc
+}
+
+func xxround(acc, input uint64) uint64 {
+	acc += input * prime64v2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * prime64v1
+}
+
+func xxmerge(acc, val uint64) uint64 {
+	val = xxround(0, val)
+	acc ^= val
+	return acc*prime64v1 + prime64v4
+}